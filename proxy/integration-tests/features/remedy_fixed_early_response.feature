@@ -30,6 +30,16 @@ Feature: Lunar Proxy fixed early response remedy
         When    A request to http:// mox :8888 /uuid is made through Lunar Proxy with header 'Early-Response: true'
         Then    Fixed response is returned with status code 418
 
+    Scenario: Lunar keeps the client connection reusable after returning a fixed early response
+        Given   API Provider is up
+        And     Lunar Proxy is up
+        When    policies.yaml file is updated
+        And     policies.yaml includes a fixed_response remedy for POST mox /uuid requests with status code 418
+        And     policies.yaml file is saved
+        And     apply_policies command is run without waiting for Fluent to reload
+        When    3 requests with a body to mox :8888 /uuid are made through Lunar Proxy over a single connection with header 'Early-Response: true'
+        Then    All responses are fixed responses with status code 418 returned over the same connection
+
     Scenario: Lunar doesn't return a fixed early response when policy is not matched
         Given   API Provider is up
         And     Lunar Proxy is up
@@ -4,10 +4,14 @@
 from behave import then, register_type
 from behave.api.async_step import async_run_until_complete
 from json import dumps, loads
+from http.client import HTTPConnection
 from typing import Any
 from utils.policies import EndpointPolicy, PoliciesRequests
 import uuid
 
+_PROXY_HOST = "localhost"
+_PROXY_PORT = 8000
+
 _EARLY_RESPONSE_BODY = dumps(loads('{"message": "GO Lunar"}'))
 
 
@@ -53,3 +57,41 @@ def step_impl(context: Any, method: str, host: str, path: str):
 async def step_impl(context: Any, status: int):
     assert loads(context.proxified_response.body) == loads(_EARLY_RESPONSE_BODY)
     assert context.proxified_response.status == status
+
+
+@when(
+    "{count:Int} requests with a body to {host} :{port:Int} {path:Path} are made through Lunar Proxy over a single connection with header 'Early-Response: true'"
+)
+def step_impl(context: Any, count: int, host: str, port: int, path: str):
+    connection = HTTPConnection(_PROXY_HOST, _PROXY_PORT, timeout=10)
+    context.keep_alive_responses = []
+    context.keep_alive_sockets = []
+    body = "x" * 64 * 1024
+    try:
+        for _ in range(count):
+            connection.request(
+                "POST",
+                path,
+                body=body,
+                headers={
+                    "x-lunar-host": f"{host}:{port}",
+                    "x-lunar-scheme": "http",
+                    "Early-Response": "true",
+                },
+            )
+            context.keep_alive_sockets.append(connection.sock)
+            response = connection.getresponse()
+            context.keep_alive_responses.append((response.status, response.read()))
+    finally:
+        connection.close()
+
+
+@then(
+    "All responses are fixed responses with status code {status:Int} returned over the same connection"
+)
+def step_impl(context: Any, status: int):
+    first_socket = context.keep_alive_sockets[0]
+    assert all(sock is first_socket for sock in context.keep_alive_sockets)
+    for response_status, response_body in context.keep_alive_responses:
+        assert response_status == status
+        assert loads(response_body) == loads(_EARLY_RESPONSE_BODY)
@@ -14,6 +14,26 @@ local function parse_headers(headers)
     return parsed_headers
 end
 
+-- Bodies up to this size are drained before an early response is sent,
+-- larger ones close the connection instead of being read to the end
+local max_drained_body_size = 1048576
+local drain_chunk_size = 16384
+
+-- Consumes the unread part of the request body, so the client connection
+-- stays in sync and can be reused for its next request (keep-alive).
+-- Returns false if the body is too large to be drained.
+local function drain_request_body(applet)
+    local drained = 0
+    while drained < max_drained_body_size do
+        local chunk = applet:receive(drain_chunk_size)
+        if chunk == nil or string.len(chunk) == 0 then
+            return true
+        end
+        drained = drained + string.len(chunk)
+    end
+    return false
+end
+
 core.register_service("mock_response", "http", function(applet)
     local headers = applet.f:var("txn.lunar.response_headers")
     local response_body = applet.f:var("txn.lunar.response_body")
@@ -21,6 +41,10 @@ core.register_service("mock_response", "http", function(applet)
     
     add_lunar_generated_header(parsed_headers)
 
+    if not drain_request_body(applet) then
+        parsed_headers["connection"] = "close"
+    end
+
     for key, value in pairs(parsed_headers) do
         applet:add_header(key, value)
     end
@@ -53,6 +77,11 @@ core.register_service("generate_request", "http", function(a_http)
         end
         
         local dest_addr = a_http.f:var("txn.scheme") .. "://" .. a_http.f:var("req.host_ip") .. ":" .. a_http.f:var("txn.dst_port") .. "/" .. a_http.f:var("txn.path")
+        -- The query string set by Lunar (e.g. once masked) is sent along
+        local request_query = a_http.f:var("req.lunar.request_query")
+        if request_query ~= nil and string.len(request_query) > 0 then
+            dest_addr = dest_addr .. "?" .. request_query
+        end
         local res, err = cli_req{url=dest_addr, headers=parsed_headers, data=modified_body}
         http.response.create{status_code=res.status_code, content=res.content}:send(a_http)
     end
@@ -66,6 +95,39 @@ core.register_action("modify_request", { "http-req" }, function(txn)
         txn.http:req_set_header(key, value)
     end
 
+    local upstream_host = txn.f:var("req.lunar.upstream_host")
+    if upstream_host ~= nil and string.len(upstream_host) > 0 then
+        local upstream_scheme = txn.f:var("req.lunar.upstream_scheme")
+        local upstream_port = tonumber(txn.f:var("req.lunar.upstream_port"))
+        local host_header = upstream_host
+        if not ((upstream_scheme == "https" and upstream_port == 443) or
+                (upstream_scheme == "http" and upstream_port == 80)) then
+            host_header = upstream_host .. ":" .. upstream_port
+        end
+
+        txn:set_var("txn.host", host_header)
+        txn:set_var("txn.scheme", upstream_scheme)
+        txn:set_var("txn.dst_port", upstream_port)
+        txn.http:req_set_header("Host", host_header)
+    end
+
+    local request_path = txn.f:var("req.lunar.request_path")
+    if request_path ~= nil and string.len(request_path) > 0 then
+        txn.http:req_set_path(request_path)
+        txn:set_var("txn.path", request_path)
+    end
+
+    -- An empty query string is removed along with its '?', by setting the URI
+    -- to the path alone (setting the path keeps the query string)
+    local request_query = txn.f:var("req.lunar.request_query")
+    if request_query ~= nil then
+        if string.len(request_query) > 0 then
+            txn.http:req_set_query(request_query)
+        else
+            txn.http:req_set_uri(txn.f:path())
+        end
+    end
+
 end, 0)
 
 core.register_action("modify_response", { "http-res" }, function(txn)
@@ -75,3 +137,38 @@ core.register_action("modify_response", { "http-res" }, function(txn)
         txn.http:res_set_header(key, value)
     end
 end, 0)
+
+-- Replaces the response payload with the body set by Lunar (e.g. once compressed).
+-- Response headers (including Content-Length) are set by modify_response.
+local ResponseBodyFilter = {}
+ResponseBodyFilter.id = "lunar response body"
+ResponseBodyFilter.flags = filter.FLT_CFG_FL_HTX
+ResponseBodyFilter.__index = ResponseBodyFilter
+
+function ResponseBodyFilter:new()
+    return setmetatable({ replaced = false }, ResponseBodyFilter)
+end
+
+function ResponseBodyFilter:start_analyze(txn, chn)
+    if chn:is_resp() then
+        filter.register_data_filter(self, chn)
+    end
+end
+
+function ResponseBodyFilter:http_payload(txn, http_msg)
+    local body = txn:get_var("res.lunar.response_body")
+    if body == nil then
+        return
+    end
+
+    if not self.replaced then
+        http_msg:set(body)
+        self.replaced = true
+    else
+        http_msg:remove()
+    end
+end
+
+core.register_filter("lunar_response_body", ResponseBodyFilter, function(response_body_filter, args)
+    return response_body_filter
+end)
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const contentTypeHeaderName = "Content-Type"
+
+// Render renders the error template, substituting each of the given
+// variables referenced as {{name}} in its headers and body. Variables are
+// escaped in JSON bodies, so the response stays valid JSON. Without a status
+// code on the template, the given status code is used. Unless the template
+// sets a content type, it is JSON for JSON bodies and plain text otherwise.
+func (template ErrorTemplate) Render(
+	statusCode int,
+	variables map[string]string,
+) (int, map[string]string, string) {
+	replacer := newVariablesReplacer(variables, unescaped)
+	bodyReplacer := replacer
+	if template.isJSONBody(variables) {
+		bodyReplacer = newVariablesReplacer(variables, escapeJSONString)
+	}
+
+	if template.StatusCode != 0 {
+		statusCode = template.StatusCode
+	}
+	body := bodyReplacer.Replace(template.Body)
+	headers := make(map[string]string, len(template.Headers)+1)
+	hasContentType := false
+	for name, value := range template.Headers {
+		headers[name] = replacer.Replace(value)
+		hasContentType = hasContentType || strings.EqualFold(name, contentTypeHeaderName)
+	}
+	if !hasContentType {
+		headers[contentTypeHeaderName] = "text/plain"
+		if json.Valid([]byte(body)) {
+			headers[contentTypeHeaderName] = "application/json"
+		}
+	}
+	return statusCode, headers, body
+}
+
+// isJSONBody returns whether the body renders JSON, whether its
+// variables are referenced within strings or as numbers
+func (template ErrorTemplate) isJSONBody(variables map[string]string) bool {
+	placeholders := make(map[string]string, len(variables))
+	for name := range variables {
+		placeholders[name] = "0"
+	}
+	body := newVariablesReplacer(placeholders, unescaped).Replace(template.Body)
+	return json.Valid([]byte(body))
+}
+
+func newVariablesReplacer(
+	variables map[string]string,
+	escape func(string) string,
+) *strings.Replacer {
+	replacements := make([]string, 0, 2*len(variables))
+	for name, value := range variables {
+		replacements = append(replacements, "{{"+name+"}}", escape(value))
+	}
+	return strings.NewReplacer(replacements...)
+}
+
+func unescaped(value string) string {
+	return value
+}
+
+func escapeJSONString(value string) string {
+	escaped, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	return string(escaped[1 : len(escaped)-1])
+}
@@ -0,0 +1,46 @@
+package config_test
+
+import (
+	"encoding/json"
+	"lunar/shared-model/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorTemplateEscapesVariablesOfJSONBodies(t *testing.T) {
+	template := config.ErrorTemplate{
+		StatusCode: 0,
+		Headers:    map[string]string{"X-Request-ID": "{{request_id}}"},
+		Body:       `{"request_id": "{{request_id}}", "retry_after": {{retry_after}}}`,
+	}
+
+	statusCode, headers, body := template.Render(429, map[string]string{
+		"request_id":  `some "quoted" id`,
+		"retry_after": "5",
+	})
+
+	assert.Equal(t, 429, statusCode)
+	assert.True(t, json.Valid([]byte(body)))
+	assert.Equal(t, `{"request_id": "some \"quoted\" id", "retry_after": 5}`, body)
+	assert.Equal(t, map[string]string{
+		"X-Request-ID": `some "quoted" id`,
+		"Content-Type": "application/json",
+	}, headers)
+}
+
+func TestErrorTemplateDoesNotEscapeVariablesOfPlainTextBodies(t *testing.T) {
+	template := config.ErrorTemplate{
+		StatusCode: 503,
+		Headers:    nil,
+		Body:       "Request {{request_id}} was rejected",
+	}
+
+	statusCode, headers, body := template.Render(429, map[string]string{
+		"request_id": `some "quoted" id`,
+	})
+
+	assert.Equal(t, 503, statusCode)
+	assert.Equal(t, `Request some "quoted" id was rejected`, body)
+	assert.Equal(t, map[string]string{"Content-Type": "text/plain"}, headers)
+}
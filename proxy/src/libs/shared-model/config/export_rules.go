@@ -0,0 +1,108 @@
+package config
+
+import (
+	"hash/fnv"
+	"math"
+	"strings"
+)
+
+// DefaultExportRulesName names the rules of the exporters
+// which have no rules of their own
+const DefaultExportRulesName = "default"
+
+// RulesOf returns the rules of the given exporter, or the default rules
+// if it has none of its own, along with whether there are any
+func (exporters *Exporters) RulesOf(exporterType ExporterType) (ExportRules, bool) {
+	if rules, found := exporters.Rules[exporterType.Name()]; found {
+		return rules, true
+	}
+	rules, found := exporters.Rules[DefaultExportRulesName]
+	return rules, found
+}
+
+// Records is whether the exporter records the given transaction: it must
+// match any of the included matches (if set) and none of the excluded ones,
+// then be sampled by its ID, so the same transactions are sampled by
+// exporters of the same sample rate
+func (rules ExportRules) Records(
+	transactionID string,
+	method string,
+	status int,
+	headers HeaderLookup,
+) bool {
+	if len(rules.Include) > 0 && !anyTransactionMatch(rules.Include, method, status, headers) {
+		return false
+	}
+	if anyTransactionMatch(rules.Exclude, method, status, headers) {
+		return false
+	}
+	return rules.SampleRate == nil || sampled(transactionID, *rules.SampleRate)
+}
+
+func anyTransactionMatch(
+	matches []TransactionMatch,
+	method string,
+	status int,
+	headers HeaderLookup,
+) bool {
+	for _, match := range matches {
+		if match.Matches(method, status, headers) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches is whether the transaction matches all the set fields
+func (match TransactionMatch) Matches(
+	method string,
+	status int,
+	headers HeaderLookup,
+) bool {
+	if len(match.Methods) > 0 && !containsFold(match.Methods, method) {
+		return false
+	}
+	if len(match.StatusCode) > 0 && !StatusCodeMatches(status, match.StatusCode) {
+		return false
+	}
+	for name, value := range match.Headers {
+		if headerValue, found := headers.Header(name, MultiValueHeaderUndefined); !found ||
+			headerValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// StatusCodeMatches returns whether the status is in any of the ranges.
+// A range with no upper bound only holds its lower one.
+func StatusCodeMatches(status int, statusRanges []Range[int]) bool {
+	for _, statusRange := range statusRanges {
+		to := statusRange.To
+		if to == 0 {
+			to = statusRange.From
+		}
+		if status >= statusRange.From && status <= to {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func sampled(transactionID string, sampleRate float64) bool {
+	if sampleRate >= 1 {
+		return true
+	}
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(transactionID))
+	return float64(hash.Sum64())/math.MaxUint64 < sampleRate
+}
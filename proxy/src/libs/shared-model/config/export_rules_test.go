@@ -0,0 +1,67 @@
+package config_test
+
+import (
+	"fmt"
+	"lunar/shared-model/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportRulesIncludeAndExcludeTransactions(t *testing.T) {
+	rules := config.ExportRules{
+		Include: []config.TransactionMatch{
+			{StatusCode: []config.Range[int]{{From: 500, To: 599}}},
+			{Methods: []string{"post"}},
+		},
+		Exclude: []config.TransactionMatch{
+			{Headers: map[string]string{"X-Health-Check": "true"}},
+		},
+	}
+	noHeaders := config.HeaderMap(map[string]string{})
+
+	assert.True(t, rules.Records("1", "GET", 503, noHeaders))
+	assert.True(t, rules.Records("2", "POST", 201, noHeaders))
+	assert.False(t, rules.Records("3", "GET", 200, noHeaders))
+	assert.False(t, rules.Records("4", "GET", 503,
+		config.HeaderMap(map[string]string{"x-health-check": "true"})))
+}
+
+func TestExportRulesSampleTransactionsByTheirID(t *testing.T) {
+	sampleRate := 0.25
+	rules := config.ExportRules{SampleRate: &sampleRate}
+	noHeaders := config.HeaderMap(map[string]string{})
+
+	recorded := 0
+	for index := 0; index < 1000; index++ {
+		transactionID := fmt.Sprintf("txn-%d", index)
+		if rules.Records(transactionID, "GET", 200, noHeaders) {
+			recorded++
+			// The same transactions are sampled every time
+			assert.True(t, rules.Records(transactionID, "GET", 200, noHeaders))
+		}
+	}
+	assert.InDelta(t, 250, recorded, 50)
+}
+
+func TestExportersRulesOfFallBackToTheDefaultRules(t *testing.T) {
+	noSampling := 0.0
+	exporters := config.Exporters{
+		Rules: map[string]config.ExportRules{
+			config.DefaultExportRulesName: {SampleRate: &noSampling},
+			// The prometheus exporter opts out of sampling to keep counts exact
+			"prometheus": {},
+		},
+	}
+	noHeaders := config.HeaderMap(map[string]string{})
+
+	fileRules, found := exporters.RulesOf(config.ExporterFile)
+	assert.True(t, found)
+	assert.False(t, fileRules.Records("1", "GET", 200, noHeaders))
+	prometheusRules, found := exporters.RulesOf(config.ExporterPrometheus)
+	assert.True(t, found)
+	assert.True(t, prometheusRules.Records("1", "GET", 200, noHeaders))
+
+	_, found = (&config.Exporters{}).RulesOf(config.ExporterFile)
+	assert.False(t, found)
+}
@@ -11,32 +11,32 @@ func (diagnosis *Diagnosis) ExporterType() ExporterType {
 		return diagnosis.exporterType
 	}
 
-	exporterLiteral := strings.ToLower(strings.Trim(diagnosis.Export, " "))
+	diagnosis.exporterType = ParseExporterType(diagnosis.Export)
+	if diagnosis.exporterType == ExporterUndefined {
+		if strings.Trim(diagnosis.Export, " ") == "" {
+			log.Error().Msgf("Exporter type is not defined for diagnosis: %v",
+				diagnosis.Name)
+		} else {
+			log.Error().Msgf("Unknown exporter type: %v for diagnosis: %v",
+				diagnosis.Export, diagnosis.Name)
+		}
+	}
 
-	switch exporterLiteral {
+	return diagnosis.exporterType
+}
 
+// ParseExporterType returns the exporter type of the given name,
+// or ExporterUndefined if there is no such exporter
+func ParseExporterType(exporterLiteral string) ExporterType {
+	switch strings.ToLower(strings.Trim(exporterLiteral, " ")) {
 	case ExporterS3.Name():
-		diagnosis.exporterType = ExporterS3
-
+		return ExporterS3
 	case ExporterFile.Name():
-		diagnosis.exporterType = ExporterFile
-
+		return ExporterFile
 	case ExporterS3Minio.Name():
-		diagnosis.exporterType = ExporterS3Minio
-
+		return ExporterS3Minio
 	case ExporterPrometheus.Name():
-		diagnosis.exporterType = ExporterPrometheus
-
-	case "":
-		log.Error().Msgf("Exporter type is not defined for diagnosis: %v",
-			diagnosis.Name)
-		diagnosis.exporterType = ExporterUndefined
-
-	default:
-		log.Error().Msgf("Unknown exporter type: %v for diagnosis: %v",
-			diagnosis.Export, diagnosis.Name)
-		diagnosis.exporterType = ExporterUndefined
+		return ExporterPrometheus
 	}
-
-	return diagnosis.exporterType
+	return ExporterUndefined
 }
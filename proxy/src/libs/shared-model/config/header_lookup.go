@@ -0,0 +1,61 @@
+package config
+
+import "strings"
+
+// joinedHeaderValuesSeparator joins the values of a header sent multiple
+// times, as they would be folded into a single header line
+const joinedHeaderValuesSeparator = ", "
+
+// HeaderLookup looks the headers of a request or response up by name,
+// case-insensitively, resolving a header sent multiple times
+// by the given policy
+type HeaderLookup interface {
+	Header(name string, multiValue MultiValueHeader) (string, bool)
+}
+
+// HeaderMap looks headers up in a map of a single value per header
+type HeaderMap map[string]string
+
+func (headers HeaderMap) Header(name string, _ MultiValueHeader) (string, bool) {
+	if value, found := headers[name]; found {
+		return value, true
+	}
+	for headerName, value := range headers {
+		if strings.EqualFold(headerName, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// ResolveHeaderValues resolves the values of a header sent multiple times
+// into one by the given policy, the first value unless it is defined
+func ResolveHeaderValues(values []string, multiValue MultiValueHeader) string {
+	if len(values) == 0 {
+		return ""
+	}
+	switch multiValue {
+	case MultiValueHeaderLast:
+		return values[len(values)-1]
+	case MultiValueHeaderJoin:
+		return strings.Join(values, joinedHeaderValuesSeparator)
+	case MultiValueHeaderFirst, MultiValueHeaderUndefined:
+		return values[0]
+	}
+	return values[0]
+}
+
+// MultiValueHeader returns how the header grouped by is resolved
+// if sent multiple times
+func (groupBy GroupBy) MultiValueHeader() MultiValueHeader {
+	switch groupBy.MultiValue {
+	case "", "first":
+		return MultiValueHeaderFirst
+	case "last":
+		return MultiValueHeaderLast
+	case "join":
+		return MultiValueHeaderJoin
+	default:
+		return MultiValueHeaderUndefined
+	}
+}
@@ -0,0 +1,18 @@
+package config
+
+func overflowPolicyLiteralToEnum(
+	overflowPolicyLiteral overflowPolicyLiteral,
+) OverflowPolicy {
+	switch overflowPolicyLiteral {
+	case "", "reject_new":
+		return OverflowPolicyRejectNew
+	case "reject_oldest":
+		return OverflowPolicyRejectOldest
+	default:
+		return OverflowPolicyUndefined
+	}
+}
+
+func (queueConfig *StrategyBasedQueueConfig) Overflow() OverflowPolicy {
+	return overflowPolicyLiteralToEnum(queueConfig.OverflowPolicy)
+}
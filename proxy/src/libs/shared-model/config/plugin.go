@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 type PluginConfig interface {
 	IsEnabled() bool
 	GetName() string
@@ -10,6 +12,26 @@ func (plugin Remedy) IsEnabled() bool {
 	return plugin.Enabled
 }
 
+// IsWarmingUp returns whether the remedy only observes requests at the
+// given time, as it is still in its warmup
+func (plugin Remedy) IsWarmingUp(now time.Time) bool {
+	return now.Before(plugin.WarmupEndsAt)
+}
+
+// Applicability returns the upstream health the remedy is applied in
+func (plugin Remedy) Applicability() ApplyWhen {
+	switch plugin.ApplyWhen {
+	case "", "always":
+		return ApplyWhenAlways
+	case "upstream_healthy":
+		return ApplyWhenUpstreamHealthy
+	case "upstream_degraded":
+		return ApplyWhenUpstreamDegraded
+	default:
+		return ApplyWhenUndefined
+	}
+}
+
 func (plugin Diagnosis) IsEnabled() bool {
 	return plugin.Enabled
 }
@@ -86,6 +86,18 @@ func (remedy *Remedy) GetMapping() []typing.UnionMemberPresence[RemedyType] {
 			Defined: remedy.Config.Authentication != nil,
 			Value:   RemedyAuth,
 		},
+		{
+			Defined: remedy.Config.ResponseBodyRewrite != nil,
+			Value:   RemedyResponseBodyRewrite,
+		},
+		{
+			Defined: remedy.Config.DecisionHook != nil,
+			Value:   RemedyDecisionHook,
+		},
+		{
+			Defined: remedy.Config.QueryParamMasking != nil,
+			Value:   RemedyQueryParamMasking,
+		},
 	}
 }
 
@@ -1,12 +1,28 @@
 package config
 
-import "github.com/go-playground/validator/v10"
+import (
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
 
 type PoliciesConfig struct {
 	Global    Global                `yaml:"global"`
 	Endpoints []EndpointConfig      `yaml:"endpoints" validate:"dive"`
 	Accounts  map[AccountID]Account `yaml:"accounts"  validate:"dive"`
 	Exporters Exporters             `yaml:"exporters"`
+	// ErrorTemplates are the error responses remedies can reference by name
+	ErrorTemplates map[string]ErrorTemplate `yaml:"error_templates"`
+}
+
+// ErrorTemplate is an error response remedies can reject requests with,
+// instead of their default response. The headers and body may reference the
+// variables {{request_id}} and {{retry_after}} (in seconds).
+// Without a status code, the status code configured on the remedy is used.
+type ErrorTemplate struct {
+	StatusCode int               `yaml:"status_code"`
+	Headers    map[string]string `yaml:"headers"`
+	Body       string            `yaml:"body"`
 }
 
 type (
@@ -67,6 +83,37 @@ type Exporters struct {
 	S3         *S3ExporterConfig      `yaml:"s3"`
 	S3Minio    *S3MinioExporterConfig `yaml:"s3_minio"`
 	Prometheus *PrometheusConfig      `yaml:"prometheus"`
+	// Rules decide which transactions each exporter records, by the name of
+	// the exporter. Exporters with no rules of their own follow the
+	// `default` rules, so rules of their own opt them out of the default
+	// ones, such as the prometheus exporter out of sampling, to keep its
+	// counts exact.
+	Rules map[string]ExportRules `yaml:"rules" validate:"dive"`
+	// Obfuscate is the obfuscation of the transactions' content exporters
+	// write, such as the samples of rejected requests, conditional on their
+	// response status. Their content is obfuscated unconditionally if unset.
+	Obfuscate *Obfuscate `yaml:"obfuscate"`
+}
+
+// ExportRules decide which transactions an exporter records, before the
+// diagnoses exporting to it process them
+type ExportRules struct {
+	// Include limits the recorded transactions to those matching any of them
+	Include []TransactionMatch `yaml:"include"`
+	// Exclude skips the transactions matching any of them, even if included
+	Exclude []TransactionMatch `yaml:"exclude"`
+	// SampleRate is the share of the remaining transactions recorded,
+	// between 0 and 1, all of them are recorded if unset
+	SampleRate *float64 `yaml:"sample_rate" validate:"omitempty,gte=0,lte=1"`
+}
+
+// TransactionMatch matches transactions by their request and response,
+// a transaction matches if it matches all the set fields.
+// A status code range with no `to` matches its `from` alone.
+type TransactionMatch struct {
+	Methods    []string          `yaml:"methods"`
+	StatusCode []Range[int]      `yaml:"status_code"`
+	Headers    map[string]string `yaml:"headers"`
 }
 
 type Global struct {
@@ -84,9 +131,26 @@ type EndpointConfig struct {
 // Remedy
 
 type Remedy struct {
-	Enabled    bool         `yaml:"enabled"`
-	Name       string       `yaml:"name"    validate:"required"`
-	Config     RemedyConfig `yaml:"config"`
+	Enabled bool         `yaml:"enabled"`
+	Name    string       `yaml:"name"    validate:"required"`
+	Config  RemedyConfig `yaml:"config"`
+	// ErrorTemplate is the name of the error template to reject requests with
+	ErrorTemplate string `yaml:"error_template"`
+	// ResolvedErrorTemplate is set to the referenced error template
+	// once the policies are loaded
+	ResolvedErrorTemplate *ErrorTemplate `yaml:"-"`
+	// RequestsMetricSampling records the requests counter of the remedy for
+	// 1 in every N requests, scaled by N. Gauges remain exact. 0 or 1 is exact
+	RequestsMetricSampling int `yaml:"requests_metric_sampling" validate:"gte=0"`
+	// WarmupSeconds is how long a remedy activated by a reload only observes
+	// requests before enforcing on them. 0 enforces immediately
+	WarmupSeconds int `yaml:"warmup_seconds" validate:"gte=0"`
+	// WarmupEndsAt is set once the policies are reloaded, for remedies which
+	// were activated by the reload with a warmup
+	WarmupEndsAt time.Time `yaml:"-"`
+	// ApplyWhen gates the remedy on the health of the upstream requests are
+	// sent to: `always` (the default), `upstream_healthy` or `upstream_degraded`
+	ApplyWhen  applyWhenLiteral `yaml:"apply_when" validate:"omitempty,oneof=always upstream_healthy upstream_degraded"` //nolint:lll
 	remedyType RemedyType
 }
 
@@ -100,6 +164,9 @@ type RemedyConfig struct {
 	FixedResponse              *FixedResponseConfig              `yaml:"fixed_response"`
 	Retry                      *RetryConfig                      `yaml:"retry"`
 	Authentication             *AuthConfig                       `yaml:"authentication"`
+	ResponseBodyRewrite        *ResponseBodyRewriteConfig        `yaml:"response_body_rewrite"`
+	DecisionHook               *DecisionHookConfig               `yaml:"decision_hook"`
+	QueryParamMasking          *QueryParamMaskingConfig          `yaml:"query_param_masking"`
 }
 
 type RemedyType int
@@ -115,10 +182,45 @@ const (
 	RemedyFixedResponse
 	RemedyRetry
 	RemedyAuth
+	RemedyResponseBodyRewrite
+	RemedyDecisionHook
+	RemedyQueryParamMasking
 )
 
 type AuthConfig struct {
-	Account AccountID `yaml:"account" validate:"required"`
+	Account   AccountID            `yaml:"account"   validate:"required"`
+	Challenge *AuthChallengeConfig `yaml:"challenge"`
+}
+
+// AuthChallengeConfig makes the authentication remedy verify the credentials
+// of the clients before authenticating their requests on the account,
+// responding to requests failing the verification by the way they failed
+type AuthChallengeConfig struct {
+	Realm              string                `yaml:"realm"`
+	Clients            []AuthClient          `yaml:"clients"             validate:"required,dive"`
+	MissingCredentials AuthChallengeResponse `yaml:"missing_credentials"`
+	InvalidCredentials AuthChallengeResponse `yaml:"invalid_credentials"`
+	ExpiredCredentials AuthChallengeResponse `yaml:"expired_credentials"`
+	Unauthorized       AuthChallengeResponse `yaml:"unauthorized"`
+}
+
+// AuthClient holds the credentials of a client, either basic
+// (username and password) or bearer (token)
+type AuthClient struct {
+	Scheme    string     `yaml:"scheme"     validate:"oneof=basic bearer"`
+	Username  string     `yaml:"username"   validate:"required_if=Scheme basic"`
+	Password  string     `yaml:"password"   validate:"required_if=Scheme basic"`
+	Token     string     `yaml:"token"      validate:"required_if=Scheme bearer"`
+	ExpiresAt *time.Time `yaml:"expires_at"`
+	// Authorized is whether the client may call the endpoints, true if unset
+	Authorized *bool `yaml:"authorized"`
+}
+
+// AuthChallengeResponse is the response to requests failing the verification
+// a certain way, the default status code and body of the way are used if unset
+type AuthChallengeResponse struct {
+	StatusCode int    `yaml:"status_code" validate:"omitempty,min=400,max=599"`
+	Body       string `yaml:"body"`
 }
 
 type PayloadPath struct {
@@ -146,6 +248,15 @@ type StrategyBasedThrottlingConfig struct {
 	GroupQuotaAllocation *GroupQuotaAllocation `yaml:"group_quota_allocation"`
 	ResponseStatusCode   int                   `yaml:"response_status_code"`
 	SpilloverConfig      SpilloverConfig       `yaml:"spillover_config"`
+	// PerConnection gives each client connection a quota of its own
+	// (within its group, if grouped), rather than sharing it
+	PerConnection bool `yaml:"per_connection"`
+	// PerClientCertificate groups requests sent over mutual TLS by their
+	// verified client certificate, rather than by the group by header
+	// a client could spoof. Other requests are grouped by the header.
+	PerClientCertificate bool `yaml:"per_client_certificate"`
+	// DynamicQuota overrides the allowed request count at runtime
+	DynamicQuota *DynamicQuota `yaml:"dynamic_quota"`
 }
 
 type SpilloverConfig struct {
@@ -160,11 +271,101 @@ type StrategyBasedQueueConfig struct {
 	TTLSeconds          float32              `yaml:"ttl_seconds"            validate:"required,gte=1"`
 	QueueSize           int64                `yaml:"queue_size"             validate:"required,gte=1"`
 	Prioritization      *GroupPrioritization `yaml:"prioritization"`
+	RateLimitHeaders    *RateLimitHeaders    `yaml:"rate_limit_headers"`
+	// WarmStart carries the budget remaining in the current window over
+	// to a queue recreated for the remedy (e.g. once its strategy changed)
+	WarmStart   bool         `yaml:"warm_start"`
+	ShadowQuota *ShadowQuota `yaml:"shadow_quota"`
+	// OverflowPolicy decides which request is rejected once the queue is
+	// full: the incoming one (`reject_new`, the default) or the oldest
+	// waiting one (`reject_oldest`), which is evicted to admit the incoming
+	OverflowPolicy overflowPolicyLiteral `yaml:"overflow_policy" validate:"omitempty,oneof=reject_new reject_oldest"` //nolint:lll
+	// ShedWhileProviderThrottled rejects requests right away rather than
+	// queueing them, while their provider throttles requests
+	// (as reported by a response based throttling remedy)
+	ShedWhileProviderThrottled bool `yaml:"shed_while_provider_throttled"`
+	// MaxConcurrentRequests additionally limits the admitted requests in
+	// flight at once (0 is unlimited). Admitted requests wait for a free
+	// concurrency slot by their priority, within the rest of their TTL.
+	MaxConcurrentRequests int `yaml:"max_concurrent_requests" validate:"gte=0"`
+	// PriorityAgingSeconds is how long a request waits for a concurrency slot
+	// to be raised by a priority level, so low priority requests are not
+	// starved by higher priority ones (defaults to 1)
+	PriorityAgingSeconds float32 `yaml:"priority_aging_seconds" validate:"gte=0"`
+	// SharedLimitGroup makes the remedies of the same group share a single
+	// queue and quota, such as of an upstream limit enforced across them.
+	// Their concurrency slots, shadow quotas and metrics stay per remedy.
+	SharedLimitGroup string `yaml:"shared_limit_group"`
+	// TTLGraceMilliseconds extends the TTL of queued requests, so requests
+	// which would be admitted right at their TTL are admitted rather than
+	// rejected (defaults to 0). It is bounded by a tenth of the TTL.
+	TTLGraceMilliseconds int `yaml:"ttl_grace_milliseconds" validate:"gte=0"`
+	// DynamicQuota overrides the allowed request count at runtime
+	DynamicQuota *DynamicQuota `yaml:"dynamic_quota"`
+	// TTLExtension extends the TTL of requests of high priorities once,
+	// if they are about to be admitted as it passes (defaults to none)
+	TTLExtension *TTLExtension `yaml:"ttl_extension"`
+}
+
+// TTLExtension extends the TTL of a queued request of one of the eligible
+// priorities once, by up to MaxMilliseconds, if as its TTL passes it is
+// next in line to be admitted by capacity freeing within the extension
+type TTLExtension struct {
+	MaxMilliseconds    int       `yaml:"max_milliseconds"    validate:"required,gte=1"`
+	EligiblePriorities []float64 `yaml:"eligible_priorities" validate:"required,min=1"`
+}
+
+// DynamicQuota overrides the allowed request count of a remedy by the quota
+// of its key in a dynamic source (Lunar Hub or a polled endpoint), bounded
+// by the min and max allowed request counts. While the source has no fresh
+// quota of the key, the static allowed request count applies.
+type DynamicQuota struct {
+	Key                    string `yaml:"key"                       validate:"required"`
+	MinAllowedRequestCount int64  `yaml:"min_allowed_request_count" validate:"gte=1"`
+	MaxAllowedRequestCount int64  `yaml:"max_allowed_request_count" validate:"gtefield=MinAllowedRequestCount"` //nolint:lll
+}
+
+// ShadowQuota is a proposed quota which is only evaluated alongside the
+// enforced one, recording which requests it would have rejected
+type ShadowQuota struct {
+	Enabled             bool  `yaml:"enabled"`
+	AllowedRequestCount int64 `yaml:"allowed_request_count" validate:"required_if=Enabled true,gte=0"` //nolint:lll
+}
+
+// RateLimitHeaders overrides the names of the headers which report the
+// current window usage on proceeded responses. Empty names use the defaults.
+type RateLimitHeaders struct {
+	Limit     string `yaml:"limit"`
+	Remaining string `yaml:"remaining"`
+	Reset     string `yaml:"reset"`
 }
 
 type ConcurrencyBasedThrottlingConfig struct {
 	MaxConcurrentRequests int `yaml:"max_concurrent_requests"`
 	ResponseStatusCode    int `yaml:"response_status_code"    validate:"required,min=100,max=599"`
+	// ScopeIsolation partitions the concurrency budget by scope, so requests
+	// of a slow scope cannot take the slots of the others. If unset, the
+	// requests share a single pool of max_concurrent_requests slots.
+	ScopeIsolation *ConcurrencyScopeIsolation `yaml:"scope_isolation"`
+}
+
+// ConcurrencyScopeIsolation partitions the concurrency budget into the slots
+// reserved for the requests of each scope, which they take first, and a pool
+// of shared slots which the requests of every scope overflow to (and requests
+// of no scope take). The budget is then the reserved and shared slots,
+// in place of max_concurrent_requests.
+type ConcurrencyScopeIsolation struct {
+	Scopes      []ConcurrencyScope `yaml:"scopes"       validate:"dive"`
+	SharedSlots int                `yaml:"shared_slots" validate:"gte=0"`
+}
+
+// ConcurrencyScope is the requests to a URL (a host, optionally followed by
+// a path prefix), of the given method if set. A request is of the first
+// scope it matches.
+type ConcurrencyScope struct {
+	URL           string `yaml:"url"            validate:"required"`
+	Method        string `yaml:"method"`
+	ReservedSlots int    `yaml:"reserved_slots" validate:"gte=1"`
 }
 
 type AccountOrchestrationConfig struct {
@@ -182,6 +383,42 @@ type RetryConfig struct {
 	Conditions             RetryConfigConditions `yaml:"conditions"`
 }
 
+// ResponseBodyRewriteConfig replaces the body of responses with the given
+// status codes (e.g. 5xx ones leaking internal details) with a safe one,
+// keeping their status. A range with no `to` matches its `from` alone.
+type ResponseBodyRewriteConfig struct {
+	StatusCode  []Range[int] `yaml:"status_code"  validate:"required,min=1"`
+	Body        string       `yaml:"body"`
+	ContentType string       `yaml:"content_type"`
+}
+
+// DecisionHookConfig consults a decision hook on requests, which allows,
+// denies or modifies them. The hook is either registered in process under
+// the given name, or called out of process at the given endpoint. A hook
+// which errors or does not decide within the timeout is failed open
+// (allowing the request) or closed (denying it).
+type DecisionHookConfig struct {
+	Hook                string `yaml:"hook"                 validate:"required_without=Endpoint,excluded_with=Endpoint"` //nolint:lll
+	Endpoint            string `yaml:"endpoint"             validate:"omitempty,url"`
+	TimeoutMilliseconds int    `yaml:"timeout_milliseconds" validate:"gte=0"`
+	FailOpen            bool   `yaml:"fail_open"`
+	// the status of denied requests, unless the hook sets one
+	// (defaults to 403, or 503 if the hook failed closed)
+	ResponseStatusCode int `yaml:"response_status_code" validate:"omitempty,min=100,max=599"`
+}
+
+// QueryParamMaskingConfig strips or hashes the configured query parameters
+// of requests before they are forwarded upstream. Parameters which are not
+// configured, or configured to be kept, are forwarded as they were sent.
+type QueryParamMaskingConfig struct {
+	Parameters []QueryParamMasking `yaml:"parameters" validate:"required,min=1,dive"`
+}
+
+type QueryParamMasking struct {
+	Name   string                         `yaml:"name"   validate:"required"`
+	Action queryParamMaskingActionLiteral `yaml:"action" validate:"oneof=strip hash keep"`
+}
+
 type RetryConfigConditions struct {
 	StatusCode []Range[int] `yaml:"status_code" validate:"required"`
 }
@@ -192,6 +429,23 @@ type Range[T any] struct {
 }
 type GroupBy struct {
 	HeaderName string `yaml:"header_name"`
+	// `multi_value` resolves the header if it was sent multiple times,
+	// to its first value unless set
+	MultiValue multiValueHeaderLiteral `yaml:"multi_value" validate:"omitempty,oneof=first last join"` //nolint:lll
+	// `path_patterns` group requests by the first pattern their path matches,
+	// tried from the most specific one. Only used for prioritization
+	PathPatterns []PathPattern `yaml:"path_patterns" validate:"dive"`
+	// the path patterns ordered from the most specific one, set once the
+	// config is loaded so requests are not grouped by sorting them
+	compiledPathPatterns []compiledPathPattern
+}
+
+// PathPattern matches request paths, where `{param}` matches any single
+// segment and `*` matches any single segment, or any remaining segments
+// when it is the last one
+type PathPattern struct {
+	Pattern string `yaml:"pattern" validate:"required"`
+	Group   string `yaml:"group"   validate:"required"`
 }
 type GroupQuotaAllocation struct {
 	GroupBy                     *GroupBy                         `yaml:"group_by"                      validate:"required"` //nolint:lll
@@ -201,8 +455,27 @@ type GroupQuotaAllocation struct {
 }
 
 type GroupPrioritization struct {
+	// `group_by` is only optional if the requests are prioritized
+	// by dimensions or an expression instead
+	GroupBy    GroupBy                    `yaml:"group_by"   validate:"required_without_all=Dimensions Expression"` //nolint:lll
+	Groups     map[string]Prioritization  `yaml:"groups"     validate:"dive"`
+	Dimensions []PrioritizationDimension  `yaml:"dimensions" validate:"dive"`
+	Combine    priorityCombinationLiteral `yaml:"combine"    validate:"omitempty,oneof=min max weighted_sum"` //nolint:lll
+	// `expression` computes the priority out of the request's method, path
+	// and headers. When set, it is used instead of the groups, which are
+	// only fallen back to if the expression fails to evaluate
+	Expression string `yaml:"expression"`
+	// `default_priority` is the priority of requests no dimension matched,
+	// such as requests lacking the grouped by header (defaults to 0,
+	// the highest priority)
+	DefaultPriority float64 `yaml:"default_priority" validate:"validateInt,gte=0"`
+}
+
+type PrioritizationDimension struct {
 	GroupBy GroupBy                   `yaml:"group_by" validate:"required"`
 	Groups  map[string]Prioritization `yaml:"groups"   validate:"dive"`
+	// `weight` is only used by the `weighted_sum` combination, defaults to 1
+	Weight *float64 `yaml:"weight" validate:"omitempty,gte=0"`
 }
 
 type Prioritization struct {
@@ -239,6 +512,143 @@ func (behavior DefaultQuotaGroupBehavior) String() string {
 	return res
 }
 
+type (
+	priorityCombinationLiteral = string
+	PriorityCombination        int
+)
+
+const (
+	PriorityCombinationUndefined PriorityCombination = iota
+	PriorityCombinationMin
+	PriorityCombinationMax
+	PriorityCombinationWeightedSum
+)
+
+func (combination PriorityCombination) String() string {
+	var res string
+	switch combination {
+	case PriorityCombinationMin:
+		res = "min"
+	case PriorityCombinationMax:
+		res = "max"
+	case PriorityCombinationWeightedSum:
+		res = "weighted_sum"
+	case PriorityCombinationUndefined:
+		res = "undefined"
+	}
+
+	return res
+}
+
+type (
+	multiValueHeaderLiteral = string
+	MultiValueHeader        int
+)
+
+const (
+	MultiValueHeaderUndefined MultiValueHeader = iota
+	MultiValueHeaderFirst
+	MultiValueHeaderLast
+	MultiValueHeaderJoin
+)
+
+func (multiValue MultiValueHeader) String() string {
+	var res string
+	switch multiValue {
+	case MultiValueHeaderFirst:
+		res = "first"
+	case MultiValueHeaderLast:
+		res = "last"
+	case MultiValueHeaderJoin:
+		res = "join"
+	case MultiValueHeaderUndefined:
+		res = "undefined"
+	}
+
+	return res
+}
+
+type (
+	applyWhenLiteral = string
+	ApplyWhen        int
+)
+
+const (
+	ApplyWhenUndefined ApplyWhen = iota
+	ApplyWhenAlways
+	ApplyWhenUpstreamHealthy
+	ApplyWhenUpstreamDegraded
+)
+
+func (applyWhen ApplyWhen) String() string {
+	var res string
+	switch applyWhen {
+	case ApplyWhenAlways:
+		res = "always"
+	case ApplyWhenUpstreamHealthy:
+		res = "upstream_healthy"
+	case ApplyWhenUpstreamDegraded:
+		res = "upstream_degraded"
+	case ApplyWhenUndefined:
+		res = "undefined"
+	}
+
+	return res
+}
+
+type (
+	queryParamMaskingActionLiteral = string
+	QueryParamMaskingAction        int
+)
+
+const (
+	QueryParamMaskingUndefined QueryParamMaskingAction = iota
+	QueryParamMaskingStrip
+	QueryParamMaskingHash
+	QueryParamMaskingKeep
+)
+
+func (action QueryParamMaskingAction) String() string {
+	var res string
+	switch action {
+	case QueryParamMaskingStrip:
+		res = "strip"
+	case QueryParamMaskingHash:
+		res = "hash"
+	case QueryParamMaskingKeep:
+		res = "keep"
+	case QueryParamMaskingUndefined:
+		res = "undefined"
+	}
+
+	return res
+}
+
+type (
+	overflowPolicyLiteral = string
+	OverflowPolicy        int
+)
+
+const (
+	OverflowPolicyUndefined OverflowPolicy = iota
+	OverflowPolicyRejectNew
+	OverflowPolicyRejectOldest
+)
+
+func (policy OverflowPolicy) String() string {
+	var res string
+	switch policy {
+	case OverflowPolicyRejectNew:
+		res = "reject_new"
+	case OverflowPolicyRejectOldest:
+		res = "reject_oldest"
+	case OverflowPolicyUndefined:
+		res = "undefined"
+	}
+
+	return res
+}
+
 type QuotaAllocation struct {
 	GroupHeaderValue     string  `yaml:"group_header_value"`
 	AllocationPercentage float64 `yaml:"allocation_percentage" validate:"gte=0"`
@@ -275,11 +685,33 @@ type HARExporterConfig struct {
 	Obfuscate           Obfuscate   `yaml:"obfuscate"`
 	RequestHeaderNames  HeaderNames `yaml:"request_header_names"`
 	ResponseHeaderNames HeaderNames `yaml:"response_header_names"`
+	// CaptureBodies sets whether bodies are captured, they are unless set to false
+	CaptureBodies        *bool                 `yaml:"capture_bodies"`
+	CaptureBodiesOnError *CaptureBodiesOnError `yaml:"capture_bodies_on_error"`
+	// OutputFormat is `har` (the default), a HAR object per transaction,
+	// or `ndjson`, each HAR entry as a line of its own, to be tailed live
+	OutputFormat string `yaml:"output_format" validate:"omitempty,oneof=har ndjson"`
+}
+
+// CaptureBodiesOnError captures the request and response bodies
+// of transactions with error responses, even when bodies are not captured
+// otherwise. Bodies larger than MaxBodySize bytes are not captured.
+type CaptureBodiesOnError struct {
+	Enabled       bool `yaml:"enabled"`
+	MinStatusCode int  `yaml:"min_status_code"`
+	MaxBodySize   int  `yaml:"max_body_size"`
 }
 
 type Obfuscate struct {
-	Enabled    bool                  `yaml:"enabled"`
-	Exclusions ObfuscationExclusions `yaml:"exclusions"`
+	Enabled    bool                   `yaml:"enabled"`
+	Exclusions ObfuscationExclusions  `yaml:"exclusions"`
+	Conditions *ObfuscationConditions `yaml:"conditions"`
+}
+
+// ObfuscationConditions limit obfuscation to the transactions matching them,
+// obfuscation is unconditional if none are set
+type ObfuscationConditions struct {
+	StatusCode []Range[int] `yaml:"status_code"`
 }
 
 type ObfuscationExclusions struct {
@@ -299,6 +731,27 @@ type MetricsCollectorConfig struct {
 	RequestHeaderNames  []string  `yaml:"request_header_names"`
 	ResponseHeaderNames []string  `yaml:"response_header_names"`
 	Counters            []Counter `yaml:"counters"`
+	SLOs                []SLO     `yaml:"slos"                  validate:"dive"`
+}
+
+// SLO is a service level objective of the transactions in the scope of the
+// diagnosis, whose compliance over a rolling window is exported as metrics
+type SLO struct {
+	Name          string `yaml:"name"           validate:"required"`
+	WindowSeconds int    `yaml:"window_seconds" validate:"required,gte=1"`
+	// Latency is met by the ratio of transactions faster than its threshold
+	Latency *LatencyObjective `yaml:"latency"`
+	// ErrorRate is met while the ratio of 5xx responses does not exceed it
+	ErrorRate *ErrorRateObjective `yaml:"error_rate"`
+}
+
+type LatencyObjective struct {
+	ThresholdMillis int64   `yaml:"threshold_millis" validate:"required,gte=1"`
+	Target          float64 `yaml:"target"           validate:"required,gt=0,lte=1"`
+}
+
+type ErrorRateObjective struct {
+	Target float64 `yaml:"target" validate:"gte=0,lt=1"`
 }
 
 type (
@@ -386,7 +839,14 @@ type S3MinioExporterConfig struct {
 
 type PrometheusConfig struct {
 	BucketBoundaries []float64 `yaml:"bucket_boundaries"`
+	// SizeBucketBoundaries are the boundaries, in bytes,
+	// of the request and response size histograms
+	SizeBucketBoundaries []float64 `yaml:"size_bucket_boundaries"`
+	// ResponseSizeEnabled also records the size of responses,
+	// along with the size of requests
+	ResponseSizeEnabled bool `yaml:"response_size_enabled"`
 }
 
-// use a single instance of Validate, it caches struct info
-var Validate *validator.Validate = validator.New()
+// use a single instance of Validate, it caches struct info.
+// `required` is applied to non-pointer structs as well, so empty ones fail it
+var Validate *validator.Validate = validator.New(validator.WithRequiredStructEnabled())
@@ -45,6 +45,12 @@ func (remedyType RemedyType) String() string {
 		result = "retry"
 	case RemedyAuth:
 		result = "authentication"
+	case RemedyResponseBodyRewrite:
+		result = "response_body_rewrite"
+	case RemedyDecisionHook:
+		result = "decision_hook"
+	case RemedyQueryParamMasking:
+		result = "query_param_masking"
 	case RemedyUndefined:
 		result = "undefined"
 	}
@@ -76,6 +82,12 @@ func ParseRemedyType(raw string) (RemedyType, error) {
 		res = RemedyRetry
 	case RemedyAuth.String():
 		res = RemedyAuth
+	case RemedyResponseBodyRewrite.String():
+		res = RemedyResponseBodyRewrite
+	case RemedyDecisionHook.String():
+		res = RemedyDecisionHook
+	case RemedyQueryParamMasking.String():
+		res = RemedyQueryParamMasking
 	default:
 		return RemedyUndefined, fmt.Errorf(
 			"RemedyType %v is not recognized",
@@ -152,3 +164,36 @@ func (auth *BasicAuth) LoadEnvValues() error {
 	}
 	return nil
 }
+
+func (challenge *AuthChallengeConfig) LoadEnvValues() error {
+	for index := range challenge.Clients {
+		if err := challenge.Clients[index].LoadEnvValues(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (client *AuthClient) LoadEnvValues() error {
+	var err error
+	client.Username, err = configuration.TryAndLoadEnvTemplateValue(client.Username)
+	if err != nil {
+		return err
+	}
+
+	client.Password, err = configuration.TryAndLoadEnvTemplateValue(client.Password)
+	if err != nil {
+		return err
+	}
+
+	client.Token, err = configuration.TryAndLoadEnvTemplateValue(client.Token)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// IsAuthorized is whether the client may call the endpoints it is verified on
+func (client *AuthClient) IsAuthorized() bool {
+	return client.Authorized == nil || *client.Authorized
+}
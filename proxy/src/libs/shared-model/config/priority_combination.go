@@ -0,0 +1,159 @@
+package config
+
+import (
+	"math"
+	"sort"
+)
+
+const defaultDimensionWeight = 1
+
+func priorityCombinationLiteralToEnum(
+	combinationLiteral priorityCombinationLiteral,
+) PriorityCombination {
+	switch combinationLiteral {
+	case "", "min":
+		return PriorityCombinationMin
+	case "max":
+		return PriorityCombinationMax
+	case "weighted_sum":
+		return PriorityCombinationWeightedSum
+	default:
+		return PriorityCombinationUndefined
+	}
+}
+
+func (prioritization *GroupPrioritization) Combination() PriorityCombination {
+	return priorityCombinationLiteralToEnum(prioritization.Combine)
+}
+
+// AllDimensions returns the configured dimensions,
+// with the top level `group_by` & `groups` as the first one (if set)
+func (prioritization *GroupPrioritization) AllDimensions() []PrioritizationDimension {
+	dimensions := []PrioritizationDimension{}
+	if prioritization.GroupBy.HeaderName != "" ||
+		len(prioritization.GroupBy.PathPatterns) > 0 ||
+		len(prioritization.Groups) > 0 {
+		dimensions = append(dimensions, PrioritizationDimension{
+			GroupBy: prioritization.GroupBy,
+			Groups:  prioritization.Groups,
+			Weight:  nil,
+		})
+	}
+	return append(dimensions, prioritization.Dimensions...)
+}
+
+// GroupCount returns the number of groups configured across all dimensions
+func (prioritization *GroupPrioritization) GroupCount() int {
+	count := 0
+	for _, dimension := range prioritization.AllDimensions() {
+		count += len(dimension.Groups)
+	}
+	return count
+}
+
+// Priorities returns the distinct priorities the groups are configured with,
+// along with the default priority of unmatched requests, in ascending order
+func (prioritization *GroupPrioritization) Priorities() []float64 {
+	distinct := map[float64]struct{}{prioritization.DefaultPriority: {}}
+	for _, dimension := range prioritization.AllDimensions() {
+		for _, group := range dimension.Groups {
+			distinct[group.Priority] = struct{}{}
+		}
+	}
+	priorities := make([]float64, 0, len(distinct))
+	for priority := range distinct {
+		priorities = append(priorities, priority)
+	}
+	sort.Float64s(priorities)
+	return priorities
+}
+
+// GroupPriorityRange returns the lowest and highest priorities the groups are
+// configured with, if any group is
+func (prioritization *GroupPrioritization) GroupPriorityRange() (float64, float64, bool) {
+	lowest, highest := math.Inf(1), math.Inf(-1)
+	for _, dimension := range prioritization.AllDimensions() {
+		for _, group := range dimension.Groups {
+			lowest = math.Min(lowest, group.Priority)
+			highest = math.Max(highest, group.Priority)
+		}
+	}
+	return lowest, highest, !math.IsInf(lowest, 1)
+}
+
+// Priority combines the priorities of all dimensions matched by the given
+// path and headers into one. Unmatched dimensions are neutral and do not affect the
+// result. If no dimension is matched, it will default to the default priority,
+// which is 0 (the highest priority) unless configured otherwise.
+// A weighted sum is clamped to the range of the configured priorities.
+func (prioritization *GroupPrioritization) Priority(
+	path string,
+	headers map[string]string,
+) float64 {
+	return prioritization.PriorityOf(path, HeaderMap(headers))
+}
+
+// PriorityOf is Priority of a request whose headers are looked up
+// case-insensitively, so headers sent multiple times are resolved
+// by the policy of each dimension
+func (prioritization *GroupPrioritization) PriorityOf(
+	path string,
+	headers HeaderLookup,
+) float64 {
+	priority, _ := prioritization.MatchPriorityOf(path, headers)
+	return priority
+}
+
+// MatchPriorityOf is PriorityOf, along with whether any dimension matched
+// the request, telling a matched priority of 0 apart from the default one
+func (prioritization *GroupPrioritization) MatchPriorityOf(
+	path string,
+	headers HeaderLookup,
+) (float64, bool) {
+	combination := prioritization.Combination()
+	lowest, highest := math.Inf(1), math.Inf(-1)
+	var res float64
+	matched := false
+
+	for _, dimension := range prioritization.AllDimensions() {
+		for _, group := range dimension.Groups {
+			lowest = math.Min(lowest, group.Priority)
+			highest = math.Max(highest, group.Priority)
+		}
+
+		group, found := dimension.Groups[dimension.GroupBy.groupOf(path, headers)]
+		if !found {
+			continue
+		}
+
+		switch {
+		case !matched:
+			res = group.Priority
+			if combination == PriorityCombinationWeightedSum {
+				res *= dimension.weight()
+			}
+		case combination == PriorityCombinationMax:
+			res = math.Max(res, group.Priority)
+		case combination == PriorityCombinationWeightedSum:
+			res += group.Priority * dimension.weight()
+		default:
+			res = math.Min(res, group.Priority)
+		}
+		matched = true
+	}
+
+	if !matched {
+		return prioritization.DefaultPriority, false
+	}
+	if combination == PriorityCombinationWeightedSum {
+		res = math.Max(lowest, math.Min(highest, res))
+	}
+	return res, true
+}
+
+func (dimension PrioritizationDimension) weight() float64 {
+	if dimension.Weight == nil {
+		return defaultDimensionWeight
+	}
+	return *dimension.Weight
+}
@@ -0,0 +1,259 @@
+package config_test
+
+import (
+	"lunar/shared-model/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	tierHeader = "X-Customer-Tier"
+	costHeader = "X-Endpoint-Cost"
+)
+
+func weight(value float64) *float64 {
+	return &value
+}
+
+func buildMultiDimensionPrioritization(
+	combine string,
+	tierWeight *float64,
+	costWeight *float64,
+) config.GroupPrioritization {
+	return config.GroupPrioritization{
+		Combine: combine,
+		Dimensions: []config.PrioritizationDimension{
+			{
+				GroupBy: config.GroupBy{HeaderName: tierHeader},
+				Groups: map[string]config.Prioritization{
+					"premium": {Priority: 1},
+					"free":    {Priority: 5},
+				},
+				Weight: tierWeight,
+			},
+			{
+				GroupBy: config.GroupBy{HeaderName: costHeader},
+				Groups: map[string]config.Prioritization{
+					"cheap":     {Priority: 2},
+					"expensive": {Priority: 4},
+				},
+				Weight: costWeight,
+			},
+		},
+	}
+}
+
+func TestPriorityWithSingleGroupBy(t *testing.T) {
+	prioritization := config.GroupPrioritization{
+		GroupBy: config.GroupBy{HeaderName: tierHeader},
+		Groups: map[string]config.Prioritization{
+			"premium": {Priority: 1},
+			"free":    {Priority: 5},
+		},
+	}
+
+	assert.Equal(t, 5.0, prioritization.Priority("", map[string]string{tierHeader: "free"}))
+	assert.Equal(t, 0.0, prioritization.Priority("", map[string]string{}))
+}
+
+func TestPriorityCombinedWithMin(t *testing.T) {
+	prioritization := buildMultiDimensionPrioritization("min", nil, nil)
+
+	premiumOnCheap := map[string]string{tierHeader: "premium", costHeader: "cheap"}
+	freeOnExpensive := map[string]string{tierHeader: "free", costHeader: "expensive"}
+
+	assert.Equal(t, 1.0, prioritization.Priority("", premiumOnCheap))
+	assert.Equal(t, 4.0, prioritization.Priority("", freeOnExpensive))
+}
+
+func TestPriorityCombinesWithMinByDefault(t *testing.T) {
+	prioritization := buildMultiDimensionPrioritization("", nil, nil)
+
+	assert.Equal(t, config.PriorityCombinationMin, prioritization.Combination())
+	assert.Equal(t, 4.0, prioritization.Priority("",
+		map[string]string{tierHeader: "free", costHeader: "expensive"}))
+}
+
+func TestPriorityCombinedWithMax(t *testing.T) {
+	prioritization := buildMultiDimensionPrioritization("max", nil, nil)
+
+	premiumOnCheap := map[string]string{tierHeader: "premium", costHeader: "cheap"}
+	freeOnExpensive := map[string]string{tierHeader: "free", costHeader: "expensive"}
+
+	assert.Equal(t, 2.0, prioritization.Priority("", premiumOnCheap))
+	assert.Equal(t, 5.0, prioritization.Priority("", freeOnExpensive))
+}
+
+func TestPriorityCombinedWithWeightedSum(t *testing.T) {
+	prioritization := buildMultiDimensionPrioritization(
+		"weighted_sum", weight(0.5), weight(0.5))
+
+	premiumOnCheap := map[string]string{tierHeader: "premium", costHeader: "cheap"}
+	freeOnExpensive := map[string]string{tierHeader: "free", costHeader: "expensive"}
+
+	assert.Equal(t, 1.5, prioritization.Priority("", premiumOnCheap))
+	assert.Equal(t, 4.5, prioritization.Priority("", freeOnExpensive))
+}
+
+func TestPriorityCombinedWithWeightedSumIsClamped(t *testing.T) {
+	// Weights default to 1, so sums may exceed the configured priorities
+	prioritization := buildMultiDimensionPrioritization("weighted_sum", nil, nil)
+
+	freeOnExpensive := map[string]string{tierHeader: "free", costHeader: "expensive"}
+	assert.Equal(t, 5.0, prioritization.Priority("", freeOnExpensive))
+
+	prioritization = buildMultiDimensionPrioritization(
+		"weighted_sum", weight(0.1), weight(0.1))
+	premiumOnCheap := map[string]string{tierHeader: "premium", costHeader: "cheap"}
+	assert.Equal(t, 1.0, prioritization.Priority("", premiumOnCheap))
+}
+
+func TestPriorityCombinedWithWeightedSumHonoursZeroWeight(t *testing.T) {
+	prioritization := buildMultiDimensionPrioritization(
+		"weighted_sum", weight(1), weight(0))
+
+	freeOnExpensive := map[string]string{tierHeader: "free", costHeader: "expensive"}
+	assert.Equal(t, 5.0, prioritization.Priority("", freeOnExpensive))
+	// the cost dimension does not contribute to the sum
+	premiumOnCheap := map[string]string{tierHeader: "premium", costHeader: "cheap"}
+	assert.Equal(t, 1.0, prioritization.Priority("", premiumOnCheap))
+}
+
+func TestPriorityIgnoresUnmatchedDimensions(t *testing.T) {
+	onlyTierMatched := map[string]string{tierHeader: "free", costHeader: "unknown"}
+	onlyCostMatched := map[string]string{costHeader: "expensive"}
+
+	for _, combine := range []string{"min", "max", "weighted_sum"} {
+		prioritization := buildMultiDimensionPrioritization(combine, nil, nil)
+		assert.Equal(t, 5.0, prioritization.Priority("", onlyTierMatched), combine)
+		assert.Equal(t, 4.0, prioritization.Priority("", onlyCostMatched), combine)
+		assert.Equal(t, 0.0, prioritization.Priority("", map[string]string{}), combine)
+	}
+}
+
+func TestMatchPriorityTellsMatchedZeroApartFromDefault(t *testing.T) {
+	prioritization := config.GroupPrioritization{
+		GroupBy: config.GroupBy{HeaderName: tierHeader},
+		Groups: map[string]config.Prioritization{
+			"premium": {Priority: 0},
+			"free":    {Priority: 5},
+		},
+	}
+
+	priority, matched := prioritization.MatchPriorityOf("",
+		config.HeaderMap(map[string]string{tierHeader: "premium"}))
+	assert.True(t, matched)
+	assert.Equal(t, 0.0, priority)
+
+	priority, matched = prioritization.MatchPriorityOf("",
+		config.HeaderMap(map[string]string{tierHeader: "unknown"}))
+	assert.False(t, matched)
+	assert.Equal(t, 0.0, priority)
+}
+
+func TestPriorityDefaultsUnmatchedRequestsToTheDefaultPriority(t *testing.T) {
+	prioritization := config.GroupPrioritization{
+		GroupBy: config.GroupBy{HeaderName: tierHeader},
+		Groups: map[string]config.Prioritization{
+			"premium": {Priority: 0},
+			"free":    {Priority: 5},
+		},
+		DefaultPriority: 5,
+	}
+
+	// The header is absent
+	priority, matched := prioritization.MatchPriorityOf("",
+		config.HeaderMap(map[string]string{}))
+	assert.False(t, matched)
+	assert.Equal(t, 5.0, priority)
+
+	// The header matches no group
+	priority, matched = prioritization.MatchPriorityOf("",
+		config.HeaderMap(map[string]string{tierHeader: "unknown"}))
+	assert.False(t, matched)
+	assert.Equal(t, 5.0, priority)
+
+	assert.Equal(t, 0.0, prioritization.Priority("",
+		map[string]string{tierHeader: "premium"}))
+	assert.Equal(t, []float64{0, 5}, prioritization.Priorities())
+}
+
+func TestPriorityCombinesGroupByWithDimensions(t *testing.T) {
+	prioritization := buildMultiDimensionPrioritization("max", nil, nil)
+	prioritization.GroupBy = config.GroupBy{HeaderName: "X-Region"}
+	prioritization.Groups = map[string]config.Prioritization{
+		"eu": {Priority: 3},
+	}
+
+	assert.Equal(t, 3.0, prioritization.Priority("",
+		map[string]string{"X-Region": "eu", tierHeader: "premium"}))
+}
+
+func buildPathPrioritization(patterns ...config.PathPattern) config.GroupPrioritization {
+	return config.GroupPrioritization{
+		GroupBy: config.GroupBy{PathPatterns: patterns},
+		Groups: map[string]config.Prioritization{
+			"critical": {Priority: 1},
+			"standard": {Priority: 3},
+			"bulk":     {Priority: 5},
+		},
+	}
+}
+
+func TestPriorityByPathPatterns(t *testing.T) {
+	prioritization := buildPathPrioritization(
+		config.PathPattern{Pattern: "/v1/critical/*", Group: "critical"},
+		config.PathPattern{Pattern: "/v1/bulk/{job}", Group: "bulk"},
+	)
+
+	assert.Equal(t, 1.0, prioritization.Priority("/v1/critical/orders/1", nil))
+	assert.Equal(t, 5.0, prioritization.Priority("/v1/bulk/export?page=2", nil))
+	// A parameter matches a single segment only
+	assert.Equal(t, 0.0, prioritization.Priority("/v1/bulk/export/1", nil))
+	assert.Equal(t, 0.0, prioritization.Priority("/v2/critical/orders", nil))
+}
+
+func TestPriorityByOverlappingPathPatternsPrefersMostSpecific(t *testing.T) {
+	// Configured from the least specific, so ordering is not by configuration
+	prioritization := buildPathPrioritization(
+		config.PathPattern{Pattern: "/v1/*", Group: "standard"},
+		config.PathPattern{Pattern: "/v1/{resource}/export", Group: "bulk"},
+		config.PathPattern{Pattern: "/v1/orders/{id}", Group: "critical"},
+	)
+
+	// Compiled once the config is loaded, patterns are tried in the same order
+	for _, compiled := range []bool{false, true} {
+		if compiled {
+			prioritization.Compile()
+		}
+		assert.Equal(t, 1.0, prioritization.Priority("/v1/orders/export", nil))
+		assert.Equal(t, 5.0, prioritization.Priority("/v1/users/export", nil))
+		assert.Equal(t, 3.0, prioritization.Priority("/v1/users/1/export", nil))
+		assert.Equal(t, 3.0, prioritization.Priority("/v1/users", nil))
+	}
+}
+
+func TestPriorityByEquallySpecificPathPatternsPrefersFirst(t *testing.T) {
+	prioritization := buildPathPrioritization(
+		config.PathPattern{Pattern: "/v1/{tenant}/jobs", Group: "bulk"},
+		config.PathPattern{Pattern: "/v1/{region}/jobs", Group: "critical"},
+	)
+
+	assert.Equal(t, 5.0, prioritization.Priority("/v1/eu/jobs", nil))
+}
+
+func TestPriorityByPathPatternsComposesWithHeaderDimensions(t *testing.T) {
+	prioritization := buildMultiDimensionPrioritization("max", nil, nil)
+	prioritization.GroupBy = config.GroupBy{PathPatterns: []config.PathPattern{
+		{Pattern: "/v1/critical/*", Group: "critical"},
+	}}
+	prioritization.Groups = map[string]config.Prioritization{
+		"critical": {Priority: 3},
+	}
+
+	assert.Equal(t, 3.0, prioritization.Priority("/v1/critical/orders",
+		map[string]string{tierHeader: "premium"}))
+	assert.Equal(t, 1.0, prioritization.Priority("/v1/orders",
+		map[string]string{tierHeader: "premium"}))
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"lunar/toolkit-core/urltree"
+	"sort"
+	"strings"
+)
+
+const pathPatternWildcard = "*"
+
+// Specificity of a single pattern segment, from the least specific one
+const (
+	wildcardSegment = iota
+	parameterSegment
+	literalSegment
+)
+
+// compiledPathPattern is a path pattern split into its segments
+type compiledPathPattern struct {
+	group    string
+	segments []string
+}
+
+// groupOf returns the group of the request by this group by, which is
+// either the value of its header or the group of the first path pattern
+// matching its path
+func (groupBy GroupBy) groupOf(path string, headers HeaderLookup) string {
+	if len(groupBy.PathPatterns) == 0 {
+		value, _ := headers.Header(groupBy.HeaderName, groupBy.MultiValueHeader())
+		return value
+	}
+	compiled := groupBy.compiledPathPatterns
+	// A group by which was not loaded with the config is compiled as it is used
+	if len(compiled) != len(groupBy.PathPatterns) {
+		compiled = groupBy.compilePathPatterns()
+	}
+	pathSegments := splitPath(path)
+	for _, pathPattern := range compiled {
+		if matchSegments(pathPattern.segments, pathSegments) {
+			return pathPattern.group
+		}
+	}
+	return ""
+}
+
+// Compile orders the path patterns of the prioritization's dimensions once,
+// so requests are grouped without sorting them. Called once the config is
+// loaded, as it is not safe to call while requests are prioritized.
+func (prioritization *GroupPrioritization) Compile() {
+	prioritization.GroupBy.compiledPathPatterns =
+		prioritization.GroupBy.compilePathPatterns()
+	for index := range prioritization.Dimensions {
+		groupBy := &prioritization.Dimensions[index].GroupBy
+		groupBy.compiledPathPatterns = groupBy.compilePathPatterns()
+	}
+}
+
+func (groupBy GroupBy) compilePathPatterns() []compiledPathPattern {
+	ordered := groupBy.orderedPathPatterns()
+	compiled := make([]compiledPathPattern, len(ordered))
+	for index, pathPattern := range ordered {
+		compiled[index] = compiledPathPattern{
+			group:    pathPattern.Group,
+			segments: splitPath(pathPattern.Pattern),
+		}
+	}
+	return compiled
+}
+
+// Source describes what requests are grouped by
+func (groupBy GroupBy) Source() string {
+	if len(groupBy.PathPatterns) > 0 {
+		return "path"
+	}
+	return groupBy.HeaderName
+}
+
+// orderedPathPatterns orders the path patterns from the most specific one,
+// which compares their segments in order, a literal segment being more
+// specific than a parameter and a parameter more than a wildcard,
+// and a longer pattern more specific than its prefix.
+// Equally specific patterns keep their configured order.
+func (groupBy GroupBy) orderedPathPatterns() []PathPattern {
+	ordered := append([]PathPattern{}, groupBy.PathPatterns...)
+	specificities := map[string][]int{}
+	for _, pathPattern := range ordered {
+		specificities[pathPattern.Pattern] = pathPattern.specificity()
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return moreSpecific(specificities[ordered[i].Pattern],
+			specificities[ordered[j].Pattern])
+	})
+	return ordered
+}
+
+func moreSpecific(specificity []int, other []int) bool {
+	for index := 0; index < len(specificity) && index < len(other); index++ {
+		if specificity[index] != other[index] {
+			return specificity[index] > other[index]
+		}
+	}
+	return len(specificity) > len(other)
+}
+
+func (pathPattern PathPattern) specificity() []int {
+	segments := splitPath(pathPattern.Pattern)
+	specificity := make([]int, len(segments))
+	for index, segment := range segments {
+		switch _, isParameter := urltree.TryExtractPathParameter(segment); {
+		case segment == pathPatternWildcard:
+			specificity[index] = wildcardSegment
+		case isParameter:
+			specificity[index] = parameterSegment
+		default:
+			specificity[index] = literalSegment
+		}
+	}
+	return specificity
+}
+
+// Match returns whether the path (without its query) matches the pattern
+func (pathPattern PathPattern) Match(path string) bool {
+	return matchSegments(splitPath(pathPattern.Pattern), splitPath(path))
+}
+
+func matchSegments(patternSegments []string, pathSegments []string) bool {
+	for index, patternSegment := range patternSegments {
+		isWildcard := patternSegment == pathPatternWildcard
+		if isWildcard && index == len(patternSegments)-1 {
+			return true
+		}
+		if index >= len(pathSegments) {
+			return false
+		}
+		_, isParameter := urltree.TryExtractPathParameter(patternSegment)
+		if isWildcard || isParameter {
+			continue
+		}
+		if patternSegment != pathSegments[index] {
+			return false
+		}
+	}
+	return len(patternSegments) == len(pathSegments)
+}
+
+func splitPath(path string) []string {
+	if index := strings.IndexByte(path, '?'); index >= 0 {
+		path = path[:index]
+	}
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
@@ -0,0 +1,20 @@
+package config
+
+func queryParamMaskingActionLiteralToEnum(
+	actionLiteral queryParamMaskingActionLiteral,
+) QueryParamMaskingAction {
+	switch actionLiteral {
+	case "strip":
+		return QueryParamMaskingStrip
+	case "hash":
+		return QueryParamMaskingHash
+	case "keep":
+		return QueryParamMaskingKeep
+	default:
+		return QueryParamMaskingUndefined
+	}
+}
+
+func (masking *QueryParamMasking) MaskingAction() QueryParamMaskingAction {
+	return queryParamMaskingActionLiteralToEnum(masking.Action)
+}
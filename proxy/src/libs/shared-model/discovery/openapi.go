@@ -0,0 +1,244 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"lunar/toolkit-core/urltree"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	openAPIVersion = "3.0.3"
+	// endpointKeyDelimiter separates the method and the URL in the keys of
+	// the discovered endpoints, as the aggregation persists them
+	endpointKeyDelimiter = ":::"
+	draftDescription     = "A draft generated from the traffic Lunar observed. " +
+		"It is lossy: only the paths, their methods, the status codes " +
+		"responded with and the path parameters are inferred, so request and " +
+		"response schemas, query and header parameters, and endpoints not " +
+		"observed are missing, and path parameters are named by their position."
+)
+
+// openAPIMethods are the methods an OpenAPI path item holds operations for,
+// endpoints of other methods are left out of the document
+var openAPIMethods = map[string]bool{
+	"get": true, "put": true, "post": true, "delete": true,
+	"options": true, "head": true, "patch": true, "trace": true,
+}
+
+type (
+	OpenAPIDocument struct {
+		OpenAPI string                      `json:"openapi"`
+		Info    OpenAPIInfo                 `json:"info"`
+		Servers []OpenAPIServer             `json:"servers,omitempty"`
+		Paths   map[string]*OpenAPIPathItem `json:"paths"`
+	}
+
+	OpenAPIInfo struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Version     string `json:"version"`
+	}
+
+	OpenAPIServer struct {
+		URL string `json:"url"`
+	}
+
+	// OpenAPIPathItem holds the operations of a path by their lower-cased
+	// method, and the servers the path was observed on
+	OpenAPIPathItem struct {
+		Servers    []OpenAPIServer
+		Operations map[string]*OpenAPIOperation
+	}
+
+	OpenAPIOperation struct {
+		Parameters []OpenAPIParameter         `json:"parameters,omitempty"`
+		Responses  map[string]OpenAPIResponse `json:"responses"`
+		// The number of requests observed, and their average duration
+		ObservedCount         int     `json:"x-lunar-observed-count"`
+		AverageDurationMillis float32 `json:"x-lunar-average-duration-ms"`
+
+		responseCounts map[string]int
+	}
+
+	OpenAPIParameter struct {
+		Name     string        `json:"name"`
+		In       string        `json:"in"`
+		Required bool          `json:"required"`
+		Schema   OpenAPISchema `json:"schema"`
+	}
+
+	OpenAPISchema struct {
+		Type string `json:"type"`
+	}
+
+	OpenAPIResponse struct {
+		Description string `json:"description"`
+	}
+)
+
+func (pathItem *OpenAPIPathItem) MarshalJSON() ([]byte, error) {
+	fields := map[string]any{}
+	for method, operation := range pathItem.Operations {
+		fields[method] = operation
+	}
+	if len(pathItem.Servers) > 0 {
+		fields["servers"] = pathItem.Servers
+	}
+	return json.Marshal(fields)
+}
+
+// ToOpenAPI drafts an OpenAPI document of the discovered endpoints.
+// Their paths are templatized by the URL tree's assumed path params,
+// paths with more than maxSplitThreshold distinct values in a segment
+// having it converged into a path parameter.
+func (output Output) ToOpenAPI(maxSplitThreshold int) (OpenAPIDocument, error) {
+	document := OpenAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: OpenAPIInfo{
+			Title:       "Discovered API",
+			Description: draftDescription,
+			Version:     output.CreatedAt,
+		},
+		Servers: []OpenAPIServer{},
+		Paths:   map[string]*OpenAPIPathItem{},
+	}
+	if document.Info.Version == "" {
+		document.Info.Version = "draft"
+	}
+
+	tree := urltree.NewURLTree[struct{}](true, maxSplitThreshold)
+	for key := range output.Endpoints {
+		_, url, found := strings.Cut(key, endpointKeyDelimiter)
+		if !found {
+			return document, fmt.Errorf("invalid discovered endpoint: %v", key)
+		}
+		if err := tree.Insert(url, &struct{}{}); err != nil {
+			return document, fmt.Errorf("failed to templatize %v: %w", url, err)
+		}
+	}
+
+	hosts := map[string]bool{}
+	for key, endpoint := range output.Endpoints {
+		method, url, _ := strings.Cut(key, endpointKeyDelimiter)
+		method = strings.ToLower(method)
+		if !openAPIMethods[method] {
+			continue
+		}
+		if lookup := tree.Lookup(url); lookup.Match {
+			url = lookup.NormalizedURL
+		}
+		host, path := splitHost(url)
+		hosts[host] = true
+
+		pathItem, found := document.Paths[path]
+		if !found {
+			pathItem = &OpenAPIPathItem{
+				Servers:    []OpenAPIServer{},
+				Operations: map[string]*OpenAPIOperation{},
+			}
+			document.Paths[path] = pathItem
+		}
+		pathItem.Servers = appendServer(pathItem.Servers, host)
+		operation, found := pathItem.Operations[method]
+		if !found {
+			operation = &OpenAPIOperation{
+				Parameters:            pathParameters(path),
+				Responses:             map[string]OpenAPIResponse{},
+				ObservedCount:         0,
+				AverageDurationMillis: 0,
+				responseCounts:        map[string]int{},
+			}
+			pathItem.Operations[method] = operation
+		}
+		operation.observe(endpoint)
+	}
+
+	for host := range hosts {
+		document.Servers = append(document.Servers, serverOf(host))
+	}
+	sort.Slice(document.Servers, func(i, j int) bool {
+		return document.Servers[i].URL < document.Servers[j].URL
+	})
+	for _, pathItem := range document.Paths {
+		// servers are only listed by paths once the document has several
+		if len(document.Servers) <= 1 {
+			pathItem.Servers = nil
+		}
+		for _, operation := range pathItem.Operations {
+			operation.describeResponses()
+		}
+	}
+	return document, nil
+}
+
+// observe adds the endpoint to the operation, as endpoints of several hosts
+// or converged paths are drafted as the same operation
+func (operation *OpenAPIOperation) observe(endpoint EndpointOutput) {
+	totalCount := operation.ObservedCount + endpoint.Count
+	if totalCount > 0 {
+		operation.AverageDurationMillis = (operation.AverageDurationMillis*
+			float32(operation.ObservedCount) +
+			endpoint.AverageDuration*float32(endpoint.Count)) /
+			float32(totalCount)
+	}
+	operation.ObservedCount = totalCount
+
+	for statusCode, count := range endpoint.StatusCodes {
+		operation.responseCounts[strconv.Itoa(statusCode)] += count
+	}
+}
+
+// describeResponses describes the responses by the status codes observed,
+// an operation requiring a response even if none was
+func (operation *OpenAPIOperation) describeResponses() {
+	for statusCode, count := range operation.responseCounts {
+		operation.Responses[statusCode] = OpenAPIResponse{
+			Description: fmt.Sprintf("Observed %d times", count),
+		}
+	}
+	if len(operation.Responses) == 0 {
+		operation.Responses["default"] = OpenAPIResponse{
+			Description: "No responses were observed",
+		}
+	}
+}
+
+// pathParameters are the path parameters of the templatized path
+func pathParameters(path string) []OpenAPIParameter {
+	parameters := []OpenAPIParameter{}
+	for _, segment := range strings.Split(path, "/") {
+		name, isParameter := urltree.TryExtractPathParameter(segment)
+		if !isParameter {
+			continue
+		}
+		parameters = append(parameters, OpenAPIParameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   OpenAPISchema{Type: "string"},
+		})
+	}
+	return parameters
+}
+
+func splitHost(url string) (string, string) {
+	host, path, _ := strings.Cut(url, "/")
+	return host, "/" + path
+}
+
+func serverOf(host string) OpenAPIServer {
+	return OpenAPIServer{URL: "https://" + host}
+}
+
+func appendServer(servers []OpenAPIServer, host string) []OpenAPIServer {
+	server := serverOf(host)
+	for _, existing := range servers {
+		if existing == server {
+			return servers
+		}
+	}
+	return append(servers, server)
+}
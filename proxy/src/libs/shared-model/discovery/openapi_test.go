@@ -0,0 +1,156 @@
+package discovery_test
+
+import (
+	"encoding/json"
+	"fmt"
+	sharedDiscovery "lunar/shared-model/discovery"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func discoveredEndpoint(count int, statusCodes map[int]int) sharedDiscovery.EndpointOutput {
+	return sharedDiscovery.EndpointOutput{
+		MinTime:         "2024-01-01T00:00:00Z",
+		MaxTime:         "2024-01-02T00:00:00Z",
+		Count:           count,
+		StatusCodes:     statusCodes,
+		AverageDuration: 10,
+	}
+}
+
+func syntheticDiscoveryOutput() sharedDiscovery.Output {
+	endpoints := map[string]sharedDiscovery.EndpointOutput{
+		"POST:::api.com/orders":           discoveredEndpoint(3, map[int]int{201: 2, 400: 1}),
+		"GET:::api.com/orders/{id}/items": discoveredEndpoint(1, map[int]int{}),
+		"PROPFIND:::api.com/files":        discoveredEndpoint(1, map[int]int{207: 1}),
+	}
+	// users are each a distinct path, until templatized
+	for id := 1; id <= 5; id++ {
+		endpoints[fmt.Sprintf("GET:::api.com/users/%d", id)] =
+			discoveredEndpoint(2, map[int]int{200: 1, 404: 1})
+	}
+	return sharedDiscovery.Output{
+		CreatedAt:    "2024-01-02T00:00:00Z",
+		Interceptors: []sharedDiscovery.InterceptorOutput{},
+		Endpoints:    endpoints,
+		Consumers:    map[string]map[string]sharedDiscovery.EndpointOutput{},
+	}
+}
+
+var pathParameterPattern = regexp.MustCompile(`\{([^}/]+)\}`)
+
+// assertStructurallyValid asserts the document holds what OpenAPI 3 requires:
+// its version and info, paths starting with a slash, operations of valid
+// methods with at least one response, and the path parameters of each path
+func assertStructurallyValid(t *testing.T, content []byte) {
+	var document map[string]any
+	require.NoError(t, json.Unmarshal(content, &document))
+	assert.Regexp(t, `^3\.0\.\d+$`, document["openapi"])
+	info := document["info"].(map[string]any)
+	assert.NotEmpty(t, info["title"])
+	assert.NotEmpty(t, info["version"])
+
+	validMethods := map[string]bool{
+		"get": true, "put": true, "post": true, "delete": true,
+		"options": true, "head": true, "patch": true, "trace": true,
+	}
+	for path, item := range document["paths"].(map[string]any) {
+		assert.Regexp(t, `^/`, path)
+		expectedParameters := []string{}
+		for _, match := range pathParameterPattern.FindAllStringSubmatch(path, -1) {
+			expectedParameters = append(expectedParameters, match[1])
+		}
+		for method, value := range item.(map[string]any) {
+			if method == "servers" {
+				continue
+			}
+			assert.True(t, validMethods[method], method)
+			operation := value.(map[string]any)
+			assert.NotEmpty(t, operation["responses"], "%s %s", method, path)
+			parameters := []string{}
+			if declared, found := operation["parameters"]; found {
+				for _, parameter := range declared.([]any) {
+					parameter := parameter.(map[string]any)
+					assert.Equal(t, "path", parameter["in"])
+					assert.Equal(t, true, parameter["required"])
+					parameters = append(parameters, parameter["name"].(string))
+				}
+			}
+			assert.ElementsMatch(t, expectedParameters, parameters, "%s %s", method, path)
+		}
+	}
+}
+
+func TestDiscoveryOutputIsDraftedAsAnOpenAPIDocument(t *testing.T) {
+	t.Parallel()
+	document, err := syntheticDiscoveryOutput().ToOpenAPI(3)
+	require.NoError(t, err)
+
+	content, err := json.Marshal(&document)
+	require.NoError(t, err)
+	assertStructurallyValid(t, content)
+
+	assert.Equal(t, []sharedDiscovery.OpenAPIServer{{URL: "https://api.com"}},
+		document.Servers)
+	assert.ElementsMatch(t, []string{
+		"/orders", "/orders/{id}/items", "/users/{_param_1}",
+	}, keys(document.Paths))
+
+	// the users were templatized into a single operation
+	users := document.Paths["/users/{_param_1}"].Operations["get"]
+	assert.Equal(t, 10, users.ObservedCount)
+	assert.Equal(t, map[string]sharedDiscovery.OpenAPIResponse{
+		"200": {Description: "Observed 5 times"},
+		"404": {Description: "Observed 5 times"},
+	}, users.Responses)
+
+	orders := document.Paths["/orders"].Operations
+	assert.ElementsMatch(t, []string{"post"}, keys(orders))
+	assert.Equal(t, map[string]sharedDiscovery.OpenAPIResponse{
+		"201": {Description: "Observed 2 times"},
+		"400": {Description: "Observed 1 times"},
+	}, orders["post"].Responses)
+
+	// an operation with no status codes observed still has a response
+	assert.Contains(t,
+		document.Paths["/orders/{id}/items"].Operations["get"].Responses, "default")
+}
+
+func TestOpenAPIDocumentListsTheServersOfEachPath(t *testing.T) {
+	t.Parallel()
+	output := syntheticDiscoveryOutput()
+	output.Endpoints["GET:::billing.com/invoices"] =
+		discoveredEndpoint(1, map[int]int{200: 1})
+
+	document, err := output.ToOpenAPI(3)
+	require.NoError(t, err)
+	content, err := json.Marshal(&document)
+	require.NoError(t, err)
+	assertStructurallyValid(t, content)
+
+	assert.Equal(t, []sharedDiscovery.OpenAPIServer{
+		{URL: "https://api.com"}, {URL: "https://billing.com"},
+	}, document.Servers)
+	assert.Equal(t, []sharedDiscovery.OpenAPIServer{{URL: "https://billing.com"}},
+		document.Paths["/invoices"].Servers)
+}
+
+func TestOpenAPIDocumentFailsOnInvalidEndpoints(t *testing.T) {
+	t.Parallel()
+	output := syntheticDiscoveryOutput()
+	output.Endpoints["api.com/no-method"] = discoveredEndpoint(1, map[int]int{})
+
+	_, err := output.ToOpenAPI(3)
+	assert.Error(t, err)
+}
+
+func keys[V any](values map[string]V) []string {
+	result := []string{}
+	for key := range values {
+		result = append(result, key)
+	}
+	return result
+}
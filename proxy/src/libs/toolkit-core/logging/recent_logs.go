@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"lunar/toolkit-core/clock"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const maxRecentLogMessageLength = 1024
+
+// RecentLog is a log kept by RecentLogs
+type RecentLog struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+// RecentLogs keeps the latest warnings and errors logged, so they can be
+// reported (e.g. in a diagnostic bundle) without reading the log file.
+// Only the latest maxEntries are kept, each message truncated to 1KB,
+// so keeping them is bounded in memory.
+type RecentLogs struct {
+	clock      clock.Clock
+	maxEntries int
+
+	mutex   sync.Mutex
+	entries []RecentLog
+	next    int
+}
+
+// KeepRecentLogs hooks the global logger to keep its recent warnings and
+// errors, it should be called once the logger is configured
+func KeepRecentLogs(clock clock.Clock, maxEntries int) *RecentLogs {
+	recentLogs := NewRecentLogs(clock, maxEntries)
+	log.Logger = log.Logger.Hook(recentLogs)
+	return recentLogs
+}
+
+func NewRecentLogs(clock clock.Clock, maxEntries int) *RecentLogs {
+	return &RecentLogs{
+		clock:      clock,
+		maxEntries: maxEntries,
+		mutex:      sync.Mutex{},
+		entries:    []RecentLog{},
+		next:       0,
+	}
+}
+
+func (recentLogs *RecentLogs) Run(_ *zerolog.Event, level zerolog.Level, message string) {
+	if level < zerolog.WarnLevel || level == zerolog.NoLevel ||
+		recentLogs.maxEntries <= 0 {
+		return
+	}
+	if len(message) > maxRecentLogMessageLength {
+		message = message[:maxRecentLogMessageLength]
+	}
+	entry := RecentLog{
+		Time:    recentLogs.clock.Now(),
+		Level:   level.String(),
+		Message: message,
+	}
+
+	recentLogs.mutex.Lock()
+	defer recentLogs.mutex.Unlock()
+	if len(recentLogs.entries) < recentLogs.maxEntries {
+		recentLogs.entries = append(recentLogs.entries, entry)
+		return
+	}
+	recentLogs.entries[recentLogs.next] = entry
+	recentLogs.next = (recentLogs.next + 1) % recentLogs.maxEntries
+}
+
+// Entries returns the kept logs, the oldest first
+func (recentLogs *RecentLogs) Entries() []RecentLog {
+	recentLogs.mutex.Lock()
+	defer recentLogs.mutex.Unlock()
+	entries := make([]RecentLog, 0, len(recentLogs.entries))
+	entries = append(entries, recentLogs.entries[recentLogs.next:]...)
+	return append(entries, recentLogs.entries[:recentLogs.next]...)
+}
@@ -11,6 +11,24 @@ type MessageI interface {
 type DiscoveryMessage struct {
 	Event WebSocketMessageEvent `json:"event"`
 	Data  discovery.Output      `json:"data"`
+	// Whether the discovery state file was not updated for a while,
+	// so the state reported may be outdated
+	Stale bool `json:"stale,omitempty"`
+}
+
+// DiscoveryHeartbeatMessage is sent instead of a discovery report
+// while the discovery state is unchanged since it was last reported
+type DiscoveryHeartbeatMessage struct {
+	Event WebSocketMessageEvent  `json:"event"`
+	Data  DiscoveryHeartbeatData `json:"data"`
+}
+
+type DiscoveryHeartbeatData struct {
+	CreatedAt string `json:"created_at"`
+	// The hash of the discovery state last reported, which is still current
+	ContentHash string `json:"content_hash"`
+	// Whether the discovery state file was not updated for a while
+	Stale bool `json:"stale,omitempty"`
 }
 
 type ConfigurationMessage struct {
@@ -28,6 +46,128 @@ type ConfigurationPayload struct {
 	Content  []byte `json:"content"`
 }
 
+// ProxyStatusMessage reports the status the proxy started with,
+// along with the errors which caused it to fail or start degraded
+type ProxyStatusMessage struct {
+	Event WebSocketMessageEvent `json:"event"`
+	Data  ProxyStatusData       `json:"data"`
+}
+
+type ProxyStatusData struct {
+	Status    ProxyStatus        `json:"status"`
+	Errors    []ProxyStatusError `json:"errors"`
+	CreatedAt string             `json:"created_at"`
+}
+
+type ProxyStatusError struct {
+	Component string `json:"component"`
+	Message   string `json:"message"`
+	Fatal     bool   `json:"fatal"`
+}
+
+type ProxyStatus string
+
+const (
+	ProxyStatusHealthy  ProxyStatus = "healthy"
+	ProxyStatusDegraded ProxyStatus = "degraded"
+	ProxyStatusFailed   ProxyStatus = "failed"
+)
+
+// ConfigChangeMessage reports an attempt to reload the proxy's config,
+// whether it was applied or rejected (in which case the prior config is kept)
+type ConfigChangeMessage struct {
+	Event WebSocketMessageEvent `json:"event"`
+	Data  ConfigChangeData      `json:"data"`
+}
+
+type ConfigChangeData struct {
+	Applied             bool             `json:"applied"`
+	Error               string           `json:"error,omitempty"`
+	PriorConfigRetained bool             `json:"prior_config_retained"`
+	Remedies            ConfigChangeDiff `json:"remedies"`
+	CreatedAt           string           `json:"created_at"`
+}
+
+// ConfigChangeDiff lists the entries which were added, removed or modified,
+// up to a bound, with the number of entries left out of the lists
+type ConfigChangeDiff struct {
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Modified  []string `json:"modified"`
+	Truncated int      `json:"truncated"`
+}
+
+// MaintenanceModeMessage toggles the maintenance mode of the proxy, in which
+// requests to the given routes are served the given response, until cleared
+type MaintenanceModeMessage struct {
+	Event WebSocketMessageEvent `json:"event"`
+	Data  MaintenanceModeData   `json:"data"`
+}
+
+type MaintenanceModeData struct {
+	Active   bool                `json:"active"`
+	Routes   []MaintenanceRoute  `json:"routes"`
+	Response MaintenanceResponse `json:"response"`
+}
+
+// MaintenanceRoute is matched like the URL and method of endpoint policies,
+// a route with no method matching every method
+type MaintenanceRoute struct {
+	Method string `json:"method,omitempty"`
+	URL    string `json:"url"`
+}
+
+type MaintenanceResponse struct {
+	StatusCode int               `json:"status_code,omitempty"`
+	Body       string            `json:"body,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+}
+
+// FeatureFlagsMessage sets the feature flags flow processors read at
+// runtime, replacing all the flags previously set
+type FeatureFlagsMessage struct {
+	Event WebSocketMessageEvent `json:"event"`
+	Data  FeatureFlagsData      `json:"data"`
+}
+
+type FeatureFlagsData struct {
+	Flags map[string]interface{} `json:"flags"`
+}
+
+// DynamicQuotasMessage sets the quotas which override the allowed request
+// counts of remedies with a dynamic quota, by their keys, replacing all the
+// quotas previously set
+type DynamicQuotasMessage struct {
+	Event WebSocketMessageEvent `json:"event"`
+	Data  DynamicQuotasData     `json:"data"`
+}
+
+type DynamicQuotasData struct {
+	Quotas map[string]int64 `json:"quotas"`
+}
+
+// HubRejectionMessage is sent by Lunar Hub when it failed to accept
+// or throttled a message of the given event, so the proxy backs off
+// its reporting of that event
+type HubRejectionMessage struct {
+	Event WebSocketMessageEvent `json:"event"`
+	Data  HubRejectionData      `json:"data"`
+}
+
+type HubRejectionData struct {
+	RejectedEvent WebSocketMessageEvent `json:"rejected_event"`
+	Reason        HubRejectionReason    `json:"reason"`
+	// If set, the proxy waits at least this many seconds before reporting again
+	RetryAfterSeconds int `json:"retry_after_seconds,omitempty"`
+}
+
+type HubRejectionReason string
+
+const (
+	HubRejectionReasonError       HubRejectionReason = "error"
+	HubRejectionReasonRateLimited HubRejectionReason = "rate_limited"
+)
+
 type (
 	WebSocketConnectionEvent string
 	WebSocketMessageEvent    string
@@ -36,4 +176,12 @@ type (
 const (
 	WebSocketEventDiscovery         WebSocketMessageEvent = "discovery-event"
 	WebSocketEventConfigurationLoad WebSocketMessageEvent = "configuration-load-event"
+	WebSocketEventProxyStatus       WebSocketMessageEvent = "proxy-status-event"
+	WebSocketEventConfigChange      WebSocketMessageEvent = "config-change-event"
+	WebSocketEventMaintenanceMode   WebSocketMessageEvent = "maintenance-mode-event"
+	WebSocketEventHubRejection      WebSocketMessageEvent = "hub-rejection-event"
+	WebSocketEventFeatureFlags      WebSocketMessageEvent = "feature-flags-event"
+	WebSocketEventDynamicQuotas     WebSocketMessageEvent = "dynamic-quotas-event"
 )
+
+const WebSocketEventDiscoveryHeartbeat WebSocketMessageEvent = "discovery-heartbeat-event"
@@ -7,3 +7,23 @@ func (ym *ConfigurationMessage) GetEvent() WebSocketMessageEvent {
 func (dm *DiscoveryMessage) GetEvent() WebSocketMessageEvent {
 	return dm.Event
 }
+
+func (hm *DiscoveryHeartbeatMessage) GetEvent() WebSocketMessageEvent {
+	return hm.Event
+}
+
+func (sm *ProxyStatusMessage) GetEvent() WebSocketMessageEvent {
+	return sm.Event
+}
+
+func (cm *ConfigChangeMessage) GetEvent() WebSocketMessageEvent {
+	return cm.Event
+}
+
+func (mm *MaintenanceModeMessage) GetEvent() WebSocketMessageEvent {
+	return mm.Event
+}
+
+func (rm *HubRejectionMessage) GetEvent() WebSocketMessageEvent {
+	return rm.Event
+}
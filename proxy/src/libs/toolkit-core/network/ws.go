@@ -2,8 +2,10 @@ package network
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -14,60 +16,178 @@ const (
 	waitUntilRetry         = 2 * time.Second
 	connectionPingInterval = 1 * time.Second
 	connectionEstablished  = "ready"
+	// How long a message may take to be written, before its connection
+	// is considered failed
+	writeTimeout = 10 * time.Second
+
+	DefaultFailoverAfterFailures   = 3
+	DefaultReturnToPrimaryInterval = time.Minute
 )
 
+var errClientClosed = errors.New("the client is closed")
+
 type (
 	OnMessageFunc    func([]byte)
 	OnDisconnectFunc func()
+	OnReconnectFunc  func()
+
+	// FailoverPolicy is when a client of several URLs fails over from the
+	// active URL to the next one, and returns to the primary (the first) one
+	FailoverPolicy struct {
+		// The consecutive failures to reconnect after which to fail over
+		AfterFailures int
+		// How often to attempt to return to the primary URL once failed over
+		ReturnToPrimaryInterval time.Duration
+		// How long to wait before reconnecting once the connection failed
+		RetryInterval time.Duration
+	}
 
 	WSClient struct {
-		url                  string
+		urls                 []string
+		failover             FailoverPolicy
 		handshakeHeaders     http.Header
-		conn                 *websocket.Conn
 		sendChan             chan []byte
 		onMessageCallback    OnMessageFunc
 		onDisconnectCallback OnDisconnectFunc
+		onReconnectCallback  OnReconnectFunc
 		connReadySignal      chan struct{}
 		connReadyMutex       sync.Mutex
+		closed               atomic.Bool
+
+		// connMutex guards the connection, and is held while writing,
+		// so the connection is never switched mid-write
+		connMutex           sync.Mutex
+		conn                *websocket.Conn
+		consecutiveFailures int
+		// The index of the URL the connection is to, switched along with it
+		active         atomic.Int32
+		reconnectMutex sync.Mutex
+		// the last failed connection, guarded by reconnectMutex,
+		// so the OnDisconnect callback is called once per failure
+		disconnectedConn *websocket.Conn
 	}
 )
 
 func NewWSClient(url string, handshakeHeaders http.Header) *WSClient {
+	return NewFailoverWSClient([]string{url}, handshakeHeaders, FailoverPolicy{
+		AfterFailures:           DefaultFailoverAfterFailures,
+		ReturnToPrimaryInterval: DefaultReturnToPrimaryInterval,
+		RetryInterval:           waitUntilRetry,
+	})
+}
+
+// NewFailoverWSClient connects to the first of the URLs it can, primarily to
+// the first one, failing over to the next URL by the policy once the
+// connection to the active one persistently fails.
+// Messages are sent over whichever connection is active. A message failing to
+// be written is sent again once reconnected, and the connection is only
+// switched between messages, so the messages in flight while failing over are
+// sent once, over the new connection. As messages are not acknowledged,
+// one written before the failure of its connection was noticed may be lost,
+// so the OnReconnect callback is called once the connection was replaced.
+func NewFailoverWSClient(
+	urls []string,
+	handshakeHeaders http.Header,
+	failover FailoverPolicy,
+) *WSClient {
+	if failover.AfterFailures <= 0 {
+		failover.AfterFailures = DefaultFailoverAfterFailures
+	}
+	if failover.ReturnToPrimaryInterval <= 0 {
+		failover.ReturnToPrimaryInterval = DefaultReturnToPrimaryInterval
+	}
+	if failover.RetryInterval <= 0 {
+		failover.RetryInterval = waitUntilRetry
+	}
 	return &WSClient{ //nolint:exhaustruct
-		url:              url,
+		urls:             urls,
+		failover:         failover,
 		handshakeHeaders: handshakeHeaders,
 		sendChan:         make(chan []byte),
 	}
 }
 
+// ConnectAndStart connects to the first URL it can, in their order
 func (client *WSClient) ConnectAndStart() error {
-	err := client.Connect()
-	if err != nil {
-		return err
+	var err error
+	for range client.urls {
+		url := client.ActiveURL()
+		if err = client.Connect(); err == nil {
+			client.Start()
+			return nil
+		}
+		if client.ActiveURL() == url {
+			client.failOver()
+		}
 	}
-
-	client.Start()
-
-	return nil
+	return err
 }
 
 func (client *WSClient) OnMessage(callback OnMessageFunc) {
 	client.onMessageCallback = callback
 }
 
+// OnDisconnect sets the callback called once the connection failed,
+// before reconnecting
 func (client *WSClient) OnDisconnect(callback OnDisconnectFunc) {
 	client.onDisconnectCallback = callback
 }
 
+// OnReconnect sets the callback called whenever the connection was replaced,
+// either reconnected, failed over or returned to the primary URL
+func (client *WSClient) OnReconnect(callback OnReconnectFunc) {
+	client.onReconnectCallback = callback
+}
+
+// Connect connects to the active URL, failing over to the next one
+// once it failed to for as many times in a row as the policy allows
 func (client *WSClient) Connect() error {
 	client.setConnectionNotReady()
+	url := client.ActiveURL()
+	conn, err := client.dial(url)
+	if err != nil {
+		client.connMutex.Lock()
+		client.consecutiveFailures++
+		shouldFailOver := client.consecutiveFailures >= client.failover.AfterFailures
+		client.connMutex.Unlock()
+		if shouldFailOver {
+			client.failOver()
+		}
+		return err
+	}
+
+	client.connMutex.Lock()
+	previousConn := client.conn
+	client.conn = conn
+	client.consecutiveFailures = 0
+	client.connMutex.Unlock()
+	go client.startPing(conn)
+	if previousConn != nil {
+		client.notifyReconnected()
+	}
+	return nil
+}
+
+// notifyReconnected calls the OnReconnect callback apart from the read and
+// write loops, so it may send messages
+func (client *WSClient) notifyReconnected() {
+	if client.onReconnectCallback != nil {
+		go func() {
+			if !client.closed.Load() {
+				client.onReconnectCallback()
+			}
+		}()
+	}
+}
+
+func (client *WSClient) dial(url string) (*websocket.Conn, error) {
 	dialer := websocket.Dialer{ //nolint:exhaustruct
 		Subprotocols: []string{"token"},
 	}
 
-	conn, _, err := dialer.Dial(client.url, client.handshakeHeaders)
+	conn, _, err := dialer.Dial(url, client.handshakeHeaders)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	conn.SetPongHandler(func(string) error {
@@ -75,20 +195,40 @@ func (client *WSClient) Connect() error {
 		client.setConnectionReady()
 		return nil
 	})
+	return conn, nil
+}
 
-	go client.startPing()
-	client.conn = conn
-	return nil
+// failOver has the next URL be the active one
+func (client *WSClient) failOver() {
+	client.connMutex.Lock()
+	defer client.connMutex.Unlock()
+	client.consecutiveFailures = 0
+	if len(client.urls) <= 1 {
+		return
+	}
+	failedURL := client.urls[client.active.Load()]
+	client.active.Store((client.active.Load() + 1) % int32(len(client.urls)))
+	log.Warn().Str("failed-url", failedURL).
+		Str("active-url", client.urls[client.active.Load()]).
+		Msg("WSClient: failing over")
 }
 
 func (client *WSClient) Start() {
 	go client.readLoop()
 	go client.writeLoop()
+	if len(client.urls) > 1 {
+		go client.returnToPrimaryLoop()
+	}
 }
 
 func (client *WSClient) Close() error {
+	client.closed.Store(true)
 	close(client.sendChan)
-	return client.conn.Close()
+	conn := client.currentConn()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
 }
 
 func (client *WSClient) Send(msg MessageI) error {
@@ -101,13 +241,33 @@ func (client *WSClient) Send(msg MessageI) error {
 	return nil
 }
 
+// ActiveURL is the URL the client is connected, or connecting, to.
+// It does not wait for writes, so it may be observed while writing stalls.
+func (client *WSClient) ActiveURL() string {
+	return client.urls[client.active.Load()]
+}
+
+// URLs are the URLs the client may connect to, the primary one first
+func (client *WSClient) URLs() []string {
+	return client.urls
+}
+
+func (client *WSClient) currentConn() *websocket.Conn {
+	client.connMutex.Lock()
+	defer client.connMutex.Unlock()
+	return client.conn
+}
+
 func (client *WSClient) readLoop() {
-	for {
-		_, msg, err := client.conn.ReadMessage()
+	for !client.closed.Load() {
+		conn := client.currentConn()
+		_, msg, err := conn.ReadMessage()
 		if err != nil {
 			log.Debug().Err(err).Msg("WSClient: read error")
-			client.onConnectionError()
-
+			// A failed connection is not read from again, until replaced
+			for client.currentConn() == conn && !client.closed.Load() {
+				client.onConnectionError(conn)
+			}
 		} else if client.onMessageCallback != nil {
 			client.onMessageCallback(msg)
 		}
@@ -116,22 +276,55 @@ func (client *WSClient) readLoop() {
 
 func (client *WSClient) writeLoop() {
 	for msg := range client.sendChan {
-		// Wait until the connection is ready
-		if !client.IsConnectionReady() {
-			<-client.connReadySignal
-		}
-		log.Debug().Msg("WSClient::writeLoop Connection is ready")
-		log.Trace().Msgf("Sending message: %s", string(msg))
-		if err := client.conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+		// A message failing to be written is sent again once reconnected
+		for {
+			conn, err := client.write(msg)
+			if err == nil || errors.Is(err, errClientClosed) {
+				break
+			}
 			log.Debug().Err(err).Msg("WSClient: write error")
-			client.onConnectionError()
+			client.onConnectionError(conn)
 		}
 	}
 }
 
-func (client *WSClient) onConnectionError() {
+func (client *WSClient) write(msg []byte) (*websocket.Conn, error) {
+	// Wait until the connection is ready
+	client.connReadyMutex.Lock()
+	connReadySignal := client.connReadySignal
+	client.connReadyMutex.Unlock()
+	if connReadySignal != nil {
+		<-connReadySignal
+	}
+	if client.closed.Load() {
+		return nil, errClientClosed
+	}
+	log.Debug().Msg("WSClient::writeLoop Connection is ready")
+	log.Trace().Msgf("Sending message: %s", string(msg))
+	client.connMutex.Lock()
+	defer client.connMutex.Unlock()
+	if err := client.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return client.conn, err
+	}
+	return client.conn, client.conn.WriteMessage(websocket.BinaryMessage, msg)
+}
+
+// onConnectionError reconnects once the given connection failed,
+// unless it was already replaced since
+func (client *WSClient) onConnectionError(failedConn *websocket.Conn) {
+	client.reconnectMutex.Lock()
+	defer client.reconnectMutex.Unlock()
+	if client.closed.Load() || client.currentConn() != failedConn {
+		return
+	}
+	if client.disconnectedConn != failedConn {
+		client.disconnectedConn = failedConn
+		if client.onDisconnectCallback != nil {
+			client.onDisconnectCallback()
+		}
+	}
 	log.Trace().Msg("WSClient: Attempting to reconnect...")
-	time.Sleep(waitUntilRetry)
+	time.Sleep(client.failover.RetryInterval)
 	connErr := client.Connect()
 	if connErr != nil {
 		log.Error().Err(connErr).Msg("WSClient: write error")
@@ -140,6 +333,46 @@ func (client *WSClient) onConnectionError() {
 	}
 }
 
+// returnToPrimaryLoop periodically attempts to connect to the primary URL
+// once failed over, switching to it once connected
+func (client *WSClient) returnToPrimaryLoop() {
+	ticker := time.NewTicker(client.failover.ReturnToPrimaryInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if client.closed.Load() {
+			return
+		}
+		client.returnToPrimary()
+	}
+}
+
+func (client *WSClient) returnToPrimary() {
+	client.reconnectMutex.Lock()
+	defer client.reconnectMutex.Unlock()
+	if client.ActiveURL() == client.urls[0] {
+		return
+	}
+	conn, err := client.dial(client.urls[0])
+	if err != nil {
+		log.Debug().Err(err).Msg("WSClient: primary is still unavailable")
+		return
+	}
+
+	// The connection is switched between writes, as the mutex is held by them
+	client.connMutex.Lock()
+	previousConn := client.conn
+	client.conn = conn
+	client.active.Store(0)
+	client.consecutiveFailures = 0
+	client.connMutex.Unlock()
+	if previousConn != nil {
+		_ = previousConn.Close()
+	}
+	log.Info().Str("active-url", client.urls[0]).
+		Msg("WSClient: returned to primary")
+	client.notifyReconnected()
+}
+
 func (client *WSClient) setConnectionNotReady() {
 	log.Debug().Msg("WSClient::setConnectionNotReady")
 	client.connReadyMutex.Lock()
@@ -170,11 +403,11 @@ func (client *WSClient) IsConnectionReady() bool {
 	return client.connReadySignal == nil
 }
 
-func (client *WSClient) startPing() {
+func (client *WSClient) startPing(conn *websocket.Conn) {
 	// Note: This function will ping the server every second to keep the connection alive.
 	// Execute this function in a separate goroutine to avoid blocking the main thread.
 	for !client.IsConnectionReady() {
-		_ = client.conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(5*time.Second))
+		_ = conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(5*time.Second))
 		time.Sleep(connectionPingInterval) // Ping every second.
 	}
 }
@@ -0,0 +1,191 @@
+package network_test
+
+import (
+	"lunar/toolkit-core/network"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testMessage struct {
+	Event network.WebSocketMessageEvent `json:"event"`
+}
+
+func (message *testMessage) GetEvent() network.WebSocketMessageEvent {
+	return message.Event
+}
+
+// hubServer is a WebSocket server recording the messages it received,
+// which can be taken down and brought back up
+type hubServer struct {
+	server   *httptest.Server
+	up       atomic.Bool
+	mutex    sync.Mutex
+	conns    []*websocket.Conn
+	received []string
+}
+
+func newHubServer() *hubServer {
+	hub := &hubServer{} //nolint:exhaustruct
+	hub.up.Store(true)
+	upgrader := websocket.Upgrader{Subprotocols: []string{"token"}} //nolint:exhaustruct
+	hub.server = httptest.NewServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			if !hub.up.Load() {
+				writer.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			conn, err := upgrader.Upgrade(writer, request, nil)
+			if err != nil {
+				return
+			}
+			hub.mutex.Lock()
+			hub.conns = append(hub.conns, conn)
+			hub.mutex.Unlock()
+			for {
+				_, message, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				hub.mutex.Lock()
+				hub.received = append(hub.received, string(message))
+				hub.mutex.Unlock()
+			}
+		}))
+	return hub
+}
+
+func (hub *hubServer) url() string {
+	return "ws" + strings.TrimPrefix(hub.server.URL, "http")
+}
+
+func (hub *hubServer) takeDown() {
+	hub.up.Store(false)
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	for _, conn := range hub.conns {
+		_ = conn.Close()
+	}
+	hub.conns = nil
+}
+
+func (hub *hubServer) messages() []string {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	return append([]string{}, hub.received...)
+}
+
+func (hub *hubServer) hasReceived(messages ...string) func() bool {
+	return func() bool {
+		return assert.ObjectsAreEqual(messages, hub.messages())
+	}
+}
+
+func messageOf(event string) string {
+	return `{"event":"` + event + `"}`
+}
+
+func TestWSClientFailsOverAndReturnsToThePrimary(t *testing.T) {
+	primary := newHubServer()
+	defer primary.server.Close()
+	standby := newHubServer()
+	defer standby.server.Close()
+	client := network.NewFailoverWSClient(
+		[]string{primary.url(), standby.url()}, http.Header{},
+		network.FailoverPolicy{
+			AfterFailures:           1,
+			ReturnToPrimaryInterval: 50 * time.Millisecond,
+			RetryInterval:           10 * time.Millisecond,
+		})
+	require.NoError(t, client.ConnectAndStart())
+	defer client.Close()
+
+	require.NoError(t, client.Send(&testMessage{Event: "before"}))
+	require.Eventually(t, primary.hasReceived(messageOf("before")),
+		5*time.Second, 10*time.Millisecond)
+
+	// Messages sent while failing over are delivered once, over the standby
+	primary.takeDown()
+	require.Eventually(t, func() bool {
+		return !client.IsConnectionReady() || client.ActiveURL() == standby.url()
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, client.Send(&testMessage{Event: "during"}))
+	require.NoError(t, client.Send(&testMessage{Event: "after"}))
+	require.Eventually(t, standby.hasReceived(messageOf("during"), messageOf("after")),
+		5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, standby.url(), client.ActiveURL())
+
+	primary.up.Store(true)
+	require.Eventually(t, func() bool { return client.ActiveURL() == primary.url() },
+		5*time.Second, 10*time.Millisecond)
+	require.NoError(t, client.Send(&testMessage{Event: "returned"}))
+	require.Eventually(t, primary.hasReceived(messageOf("before"), messageOf("returned")),
+		5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{messageOf("during"), messageOf("after")},
+		standby.messages())
+}
+
+func TestWSClientConnectsToTheFirstAvailableURL(t *testing.T) {
+	primary := newHubServer()
+	defer primary.server.Close()
+	primary.up.Store(false)
+	standby := newHubServer()
+	defer standby.server.Close()
+	client := network.NewFailoverWSClient(
+		[]string{primary.url(), standby.url()}, http.Header{},
+		network.FailoverPolicy{ //nolint:exhaustruct
+			AfterFailures:           3,
+			ReturnToPrimaryInterval: time.Hour,
+		})
+	require.NoError(t, client.ConnectAndStart())
+	defer client.Close()
+
+	assert.Equal(t, standby.url(), client.ActiveURL())
+	require.NoError(t, client.Send(&testMessage{Event: "message"}))
+	require.Eventually(t, standby.hasReceived(messageOf("message")),
+		5*time.Second, 10*time.Millisecond)
+}
+
+func TestWSClientNotifiesWheneverTheConnectionFailedOrWasReplaced(t *testing.T) {
+	primary := newHubServer()
+	defer primary.server.Close()
+	standby := newHubServer()
+	defer standby.server.Close()
+	client := network.NewFailoverWSClient(
+		[]string{primary.url(), standby.url()}, http.Header{},
+		network.FailoverPolicy{
+			AfterFailures:           1,
+			ReturnToPrimaryInterval: 50 * time.Millisecond,
+			RetryInterval:           10 * time.Millisecond,
+		})
+	disconnected := atomic.Int32{}
+	client.OnDisconnect(func() { disconnected.Add(1) })
+	reconnected := atomic.Int32{}
+	client.OnReconnect(func() {
+		reconnected.Add(1)
+		// Messages may be sent from the callback, as it is called apart
+		_ = client.Send(&testMessage{Event: "reconnected"})
+	})
+	require.NoError(t, client.ConnectAndStart())
+	defer client.Close()
+	assert.Zero(t, reconnected.Load())
+
+	primary.takeDown()
+	require.Eventually(t, standby.hasReceived(messageOf("reconnected")),
+		5*time.Second, 10*time.Millisecond)
+
+	primary.up.Store(true)
+	require.Eventually(t, primary.hasReceived(messageOf("reconnected")),
+		5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), reconnected.Load())
+	// Returning to the primary replaces a connection which did not fail
+	assert.Equal(t, int32(1), disconnected.Load())
+}
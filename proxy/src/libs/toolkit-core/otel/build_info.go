@@ -0,0 +1,38 @@
+package otel
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const buildInfoMetricName = "lunar_proxy_build_info"
+
+// BuildInfo describes the running build of the proxy
+type BuildInfo struct {
+	Version   string
+	GitCommit string
+	BuildDate string
+}
+
+// RegisterBuildInfo registers the constant `lunar_proxy_build_info` gauge,
+// always 1, with the build as its attributes, so the versions run
+// across a fleet can be compared. Unknown build details are reported empty.
+func RegisterBuildInfo(meter metric.Meter, info BuildInfo) error {
+	attributes := metric.WithAttributes(
+		attribute.String("version", info.Version),
+		attribute.String("git_commit", info.GitCommit),
+		attribute.String("build_date", info.BuildDate),
+	)
+	_, err := meter.Int64ObservableGauge(
+		buildInfoMetricName,
+		metric.WithDescription("Build of the running proxy, always 1"),
+		metric.WithInt64Callback(
+			func(_ context.Context, observer metric.Int64Observer) error {
+				observer.Observe(1, attributes)
+				return nil
+			}),
+	)
+	return err
+}
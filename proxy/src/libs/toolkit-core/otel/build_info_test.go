@@ -0,0 +1,39 @@
+package otel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestRegisterBuildInfoReportsConstantGauge(t *testing.T) {
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+
+	require.NoError(t, RegisterBuildInfo(meter, BuildInfo{
+		Version:   "v1.2.3",
+		GitCommit: "abc123",
+		BuildDate: "2024-01-01T00:00:00Z",
+	}))
+
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+	require.Len(t, resourceMetrics.ScopeMetrics, 1)
+	require.Len(t, resourceMetrics.ScopeMetrics[0].Metrics, 1)
+	recordedMetric := resourceMetrics.ScopeMetrics[0].Metrics[0]
+	require.Equal(t, buildInfoMetricName, recordedMetric.Name)
+
+	gauge, ok := recordedMetric.Data.(metricdata.Gauge[int64])
+	require.True(t, ok)
+	require.Len(t, gauge.DataPoints, 1)
+	require.Equal(t, int64(1), gauge.DataPoints[0].Value)
+	require.Equal(t, attribute.NewSet(
+		attribute.String("version", "v1.2.3"),
+		attribute.String("git_commit", "abc123"),
+		attribute.String("build_date", "2024-01-01T00:00:00Z"),
+	), gauge.DataPoints[0].Attributes)
+}
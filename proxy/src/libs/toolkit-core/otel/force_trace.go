@@ -0,0 +1,132 @@
+package otel
+
+import (
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	forceTraceHeaderEnvVar         = "LUNAR_FORCE_TRACE_HEADER"
+	forceTraceTrustedSourcesEnvVar = "LUNAR_FORCE_TRACE_TRUSTED_SOURCES"
+	defaultForceTraceHeader        = "X-Lunar-Force-Trace"
+	// ForceTraceAttributeKey is set on spans started for requests which
+	// force tracing, so the sampler and the tail sampling processor keep them
+	ForceTraceAttributeKey = "lunar.force_trace"
+)
+
+// ForceTraceConfig configures the header which forces the trace of a request
+// to be sampled, regardless of the configured sampling.
+// The header is only honored for requests from the trusted sources,
+// so it cannot be abused to flood the trace backend.
+// With no trusted sources, the header is ignored.
+type ForceTraceConfig struct {
+	Header         string
+	TrustedSources []netip.Prefix
+}
+
+// ForceTraceConfigFromEnv loads the force trace config. Trusted sources are
+// a comma separated list of IP addresses and CIDR ranges.
+func ForceTraceConfigFromEnv() ForceTraceConfig {
+	config := ForceTraceConfig{
+		Header:         defaultForceTraceHeader,
+		TrustedSources: []netip.Prefix{},
+	}
+	if header := os.Getenv(forceTraceHeaderEnvVar); header != "" {
+		config.Header = header
+	}
+	for _, raw := range strings.Split(os.Getenv(forceTraceTrustedSourcesEnvVar), ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		source, err := parseTrustedSource(raw)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Invalid trusted source in %s: %s, ignoring it",
+				forceTraceTrustedSourcesEnvVar, raw)
+			continue
+		}
+		config.TrustedSources = append(config.TrustedSources, source)
+	}
+	return config
+}
+
+func parseTrustedSource(raw string) (netip.Prefix, error) {
+	if strings.Contains(raw, "/") {
+		prefix, err := netip.ParsePrefix(raw)
+		return prefix.Masked(), err
+	}
+	address, err := netip.ParseAddr(raw)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(address, address.BitLen()), nil
+}
+
+// IsForced returns whether a request from the given client IP with the given
+// headers forces its trace to be sampled
+func (config ForceTraceConfig) IsForced(
+	clientIP string,
+	headers map[string]string,
+) bool {
+	if len(config.TrustedSources) == 0 ||
+		!strings.EqualFold(HeadersCarrier(headers).Get(config.Header), "true") {
+		return false
+	}
+	address, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return false
+	}
+	address = address.Unmap()
+	for _, source := range config.TrustedSources {
+		if source.Contains(address) {
+			return true
+		}
+	}
+	log.Debug().Msgf("Ignoring %s header of untrusted source %s",
+		config.Header, clientIP)
+	return false
+}
+
+// ForceTraceSampler samples spans started with the force trace attribute,
+// deferring to its base sampler for the rest
+type ForceTraceSampler struct {
+	base sdktrace.Sampler
+}
+
+var _ sdktrace.Sampler = ForceTraceSampler{}
+
+func NewForceTraceSampler(base sdktrace.Sampler) ForceTraceSampler {
+	return ForceTraceSampler{base: base}
+}
+
+func (sampler ForceTraceSampler) ShouldSample(
+	parameters sdktrace.SamplingParameters,
+) sdktrace.SamplingResult {
+	if hasForceTraceAttribute(parameters.Attributes) {
+		return sdktrace.SamplingResult{
+			Decision:   sdktrace.RecordAndSample,
+			Attributes: nil,
+			Tracestate: trace.SpanContextFromContext(parameters.ParentContext).TraceState(),
+		}
+	}
+	return sampler.base.ShouldSample(parameters)
+}
+
+func (sampler ForceTraceSampler) Description() string {
+	return "ForceTraceSampler{" + sampler.base.Description() + "}"
+}
+
+func hasForceTraceAttribute(attributes []attribute.KeyValue) bool {
+	for _, attribute := range attributes {
+		if attribute.Key == ForceTraceAttributeKey {
+			return attribute.Value.AsBool()
+		}
+	}
+	return false
+}
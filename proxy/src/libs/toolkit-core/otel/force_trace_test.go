@@ -0,0 +1,83 @@
+package otel
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestForceTraceSamplerOverridesAlwaysOffSampler(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(NewForceTraceSampler(sdktrace.NeverSample())),
+		sdktrace.WithSpanProcessor(recorder),
+	).Tracer("test")
+
+	_, forced := tracer.Start(context.Background(), "forced",
+		trace.WithAttributes(attribute.Bool(ForceTraceAttributeKey, true)))
+	forced.End()
+	_, unforced := tracer.Start(context.Background(), "unforced")
+	unforced.End()
+
+	require.True(t, forced.SpanContext().IsSampled())
+	require.False(t, unforced.SpanContext().IsSampled())
+	require.Equal(t, []string{"forced"}, exportedSpanNames(recorder))
+}
+
+func TestTailSamplingExportsForcedSpans(t *testing.T) {
+	tracer, recorder := newTailSampledTracer(TailSamplingConfig{
+		LatencyThreshold: 0,
+		SuccessRatio:     0,
+	})
+
+	_, forced := tracer.Start(context.Background(), "forced",
+		trace.WithAttributes(attribute.Bool(ForceTraceAttributeKey, true)))
+	forced.End()
+	_, unforced := tracer.Start(context.Background(), "unforced")
+	unforced.End()
+
+	require.Equal(t, []string{"forced"}, exportedSpanNames(recorder))
+}
+
+func TestForceTraceIsOnlyHonoredForTrustedSources(t *testing.T) {
+	config := ForceTraceConfig{
+		Header: defaultForceTraceHeader,
+		TrustedSources: []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.0/8"),
+			netip.MustParsePrefix("192.168.1.7/32"),
+		},
+	}
+	forcing := map[string]string{"x-lunar-force-trace": "true"}
+
+	require.True(t, config.IsForced("10.1.2.3", forcing))
+	require.True(t, config.IsForced("192.168.1.7", forcing))
+	require.True(t, config.IsForced("::ffff:10.1.2.3", forcing))
+	require.False(t, config.IsForced("192.168.1.8", forcing))
+	require.False(t, config.IsForced("", forcing))
+	require.False(t, config.IsForced("10.1.2.3",
+		map[string]string{"X-Lunar-Force-Trace": "false"}))
+
+	// Without trusted sources, the header is ignored
+	require.False(t, ForceTraceConfig{
+		Header:         defaultForceTraceHeader,
+		TrustedSources: []netip.Prefix{},
+	}.IsForced("10.1.2.3", forcing))
+}
+
+func TestForceTraceConfigFromEnv(t *testing.T) {
+	t.Setenv(forceTraceHeaderEnvVar, "X-Debug-Trace")
+	t.Setenv(forceTraceTrustedSourcesEnvVar, "10.0.0.0/8, 127.0.0.1,invalid")
+	require.Equal(t, ForceTraceConfig{
+		Header: "X-Debug-Trace",
+		TrustedSources: []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.0/8"),
+			netip.MustParsePrefix("127.0.0.1/32"),
+		},
+	}, ForceTraceConfigFromEnv())
+}
@@ -56,8 +56,11 @@ func InitProvider(serviceName string) func() {
 		sdkMetric.WithReader(exporter),
 	)
 	setRealMeter(meterProvider.Meter(meterName))
+	if RuntimeMetricsEnabledFromEnv() {
+		handleErr(StartRuntimeMetrics(meterProvider), "Failed to start runtime metrics")
+	}
 
-	var tracerProvider sdktrace.TracerProvider
+	var tracerProvider *sdktrace.TracerProvider
 	otelAgentAddr, traceProviderEnabled := os.LookupEnv(
 		"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
 
@@ -70,11 +73,18 @@ func InitProvider(serviceName string) func() {
 		traceExporter, err := otlptrace.New(ctx, traceClient)
 		handleErr(err, "Failed to create the collector trace exporter")
 
+		// Local root spans are all recorded, so the tail sampling processor
+		// can decide which to export once their status and latency are known.
+		// Spans of requests forcing tracing are recorded even if their
+		// propagated context was not sampled.
 		bsp := sdktrace.NewBatchSpanProcessor(traceExporter)
-		tracerProvider := sdktrace.NewTracerProvider(
-			sdktrace.WithSampler(sdktrace.AlwaysSample()),
+		tracerProvider = sdktrace.NewTracerProvider(
+			sdktrace.WithSampler(NewForceTraceSampler(
+				sdktrace.ParentBased(sdktrace.AlwaysSample()))),
 			sdktrace.WithResource(resource),
-			sdktrace.WithSpanProcessor(bsp),
+			sdktrace.WithSpanProcessor(
+				NewTailSamplingProcessor(contextmanager.Get().GetClock(), bsp,
+					TailSamplingConfigFromEnv())),
 		)
 
 		// set global propagator to trace context (the default is no-op).
@@ -106,8 +116,10 @@ func handleErr(err error, message string) {
 	}
 }
 
-func Tracer(ctx context.Context, spanName string) (
-	context.Context, trace.Span,
-) {
-	return otel.Tracer("lunar-engine").Start(ctx, spanName)
+func Tracer(
+	ctx context.Context,
+	spanName string,
+	opts ...trace.SpanStartOption,
+) (context.Context, trace.Span) {
+	return otel.Tracer("lunar-engine").Start(ctx, spanName, opts...)
 }
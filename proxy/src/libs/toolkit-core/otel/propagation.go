@@ -0,0 +1,58 @@
+package otel
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// HeadersCarrier adapts a plain headers map to `propagation.TextMapCarrier`.
+// Header names are matched case-insensitively, since the headers
+// we receive from HAProxy are canonicalized (e.g. `Traceparent`).
+type HeadersCarrier map[string]string
+
+var _ propagation.TextMapCarrier = HeadersCarrier{}
+
+func (carrier HeadersCarrier) Get(key string) string {
+	if value, found := carrier[key]; found {
+		return value
+	}
+	for name, value := range carrier {
+		if strings.EqualFold(name, key) {
+			return value
+		}
+	}
+	return ""
+}
+
+func (carrier HeadersCarrier) Set(key string, value string) {
+	carrier[key] = value
+}
+
+func (carrier HeadersCarrier) Keys() []string {
+	keys := make([]string, 0, len(carrier))
+	for key := range carrier {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// ExtractContext returns a copy of ctx carrying the trace context and baggage
+// found in the given headers. If tracing is disabled, ctx is returned as is.
+func ExtractContext(
+	ctx context.Context,
+	headers map[string]string,
+) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, HeadersCarrier(headers))
+}
+
+// InjectContext returns the headers (`traceparent`, `tracestate`, `baggage`)
+// which propagate the trace context and baggage held by ctx.
+// If tracing is disabled, an empty map is returned.
+func InjectContext(ctx context.Context) map[string]string {
+	headers := HeadersCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, headers)
+	return headers
+}
@@ -0,0 +1,89 @@
+package otel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	incomingTraceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	incomingParentID    = "00f067aa0ba902b7"
+	incomingTraceparent = "00-" + incomingTraceID + "-" + incomingParentID + "-01"
+)
+
+func withTracingEnabled(t *testing.T) {
+	previousPropagator := otel.GetTextMapPropagator()
+	previousProvider := otel.GetTracerProvider()
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(sdktrace.AlwaysSample())))
+	t.Cleanup(func() {
+		otel.SetTextMapPropagator(previousPropagator)
+		otel.SetTracerProvider(previousProvider)
+	})
+}
+
+func TestHeadersCarrierGetIsCaseInsensitive(t *testing.T) {
+	carrier := HeadersCarrier{"Traceparent": incomingTraceparent}
+	require.Equal(t, incomingTraceparent, carrier.Get("traceparent"))
+	require.Equal(t, "", carrier.Get("tracestate"))
+}
+
+func TestInjectContextIsNoOpWhenTracingIsDisabled(t *testing.T) {
+	ctx := ExtractContext(
+		context.Background(),
+		map[string]string{"Traceparent": incomingTraceparent},
+	)
+	ctx, span := Tracer(ctx, "upstream", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	require.Empty(t, InjectContext(ctx))
+}
+
+func TestInjectContextContinuesIncomingTrace(t *testing.T) {
+	withTracingEnabled(t)
+
+	ctx := ExtractContext(
+		context.Background(),
+		map[string]string{
+			"Traceparent": incomingTraceparent,
+			"Baggage":     "tenant=acme",
+		},
+	)
+	ctx, span := Tracer(ctx, "upstream", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	headers := InjectContext(ctx)
+	spanContext := trace.SpanContextFromContext(
+		ExtractContext(context.Background(), headers),
+	)
+	require.Equal(t, incomingTraceID, spanContext.TraceID().String())
+	require.NotEqual(t, incomingParentID, spanContext.SpanID().String())
+	require.Equal(t, span.SpanContext().SpanID(), spanContext.SpanID())
+	require.Equal(t, "tenant=acme", headers["baggage"])
+}
+
+func TestInjectContextStartsNewTraceWithoutIncomingContext(t *testing.T) {
+	withTracingEnabled(t)
+
+	ctx, span := Tracer(
+		context.Background(),
+		"upstream",
+		trace.WithSpanKind(trace.SpanKindClient),
+	)
+	defer span.End()
+
+	headers := InjectContext(ctx)
+	require.Contains(t, headers, "traceparent")
+	require.Equal(t, span.SpanContext().TraceID(),
+		trace.SpanContextFromContext(
+			ExtractContext(context.Background(), headers),
+		).TraceID())
+}
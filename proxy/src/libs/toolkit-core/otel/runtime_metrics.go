@@ -0,0 +1,31 @@
+package otel
+
+import (
+	"os"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/runtime"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	runtimeMetricsEnvVar = "LUNAR_RUNTIME_METRICS_ENABLED"
+	// runtimeMemStatsInterval bounds how often the memory statistics are read,
+	// as reading them briefly stops the world
+	runtimeMemStatsInterval = 15 * time.Second
+)
+
+// RuntimeMetricsEnabledFromEnv returns whether the Go runtime metrics
+// (goroutines, GC, heap) are reported, by default they are not
+func RuntimeMetricsEnabledFromEnv() bool {
+	return os.Getenv(runtimeMetricsEnvVar) == "true"
+}
+
+// StartRuntimeMetrics reports the Go runtime metrics on meters of the given
+// provider, so they are exported along with the rest of the proxy metrics
+func StartRuntimeMetrics(meterProvider metric.MeterProvider) error {
+	return runtime.Start(
+		runtime.WithMeterProvider(meterProvider),
+		runtime.WithMinimumReadMemStatsInterval(runtimeMemStatsInterval),
+	)
+}
@@ -0,0 +1,37 @@
+package otel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestStartRuntimeMetricsReportsOnTheGivenProvider(t *testing.T) {
+	reader := sdkMetric.NewManualReader()
+	meterProvider := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader))
+
+	require.NoError(t, StartRuntimeMetrics(meterProvider))
+
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+	names := map[string]bool{}
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, recordedMetric := range scopeMetrics.Metrics {
+			names[recordedMetric.Name] = true
+		}
+	}
+	require.True(t, names["process.runtime.go.goroutines"])
+	require.True(t, names["process.runtime.go.gc.count"])
+	require.True(t, names["process.runtime.go.mem.heap_alloc"])
+}
+
+func TestRuntimeMetricsAreDisabledByDefault(t *testing.T) {
+	t.Setenv(runtimeMetricsEnvVar, "")
+	require.False(t, RuntimeMetricsEnabledFromEnv())
+
+	t.Setenv(runtimeMetricsEnvVar, "true")
+	require.True(t, RuntimeMetricsEnabledFromEnv())
+}
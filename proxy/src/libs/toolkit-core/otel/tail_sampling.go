@@ -0,0 +1,195 @@
+package otel
+
+import (
+	"context"
+	"encoding/binary"
+	"lunar/toolkit-core/clock"
+	"lunar/toolkit-core/vacuum"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracesLatencyThresholdEnvVar = "LUNAR_TRACES_LATENCY_THRESHOLD_MS"
+	tracesSuccessRatioEnvVar     = "LUNAR_TRACES_SUCCESS_SAMPLING_RATIO"
+	statusCodeAttributeKey       = "http.status_code"
+	minErrorStatusCode           = 400
+	retainedSpansVacuumName      = "tail-sampling-retained-spans"
+	// spans still running this long after they started are no longer
+	// retained, and are sampled like the rest once they end
+	retainedSpansTTL        = 5 * time.Minute
+	retainedSpansVacuumTick = 10 * time.Second
+	maxRetainedRunningSpans = 100_000
+)
+
+// TailSamplingConfig configures which ended spans are exported.
+// Spans of error responses and spans lasting at least LatencyThreshold
+// are always exported, while the fast, successful rest is exported at
+// SuccessRatio. A zero LatencyThreshold disables latency based retention.
+type TailSamplingConfig struct {
+	LatencyThreshold time.Duration
+	SuccessRatio     float64
+}
+
+// TailSamplingConfigFromEnv loads the tail sampling config,
+// defaulting to exporting all spans
+func TailSamplingConfigFromEnv() TailSamplingConfig {
+	config := TailSamplingConfig{LatencyThreshold: 0, SuccessRatio: 1}
+	if raw, found := os.LookupEnv(tracesLatencyThresholdEnvVar); found {
+		milliseconds, err := strconv.Atoi(raw)
+		if err != nil || milliseconds < 0 {
+			log.Warn().Msgf("Invalid %s: %s, latency based retention is disabled",
+				tracesLatencyThresholdEnvVar, raw)
+		} else {
+			config.LatencyThreshold = time.Duration(milliseconds) * time.Millisecond
+		}
+	}
+	if raw, found := os.LookupEnv(tracesSuccessRatioEnvVar); found {
+		ratio, err := strconv.ParseFloat(raw, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			log.Warn().Msgf("Invalid %s: %s, all successful spans are exported",
+				tracesSuccessRatioEnvVar, raw)
+		} else {
+			config.SuccessRatio = ratio
+		}
+	}
+	return config
+}
+
+// TailSamplingProcessor decides whether to export each span once it ends,
+// as its status and latency are unknown when it starts.
+// It is meant to be used along with a parent based sampler recording all
+// local root spans: spans continuing an unsampled propagated context are
+// not recorded at all, while spans continuing a sampled one are always
+// exported, since the decision was already made upstream.
+// Spans of requests which force tracing are always exported as well.
+// Fast, successful spans are sub-sampled by their trace ID,
+// so such spans of the same trace are either all exported or all dropped.
+type TailSamplingProcessor struct {
+	next             sdktrace.SpanProcessor
+	latencyThreshold time.Duration
+	successBound     uint64
+	// running spans continuing a sampled propagated context, which are
+	// always exported. At most maxRetainedRunningSpans are retained at once,
+	// each for up to retainedSpansTTL.
+	retainedMutex  *sync.RWMutex
+	retained       map[trace.SpanID]struct{}
+	retainedVacuum *vacuum.MapVacuum[trace.SpanID, struct{}]
+}
+
+var _ sdktrace.SpanProcessor = (*TailSamplingProcessor)(nil)
+
+// NewTailSamplingProcessor builds a processor passing the spans
+// it samples to the next processor
+func NewTailSamplingProcessor(
+	clock clock.Clock,
+	next sdktrace.SpanProcessor,
+	config TailSamplingConfig,
+) *TailSamplingProcessor {
+	retained := map[trace.SpanID]struct{}{}
+	retainedMutex := sync.RWMutex{}
+	retainedVacuum := vacuum.NewMapVacuum(
+		retainedSpansVacuumName,
+		clock,
+		retainedSpansTTL,
+		retainedSpansVacuumTick,
+		retained,
+		&retainedMutex,
+	)
+	return &TailSamplingProcessor{
+		next:             next,
+		latencyThreshold: config.LatencyThreshold,
+		successBound:     ratioBound(config.SuccessRatio),
+		retainedMutex:    &retainedMutex,
+		retained:         retained,
+		retainedVacuum:   &retainedVacuum,
+	}
+}
+
+// ratioBound is compared with the trace ID the same way
+// sdktrace.TraceIDRatioBased does, so sampling decisions agree with it
+func ratioBound(ratio float64) uint64 {
+	if ratio >= 1 {
+		return 1 << 63
+	}
+	if ratio <= 0 {
+		return 0
+	}
+	return uint64(ratio * (1 << 63))
+}
+
+func (processor *TailSamplingProcessor) OnStart(
+	parent context.Context,
+	span sdktrace.ReadWriteSpan,
+) {
+	parentSpanContext := span.Parent()
+	processor.retainedMutex.Lock()
+	_, parentRetained := processor.retained[parentSpanContext.SpanID()]
+	retain := parentRetained ||
+		(parentSpanContext.IsRemote() && parentSpanContext.IsSampled())
+	if retain && len(processor.retained) >= maxRetainedRunningSpans {
+		log.Trace().Msgf("Too many running spans are retained, "+
+			"span %s is sampled once it ends", span.SpanContext().SpanID())
+		retain = false
+	}
+	if retain {
+		processor.retained[span.SpanContext().SpanID()] = struct{}{}
+	}
+	processor.retainedMutex.Unlock()
+	if retain {
+		processor.retainedVacuum.VacuumKey(span.SpanContext().SpanID())
+	}
+	processor.next.OnStart(parent, span)
+}
+
+func (processor *TailSamplingProcessor) OnEnd(span sdktrace.ReadOnlySpan) {
+	processor.retainedMutex.Lock()
+	_, retained := processor.retained[span.SpanContext().SpanID()]
+	delete(processor.retained, span.SpanContext().SpanID())
+	processor.retainedMutex.Unlock()
+
+	if retained || processor.shouldExport(span) {
+		processor.next.OnEnd(span)
+	}
+}
+
+func (processor *TailSamplingProcessor) shouldExport(
+	span sdktrace.ReadOnlySpan,
+) bool {
+	if isErrorSpan(span) || hasForceTraceAttribute(span.Attributes()) {
+		return true
+	}
+	if processor.latencyThreshold > 0 &&
+		span.EndTime().Sub(span.StartTime()) >= processor.latencyThreshold {
+		return true
+	}
+	traceID := span.SpanContext().TraceID()
+	return binary.BigEndian.Uint64(traceID[8:16])>>1 < processor.successBound
+}
+
+func isErrorSpan(span sdktrace.ReadOnlySpan) bool {
+	if span.Status().Code == codes.Error {
+		return true
+	}
+	for _, attribute := range span.Attributes() {
+		if attribute.Key == statusCodeAttributeKey {
+			return attribute.Value.AsInt64() >= minErrorStatusCode
+		}
+	}
+	return false
+}
+
+func (processor *TailSamplingProcessor) Shutdown(ctx context.Context) error {
+	return processor.next.Shutdown(ctx)
+}
+
+func (processor *TailSamplingProcessor) ForceFlush(ctx context.Context) error {
+	return processor.next.ForceFlush(ctx)
+}
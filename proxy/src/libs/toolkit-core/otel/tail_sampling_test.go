@@ -0,0 +1,165 @@
+package otel
+
+import (
+	"context"
+	"lunar/toolkit-core/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTailSampledTracer(
+	config TailSamplingConfig,
+) (trace.Tracer, *tracetest.SpanRecorder) {
+	tracer, recorder, _ := newTailSampledTracerWithProcessor(
+		clock.NewMockClock(), config)
+	return tracer, recorder
+}
+
+func newTailSampledTracerWithProcessor(
+	clock clock.Clock,
+	config TailSamplingConfig,
+) (trace.Tracer, *tracetest.SpanRecorder, *TailSamplingProcessor) {
+	recorder := tracetest.NewSpanRecorder()
+	processor := NewTailSamplingProcessor(clock, recorder, config)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+		sdktrace.WithSpanProcessor(processor),
+	)
+	return provider.Tracer("test"), recorder, processor
+}
+
+func remoteSpanContext(flags trace.TraceFlags) context.Context {
+	traceID, _ := trace.TraceIDFromHex(incomingTraceID)
+	spanID, _ := trace.SpanIDFromHex(incomingParentID)
+	return trace.ContextWithRemoteSpanContext(context.Background(),
+		trace.NewSpanContext(trace.SpanContextConfig{ //nolint:exhaustruct
+			TraceID:    traceID,
+			SpanID:     spanID,
+			TraceFlags: flags,
+			Remote:     true,
+		}))
+}
+
+func exportedSpanNames(recorder *tracetest.SpanRecorder) []string {
+	names := []string{}
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+	}
+	return names
+}
+
+func TestTailSamplingExportsErrorAndSlowSpans(t *testing.T) {
+	tracer, recorder := newTailSampledTracer(TailSamplingConfig{
+		LatencyThreshold: time.Second,
+		SuccessRatio:     0,
+	})
+	start := time.Unix(1000, 0)
+	endSpan := func(name string, duration time.Duration, opts ...func(trace.Span)) {
+		_, span := tracer.Start(context.Background(), name, trace.WithTimestamp(start))
+		for _, opt := range opts {
+			opt(span)
+		}
+		span.End(trace.WithTimestamp(start.Add(duration)))
+	}
+
+	endSpan("fast", time.Millisecond)
+	endSpan("slow", 2*time.Second)
+	endSpan("failed", time.Millisecond, func(span trace.Span) {
+		span.SetStatus(codes.Error, "failed")
+	})
+	endSpan("throttled", time.Millisecond, func(span trace.Span) {
+		span.SetAttributes(attribute.Int(statusCodeAttributeKey, 429))
+	})
+	endSpan("succeeded", time.Millisecond, func(span trace.Span) {
+		span.SetAttributes(attribute.Int(statusCodeAttributeKey, 200))
+	})
+
+	require.Equal(t, []string{"slow", "failed", "throttled"},
+		exportedSpanNames(recorder))
+}
+
+func TestTailSamplingSubSamplesSuccessfulSpansByTraceID(t *testing.T) {
+	tracer, recorder := newTailSampledTracer(TailSamplingConfig{
+		LatencyThreshold: 0,
+		SuccessRatio:     0.5,
+	})
+	ratioSampler := sdktrace.TraceIDRatioBased(0.5)
+
+	wantExported := 0
+	for i := 0; i < 200; i++ {
+		ctx, root := tracer.Start(context.Background(), "root")
+		_, child := tracer.Start(ctx, "child")
+		child.End()
+		root.End()
+
+		result := ratioSampler.ShouldSample(sdktrace.SamplingParameters{ //nolint:exhaustruct
+			TraceID: root.SpanContext().TraceID(),
+		})
+		if result.Decision == sdktrace.RecordAndSample {
+			wantExported += 2
+		}
+	}
+
+	// A trace is exported as a whole, agreeing with the ratio sampler
+	require.Len(t, recorder.Ended(), wantExported)
+	require.Greater(t, wantExported, 0)
+	require.Less(t, wantExported, 400)
+}
+
+func TestTailSamplingComposesWithPropagatedContexts(t *testing.T) {
+	tracer, recorder := newTailSampledTracer(TailSamplingConfig{
+		LatencyThreshold: 0,
+		SuccessRatio:     0,
+	})
+	ctx, sampled := tracer.Start(remoteSpanContext(trace.FlagsSampled),
+		"sampled upstream")
+	_, child := tracer.Start(ctx, "child of sampled upstream")
+	child.End()
+	sampled.End()
+	_, unsampled := tracer.Start(remoteSpanContext(0), "unsampled upstream")
+	unsampled.End()
+
+	require.Equal(t, []string{"child of sampled upstream", "sampled upstream"},
+		exportedSpanNames(recorder))
+}
+
+func TestTailSamplingStopsRetainingSpansRunningPastTheTTL(t *testing.T) {
+	clock := clock.NewMockClock()
+	tracer, recorder, processor := newTailSampledTracerWithProcessor(clock,
+		TailSamplingConfig{LatencyThreshold: 0, SuccessRatio: 0})
+	retainedCount := func() int {
+		processor.retainedMutex.RLock()
+		defer processor.retainedMutex.RUnlock()
+		return len(processor.retained)
+	}
+
+	_, span := tracer.Start(remoteSpanContext(trace.FlagsSampled), "never ending")
+	require.Equal(t, 1, retainedCount())
+	clock.AdvanceTime(retainedSpansTTL)
+	require.Eventually(t, func() bool {
+		clock.AdvanceTime(retainedSpansVacuumTick)
+		return retainedCount() == 0
+	}, time.Second, time.Millisecond)
+
+	span.End()
+	require.Empty(t, exportedSpanNames(recorder))
+}
+
+func TestTailSamplingConfigFromEnv(t *testing.T) {
+	t.Setenv(tracesLatencyThresholdEnvVar, "250")
+	t.Setenv(tracesSuccessRatioEnvVar, "0.1")
+	require.Equal(t, TailSamplingConfig{
+		LatencyThreshold: 250 * time.Millisecond,
+		SuccessRatio:     0.1,
+	}, TailSamplingConfigFromEnv())
+
+	t.Setenv(tracesSuccessRatioEnvVar, "2")
+	require.Equal(t, float64(1), TailSamplingConfigFromEnv().SuccessRatio)
+}
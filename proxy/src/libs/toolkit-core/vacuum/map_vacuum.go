@@ -23,6 +23,7 @@ type MapVacuum[K comparable, V any] struct {
 	mapToVacuum  map[K]V
 	mapMutex     *sync.RWMutex
 	active       bool
+	onVacuum     func(key K, value V)
 }
 
 func NewMapVacuum[K comparable, V any](
@@ -46,6 +47,13 @@ func NewMapVacuum[K comparable, V any](
 	}
 }
 
+// OnVacuum sets a function to be called with each entry vacuumed from the map,
+// while the map's mutex is held. Keys deleted from the map before their TTL
+// passed are not passed to it.
+func (mapVacuum *MapVacuum[K, V]) OnVacuum(onVacuum func(key K, value V)) {
+	mapVacuum.onVacuum = onVacuum
+}
+
 func (mapVacuum *MapVacuum[K, V]) VacuumKey(keyToVacuum K) {
 	entry := mapVacuumEntry[K, V]{
 		vacuumAt:    mapVacuum.clock.Now().Add(mapVacuum.ttl),
@@ -88,6 +96,10 @@ func (mapVacuum *MapVacuum[K, V]) vacuum() {
 	mapVacuum.mapMutex.Lock()
 	for _, entry := range mapVacuum.entries {
 		if entry.vacuumAt.Before(now) {
+			value, found := mapVacuum.mapToVacuum[entry.keyToVacuum]
+			if found && mapVacuum.onVacuum != nil {
+				mapVacuum.onVacuum(entry.keyToVacuum, value)
+			}
 			delete(mapVacuum.mapToVacuum, entry.keyToVacuum)
 			deleteUntil++
 		} else {
@@ -113,3 +113,39 @@ func TestMapVacuumDoesNothingIfVacuumedKeyIsDeletedBeforeVacuumRuns(
 	assert.Empty(t, mapToVacuum)
 	mapMutex.RUnlock()
 }
+
+func TestMapVacuumCallsOnVacuumOnlyForVacuumedEntries(t *testing.T) {
+	clock := clock.NewMockClock()
+	ttl := 5 * time.Second
+	tick := 1 * time.Second
+	mapToVacuum := map[string]string{}
+	mapMutex := sync.RWMutex{}
+	mapVacuum := vacuum.NewMapVacuum(
+		"test",
+		clock,
+		ttl,
+		tick,
+		mapToVacuum,
+		&mapMutex,
+	)
+	vacuumed := map[string]string{}
+	mapVacuum.OnVacuum(func(key string, value string) {
+		vacuumed[key] = value
+	})
+
+	mapToVacuum["hello"] = "world"
+	mapToVacuum["deleted"] = "early"
+	mapVacuum.VacuumKey("hello")
+	mapVacuum.VacuumKey("deleted")
+	mapMutex.Lock()
+	delete(mapToVacuum, "deleted")
+	mapMutex.Unlock()
+	for i := 0; i < 6; i++ {
+		clock.AdvanceTime(tick + AcceptableDelta)
+	}
+
+	mapMutex.RLock()
+	assert.Empty(t, mapToVacuum)
+	assert.Equal(t, map[string]string{"hello": "world"}, vacuumed)
+	mapMutex.RUnlock()
+}
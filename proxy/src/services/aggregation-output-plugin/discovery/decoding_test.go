@@ -97,6 +97,10 @@ func TestDecodeRecordWithAllRemedyTypes(
 			testDecodeRecordWithRemedyType(t, remedyType)
 		case sharedConfig.RemedyAuth:
 			testDecodeRecordWithRemedyType(t, remedyType)
+		case sharedConfig.RemedyResponseBodyRewrite:
+			testDecodeRecordWithRemedyType(t, remedyType)
+		case sharedConfig.RemedyQueryParamMasking:
+			testDecodeRecordWithRemedyType(t, remedyType)
 		case sharedConfig.RemedyUndefined:
 			continue
 		}
@@ -12,6 +12,8 @@ import (
 // 2. Actions which modify the request have the second highest priority,
 //    if there are multiple actions which modify the request, they will be
 //    merged, with the later one taking precedence in the case of a conflict.
+//    Actions which generate the request are merged with the actions which
+//    modify it, regardless of their order, so the request is still generated.
 // 3. Actions which do nothing have the lowest priority.
 
 func (action *NoOpAction) ReqPrioritize(
@@ -49,19 +51,30 @@ func (action *ModifyRequestAction) ReqPrioritize(
 		prioritizedAction = action
 
 	case sharedActions.ReqModifiedRequest:
+		otherAction := other.(*ModifyRequestAction)
 		mergedHeaders := utils.MergeHeaders(
-			action.HeadersToSet, other.(*ModifyRequestAction).HeadersToSet)
+			action.HeadersToSet, otherAction.HeadersToSet)
 
-		prioritizedAction = &ModifyRequestAction{HeadersToSet: mergedHeaders}
+		upstream := action.Upstream
+		if otherAction.Upstream != nil {
+			upstream = otherAction.Upstream
+		}
+		prioritizedAction = &ModifyRequestAction{
+			HeadersToSet: mergedHeaders,
+			Upstream:     upstream,
+			QueryToSet:   mergeQueryToSet(action.QueryToSet, otherAction.QueryToSet),
+		}
 
 	case sharedActions.ReqGenerateRequest:
+		otherAction := other.(*GenerateRequestAction)
 		mergedHeaders := utils.MergeHeaders(
-			action.HeadersToSet, other.(*GenerateRequestAction).HeadersToSet)
+			action.HeadersToSet, otherAction.HeadersToSet)
 
 		prioritizedAction = &GenerateRequestAction{
 			HeadersToSet:    mergedHeaders,
-			HeadersToRemove: other.(*GenerateRequestAction).HeadersToRemove,
-			Body:            other.(*GenerateRequestAction).Body,
+			HeadersToRemove: otherAction.HeadersToRemove,
+			Body:            otherAction.Body,
+			QueryToSet:      mergeQueryToSet(action.QueryToSet, otherAction.QueryToSet),
 		}
 	}
 
@@ -81,30 +94,47 @@ func (action *GenerateRequestAction) ReqPrioritize(
 		prioritizedAction = action
 
 	case sharedActions.ReqModifiedRequest:
+		// The request is still generated, as it is when the modification
+		// comes first, with the modification applied to it
+		otherAction := other.(*ModifyRequestAction)
 		mergedHeaders := utils.MergeHeaders(
-			action.HeadersToSet, other.(*ModifyRequestAction).HeadersToSet)
+			action.HeadersToSet, otherAction.HeadersToSet)
 
-		prioritizedAction = &ModifyRequestAction{
-			HeadersToSet: mergedHeaders,
+		prioritizedAction = &GenerateRequestAction{
+			HeadersToSet:    mergedHeaders,
+			HeadersToRemove: action.HeadersToRemove,
+			Body:            action.Body,
+			QueryToSet:      mergeQueryToSet(action.QueryToSet, otherAction.QueryToSet),
 		}
 
 	case sharedActions.ReqGenerateRequest:
+		otherAction := other.(*GenerateRequestAction)
 		mergedHeaders := utils.MergeHeaders(
-			action.HeadersToSet, other.(*GenerateRequestAction).HeadersToSet)
+			action.HeadersToSet, otherAction.HeadersToSet)
 
 		headersToRemove := append(action.HeadersToRemove,
-			other.(*GenerateRequestAction).HeadersToRemove...)
+			otherAction.HeadersToRemove...)
 
 		prioritizedAction = &GenerateRequestAction{
 			HeadersToSet:    mergedHeaders,
 			HeadersToRemove: headersToRemove,
-			Body:            other.(*GenerateRequestAction).Body,
+			Body:            otherAction.Body,
+			QueryToSet:      mergeQueryToSet(action.QueryToSet, otherAction.QueryToSet),
 		}
 	}
 
 	return prioritizedAction
 }
 
+// mergeQueryToSet keeps the query string set by the earlier action,
+// unless the later one sets it too
+func mergeQueryToSet(queryToSet *string, otherQueryToSet *string) *string {
+	if otherQueryToSet != nil {
+		return otherQueryToSet
+	}
+	return queryToSet
+}
+
 func (action *EarlyResponseAction) ReqPrioritize(
 	_ ReqLunarAction,
 ) ReqLunarAction {
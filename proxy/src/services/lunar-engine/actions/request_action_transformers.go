@@ -2,6 +2,7 @@ package actions
 
 import (
 	"lunar/engine/messages"
+	publictypes "lunar/engine/streams/public-types"
 	"lunar/engine/utils"
 	sharedActions "lunar/shared-model/actions"
 	"strings"
@@ -18,10 +19,27 @@ const (
 	GenerateRequestActionName = "generate_request"
 	RequestHeadersActionName  = "request_headers"
 	RequestBodyActionName     = "request_body"
+	UpstreamSchemeActionName  = "upstream_scheme"
+	UpstreamHostActionName    = "upstream_host"
+	UpstreamPortActionName    = "upstream_port"
+	RequestPathActionName     = "request_path"
+	RequestQueryActionName    = "request_query"
 
 	RequestRunResultName = "request_run_result"
 )
 
+// NewDeadlineExceededAction responds to a request whose deadline passed
+// before it could be sent upstream
+func NewDeadlineExceededAction(deadline messages.RequestDeadline) *EarlyResponseAction {
+	return &EarlyResponseAction{
+		Status: deadline.ExceededStatusCode,
+		Body:   "The request timed out",
+		Headers: map[string]string{
+			"Content-Type": "text/plain",
+		},
+	}
+}
+
 // EarlyResponseAction
 func (action *EarlyResponseAction) ReqToSpoeActions() []spoe.Action {
 	actions := []spoe.Action{
@@ -71,9 +89,48 @@ func (action *ModifyRequestAction) ReqToSpoeActions() []spoe.Action {
 			Value: utils.DumpHeaders(action.HeadersToSet),
 		},
 	}
+	if action.Upstream != nil {
+		actions = append(actions, upstreamToSpoeActions(action.Upstream)...)
+	}
+	if action.QueryToSet != nil {
+		actions = append(actions, queryToSpoeAction(*action.QueryToSet))
+	}
 	return actions
 }
 
+func queryToSpoeAction(query string) spoe.Action {
+	return spoe.ActionSetVar{
+		Name:  RequestQueryActionName,
+		Scope: spoe.VarScopeRequest,
+		Value: query,
+	}
+}
+
+func upstreamToSpoeActions(upstream *publictypes.UpstreamTarget) []spoe.Action {
+	return []spoe.Action{
+		spoe.ActionSetVar{
+			Name:  UpstreamSchemeActionName,
+			Scope: spoe.VarScopeRequest,
+			Value: upstream.Scheme,
+		},
+		spoe.ActionSetVar{
+			Name:  UpstreamHostActionName,
+			Scope: spoe.VarScopeRequest,
+			Value: upstream.Host,
+		},
+		spoe.ActionSetVar{
+			Name:  UpstreamPortActionName,
+			Scope: spoe.VarScopeRequest,
+			Value: upstream.Port,
+		},
+		spoe.ActionSetVar{
+			Name:  RequestPathActionName,
+			Scope: spoe.VarScopeRequest,
+			Value: upstream.Path,
+		},
+	}
+}
+
 func (action *ModifyRequestAction) ReqRunResult() sharedActions.RemedyReqRunResult {
 	return sharedActions.ReqModifiedRequest
 }
@@ -82,7 +139,15 @@ func (action *ModifyRequestAction) EnsureRequestIsUpdated(
 	onRequest *messages.OnRequest,
 ) {
 	for name, value := range action.HeadersToSet {
-		onRequest.Headers[name] = value
+		onRequest.SetHeader(name, value)
+	}
+	if action.Upstream != nil {
+		onRequest.Scheme = action.Upstream.Scheme
+		onRequest.Path = action.Upstream.Path
+		onRequest.URL = action.Upstream.Host + action.Upstream.Path
+	}
+	if action.QueryToSet != nil {
+		onRequest.Query = *action.QueryToSet
 	}
 }
 
@@ -104,6 +169,9 @@ func (action *GenerateRequestAction) ReqToSpoeActions() []spoe.Action {
 			Value: []byte(action.Body),
 		},
 	}
+	if action.QueryToSet != nil {
+		actions = append(actions, queryToSpoeAction(*action.QueryToSet))
+	}
 	return actions
 }
 
@@ -120,10 +188,15 @@ func (action *GenerateRequestAction) EnsureRequestIsUpdated(
 	}
 
 	for name, value := range action.HeadersToSet {
-		onRequest.Headers[strings.ToLower(name)] = value
+		onRequest.SetHeader(strings.ToLower(name), value)
 	}
 
 	for _, value := range action.HeadersToRemove {
 		delete(onRequest.Headers, value)
+		delete(onRequest.HeaderValues, strings.ToLower(value))
+	}
+
+	if action.QueryToSet != nil {
+		onRequest.Query = *action.QueryToSet
 	}
 }
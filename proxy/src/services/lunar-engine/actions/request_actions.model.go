@@ -2,6 +2,7 @@ package actions
 
 import (
 	"lunar/engine/messages"
+	publictypes "lunar/engine/streams/public-types"
 	sharedActions "lunar/shared-model/actions"
 
 	spoe "github.com/TheLunarCompany/haproxy-spoe-go"
@@ -32,10 +33,18 @@ type EarlyResponseAction struct {
 // actual API provider
 type ModifyRequestAction struct {
 	HeadersToSet map[string]string
+	// If set, the request will be directed to this upstream instead
+	Upstream *publictypes.UpstreamTarget
+	// If set, the query string of the request is replaced by it
+	// (an empty one removes the query string)
+	QueryToSet *string
 }
 
 type GenerateRequestAction struct {
 	HeadersToSet    map[string]string
 	HeadersToRemove []string
 	Body            string
+	// If set, the query string of the generated request
+	// (an empty one sends it without a query string)
+	QueryToSet *string
 }
@@ -25,10 +25,18 @@ func (action *ModifyResponseAction) RespPrioritize(
 		prioritizedAction = action
 
 	case sharedActions.RespModifiedResponse:
+		otherModify := other.(*ModifyResponseAction)
 		mergedHeaders := utils.MergeHeaders(
-			action.HeadersToSet, other.(*ModifyResponseAction).HeadersToSet)
-
-		prioritizedAction = &ModifyResponseAction{HeadersToSet: mergedHeaders}
+			action.HeadersToSet, otherModify.HeadersToSet)
+
+		body := action.Body
+		if otherModify.Body != nil {
+			body = otherModify.Body
+		}
+		prioritizedAction = &ModifyResponseAction{
+			HeadersToSet: mergedHeaders,
+			Body:         body,
+		}
 	}
 
 	return prioritizedAction
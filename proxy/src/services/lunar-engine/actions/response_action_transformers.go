@@ -25,6 +25,13 @@ func (action *ModifyResponseAction) RespToSpoeActions() []spoe.Action {
 		},
 	}
 
+	if action.Body != nil {
+		actions = append(actions, spoe.ActionSetVar{
+			Name:  ResponseBodyActionName,
+			Scope: spoe.VarScopeResponse,
+			Value: []byte(*action.Body),
+		})
+	}
 	return actions
 }
 
@@ -38,4 +45,7 @@ func (action *ModifyResponseAction) EnsureResponseIsUpdated(
 	for name, value := range action.HeadersToSet {
 		onResponse.Headers[name] = value
 	}
+	if action.Body != nil {
+		onResponse.Body = *action.Body
+	}
 }
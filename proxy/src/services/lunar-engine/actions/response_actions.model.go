@@ -24,4 +24,6 @@ type RespLunarAction interface {
 // it is returned to the user
 type ModifyResponseAction struct {
 	HeadersToSet map[string]string
+	// If set, the response body will be replaced with it
+	Body *string
 }
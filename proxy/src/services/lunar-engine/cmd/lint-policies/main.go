@@ -0,0 +1,38 @@
+// lint-policies validates a policies configuration file offline,
+// without a running proxy. It exits with a non-zero code if any
+// error was found, so it can be used as a pre-deploy CI step.
+//
+// Usage: lint-policies <path-to-policies.yaml>
+package main
+
+import (
+	"fmt"
+	"lunar/engine/config"
+	"os"
+)
+
+const (
+	exitCodeLintErrors = 1
+	exitCodeUsage      = 2
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s <policies-file>\n", os.Args[0])
+		os.Exit(exitCodeUsage)
+	}
+
+	issues, err := config.Lint(os.Args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "💔 %v\n", err)
+		os.Exit(exitCodeUsage)
+	}
+
+	for _, issue := range issues {
+		fmt.Println(issue.String())
+	}
+	if config.HasLintErrors(issues) {
+		os.Exit(exitCodeLintErrors)
+	}
+	fmt.Printf("✅ %s is valid\n", os.Args[1])
+}
@@ -1,10 +1,13 @@
 package communication
 
-import "lunar/toolkit-core/network"
+import (
+	"encoding/json"
+	"lunar/toolkit-core/network"
+)
 
 type EventType = string
 
 type WebSocketMessage struct {
 	Event network.WebSocketConnectionEvent `json:"event"`
-	Data  interface{}                      `json:"data"`
+	Data  json.RawMessage                  `json:"data"`
 }
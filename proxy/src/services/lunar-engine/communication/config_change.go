@@ -0,0 +1,21 @@
+package communication
+
+import (
+	"lunar/toolkit-core/network"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ReportConfigChange sends a config change to Lunar Hub in the background,
+// so reloading the config is not blocked by an unreachable hub
+func (hub *HubCommunication) ReportConfigChange(change network.ConfigChangeData) {
+	if hub == nil {
+		log.Trace().Msg("Hub communication is down, config change is not reported")
+		return
+	}
+	message := network.ConfigChangeMessage{
+		Event: network.WebSocketEventConfigChange,
+		Data:  change,
+	}
+	go hub.SendDataToHub(&message)
+}
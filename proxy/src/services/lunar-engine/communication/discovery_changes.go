@@ -0,0 +1,61 @@
+package communication
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	sharedDiscovery "lunar/shared-model/discovery"
+	"strconv"
+	"sync"
+)
+
+// DiscoveryChanges tells whether the discovery state changed since it was
+// last reported to Lunar Hub, by a hash of its content, so an idle proxy
+// sends a heartbeat rather than the same report every interval.
+// Nothing was reported before the first report, so it is always sent,
+// and a report which failed or was rejected is forgotten to be sent again.
+type DiscoveryChanges struct {
+	mutex        sync.Mutex
+	reportedHash string
+}
+
+func NewDiscoveryChanges() *DiscoveryChanges {
+	return &DiscoveryChanges{
+		mutex:        sync.Mutex{},
+		reportedHash: "",
+	}
+}
+
+// HashDiscoveryState hashes the content of the discovery state,
+// leaving out when it was created, as it differs between reports
+func HashDiscoveryState(output sharedDiscovery.Output) (string, error) {
+	output.CreatedAt = ""
+	// maps are encoded by their sorted keys, so equal states hash the same
+	content, err := json.Marshal(output)
+	if err != nil {
+		return "", err
+	}
+	hash := fnv.New64a()
+	_, _ = hash.Write(content)
+	return strconv.FormatUint(hash.Sum64(), 16), nil
+}
+
+// IsUnchanged is whether the state of the given hash is the one last reported
+func (changes *DiscoveryChanges) IsUnchanged(hash string) bool {
+	changes.mutex.Lock()
+	defer changes.mutex.Unlock()
+	return changes.reportedHash != "" && changes.reportedHash == hash
+}
+
+// Reported records the state of the given hash was reported
+func (changes *DiscoveryChanges) Reported(hash string) {
+	changes.mutex.Lock()
+	defer changes.mutex.Unlock()
+	changes.reportedHash = hash
+}
+
+// Forget has the next state be reported even if unchanged
+func (changes *DiscoveryChanges) Forget() {
+	changes.mutex.Lock()
+	defer changes.mutex.Unlock()
+	changes.reportedHash = ""
+}
@@ -0,0 +1,49 @@
+package communication_test
+
+import (
+	"lunar/engine/communication"
+	"lunar/toolkit-core/network"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func reportDiscovery(
+	changes *communication.DiscoveryChanges,
+	endpoints int,
+	createdAt string,
+) network.WebSocketMessageEvent {
+	output := syntheticDiscoveryState(endpoints)
+	output.CreatedAt = createdAt
+	message, hash := communication.DiscoveryReport(changes, output, false)
+	changes.Reported(hash)
+	return message.GetEvent()
+}
+
+func TestDiscoveryReportIsSkippedWhileTheStateIsUnchanged(t *testing.T) {
+	changes := communication.NewDiscoveryChanges()
+
+	// The first report is always sent
+	require.Equal(t, network.WebSocketEventDiscovery,
+		reportDiscovery(changes, 3, "2023-01-01T00:00:00Z"))
+	// Reports of the same state, created later, are but heartbeats
+	require.Equal(t, network.WebSocketEventDiscoveryHeartbeat,
+		reportDiscovery(changes, 3, "2023-01-01T00:05:00Z"))
+	require.Equal(t, network.WebSocketEventDiscoveryHeartbeat,
+		reportDiscovery(changes, 3, "2023-01-01T00:10:00Z"))
+	// Once the state changed, it is reported again
+	require.Equal(t, network.WebSocketEventDiscovery,
+		reportDiscovery(changes, 4, "2023-01-01T00:15:00Z"))
+}
+
+func TestDiscoveryReportIsSentAgainOnceForgotten(t *testing.T) {
+	changes := communication.NewDiscoveryChanges()
+	require.Equal(t, network.WebSocketEventDiscovery,
+		reportDiscovery(changes, 3, "2023-01-01T00:00:00Z"))
+
+	// As when the hub rejected the report
+	changes.Forget()
+	assert.Equal(t, network.WebSocketEventDiscovery,
+		reportDiscovery(changes, 3, "2023-01-01T00:05:00Z"))
+}
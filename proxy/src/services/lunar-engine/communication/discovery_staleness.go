@@ -0,0 +1,142 @@
+package communication
+
+import (
+	"context"
+	"lunar/engine/utils/environment"
+	"lunar/toolkit-core/clock"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	// the discovery state file is stale once it was not updated
+	// for as many report intervals
+	defaultDiscoveryStaleAfterIntervals = 3.0
+
+	discoveryStaleMetricName = "lunar_hub.discovery_stale"
+)
+
+// DiscoveryStaleness tells whether the discovery state file went stale,
+// as it was not modified for longer than the threshold, which happens once
+// the process writing it stopped. The file of an idle proxy is not modified
+// either, so it is only stale if transactions were seen since it was.
+// The state is checked on every report, so a file updated again clears it
+// on the next one.
+type DiscoveryStaleness struct {
+	clock     clock.Clock
+	threshold time.Duration
+	// when the last transaction was seen, in Unix nanoseconds, 0 until one was
+	lastTransaction atomic.Int64
+
+	mutex sync.Mutex
+	stale bool
+}
+
+func NewDiscoveryStaleness(clock clock.Clock, threshold time.Duration) *DiscoveryStaleness {
+	return &DiscoveryStaleness{ //nolint:exhaustruct
+		clock:     clock,
+		threshold: threshold,
+		mutex:     sync.Mutex{},
+		stale:     false,
+	}
+}
+
+// ObserveTransaction records a transaction was seen, which the discovery
+// state file is expected to be modified by
+func (staleness *DiscoveryStaleness) ObserveTransaction() {
+	staleness.lastTransaction.Store(staleness.clock.Now().UnixNano())
+}
+
+// newDiscoveryStalenessFromEnv reads the threshold relative to the report
+// interval, by default the file is stale once 3 intervals passed
+func newDiscoveryStalenessFromEnv(
+	clock clock.Clock,
+	reportInterval time.Duration,
+) *DiscoveryStaleness {
+	intervals, err := environment.GetDiscoveryStaleAfterIntervals()
+	if err != nil || intervals <= 0 {
+		intervals = defaultDiscoveryStaleAfterIntervals
+	}
+	return NewDiscoveryStaleness(clock,
+		time.Duration(intervals*float64(reportInterval)))
+}
+
+// Check updates the state by the modification time of the file, and returns
+// whether it is stale. Warns once the file went stale, until it is updated.
+// A file which cannot be checked leaves the state as it was.
+func (staleness *DiscoveryStaleness) Check(fileLocation string) bool {
+	fileInfo, err := os.Stat(fileLocation)
+	staleness.mutex.Lock()
+	defer staleness.mutex.Unlock()
+	if err != nil {
+		log.Debug().Err(err).Msg("HubCommunication::DiscoveryWorker Could not " +
+			"check when the discovery state file was modified")
+		return staleness.stale
+	}
+
+	age := staleness.clock.Now().Sub(fileInfo.ModTime())
+	lastTransaction := staleness.lastTransaction.Load()
+	trafficSinceModified := lastTransaction != 0 &&
+		time.Unix(0, lastTransaction).After(fileInfo.ModTime())
+	stale := age > staleness.threshold && trafficSinceModified
+	if stale && !staleness.stale {
+		log.Warn().
+			Str("file", fileLocation).
+			Str("last-modified", fileInfo.ModTime().String()).
+			Msgf("HubCommunication::DiscoveryWorker Discovery state file was not "+
+				"updated for %v, discovery may have stalled", age.Round(time.Second))
+	} else if !stale && staleness.stale {
+		log.Info().Str("file", fileLocation).
+			Msg("HubCommunication::DiscoveryWorker Discovery state file is updated again")
+	}
+	staleness.stale = stale
+	return stale
+}
+
+// IsStale is whether the file was stale as of its last check
+func (staleness *DiscoveryStaleness) IsStale() bool {
+	staleness.mutex.Lock()
+	defer staleness.mutex.Unlock()
+	return staleness.stale
+}
+
+// ObserveTransaction records a transaction was seen by the engine, so the
+// discovery state file is only stale while there is traffic to discover
+func (hub *HubCommunication) ObserveTransaction() {
+	if hub == nil {
+		return
+	}
+	hub.discoveryStaleness.ObserveTransaction()
+}
+
+// ObserveDiscoveryStaleness reports whether the discovery state file is
+// stale, observing 1 while it is and 0 otherwise
+func (hub *HubCommunication) ObserveDiscoveryStaleness(meter metric.Meter) {
+	if hub == nil {
+		return
+	}
+	_, err := meter.Int64ObservableGauge(
+		discoveryStaleMetricName,
+		metric.WithDescription("Whether the discovery state file was not "+
+			"updated for longer than the staleness threshold, 1 while it was not"),
+		metric.WithUnit("{file}"),
+		metric.WithInt64Callback(
+			func(_ context.Context, observer metric.Int64Observer) error {
+				stale := int64(0)
+				if hub.discoveryStaleness.IsStale() {
+					stale = 1
+				}
+				observer.Observe(stale)
+				return nil
+			}),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to initialize %s metric",
+			discoveryStaleMetricName)
+	}
+}
@@ -0,0 +1,73 @@
+package communication_test
+
+import (
+	"lunar/engine/communication"
+	"lunar/toolkit-core/clock"
+	"lunar/toolkit-core/network"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscoveryStalenessIsClearedOnceTheFileIsUpdated(t *testing.T) {
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(100000, 0))
+	fileLocation := filepath.Join(t.TempDir(), "discovery.json")
+	require.NoError(t, os.WriteFile(fileLocation, []byte("{}"), 0o600))
+	staleness := communication.NewDiscoveryStaleness(clock, 15*time.Minute)
+
+	require.NoError(t, os.Chtimes(fileLocation, clock.Now(), clock.Now().Add(-10*time.Minute)))
+	require.False(t, staleness.Check(fileLocation))
+
+	// The process writing the file stopped 20 minutes ago, while traffic
+	// kept being seen
+	require.NoError(t, os.Chtimes(fileLocation, clock.Now(), clock.Now().Add(-20*time.Minute)))
+	staleness.ObserveTransaction()
+	require.True(t, staleness.Check(fileLocation))
+	require.True(t, staleness.IsStale())
+
+	// A missing file leaves the state as it was
+	require.True(t, staleness.Check(fileLocation+".missing"))
+
+	require.NoError(t, os.Chtimes(fileLocation, clock.Now(), clock.Now()))
+	assert.False(t, staleness.Check(fileLocation))
+	assert.False(t, staleness.IsStale())
+}
+
+func TestDiscoveryStateFileOfAnIdleProxyIsNotStale(t *testing.T) {
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(100000, 0))
+	fileLocation := filepath.Join(t.TempDir(), "discovery.json")
+	require.NoError(t, os.WriteFile(fileLocation, []byte("{}"), 0o600))
+	staleness := communication.NewDiscoveryStaleness(clock, 15*time.Minute)
+
+	// No transaction was seen at all
+	require.NoError(t, os.Chtimes(fileLocation, clock.Now(), clock.Now().Add(-20*time.Minute)))
+	require.False(t, staleness.Check(fileLocation))
+
+	// Nor since the file was last modified
+	clock.Set(time.Unix(100000, 0).Add(-30 * time.Minute))
+	staleness.ObserveTransaction()
+	clock.Set(time.Unix(100000, 0))
+	require.False(t, staleness.Check(fileLocation))
+
+	clock.AdvanceTime(time.Minute)
+	staleness.ObserveTransaction()
+	assert.True(t, staleness.Check(fileLocation))
+}
+
+func TestDiscoveryReportIsAnnotatedAsStale(t *testing.T) {
+	changes := communication.NewDiscoveryChanges()
+	output := syntheticDiscoveryState(3)
+
+	message, hash := communication.DiscoveryReport(changes, output, true)
+	require.True(t, message.(*network.DiscoveryMessage).Stale)
+
+	changes.Reported(hash)
+	message, _ = communication.DiscoveryReport(changes, output, true)
+	assert.True(t, message.(*network.DiscoveryHeartbeatMessage).Data.Stale)
+}
@@ -0,0 +1,114 @@
+package communication
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	sharedDiscovery "lunar/shared-model/discovery"
+	"os"
+)
+
+// ReadDiscoveryState reads the whole discovery state file into memory
+// before decoding it
+func ReadDiscoveryState(path string) (sharedDiscovery.Output, error) {
+	output := sharedDiscovery.Output{} //nolint:exhaustruct
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return output, err
+	}
+	err = json.Unmarshal(data, &output)
+	return output, err
+}
+
+// StreamDiscoveryState decodes the discovery state file as it is read,
+// one endpoint at a time, so the file is never held in memory as a whole.
+// It makes more, smaller allocations than ReadDiscoveryState (see the
+// benchmarks), so it only pays off once the file itself is large enough
+// to matter next to the decoded state.
+// Partial or corrupt files fail to decode, as with ReadDiscoveryState.
+func StreamDiscoveryState(path string) (sharedDiscovery.Output, error) {
+	output := sharedDiscovery.Output{} //nolint:exhaustruct
+	file, err := os.Open(path)
+	if err != nil {
+		return output, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	if err := expectDelim(decoder, '{'); err != nil {
+		return output, err
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return output, err
+		}
+		switch token {
+		case "endpoints":
+			output.Endpoints, err = decodeMapEntries[sharedDiscovery.EndpointOutput](
+				decoder)
+		case "consumers":
+			output.Consumers, err = decodeMapEntries[map[string]sharedDiscovery.EndpointOutput](
+				decoder)
+		case "created_at":
+			err = decoder.Decode(&output.CreatedAt)
+		case "interceptors":
+			err = decoder.Decode(&output.Interceptors)
+		default:
+			err = decoder.Decode(&json.RawMessage{})
+		}
+		if err != nil {
+			return output, err
+		}
+	}
+	if err := expectDelim(decoder, '}'); err != nil {
+		return output, err
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return output, errors.New("unexpected data after the discovery state")
+	}
+	return output, nil
+}
+
+// decodeMapEntries decodes the JSON object the decoder is at
+// entry by entry, rather than as a whole
+func decodeMapEntries[V any](decoder *json.Decoder) (map[string]V, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, nil
+	}
+	if token != json.Delim('{') {
+		return nil, fmt.Errorf("expected an object, found %v", token)
+	}
+	entries := map[string]V{}
+	// Decoded into the same variable, so only its content is allocated
+	var value V
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		value = *new(V)
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		entries[key.(string)] = value
+	}
+	return entries, expectDelim(decoder, '}')
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected '%v', found %v", delim, token)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package communication_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"lunar/engine/communication"
+	sharedDiscovery "lunar/shared-model/discovery"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeDiscoveryState(t testing.TB, content string) string {
+	path := filepath.Join(t.TempDir(), "discovery.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func syntheticDiscoveryState(endpoints int) sharedDiscovery.Output {
+	output := sharedDiscovery.Output{
+		CreatedAt: "2023-01-01T00:00:00Z",
+		Interceptors: []sharedDiscovery.InterceptorOutput{{
+			Type:                "lunar-interceptor",
+			Version:             "1.0.0",
+			LastTransactionDate: "2023-01-01T00:00:00Z",
+		}},
+		Endpoints: map[string]sharedDiscovery.EndpointOutput{},
+		Consumers: map[string]map[string]sharedDiscovery.EndpointOutput{
+			"consumer": {},
+		},
+	}
+	for i := 0; i < endpoints; i++ {
+		endpoint := sharedDiscovery.EndpointOutput{
+			MinTime:         "2023-01-01T00:00:00Z",
+			MaxTime:         "2023-01-01T01:00:00Z",
+			Count:           i,
+			StatusCodes:     map[int]int{200: i, 429: 1},
+			AverageDuration: 12.5,
+		}
+		key := fmt.Sprintf("GET:::api.com/resource/%d", i)
+		output.Endpoints[key] = endpoint
+		output.Consumers["consumer"][key] = endpoint
+	}
+	return output
+}
+
+func writeSyntheticDiscoveryState(t testing.TB, endpoints int) string {
+	data, err := json.Marshal(syntheticDiscoveryState(endpoints))
+	require.NoError(t, err)
+	return writeDiscoveryState(t, string(data))
+}
+
+func TestStreamDiscoveryStateDecodesLikeReadDiscoveryState(t *testing.T) {
+	path := writeSyntheticDiscoveryState(t, 100)
+
+	read, err := communication.ReadDiscoveryState(path)
+	require.NoError(t, err)
+	streamed, err := communication.StreamDiscoveryState(path)
+	require.NoError(t, err)
+	assert.Equal(t, syntheticDiscoveryState(100), streamed)
+	assert.Equal(t, read, streamed)
+}
+
+func TestStreamDiscoveryStateSkipsUnknownFieldsAndNullMaps(t *testing.T) {
+	path := writeDiscoveryState(t,
+		`{"version": {"major": 1}, "endpoints": null, "consumers": {}}`)
+
+	read, err := communication.ReadDiscoveryState(path)
+	require.NoError(t, err)
+	streamed, err := communication.StreamDiscoveryState(path)
+	require.NoError(t, err)
+	assert.Equal(t, read, streamed)
+}
+
+func TestStreamDiscoveryStateFailsOnPartialOrCorruptFiles(t *testing.T) {
+	data, err := json.Marshal(syntheticDiscoveryState(10))
+	require.NoError(t, err)
+	for name, content := range map[string]string{
+		"empty":         "",
+		"partial":       string(data[:len(data)/2]),
+		"corrupt":       `{"endpoints": {"GET:::api.com": {"count": "many"}}}`,
+		"not an object": `[]`,
+		"trailing data": string(data) + `{}`,
+	} {
+		path := writeDiscoveryState(t, content)
+		_, err := communication.ReadDiscoveryState(path)
+		assert.Error(t, err, name)
+		_, err = communication.StreamDiscoveryState(path)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestStreamDiscoveryStateFailsOnMissingFile(t *testing.T) {
+	_, err := communication.StreamDiscoveryState(
+		filepath.Join(t.TempDir(), "missing.json"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func benchmarkDiscoveryState(
+	b *testing.B,
+	read func(string) (sharedDiscovery.Output, error),
+) {
+	path := writeSyntheticDiscoveryState(b, 50000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := read(path); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadDiscoveryState(b *testing.B) {
+	benchmarkDiscoveryState(b, communication.ReadDiscoveryState)
+}
+
+func BenchmarkStreamDiscoveryState(b *testing.B) {
+	benchmarkDiscoveryState(b, communication.StreamDiscoveryState)
+}
@@ -0,0 +1,39 @@
+package communication
+
+import (
+	"encoding/json"
+	"lunar/toolkit-core/network"
+
+	"github.com/rs/zerolog/log"
+)
+
+// OnDynamicQuotas sets the dynamic quotas with the given function
+// whenever Lunar Hub sends a dynamic quotas event
+func (hub *HubCommunication) OnDynamicQuotas(
+	handler func(network.DynamicQuotasData),
+) {
+	if hub == nil {
+		log.Trace().Msg("Hub communication is down, dynamic quotas are not controlled by it")
+		return
+	}
+	hub.onDynamicQuotasMutex.Lock()
+	defer hub.onDynamicQuotasMutex.Unlock()
+	hub.onDynamicQuotas = handler
+}
+
+func (hub *HubCommunication) handleDynamicQuotas(data json.RawMessage) {
+	hub.onDynamicQuotasMutex.RLock()
+	handler := hub.onDynamicQuotas
+	hub.onDynamicQuotasMutex.RUnlock()
+	if handler == nil {
+		log.Debug().Msg("HubCommunication::OnMessage Dynamic quotas are not handled")
+		return
+	}
+	var dynamicQuotas network.DynamicQuotasData
+	if err := json.Unmarshal(data, &dynamicQuotas); err != nil {
+		log.Error().Err(err).
+			Msg("HubCommunication::OnMessage Error unmarshalling dynamic quotas")
+		return
+	}
+	handler(dynamicQuotas)
+}
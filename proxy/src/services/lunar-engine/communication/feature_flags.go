@@ -0,0 +1,39 @@
+package communication
+
+import (
+	"encoding/json"
+	"lunar/toolkit-core/network"
+
+	"github.com/rs/zerolog/log"
+)
+
+// OnFeatureFlags sets the feature flags with the given function
+// whenever Lunar Hub sends a feature flags event
+func (hub *HubCommunication) OnFeatureFlags(
+	handler func(network.FeatureFlagsData),
+) {
+	if hub == nil {
+		log.Trace().Msg("Hub communication is down, feature flags are not controlled by it")
+		return
+	}
+	hub.onFeatureFlagsMutex.Lock()
+	defer hub.onFeatureFlagsMutex.Unlock()
+	hub.onFeatureFlags = handler
+}
+
+func (hub *HubCommunication) handleFeatureFlags(data json.RawMessage) {
+	hub.onFeatureFlagsMutex.RLock()
+	handler := hub.onFeatureFlags
+	hub.onFeatureFlagsMutex.RUnlock()
+	if handler == nil {
+		log.Debug().Msg("HubCommunication::OnMessage Feature flags are not handled")
+		return
+	}
+	var featureFlags network.FeatureFlagsData
+	if err := json.Unmarshal(data, &featureFlags); err != nil {
+		log.Error().Err(err).
+			Msg("HubCommunication::OnMessage Error unmarshalling feature flags")
+		return
+	}
+	handler(featureFlags)
+}
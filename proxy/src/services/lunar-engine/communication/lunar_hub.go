@@ -10,10 +10,12 @@ import (
 	"lunar/toolkit-core/network"
 	"net/http"
 	"net/url"
-	"os"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 )
 
 const (
@@ -24,6 +26,11 @@ const (
 	proxyIDHeader             = "x-lunar-proxy-id"
 )
 
+const (
+	activeEndpointMetricName = "lunar_hub.active_endpoint"
+	endpointAttribute        = "endpoint"
+)
+
 var epochTime = time.Unix(0, 0)
 
 type HubCommunication struct {
@@ -32,6 +39,23 @@ type HubCommunication struct {
 	periodicInterval time.Duration
 	clock            clock.Clock
 	nextReportTime   time.Time
+	discoveryBackoff *ReportBackoff
+	discoveryChanges *DiscoveryChanges
+	// the last full discovery report sent, sent again once the connection
+	// was replaced, as the report in flight while it failed may be lost
+	lastDiscoveryReport      network.MessageI
+	lastDiscoveryReportMutex sync.Mutex
+	// whether the discovery state file went stale, as of the last report
+	discoveryStaleness *DiscoveryStaleness
+	// nil unless maintenance mode is controlled by Lunar Hub
+	onMaintenanceMode      func(network.MaintenanceModeData)
+	onMaintenanceModeMutex sync.RWMutex
+	// nil unless feature flags are controlled by Lunar Hub
+	onFeatureFlags      func(network.FeatureFlagsData)
+	onFeatureFlagsMutex sync.RWMutex
+	// nil unless dynamic quotas are controlled by Lunar Hub
+	onDynamicQuotas      func(network.DynamicQuotasData)
+	onDynamicQuotasMutex sync.RWMutex
 }
 
 func NewHubCommunication(apiKey string, proxyID string, clock clock.Clock) *HubCommunication {
@@ -43,10 +67,14 @@ func NewHubCommunication(apiKey string, proxyID string, clock clock.Clock) *HubC
 		reportInterval = defaultReportInterval
 	}
 
-	hubURL := url.URL{ //nolint: exhaustruct
-		Scheme: "ws",
-		Host:   environment.GetHubURL(),
-		Path:   "/ui/v1/control",
+	hubURLs := []string{}
+	for _, hubHost := range environment.GetHubURLs() {
+		hubURL := url.URL{ //nolint: exhaustruct
+			Scheme: "ws",
+			Host:   hubHost,
+			Path:   "/ui/v1/control",
+		}
+		hubURLs = append(hubURLs, hubURL.String())
 	}
 
 	handshakeHeaders := http.Header{
@@ -55,14 +83,24 @@ func NewHubCommunication(apiKey string, proxyID string, clock clock.Clock) *HubC
 		proxyVersionHeader: []string{environment.GetProxyVersion()},
 	}
 	hub := HubCommunication{ //nolint: exhaustruct
-		client:           network.NewWSClient(hubURL.String(), handshakeHeaders),
+		client: network.NewFailoverWSClient(
+			hubURLs, handshakeHeaders, hubFailoverPolicyFromEnv()),
 		workersStop:      []context.CancelFunc{},
 		periodicInterval: time.Duration(reportInterval) * time.Second,
 		clock:            clock,
 		nextReportTime:   time.Time{},
+		discoveryBackoff: newReportBackoffFromEnv(
+			time.Duration(reportInterval) * time.Second),
+		discoveryChanges: NewDiscoveryChanges(),
+		discoveryStaleness: newDiscoveryStalenessFromEnv(
+			clock, time.Duration(reportInterval)*time.Second),
 	}
 
 	hub.client.OnMessage(hub.onMessage)
+	// Whether the endpoint the connection is now to has the last reported
+	// state is unknown, so the next report is sent in full
+	hub.client.OnDisconnect(hub.discoveryChanges.Forget)
+	hub.client.OnReconnect(hub.onReconnect)
 
 	if err := hub.client.ConnectAndStart(); err != nil {
 		log.Error().Err(err).Msg("Failed to make connection with Lunar Hub")
@@ -72,13 +110,70 @@ func NewHubCommunication(apiKey string, proxyID string, clock clock.Clock) *HubC
 	return &hub
 }
 
+func hubFailoverPolicyFromEnv() network.FailoverPolicy {
+	afterFailures, err := environment.GetHubFailoverAfterFailures()
+	if err != nil || afterFailures <= 0 {
+		afterFailures = network.DefaultFailoverAfterFailures
+	}
+	returnToPrimaryInterval, err := environment.GetHubReturnToPrimaryInterval()
+	if err != nil || returnToPrimaryInterval <= 0 {
+		returnToPrimaryInterval = network.DefaultReturnToPrimaryInterval
+	}
+	return network.FailoverPolicy{ //nolint:exhaustruct
+		AfterFailures:           afterFailures,
+		ReturnToPrimaryInterval: returnToPrimaryInterval,
+	}
+}
+
+// IsConnected is whether the connection to Lunar Hub is currently up
+func (hub *HubCommunication) IsConnected() bool {
+	return hub != nil && hub.client.IsConnectionReady()
+}
+
+// ObserveActiveEndpoint reports which of the Lunar Hub endpoints the
+// connection is active to, observing 1 for it and 0 for its standbys
+func (hub *HubCommunication) ObserveActiveEndpoint(meter metric.Meter) {
+	if hub == nil {
+		return
+	}
+	_, err := meter.Int64ObservableGauge(
+		activeEndpointMetricName,
+		metric.WithDescription("Whether the connection to Lunar Hub is "+
+			"active to the endpoint, as it fails over between endpoints"),
+		metric.WithUnit("{connection}"),
+		metric.WithInt64Callback(
+			func(_ context.Context, observer metric.Int64Observer) error {
+				activeURL := hub.client.ActiveURL()
+				for _, endpoint := range hub.client.URLs() {
+					active := int64(0)
+					if endpoint == activeURL {
+						active = 1
+					}
+					observer.Observe(active, metric.WithAttributes(
+						attribute.String(endpointAttribute, endpoint)))
+				}
+				return nil
+			}),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to initialize %s metric",
+			activeEndpointMetricName)
+	}
+}
+
 func (hub *HubCommunication) SendDataToHub(message network.MessageI) {
+	_ = hub.sendDataToHub(message)
+}
+
+func (hub *HubCommunication) sendDataToHub(message network.MessageI) error {
 	log.Trace().Msgf(
 		"HubCommunication::SendDataToHub Sending data to Lunar Hub, event: %+v", message.GetEvent())
-	if err := hub.client.Send(message); err != nil {
+	err := hub.client.Send(message)
+	if err != nil {
 		log.Debug().Err(err).Msg(
 			"HubCommunication::SendDataToHub Error sending data to Lunar Hub")
 	}
+	return err
 }
 
 func (hub *HubCommunication) StartDiscoveryWorker() {
@@ -92,6 +187,11 @@ func (hub *HubCommunication) StartDiscoveryWorker() {
 		return
 	}
 
+	readDiscoveryState := ReadDiscoveryState
+	if environment.IsDiscoveryStateStreamingEnabled() {
+		readDiscoveryState = StreamDiscoveryState
+	}
+
 	go func() {
 		for {
 			timeToWaitForNextReport := hub.calculateTimeToWaitForNextReport()
@@ -99,36 +199,93 @@ func (hub *HubCommunication) StartDiscoveryWorker() {
 			case <-ctx.Done():
 				log.Trace().Msg("HubCommunication::DiscoveryWorker task canceled")
 				return
+			case <-hub.discoveryBackoff.Changed():
+				continue // Reschedule the next report as Lunar Hub rejected the last one
 			case <-time.After(timeToWaitForNextReport):
-				data, err := os.ReadFile(discoveryFileLocation)
-				if err != nil {
-					log.Error().Err(err).Msg(
-						"HubCommunication::DiscoveryWorker Error reading file")
-					continue
-				}
-				// Unmarshal the object data to Aggregation object and send it to the hub
-				output := sharedDiscovery.Output{}
-				err = json.Unmarshal(data, &output)
+				hub.discoveryBackoff.Due()
+				stale := hub.discoveryStaleness.Check(discoveryFileLocation)
+				output, err := readDiscoveryState(discoveryFileLocation)
 				if err != nil {
 					log.Error().Err(err).Msg(
-						"HubCommunication::DiscoveryWorker Error unmarshalling data")
+						"HubCommunication::DiscoveryWorker Error reading discovery state")
 					continue
 				}
 				output.CreatedAt = sharedActions.TimestampToStringFromTime(hub.nextReportTime)
-				message := network.DiscoveryMessage{
-					Event: network.WebSocketEventDiscovery,
-					Data:  output,
-				}
+				message, hash := DiscoveryReport(hub.discoveryChanges, output, stale)
 				log.Trace().Msgf("HubCommunication::DiscoveryWorker Sending data to Lunar Hub: %v, %+v",
 					hub.nextReportTime, message)
-				hub.SendDataToHub(&message)
+				if err := hub.sendDataToHub(message); err != nil {
+					hub.discoveryChanges.Forget()
+					hub.discoveryBackoff.Failed()
+					continue
+				}
+				hub.discoveryChanges.Reported(hash)
+				hub.discoveryBackoff.Sent()
+				if _, isFullReport := message.(*network.DiscoveryMessage); isFullReport {
+					hub.lastDiscoveryReportMutex.Lock()
+					hub.lastDiscoveryReport = message
+					hub.lastDiscoveryReportMutex.Unlock()
+				}
 			}
 		}
 	}()
 }
 
+// onReconnect sends the last discovery report again over the new connection,
+// so the endpoint it is to has the discovery state without waiting for the
+// next report, which is sent in full
+func (hub *HubCommunication) onReconnect() {
+	hub.discoveryChanges.Forget()
+	hub.lastDiscoveryReportMutex.Lock()
+	lastDiscoveryReport := hub.lastDiscoveryReport
+	hub.lastDiscoveryReportMutex.Unlock()
+	if lastDiscoveryReport == nil {
+		return
+	}
+	log.Debug().Str("active-url", hub.client.ActiveURL()).Msg(
+		"HubCommunication::onReconnect Sending the last discovery report again")
+	_ = hub.sendDataToHub(lastDiscoveryReport)
+}
+
+// DiscoveryReport is the message reporting the discovery state, along with
+// its hash. While the state is unchanged since it was last reported,
+// it is a heartbeat instead of the full report.
+// Either is annotated as stale if the discovery state file went stale.
+func DiscoveryReport(
+	changes *DiscoveryChanges,
+	output sharedDiscovery.Output,
+	stale bool,
+) (network.MessageI, string) {
+	hash, err := HashDiscoveryState(output)
+	if err != nil {
+		log.Debug().Err(err).Msg("HubCommunication::DiscoveryWorker Could not hash " +
+			"the discovery state, will send the full report")
+	} else if changes.IsUnchanged(hash) {
+		log.Trace().Str("content-hash", hash).Msg(
+			"HubCommunication::DiscoveryWorker Discovery state unchanged, skipping report")
+		return &network.DiscoveryHeartbeatMessage{
+			Event: network.WebSocketEventDiscoveryHeartbeat,
+			Data: network.DiscoveryHeartbeatData{
+				CreatedAt:   output.CreatedAt,
+				ContentHash: hash,
+				Stale:       stale,
+			},
+		}, hash
+	}
+	return &network.DiscoveryMessage{
+		Event: network.WebSocketEventDiscovery,
+		Data:  output,
+		Stale: stale,
+	}, hash
+}
+
 func (hub *HubCommunication) calculateTimeToWaitForNextReport() time.Duration {
 	currentTime := hub.clock.Now()
+	// While backing off, reports are no longer aligned to the interval
+	if delay := hub.discoveryBackoff.Delay(); delay > 0 {
+		hub.nextReportTime = currentTime.Add(delay)
+		return delay
+	}
 	elapsedTime := currentTime.Sub(epochTime)
 	previousReportTime := epochTime.Add(
 		(elapsedTime / hub.periodicInterval) * hub.periodicInterval,
@@ -153,7 +310,15 @@ func (hub *HubCommunication) onMessage(message []byte) {
 		return
 	}
 
-	switch wsMessage.Event {
+	switch network.WebSocketMessageEvent(wsMessage.Event) {
+	case network.WebSocketEventMaintenanceMode:
+		hub.handleMaintenanceMode(wsMessage.Data)
+	case network.WebSocketEventHubRejection:
+		hub.handleHubRejection(wsMessage.Data)
+	case network.WebSocketEventFeatureFlags:
+		hub.handleFeatureFlags(wsMessage.Data)
+	case network.WebSocketEventDynamicQuotas:
+		hub.handleDynamicQuotas(wsMessage.Data)
 	// Here we can add more cases for different events
 	default:
 		log.Debug().Msgf("HubCommunication::OnMessage Unknown event: %v", wsMessage.Event)
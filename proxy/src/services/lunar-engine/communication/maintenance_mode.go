@@ -0,0 +1,39 @@
+package communication
+
+import (
+	"encoding/json"
+	"lunar/toolkit-core/network"
+
+	"github.com/rs/zerolog/log"
+)
+
+// OnMaintenanceMode toggles the maintenance mode with the given function
+// whenever Lunar Hub sends a maintenance mode event
+func (hub *HubCommunication) OnMaintenanceMode(
+	handler func(network.MaintenanceModeData),
+) {
+	if hub == nil {
+		log.Trace().Msg("Hub communication is down, maintenance mode is not controlled by it")
+		return
+	}
+	hub.onMaintenanceModeMutex.Lock()
+	defer hub.onMaintenanceModeMutex.Unlock()
+	hub.onMaintenanceMode = handler
+}
+
+func (hub *HubCommunication) handleMaintenanceMode(data json.RawMessage) {
+	hub.onMaintenanceModeMutex.RLock()
+	handler := hub.onMaintenanceMode
+	hub.onMaintenanceModeMutex.RUnlock()
+	if handler == nil {
+		log.Debug().Msg("HubCommunication::OnMessage Maintenance mode is not handled")
+		return
+	}
+	var maintenanceMode network.MaintenanceModeData
+	if err := json.Unmarshal(data, &maintenanceMode); err != nil {
+		log.Error().Err(err).
+			Msg("HubCommunication::OnMessage Error unmarshalling maintenance mode")
+		return
+	}
+	handler(maintenanceMode)
+}
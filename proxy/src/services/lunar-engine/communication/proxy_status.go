@@ -0,0 +1,87 @@
+package communication
+
+import (
+	sharedActions "lunar/shared-model/actions"
+	"lunar/toolkit-core/network"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// StartupStatus collects the errors met while starting the proxy,
+// so the status it started with can be reported to Lunar Hub
+type StartupStatus struct {
+	mutex  sync.Mutex
+	errors []network.ProxyStatusError
+}
+
+func NewStartupStatus() *StartupStatus {
+	return &StartupStatus{
+		mutex:  sync.Mutex{},
+		errors: []network.ProxyStatusError{},
+	}
+}
+
+// Degraded records an error the proxy started despite,
+// with the given component not working as configured
+func (status *StartupStatus) Degraded(component string, err error) {
+	status.add(component, err, false)
+}
+
+// Failed records an error which prevented the proxy from starting
+func (status *StartupStatus) Failed(component string, err error) {
+	status.add(component, err, true)
+}
+
+func (status *StartupStatus) add(component string, err error, fatal bool) {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	status.errors = append(status.errors, network.ProxyStatusError{
+		Component: component,
+		Message:   err.Error(),
+		Fatal:     fatal,
+	})
+}
+
+func (status *StartupStatus) Data(now time.Time) network.ProxyStatusData {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	proxyStatus := network.ProxyStatusHealthy
+	for _, statusError := range status.errors {
+		if statusError.Fatal {
+			proxyStatus = network.ProxyStatusFailed
+			break
+		}
+		proxyStatus = network.ProxyStatusDegraded
+	}
+	return network.ProxyStatusData{
+		Status:    proxyStatus,
+		Errors:    append([]network.ProxyStatusError{}, status.errors...),
+		CreatedAt: sharedActions.TimestampToStringFromTime(now),
+	}
+}
+
+// ReportProxyStatus sends the startup status to Lunar Hub in the background,
+// so startup is not blocked by an unreachable hub.
+// The returned channel is closed once the status was handed to the hub
+// connection, or right away if there is no hub communication.
+func (hub *HubCommunication) ReportProxyStatus(
+	status *StartupStatus,
+) <-chan struct{} {
+	sent := make(chan struct{})
+	if hub == nil {
+		log.Debug().Msg("Hub communication is down, proxy status is not reported")
+		close(sent)
+		return sent
+	}
+	message := network.ProxyStatusMessage{
+		Event: network.WebSocketEventProxyStatus,
+		Data:  status.Data(hub.clock.Now()),
+	}
+	go func() {
+		defer close(sent)
+		hub.SendDataToHub(&message)
+	}()
+	return sent
+}
@@ -0,0 +1,43 @@
+package communication_test
+
+import (
+	"errors"
+	"lunar/engine/communication"
+	"lunar/toolkit-core/network"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartupStatusReportsTheWorstError(t *testing.T) {
+	status := communication.NewStartupStatus()
+	now := time.Unix(1000, 0)
+	assert.Equal(t, network.ProxyStatusHealthy, status.Data(now).Status)
+
+	status.Degraded("dead_letter_file", errors.New("permission denied"))
+	assert.Equal(t, network.ProxyStatusDegraded, status.Data(now).Status)
+
+	status.Failed("policies", errors.New("invalid remedy"))
+	data := status.Data(now)
+	assert.Equal(t, network.ProxyStatusFailed, data.Status)
+	require.Len(t, data.Errors, 2)
+	assert.Equal(t, network.ProxyStatusError{
+		Component: "policies",
+		Message:   "invalid remedy",
+		Fatal:     true,
+	}, data.Errors[1])
+}
+
+func TestReportProxyStatusWithoutHubDoesNotBlock(t *testing.T) {
+	var hub *communication.HubCommunication
+	status := communication.NewStartupStatus()
+	status.Failed("policies", errors.New("invalid remedy"))
+
+	select {
+	case <-hub.ReportProxyStatus(status):
+	case <-time.After(time.Second):
+		t.Fatal("reporting the proxy status without a hub blocked")
+	}
+}
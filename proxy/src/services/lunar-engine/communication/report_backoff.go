@@ -0,0 +1,216 @@
+package communication
+
+import (
+	"context"
+	"encoding/json"
+	"lunar/engine/utils/environment"
+	"lunar/toolkit-core/network"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	DefaultReportBackoffMultiplier         = 2.0
+	DefaultReportBackoffMax                = time.Hour
+	reportBackoffMetricName                = "lunar_hub.discovery_backoff"
+	reportBackoffConsecutiveFailuresMetric = "lunar_hub.discovery_consecutive_failures"
+)
+
+// ReportBackoff backs the reporting to Lunar Hub off while the hub fails to
+// accept or throttles the reports, by growing the interval between them
+// exponentially up to a maximum.
+// A report is only known to be delivered once the next report is due without
+// the hub rejecting it, so the backoff is kept until then, while the next
+// report is optimistically scheduled on the regular interval.
+type ReportBackoff struct {
+	interval    time.Duration
+	multiplier  float64
+	maxInterval time.Duration
+
+	mutex               sync.Mutex
+	consecutiveFailures int
+	retryAfter          time.Duration
+	pendingDelivery     bool
+	changed             chan struct{}
+}
+
+func NewReportBackoff(
+	interval time.Duration,
+	multiplier float64,
+	maxInterval time.Duration,
+) *ReportBackoff {
+	if multiplier < 1 {
+		multiplier = DefaultReportBackoffMultiplier
+	}
+	if maxInterval < interval {
+		maxInterval = interval
+	}
+	return &ReportBackoff{
+		interval:            interval,
+		multiplier:          multiplier,
+		maxInterval:         maxInterval,
+		mutex:               sync.Mutex{},
+		consecutiveFailures: 0,
+		retryAfter:          0,
+		pendingDelivery:     false,
+		changed:             make(chan struct{}, 1),
+	}
+}
+
+func newReportBackoffFromEnv(interval time.Duration) *ReportBackoff {
+	multiplier, err := environment.GetHubReportBackoffMultiplier()
+	if err != nil || multiplier < 1 {
+		multiplier = DefaultReportBackoffMultiplier
+	}
+	maxInterval, err := environment.GetHubReportBackoffMax()
+	if err != nil || maxInterval <= 0 {
+		maxInterval = DefaultReportBackoffMax
+	}
+	return NewReportBackoff(interval, multiplier, maxInterval)
+}
+
+// Delay returns how long to wait before the next report beyond the regular
+// interval, zero if the hub is healthy
+func (backoff *ReportBackoff) Delay() time.Duration {
+	backoff.mutex.Lock()
+	defer backoff.mutex.Unlock()
+	if backoff.pendingDelivery || backoff.consecutiveFailures == 0 {
+		return 0
+	}
+	return backoff.delay()
+}
+
+func (backoff *ReportBackoff) delay() time.Duration {
+	growth := math.Pow(backoff.multiplier, float64(backoff.consecutiveFailures))
+	delay := backoff.maxInterval
+	if scaled := float64(backoff.interval) * growth; scaled < float64(backoff.maxInterval) {
+		delay = time.Duration(scaled)
+	}
+	if backoff.retryAfter > delay {
+		delay = backoff.retryAfter
+	}
+	return delay
+}
+
+// Due is called once the next report is due, the previous report being
+// delivered if it was sent and the hub did not reject it since
+func (backoff *ReportBackoff) Due() {
+	backoff.mutex.Lock()
+	defer backoff.mutex.Unlock()
+	if !backoff.pendingDelivery {
+		return
+	}
+	backoff.pendingDelivery = false
+	if backoff.consecutiveFailures > 0 {
+		log.Info().Msgf("Lunar Hub accepted the reports again after %d failures",
+			backoff.consecutiveFailures)
+	}
+	backoff.consecutiveFailures = 0
+	backoff.retryAfter = 0
+}
+
+// Sent records the report was handed to the hub connection
+func (backoff *ReportBackoff) Sent() {
+	backoff.mutex.Lock()
+	defer backoff.mutex.Unlock()
+	backoff.pendingDelivery = true
+}
+
+// Failed records the report could not be handed to the hub connection
+func (backoff *ReportBackoff) Failed() {
+	backoff.fail(0)
+}
+
+// Rejected records the hub failed to accept or throttled the report,
+// waiting at least retryAfter before the next one
+func (backoff *ReportBackoff) Rejected(retryAfter time.Duration) {
+	backoff.fail(retryAfter)
+	select {
+	case backoff.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (backoff *ReportBackoff) fail(retryAfter time.Duration) {
+	backoff.mutex.Lock()
+	defer backoff.mutex.Unlock()
+	backoff.pendingDelivery = false
+	backoff.consecutiveFailures++
+	backoff.retryAfter = retryAfter
+	log.Debug().Msgf("Backing off reporting to Lunar Hub for %v after %d failures",
+		backoff.delay(), backoff.consecutiveFailures)
+}
+
+// Changed is signaled when the hub rejected a report,
+// for the next report to be rescheduled
+func (backoff *ReportBackoff) Changed() <-chan struct{} {
+	return backoff.changed
+}
+
+func (backoff *ReportBackoff) ConsecutiveFailures() int {
+	backoff.mutex.Lock()
+	defer backoff.mutex.Unlock()
+	return backoff.consecutiveFailures
+}
+
+// ObserveDiscoveryBackoff reports the backoff of the discovery reporting
+// as metrics
+func (hub *HubCommunication) ObserveDiscoveryBackoff(meter metric.Meter) {
+	if hub == nil {
+		return
+	}
+	backoff := hub.discoveryBackoff
+	_, err := meter.Float64ObservableGauge(
+		reportBackoffMetricName,
+		metric.WithDescription("Delay added to the interval between discovery "+
+			"reports while Lunar Hub fails to accept or throttles them"),
+		metric.WithUnit("s"),
+		metric.WithFloat64Callback(
+			func(_ context.Context, observer metric.Float64Observer) error {
+				observer.Observe(backoff.Delay().Seconds())
+				return nil
+			}),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to initialize %s metric",
+			reportBackoffMetricName)
+	}
+	_, err = meter.Int64ObservableGauge(
+		reportBackoffConsecutiveFailuresMetric,
+		metric.WithDescription("Discovery reports Lunar Hub failed to accept "+
+			"or throttled in a row"),
+		metric.WithUnit("{report}"),
+		metric.WithInt64Callback(
+			func(_ context.Context, observer metric.Int64Observer) error {
+				observer.Observe(int64(backoff.ConsecutiveFailures()))
+				return nil
+			}),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to initialize %s metric",
+			reportBackoffConsecutiveFailuresMetric)
+	}
+}
+
+func (hub *HubCommunication) handleHubRejection(data json.RawMessage) {
+	var rejection network.HubRejectionData
+	if err := json.Unmarshal(data, &rejection); err != nil {
+		log.Error().Err(err).
+			Msg("HubCommunication::OnMessage Error unmarshalling hub rejection")
+		return
+	}
+	if rejection.RejectedEvent != network.WebSocketEventDiscovery &&
+		rejection.RejectedEvent != network.WebSocketEventDiscoveryHeartbeat {
+		log.Debug().Msgf("HubCommunication::OnMessage Lunar Hub rejected %v: %v",
+			rejection.RejectedEvent, rejection.Reason)
+		return
+	}
+	log.Warn().Msgf("Lunar Hub rejected the discovery report: %v", rejection.Reason)
+	hub.discoveryChanges.Forget()
+	hub.discoveryBackoff.Rejected(
+		time.Duration(rejection.RetryAfterSeconds) * time.Second)
+}
@@ -0,0 +1,65 @@
+package communication_test
+
+import (
+	"lunar/engine/communication"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportBackoffGrowsExponentiallyUpToTheMax(t *testing.T) {
+	backoff := communication.NewReportBackoff(10*time.Second, 2, time.Minute)
+	assert.Zero(t, backoff.Delay())
+
+	for _, expected := range []time.Duration{
+		20 * time.Second, 40 * time.Second, time.Minute, time.Minute,
+	} {
+		backoff.Due()
+		backoff.Failed()
+		assert.Equal(t, expected, backoff.Delay())
+	}
+	assert.Equal(t, 4, backoff.ConsecutiveFailures())
+}
+
+func TestReportBackoffResetsOnceTheReportWasDelivered(t *testing.T) {
+	backoff := communication.NewReportBackoff(10*time.Second, 2, time.Minute)
+	backoff.Failed()
+	backoff.Due()
+	backoff.Sent()
+	// The next report is scheduled on the regular interval while pending
+	assert.Zero(t, backoff.Delay())
+	assert.Equal(t, 1, backoff.ConsecutiveFailures())
+
+	backoff.Due()
+	assert.Zero(t, backoff.Delay())
+	assert.Zero(t, backoff.ConsecutiveFailures())
+}
+
+func TestReportBackoffKeepsGrowingWhileTheHubRejectsSentReports(t *testing.T) {
+	backoff := communication.NewReportBackoff(10*time.Second, 2, time.Hour)
+	for _, expected := range []time.Duration{
+		20 * time.Second, 40 * time.Second, 80 * time.Second,
+	} {
+		backoff.Due()
+		backoff.Sent()
+		backoff.Rejected(0)
+		assert.Equal(t, expected, backoff.Delay())
+		select {
+		case <-backoff.Changed():
+		default:
+			t.Fatal("rejection did not signal the next report to be rescheduled")
+		}
+	}
+}
+
+func TestReportBackoffHonorsTheRetryAfterOfTheHub(t *testing.T) {
+	backoff := communication.NewReportBackoff(10*time.Second, 2, time.Minute)
+	backoff.Sent()
+	backoff.Rejected(5 * time.Minute)
+	assert.Equal(t, 5*time.Minute, backoff.Delay())
+
+	backoff.Due()
+	backoff.Failed()
+	assert.Equal(t, 40*time.Second, backoff.Delay())
+}
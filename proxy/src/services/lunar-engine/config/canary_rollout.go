@@ -0,0 +1,335 @@
+package config
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	sharedConfig "lunar/shared-model/config"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	canaryRequestsMetricName         = "lunar_config.canary.requests"
+	canaryUpstreamDurationMetricName = "lunar_config.canary.upstream_duration"
+	canaryVersionKey                 = "config_version"
+	canaryRoleKey                    = "config_role"
+	canaryRoleCurrent                = "current"
+	canaryRoleCandidate              = "candidate"
+	canaryOutcomeKey                 = "outcome"
+	canaryOutcomeRejected            = "rejected"
+	canaryOutcomeForwarded           = "forwarded"
+
+	// the fraction of transactions sampled is precise up to this resolution
+	canarySamplingResolution = 1_000_000
+)
+
+var (
+	errCanaryRunning = errors.New(
+		"a canary config is already running, promote or roll it back first")
+	errNoCanaryRunning = errors.New("no canary config is running")
+	errCanaryExporters = errors.New("exporters cannot be changed by a canary " +
+		"config, as they are shared by both versions")
+	errCanaryOutOfRange = errors.New(
+		"canary fraction must be greater than 0 and at most 1")
+)
+
+// canaryRollout routes a fraction of the transactions through a candidate
+// config version, while the rest keep going through the current one
+type canaryRollout struct {
+	version  PoliciesVersion
+	data     *PoliciesData
+	rawData  []byte
+	fraction float64
+	seed     uint64
+}
+
+// samples returns whether the transaction goes through the candidate.
+// The transaction ID is hashed along with the seed, so rollouts with the
+// same seed sample the same transactions.
+func (canary *canaryRollout) samples(txnID TxnID) bool {
+	hash := fnv.New64a()
+	seed := make([]byte, 8)
+	binary.BigEndian.PutUint64(seed, canary.seed)
+	_, _ = hash.Write(seed)
+	_, _ = hash.Write([]byte(txnID))
+	point := hash.Sum64() % canarySamplingResolution
+	return float64(point) < canary.fraction*canarySamplingResolution
+}
+
+// CanaryMetrics compares the transactions going through the current and
+// candidate config versions while a canary runs, tagged by their version
+type CanaryMetrics struct {
+	requests         metric.Int64Counter
+	upstreamDuration metric.Int64Histogram
+}
+
+func NewCanaryMetrics(meter metric.Meter) *CanaryMetrics {
+	requests, err := meter.Int64Counter(
+		canaryRequestsMetricName,
+		metric.WithDescription("Requests handled while a canary config runs, "+
+			"by the config version they went through and whether it rejected them"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to initialize %s metric",
+			canaryRequestsMetricName)
+	}
+	upstreamDuration, err := meter.Int64Histogram(
+		canaryUpstreamDurationMetricName,
+		metric.WithDescription("Upstream duration of the transactions handled while "+
+			"a canary config runs, by the config version they went through"),
+		metric.WithUnit("ms"),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to initialize %s metric",
+			canaryUpstreamDurationMetricName)
+	}
+	return &CanaryMetrics{
+		requests:         requests,
+		upstreamDuration: upstreamDuration,
+	}
+}
+
+// SetCanaryMetrics records the transactions of canaries with the given metrics
+func (txnPoliciesAccessor *TxnPoliciesAccessor) SetCanaryMetrics(
+	metrics *CanaryMetrics,
+) {
+	txnPoliciesAccessor.canaryMetrics = metrics
+}
+
+// StartCanary validates the candidate config and routes the given fraction
+// of the transactions through it, sampled by a hash of their ID seeded by
+// the given seed. The candidate is only applied to all transactions once
+// promoted, and is not written to the policies file until then.
+func (txnPoliciesAccessor *TxnPoliciesAccessor) StartCanary(
+	rawData []byte,
+	fraction float64,
+	seed uint64,
+) error {
+	if fraction <= 0 || fraction > 1 {
+		return fmt.Errorf("%w, got %v", errCanaryOutOfRange, fraction)
+	}
+	candidate, err := buildRawPoliciesData(rawData)
+	if err != nil {
+		return err
+	}
+	current := txnPoliciesAccessor.getCurrentPoliciesData()
+	if !reflect.DeepEqual(current.Config.Exporters, candidate.Config.Exporters) {
+		return errCanaryExporters
+	}
+	if txnPoliciesAccessor.getCanary() != nil {
+		return errCanaryRunning
+	}
+
+	// The endpoints of both versions are managed while the canary runs
+	err = ManageHAProxyEndpoints(mergeHAProxyEndpointsRequests(
+		BuildHAProxyEndpointsRequest(&current.Config),
+		BuildHAProxyEndpointsRequest(&candidate.Config),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to initialize HAProxy endpoints: %v", err)
+	}
+	// Remedies added by the candidate warm up on the canary transactions,
+	// and keep their warmup once promoted
+	startRemedyWarmups(&current.Config, &candidate.Config,
+		txnPoliciesAccessor.clock.Now())
+
+	txnPoliciesAccessor.mutex.Lock()
+	if txnPoliciesAccessor.canary != nil {
+		txnPoliciesAccessor.mutex.Unlock()
+		return errCanaryRunning
+	}
+	txnPoliciesAccessor.latestVersion++
+	canary := &canaryRollout{
+		version:  txnPoliciesAccessor.latestVersion,
+		data:     candidate,
+		rawData:  rawData,
+		fraction: fraction,
+		seed:     seed,
+	}
+	txnPoliciesAccessor.policiesVersions[canary.version] = candidate
+	txnPoliciesAccessor.canary = canary
+	currentVersion := txnPoliciesAccessor.currentVersion
+	txnPoliciesAccessor.mutex.Unlock()
+
+	log.Info().
+		Msgf("Started canary config version %d on %.2f%% of transactions, "+
+			"current version: %d", canary.version, fraction*100, currentVersion)
+	return nil
+}
+
+// PromoteCanary applies the candidate config of the running canary to all
+// transactions. Transactions switch to it at once, except for the ones
+// already anchored to the previous version.
+func (txnPoliciesAccessor *TxnPoliciesAccessor) PromoteCanary() error {
+	return txnPoliciesAccessor.recordChange(txnPoliciesAccessor.promoteCanary)
+}
+
+func (txnPoliciesAccessor *TxnPoliciesAccessor) promoteCanary() error {
+	txnPoliciesAccessor.mutex.Lock()
+	canary := txnPoliciesAccessor.canary
+	if canary == nil {
+		txnPoliciesAccessor.mutex.Unlock()
+		return errNoCanaryRunning
+	}
+	previousVersion := txnPoliciesAccessor.currentVersion
+	previous := txnPoliciesAccessor.policiesVersions[previousVersion]
+	txnPoliciesAccessor.currentVersion = canary.version
+	txnPoliciesAccessor.canary = nil
+	txnPoliciesAccessor.mutex.Unlock()
+
+	txnPoliciesAccessor.policiesVersionsVacuum.VacuumKey(previousVersion)
+	if previous != nil {
+		scheduleUnmanageStaleEndpoints(&previous.Config, &canary.data.Config)
+	}
+	log.Info().
+		Msgf("Promoted canary config, current version: %d", canary.version)
+
+	filePath, err := getPoliciesPath()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, canary.rawData, 0o644)
+}
+
+// RollbackCanary stops routing transactions through the candidate config
+// of the running canary, all of them going through the current one again
+func (txnPoliciesAccessor *TxnPoliciesAccessor) RollbackCanary() error {
+	txnPoliciesAccessor.mutex.Lock()
+	canary := txnPoliciesAccessor.canary
+	current := txnPoliciesAccessor.policiesVersions[txnPoliciesAccessor.currentVersion]
+	txnPoliciesAccessor.canary = nil
+	txnPoliciesAccessor.mutex.Unlock()
+	if canary == nil {
+		return errNoCanaryRunning
+	}
+
+	txnPoliciesAccessor.rolledBack(canary, current)
+	log.Info().Msgf("Rolled back canary config version %d", canary.version)
+	return nil
+}
+
+// rolledBack releases what was held for a canary once it was rolled back
+func (txnPoliciesAccessor *TxnPoliciesAccessor) rolledBack(
+	canary *canaryRollout,
+	current *PoliciesData,
+) {
+	txnPoliciesAccessor.policiesVersionsVacuum.VacuumKey(canary.version)
+	if current != nil {
+		scheduleUnmanageStaleEndpoints(&canary.data.Config, &current.Config)
+	}
+}
+
+// RecordCanaryRequest records whether the request was rejected by the
+// config version it went through, if it went through one of a canary
+func (txnPoliciesAccessor *TxnPoliciesAccessor) RecordCanaryRequest(
+	txnID TxnID,
+	rejected bool,
+) {
+	metrics := txnPoliciesAccessor.canaryMetrics
+	if metrics == nil || metrics.requests == nil {
+		return
+	}
+	attributes, found := txnPoliciesAccessor.canaryAttributesOf(txnID)
+	if !found {
+		return
+	}
+	outcome := canaryOutcomeForwarded
+	if rejected {
+		outcome = canaryOutcomeRejected
+	}
+	attributes = append(attributes, attribute.String(canaryOutcomeKey, outcome))
+	metrics.requests.Add(context.Background(), 1,
+		metric.WithAttributes(attributes...))
+}
+
+// RecordCanaryResponse records the upstream duration of the transaction,
+// if it went through a config version of a canary
+func (txnPoliciesAccessor *TxnPoliciesAccessor) RecordCanaryResponse(
+	txnID TxnID,
+	upstreamDuration time.Duration,
+) {
+	metrics := txnPoliciesAccessor.canaryMetrics
+	if metrics == nil || metrics.upstreamDuration == nil {
+		return
+	}
+	attributes, found := txnPoliciesAccessor.canaryAttributesOf(txnID)
+	if !found {
+		return
+	}
+	metrics.upstreamDuration.Record(context.Background(),
+		upstreamDuration.Milliseconds(), metric.WithAttributes(attributes...))
+}
+
+// canaryAttributesOf returns the attributes of the config version the
+// transaction went through, if it is the current or candidate version
+// of the running canary
+func (txnPoliciesAccessor *TxnPoliciesAccessor) canaryAttributesOf(
+	txnID TxnID,
+) ([]attribute.KeyValue, bool) {
+	txnPoliciesAccessor.mutex.RLock()
+	defer txnPoliciesAccessor.mutex.RUnlock()
+	canary := txnPoliciesAccessor.canary
+	if canary == nil {
+		return nil, false
+	}
+	version, found := txnPoliciesAccessor.txnVersions[txnID]
+	if !found {
+		return nil, false
+	}
+	var role string
+	switch version {
+	case canary.version:
+		role = canaryRoleCandidate
+	case txnPoliciesAccessor.currentVersion:
+		role = canaryRoleCurrent
+	default:
+		return nil, false
+	}
+	return []attribute.KeyValue{
+		attribute.String(canaryVersionKey, strconv.Itoa(int(version))),
+		attribute.String(canaryRoleKey, role),
+	}, true
+}
+
+func (txnPoliciesAccessor *TxnPoliciesAccessor) getCanary() *canaryRollout {
+	txnPoliciesAccessor.mutex.RLock()
+	defer txnPoliciesAccessor.mutex.RUnlock()
+	return txnPoliciesAccessor.canary
+}
+
+func mergeHAProxyEndpointsRequests(
+	request *HAProxyEndpointsRequest,
+	other *HAProxyEndpointsRequest,
+) *HAProxyEndpointsRequest {
+	return &HAProxyEndpointsRequest{
+		ManageAll: request.ManageAll || other.ManageAll,
+		ManagedEndpoints: lo.Union(
+			request.ManagedEndpoints,
+			other.ManagedEndpoints,
+		),
+	}
+}
+
+// scheduleUnmanageStaleEndpoints unmanages the endpoints managed for
+// the stale config which are not managed for the kept one
+func scheduleUnmanageStaleEndpoints(
+	stale *sharedConfig.PoliciesConfig,
+	kept *sharedConfig.PoliciesConfig,
+) {
+	staleEndpoints, _ := lo.Difference(
+		BuildHAProxyEndpointsRequest(stale).ManagedEndpoints,
+		BuildHAProxyEndpointsRequest(kept).ManagedEndpoints,
+	)
+	ScheduleUnmanageHAProxyEndpoints(staleEndpoints)
+}
@@ -0,0 +1,188 @@
+package config_test
+
+import (
+	"context"
+	"fmt"
+	"lunar/engine/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+const candidateRawData = `
+global:
+  remedies:
+    - name: remedy_c
+      enabled: false
+      config:
+        fixed_response:
+          status_code: 503
+`
+
+func candidateRemedyName(policiesData *config.PoliciesData) string {
+	if len(policiesData.Config.Global.Remedies) == 0 {
+		return ""
+	}
+	return policiesData.Config.Global.Remedies[0].Name
+}
+
+// candidateTxns returns the IDs of the transactions going through the candidate
+func candidateTxns(accessor *config.TxnPoliciesAccessor, txnCount int) []string {
+	candidates := []string{}
+	for i := 0; i < txnCount; i++ {
+		txnID := fmt.Sprintf("txn-%d", i)
+		policiesData := accessor.GetTxnPoliciesData(config.TxnID(txnID))
+		if candidateRemedyName(policiesData) == "remedy_c" {
+			candidates = append(candidates, txnID)
+		}
+	}
+	return candidates
+}
+
+func TestCanaryRoutesAReproducibleFractionOfTransactionsThroughTheCandidate(
+	t *testing.T,
+) {
+	initValidations()
+	accessor := config.NewTxnPoliciesAccessor(createPoliciesData("remedy_a"))
+	require.NoError(t, accessor.StartCanary([]byte(candidateRawData), 0.2, 42))
+	candidates := candidateTxns(&accessor, 1000)
+	assert.InDelta(t, 200, len(candidates), 50)
+
+	// Transactions stay on the version they were anchored to
+	assert.Equal(t, candidates, candidateTxns(&accessor, 1000))
+
+	// A rollout with the same seed samples the same transactions
+	other := config.NewTxnPoliciesAccessor(createPoliciesData("remedy_a"))
+	require.NoError(t, other.StartCanary([]byte(candidateRawData), 0.2, 42))
+	assert.Equal(t, candidates, candidateTxns(&other, 1000))
+}
+
+func TestRolledBackCanaryRoutesNewTransactionsThroughTheCurrentConfig(
+	t *testing.T,
+) {
+	initValidations()
+	accessor := config.NewTxnPoliciesAccessor(createPoliciesData("remedy_a"))
+	require.NoError(t, accessor.StartCanary([]byte(candidateRawData), 1, 0))
+	anchored := accessor.GetTxnPoliciesData(config.TxnID("anchored"))
+	assert.Equal(t, "remedy_c", candidateRemedyName(anchored))
+
+	require.NoError(t, accessor.RollbackCanary())
+	assert.Empty(t, candidateTxns(&accessor, 100))
+	assert.Equal(t, anchored,
+		accessor.GetTxnPoliciesData(config.TxnID("anchored")))
+	assert.Error(t, accessor.RollbackCanary())
+}
+
+func TestPromotedCanaryRoutesAllTransactionsThroughTheCandidate(t *testing.T) {
+	initValidations()
+	policiesPath := filepath.Join(t.TempDir(), "policies.yaml")
+	t.Setenv("LUNAR_PROXY_POLICIES_CONFIG", policiesPath)
+	accessor := config.NewTxnPoliciesAccessor(createPoliciesData("remedy_a"))
+	assert.Error(t, accessor.PromoteCanary())
+
+	require.NoError(t, accessor.StartCanary([]byte(candidateRawData), 0.1, 0))
+	require.NoError(t, accessor.PromoteCanary())
+
+	assert.Len(t, candidateTxns(&accessor, 100), 100)
+	written, err := os.ReadFile(policiesPath)
+	require.NoError(t, err)
+	assert.Equal(t, candidateRawData, string(written))
+
+	// Versions set later do not collide with the promoted one
+	require.NoError(t, accessor.UpdatePoliciesData(createPoliciesData("remedy_d")))
+	assert.Equal(t, "remedy_d", candidateRemedyName(
+		accessor.GetTxnPoliciesData(config.TxnID("new"))))
+}
+
+func TestReloadingConfigRollsBackTheRunningCanary(t *testing.T) {
+	initValidations()
+	accessor := config.NewTxnPoliciesAccessor(createPoliciesData("remedy_a"))
+	require.NoError(t, accessor.StartCanary([]byte(candidateRawData), 1, 0))
+
+	require.NoError(t, accessor.UpdatePoliciesData(createPoliciesData("remedy_d")))
+
+	assert.Equal(t, "remedy_d", candidateRemedyName(
+		accessor.GetTxnPoliciesData(config.TxnID("1"))))
+	assert.Error(t, accessor.PromoteCanary())
+}
+
+func TestStartCanaryRejectsInvalidCandidates(t *testing.T) {
+	initValidations()
+	accessor := config.NewTxnPoliciesAccessor(createPoliciesData("remedy_a"))
+
+	assert.Error(t, accessor.StartCanary([]byte("global: ["), 0.1, 0))
+	assert.Error(t, accessor.StartCanary([]byte(candidateRawData), 0, 0))
+	assert.Error(t, accessor.StartCanary([]byte(candidateRawData), 1.5, 0))
+	assert.Error(t, accessor.StartCanary([]byte(candidateRawData+`
+exporters:
+  file:
+    file_dir: "/tmp"
+    file_name: "output.log"
+`), 0.1, 0))
+	assert.Empty(t, candidateTxns(&accessor, 100))
+
+	require.NoError(t, accessor.StartCanary([]byte(candidateRawData), 0.1, 0))
+	assert.Error(t, accessor.StartCanary([]byte(candidateRawData), 0.1, 0))
+}
+
+func TestCanaryMetricsAreTaggedByConfigVersion(t *testing.T) {
+	initValidations()
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	accessor := config.NewTxnPoliciesAccessor(createPoliciesData("remedy_a"))
+	accessor.SetCanaryMetrics(config.NewCanaryMetrics(meter))
+
+	// Transactions are only recorded while a canary runs
+	accessor.GetTxnPoliciesData(config.TxnID("before"))
+	accessor.RecordCanaryRequest(config.TxnID("before"), true)
+
+	require.NoError(t, accessor.StartCanary([]byte(candidateRawData), 0.5, 7))
+	candidates := candidateTxns(&accessor, 10)
+	for i := 0; i < 10; i++ {
+		txnID := config.TxnID(fmt.Sprintf("txn-%d", i))
+		accessor.RecordCanaryRequest(txnID, false)
+		accessor.RecordCanaryResponse(txnID, 20*time.Millisecond)
+	}
+
+	var data metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &data))
+	requests := map[string]int64{}
+	durations := map[string]uint64{}
+	for _, scopeMetrics := range data.ScopeMetrics {
+		for _, metric := range scopeMetrics.Metrics {
+			switch metric.Name {
+			case "lunar_config.canary.requests":
+				sum, ok := metric.Data.(metricdata.Sum[int64])
+				require.True(t, ok)
+				for _, point := range sum.DataPoints {
+					version, _ := point.Attributes.Value("config_version")
+					role, _ := point.Attributes.Value("config_role")
+					outcome, _ := point.Attributes.Value("outcome")
+					requests[version.AsString()+"/"+role.AsString()+"/"+
+						outcome.AsString()] += point.Value
+				}
+			case "lunar_config.canary.upstream_duration":
+				histogram, ok := metric.Data.(metricdata.Histogram[int64])
+				require.True(t, ok)
+				for _, point := range histogram.DataPoints {
+					role, _ := point.Attributes.Value("config_role")
+					durations[role.AsString()] += point.Count
+				}
+			}
+		}
+	}
+	assert.Equal(t, map[string]int64{
+		"1/current/forwarded":   int64(10 - len(candidates)),
+		"2/candidate/forwarded": int64(len(candidates)),
+	}, requests)
+	assert.Equal(t, map[string]uint64{
+		"current":   uint64(10 - len(candidates)),
+		"candidate": uint64(len(candidates)),
+	}, durations)
+}
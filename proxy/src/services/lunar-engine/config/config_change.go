@@ -0,0 +1,201 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	sharedActions "lunar/shared-model/actions"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"lunar/toolkit-core/network"
+	"reflect"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	configChangesMetricName  = "lunar_config.changes"
+	configChangeResultKey    = "result"
+	configChangeApplied      = "applied"
+	configChangeRejected     = "rejected"
+	maxConfigChangeEntries   = 50
+	maxConfigChangeErrorSize = 512
+)
+
+// ConfigChangeRecorder records every attempt to reload the policies config,
+// to the logs, a metric and optionally Lunar Hub, so config changes are
+// traceable. Changed remedies are only identified by their name and
+// endpoint, as their config may hold secrets.
+type ConfigChangeRecorder struct {
+	clock   clock.Clock
+	changes metric.Int64Counter
+	report  func(network.ConfigChangeData)
+}
+
+// NewConfigChangeRecorder creates a recorder which reports the changes
+// with the given function, if it is not nil
+func NewConfigChangeRecorder(
+	clock clock.Clock,
+	meter metric.Meter,
+	report func(network.ConfigChangeData),
+) *ConfigChangeRecorder {
+	changes, err := meter.Int64Counter(
+		configChangesMetricName,
+		metric.WithDescription("Attempts to reload the policies config, "+
+			"by whether they were applied"),
+		metric.WithUnit("{reload}"),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to initialize %s metric",
+			configChangesMetricName)
+	}
+	return &ConfigChangeRecorder{
+		clock:   clock,
+		changes: changes,
+		report:  report,
+	}
+}
+
+// Applied records a reload which replaced the previous config with the next
+func (recorder *ConfigChangeRecorder) Applied(
+	previous *sharedConfig.PoliciesConfig,
+	next *sharedConfig.PoliciesConfig,
+) {
+	if recorder == nil {
+		return
+	}
+	diff := DiffRemedies(previous, next)
+	log.Info().
+		Strs("added", diff.Added).
+		Strs("removed", diff.Removed).
+		Strs("modified", diff.Modified).
+		Int("truncated", diff.Truncated).
+		Msg("Policies config changed")
+	recorder.record(network.ConfigChangeData{
+		Applied:             true,
+		Error:               "",
+		PriorConfigRetained: false,
+		Remedies:            diff,
+		CreatedAt:           "",
+	})
+}
+
+// Rejected records a reload which failed, with whether the prior config
+// is still the one in effect
+func (recorder *ConfigChangeRecorder) Rejected(err error, priorConfigRetained bool) {
+	if recorder == nil {
+		return
+	}
+	reason := err.Error()
+	if len(reason) > maxConfigChangeErrorSize {
+		reason = reason[:maxConfigChangeErrorSize] + "..."
+	}
+	log.Warn().
+		Str("error", reason).
+		Bool("prior_config_retained", priorConfigRetained).
+		Msg("Policies config change was rejected")
+	recorder.record(network.ConfigChangeData{
+		Applied:             false,
+		Error:               reason,
+		PriorConfigRetained: priorConfigRetained,
+		Remedies: network.ConfigChangeDiff{
+			Added:     []string{},
+			Removed:   []string{},
+			Modified:  []string{},
+			Truncated: 0,
+		},
+		CreatedAt: "",
+	})
+}
+
+func (recorder *ConfigChangeRecorder) record(change network.ConfigChangeData) {
+	change.CreatedAt = sharedActions.TimestampToStringFromTime(recorder.clock.Now())
+	result := configChangeApplied
+	if !change.Applied {
+		result = configChangeRejected
+	}
+	if recorder.changes != nil {
+		recorder.changes.Add(context.Background(), 1, metric.WithAttributes(
+			attribute.String(configChangeResultKey, result)))
+	}
+	if recorder.report != nil {
+		recorder.report(change)
+	}
+}
+
+// DiffRemedies lists the remedies which were added, removed or modified
+// between the configs, by their endpoint and name. Each list is bounded,
+// so a reload replacing a large config does not flood the logs.
+func DiffRemedies(
+	previous *sharedConfig.PoliciesConfig,
+	next *sharedConfig.PoliciesConfig,
+) network.ConfigChangeDiff {
+	previousRemedies := remediesByKey(previous)
+	nextRemedies := remediesByKey(next)
+	added, removed, modified := []string{}, []string{}, []string{}
+	for key, nextRemedy := range nextRemedies {
+		previousRemedy, found := previousRemedies[key]
+		switch {
+		case !found:
+			added = append(added, key)
+		case !reflect.DeepEqual(previousRemedy, nextRemedy):
+			modified = append(modified, key)
+		}
+	}
+	for key := range previousRemedies {
+		if _, found := nextRemedies[key]; !found {
+			removed = append(removed, key)
+		}
+	}
+
+	diff := network.ConfigChangeDiff{} //nolint:exhaustruct
+	remaining := maxConfigChangeEntries
+	bound := func(keys []string) []string {
+		sort.Strings(keys)
+		kept := len(keys)
+		if kept > remaining {
+			kept = remaining
+		}
+		remaining -= kept
+		diff.Truncated += len(keys) - kept
+		return keys[:kept]
+	}
+	diff.Added = bound(added)
+	diff.Removed = bound(removed)
+	diff.Modified = bound(modified)
+	return diff
+}
+
+func remediesByKey(
+	policies *sharedConfig.PoliciesConfig,
+) map[string]sharedConfig.Remedy {
+	remedies := map[string]sharedConfig.Remedy{}
+	forEachRemedy(policies, func(key string, remedy *sharedConfig.Remedy) {
+		remedies[key] = *remedy
+	})
+	return remedies
+}
+
+// forEachRemedy calls the given function with each remedy of the policies,
+// along with the key identifying it by its endpoint and name
+func forEachRemedy(
+	policies *sharedConfig.PoliciesConfig,
+	do func(key string, remedy *sharedConfig.Remedy),
+) {
+	if policies == nil {
+		return
+	}
+	for index := range policies.Global.Remedies {
+		remedy := &policies.Global.Remedies[index]
+		do(fmt.Sprintf("global/%s", remedy.Name), remedy)
+	}
+	for _, endpoint := range policies.Endpoints {
+		for index := range endpoint.Remedies {
+			remedy := &endpoint.Remedies[index]
+			do(fmt.Sprintf("%s %s/%s", endpoint.Method, endpoint.URL, remedy.Name),
+				remedy)
+		}
+	}
+}
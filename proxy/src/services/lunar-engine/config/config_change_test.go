@@ -0,0 +1,124 @@
+package config_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lunar/engine/config"
+	"lunar/toolkit-core/clock"
+	"lunar/toolkit-core/network"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestDiffRemediesListsAddedRemovedAndModifiedRemedies(t *testing.T) {
+	previous := &createPoliciesData("kept", "modified", "removed").Config
+	next := &createPoliciesData("kept", "modified", "added").Config
+	next.Global.Remedies[1].Enabled = true
+
+	assert.Equal(t, network.ConfigChangeDiff{
+		Added:     []string{"global/added"},
+		Removed:   []string{"global/removed"},
+		Modified:  []string{"global/modified"},
+		Truncated: 0,
+	}, config.DiffRemedies(previous, next))
+}
+
+func TestDiffRemediesIsBounded(t *testing.T) {
+	remedyNames := []string{}
+	for i := 0; i < 60; i++ {
+		remedyNames = append(remedyNames, fmt.Sprintf("remedy_%02d", i))
+	}
+	previous := &createPoliciesData().Config
+	next := &createPoliciesData(remedyNames...).Config
+
+	diff := config.DiffRemedies(previous, next)
+	assert.Len(t, diff.Added, 50)
+	assert.Equal(t, "global/remedy_00", diff.Added[0])
+	assert.Equal(t, 10, diff.Truncated)
+}
+
+func TestConfigChangeRecorderRecordsAppliedAndRejectedChanges(t *testing.T) {
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	reported := []network.ConfigChangeData{}
+	recorder := config.NewConfigChangeRecorder(clock, meter,
+		func(change network.ConfigChangeData) {
+			reported = append(reported, change)
+		})
+
+	recorder.Applied(&createPoliciesData("a").Config, &createPoliciesData("b").Config)
+	recorder.Rejected(errors.New("invalid remedy"), true)
+
+	require.Len(t, reported, 2)
+	assert.True(t, reported[0].Applied)
+	assert.Equal(t, []string{"global/b"}, reported[0].Remedies.Added)
+	assert.False(t, reported[1].Applied)
+	assert.Equal(t, "invalid remedy", reported[1].Error)
+	assert.True(t, reported[1].PriorConfigRetained)
+	assert.NotEmpty(t, reported[1].CreatedAt)
+
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+	changes := map[string]int64{}
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, recordedMetric := range scopeMetrics.Metrics {
+			if recordedMetric.Name != "lunar_config.changes" {
+				continue
+			}
+			sum, ok := recordedMetric.Data.(metricdata.Sum[int64])
+			require.True(t, ok)
+			for _, point := range sum.DataPoints {
+				result, _ := point.Attributes.Value("result")
+				changes[result.AsString()] += point.Value
+			}
+		}
+	}
+	assert.Equal(t, map[string]int64{"applied": 1, "rejected": 1}, changes)
+}
+
+func TestUpdateRawDataRecordsRejectedChangeRetainingPriorConfig(t *testing.T) {
+	policiesData := createPoliciesData("remedy_a")
+	txnPoliciesAccessor := config.NewTxnPoliciesAccessor(policiesData)
+	reported := []network.ConfigChangeData{}
+	txnPoliciesAccessor.SetChangeRecorder(config.NewConfigChangeRecorder(
+		clock.NewMockClock(),
+		sdkMetric.NewMeterProvider().Meter("test"),
+		func(change network.ConfigChangeData) {
+			reported = append(reported, change)
+		},
+	))
+
+	err := txnPoliciesAccessor.UpdateRawData([]byte("global: ["))
+	require.Error(t, err)
+
+	require.Len(t, reported, 1)
+	assert.False(t, reported[0].Applied)
+	assert.True(t, reported[0].PriorConfigRetained)
+	assert.Equal(t, policiesData,
+		txnPoliciesAccessor.GetTxnPoliciesData(config.TxnID("1")))
+}
+
+func TestChangeAppliedListenerIsCalledWithThePoliciesInEffect(t *testing.T) {
+	initValidations()
+	t.Setenv("LUNAR_PROXY_POLICIES_CONFIG", filepath.Join(t.TempDir(), "policies.yaml"))
+	txnPoliciesAccessor := config.NewTxnPoliciesAccessor(createPoliciesData("remedy_a"))
+	applied := []string{}
+	txnPoliciesAccessor.OnChangeApplied(func(policiesData *config.PoliciesData) {
+		applied = append(applied, candidateRemedyName(policiesData))
+	})
+
+	require.Error(t, txnPoliciesAccessor.UpdateRawData([]byte("global: [")))
+	assert.Empty(t, applied)
+
+	require.NoError(t, txnPoliciesAccessor.UpdateRawData([]byte(candidateRawData)))
+	assert.Equal(t, []string{"remedy_c"}, applied)
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	sharedConfig "lunar/shared-model/config"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// configVersionLength is the number of hex characters a config version
+	// is truncated to, enough to tell loaded configs apart
+	configVersionLength = 12
+	// UnknownConfigVersion is the version of a config which could not be hashed
+	UnknownConfigVersion = "unknown"
+)
+
+// ComputeConfigVersion returns a stable hash of the given policies config,
+// equal for equal configs regardless of the formatting they were loaded from
+func ComputeConfigVersion(config *sharedConfig.PoliciesConfig) string {
+	// Maps are marshalled with sorted keys, so the output is stable
+	canonical, err := yaml.Marshal(config)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to compute the config version")
+		return UnknownConfigVersion
+	}
+	hash := sha256.Sum256(canonical)
+	return hex.EncodeToString(hash[:])[:configVersionLength]
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"context"
+	"lunar/toolkit-core/otel"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	deprecatedFieldsMetricName = "lunar_config.deprecated_fields"
+	deprecatedFieldKey         = "field"
+	anyListItem                = "[]"
+)
+
+// DeprecatedField is a field of the policies config which still works,
+// but is planned to be removed in favor of its replacement
+type DeprecatedField struct {
+	// Path is the YAML path of the field, with `[]` standing for any item
+	// of a list (e.g. `endpoints[].remedies[].config.retry.attempts`)
+	Path        string
+	Replacement string
+}
+
+// deprecatedFields is the registry of deprecated fields. Once a field is
+// deprecated, add it here until it is removed, so operators using it
+// are warned on every load of the policies config.
+var deprecatedFields = []DeprecatedField{}
+
+// warnDeprecatedFields warns about the deprecated fields a loaded config uses
+func warnDeprecatedFields(data []byte) {
+	newDeprecationWarner(otel.GetMeter(), deprecatedFields).warn(data)
+}
+
+// deprecationWarner warns about the deprecated fields a config uses,
+// to the logs and a metric counting the loads using each field
+type deprecationWarner struct {
+	fields []DeprecatedField
+	uses   metric.Int64Counter
+}
+
+func newDeprecationWarner(
+	meter metric.Meter,
+	fields []DeprecatedField,
+) *deprecationWarner {
+	uses, err := meter.Int64Counter(
+		deprecatedFieldsMetricName,
+		metric.WithDescription("Loads of the policies config "+
+			"using a deprecated field, by field"),
+		metric.WithUnit("{load}"),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to initialize %s metric",
+			deprecatedFieldsMetricName)
+	}
+	return &deprecationWarner{fields: fields, uses: uses}
+}
+
+// warn warns once about each deprecated field the config uses,
+// however many times it is used, and returns the fields used
+func (warner *deprecationWarner) warn(data []byte) []DeprecatedField {
+	used := []DeprecatedField{}
+	var document yaml.Node
+	if len(warner.fields) == 0 || yaml.Unmarshal(data, &document) != nil ||
+		len(document.Content) == 0 {
+		return used
+	}
+	for _, field := range warner.fields {
+		lines := fieldLines(document.Content[0], field.Path)
+		if len(lines) == 0 {
+			continue
+		}
+		used = append(used, field)
+		log.Warn().
+			Str("field", field.Path).
+			Str("replacement", field.Replacement).
+			Ints("lines", lines).
+			Msg("Policies config uses a deprecated field, " +
+				"it will be removed in a future version")
+		if warner.uses != nil {
+			warner.uses.Add(context.Background(), 1, metric.WithAttributes(
+				attribute.String(deprecatedFieldKey, field.Path)))
+		}
+	}
+	return used
+}
+
+// fieldLines returns the lines the field in the given path is set at
+func fieldLines(node *yaml.Node, path string) []int {
+	nodes := []*yaml.Node{node}
+	lines := []int{}
+	segments := strings.Split(path, ".")
+	for index, segment := range segments {
+		key, isList := strings.CutSuffix(segment, anyListItem)
+		next := []*yaml.Node{}
+		for _, parent := range nodes {
+			if parent.Kind != yaml.MappingNode {
+				continue
+			}
+			for i := 0; i+1 < len(parent.Content); i += 2 {
+				if parent.Content[i].Value != key {
+					continue
+				}
+				value := parent.Content[i+1]
+				if index == len(segments)-1 {
+					lines = append(lines, parent.Content[i].Line)
+				}
+				if !isList {
+					next = append(next, value)
+				} else if value.Kind == yaml.SequenceNode {
+					next = append(next, value.Content...)
+				}
+			}
+		}
+		nodes = next
+	}
+	return lines
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"gopkg.in/yaml.v3"
+)
+
+const policiesWithDeprecatedField = `
+endpoints:
+  - url: api.com/users
+    method: GET
+    remedies:
+      - name: first retry
+        config:
+          retry:
+            attempts: 3
+      - name: second retry
+        config:
+          retry:
+            attempts: 5
+  - url: api.com/orders
+    method: GET
+    remedies:
+      - name: third retry
+        config:
+          retry:
+            attempts: 2
+`
+
+var testDeprecatedFields = []DeprecatedField{
+	{
+		Path:        "endpoints[].remedies[].config.retry.attempts",
+		Replacement: "endpoints[].remedies[].config.retry.max_attempts",
+	},
+	{
+		Path:        "global.remedies[].config.retry.attempts",
+		Replacement: "global.remedies[].config.retry.max_attempts",
+	},
+}
+
+func deprecatedFieldUses(
+	t *testing.T,
+	reader *sdkMetric.ManualReader,
+) map[string]int64 {
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+	uses := map[string]int64{}
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, recordedMetric := range scopeMetrics.Metrics {
+			if recordedMetric.Name != deprecatedFieldsMetricName {
+				continue
+			}
+			sum, ok := recordedMetric.Data.(metricdata.Sum[int64])
+			require.True(t, ok)
+			for _, point := range sum.DataPoints {
+				field, _ := point.Attributes.Value(deprecatedFieldKey)
+				uses[field.AsString()] += point.Value
+			}
+		}
+	}
+	return uses
+}
+
+func TestDeprecatedFieldIsWarnedAboutOncePerLoad(t *testing.T) {
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	warner := newDeprecationWarner(meter, testDeprecatedFields)
+
+	used := warner.warn([]byte(policiesWithDeprecatedField))
+
+	assert.Equal(t, testDeprecatedFields[:1], used)
+	assert.Equal(t, map[string]int64{
+		"endpoints[].remedies[].config.retry.attempts": 1,
+	}, deprecatedFieldUses(t, reader))
+
+	// Each load warns again
+	warner.warn([]byte(policiesWithDeprecatedField))
+	assert.Equal(t, map[string]int64{
+		"endpoints[].remedies[].config.retry.attempts": 2,
+	}, deprecatedFieldUses(t, reader))
+}
+
+func TestConfigWithoutDeprecatedFieldsIsNotWarnedAbout(t *testing.T) {
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	warner := newDeprecationWarner(meter, testDeprecatedFields)
+
+	used := warner.warn([]byte(`
+global:
+  remedies:
+    - name: retry
+      config:
+        retry:
+          cooldown_multiplier: 2
+`))
+
+	assert.Empty(t, used)
+	assert.Empty(t, deprecatedFieldUses(t, reader))
+}
+
+func TestDeprecatedFieldLinesAreFound(t *testing.T) {
+	linter := policiesLinter{file: "policies.yaml", issues: []LintIssue{}} //nolint:exhaustruct
+	var document yaml.Node
+	require.NoError(t, yaml.Unmarshal([]byte(policiesWithDeprecatedField), &document))
+	linter.root = &document
+
+	linter.lintDeprecatedFields(testDeprecatedFields)
+
+	lines := []int{}
+	for _, issue := range linter.issues {
+		assert.Equal(t, LintWarning, issue.Severity)
+		lines = append(lines, issue.Line)
+	}
+	assert.Equal(t, []int{9, 13, 20}, lines)
+}
@@ -100,3 +100,18 @@ func TestWhenExporterTypeIsCalledWithUnknownExportThenExporterUndefinedIsReturne
 
 	assert.Equal(t, sharedConfig.ExporterUndefined, exporterType)
 }
+
+func TestWhenParseExporterTypeIsCalledWithExporterNamesThenTheirTypesAreReturned(
+	t *testing.T,
+) {
+	assert.Equal(t, sharedConfig.ExporterFile,
+		sharedConfig.ParseExporterType(" File "))
+	assert.Equal(t, sharedConfig.ExporterS3,
+		sharedConfig.ParseExporterType("s3"))
+	assert.Equal(t, sharedConfig.ExporterS3Minio,
+		sharedConfig.ParseExporterType("s3_minio"))
+	assert.Equal(t, sharedConfig.ExporterUndefined,
+		sharedConfig.ParseExporterType(""))
+	assert.Equal(t, sharedConfig.ExporterUndefined,
+		sharedConfig.ParseExporterType("syslog"))
+}
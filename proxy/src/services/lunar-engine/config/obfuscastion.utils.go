@@ -43,6 +43,26 @@ func ShouldObfuscatePathParam(
 	}
 }
 
+// ObfuscateForResponseStatus returns the obfuscation config which applies
+// to a transaction with the given response status: obfuscation is disabled
+// if the config has conditions and the status matches none of them
+func ObfuscateForResponseStatus(
+	config sharedConfig.Obfuscate,
+	status int,
+) sharedConfig.Obfuscate {
+	if !config.Enabled || config.Conditions == nil ||
+		len(config.Conditions.StatusCode) == 0 {
+		return config
+	}
+	for _, statusRange := range config.Conditions.StatusCode {
+		if status >= statusRange.From && status <= statusRange.To {
+			return config
+		}
+	}
+	config.Enabled = false
+	return config
+}
+
 func shouldObfuscate(value string, exclusionList []string) bool {
 	return !slices.Contains(exclusionList, strings.TrimSpace(value))
 }
@@ -32,16 +32,29 @@ type TxnID string
 type PoliciesData struct {
 	Config             sharedConfig.PoliciesConfig
 	EndpointPolicyTree EndpointPolicyTree
+	// ConfigVersion is the hash of Config, computed once it is loaded
+	ConfigVersion string
 }
 
 type TxnPoliciesAccessor struct {
-	currentVersion         PoliciesVersion
+	currentVersion PoliciesVersion
+	// the latest version set, which is ahead of the current one
+	// while a candidate version of a canary runs
+	latestVersion          PoliciesVersion
 	policiesVersions       map[PoliciesVersion]*PoliciesData
 	txnVersions            map[TxnID]PoliciesVersion
 	txnVersionsVacuum      *vacuum.MapVacuum[TxnID, PoliciesVersion]
 	policiesVersionsVacuum *vacuum.MapVacuum[PoliciesVersion, *PoliciesData]
 	mutex                  *sync.RWMutex
 	clock                  clock.Clock
+	// nil unless config changes should be recorded
+	changeRecorder *ConfigChangeRecorder
+	// nil unless applied config changes should be listened to
+	onChangeApplied func(*PoliciesData)
+	// nil unless a canary config runs
+	canary *canaryRollout
+	// nil unless canary transactions should be recorded
+	canaryMetrics *CanaryMetrics
 }
 
 type PoliciesAccessor interface {
@@ -65,26 +78,73 @@ func (txnPoliciesAccessor *TxnPoliciesAccessor) GetTxnPoliciesData(
 	return policies
 }
 
+// SetChangeRecorder records the reloads from now on with the given recorder
+func (txnPoliciesAccessor *TxnPoliciesAccessor) SetChangeRecorder(
+	recorder *ConfigChangeRecorder,
+) {
+	txnPoliciesAccessor.changeRecorder = recorder
+}
+
+// OnChangeApplied calls the given callback with the policies in effect
+// whenever a change replaced them from now on
+func (txnPoliciesAccessor *TxnPoliciesAccessor) OnChangeApplied(
+	callback func(*PoliciesData),
+) {
+	txnPoliciesAccessor.onChangeApplied = callback
+}
+
 func (txnPoliciesAccessor *TxnPoliciesAccessor) ReloadFromFile() error {
-	newPoliciesData, err := loadDataFromFile()
-	if err != nil {
-		return err
-	}
-	log.Debug().Msgf("Loaded policies data from file: %+v", *newPoliciesData)
-	return txnPoliciesAccessor.UpdatePoliciesData(newPoliciesData)
+	return txnPoliciesAccessor.recordChange(func() error {
+		newPoliciesData, err := loadDataFromFile()
+		if err != nil {
+			return err
+		}
+		log.Debug().Msgf("Loaded policies data from file: %+v", *newPoliciesData)
+		return txnPoliciesAccessor.UpdatePoliciesData(newPoliciesData)
+	})
 }
 
 func (txnPoliciesAccessor *TxnPoliciesAccessor) UpdateRawData(
 	rawData []byte,
 ) error {
-	configPolicy, err := configuration.UnmarshalPolicyRawData[sharedConfig.PoliciesConfig](rawData)
+	return txnPoliciesAccessor.recordChange(func() error {
+		return txnPoliciesAccessor.updateRawData(rawData)
+	})
+}
+
+// recordChange records the outcome of the given reload. A failed reload
+// retained the prior config unless it failed once a new version was set.
+func (txnPoliciesAccessor *TxnPoliciesAccessor) recordChange(
+	reload func() error,
+) error {
+	previousVersion, previous := txnPoliciesAccessor.getCurrentVersionData()
+	err := reload()
+	currentVersion, current := txnPoliciesAccessor.getCurrentVersionData()
 	if err != nil {
+		txnPoliciesAccessor.changeRecorder.Rejected(err,
+			currentVersion == previousVersion)
 		return err
 	}
-	if err = Validate(configPolicy.UnmarshaledData); err != nil {
-		return err
+	txnPoliciesAccessor.changeRecorder.Applied(&previous.Config, &current.Config)
+	if txnPoliciesAccessor.onChangeApplied != nil {
+		txnPoliciesAccessor.onChangeApplied(current)
 	}
-	policyData, err := BuildPolicyData(configPolicy.UnmarshaledData)
+	return nil
+}
+
+func (txnPoliciesAccessor *TxnPoliciesAccessor) getCurrentVersionData() (
+	PoliciesVersion, *PoliciesData,
+) {
+	txnPoliciesAccessor.mutex.RLock()
+	version := txnPoliciesAccessor.currentVersion
+	txnPoliciesAccessor.mutex.RUnlock()
+	return version, txnPoliciesAccessor.getCurrentPoliciesData()
+}
+
+func (txnPoliciesAccessor *TxnPoliciesAccessor) updateRawData(
+	rawData []byte,
+) error {
+	policyData, err := buildRawPoliciesData(rawData)
 	if err != nil {
 		return err
 	}
@@ -99,6 +159,19 @@ func (txnPoliciesAccessor *TxnPoliciesAccessor) UpdateRawData(
 	return os.WriteFile(filePath, rawData, 0o644)
 }
 
+// buildRawPoliciesData validates the given policies config and builds its data
+func buildRawPoliciesData(rawData []byte) (*PoliciesData, error) {
+	configPolicy, err := configuration.UnmarshalPolicyRawData[sharedConfig.PoliciesConfig](rawData)
+	if err != nil {
+		return nil, err
+	}
+	if err = Validate(configPolicy.UnmarshaledData); err != nil {
+		return nil, err
+	}
+	warnDeprecatedFields(configPolicy.Content)
+	return BuildPolicyData(configPolicy.UnmarshaledData)
+}
+
 func (txnPoliciesAccessor *TxnPoliciesAccessor) UpdatePoliciesData(
 	newPoliciesData *PoliciesData,
 ) error {
@@ -112,6 +185,8 @@ func (txnPoliciesAccessor *TxnPoliciesAccessor) UpdatePoliciesData(
 		return fmt.Errorf("failed to initialize HAProxy endpoints: %v", err)
 	}
 
+	startRemedyWarmups(&previousConfig, &newPoliciesData.Config,
+		txnPoliciesAccessor.clock.Now())
 	newPoliciesVersion := txnPoliciesAccessor.setNextVersion(newPoliciesData)
 
 	// Unmanaging HAProxy endpoints should occur after all possible
@@ -132,13 +207,17 @@ func (txnPoliciesAccessor *TxnPoliciesAccessor) setTxnVersion(
 	txnID TxnID,
 ) PoliciesVersion {
 	txnPoliciesAccessor.mutex.Lock()
-	currentVersion := txnPoliciesAccessor.currentVersion
-	txnPoliciesAccessor.txnVersions[txnID] = currentVersion
+	txnVersion := txnPoliciesAccessor.currentVersion
+	canary := txnPoliciesAccessor.canary
+	if canary != nil && canary.samples(txnID) {
+		txnVersion = canary.version
+	}
+	txnPoliciesAccessor.txnVersions[txnID] = txnVersion
 	txnPoliciesAccessor.mutex.Unlock()
 
 	txnPoliciesAccessor.txnVersionsVacuum.VacuumKey(txnID)
 
-	return currentVersion
+	return txnVersion
 }
 
 func (txnPoliciesAccessor *TxnPoliciesAccessor) setNextVersion(
@@ -146,11 +225,20 @@ func (txnPoliciesAccessor *TxnPoliciesAccessor) setNextVersion(
 ) PoliciesVersion {
 	txnPoliciesAccessor.mutex.Lock()
 	previousVersion := txnPoliciesAccessor.currentVersion
-	txnPoliciesAccessor.currentVersion++
+	txnPoliciesAccessor.latestVersion++
+	txnPoliciesAccessor.currentVersion = txnPoliciesAccessor.latestVersion
 	txnPoliciesAccessor.policiesVersions[txnPoliciesAccessor.currentVersion] = policiesData
+	// A running canary was compared with the replaced config, so it ends
+	canary := txnPoliciesAccessor.canary
+	txnPoliciesAccessor.canary = nil
 	txnPoliciesAccessor.mutex.Unlock()
 
 	txnPoliciesAccessor.policiesVersionsVacuum.VacuumKey(previousVersion)
+	if canary != nil {
+		log.Warn().Msgf("Policies config was reloaded while canary config "+
+			"version %d was running, it is rolled back", canary.version)
+		txnPoliciesAccessor.rolledBack(canary, policiesData)
+	}
 
 	return previousVersion
 }
@@ -177,6 +265,8 @@ func ScheduleUnmanageHAProxyEndpoints(haproxyEndpointsToRemove []string) {
 	}
 	go func() {
 		clock.Sleep(staleVersionTTL)
+		// Endpoints pinned meanwhile are kept managed
+		haproxyEndpointsToRemove := unpinnedEndpoints(haproxyEndpointsToRemove)
 		err := unmanageHAProxyEndpoints(haproxyEndpointsToRemove)
 		if err != nil {
 			log.Error().Err(err).Msgf("Failed to unmanage HAProxy endpoints")
@@ -188,6 +278,24 @@ func ScheduleUnmanageHAProxyEndpoints(haproxyEndpointsToRemove []string) {
 	}()
 }
 
+// HAProxyEndpoints returns the endpoints managed for the current config,
+// along with the ones of the candidate config if a canary runs
+func (txnPoliciesAccessor *TxnPoliciesAccessor) HAProxyEndpoints() *HAProxyEndpointsRequest {
+	endpoints := BuildHAProxyEndpointsRequest(
+		&txnPoliciesAccessor.getCurrentPoliciesData().Config)
+	if canary := txnPoliciesAccessor.getCanary(); canary != nil {
+		endpoints = mergeHAProxyEndpointsRequests(endpoints,
+			BuildHAProxyEndpointsRequest(&canary.data.Config))
+	}
+	return endpoints
+}
+
+// GetCurrentPoliciesData returns the current policies,
+// without anchoring any transaction to their version
+func (txnPoliciesAccessor *TxnPoliciesAccessor) GetCurrentPoliciesData() *PoliciesData {
+	return txnPoliciesAccessor.getCurrentPoliciesData()
+}
+
 func (txnPoliciesAccessor *TxnPoliciesAccessor) getCurrentPoliciesData() *PoliciesData {
 	txnPoliciesAccessor.mutex.RLock()
 	value, found := txnPoliciesAccessor.policiesVersions[txnPoliciesAccessor.currentVersion]
@@ -260,12 +368,17 @@ func NewTxnPoliciesAccessor(policiesData *PoliciesData) TxnPoliciesAccessor {
 	)
 	return TxnPoliciesAccessor{
 		currentVersion:         1,
+		latestVersion:          1,
 		policiesVersions:       policiesVersions,
 		txnVersions:            txnVersions,
 		txnVersionsVacuum:      &txnVersionsVacuum,
 		policiesVersionsVacuum: &policiesVersionsVacuum,
 		mutex:                  &mutex,
 		clock:                  clock,
+		changeRecorder:         nil,
+		onChangeApplied:        nil,
+		canary:                 nil,
+		canaryMetrics:          nil,
 	}
 }
 
@@ -355,6 +468,15 @@ func getRemedyConfig(config sharedConfig.RemedyConfig) any {
 	if config.Retry != nil {
 		return config.Retry
 	}
+	if config.ResponseBodyRewrite != nil {
+		return config.ResponseBodyRewrite
+	}
+	if config.DecisionHook != nil {
+		return config.DecisionHook
+	}
+	if config.QueryParamMasking != nil {
+		return config.QueryParamMasking
+	}
 	return nil
 }
 
@@ -403,6 +525,8 @@ func logAccounts(config *sharedConfig.PoliciesConfig) {
 func BuildPolicyData(config *sharedConfig.PoliciesConfig) (
 	*PoliciesData, error,
 ) {
+	resolveErrorTemplates(config)
+	compilePrioritizations(config)
 	policyTree, err := BuildEndpointPolicyTree(config.Endpoints)
 	if err != nil {
 		return nil, errors.Join(errors.New("failed to build policy tree"), err)
@@ -411,9 +535,47 @@ func BuildPolicyData(config *sharedConfig.PoliciesConfig) (
 	return &PoliciesData{
 		Config:             *config,
 		EndpointPolicyTree: *policyTree,
+		ConfigVersion:      ComputeConfigVersion(config),
 	}, nil
 }
 
+// resolveErrorTemplates sets the error template referenced by each remedy,
+// so remedies do not need the whole policies to build their responses
+func resolveErrorTemplates(config *sharedConfig.PoliciesConfig) {
+	resolve := func(remedies []sharedConfig.Remedy) {
+		for index := range remedies {
+			remedies[index].ResolvedErrorTemplate = nil
+			template, found := config.ErrorTemplates[remedies[index].ErrorTemplate]
+			if remedies[index].ErrorTemplate != "" && found {
+				remedies[index].ResolvedErrorTemplate = &template
+			}
+		}
+	}
+
+	resolve(config.Global.Remedies)
+	for _, endpoint := range config.Endpoints {
+		resolve(endpoint.Remedies)
+	}
+}
+
+// compilePrioritizations compiles the prioritization of each remedy once,
+// so requests are prioritized without compiling it
+func compilePrioritizations(config *sharedConfig.PoliciesConfig) {
+	compile := func(remedies []sharedConfig.Remedy) {
+		for _, remedy := range remedies {
+			queueConfig := remedy.Config.StrategyBasedQueue
+			if queueConfig != nil && queueConfig.Prioritization != nil {
+				queueConfig.Prioritization.Compile()
+			}
+		}
+	}
+
+	compile(config.Global.Remedies)
+	for _, endpoint := range config.Endpoints {
+		compile(endpoint.Remedies)
+	}
+}
+
 func notifyEnabledPlugins(config *sharedConfig.PoliciesConfig) {
 	var enabledPlugins []string
 	enabledPlugins = append(enabledPlugins,
@@ -7,6 +7,7 @@ import (
 
 	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
 )
 
 func TestGetTxnPoliciesDataReturnsCurrentPoliciesWhenTxnIsNew(t *testing.T) {
@@ -63,6 +64,34 @@ func TestUpdatePoliciesDataDoesNotFailWhenExportersDataChanges(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestComputeConfigVersionIsStableHashOfConfig(t *testing.T) {
+	parse := func(raw string) *sharedConfig.PoliciesConfig {
+		var policies sharedConfig.PoliciesConfig
+		assert.Nil(t, yaml.Unmarshal([]byte(raw), &policies))
+		return &policies
+	}
+	version := config.ComputeConfigVersion(parse(`
+global:
+  remedies:
+    - name: remedy_a
+      enabled: true
+`))
+	reformatted := config.ComputeConfigVersion(parse(`
+global:
+    remedies: [{enabled: true, name: remedy_a}]
+`))
+	changed := config.ComputeConfigVersion(parse(`
+global:
+  remedies:
+    - name: remedy_a
+      enabled: false
+`))
+
+	assert.Len(t, version, 12)
+	assert.Equal(t, version, reformatted)
+	assert.NotEqual(t, version, changed)
+}
+
 func createPoliciesData(remedyNames ...string) *config.PoliciesData {
 	remedies := lo.Map(
 		remedyNames,
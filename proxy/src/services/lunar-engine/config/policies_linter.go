@@ -0,0 +1,407 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"lunar/engine/utils/environment"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/urltree"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"gopkg.in/yaml.v3"
+)
+
+type LintSeverity string
+
+const (
+	LintError   LintSeverity = "error"
+	LintWarning LintSeverity = "warning"
+)
+
+// LintIssue is a single problem found in a policies configuration file.
+// Line is 0 when the issue cannot be pinned to a specific line.
+type LintIssue struct {
+	File     string
+	Line     int
+	Severity LintSeverity
+	Message  string
+}
+
+func (issue LintIssue) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s",
+		issue.File, issue.Line, issue.Severity, issue.Message)
+}
+
+// HasLintErrors reports whether any of the given issues is an error
+func HasLintErrors(issues []LintIssue) bool {
+	for _, issue := range issues {
+		if issue.Severity == LintError {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	namespaceSegmentExp = regexp.MustCompile(`^([^\[]*)(?:\[(.*)\])?$`)
+	yamlErrorLineExp    = regexp.MustCompile(`line (\d+)`)
+)
+
+// Lint loads the policies configuration in the given path and runs on it
+// the same validations used on load, along with a few checks which are
+// only reported offline. All issues found are returned, ordered by line.
+// The returned error is set only if the file could not be linted at all.
+func Lint(path string) ([]LintIssue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policies file: %w", err)
+	}
+	if err := RegisterValidations(); err != nil {
+		return nil, fmt.Errorf("failed to register config validation: %w", err)
+	}
+
+	linter := policiesLinter{file: path, issues: []LintIssue{}} //nolint:exhaustruct
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		linter.reportYAMLError(err)
+		return linter.issues, nil
+	}
+	linter.root = &document
+
+	var policiesConfig sharedConfig.PoliciesConfig
+	if len(document.Content) > 0 {
+		if err := document.Decode(&policiesConfig); err != nil {
+			linter.reportYAMLError(err)
+			return linter.issues, nil
+		}
+	}
+
+	linter.lintValidations(policiesConfig)
+	linter.lintEnvReferences(policiesConfig)
+	linter.lintPrioritizations(policiesConfig)
+	linter.lintOverlappingScopes(policiesConfig)
+	linter.lintDuplicatePolicyNames(policiesConfig)
+	linter.lintDeprecatedFields(deprecatedFields)
+
+	sort.SliceStable(linter.issues, func(i, j int) bool {
+		return linter.issues[i].Line < linter.issues[j].Line
+	})
+	return linter.issues, nil
+}
+
+type policiesLinter struct {
+	file   string
+	root   *yaml.Node
+	issues []LintIssue
+}
+
+func (linter *policiesLinter) report(
+	line int,
+	severity LintSeverity,
+	format string,
+	args ...any,
+) {
+	linter.issues = append(linter.issues, LintIssue{
+		File:     linter.file,
+		Line:     line,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (linter *policiesLinter) reportYAMLError(err error) {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		linter.report(yamlErrorLine(err.Error()), LintError, "%s", err.Error())
+		return
+	}
+	for _, message := range typeErr.Errors {
+		linter.report(yamlErrorLine(message), LintError, "%s", message)
+	}
+}
+
+func (linter *policiesLinter) lintValidations(
+	policiesConfig sharedConfig.PoliciesConfig,
+) {
+	validateErr := sharedConfig.Validate.Struct(policiesConfig)
+	var vErrs validator.ValidationErrors
+	if errors.As(validateErr, &vErrs) {
+		for _, vErr := range vErrs {
+			// Reported along with the line of each duplicate
+			// by lintDuplicatePolicyNames
+			if vErr.Tag() == duplicatePolicyName {
+				continue
+			}
+			// Reported along with what the group by lacks
+			// by lintPrioritizations
+			if vErr.Field() == "GroupBy" && strings.HasPrefix(vErr.Tag(), "required") {
+				continue
+			}
+			linter.report(
+				linter.lineOf(yamlPathOf(vErr.StructNamespace())),
+				LintError,
+				"%s",
+				validationError(vErr, environment.IsLogLevelDebug()).Error(),
+			)
+		}
+	} else if validateErr != nil {
+		linter.report(0, LintError, "%s", validateErr.Error())
+	}
+}
+
+// lintEnvReferences reports account and challenge client values referencing
+// env vars (`${{VAR}}`) which cannot be loaded from the current environment,
+// the same way they are loaded when the policies are applied
+func (linter *policiesLinter) lintEnvReferences(
+	policiesConfig sharedConfig.PoliciesConfig,
+) {
+	for accountID, account := range policiesConfig.Accounts {
+		if err := account.Authentication.LoadEnvValues(); err != nil {
+			linter.report(
+				linter.lineOf([]string{"accounts", string(accountID), "authentication"}),
+				LintError,
+				"account '%s': %s", accountID, err.Error())
+		}
+	}
+	forEachAuthChallenge(&policiesConfig,
+		func(path []string, remedyName string, challenge *sharedConfig.AuthChallengeConfig) {
+			if err := challenge.LoadEnvValues(); err != nil {
+				linter.report(linter.lineOf(path), LintError,
+					"remedy '%s' challenge: %s", remedyName, err.Error())
+			}
+		})
+}
+
+func (linter *policiesLinter) lintPrioritizations(
+	policiesConfig sharedConfig.PoliciesConfig,
+) {
+	lintRemedies := func(remedies []sharedConfig.Remedy, path []string) {
+		for index, remedy := range remedies {
+			queueConfig := remedy.Config.StrategyBasedQueue
+			if queueConfig == nil || queueConfig.Prioritization == nil {
+				continue
+			}
+			prioritizationPath := append(append([]string{}, path...),
+				strconv.Itoa(index), "config", "strategy_based_queue",
+				"prioritization")
+			line := linter.lineOf(prioritizationPath)
+			dimensions := queueConfig.Prioritization.AllDimensions()
+
+			if len(dimensions) == 0 && queueConfig.Prioritization.Expression == "" {
+				linter.report(line, LintError,
+					"remedy '%s' prioritization has no group_by, "+
+						"dimensions or expression",
+					remedy.Name)
+			}
+			for _, dimension := range dimensions {
+				groupBy := dimension.GroupBy
+				switch {
+				case groupBy.HeaderName == "" && len(groupBy.PathPatterns) == 0:
+					linter.report(line, LintError,
+						"remedy '%s' prioritization has no group_by.header_name "+
+							"or group_by.path_patterns",
+						remedy.Name)
+				case groupBy.HeaderName != "" && len(groupBy.PathPatterns) > 0:
+					linter.report(line, LintError,
+						"remedy '%s' prioritization groups by both header '%s' "+
+							"and path patterns, only one can be used per dimension",
+						remedy.Name, groupBy.HeaderName)
+				}
+				if len(dimension.Groups) == 0 {
+					linter.report(line, LintWarning,
+						"remedy '%s' prioritization by '%s' has no groups",
+						remedy.Name, groupBy.Source())
+				}
+				for _, pathPattern := range groupBy.PathPatterns {
+					if _, found := dimension.Groups[pathPattern.Group]; !found {
+						linter.report(line, LintWarning,
+							"remedy '%s' prioritization path pattern '%s' "+
+								"refers to undefined group '%s'",
+							remedy.Name, pathPattern.Pattern, pathPattern.Group)
+					}
+				}
+			}
+		}
+	}
+
+	lintRemedies(policiesConfig.Global.Remedies, []string{"global", "remedies"})
+	for index, endpoint := range policiesConfig.Endpoints {
+		lintRemedies(endpoint.Remedies,
+			[]string{"endpoints", strconv.Itoa(index), "remedies"})
+	}
+}
+
+// lintOverlappingScopes reports endpoints which are defined more than once,
+// either exactly or up to the naming of their path parameters
+func (linter *policiesLinter) lintOverlappingScopes(
+	policiesConfig sharedConfig.PoliciesConfig,
+) {
+	firstSeen := map[string]int{}
+	for index, endpoint := range policiesConfig.Endpoints {
+		line := linter.lineOf([]string{"endpoints", strconv.Itoa(index)})
+		scope := endpoint.Method + " " + normalizePathParams(endpoint.URL)
+		seenIndex, found := firstSeen[scope]
+		if !found {
+			firstSeen[scope] = index
+			continue
+		}
+
+		seenEndpoint := policiesConfig.Endpoints[seenIndex]
+		seenLine := linter.lineOf([]string{"endpoints", strconv.Itoa(seenIndex)})
+		if seenEndpoint.URL == endpoint.URL {
+			linter.report(line, LintError,
+				"endpoint %s %s is already defined at line %d",
+				endpoint.Method, endpoint.URL, seenLine)
+			continue
+		}
+		linter.report(line, LintWarning,
+			"endpoint %s %s overlaps with endpoint %s %s defined at line %d",
+			endpoint.Method, endpoint.URL,
+			seenEndpoint.Method, seenEndpoint.URL, seenLine)
+	}
+}
+
+// lintDuplicatePolicyNames reports policies named like a policy defined
+// before them. Names identify remedies in metrics and queues, so policies
+// sharing a name would have them merged.
+func (linter *policiesLinter) lintDuplicatePolicyNames(
+	policiesConfig sharedConfig.PoliciesConfig,
+) {
+	firstSeen := map[string]int{}
+	check := func(name string, path []string) {
+		line := linter.lineOf(path)
+		seenLine, found := firstSeen[name]
+		if !found {
+			firstSeen[name] = line
+			return
+		}
+		linter.report(line, LintError,
+			"policy name '%s' is already used at line %d", name, seenLine)
+	}
+
+	for index, remedy := range policiesConfig.Global.Remedies {
+		check(remedy.Name, []string{"global", "remedies", strconv.Itoa(index)})
+	}
+	for index, diagnosis := range policiesConfig.Global.Diagnosis {
+		check(diagnosis.Name, []string{"global", "diagnosis", strconv.Itoa(index)})
+	}
+	for endpointIndex, endpoint := range policiesConfig.Endpoints {
+		endpointPath := []string{"endpoints", strconv.Itoa(endpointIndex)}
+		for index, remedy := range endpoint.Remedies {
+			check(remedy.Name, append(append([]string{}, endpointPath...),
+				"remedies", strconv.Itoa(index)))
+		}
+		for index, diagnosis := range endpoint.Diagnosis {
+			check(diagnosis.Name, append(append([]string{}, endpointPath...),
+				"diagnosis", strconv.Itoa(index)))
+		}
+	}
+}
+
+// lintDeprecatedFields warns at each line a deprecated field is set at
+func (linter *policiesLinter) lintDeprecatedFields(fields []DeprecatedField) {
+	if linter.root == nil || len(linter.root.Content) == 0 {
+		return
+	}
+	for _, field := range fields {
+		for _, line := range fieldLines(linter.root.Content[0], field.Path) {
+			linter.report(line, LintWarning,
+				"%s is deprecated, use %s instead", field.Path, field.Replacement)
+		}
+	}
+}
+
+func normalizePathParams(url string) string {
+	parts := strings.Split(url, "/")
+	for index, part := range parts {
+		if _, isPathParam := urltree.TryExtractPathParameter(part); isPathParam {
+			parts[index] = "{}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// lineOf returns the line of the deepest node found along the given path
+func (linter *policiesLinter) lineOf(path []string) int {
+	if linter.root == nil || len(linter.root.Content) == 0 {
+		return 0
+	}
+	node := linter.root.Content[0]
+	line := node.Line
+	for _, key := range path {
+		node = childNode(node, key)
+		if node == nil {
+			break
+		}
+		line = node.Line
+	}
+	return line
+}
+
+func childNode(node *yaml.Node, key string) *yaml.Node {
+	switch node.Kind { //nolint:exhaustive
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return node.Content[i+1]
+			}
+		}
+	case yaml.SequenceNode:
+		index, err := strconv.Atoi(key)
+		if err == nil && index >= 0 && index < len(node.Content) {
+			return node.Content[index]
+		}
+	}
+	return nil
+}
+
+// yamlPathOf translates a validator struct namespace
+// (e.g. `PoliciesConfig.Endpoints[0].Method`) into the matching
+// YAML path (e.g. `endpoints`, `0`, `method`)
+func yamlPathOf(structNamespace string) []string {
+	path := []string{}
+	currentType := reflect.TypeOf(sharedConfig.PoliciesConfig{}) //nolint:exhaustruct
+	segments := strings.Split(structNamespace, ".")
+	for _, segment := range segments[1:] {
+		match := namespaceSegmentExp.FindStringSubmatch(segment)
+		if match == nil || match[1] == "" {
+			break
+		}
+		for currentType.Kind() == reflect.Pointer {
+			currentType = currentType.Elem()
+		}
+		if currentType.Kind() != reflect.Struct {
+			break
+		}
+		field, found := currentType.FieldByName(match[1])
+		if !found {
+			break
+		}
+		yamlName := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if yamlName == "" || yamlName == "-" {
+			break
+		}
+		path = append(path, yamlName)
+		currentType = field.Type
+		if match[2] != "" {
+			path = append(path, match[2])
+			currentType = currentType.Elem()
+		}
+	}
+	return path
+}
+
+func yamlErrorLine(message string) int {
+	match := yamlErrorLineExp.FindStringSubmatch(message)
+	if match == nil {
+		return 0
+	}
+	line, _ := strconv.Atoi(match[1])
+	return line
+}
@@ -0,0 +1,227 @@
+package config_test
+
+import (
+	"lunar/engine/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const validPoliciesYAML = `
+global:
+  remedies:
+    - name: queue
+      enabled: true
+      config:
+        strategy_based_queue:
+          allowed_request_count: 10
+          window_size_in_seconds: 10
+          response_status_code: 429
+          ttl_seconds: 5
+          queue_size: 10
+          prioritization:
+            group_by:
+              header_name: X-Group
+            groups:
+              production:
+                priority: 1
+endpoints:
+  - url: api.com/users/{id}
+    method: GET
+`
+
+func writePoliciesFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLintReturnsNoIssuesForValidConfig(t *testing.T) {
+	path := writePoliciesFile(t, validPoliciesYAML)
+
+	issues, err := config.Lint(path)
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+	assert.False(t, config.HasLintErrors(issues))
+}
+
+func TestLintFailsIfFileIsMissing(t *testing.T) {
+	_, err := config.Lint(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestLintReportsInvalidYAMLWithLine(t *testing.T) {
+	path := writePoliciesFile(t, "endpoints:\n  - url: api.com\n    method: GET\n  bad\n")
+
+	issues, err := config.Lint(path)
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	assert.Equal(t, config.LintError, issues[0].Severity)
+	assert.Equal(t, 4, issues[0].Line)
+}
+
+func TestLintReportsValidationErrorsWithLine(t *testing.T) {
+	path := writePoliciesFile(t, strings.Replace(validPoliciesYAML,
+		"allowed_request_count: 10", "allowed_request_count: 0", 1))
+
+	issues, err := config.Lint(path)
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	assert.Equal(t, config.LintError, issues[0].Severity)
+	assert.Equal(t, path, issues[0].File)
+	assert.Equal(t, 8, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "AllowedRequestCount")
+}
+
+func TestLintReportsUnsetEnvVarReferences(t *testing.T) {
+	path := writePoliciesFile(t, validPoliciesYAML+`
+accounts:
+  my-account:
+    authentication:
+      basic:
+        username: ${{LINT_TEST_UNSET_USERNAME}}
+        password: secret
+`)
+
+	issues, err := config.Lint(path)
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	assert.Equal(t, 26, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "LINT_TEST_UNSET_USERNAME")
+}
+
+func TestLintReportsEmptyEnvVarReferences(t *testing.T) {
+	t.Setenv("LINT_TEST_EMPTY_TOKEN", "")
+	t.Setenv("LINT_TEST_SET_TOKEN", "token")
+	path := writePoliciesFile(t, validPoliciesYAML+`
+accounts:
+  empty-account:
+    authentication:
+      api_key:
+        tokens:
+          - name: X-API-Key
+            value: ${{LINT_TEST_EMPTY_TOKEN}}
+  set-account:
+    authentication:
+      api_key:
+        tokens:
+          - name: X-API-Key
+            value: ${{LINT_TEST_SET_TOKEN}}
+    tokens:
+      - header:
+          name: X-Untemplated
+          value: ${{LINT_TEST_UNSET_TOKEN}}
+`)
+
+	issues, err := config.Lint(path)
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	assert.Equal(t, 26, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "empty-account")
+	assert.Contains(t, issues[0].Message, "LINT_TEST_EMPTY_TOKEN")
+}
+
+func TestLintReportsInconsistentPriorityGroups(t *testing.T) {
+	path := writePoliciesFile(t, strings.Replace(validPoliciesYAML,
+		"header_name: X-Group", `header_name: ""`, 1))
+
+	issues, err := config.Lint(path)
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	assert.Equal(t, config.LintError, issues[0].Severity)
+	assert.Contains(t, issues[0].Message, "group_by.header_name")
+}
+
+func TestLintAcceptsPriorityByPathPatterns(t *testing.T) {
+	path := writePoliciesFile(t, strings.Replace(validPoliciesYAML,
+		"header_name: X-Group", `path_patterns:
+                - pattern: /v1/{version}/production/*
+                  group: production`, 1))
+
+	issues, err := config.Lint(path)
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestLintReportsPathPatternOfUndefinedGroup(t *testing.T) {
+	path := writePoliciesFile(t, strings.Replace(validPoliciesYAML,
+		"header_name: X-Group", `path_patterns:
+                - pattern: /v1/bulk/*
+                  group: bulk`, 1))
+
+	issues, err := config.Lint(path)
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	assert.Equal(t, config.LintWarning, issues[0].Severity)
+	assert.Contains(t, issues[0].Message, "undefined group 'bulk'")
+}
+
+func TestLintAcceptsPriorityExpressionWithoutGroups(t *testing.T) {
+	path := writePoliciesFile(t, strings.Replace(validPoliciesYAML, `group_by:
+              header_name: X-Group
+            groups:
+              production:
+                priority: 1`, `expression: 'int(headers["X-Tier"])'`, 1))
+
+	issues, err := config.Lint(path)
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestLintReportsInvalidPriorityExpression(t *testing.T) {
+	path := writePoliciesFile(t, strings.Replace(validPoliciesYAML,
+		"prioritization:", `prioritization:
+            expression: 'int(headers["X-Tier"]'`, 1))
+
+	issues, err := config.Lint(path)
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	assert.Equal(t, config.LintError, issues[0].Severity)
+	assert.Contains(t, issues[0].Message, "invalid priority expression")
+}
+
+func TestLintReportsOverlappingScopes(t *testing.T) {
+	path := writePoliciesFile(t, validPoliciesYAML+`
+  - url: api.com/users/{user_id}
+    method: GET
+  - url: api.com/users/{id}
+    method: GET
+  - url: api.com/users/{id}
+    method: POST
+`)
+
+	issues, err := config.Lint(path)
+	require.NoError(t, err)
+	require.Len(t, issues, 2)
+
+	assert.Equal(t, config.LintWarning, issues[0].Severity)
+	assert.Equal(t, 23, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "overlaps")
+
+	assert.Equal(t, config.LintError, issues[1].Severity)
+	assert.Equal(t, 25, issues[1].Line)
+	assert.Contains(t, issues[1].Message, "already defined at line 20")
+	assert.True(t, config.HasLintErrors(issues))
+}
+
+func TestLintReportsDuplicatePolicyNamesWithLine(t *testing.T) {
+	path := writePoliciesFile(t, validPoliciesYAML+`    remedies:
+      - name: queue
+        enabled: true
+        config:
+          fixed_response:
+            status_code: 418
+`)
+
+	issues, err := config.Lint(path)
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	assert.Equal(t, config.LintError, issues[0].Severity)
+	assert.Equal(t, 23, issues[0].Line)
+	assert.Contains(t, issues[0].Message,
+		"policy name 'queue' is already used at line 4")
+}
@@ -4,12 +4,16 @@ import (
 	"errors"
 	"fmt"
 	"lunar/engine/utils/environment"
+	"lunar/engine/utils/expression"
+	"lunar/engine/utils/queue"
 	sharedConfig "lunar/shared-model/config"
 	"lunar/toolkit-core/configuration"
 	"lunar/toolkit-core/logic"
 	"lunar/toolkit-core/urltree"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 
@@ -25,8 +29,51 @@ var (
 	duplicatePolicyName = "duplicate_policy_name"
 	misalignedWindows   = "misaligned_windows"
 	missingPathParam    = "missing_path_param"
+	invalidExpression   = "invalid_expression"
+	undefinedTemplate   = "undefined_error_template"
+	tooManyGroups       = "too_many_priority_groups"
+	mismatchedLimits    = "mismatched_shared_limit_group"
+	outOfRangePriority  = "default_priority_out_of_range"
+	unknownExportRules  = "unknown_export_rules"
+	ttlGraceTooLong     = "ttl_grace_too_long"
+	unregisteredHook    = "unregistered_decision_hook"
 )
 
+// isDecisionHookRegistered looks up whether a decision hook is registered,
+// hooks are not looked up if it is not set
+var isDecisionHookRegistered func(name string) bool
+
+// SetDecisionHookRegistry sets how decision hooks are looked up, so remedies
+// referencing a hook which is not registered fail the configuration load
+func SetDecisionHookRegistry(isRegistered func(name string) bool) {
+	isDecisionHookRegistered = isRegistered
+}
+
+const defaultMaxPriorityGroups = 1000
+
+// MaxPriorityGroups returns the limit on the number of priority groups
+// a remedy can be configured with (across all of its dimensions),
+// which also bounds the distinct priorities it reports on its metrics
+func MaxPriorityGroups() int {
+	maxGroups, err := environment.GetMaxPriorityGroups()
+	if err != nil || maxGroups <= 0 {
+		return defaultMaxPriorityGroups
+	}
+	return maxGroups
+}
+
+// RegisterValidations registers the custom validations
+// of the policies configuration
+func RegisterValidations() error {
+	sharedConfig.Validate.RegisterStructValidation(
+		ValidateStructLevel,
+		sharedConfig.Remedy{},         //nolint: exhaustruct
+		sharedConfig.Diagnosis{},      //nolint: exhaustruct
+		sharedConfig.PoliciesConfig{}, //nolint: exhaustruct
+	)
+	return sharedConfig.Validate.RegisterValidation("validateInt", ValidateInt)
+}
+
 func ReadPoliciesConfig(path string) (*sharedConfig.PoliciesConfig, error) {
 	config, readErr := configuration.DecodeYAML[sharedConfig.PoliciesConfig](
 		path,
@@ -38,6 +85,7 @@ func ReadPoliciesConfig(path string) (*sharedConfig.PoliciesConfig, error) {
 	if err := Validate(config.UnmarshaledData); err != nil {
 		return nil, err
 	}
+	warnDeprecatedFields(config.Content)
 
 	return config.UnmarshaledData, nil
 }
@@ -57,63 +105,9 @@ func ValidateWithDebugLevel(
 			return err
 		}
 
-		source := "💔 Policies configuration"
 		if vErrs, ok := validateErr.(validator.ValidationErrors); ok {
 			for _, vErr := range vErrs {
-				if isDebugLevel {
-					source = fmt.Sprintf("'%s'", vErr.StructNamespace())
-				}
-
-				var newErr error
-				switch vErr.Tag() {
-				case castingError:
-					newErr = fmt.Errorf(
-						"💔 Failed casting '%s' struct (%v)",
-						vErr.StructNamespace(),
-						vErr.Value(),
-					)
-				case unknownPlugin:
-					newErr = fmt.Errorf(
-						"%s has an unknown plugin",
-						source,
-					)
-				case undefinedExporter:
-					newErr = fmt.Errorf(
-						"%s has a value of '%v' and it's an undefined exporter",
-						source,
-						vErr.Value(),
-					)
-				case undefinedAccount:
-					newErr = fmt.Errorf(
-						"%s has a value of '%v' and it's an undefined account",
-						source,
-						vErr.Value(),
-					)
-				case duplicatePolicyName:
-					newErr = fmt.Errorf(
-						"%s has duplicate policy names: '%v'",
-						source,
-						vErr.Value(),
-					)
-				case misalignedWindows:
-					if !isDebugLevel {
-						source = "💔 Throttling configuration"
-					}
-					newErr = fmt.Errorf(
-						"%s has misaligned window sizes: '%v'",
-						source,
-						vErr.Value(),
-					)
-
-				default:
-					newErr = fmt.Errorf(
-						"💔 '%s' has a value of '%v' which does not satisfy the '%s' constraint",
-						vErr.StructNamespace(),
-						vErr.Value(),
-						vErr.Tag(),
-					)
-				}
-				err = errors.Join(err, newErr)
+				err = errors.Join(err, validationError(vErr, isDebugLevel))
 			}
 		}
 	}
@@ -123,10 +117,155 @@ func ValidateWithDebugLevel(
 			err = errors.Join(err, newErr)
 		}
 	}
+	forEachAuthChallenge(config,
+		func(_ []string, _ string, challenge *sharedConfig.AuthChallengeConfig) {
+			if newErr := challenge.LoadEnvValues(); newErr != nil {
+				err = errors.Join(err, newErr)
+			}
+		})
 
 	return err
 }
 
+// forEachAuthChallenge calls handle with each challenge configured by the
+// authentication remedies, along with the path of the remedy in the policies
+func forEachAuthChallenge(
+	config *sharedConfig.PoliciesConfig,
+	handle func(path []string, remedyName string,
+		challenge *sharedConfig.AuthChallengeConfig),
+) {
+	handleRemedies := func(path []string, remedies []sharedConfig.Remedy) {
+		for index, remedy := range remedies {
+			authConfig := remedy.Config.Authentication
+			if authConfig == nil || authConfig.Challenge == nil {
+				continue
+			}
+			remedyPath := append(append([]string{}, path...),
+				"remedies", strconv.Itoa(index))
+			handle(remedyPath, remedy.Name, authConfig.Challenge)
+		}
+	}
+	handleRemedies([]string{"global"}, config.Global.Remedies)
+	for index, endpoint := range config.Endpoints {
+		handleRemedies([]string{"endpoints", strconv.Itoa(index)}, endpoint.Remedies)
+	}
+}
+
+// validationError builds a human readable error out of a validator error
+func validationError(vErr validator.FieldError, isDebugLevel bool) error {
+	source := "💔 Policies configuration"
+	if isDebugLevel {
+		source = fmt.Sprintf("'%s'", vErr.StructNamespace())
+	}
+
+	var newErr error
+	switch vErr.Tag() {
+	case castingError:
+		newErr = fmt.Errorf(
+			"💔 Failed casting '%s' struct (%v)",
+			vErr.StructNamespace(),
+			vErr.Value(),
+		)
+	case unknownPlugin:
+		newErr = fmt.Errorf(
+			"%s has an unknown plugin",
+			source,
+		)
+	case undefinedExporter:
+		newErr = fmt.Errorf(
+			"%s has a value of '%v' and it's an undefined exporter",
+			source,
+			vErr.Value(),
+		)
+	case undefinedTemplate:
+		newErr = fmt.Errorf(
+			"%s has a value of '%v' and it's an undefined error template",
+			source,
+			vErr.Value(),
+		)
+	case unregisteredHook:
+		newErr = fmt.Errorf(
+			"%s has a value of '%v' and it's an unregistered decision hook",
+			source,
+			vErr.Value(),
+		)
+	case undefinedAccount:
+		newErr = fmt.Errorf(
+			"%s has a value of '%v' and it's an undefined account",
+			source,
+			vErr.Value(),
+		)
+	case duplicatePolicyName:
+		newErr = fmt.Errorf(
+			"%s has duplicate policy names: '%v'",
+			source,
+			vErr.Value(),
+		)
+	case misalignedWindows:
+		if !isDebugLevel {
+			source = "💔 Throttling configuration"
+		}
+		newErr = fmt.Errorf(
+			"%s has misaligned window sizes: '%v'",
+			source,
+			vErr.Value(),
+		)
+	case tooManyGroups:
+		newErr = fmt.Errorf(
+			"%s has %v priority groups, more than the limit of %s "+
+				"(which can be raised with LUNAR_MAX_PRIORITY_GROUPS)",
+			source,
+			vErr.Value(),
+			vErr.Param(),
+		)
+	case unknownExportRules:
+		newErr = fmt.Errorf(
+			"%s has export rules of '%v', which is neither an exporter nor '%s'",
+			source,
+			vErr.Value(),
+			sharedConfig.DefaultExportRulesName,
+		)
+	case ttlGraceTooLong:
+		newErr = fmt.Errorf(
+			"%s has a TTL grace of %vms, longer than %s of its TTL",
+			source,
+			vErr.Value(),
+			vErr.Param(),
+		)
+	case outOfRangePriority:
+		newErr = fmt.Errorf(
+			"%s has a default priority of %v, out of the range of "+
+				"its group priorities (%s)",
+			source,
+			vErr.Value(),
+			vErr.Param(),
+		)
+	case mismatchedLimits:
+		newErr = fmt.Errorf(
+			"%s has remedies of shared limit group '%v' with different "+
+				"allowed request counts, window sizes or dynamic quotas",
+			source,
+			vErr.Value(),
+		)
+	case invalidExpression:
+		newErr = fmt.Errorf(
+			"%s has an invalid priority expression '%v': %s",
+			source,
+			vErr.Value(),
+			vErr.Param(),
+		)
+
+	default:
+		newErr = fmt.Errorf(
+			"💔 '%s' has a value of '%v' which does not satisfy the '%s' constraint",
+			vErr.StructNamespace(),
+			vErr.Value(),
+			vErr.Tag(),
+		)
+	}
+	return newErr
+}
+
 func ValidateInt(fl validator.FieldLevel) bool {
 	// Get the field value
 	value := fl.Field().Interface()
@@ -160,6 +299,8 @@ func ValidateStructLevel(structLevel validator.StructLevel) {
 	case sharedConfig.PoliciesConfig:
 		validateUniquePolicyNames(structLevel)
 		validateStrategyBasedThrottlingChains(structLevel)
+		validateSharedLimitGroups(structLevel)
+		validateExportRulesNames(structLevel)
 	default:
 		return
 	}
@@ -213,9 +354,121 @@ func validateRemedy(structLevel validator.StructLevel) {
 		}
 	}
 
+	validatePriorityExpression(structLevel, remedyPlugin)
+	validatePriorityGroupCount(structLevel, remedyPlugin)
+	validateDefaultPriority(structLevel, remedyPlugin)
+	validateTTLGrace(structLevel, remedyPlugin)
+	validateErrorTemplateReference(structLevel, remedyPlugin)
+	validateDecisionHookRegistered(structLevel, remedyPlugin)
+
 	// todo add validation for caching in global -> not allowed
 }
 
+// validatePriorityExpression compiles the priority expression (if set),
+// so syntax errors fail the configuration load rather than each request
+func validatePriorityExpression(
+	structLevel validator.StructLevel,
+	remedyPlugin sharedConfig.Remedy,
+) {
+	queueConfig := remedyPlugin.Config.StrategyBasedQueue
+	if queueConfig == nil || queueConfig.Prioritization == nil ||
+		queueConfig.Prioritization.Expression == "" {
+		return
+	}
+
+	source := queueConfig.Prioritization.Expression
+	if _, err := expression.Compile(source); err != nil {
+		structLevel.ReportError(source, "", "", invalidExpression, err.Error())
+	}
+}
+
+func validatePriorityGroupCount(
+	structLevel validator.StructLevel,
+	remedyPlugin sharedConfig.Remedy,
+) {
+	queueConfig := remedyPlugin.Config.StrategyBasedQueue
+	if queueConfig == nil || queueConfig.Prioritization == nil {
+		return
+	}
+	maxGroups := MaxPriorityGroups()
+	if groupCount := queueConfig.Prioritization.GroupCount(); groupCount > maxGroups {
+		structLevel.ReportError(groupCount, "", "", tooManyGroups,
+			strconv.Itoa(maxGroups))
+	}
+}
+
+// validateDefaultPriority requires the default priority of unmatched requests
+// to be in the range of the group priorities, 0 being always valid as the
+// default for compatibility
+func validateDefaultPriority(
+	structLevel validator.StructLevel,
+	remedyPlugin sharedConfig.Remedy,
+) {
+	queueConfig := remedyPlugin.Config.StrategyBasedQueue
+	if queueConfig == nil || queueConfig.Prioritization == nil ||
+		queueConfig.Prioritization.DefaultPriority == 0 {
+		return
+	}
+	defaultPriority := queueConfig.Prioritization.DefaultPriority
+	lowest, highest, found := queueConfig.Prioritization.GroupPriorityRange()
+	if !found || (defaultPriority >= lowest && defaultPriority <= highest) {
+		return
+	}
+	structLevel.ReportError(defaultPriority, "", "", outOfRangePriority,
+		fmt.Sprintf("%v-%v", lowest, highest))
+}
+
+// validateTTLGrace bounds the grace on the TTL of queued requests,
+// so it only resolves requests right at their TTL in favor of admission
+func validateTTLGrace(
+	structLevel validator.StructLevel,
+	remedyPlugin sharedConfig.Remedy,
+) {
+	queueConfig := remedyPlugin.Config.StrategyBasedQueue
+	if queueConfig == nil || queueConfig.TTLGraceMilliseconds == 0 {
+		return
+	}
+	ttl := time.Duration(float64(queueConfig.TTLSeconds) * float64(time.Second))
+	grace := time.Duration(queueConfig.TTLGraceMilliseconds) * time.Millisecond
+	if queue.BoundTTLGrace(ttl, grace) < grace {
+		structLevel.ReportError(queueConfig.TTLGraceMilliseconds, "", "",
+			ttlGraceTooLong, fmt.Sprintf("%v%%", queue.MaxTTLGraceFraction*100))
+	}
+}
+
+func validateErrorTemplateReference(
+	structLevel validator.StructLevel,
+	remedyPlugin sharedConfig.Remedy,
+) {
+	if remedyPlugin.ErrorTemplate == "" {
+		return
+	}
+	policiesConfig, ok := structLevel.Top().Interface().(sharedConfig.PoliciesConfig)
+	if !ok {
+		structLevel.ReportError(policiesConfig, "", "", castingError, "")
+		return
+	}
+	if _, found := policiesConfig.ErrorTemplates[remedyPlugin.ErrorTemplate]; !found {
+		structLevel.ReportError(
+			remedyPlugin.ErrorTemplate, "", "", undefinedTemplate, "")
+	}
+}
+
+// validateDecisionHookRegistered fails the configuration load if the remedy
+// uses an in-process decision hook which is not registered
+func validateDecisionHookRegistered(
+	structLevel validator.StructLevel,
+	remedyPlugin sharedConfig.Remedy,
+) {
+	hookConfig := remedyPlugin.Config.DecisionHook
+	if hookConfig == nil || hookConfig.Hook == "" || isDecisionHookRegistered == nil {
+		return
+	}
+	if !isDecisionHookRegistered(hookConfig.Hook) {
+		structLevel.ReportError(hookConfig.Hook, "", "", unregisteredHook, "")
+	}
+}
+
 func validateExporters(structLevel validator.StructLevel) {
 	diagnosisPlugin, ok := structLevel.Current().Interface().(sharedConfig.Diagnosis) //nolint
 	if !ok {
@@ -299,6 +552,66 @@ func validateStrategyBasedThrottlingChains(structLevel validator.StructLevel) {
 	}
 }
 
+// validateSharedLimitGroups requires the queues of the remedies sharing
+// a limit group to be of the same strategy, as they share a single quota
+func validateSharedLimitGroups(structLevel validator.StructLevel) {
+	policiesConfig, ok := structLevel.Current().Interface().(sharedConfig.PoliciesConfig)
+	if !ok {
+		structLevel.ReportError(policiesConfig, "", "", castingError, "")
+		return
+	}
+
+	remedies := append([]sharedConfig.Remedy{}, policiesConfig.Global.Remedies...)
+	for _, endpoint := range policiesConfig.Endpoints {
+		remedies = append(remedies, endpoint.Remedies...)
+	}
+	strategies := map[string]*sharedConfig.StrategyBasedQueueConfig{}
+	mismatched := map[string]bool{}
+	for _, remedy := range remedies {
+		queueConfig := remedy.Config.StrategyBasedQueue
+		if queueConfig == nil || queueConfig.SharedLimitGroup == "" {
+			continue
+		}
+		group := queueConfig.SharedLimitGroup
+		first, found := strategies[group]
+		if !found {
+			strategies[group] = queueConfig
+			continue
+		}
+		if !mismatched[group] &&
+			(first.AllowedRequestCount != queueConfig.AllowedRequestCount ||
+				first.WindowSizeInSeconds != queueConfig.WindowSizeInSeconds ||
+				!sameDynamicQuota(first.DynamicQuota, queueConfig.DynamicQuota)) {
+			mismatched[group] = true
+			structLevel.ReportError(group, "", "", mismatchedLimits, "")
+		}
+	}
+}
+
+// sameDynamicQuota is whether both remedies have the same dynamic quota,
+// or neither has one
+func sameDynamicQuota(first, second *sharedConfig.DynamicQuota) bool {
+	if first == nil || second == nil {
+		return first == second
+	}
+	return *first == *second
+}
+
+func validateExportRulesNames(structLevel validator.StructLevel) {
+	policiesConfig, ok := structLevel.Current().Interface().(sharedConfig.PoliciesConfig)
+	if !ok {
+		structLevel.ReportError(policiesConfig, "", "", castingError, "")
+		return
+	}
+
+	for name := range policiesConfig.Exporters.Rules {
+		if name != sharedConfig.DefaultExportRulesName &&
+			sharedConfig.ParseExporterType(name) == sharedConfig.ExporterUndefined {
+			structLevel.ReportError(name, "", "", unknownExportRules, "")
+		}
+	}
+}
+
 func validateCachePlugin(structLevel validator.StructLevel) {
 	remedyPlugin, ok := structLevel.Current().Interface().(sharedConfig.Remedy)
 	if !ok {
@@ -169,6 +169,29 @@ func TestValidateFailsIfTwoPoliciesShareTheSameName(
 	assert.Error(t, err)
 }
 
+func TestValidateFailsIfRemediesOfDifferentEndpointsShareTheSameName(
+	t *testing.T,
+) {
+	initValidations()
+
+	endpoint := func(url string) sharedConfig.EndpointConfig {
+		return sharedConfig.EndpointConfig{
+			URL: url, Method: "GET",
+			Remedies: []sharedConfig.Remedy{
+				buildStrategyBasedThrottling("throttling", 10),
+			},
+		}
+	}
+	policiesConfig := sharedConfig.PoliciesConfig{
+		Endpoints: []sharedConfig.EndpointConfig{
+			endpoint("api.com/users"),
+			endpoint("api.com/orders"),
+		},
+	}
+	err := config.Validate(&policiesConfig)
+	assert.ErrorContains(t, err, "duplicate policy names: '[throttling]'")
+}
+
 func TestValidateFailsIfCachePolicyMissingPathParam(
 	t *testing.T,
 ) {
@@ -337,3 +360,273 @@ func buildStrategyBasedQueueRemedy(
 		StrategyBasedQueue: &cachingConfig,
 	}
 }
+
+func buildPoliciesConfigWithErrorTemplateReference(
+	templateName string,
+) sharedConfig.PoliciesConfig {
+	remedy := buildStrategyBasedThrottling("throttling", 60)
+	remedy.ErrorTemplate = templateName
+	return sharedConfig.PoliciesConfig{
+		Global: sharedConfig.Global{Remedies: []sharedConfig.Remedy{remedy}},
+		ErrorTemplates: map[string]sharedConfig.ErrorTemplate{
+			"rate_limited": {StatusCode: 503, Body: `{"error": "rate limited"}`},
+		},
+	}
+}
+
+func TestValidateFailsIfErrorTemplateIsUndefined(t *testing.T) {
+	initValidations()
+
+	policiesConfig := buildPoliciesConfigWithErrorTemplateReference("missing")
+	err := config.Validate(&policiesConfig)
+	assert.ErrorContains(t, err, "undefined error template")
+
+	policiesConfig = buildPoliciesConfigWithErrorTemplateReference("rate_limited")
+	assert.Nil(t, config.Validate(&policiesConfig))
+}
+
+func TestBuildPolicyDataResolvesErrorTemplates(t *testing.T) {
+	policiesConfig := buildPoliciesConfigWithErrorTemplateReference("rate_limited")
+	policiesConfig.Global.Remedies = append(policiesConfig.Global.Remedies,
+		buildStrategyBasedThrottling("default response", 60))
+
+	policiesData, err := config.BuildPolicyData(&policiesConfig)
+
+	assert.Nil(t, err)
+	remedies := policiesData.Config.Global.Remedies
+	assert.Equal(t,
+		&sharedConfig.ErrorTemplate{StatusCode: 503, Body: `{"error": "rate limited"}`},
+		remedies[0].ResolvedErrorTemplate)
+	assert.Nil(t, remedies[1].ResolvedErrorTemplate)
+}
+
+func TestValidateFailsIfPriorityGroupsExceedTheLimit(t *testing.T) {
+	initValidations()
+	t.Setenv("LUNAR_MAX_PRIORITY_GROUPS", "2")
+
+	remedyConfig := buildStrategyBasedQueueRemedy(1)
+	policiesConfig := sharedConfig.PoliciesConfig{
+		Global: sharedConfig.Global{Remedies: []sharedConfig.Remedy{
+			{Enabled: true, Name: "queue", Config: remedyConfig},
+		}},
+	}
+	assert.Nil(t, config.Validate(&policiesConfig))
+
+	prioritization := remedyConfig.StrategyBasedQueue.Prioritization
+	prioritization.Dimensions = []sharedConfig.PrioritizationDimension{{
+		GroupBy: sharedConfig.GroupBy{HeaderName: "X-Tier"},
+		Groups: map[string]sharedConfig.Prioritization{
+			"premium": {Priority: 1},
+			"free":    {Priority: 5},
+		},
+	}}
+	err := config.Validate(&policiesConfig)
+	assert.ErrorContains(t, err, "has 3 priority groups, more than the limit of 2")
+}
+
+func TestValidateChecksAuthChallengeClientsAndLoadsTheirEnvValues(t *testing.T) {
+	initValidations()
+	t.Setenv("CHALLENGE_TEST_TOKEN", "token-from-env")
+
+	challenge := &sharedConfig.AuthChallengeConfig{
+		Clients: []sharedConfig.AuthClient{
+			{Scheme: "bearer", Token: "${{CHALLENGE_TEST_TOKEN}}"},
+		},
+	}
+	policiesConfig := sharedConfig.PoliciesConfig{
+		Global: sharedConfig.Global{Remedies: []sharedConfig.Remedy{{
+			Enabled: true,
+			Name:    "auth",
+			Config: sharedConfig.RemedyConfig{
+				Authentication: &sharedConfig.AuthConfig{
+					Account:   "account",
+					Challenge: challenge,
+				},
+			},
+		}}},
+	}
+	assert.Nil(t, config.Validate(&policiesConfig))
+	assert.Equal(t, "token-from-env", challenge.Clients[0].Token)
+
+	challenge.Clients = append(challenge.Clients, sharedConfig.AuthClient{Scheme: "basic"})
+	challenge.Unauthorized.StatusCode = 200
+	err := config.Validate(&policiesConfig)
+	assert.ErrorContains(t, err, "Username")
+	assert.ErrorContains(t, err, "Password")
+	assert.ErrorContains(t, err, "StatusCode")
+}
+
+func TestValidateFailsIfSharedLimitGroupStrategiesDiffer(t *testing.T) {
+	initValidations()
+
+	firstConfig := buildStrategyBasedQueueRemedy(1)
+	firstConfig.StrategyBasedQueue.SharedLimitGroup = "upstream"
+	secondConfig := buildStrategyBasedQueueRemedy(1)
+	secondConfig.StrategyBasedQueue.SharedLimitGroup = "upstream"
+	policiesConfig := sharedConfig.PoliciesConfig{
+		Global: sharedConfig.Global{Remedies: []sharedConfig.Remedy{
+			{Enabled: true, Name: "first", Config: firstConfig},
+		}},
+		Endpoints: []sharedConfig.EndpointConfig{{
+			URL:    "api.com/users",
+			Method: "GET",
+			Remedies: []sharedConfig.Remedy{
+				{Enabled: true, Name: "second", Config: secondConfig},
+			},
+		}},
+	}
+	assert.Nil(t, config.Validate(&policiesConfig))
+
+	secondConfig.StrategyBasedQueue.AllowedRequestCount = 2
+	err := config.Validate(&policiesConfig)
+	assert.ErrorContains(t, err, "shared limit group 'upstream'")
+
+	// The remedies of a group share a single dynamic quota too
+	secondConfig.StrategyBasedQueue.AllowedRequestCount = 1
+	dynamicQuota := sharedConfig.DynamicQuota{
+		Key:                    "upstream",
+		MinAllowedRequestCount: 1,
+		MaxAllowedRequestCount: 10,
+	}
+	firstConfig.StrategyBasedQueue.DynamicQuota = &dynamicQuota
+	err = config.Validate(&policiesConfig)
+	assert.ErrorContains(t, err, "shared limit group 'upstream'")
+
+	otherDynamicQuota := dynamicQuota
+	secondConfig.StrategyBasedQueue.DynamicQuota = &otherDynamicQuota
+	assert.Nil(t, config.Validate(&policiesConfig))
+
+	otherDynamicQuota.Key = "other"
+	err = config.Validate(&policiesConfig)
+	assert.ErrorContains(t, err, "shared limit group 'upstream'")
+}
+
+func TestValidateFailsIfDefaultPriorityIsOutOfTheGroupPriorities(t *testing.T) {
+	initValidations()
+
+	remedyConfig := buildStrategyBasedQueueRemedy(5)
+	remedyConfig.StrategyBasedQueue.Prioritization.Groups["bar"] =
+		sharedConfig.Prioritization{Priority: 1}
+	policiesConfig := sharedConfig.PoliciesConfig{
+		Global: sharedConfig.Global{Remedies: []sharedConfig.Remedy{
+			{Enabled: true, Name: "queue", Config: remedyConfig},
+		}},
+	}
+	prioritization := remedyConfig.StrategyBasedQueue.Prioritization
+	for _, defaultPriority := range []float64{0, 1, 5} {
+		prioritization.DefaultPriority = defaultPriority
+		assert.Nil(t, config.Validate(&policiesConfig))
+	}
+
+	prioritization.DefaultPriority = 6
+	err := config.Validate(&policiesConfig)
+	assert.ErrorContains(t, err,
+		"has a default priority of 6, out of the range of its group priorities (1-5)")
+
+	prioritization.DefaultPriority = 2.5
+	assert.Error(t, config.Validate(&policiesConfig))
+}
+
+func TestValidateFailsIfExportRulesAreOfAnUnknownExporter(t *testing.T) {
+	initValidations()
+
+	policiesConfig := sharedConfig.PoliciesConfig{
+		Exporters: sharedConfig.Exporters{Rules: map[string]sharedConfig.ExportRules{
+			sharedConfig.DefaultExportRulesName: {},
+			"file":                              {},
+		}},
+	}
+	assert.Nil(t, config.Validate(&policiesConfig))
+
+	policiesConfig.Exporters.Rules["kafka"] = sharedConfig.ExportRules{}
+	err := config.Validate(&policiesConfig)
+	assert.ErrorContains(t, err, "has export rules of 'kafka'")
+}
+
+func TestValidateFailsIfTTLGraceExceedsATenthOfTheTTL(t *testing.T) {
+	initValidations()
+
+	remedyConfig := buildStrategyBasedQueueRemedy(1)
+	policiesConfig := sharedConfig.PoliciesConfig{
+		Global: sharedConfig.Global{Remedies: []sharedConfig.Remedy{
+			{Enabled: true, Name: "queue", Config: remedyConfig},
+		}},
+	}
+	// The TTL is of 10 seconds
+	remedyConfig.StrategyBasedQueue.TTLGraceMilliseconds = 1000
+	assert.Nil(t, config.Validate(&policiesConfig))
+
+	remedyConfig.StrategyBasedQueue.TTLGraceMilliseconds = 1001
+	err := config.Validate(&policiesConfig)
+	assert.ErrorContains(t, err, "has a TTL grace of 1001ms, longer than 10% of its TTL")
+}
+
+func TestValidateFailsIfDynamicQuotaBoundsAreInverted(t *testing.T) {
+	initValidations()
+
+	remedyConfig := buildStrategyBasedQueueRemedy(1)
+	policiesConfig := sharedConfig.PoliciesConfig{
+		Global: sharedConfig.Global{Remedies: []sharedConfig.Remedy{
+			{Enabled: true, Name: "queue", Config: remedyConfig},
+		}},
+	}
+	remedyConfig.StrategyBasedQueue.DynamicQuota = &sharedConfig.DynamicQuota{
+		Key:                    "upstream",
+		MinAllowedRequestCount: 5,
+		MaxAllowedRequestCount: 50,
+	}
+	assert.Nil(t, config.Validate(&policiesConfig))
+
+	remedyConfig.StrategyBasedQueue.DynamicQuota.MaxAllowedRequestCount = 4
+	assert.Error(t, config.Validate(&policiesConfig))
+}
+
+func TestValidateFailsIfDecisionHookIsBothRegisteredAndAnEndpoint(t *testing.T) {
+	initValidations()
+
+	remedyConfig := &sharedConfig.DecisionHookConfig{Hook: "custom"} //nolint:exhaustruct
+	policiesConfig := sharedConfig.PoliciesConfig{
+		Global: sharedConfig.Global{Remedies: []sharedConfig.Remedy{{
+			Enabled: true,
+			Name:    "hook",
+			Config:  sharedConfig.RemedyConfig{DecisionHook: remedyConfig},
+		}}},
+	}
+	assert.Nil(t, config.Validate(&policiesConfig))
+
+	remedyConfig.Endpoint = "http://localhost:8080/decide"
+	assert.Error(t, config.Validate(&policiesConfig))
+
+	remedyConfig.Hook = ""
+	assert.Nil(t, config.Validate(&policiesConfig))
+
+	remedyConfig.Endpoint = ""
+	assert.Error(t, config.Validate(&policiesConfig))
+}
+
+func TestValidateFailsIfDecisionHookIsNotRegistered(t *testing.T) {
+	initValidations()
+	config.SetDecisionHookRegistry(func(name string) bool { return name == "custom" })
+	defer config.SetDecisionHookRegistry(nil)
+
+	remedyConfig := &sharedConfig.DecisionHookConfig{ //nolint:exhaustruct
+		Hook:               "custom",
+		ResponseStatusCode: 429,
+	}
+	policiesConfig := sharedConfig.PoliciesConfig{
+		Global: sharedConfig.Global{Remedies: []sharedConfig.Remedy{{
+			Enabled: true,
+			Name:    "hook",
+			Config:  sharedConfig.RemedyConfig{DecisionHook: remedyConfig},
+		}}},
+	}
+	assert.Nil(t, config.Validate(&policiesConfig))
+
+	remedyConfig.Hook = "missing"
+	assert.ErrorContains(t, config.Validate(&policiesConfig),
+		"'missing' and it's an unregistered decision hook")
+
+	remedyConfig.Hook = "custom"
+	remedyConfig.ResponseStatusCode = 1000
+	assert.Error(t, config.Validate(&policiesConfig))
+}
@@ -0,0 +1,88 @@
+package config_test
+
+import (
+	"lunar/engine/config"
+	sharedConfig "lunar/shared-model/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateFailsOnUnknownPriorityCombination(t *testing.T) {
+	initValidations()
+	remedyConfig := buildStrategyBasedQueueRemedy(1)
+	remedyConfig.StrategyBasedQueue.Prioritization.Combine = "avg"
+	remedyConfig.StrategyBasedQueue.Prioritization.Dimensions = []sharedConfig.PrioritizationDimension{
+		{
+			GroupBy: sharedConfig.GroupBy{HeaderName: "X-Endpoint-Cost"},
+			Groups: map[string]sharedConfig.Prioritization{
+				"cheap": {Priority: 2},
+			},
+		},
+	}
+
+	policiesConfig := sharedConfig.PoliciesConfig{
+		Global: sharedConfig.Global{Remedies: []sharedConfig.Remedy{
+			{Enabled: true, Name: "queue", Config: remedyConfig},
+		}},
+	}
+	err := config.Validate(&policiesConfig)
+	assert.NotNil(t, err)
+}
+
+func TestValidateFailsOnEmptyPriorityGroupBy(t *testing.T) {
+	initValidations()
+	dimension := sharedConfig.PrioritizationDimension{
+		GroupBy: sharedConfig.GroupBy{HeaderName: "X-Endpoint-Cost"},
+		Groups: map[string]sharedConfig.Prioritization{
+			"cheap": {Priority: 2},
+		},
+	}
+	validate := func(prioritization *sharedConfig.GroupPrioritization) error {
+		remedyConfig := buildStrategyBasedQueueRemedy(1)
+		remedyConfig.StrategyBasedQueue.Prioritization = prioritization
+		return config.Validate(&sharedConfig.PoliciesConfig{
+			Global: sharedConfig.Global{Remedies: []sharedConfig.Remedy{
+				{Enabled: true, Name: "queue", Config: remedyConfig},
+			}},
+		})
+	}
+
+	assert.NotNil(t, validate(&sharedConfig.GroupPrioritization{}))
+	// The top level group by is only optional with dimensions or an expression
+	assert.Nil(t, validate(&sharedConfig.GroupPrioritization{
+		Dimensions: []sharedConfig.PrioritizationDimension{dimension},
+	}))
+	assert.Nil(t, validate(&sharedConfig.GroupPrioritization{
+		Expression: `int(headers["X-Tier"])`,
+	}))
+
+	dimension.GroupBy = sharedConfig.GroupBy{}
+	assert.NotNil(t, validate(&sharedConfig.GroupPrioritization{
+		Dimensions: []sharedConfig.PrioritizationDimension{dimension},
+	}))
+}
+
+func TestValidateFailsOnInvalidPriorityExpression(t *testing.T) {
+	initValidations()
+	for source, valid := range map[string]bool{
+		`int(headers["X-Tier"]) * 2`:      true,
+		`int(headers["X-Tier"]) *`:        false,
+		`os.Getenv("HOME") == "" ? 1 : 2`: false,
+	} {
+		remedyConfig := buildStrategyBasedQueueRemedy(1)
+		remedyConfig.StrategyBasedQueue.Prioritization.Expression = source
+
+		policiesConfig := sharedConfig.PoliciesConfig{
+			Global: sharedConfig.Global{Remedies: []sharedConfig.Remedy{
+				{Enabled: true, Name: "queue", Config: remedyConfig},
+			}},
+		}
+		err := config.Validate(&policiesConfig)
+		if valid {
+			assert.Nil(t, err, source)
+		} else {
+			assert.ErrorContains(t, err, "invalid priority expression", source)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	sharedConfig "lunar/shared-model/config"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// startRemedyWarmups sets when the warmup of each remedy activated by
+// a reload ends. Remedies which were already active keep their warmup,
+// so it is tied to their activation rather than to the latest reload.
+func startRemedyWarmups(
+	previous *sharedConfig.PoliciesConfig,
+	next *sharedConfig.PoliciesConfig,
+	now time.Time,
+) {
+	previousRemedies := remediesByKey(previous)
+	forEachRemedy(next, func(key string, remedy *sharedConfig.Remedy) {
+		remedy.WarmupEndsAt = time.Time{}
+		if !remedy.Enabled || remedy.WarmupSeconds == 0 {
+			return
+		}
+		previousRemedy, found := previousRemedies[key]
+		if found && previousRemedy.Enabled {
+			remedy.WarmupEndsAt = previousRemedy.WarmupEndsAt
+			return
+		}
+		remedy.WarmupEndsAt = now.Add(
+			time.Duration(remedy.WarmupSeconds) * time.Second)
+		log.Info().Msgf("Remedy %s is warming up, "+
+			"it only observes requests until %s",
+			key, remedy.WarmupEndsAt.Format(time.RFC3339))
+	})
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	sharedConfig "lunar/shared-model/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func warmingUpPolicies(names ...string) *sharedConfig.PoliciesConfig {
+	remedies := []sharedConfig.Remedy{}
+	for _, name := range names {
+		remedies = append(remedies, sharedConfig.Remedy{
+			Enabled:       true,
+			Name:          name,
+			WarmupSeconds: 60,
+		})
+	}
+	return &sharedConfig.PoliciesConfig{
+		Global: sharedConfig.Global{Remedies: remedies},
+	}
+}
+
+func warmupEndsAt(policies *sharedConfig.PoliciesConfig) map[string]time.Time {
+	endsAt := map[string]time.Time{}
+	for _, remedy := range policies.Global.Remedies {
+		endsAt[remedy.Name] = remedy.WarmupEndsAt
+	}
+	return endsAt
+}
+
+func TestStartRemedyWarmupsTiesWarmupToActivation(t *testing.T) {
+	now := time.Unix(1000, 0)
+	// Remedy A was loaded on startup, so it enforces right away
+	initial := warmingUpPolicies("remedy_a")
+
+	added := warmingUpPolicies("remedy_a", "remedy_b")
+	startRemedyWarmups(initial, added, now)
+	assert.Equal(t, map[string]time.Time{
+		"remedy_a": {},
+		"remedy_b": now.Add(60 * time.Second),
+	}, warmupEndsAt(added))
+
+	// An unrelated reload does not restart the warmup
+	unrelated := warmingUpPolicies("remedy_a", "remedy_b", "remedy_c")
+	startRemedyWarmups(added, unrelated, now.Add(30*time.Second))
+	assert.Equal(t, map[string]time.Time{
+		"remedy_a": {},
+		"remedy_b": now.Add(60 * time.Second),
+		"remedy_c": now.Add(90 * time.Second),
+	}, warmupEndsAt(unrelated))
+
+	// Once disabled, a remedy warms up again when it is enabled again
+	disabled := warmingUpPolicies("remedy_a", "remedy_b", "remedy_c")
+	disabled.Global.Remedies[0].Enabled = false
+	startRemedyWarmups(unrelated, disabled, now.Add(40*time.Second))
+	enabled := warmingUpPolicies("remedy_a", "remedy_b", "remedy_c")
+	startRemedyWarmups(disabled, enabled, now.Add(50*time.Second))
+	assert.Equal(t, now.Add(110*time.Second), warmupEndsAt(enabled)["remedy_a"])
+}
+
+func TestRemedyIsWarmingUpUntilItsWarmupEnds(t *testing.T) {
+	now := time.Unix(1000, 0)
+	policies := warmingUpPolicies("remedy_a")
+	startRemedyWarmups(warmingUpPolicies(), policies, now)
+	remedy := policies.Global.Remedies[0]
+
+	assert.True(t, remedy.IsWarmingUp(now.Add(59*time.Second)))
+	assert.False(t, remedy.IsWarmingUp(now.Add(60*time.Second)))
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"lunar/toolkit-core/clock"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	remotePoliciesFailuresMetricName = "lunar_config.remote_policies.failed_fetches"
+	remotePoliciesRequestTimeout     = 10 * time.Second
+	remotePoliciesReasonAttribute    = "reason"
+	remotePoliciesReasonFetch        = "fetch"
+	remotePoliciesReasonInvalid      = "invalid"
+)
+
+// RemotePoliciesPoller polls a remote source (an HTTP endpoint returning the
+// policies config) and applies the config whenever it changes.
+// Config which fails to apply is rejected, so the last good config is retained.
+type RemotePoliciesPoller struct {
+	clock    clock.Clock
+	client   *http.Client
+	url      string
+	interval time.Duration
+	apply    func(rawData []byte) error
+
+	mutex          sync.Mutex
+	etag           string
+	contentHash    [sha256.Size]byte
+	failedFetches  metric.Int64Counter
+	stopChannel    chan struct{}
+	stopOnce       sync.Once
+	runningContext context.Context
+	cancel         context.CancelFunc
+}
+
+// NewRemotePoliciesPoller creates a poller of the given URL, applying
+// changed config with the given function (which is expected to validate it).
+func NewRemotePoliciesPoller(
+	clock clock.Clock,
+	url string,
+	interval time.Duration,
+	meter metric.Meter,
+	apply func(rawData []byte) error,
+) *RemotePoliciesPoller {
+	failedFetches, err := meter.Int64Counter(
+		remotePoliciesFailuresMetricName,
+		metric.WithDescription("The number of failed fetches of the remote policies config"),
+		metric.WithUnit("{fetch}"),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to initialize %s metric",
+			remotePoliciesFailuresMetricName)
+	}
+	runningContext, cancel := context.WithCancel(context.Background())
+	return &RemotePoliciesPoller{
+		clock:          clock,
+		client:         &http.Client{Timeout: remotePoliciesRequestTimeout},
+		url:            url,
+		interval:       interval,
+		apply:          apply,
+		failedFetches:  failedFetches,
+		stopChannel:    make(chan struct{}),
+		runningContext: runningContext,
+		cancel:         cancel,
+	}
+}
+
+// Run polls the remote source in the background, until stopped
+func (poller *RemotePoliciesPoller) Run() {
+	log.Info().Msgf("Polling policies config from %s every %v",
+		poller.url, poller.interval)
+	go func() {
+		for {
+			if err := poller.Poll(); err != nil {
+				log.Warn().Err(err).Msg("Failed to update policies from remote source")
+			}
+			select {
+			case <-poller.stopChannel:
+				return
+			case <-poller.clock.After(poller.interval):
+			}
+		}
+	}()
+}
+
+func (poller *RemotePoliciesPoller) Stop() {
+	poller.stopOnce.Do(func() {
+		poller.cancel()
+		close(poller.stopChannel)
+	})
+}
+
+// Poll fetches the remote config once, and applies it if it changed
+func (poller *RemotePoliciesPoller) Poll() error {
+	poller.mutex.Lock()
+	defer poller.mutex.Unlock()
+
+	rawData, etag, modified, err := poller.fetch()
+	if err != nil {
+		poller.countFailure(remotePoliciesReasonFetch)
+		return err
+	}
+	contentHash := sha256.Sum256(rawData)
+	if !modified || contentHash == poller.contentHash {
+		log.Trace().Msg("Remote policies config did not change")
+		return nil
+	}
+
+	if err := poller.apply(rawData); err != nil {
+		poller.countFailure(remotePoliciesReasonInvalid)
+		return fmt.Errorf("rejected remote policies config, "+
+			"keeping the last good config: %w", err)
+	}
+	poller.etag = etag
+	poller.contentHash = contentHash
+	log.Info().Msg("Applied policies config from remote source")
+	return nil
+}
+
+func (poller *RemotePoliciesPoller) fetch() ([]byte, string, bool, error) {
+	request, err := http.NewRequestWithContext(
+		poller.runningContext, http.MethodGet, poller.url, nil)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if poller.etag != "" {
+		request.Header.Set("If-None-Match", poller.etag)
+	}
+	response, err := poller.client.Do(request)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("failed to fetch remote policies: %w", err)
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		return nil, poller.etag, false, nil
+	case http.StatusOK:
+		rawData, err := io.ReadAll(response.Body)
+		if err != nil {
+			return nil, "", false, fmt.Errorf("failed to read remote policies: %w", err)
+		}
+		if len(bytes.TrimSpace(rawData)) == 0 {
+			return nil, "", false, fmt.Errorf("remote policies config is empty")
+		}
+		return rawData, response.Header.Get("ETag"), true, nil
+	default:
+		return nil, "", false, fmt.Errorf("failed to fetch remote policies, "+
+			"status code: %d", response.StatusCode)
+	}
+}
+
+func (poller *RemotePoliciesPoller) countFailure(reason string) {
+	if poller.failedFetches == nil {
+		return
+	}
+	poller.failedFetches.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String(remotePoliciesReasonAttribute, reason)))
+}
@@ -0,0 +1,171 @@
+package config_test
+
+import (
+	"context"
+	"errors"
+	"lunar/engine/config"
+	"lunar/toolkit-core/clock"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+const failedFetchesMetricName = "lunar_config.remote_policies.failed_fetches"
+
+var errInvalidPolicies = errors.New("invalid policies")
+
+// remotePoliciesServer serves the policies content with its ETag,
+// answering conditional requests of the current ETag with 304
+type remotePoliciesServer struct {
+	mutex      sync.Mutex
+	content    string
+	etag       string
+	statusCode int
+	requests   int
+}
+
+func (server *remotePoliciesServer) set(content, etag string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.content = content
+	server.etag = etag
+}
+
+func (server *remotePoliciesServer) ServeHTTP(
+	writer http.ResponseWriter,
+	request *http.Request,
+) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.requests++
+	if server.statusCode != 0 {
+		writer.WriteHeader(server.statusCode)
+		return
+	}
+	if server.etag != "" && request.Header.Get("If-None-Match") == server.etag {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writer.Header().Set("ETag", server.etag)
+	_, _ = writer.Write([]byte(server.content))
+}
+
+// policiesApplier records the applied content, rejecting invalid content
+type policiesApplier struct {
+	applied []string
+}
+
+func (applier *policiesApplier) apply(rawData []byte) error {
+	if string(rawData) == "invalid" {
+		return errInvalidPolicies
+	}
+	applier.applied = append(applier.applied, string(rawData))
+	return nil
+}
+
+func newTestRemotePoliciesPoller(
+	t *testing.T,
+	server *remotePoliciesServer,
+	applier *policiesApplier,
+) (*config.RemotePoliciesPoller, *sdkMetric.ManualReader) {
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	poller := config.NewRemotePoliciesPoller(
+		clock.NewMockClock(), httpServer.URL, time.Minute, meter, applier.apply)
+	t.Cleanup(poller.Stop)
+	return poller, reader
+}
+
+func collectFailedFetches(
+	t *testing.T,
+	reader *sdkMetric.ManualReader,
+) map[string]int64 {
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+
+	perReason := map[string]int64{}
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, recordedMetric := range scopeMetrics.Metrics {
+			if recordedMetric.Name != failedFetchesMetricName {
+				continue
+			}
+			sum, _ := recordedMetric.Data.(metricdata.Sum[int64])
+			for _, dataPoint := range sum.DataPoints {
+				reason, _ := dataPoint.Attributes.Value("reason")
+				perReason[reason.AsString()] += dataPoint.Value
+			}
+		}
+	}
+	return perReason
+}
+
+func TestRemotePoliciesPollerAppliesChangedPolicies(t *testing.T) {
+	t.Parallel()
+	server := &remotePoliciesServer{}
+	server.set("policies: v1", `"v1"`)
+	applier := &policiesApplier{}
+	poller, _ := newTestRemotePoliciesPoller(t, server, applier)
+
+	require.NoError(t, poller.Poll())
+	require.NoError(t, poller.Poll())
+	server.set("policies: v2", `"v2"`)
+	require.NoError(t, poller.Poll())
+
+	require.Equal(t, []string{"policies: v1", "policies: v2"}, applier.applied)
+}
+
+func TestRemotePoliciesPollerDoesNotReapplyUnchangedContentWithoutETag(t *testing.T) {
+	t.Parallel()
+	server := &remotePoliciesServer{}
+	server.set("policies: v1", "")
+	applier := &policiesApplier{}
+	poller, _ := newTestRemotePoliciesPoller(t, server, applier)
+
+	require.NoError(t, poller.Poll())
+	require.NoError(t, poller.Poll())
+
+	require.Equal(t, []string{"policies: v1"}, applier.applied)
+	require.Equal(t, 2, server.requests)
+}
+
+func TestRemotePoliciesPollerKeepsLastGoodPoliciesOnInvalidContent(t *testing.T) {
+	t.Parallel()
+	server := &remotePoliciesServer{}
+	server.set("policies: v1", `"v1"`)
+	applier := &policiesApplier{}
+	poller, reader := newTestRemotePoliciesPoller(t, server, applier)
+	require.NoError(t, poller.Poll())
+
+	server.set("invalid", `"v2"`)
+	err := poller.Poll()
+
+	require.ErrorIs(t, err, errInvalidPolicies)
+	require.Equal(t, []string{"policies: v1"}, applier.applied)
+	require.Equal(t, map[string]int64{"invalid": 1}, collectFailedFetches(t, reader))
+
+	// Once fixed upstream, the policies are applied
+	server.set("policies: v3", `"v3"`)
+	require.NoError(t, poller.Poll())
+	require.Equal(t, []string{"policies: v1", "policies: v3"}, applier.applied)
+}
+
+func TestRemotePoliciesPollerCountsFailedFetches(t *testing.T) {
+	t.Parallel()
+	server := &remotePoliciesServer{statusCode: http.StatusInternalServerError}
+	applier := &policiesApplier{}
+	poller, reader := newTestRemotePoliciesPoller(t, server, applier)
+
+	require.Error(t, poller.Poll())
+	require.Error(t, poller.Poll())
+
+	require.Empty(t, applier.applied)
+	require.Equal(t, map[string]int64{"fetch": 2}, collectFailedFetches(t, reader))
+}
@@ -9,8 +9,10 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
 )
 
 const (
@@ -46,10 +48,21 @@ var (
 	haproxyManagedEndpointURL = "http://localhost:" + haproxyManagePort + "/managed_endpoint"
 	haproxyManageAllURL       = "http://localhost:" + haproxyManagePort + "/manage_all"
 	haproxyUnManageAllURL     = "http://localhost:" + haproxyManagePort + "/unmanage_all"
+	haproxyShuttingDownURL    = "http://localhost:" + haproxyManagePort + "/shutting_down"
 )
 
 var regexToFindPathParameters = regexp.MustCompile(`/\{[a-zA-Z0-9-_]+\}`)
 
+// pinnedEndpoints are kept managed regardless of the policies config,
+// so they are not unmanaged once the config stops managing them
+var pinnedEndpoints = struct {
+	mutex     sync.Mutex
+	endpoints map[string]int
+}{
+	mutex:     sync.Mutex{},
+	endpoints: map[string]int{},
+}
+
 type HAProxyEndpointsRequest struct {
 	ManageAll        bool
 	ManagedEndpoints []string
@@ -103,6 +116,55 @@ func ManageHAProxyEndpoints(haproxyEndpoints *HAProxyEndpointsRequest) error {
 	return nil
 }
 
+// PinHAProxyEndpoints manages the given endpoints until they are unpinned,
+// whether the policies config manages them or not
+func PinHAProxyEndpoints(endpoints []string) error {
+	pinnedEndpoints.mutex.Lock()
+	for _, endpoint := range endpoints {
+		pinnedEndpoints.endpoints[endpoint]++
+	}
+	pinnedEndpoints.mutex.Unlock()
+	err := ManageHAProxyEndpoints(&HAProxyEndpointsRequest{
+		ManageAll:        false,
+		ManagedEndpoints: endpoints,
+	})
+	if err != nil {
+		unpin(endpoints)
+	}
+	return err
+}
+
+// UnpinHAProxyEndpoints stops keeping the given endpoints managed,
+// unmanaging the ones which are not managed otherwise
+func UnpinHAProxyEndpoints(endpoints []string, managed *HAProxyEndpointsRequest) {
+	unpin(endpoints)
+	if managed.ManageAll {
+		return
+	}
+	endpointsToRemove, _ := lo.Difference(endpoints, managed.ManagedEndpoints)
+	ScheduleUnmanageHAProxyEndpoints(endpointsToRemove)
+}
+
+func unpin(endpoints []string) {
+	pinnedEndpoints.mutex.Lock()
+	defer pinnedEndpoints.mutex.Unlock()
+	for _, endpoint := range endpoints {
+		pinnedEndpoints.endpoints[endpoint]--
+		if pinnedEndpoints.endpoints[endpoint] <= 0 {
+			delete(pinnedEndpoints.endpoints, endpoint)
+		}
+	}
+}
+
+func unpinnedEndpoints(endpoints []string) []string {
+	pinnedEndpoints.mutex.Lock()
+	defer pinnedEndpoints.mutex.Unlock()
+	return lo.Filter(endpoints, func(endpoint string, _ int) bool {
+		_, pinned := pinnedEndpoints.endpoints[endpoint]
+		return !pinned
+	})
+}
+
 func unmanageHAProxyEndpoints(unmanagedEndpoints []string) error {
 	for _, unmanagedEndpoint := range unmanagedEndpoints {
 		err := operateEndpoint(unmanagedEndpoint, http.MethodDelete)
@@ -206,6 +268,25 @@ func manageAll() error {
 	return nil
 }
 
+// MarkProxyShuttingDown makes the proxy's healthcheck fail,
+// so load balancers stop sending traffic to it
+func MarkProxyShuttingDown() error {
+	request, err := http.NewRequest(http.MethodPut, haproxyShuttingDownURL, nil)
+	if err != nil {
+		return err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to mark proxy as shutting down, status: %v",
+			response.StatusCode)
+	}
+	return nil
+}
+
 func HaproxyEndpointFormat(method string, url string) string {
 	log.Trace().Msgf("Original URL: %v", url)
 	url = strings.ReplaceAll(url, ".", `\.`)
@@ -51,6 +51,8 @@ type Entry struct {
 	Time            time.Duration `json:"time"`
 	Request         Request       `json:"request"`
 	Response        Response      `json:"response"`
+	// ConfigVersion is a custom field, prefixed by underscore as HAR requires
+	ConfigVersion string `json:"_configVersion,omitempty"`
 }
 
 type Creator struct {
@@ -9,10 +9,12 @@ import (
 	"lunar/engine/utils/environment"
 	contextmanager "lunar/toolkit-core/context-manager"
 	"lunar/toolkit-core/logging"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	spoe "github.com/TheLunarCompany/haproxy-spoe-go"
@@ -25,6 +27,7 @@ const (
 	lunarEnginePort      string = "12345"
 	lunarEngine          string = "lunar-engine"
 	proxyIDPrefix        string = "proxy-"
+	recentLogsMaxEntries int    = 100
 )
 
 var (
@@ -45,13 +48,15 @@ func main() {
 		log.Fatal().Stack().Err(err).Msg("Could not get proxy timeout")
 	}
 
-	ctx, cancelCtx := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, cancelCtx := signal.NotifyContext(
+		context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancelCtx()
 
 	ctxMng := contextmanager.Get().WithContext(ctx)
 
 	clock := ctxMng.GetClock()
 	telemetryWriter := logging.ConfigureLogger(lunarEngine, true, clock)
+	recentLogs := logging.KeepRecentLogs(clock, recentLogsMaxEntries)
 
 	if environment.IsEngineFailsafeEnabled() {
 		log.Info().Msg("Engine failsafe is enabled, setting up failsafe handler.")
@@ -76,6 +81,7 @@ func main() {
 	}
 
 	handlingDataMng := routing.NewHandlingDataManager(proxyTimeout, hubComm)
+	handlingDataMng.SetRecentLogs(recentLogs)
 	if err = handlingDataMng.Setup(); err != nil {
 		log.Panic().Stack().Err(err).Msg("Failed to setup handling data manager")
 	}
@@ -95,16 +101,26 @@ func main() {
 		}
 	}()
 	agent := spoe.New(spoe.Handler(routing.Handler(handlingDataMng)))
+	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%s", lunarEnginePort))
+	if err != nil {
+		log.Panic().Stack().Err(err).Msg("Could not listen for engine SPOE server")
+	}
 
+	go func() {
+		if err := agent.Serve(handlingDataMng.TrackConnections(listener)); err != nil {
+			handlingDataMng.StopDiagnosisWorker()
+			log.Fatal().
+				Stack().
+				Err(err).
+				Msg("Could not bring up engine SPOE server")
+		}
+	}()
 	log.Info().Msg("🚀 Lunar Proxy is up and running")
-	if err := agent.
-		ListenAndServe(fmt.Sprintf("0.0.0.0:%s", lunarEnginePort)); err != nil {
-		handlingDataMng.StopDiagnosisWorker()
-		log.Fatal().
-			Stack().
-			Err(err).
-			Msg("Could not bring up engine SPOE server")
-	}
+
+	// Transactions keep being processed while shutting down,
+	// so the admitted ones can complete within the grace period
+	<-ctx.Done()
+	handlingDataMng.ShutdownGracefully()
 }
 
 func getProxyTimeout() (time.Duration, error) {
@@ -15,8 +15,10 @@ func (request *OnRequest) DeepCopy() OnRequest {
 		Path:           strings.Clone(request.Path),
 		Query:          strings.Clone(request.Query),
 		Headers:        utils.DeepCopyHeaders(request.Headers),
+		HeaderValues:   utils.DeepCopyHeaderValues(request.HeaderValues),
 		Body:           strings.Clone(request.Body),
 		Time:           request.Time,
+		ConfigVersion:  strings.Clone(request.ConfigVersion),
 		parsedURL:      request.parsedURL,
 		parsedURLParts: request.parsedURLParts,
 	}
@@ -24,13 +26,15 @@ func (request *OnRequest) DeepCopy() OnRequest {
 
 func (response *OnResponse) DeepCopy() OnResponse {
 	return OnResponse{
-		ID:         strings.Clone(response.ID),
-		SequenceID: strings.Clone(response.SequenceID),
-		Method:     strings.Clone(response.Method),
-		URL:        strings.Clone(response.URL),
-		Status:     response.Status, // int is immutable
-		Headers:    utils.DeepCopyHeaders(response.Headers),
-		Body:       strings.Clone(response.Body),
-		Time:       response.Time,
+		ID:            strings.Clone(response.ID),
+		SequenceID:    strings.Clone(response.SequenceID),
+		Method:        strings.Clone(response.Method),
+		URL:           strings.Clone(response.URL),
+		Status:        response.Status, // int is immutable
+		Headers:       utils.DeepCopyHeaders(response.Headers),
+		HeaderValues:  utils.DeepCopyHeaderValues(response.HeaderValues),
+		Body:          strings.Clone(response.Body),
+		Time:          response.Time,
+		ConfigVersion: strings.Clone(response.ConfigVersion),
 	}
 }
@@ -2,25 +2,108 @@ package messages
 
 import (
 	"fmt"
+	"lunar/engine/utils"
+	"lunar/engine/utils/bodysize"
+	sharedConfig "lunar/shared-model/config"
+	"net/http"
 	"net/url"
+	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	upgradeHeaderName = "Upgrade"
+	webSocketProtocol = "websocket"
 )
 
 type OnRequest struct {
-	ID             string
-	SequenceID     string
-	Method         string
-	Scheme         string
-	URL            string
-	Path           string
-	Query          string
-	Headers        map[string]string
-	Body           string
-	Time           time.Time
+	ID           string
+	SequenceID   string
+	ConnectionID string
+	ClientIP     string
+	// Empty unless the request was sent over mutual TLS
+	ClientCertificate ClientCertificate
+	Method            string
+	Scheme            string
+	URL               string
+	Path              string
+	Query             string
+	// Headers holds the first value of each header, in its canonical case
+	Headers map[string]string
+	// HeaderValues holds every value of each header, for lookups by Header
+	HeaderValues utils.HeaderValues
+	Body         string
+	Time         time.Time
+	// ConfigVersion is the version of the config handling the request
+	ConfigVersion string
+	// SpanContext is of the span the request is traced in upstream,
+	// it is invalid unless tracing is enabled
+	SpanContext trace.SpanContext
+	// Deadline is zero unless the client told how long it waits
+	Deadline       RequestDeadline
 	parsedURL      *url.URL
 	parsedURLParts parsedURLParts
 }
 
+// RequestDeadline is until when the client waits for the response to its
+// request, as it told by the request timeout header
+type RequestDeadline struct {
+	At time.Time
+	// The status the request is responded with once the deadline passed
+	ExceededStatusCode int
+}
+
+func (deadline RequestDeadline) IsSet() bool {
+	return !deadline.At.IsZero()
+}
+
+// Remaining is the time left until the deadline, negative once it passed
+func (deadline RequestDeadline) Remaining(now time.Time) time.Duration {
+	return deadline.At.Sub(now)
+}
+
+// ClientCertificate identifies the client of a mutual TLS connection by the
+// certificate it presented. It is only set once the certificate was verified,
+// so unlike a header it cannot be spoofed.
+type ClientCertificate struct {
+	Subject string
+	// The hex encoded SHA-256 of the DER encoded certificate
+	Fingerprint string
+}
+
+// Header looks the header up case-insensitively, resolving a header sent
+// multiple times by the given policy. Looking headers up normalizes neither
+// Headers nor HeaderValues, so the headers forwarded upstream are unaltered.
+func (onRequest *OnRequest) Header(
+	name string,
+	multiValue sharedConfig.MultiValueHeader,
+) (string, bool) {
+	return utils.LookupHeader(onRequest.Headers, onRequest.HeaderValues,
+		name, multiValue)
+}
+
+// IsWebSocketUpgrade is whether the request asks to switch its connection
+// to a WebSocket, so its response is followed by a stream rather than a body
+func (onRequest *OnRequest) IsWebSocketUpgrade() bool {
+	return upgradesToWebSocket(onRequest)
+}
+
+// SetHeader sets the header to a single value, for lookups by Header
+// to see the header as it will be forwarded upstream
+func (onRequest *OnRequest) SetHeader(name string, value string) {
+	onRequest.Headers[name] = value
+	onRequest.HeaderValues.SetHeaderValue(name, value)
+}
+
+// BodySize estimates the size of the request body by the given estimation.
+// An empty body is taken as not buffered, its size being known from its
+// Content-Length only, so requests on the no-buffer path are not counted.
+func (onRequest *OnRequest) BodySize(estimation bodysize.Estimation) bodysize.Size {
+	return bodysize.EstimateInMemory(onRequest, onRequest.Body, estimation)
+}
+
 type parsedURLParts struct {
 	scheme string
 	url    string
@@ -53,14 +136,61 @@ func (onRequest *OnRequest) ParsedURL() (*url.URL, error) {
 }
 
 type OnResponse struct {
-	ID         string
-	SequenceID string
-	Method     string
-	URL        string
-	Status     int
-	Headers    map[string]string
-	Body       string
-	Time       time.Time
+	ID                string
+	SequenceID        string
+	ConnectionID      string
+	ClientCertificate ClientCertificate
+	Method            string
+	URL               string
+	Status            int
+	// Headers holds the first value of each header, in its canonical case
+	Headers map[string]string
+	// HeaderValues holds every value of each header, for lookups by Header
+	HeaderValues utils.HeaderValues
+	Body         string
+	Time         time.Time
+	// The Accept-Encoding header of the request, forwarded along with the response
+	AcceptEncoding string
+	// The time the upstream took to send the response headers, if known
+	UpstreamDuration time.Duration
+	// ConfigVersion is the version of the config handling the response
+	ConfigVersion string
+}
+
+// Header looks the header up case-insensitively, resolving a header sent
+// multiple times by the given policy
+func (onResponse *OnResponse) Header(
+	name string,
+	multiValue sharedConfig.MultiValueHeader,
+) (string, bool) {
+	return utils.LookupHeader(onResponse.Headers, onResponse.HeaderValues,
+		name, multiValue)
+}
+
+// IsWebSocketUpgrade is whether the response switched its connection to a
+// WebSocket, so it is followed by a long-lived stream rather than a body
+func (onResponse *OnResponse) IsWebSocketUpgrade() bool {
+	return onResponse.Status == http.StatusSwitchingProtocols &&
+		upgradesToWebSocket(onResponse)
+}
+
+func upgradesToWebSocket(headers sharedConfig.HeaderLookup) bool {
+	upgrade, found := headers.Header(upgradeHeaderName, sharedConfig.MultiValueHeaderJoin)
+	if !found {
+		return false
+	}
+	for _, protocol := range strings.Split(upgrade, ",") {
+		if strings.EqualFold(strings.TrimSpace(protocol), webSocketProtocol) {
+			return true
+		}
+	}
+	return false
+}
+
+// BodySize estimates the size of the response body by the given estimation,
+// as the size of the request body is
+func (onResponse *OnResponse) BodySize(estimation bodysize.Estimation) bodysize.Size {
+	return bodysize.EstimateInMemory(onResponse, onResponse.Body, estimation)
 }
 
 func (onResponse *OnResponse) IsNewSequence() bool {
@@ -2,6 +2,9 @@ package messages_test
 
 import (
 	"lunar/engine/messages"
+	"lunar/engine/utils"
+	"lunar/engine/utils/bodysize"
+	sharedConfig "lunar/shared-model/config"
 	"net/url"
 	"testing"
 	"time"
@@ -109,3 +112,89 @@ func TestItReturnsUpdatedParsedURLWhenQueryChanged(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, url.Values(map[string][]string{}), res.Query())
 }
+
+func TestHeaderIsLookedUpCaseInsensitively(t *testing.T) {
+	rawHeaders := "X-Tier: free\nX-TIER: premium\nAuthorization: bla\n"
+	onRequest := messages.OnRequest{} //nolint:exhaustruct
+	onRequest.Headers, onRequest.HeaderValues = utils.ParseHeadersWithValues(&rawHeaders)
+	forwarded := utils.DeepCopyHeaders(onRequest.Headers)
+
+	for _, name := range []string{"x-tier", "X-Tier", "X-TIER"} {
+		value, found := onRequest.Header(name, sharedConfig.MultiValueHeaderFirst)
+		assert.True(t, found)
+		assert.Equal(t, "free", value)
+	}
+	value, _ := onRequest.Header("x-tier", sharedConfig.MultiValueHeaderLast)
+	assert.Equal(t, "premium", value)
+	value, _ = onRequest.Header("x-tier", sharedConfig.MultiValueHeaderJoin)
+	assert.Equal(t, "free, premium", value)
+	value, _ = onRequest.Header("AUTHORIZATION", sharedConfig.MultiValueHeaderJoin)
+	assert.Equal(t, "bla", value)
+	_, found := onRequest.Header("X-Missing", sharedConfig.MultiValueHeaderFirst)
+	assert.False(t, found)
+
+	// Looking headers up does not alter the headers forwarded upstream
+	assert.Equal(t, forwarded, onRequest.Headers)
+}
+
+func TestHeaderFallsBackToHeadersWithoutValues(t *testing.T) {
+	onRequest := messages.OnRequest{ //nolint:exhaustruct
+		Headers: map[string]string{"X-Tier": "free"},
+	}
+	value, found := onRequest.Header("x-tier", sharedConfig.MultiValueHeaderLast)
+	assert.True(t, found)
+	assert.Equal(t, "free", value)
+
+	onRequest.HeaderValues = utils.HeaderValues{"x-tier": {"free"}}
+	onRequest.SetHeader("X-Tier", "premium")
+	value, _ = onRequest.Header("X-TIER", sharedConfig.MultiValueHeaderJoin)
+	assert.Equal(t, "premium", value)
+	assert.Equal(t, map[string]string{"X-Tier": "premium"}, onRequest.Headers)
+}
+
+func TestBodySizeOfUnbufferedBodyIsItsContentLength(t *testing.T) {
+	onRequest := messages.OnRequest{ //nolint:exhaustruct
+		Headers: map[string]string{"Content-Length": "1024"},
+	}
+	size := onRequest.BodySize(bodysize.EstimationCounted)
+	assert.Equal(t, int64(1024), size.Bytes)
+	assert.Equal(t, bodysize.SourceContentLength, size.Source)
+
+	onRequest.Body = "hello"
+	size = onRequest.BodySize(bodysize.EstimationCounted)
+	assert.Equal(t, int64(5), size.Bytes)
+	assert.Equal(t, bodysize.SourceCounted, size.Source)
+}
+
+func TestWebSocketUpgradeIsTheResponseSwitchingToAWebSocket(t *testing.T) {
+	for headers, isWebSocketUpgrade := range map[string]bool{
+		"Upgrade: websocket\n":               true,
+		"Upgrade: h2c, WebSocket\n":          true,
+		"upgrade: h2c\nUpgrade: websocket\n": true,
+		"Upgrade: h2c\n":                     false,
+		"Connection: Upgrade\n":              false,
+	} {
+		rawHeaders := headers
+		onResponse := messages.OnResponse{Status: 101} //nolint:exhaustruct
+		onResponse.Headers, onResponse.HeaderValues = utils.ParseHeadersWithValues(&rawHeaders)
+		assert.Equal(t, isWebSocketUpgrade, onResponse.IsWebSocketUpgrade(), headers)
+
+		// Responses which did not switch protocols are no upgrade
+		onResponse.Status = 200
+		assert.False(t, onResponse.IsWebSocketUpgrade(), headers)
+	}
+}
+
+func TestWebSocketUpgradeIsTheRequestAskingForAWebSocket(t *testing.T) {
+	for headers, isWebSocketUpgrade := range map[string]bool{
+		"Upgrade: websocket\n":      true,
+		"Upgrade: h2c, WebSocket\n": true,
+		"Upgrade: h2c\n":            false,
+		"Connection: Upgrade\n":     false,
+	} {
+		rawHeaders := headers
+		onRequest := messages.OnRequest{} //nolint:exhaustruct
+		onRequest.Headers, onRequest.HeaderValues = utils.ParseHeadersWithValues(&rawHeaders)
+		assert.Equal(t, isWebSocketUpgrade, onRequest.IsWebSocketUpgrade(), headers)
+	}
+}
@@ -1,19 +1,30 @@
 package routing
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io"
+	"lunar/engine/communication"
 	"lunar/engine/config"
+	streamtypes "lunar/engine/streams/types"
 	"lunar/engine/utils/writers"
+	"lunar/toolkit-core/network"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 )
 
 const (
 	managedKey string = "LUNAR_MANAGED"
+
+	bearerAuthorizationPrefix = "Bearer "
+
+	// Matches the URL tree split threshold the discovery is aggregated with
+	discoveryOpenAPIMaxSplitThreshold = 50
 )
 
 var (
@@ -120,6 +131,240 @@ func HandleValidatePolicies() func(http.ResponseWriter, *http.Request) {
 	}
 }
 
+// HandleCanaryPolicies starts a canary of the posted policies config on the
+// fraction of the transactions given by the `fraction` query parameter,
+// sampled by the optional `seed` query parameter, or rolls it back on DELETE
+func HandleCanaryPolicies(
+	policyAccessor *config.TxnPoliciesAccessor,
+) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				handleError(writer,
+					"Error reading request body",
+					http.StatusUnprocessableEntity, err)
+				return
+			}
+			defer req.Body.Close()
+
+			query := req.URL.Query()
+			fraction, err := strconv.ParseFloat(query.Get("fraction"), 64)
+			if err != nil {
+				handleError(writer,
+					"Invalid canary fraction",
+					http.StatusBadRequest, err)
+				return
+			}
+			var seed uint64
+			if rawSeed := query.Get("seed"); rawSeed != "" {
+				seed, err = strconv.ParseUint(rawSeed, 10, 64)
+				if err != nil {
+					handleError(writer,
+						"Invalid canary seed",
+						http.StatusBadRequest, err)
+					return
+				}
+			}
+
+			err = policyAccessor.StartCanary(body, fraction, seed)
+			if err != nil {
+				handleError(writer,
+					"Failed to start canary policies",
+					http.StatusUnprocessableEntity, err)
+				return
+			}
+			SuccessResponse(writer, "✅ Successfully started canary policies")
+		case http.MethodDelete:
+			err := policyAccessor.RollbackCanary()
+			if err != nil {
+				handleError(writer,
+					"Failed to roll back canary policies",
+					http.StatusConflict, err)
+				return
+			}
+			SuccessResponse(writer, "✅ Successfully rolled back canary policies")
+		default:
+			http.Error(writer, "Unsupported Method", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func HandlePromoteCanaryPolicies(
+	policyAccessor *config.TxnPoliciesAccessor,
+) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			err := policyAccessor.PromoteCanary()
+			if err != nil {
+				handleError(writer,
+					"Failed to promote canary policies",
+					http.StatusConflict, err)
+				return
+			}
+			SuccessResponse(writer, "✅ Successfully promoted canary policies")
+		default:
+			http.Error(writer, "Unsupported Method", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// HandleSelfTest reports the result of the latest self-test run, failing
+// with 503 if it failed or did not run yet, so it can serve as a readiness
+// check. It passes if the self-test is disabled.
+func HandleSelfTest(selfTest *SelfTest) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(writer, "Unsupported Method", http.StatusMethodNotAllowed)
+			return
+		}
+		if selfTest == nil {
+			SuccessResponse(writer, "Self-test is disabled")
+			return
+		}
+		result, ran := selfTest.Result()
+		if !ran {
+			http.Error(writer, "Self-test did not run yet", http.StatusServiceUnavailable)
+			return
+		}
+		status := http.StatusOK
+		if !result.Passed {
+			status = http.StatusServiceUnavailable
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(status)
+		if err := json.NewEncoder(writer).Encode(result); err != nil {
+			log.Error().Err(err).Stack().Msg("Failed encoding response")
+		}
+	}
+}
+
+// HandleMaintenanceMode returns the maintenance mode on GET, puts the routes
+// of the posted maintenance mode in it on POST or PUT, and clears it on DELETE
+func HandleMaintenanceMode(
+	maintenanceMode *MaintenanceMode,
+) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusOK)
+			err := json.NewEncoder(writer).Encode(maintenanceMode.Current())
+			if err != nil {
+				log.Error().Err(err).Stack().Msg("Failed encoding response")
+			}
+		case http.MethodPost, http.MethodPut:
+			var data network.MaintenanceModeData
+			if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
+				handleError(writer,
+					"Error reading maintenance mode",
+					http.StatusUnprocessableEntity, err)
+				return
+			}
+			defer req.Body.Close()
+			data.Active = true
+			if err := maintenanceMode.Activate(data); err != nil {
+				handleError(writer,
+					"Failed to activate maintenance mode",
+					http.StatusUnprocessableEntity, err)
+				return
+			}
+			SuccessResponse(writer, "✅ Successfully activated maintenance mode")
+		case http.MethodDelete:
+			if !maintenanceMode.Clear() {
+				SuccessResponse(writer, "Maintenance mode was not active")
+				return
+			}
+			SuccessResponse(writer, "✅ Successfully cleared maintenance mode")
+		default:
+			http.Error(writer, "Unsupported Method", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// HandleFeatureFlags returns the feature flags on GET, sets the posted flags
+// on POST or PUT keeping the others, and removes the flags named by the
+// name query parameters on DELETE, or all of them if none is named
+func HandleFeatureFlags(
+	featureFlags *streamtypes.FeatureFlags,
+) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusOK)
+			err := json.NewEncoder(writer).Encode(featureFlags.All())
+			if err != nil {
+				log.Error().Err(err).Stack().Msg("Failed encoding response")
+			}
+		case http.MethodPost, http.MethodPut:
+			var flags map[string]interface{}
+			if err := json.NewDecoder(req.Body).Decode(&flags); err != nil {
+				handleError(writer,
+					"Error reading feature flags",
+					http.StatusUnprocessableEntity, err)
+				return
+			}
+			defer req.Body.Close()
+			if err := featureFlags.Set(flags); err != nil {
+				handleError(writer,
+					"Failed to set feature flags",
+					http.StatusUnprocessableEntity, err)
+				return
+			}
+			SuccessResponse(writer, "✅ Successfully set feature flags")
+		case http.MethodDelete:
+			names := req.URL.Query()["name"]
+			if len(names) == 0 {
+				_ = featureFlags.Replace(map[string]interface{}{})
+				SuccessResponse(writer, "✅ Successfully removed all feature flags")
+				return
+			}
+			featureFlags.Delete(names...)
+			SuccessResponse(writer, "✅ Successfully removed feature flags")
+		default:
+			http.Error(writer, "Unsupported Method", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// HandleDiagnosticBundle responds with the diagnostic bundle, a gzipped
+// tarball, on GET. Requests must bear the admin API token, and the bundle is
+// disabled unless the token is set, as it holds the engine's (redacted) state.
+func HandleDiagnosticBundle(
+	adminAPIToken string,
+	bundle *DiagnosticBundle,
+) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(writer, "Unsupported Method", http.StatusMethodNotAllowed)
+			return
+		}
+		if adminAPIToken == "" {
+			http.Error(writer, "Diagnostic bundle is disabled, "+
+				"the admin API token is not set", http.StatusForbidden)
+			return
+		}
+		token, found := strings.CutPrefix(
+			req.Header.Get("Authorization"), bearerAuthorizationPrefix)
+		if !found ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(adminAPIToken)) != 1 {
+			writer.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/gzip")
+		writer.Header().Set("Content-Disposition",
+			`attachment; filename="lunar-diagnostic-bundle.tar.gz"`)
+		if err := bundle.Write(writers.NopCloser(writer)); err != nil {
+			log.Error().Err(err).Msg("Failed writing diagnostic bundle")
+		}
+	}
+}
+
 func HandleJSONFileRead(location string) func(
 	http.ResponseWriter, *http.Request) {
 	return func(writer http.ResponseWriter, req *http.Request) {
@@ -139,6 +384,37 @@ func HandleJSONFileRead(location string) func(
 	}
 }
 
+// HandleDiscoveryOpenAPI drafts an OpenAPI document of the discovered
+// endpoints, responding with the document itself so it can be saved as is
+func HandleDiscoveryOpenAPI(location string) func(
+	http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			output, err := communication.ReadDiscoveryState(location)
+			if err != nil {
+				handleError(writer,
+					fmt.Sprintf("Failed to read %s: %v", location, err),
+					http.StatusUnprocessableEntity, err)
+				return
+			}
+			document, err := output.ToOpenAPI(discoveryOpenAPIMaxSplitThreshold)
+			if err != nil {
+				handleError(writer, "Failed to draft OpenAPI document",
+					http.StatusUnprocessableEntity, err)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusOK)
+			if err := json.NewEncoder(writer).Encode(&document); err != nil {
+				log.Error().Err(err).Stack().Msg("Failed encoding response")
+			}
+		default:
+			http.Error(writer, "Unsupported Method", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
 func HandleHandshake() func(
 	http.ResponseWriter, *http.Request) {
 	return func(writer http.ResponseWriter, req *http.Request) {
@@ -0,0 +1,63 @@
+package routing
+
+import (
+	"lunar/engine/messages"
+
+	spoe "github.com/TheLunarCompany/haproxy-spoe-go"
+)
+
+const (
+	// verifiedCertificateResult is the verification result HAProxy reports
+	// (as `ssl_c_verify`) for a client certificate which was verified
+	verifiedCertificateResult = 0
+	// unknownCertificateResult stands for a missing verification result,
+	// so it is not mistaken for a successful verification
+	unknownCertificateResult = -1
+)
+
+// clientCertificateArgs collects the client certificate HAProxy reports
+// with a message. On connections which are not TLS, or on which
+// the client presented no certificate, the arguments are missing.
+type clientCertificateArgs struct {
+	used         bool
+	verifyResult int
+	subject      string
+	fingerprint  string
+}
+
+func newClientCertificateArgs() clientCertificateArgs {
+	return clientCertificateArgs{
+		used:         false,
+		verifyResult: unknownCertificateResult,
+		subject:      "",
+		fingerprint:  "",
+	}
+}
+
+func (args *clientCertificateArgs) read(arg *spoe.Arg) {
+	switch arg.Name {
+	case "client_cert_used":
+		args.used = extractArg[bool](arg)
+	case "client_cert_verify":
+		args.verifyResult = unknownCertificateResult
+		if arg.Value != nil {
+			args.verifyResult = extractArg[int](arg)
+		}
+	case "client_cert_subject":
+		args.subject = extractArg[string](arg)
+	case "client_cert_fingerprint":
+		args.fingerprint = extractArg[string](arg)
+	}
+}
+
+// verified returns the client certificate, only if it was verified
+func (args clientCertificateArgs) verified() messages.ClientCertificate {
+	if !args.used || args.verifyResult != verifiedCertificateResult ||
+		args.fingerprint == "" {
+		return messages.ClientCertificate{}
+	}
+	return messages.ClientCertificate{
+		Subject:     args.subject,
+		Fingerprint: args.fingerprint,
+	}
+}
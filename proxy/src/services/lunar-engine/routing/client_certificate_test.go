@@ -0,0 +1,60 @@
+package routing
+
+import (
+	"lunar/engine/messages"
+	"testing"
+
+	spoe "github.com/TheLunarCompany/haproxy-spoe-go"
+	"github.com/stretchr/testify/require"
+)
+
+func readClientCertificate(values map[string]interface{}) messages.ClientCertificate {
+	args := newClientCertificateArgs()
+	for name, value := range values {
+		args.read(&spoe.Arg{Name: name, Value: value})
+	}
+	return args.verified()
+}
+
+func TestVerifiedClientCertificateIsRead(t *testing.T) {
+	certificate := readClientCertificate(map[string]interface{}{
+		"client_cert_used":        true,
+		"client_cert_verify":      0,
+		"client_cert_subject":     "/C=US/O=Acme/CN=billing",
+		"client_cert_fingerprint": "9f86d081884c7d65",
+	})
+
+	require.Equal(t, messages.ClientCertificate{
+		Subject:     "/C=US/O=Acme/CN=billing",
+		Fingerprint: "9f86d081884c7d65",
+	}, certificate)
+}
+
+func TestClientCertificateIsEmptyWithoutMutualTLS(t *testing.T) {
+	// Over plain connections, HAProxy has no sample for the arguments
+	require.Equal(t, messages.ClientCertificate{}, readClientCertificate(
+		map[string]interface{}{
+			"client_cert_used":        nil,
+			"client_cert_verify":      nil,
+			"client_cert_subject":     nil,
+			"client_cert_fingerprint": nil,
+		}))
+	// Over TLS connections on which the client presented no certificate
+	require.Equal(t, messages.ClientCertificate{}, readClientCertificate(
+		map[string]interface{}{
+			"client_cert_used":   false,
+			"client_cert_verify": 0,
+		}))
+}
+
+func TestUnverifiedClientCertificateIsIgnored(t *testing.T) {
+	for _, verifyResult := range []interface{}{nil, 10, 20} {
+		require.Equal(t, messages.ClientCertificate{}, readClientCertificate(
+			map[string]interface{}{
+				"client_cert_used":        true,
+				"client_cert_verify":      verifyResult,
+				"client_cert_subject":     "/CN=billing",
+				"client_cert_fingerprint": "9f86d081884c7d65",
+			}), verifyResult)
+	}
+}
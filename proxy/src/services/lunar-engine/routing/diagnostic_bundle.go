@@ -0,0 +1,307 @@
+package routing
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lunar/engine/services/remedies"
+	"lunar/engine/utils/obfuscation"
+	"lunar/engine/utils/writers"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"lunar/toolkit-core/logging"
+	"lunar/toolkit-core/otel"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultDiagnosticBundleTimeout       = 10 * time.Second
+	defaultDiagnosticBundleMaxEntryBytes = 4 * 1024 * 1024
+	diagnosticBundleFileMode             = 0o444
+)
+
+var errDiagnosticBundleTimeout = errors.New("timed out gathering the entry")
+
+// redactedConfigKeys hold credentials, so every value under them is
+// obfuscated in the bundled config. Under tokensConfigKey, only the token
+// values are, so the names of the headers and fields they are sent in remain.
+var redactedConfigKeys = map[string]bool{
+	"password": true,
+	"token":    true,
+	"secret":   true,
+}
+
+const (
+	tokensConfigKey     = "tokens"
+	tokenValueConfigKey = "value"
+)
+
+// DiagnosticBundleSources are what a diagnostic bundle is gathered from.
+// Sources which are not available in the engine's mode are left unset,
+// and are not bundled.
+type DiagnosticBundleSources struct {
+	BuildInfo    otel.BuildInfo
+	Policies     func() *sharedConfig.PoliciesConfig
+	Queues       func() []remedies.PendingQueue
+	Throttles    func() []remedies.RemedyWindowUsage
+	Metrics      prometheus.Gatherer
+	HubConnected func() bool
+	RecentLogs   *logging.RecentLogs
+}
+
+// DiagnosticBundle gathers the state of the engine for support escalations:
+// its resolved config (with the credentials in it obfuscated), the queues and
+// throttles, the current metrics, the Lunar Hub connection, its version
+// and its recent warnings and errors.
+// Gathering the bundle only reads the state. It is bounded in time, entries
+// not gathered in time are left out with an error, and in size, each entry
+// being truncated once larger than the max entry size.
+type DiagnosticBundle struct {
+	clock         clock.Clock
+	obfuscator    obfuscation.Obfuscator
+	sources       DiagnosticBundleSources
+	timeout       time.Duration
+	maxEntryBytes int
+}
+
+// DiagnosticBundleManifest describes the entries of a bundle
+type DiagnosticBundleManifest struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Entries     []string  `json:"entries"`
+	// Entries which were larger than the max entry size
+	Truncated []string `json:"truncated"`
+	// Entries which failed to be gathered, by their error
+	Errors map[string]string `json:"errors"`
+}
+
+type diagnosticBundleEntry struct {
+	name    string
+	content []byte
+	err     error
+}
+
+func NewDiagnosticBundle(
+	clock clock.Clock,
+	obfuscator obfuscation.Obfuscator,
+	sources DiagnosticBundleSources,
+	timeout time.Duration,
+	maxEntryBytes int,
+) *DiagnosticBundle {
+	return &DiagnosticBundle{
+		clock:         clock,
+		obfuscator:    obfuscator,
+		sources:       sources,
+		timeout:       timeout,
+		maxEntryBytes: maxEntryBytes,
+	}
+}
+
+// Write gathers the bundle and writes it to the writer as a gzipped tarball,
+// along with a manifest.json describing its entries
+func (bundle *DiagnosticBundle) Write(writer writers.Writer) error {
+	manifest := DiagnosticBundleManifest{
+		GeneratedAt: bundle.clock.Now(),
+		Entries:     []string{},
+		Truncated:   []string{},
+		Errors:      map[string]string{},
+	}
+	entries := []diagnosticBundleEntry{}
+	for _, entry := range bundle.gather() {
+		if entry.err != nil {
+			manifest.Errors[entry.name] = entry.err.Error()
+			continue
+		}
+		if len(entry.content) > bundle.maxEntryBytes {
+			entry.content = entry.content[:bundle.maxEntryBytes]
+			manifest.Truncated = append(manifest.Truncated, entry.name)
+		}
+		manifest.Entries = append(manifest.Entries, entry.name)
+		entries = append(entries, entry)
+	}
+	manifestContent, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	entries = append([]diagnosticBundleEntry{{
+		name:    "manifest.json",
+		content: manifestContent,
+		err:     nil,
+	}}, entries...)
+
+	gzipWriter := gzip.NewWriter(writer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, entry := range entries {
+		err := tarWriter.WriteHeader(&tar.Header{ //nolint:exhaustruct
+			Name:    entry.name,
+			Mode:    diagnosticBundleFileMode,
+			Size:    int64(len(entry.content)),
+			ModTime: manifest.GeneratedAt,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tarWriter.Write(entry.content); err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+// gather gathers the entries of the available sources concurrently, leaving
+// the entries not gathered once the timeout passed with an error
+func (bundle *DiagnosticBundle) gather() []diagnosticBundleEntry {
+	gatherers := bundle.entryGatherers()
+	results := make([]chan diagnosticBundleEntry, len(gatherers))
+	for i, gatherer := range gatherers {
+		results[i] = make(chan diagnosticBundleEntry, 1)
+		go func(gatherer entryGatherer, result chan<- diagnosticBundleEntry) {
+			content, err := gatherer.gather()
+			result <- diagnosticBundleEntry{name: gatherer.name, content: content, err: err}
+		}(gatherer, results[i])
+	}
+
+	timedOut := make(chan struct{})
+	go func() {
+		<-bundle.clock.After(bundle.timeout)
+		close(timedOut)
+	}()
+	entries := make([]diagnosticBundleEntry, 0, len(gatherers))
+	for i, gatherer := range gatherers {
+		select {
+		case entry := <-results[i]:
+			entries = append(entries, entry)
+		case <-timedOut:
+			// entries gathered in time are kept, even once the timeout passed
+			select {
+			case entry := <-results[i]:
+				entries = append(entries, entry)
+			default:
+				entries = append(entries, diagnosticBundleEntry{
+					name:    gatherer.name,
+					content: nil,
+					err:     errDiagnosticBundleTimeout,
+				})
+			}
+		}
+	}
+	return entries
+}
+
+type entryGatherer struct {
+	name   string
+	gather func() ([]byte, error)
+}
+
+func (bundle *DiagnosticBundle) entryGatherers() []entryGatherer {
+	sources := bundle.sources
+	gatherers := []entryGatherer{
+		{name: "version.json", gather: func() ([]byte, error) {
+			return marshalEntry(sources.BuildInfo)
+		}},
+		{name: "hub.json", gather: func() ([]byte, error) {
+			hub := map[string]bool{"connected": false}
+			if sources.HubConnected != nil {
+				hub["connected"] = sources.HubConnected()
+			}
+			return marshalEntry(hub)
+		}},
+	}
+	if sources.Policies != nil {
+		gatherers = append(gatherers, entryGatherer{
+			name:   "config.yaml",
+			gather: func() ([]byte, error) { return bundle.redactedPolicies() },
+		})
+	}
+	if sources.Queues != nil {
+		gatherers = append(gatherers, entryGatherer{
+			name:   "queues.json",
+			gather: func() ([]byte, error) { return marshalEntry(sources.Queues()) },
+		})
+	}
+	if sources.Throttles != nil {
+		gatherers = append(gatherers, entryGatherer{
+			name:   "throttles.json",
+			gather: func() ([]byte, error) { return marshalEntry(sources.Throttles()) },
+		})
+	}
+	if sources.Metrics != nil {
+		gatherers = append(gatherers, entryGatherer{
+			name:   "metrics.txt",
+			gather: func() ([]byte, error) { return gatherMetrics(sources.Metrics) },
+		})
+	}
+	if sources.RecentLogs != nil {
+		gatherers = append(gatherers, entryGatherer{
+			name: "recent_logs.json",
+			gather: func() ([]byte, error) {
+				return marshalEntry(sources.RecentLogs.Entries())
+			},
+		})
+	}
+	return gatherers
+}
+
+func marshalEntry(value any) ([]byte, error) {
+	return json.MarshalIndent(value, "", "  ")
+}
+
+func gatherMetrics(gatherer prometheus.Gatherer) ([]byte, error) {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	var content bytes.Buffer
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(&content, family); err != nil {
+			return nil, err
+		}
+	}
+	return content.Bytes(), nil
+}
+
+// redactedPolicies is the resolved policies config, as YAML,
+// with the credentials in it obfuscated by the bundle's obfuscator
+func (bundle *DiagnosticBundle) redactedPolicies() ([]byte, error) {
+	policies := bundle.sources.Policies()
+	if policies == nil {
+		return nil, errors.New("no policies config is loaded")
+	}
+	var document yaml.Node
+	if err := document.Encode(policies); err != nil {
+		return nil, fmt.Errorf("failed to encode policies config: %w", err)
+	}
+	bundle.redact(&document, false, false)
+	return yaml.Marshal(&document)
+}
+
+func (bundle *DiagnosticBundle) redact(node *yaml.Node, isRedacted bool, inTokens bool) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if isRedacted && node.Value != "" {
+			node.Value = bundle.obfuscator.ObfuscateString(node.Value)
+			node.Tag = "!!str"
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			isValueRedacted := isRedacted || redactedConfigKeys[key] ||
+				(inTokens && key == tokenValueConfigKey)
+			bundle.redact(node.Content[i+1], isValueRedacted,
+				inTokens || key == tokensConfigKey)
+		}
+	case yaml.DocumentNode, yaml.SequenceNode, yaml.AliasNode:
+		for _, child := range node.Content {
+			bundle.redact(child, isRedacted, inTokens)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package routing
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"lunar/engine/services/remedies"
+	"lunar/engine/utils/obfuscation"
+	"lunar/engine/utils/writers"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"lunar/toolkit-core/logging"
+	"lunar/toolkit-core/otel"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readDiagnosticBundle(t *testing.T, content []byte) map[string]string {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(content))
+	require.NoError(t, err)
+	tarReader := tar.NewReader(gzipReader)
+	entries := map[string]string{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return entries
+		}
+		require.NoError(t, err)
+		entry, err := io.ReadAll(tarReader)
+		require.NoError(t, err)
+		entries[header.Name] = string(entry)
+	}
+}
+
+func diagnosticBundlePolicies() *sharedConfig.PoliciesConfig {
+	return &sharedConfig.PoliciesConfig{ //nolint:exhaustruct
+		Accounts: map[sharedConfig.AccountID]sharedConfig.Account{
+			"production": {
+				Tokens: []sharedConfig.Token{{Header: &sharedConfig.Header{
+					Name:  "Authorization",
+					Value: "Bearer account-secret",
+				}}},
+				Authentication: sharedConfig.Authentication{ //nolint:exhaustruct
+					Basic: &sharedConfig.BasicAuth{
+						Username: "admin",
+						Password: "basic-secret",
+					},
+				},
+			},
+		},
+	}
+}
+
+func writeDiagnosticBundle(t *testing.T, bundle *DiagnosticBundle) map[string]string {
+	var content bytes.Buffer
+	require.NoError(t, bundle.Write(writers.NopCloser(&content)))
+	return readDiagnosticBundle(t, content.Bytes())
+}
+
+func TestDiagnosticBundleHoldsTheEngineStateWithCredentialsRedacted(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{ //nolint:exhaustruct
+		Name: "lunar_test_requests",
+	})
+	registry.MustRegister(counter)
+	counter.Add(3)
+	recentLogs := logging.NewRecentLogs(clock, 10)
+	recentLogs.Run(nil, zerolog.InfoLevel, "Not kept")
+	recentLogs.Run(nil, zerolog.ErrorLevel, "Failed to export")
+	obfuscator := obfuscation.Obfuscator{Hasher: obfuscation.MD5Hasher{}}
+	bundle := NewDiagnosticBundle(clock, obfuscator, DiagnosticBundleSources{
+		BuildInfo: otel.BuildInfo{Version: "1.2.3", GitCommit: "abc", BuildDate: "today"},
+		Policies:  diagnosticBundlePolicies,
+		Queues: func() []remedies.PendingQueue {
+			return []remedies.PendingQueue{{ //nolint:exhaustruct
+				RemedyName: "queue",
+				Requests:   []remedies.PendingQueuedRequest{{ID: "waiting"}}, //nolint:exhaustruct
+			}}
+		},
+		Throttles: func() []remedies.RemedyWindowUsage {
+			return []remedies.RemedyWindowUsage{{RemedyName: "throttle", Quota: 10, Used: 4}} //nolint:exhaustruct
+		},
+		Metrics:      registry,
+		HubConnected: func() bool { return true },
+		RecentLogs:   recentLogs,
+	}, time.Second, 1024*1024)
+
+	entries := writeDiagnosticBundle(t, bundle)
+
+	var manifest DiagnosticBundleManifest
+	require.NoError(t, json.Unmarshal([]byte(entries["manifest.json"]), &manifest))
+	assert.ElementsMatch(t, []string{
+		"version.json", "hub.json", "config.yaml", "queues.json",
+		"throttles.json", "metrics.txt", "recent_logs.json",
+	}, manifest.Entries)
+	assert.Empty(t, manifest.Errors)
+	assert.Empty(t, manifest.Truncated)
+
+	assert.Contains(t, entries["version.json"], `"Version": "1.2.3"`)
+	assert.JSONEq(t, `{"connected": true}`, entries["hub.json"])
+	assert.Contains(t, entries["queues.json"], `"id": "waiting"`)
+	assert.Contains(t, entries["throttles.json"], `"RemedyName": "throttle"`)
+	assert.Contains(t, entries["metrics.txt"], "lunar_test_requests 3")
+	assert.Contains(t, entries["recent_logs.json"], "Failed to export")
+	assert.NotContains(t, entries["recent_logs.json"], "Not kept")
+
+	config := entries["config.yaml"]
+	assert.NotContains(t, config, "secret")
+	assert.Contains(t, config, obfuscator.ObfuscateString("Bearer account-secret"))
+	assert.Contains(t, config, obfuscator.ObfuscateString("basic-secret"))
+	// Only the credentials are redacted
+	assert.Contains(t, config, "Authorization")
+	assert.Contains(t, config, "admin")
+}
+
+func TestDiagnosticBundleIsBoundedInTimeAndSize(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	blocked := make(chan struct{})
+	defer close(blocked)
+	bundle := NewDiagnosticBundle(clock,
+		obfuscation.Obfuscator{Hasher: obfuscation.MD5Hasher{}},
+		DiagnosticBundleSources{ //nolint:exhaustruct
+			Queues: func() []remedies.PendingQueue {
+				<-blocked
+				return nil
+			},
+			Throttles: func() []remedies.RemedyWindowUsage {
+				return make([]remedies.RemedyWindowUsage, 100)
+			},
+		}, time.Second, 64)
+
+	results := make(chan map[string]string, 1)
+	go func() {
+		results <- writeDiagnosticBundle(t, bundle)
+	}()
+	var entries map[string]string
+	require.Eventually(t, func() bool {
+		clock.AdvanceTime(time.Second)
+		select {
+		case entries = <-results:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+
+	var manifest DiagnosticBundleManifest
+	require.NoError(t, json.Unmarshal([]byte(entries["manifest.json"]), &manifest))
+	assert.Equal(t, map[string]string{"queues.json": errDiagnosticBundleTimeout.Error()},
+		manifest.Errors)
+	assert.NotContains(t, entries, "queues.json")
+	assert.Equal(t, []string{"throttles.json"}, manifest.Truncated)
+	assert.Len(t, entries["throttles.json"], 64)
+}
+
+func TestDiagnosticBundleRequiresTheAdminAPIToken(t *testing.T) {
+	t.Parallel()
+	bundle := NewDiagnosticBundle(clock.NewMockClock(),
+		obfuscation.Obfuscator{Hasher: obfuscation.MD5Hasher{}},
+		DiagnosticBundleSources{}, time.Second, 1024) //nolint:exhaustruct
+	requestBundle := func(adminAPIToken string, authorization string) *http.Response {
+		request := httptest.NewRequest(http.MethodGet, "/diagnostic_bundle", nil)
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		recorder := httptest.NewRecorder()
+		HandleDiagnosticBundle(adminAPIToken, bundle)(recorder, request)
+		return recorder.Result()
+	}
+
+	// The bundle is disabled unless the token is set
+	response := requestBundle("", "Bearer ")
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	for _, authorization := range []string{"", "Bearer wrong", "admin-token"} {
+		response := requestBundle("admin-token", authorization)
+		defer response.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode, authorization)
+	}
+
+	response = requestBundle("admin-token", "Bearer admin-token")
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "application/gzip", response.Header.Get("Content-Type"))
+	content, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Contains(t, readDiagnosticBundle(t, content), "manifest.json")
+}
@@ -0,0 +1,92 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	inFlightTransactionsMetricName = "lunar_proxy_engine.in_flight_transactions"
+	spoeConnectionsMetricName      = "lunar_proxy_engine.spoe_connections"
+)
+
+// connectionCounter counts the connections accepted by the listeners it
+// tracks which are still open
+type connectionCounter struct {
+	open atomic.Int64
+}
+
+// track counts the connections accepted by the given listener
+// until they are closed
+func (counter *connectionCounter) track(listener net.Listener) net.Listener {
+	return &countingListener{Listener: listener, counter: counter}
+}
+
+func (counter *connectionCounter) count() int64 {
+	return counter.open.Load()
+}
+
+type countingListener struct {
+	net.Listener
+	counter *connectionCounter
+}
+
+func (listener *countingListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	listener.counter.open.Add(1)
+	return &countedConn{Conn: conn, counter: listener.counter, once: sync.Once{}}, nil
+}
+
+type countedConn struct {
+	net.Conn
+	counter *connectionCounter
+	once    sync.Once
+}
+
+// Close only uncounts the connection once, as it may be closed repeatedly
+func (conn *countedConn) Close() error {
+	conn.once.Do(func() { conn.counter.open.Add(-1) })
+	return conn.Conn.Close()
+}
+
+// registerEngineMetrics registers the gauges of the engine internals,
+// the transactions in flight and the open SPOE connections (from HAProxy)
+func registerEngineMetrics(
+	meter metric.Meter,
+	shutdownState *ShutdownState,
+	connections *connectionCounter,
+) error {
+	inFlight, err := meter.Int64ObservableGauge(
+		inFlightTransactionsMetricName,
+		metric.WithDescription("Transactions admitted and not yet completed"),
+		metric.WithUnit("{transaction}"),
+	)
+	if err != nil {
+		return err
+	}
+	spoeConnections, err := meter.Int64ObservableGauge(
+		spoeConnectionsMetricName,
+		metric.WithDescription("Open SPOE connections from HAProxy"),
+		metric.WithUnit("{connection}"),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = meter.RegisterCallback(
+		func(_ context.Context, observer metric.Observer) error {
+			observer.ObserveInt64(inFlight, int64(shutdownState.InFlightCount()))
+			observer.ObserveInt64(spoeConnections, connections.count())
+			return nil
+		},
+		inFlight,
+		spoeConnections,
+	)
+	return err
+}
@@ -0,0 +1,63 @@
+package routing
+
+import (
+	"context"
+	"lunar/toolkit-core/clock"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func collectEngineGauges(t *testing.T, reader *sdkMetric.ManualReader) map[string]int64 {
+	var data metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &data))
+	gauges := map[string]int64{}
+	for _, scopeMetrics := range data.ScopeMetrics {
+		for _, metric := range scopeMetrics.Metrics {
+			gauge, ok := metric.Data.(metricdata.Gauge[int64])
+			require.True(t, ok)
+			for _, point := range gauge.DataPoints {
+				gauges[metric.Name] += point.Value
+			}
+		}
+	}
+	return gauges
+}
+
+func TestEngineMetricsReportInFlightTransactionsAndOpenConnections(t *testing.T) {
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	shutdownState := NewShutdownState(clock.NewMockClock(), time.Minute, 0, 0)
+	connections := &connectionCounter{} //nolint:exhaustruct
+	require.NoError(t, registerEngineMetrics(meter, shutdownState, connections))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	tracked := connections.track(listener)
+	defer tracked.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := tracked.Accept()
+	require.NoError(t, err)
+	require.True(t, shutdownState.Admit("txn"))
+
+	require.Equal(t, map[string]int64{
+		inFlightTransactionsMetricName: 1,
+		spoeConnectionsMetricName:      1,
+	}, collectEngineGauges(t, reader))
+
+	// Closing a connection again does not uncount it twice
+	require.NoError(t, conn.Close())
+	_ = conn.Close()
+	shutdownState.Complete("txn")
+	require.Equal(t, map[string]int64{
+		inFlightTransactionsMetricName: 0,
+		spoeConnectionsMetricName:      0,
+	}, collectEngineGauges(t, reader))
+}
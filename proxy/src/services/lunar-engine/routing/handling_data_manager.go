@@ -6,16 +6,23 @@ import (
 	"lunar/engine/config"
 	"lunar/engine/runner"
 	"lunar/engine/services"
+	"lunar/engine/services/remedies"
 	"lunar/engine/streams"
+	streamtypes "lunar/engine/streams/types"
 	"lunar/engine/utils/environment"
+	"lunar/engine/utils/obfuscation"
 	"lunar/engine/utils/writers"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
 	contextmanager "lunar/toolkit-core/context-manager"
+	"lunar/toolkit-core/logging"
+	"lunar/toolkit-core/network"
 	"lunar/toolkit-core/otel"
+	"net"
 	"net/http"
 	"time"
 
-	sharedConfig "lunar/shared-model/config"
-
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 	"github.com/samber/lo"
 )
@@ -23,6 +30,13 @@ import (
 const (
 	lunarEngine            string = "lunar-engine"
 	syslogExporterEndpoint string = "127.0.0.1:5140"
+
+	defaultRemotePoliciesInterval = 30 * time.Second
+	defaultDynamicQuotasInterval  = 30 * time.Second
+	defaultDeadLetterMaxFileSize  = 100 * 1024 * 1024
+	defaultDeadLetterMaxFiles     = 5
+	// Failing to start is only delayed this long to report it to Lunar Hub
+	failedStartupReportTimeout = 2 * time.Second
 )
 
 type PoliciesData struct {
@@ -32,6 +46,8 @@ type PoliciesData struct {
 
 type StreamsData struct {
 	stream *streams.Stream
+	// kept across flows reloads, so operators' changes are not lost
+	featureFlags *streamtypes.FeatureFlags
 }
 
 type HandlingDataManager struct {
@@ -40,12 +56,26 @@ type HandlingDataManager struct {
 
 	isStreamsEnabled bool
 	lunarHub         *communication.HubCommunication
+	startupStatus    *communication.StartupStatus
 	writer           writers.Writer
+	upstreamTracer   *UpstreamTracer
+	shutdownState    *ShutdownState
+	requestDeadlines *RequestDeadlines
+	spoeConnections  *connectionCounter
+	maintenanceMode  *MaintenanceMode
+	selfTest         *SelfTest
+	// nil unless export writers are reopened on SIGHUP
+	reopenSignal     *ReopenSignal
 	proxyTimeout     time.Duration
 	policiesServices *services.PoliciesServices
 
+	// nil unless the recent logs are kept, for the diagnostic bundle
+	recentLogs *logging.RecentLogs
+
 	shutdown              func()
 	areMetricsInitialized bool
+	remotePoliciesPoller  *config.RemotePoliciesPoller
+	dynamicQuotasPoller   *remedies.DynamicQuotasPoller
 }
 
 func NewHandlingDataManager(
@@ -53,19 +83,166 @@ func NewHandlingDataManager(
 	hubComm *communication.HubCommunication,
 ) *HandlingDataManager {
 	ctxMng := contextmanager.Get()
+	startupStatus := communication.NewStartupStatus()
+	upstreamTracer := NewUpstreamTracer(ctxMng.GetClock(), proxyTimeout,
+		otel.ForceTraceConfigFromEnv())
 	data := &HandlingDataManager{
-		proxyTimeout: proxyTimeout,
-		lunarHub:     hubComm,
-		writer:       writers.Dial("tcp", syslogExporterEndpoint, ctxMng.GetClock()),
+		proxyTimeout:     proxyTimeout,
+		lunarHub:         hubComm,
+		startupStatus:    startupStatus,
+		writer:           newExportWriter(ctxMng.GetClock(), startupStatus),
+		upstreamTracer:   upstreamTracer,
+		shutdownState:    newShutdownState(ctxMng.GetClock(), proxyTimeout),
+		requestDeadlines: newRequestDeadlinesFromEnv(ctxMng.GetClock(), proxyTimeout),
+		spoeConnections:  &connectionCounter{}, //nolint:exhaustruct
+		StreamsData: StreamsData{
+			stream:       nil,
+			featureFlags: streamtypes.NewFeatureFlags(),
+		},
 	}
 	return data
 }
 
+// newExportWriter writes exported data to the configured syslog targets,
+// by default to a single target, the bundled Fluent Bit.
+// Data which could not be delivered is dead lettered, if configured.
+func newExportWriter(
+	clock clock.Clock,
+	startupStatus *communication.StartupStatus,
+) writers.Writer {
+	deadLetterSink := newDeadLetterSink(startupStatus)
+	defaultWriter := func() writers.Writer {
+		writer := writers.Dial("tcp", syslogExporterEndpoint, clock)
+		if deadLetterSink == nil {
+			return writer
+		}
+		return writers.WithDeadLetterSink(writer, deadLetterSink)
+	}
+
+	rawTargets := environment.GetSyslogTargets()
+	if rawTargets == "" {
+		return defaultWriter()
+	}
+	targets, err := writers.ParseTargetConfigs(rawTargets)
+	if err == nil {
+		var routingWriter *writers.RoutingWriter
+		routingWriter, err = writers.NewRoutingWriter(clock, targets, deadLetterSink)
+		if err == nil {
+			log.Info().Msgf("Exporting to %d syslog targets", len(targets))
+			return routingWriter
+		}
+	}
+	log.Error().Err(err).Msg("Invalid syslog targets, exporting to the default target")
+	startupStatus.Degraded("syslog_targets", err)
+	return defaultWriter()
+}
+
+// newDeadLetterSink sets up the dead letter file if configured,
+// by default it is rotated every 100MB, keeping 5 rotated files
+func newDeadLetterSink(
+	startupStatus *communication.StartupStatus,
+) *writers.DeadLetterSink {
+	path := environment.GetDeadLetterFile()
+	if path == "" {
+		return nil
+	}
+	maxFileSize, err := environment.GetDeadLetterMaxFileSize()
+	if err != nil || maxFileSize <= 0 {
+		maxFileSize = defaultDeadLetterMaxFileSize
+	}
+	maxFiles, err := environment.GetDeadLetterMaxFiles()
+	if err != nil || maxFiles < 0 {
+		maxFiles = defaultDeadLetterMaxFiles
+	}
+	sink, err := writers.NewDeadLetterSink(writers.DeadLetterSinkConfig{
+		Path:        path,
+		MaxFileSize: maxFileSize,
+		MaxFiles:    maxFiles,
+	}, otel.GetMeter)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to set up dead letter file, " +
+			"data which could not be delivered is dropped")
+		startupStatus.Degraded("dead_letter_file", err)
+		return nil
+	}
+	log.Info().Msgf("Data which could not be delivered is dead lettered to %s", path)
+	return sink
+}
+
+// newShutdownState reads the shutdown configuration, by default in flight
+// transactions are given the proxy timeout to complete
+func newShutdownState(
+	clock clock.Clock,
+	proxyTimeout time.Duration,
+) *ShutdownState {
+	gracePeriod, err := environment.GetShutdownGracePeriod()
+	if err != nil || gracePeriod < 0 {
+		gracePeriod = proxyTimeout
+	}
+	retryAfter, err := environment.GetShutdownRetryAfter()
+	if err != nil || retryAfter < 0 {
+		retryAfter = defaultShutdownRetryAfter
+	}
+	return NewShutdownState(clock, proxyTimeout, retryAfter, gracePeriod)
+}
+
+// SetRecentLogs sets the recent logs reported in the diagnostic bundle
+func (rd *HandlingDataManager) SetRecentLogs(recentLogs *logging.RecentLogs) {
+	rd.recentLogs = recentLogs
+}
+
+// TrackConnections counts the open connections accepted by the given
+// SPOE listener, as reported by the engine metrics
+func (rd *HandlingDataManager) TrackConnections(listener net.Listener) net.Listener {
+	return rd.spoeConnections.track(listener)
+}
+
+// Setup initializes the engine and reports the status it started with
+// to Lunar Hub, which is only waited for (briefly) if it failed to start
 func (rd *HandlingDataManager) Setup() error {
+	component := "policies"
+	initialize := rd.initializePolicies
 	if environment.IsStreamsEnabled() {
-		return rd.initializeStreams()
+		component = "streams"
+		initialize = rd.initializeStreams
+	}
+	err := initialize()
+	if err != nil {
+		rd.startupStatus.Failed(component, err)
+	}
+	rd.reopenSignal = newReopenSignalFromEnv(rd.writer)
+	if rd.reopenSignal != nil {
+		rd.reopenSignal.Run()
+	}
+
+	reported := rd.lunarHub.ReportProxyStatus(rd.startupStatus)
+	if err != nil {
+		select {
+		case <-reported:
+		case <-time.After(failedStartupReportTimeout):
+			log.Warn().Msg("Timed out reporting the failed startup to Lunar Hub")
+		}
+	}
+	return err
+}
+
+// initTelemetry initializes the telemetry providers,
+// registering the build info and engine metrics on the new meter
+func (rd *HandlingDataManager) initTelemetry() func() {
+	shutdown := otel.InitProvider(lunarEngine)
+	err := otel.RegisterBuildInfo(otel.GetMeter(), otel.BuildInfo{
+		Version:   environment.GetProxyVersion(),
+		GitCommit: environment.GetProxyGitCommit(),
+		BuildDate: environment.GetProxyBuildDate(),
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to register build info metric")
+	}
+	err = registerEngineMetrics(otel.GetMeter(), rd.shutdownState, rd.spoeConnections)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to register engine metrics")
 	}
-	return rd.initializePolicies()
+	return shutdown
 }
 
 func (rd *HandlingDataManager) RunDiagnosisWorker() {
@@ -94,17 +271,63 @@ func (rd *HandlingDataManager) IsStreamsEnabled() bool {
 }
 
 func (rd *HandlingDataManager) Shutdown() {
+	if rd.remotePoliciesPoller != nil {
+		rd.remotePoliciesPoller.Stop()
+	}
+	if rd.dynamicQuotasPoller != nil {
+		rd.dynamicQuotasPoller.Stop()
+	}
+	if rd.selfTest != nil {
+		rd.selfTest.Stop()
+	}
+	if rd.reopenSignal != nil {
+		rd.reopenSignal.Stop()
+	}
+	if rd.policiesServices != nil {
+		rd.policiesServices.Exporters.UsageSnapshot.Stop()
+	}
 	if rd.shutdown != nil {
 		rd.shutdown()
 	}
 }
 
+// ShutdownGracefully stops admitting new requests, which are responded to
+// with 503 from now on, and fails the proxy's healthcheck so load balancers
+// stop sending traffic. It then waits for the in flight transactions
+// to complete, for up to the configured grace period. If configured, the
+// requests waiting in queues as the shutdown begins are exported beforehand.
+func (rd *HandlingDataManager) ShutdownGracefully() {
+	rd.shutdownState.Begin()
+	if err := config.MarkProxyShuttingDown(); err != nil {
+		log.Warn().Err(err).Msg("Failed to fail the proxy healthcheck")
+	}
+
+	if rd.policiesServices != nil {
+		services.ExportQueueSnapshot(contextmanager.Get().GetClock(), rd.writer,
+			rd.policiesServices.Remedies.StrategyBasedQueuePlugin)
+	}
+
+	inFlight := rd.shutdownState.InFlightCount()
+	log.Info().Msgf("Shutting down gracefully, waiting for %d in flight transactions",
+		inFlight)
+	if !rd.shutdownState.WaitForInFlight() {
+		log.Warn().Msgf("Grace period passed with %d transactions still in flight",
+			rd.shutdownState.InFlightCount())
+		return
+	}
+	log.Info().Msg("All in flight transactions completed")
+}
+
 func (rd *HandlingDataManager) SetHandleRoutes(mux *http.ServeMux) {
 	if rd.isStreamsEnabled {
 		mux.HandleFunc(
 			"/load_flows",
 			rd.handleFlowsLoading(),
 		)
+		mux.HandleFunc(
+			"/feature_flags",
+			HandleFeatureFlags(rd.featureFlags),
+		)
 	} else {
 		mux.HandleFunc(
 			"/apply_policies",
@@ -116,12 +339,33 @@ func (rd *HandlingDataManager) SetHandleRoutes(mux *http.ServeMux) {
 			"/validate_policies",
 			HandleValidatePolicies(),
 		)
+		mux.HandleFunc(
+			"/maintenance_mode",
+			HandleMaintenanceMode(rd.maintenanceMode),
+		)
+		mux.HandleFunc(
+			"/self_test",
+			HandleSelfTest(rd.selfTest),
+		)
+		mux.HandleFunc(
+			"/canary_policies",
+			HandleCanaryPolicies(rd.configBuildResult.Accessor),
+		)
+		mux.HandleFunc(
+			"/promote_canary_policies",
+			HandlePromoteCanaryPolicies(rd.configBuildResult.Accessor),
+		)
 	}
 	mux.HandleFunc(
 		"/discover",
 		HandleJSONFileRead(environment.GetDiscoveryStateLocation()),
 	)
 
+	mux.HandleFunc(
+		"/discover/openapi",
+		HandleDiscoveryOpenAPI(environment.GetDiscoveryStateLocation()),
+	)
+
 	mux.HandleFunc(
 		"/remedy_stats",
 		HandleJSONFileRead(environment.GetRemedyStateLocation()),
@@ -131,6 +375,50 @@ func (rd *HandlingDataManager) SetHandleRoutes(mux *http.ServeMux) {
 		"/handshake",
 		HandleHandshake(),
 	)
+
+	mux.HandleFunc(
+		"/diagnostic_bundle",
+		HandleDiagnosticBundle(environment.GetAdminAPIToken(), rd.diagnosticBundle()),
+	)
+}
+
+// diagnosticBundle gathers the state of the policies or streams,
+// whichever the engine runs, with credentials obfuscated by MD5
+func (rd *HandlingDataManager) diagnosticBundle() *DiagnosticBundle {
+	sources := DiagnosticBundleSources{
+		BuildInfo: otel.BuildInfo{
+			Version:   environment.GetProxyVersion(),
+			GitCommit: environment.GetProxyGitCommit(),
+			BuildDate: environment.GetProxyBuildDate(),
+		},
+		Policies:     nil,
+		Queues:       nil,
+		Throttles:    nil,
+		Metrics:      prometheus.DefaultGatherer,
+		HubConnected: rd.lunarHub.IsConnected,
+		RecentLogs:   rd.recentLogs,
+	}
+	if policiesAccessor := rd.configBuildResult.Accessor; policiesAccessor != nil {
+		sources.Policies = func() *sharedConfig.PoliciesConfig {
+			policiesData := policiesAccessor.GetCurrentPoliciesData()
+			if policiesData == nil {
+				return nil
+			}
+			return &policiesData.Config
+		}
+	}
+	if rd.policiesServices != nil {
+		remedyPlugins := rd.policiesServices.Remedies
+		sources.Queues = remedyPlugins.StrategyBasedQueuePlugin.PendingQueues
+		sources.Throttles = remedyPlugins.StrategyBasedThrottlingPlugin.WindowUsages
+	}
+	return NewDiagnosticBundle(
+		contextmanager.Get().GetClock(),
+		obfuscation.Obfuscator{Hasher: obfuscation.MD5Hasher{}},
+		sources,
+		defaultDiagnosticBundleTimeout,
+		defaultDiagnosticBundleMaxEntryBytes,
+	)
 }
 
 func (rd *HandlingDataManager) initializeStreams() (err error) {
@@ -147,7 +435,7 @@ func (rd *HandlingDataManager) initializeStreams() (err error) {
 	// This happens when calling load_flows
 	rd.Shutdown()
 
-	rd.shutdown = otel.InitProvider(lunarEngine)
+	rd.shutdown = rd.initTelemetry()
 
 	if !rd.areMetricsInitialized {
 		go otel.ServeMetrics()
@@ -155,7 +443,8 @@ func (rd *HandlingDataManager) initializeStreams() (err error) {
 	}
 
 	rd.stream = streams.NewStream()
-	rd.stream.WithHub(rd.lunarHub)
+	rd.stream.WithHub(rd.lunarHub).WithFeatureFlags(rd.featureFlags)
+	rd.lunarHub.OnFeatureFlags(rd.applyFeatureFlags)
 	if err = rd.stream.Initialize(); err != nil {
 		return fmt.Errorf("failed to initialize streams: %w", err)
 	}
@@ -208,29 +497,35 @@ func (rd *HandlingDataManager) handleFlowsLoading() func(http.ResponseWriter, *h
 
 func (rd *HandlingDataManager) initializePolicies() error {
 	log.Info().Msg("Using policies for Lunar Engine")
-	sharedConfig.Validate.RegisterStructValidation(
-		config.ValidateStructLevel,
-		sharedConfig.Remedy{},         //nolint: exhaustruct
-		sharedConfig.Diagnosis{},      //nolint: exhaustruct
-		sharedConfig.PoliciesConfig{}, //nolint: exhaustruct
-	)
-	err := sharedConfig.Validate.RegisterValidation(
-		"validateInt",
-		config.ValidateInt,
-	)
+	err := config.RegisterValidations()
 	if err != nil {
 		return fmt.Errorf("failed to register config validation: %w", err)
 	}
+	config.SetDecisionHookRegistry(remedies.IsDecisionHookRegistered)
 
 	configBuildResult, err := config.BuildInitialFromFile()
 	if err != nil {
 		return fmt.Errorf("failed to build initial config: %w", err)
 	}
 	rd.configBuildResult = configBuildResult
+	rd.shutdown = rd.initTelemetry()
+	rd.configBuildResult.Accessor.SetChangeRecorder(config.NewConfigChangeRecorder(
+		contextmanager.Get().GetClock(),
+		otel.GetMeter(),
+		rd.lunarHub.ReportConfigChange,
+	))
+	rd.configBuildResult.Accessor.SetCanaryMetrics(
+		config.NewCanaryMetrics(otel.GetMeter()))
+	rd.maintenanceMode = NewMaintenanceMode(otel.GetMeter(),
+		haproxyMaintenanceEndpoints{
+			managed: rd.configBuildResult.Accessor.HAProxyEndpoints,
+		})
+	rd.lunarHub.OnMaintenanceMode(rd.applyMaintenanceMode)
+	rd.lunarHub.ObserveDiscoveryBackoff(otel.GetMeter())
+	rd.lunarHub.ObserveActiveEndpoint(otel.GetMeter())
+	rd.lunarHub.ObserveDiscoveryStaleness(otel.GetMeter())
 	rd.diagnosisWorker = runner.NewDiagnosisWorker()
 
-	rd.shutdown = otel.InitProvider(lunarEngine)
-
 	go otel.ServeMetrics()
 
 	rd.policiesServices, err = services.Initialize(
@@ -241,9 +536,98 @@ func (rd *HandlingDataManager) initializePolicies() error {
 	if err != nil {
 		return fmt.Errorf("failed to initialize services: %w", err)
 	}
+	rd.configBuildResult.Accessor.OnChangeApplied(rd.onPoliciesChangeApplied)
+	rd.selfTest, err = newSelfTestFromEnv(
+		contextmanager.Get().GetClock(),
+		otel.GetMeter(),
+		rd.proxyTimeout,
+		rd.configBuildResult.Accessor.GetCurrentPoliciesData,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to initialize self-test: %w", err)
+	}
+	if rd.selfTest != nil {
+		rd.selfTest.Run()
+	}
+	rd.runRemotePoliciesPoller()
+	rd.lunarHub.OnDynamicQuotas(rd.applyDynamicQuotas)
+	rd.runDynamicQuotasPoller()
 	return nil
 }
 
+// onPoliciesChangeApplied releases what the services hold for the remedies
+// the applied policies no longer configure
+func (rd *HandlingDataManager) onPoliciesChangeApplied(policiesData *config.PoliciesData) {
+	rd.policiesServices.Remedies.StrategyBasedQueuePlugin.ForgetRemovedRemedies(
+		&policiesData.Config)
+}
+
+// applyMaintenanceMode toggles the maintenance mode as sent by Lunar Hub
+func (rd *HandlingDataManager) applyMaintenanceMode(
+	data network.MaintenanceModeData,
+) {
+	if err := rd.maintenanceMode.Apply(data); err != nil {
+		log.Error().Err(err).Msg("Failed to apply maintenance mode sent by Lunar Hub")
+	}
+}
+
+// applyFeatureFlags replaces the feature flags with the ones sent by Lunar Hub
+func (rd *HandlingDataManager) applyFeatureFlags(data network.FeatureFlagsData) {
+	if err := rd.featureFlags.Replace(data.Flags); err != nil {
+		log.Error().Err(err).Msg("Failed to apply feature flags sent by Lunar Hub")
+	}
+}
+
+// runRemotePoliciesPoller polls for the policies config if a remote source
+// is configured. Fetched config is applied as if it was posted to the
+// apply policies endpoint, so it is validated before being hot reloaded.
+func (rd *HandlingDataManager) runRemotePoliciesPoller() {
+	url := environment.GetRemotePoliciesURL()
+	if url == "" {
+		log.Debug().Msg("Remote policies URL not set, policies are read from file")
+		return
+	}
+	interval, err := environment.GetRemotePoliciesInterval()
+	if err != nil || interval <= 0 {
+		interval = defaultRemotePoliciesInterval
+	}
+	rd.remotePoliciesPoller = config.NewRemotePoliciesPoller(
+		contextmanager.Get().GetClock(),
+		url,
+		interval,
+		otel.GetMeter(),
+		rd.configBuildResult.Accessor.UpdateRawData,
+	)
+	rd.remotePoliciesPoller.Run()
+}
+
+// applyDynamicQuotas replaces the dynamic quotas with the ones sent by Lunar Hub
+func (rd *HandlingDataManager) applyDynamicQuotas(data network.DynamicQuotasData) {
+	rd.policiesServices.Remedies.DynamicQuotas.Set(data.Quotas)
+}
+
+// runDynamicQuotasPoller polls for the dynamic quotas if an endpoint is
+// configured, in addition to the ones Lunar Hub may send
+func (rd *HandlingDataManager) runDynamicQuotasPoller() {
+	url := environment.GetDynamicQuotasURL()
+	if url == "" {
+		log.Debug().Msg("Dynamic quotas URL not set, dynamic quotas are not polled")
+		return
+	}
+	interval, err := environment.GetDynamicQuotasInterval()
+	if err != nil || interval <= 0 {
+		interval = defaultDynamicQuotasInterval
+	}
+	rd.dynamicQuotasPoller = remedies.NewDynamicQuotasPoller(
+		contextmanager.Get().GetClock(),
+		url,
+		interval,
+		otel.GetMeter(),
+		rd.policiesServices.Remedies.DynamicQuotas,
+	)
+	rd.dynamicQuotasPoller.Run()
+}
+
 func (rd *HandlingDataManager) buildHAProxyFlowsEndpointsRequest() *config.HAProxyEndpointsRequest {
 	if !rd.isStreamsEnabled {
 		return &config.HAProxyEndpointsRequest{}
@@ -0,0 +1,292 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lunar/engine/actions"
+	"lunar/engine/config"
+	"lunar/engine/messages"
+	"lunar/toolkit-core/network"
+	"lunar/toolkit-core/urltree"
+	"net/http"
+	"strings"
+	"sync"
+
+	spoe "github.com/TheLunarCompany/haproxy-spoe-go"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	maintenanceServedMetricName  = "lunar_maintenance_mode.served_requests"
+	defaultMaintenanceStatusCode = 503
+	defaultMaintenanceBody       = "The service is under maintenance"
+)
+
+// routes with no method are managed in HAProxy for each of these methods
+var maintenanceHAProxyMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// maintenanceMethods are the methods of a route which are in maintenance,
+// holding an empty method if all of them are
+type maintenanceMethods map[string]struct{}
+
+// MaintenanceEndpoints keeps the routes in maintenance managed in HAProxy,
+// so their requests reach the engine to be served the maintenance response
+type MaintenanceEndpoints interface {
+	Pin(endpoints []string) error
+	Unpin(endpoints []string)
+}
+
+// MaintenanceMode serves a fixed response to the requests of the routes
+// in maintenance, before any remedy runs or the upstream is called.
+// Unlike a config reload, it is toggled at once and stays active
+// until explicitly cleared, through the admin endpoint or Lunar Hub.
+type MaintenanceMode struct {
+	endpoints MaintenanceEndpoints
+	served    metric.Int64Counter
+
+	mutex  sync.RWMutex
+	active *activeMaintenance
+}
+
+type activeMaintenance struct {
+	data      network.MaintenanceModeData
+	routes    *urltree.URLTree[maintenanceMethods]
+	action    *actions.EarlyResponseAction
+	endpoints []string
+}
+
+// NewMaintenanceMode creates an inactive maintenance mode, whose routes are
+// kept managed in HAProxy by the given endpoints unless it is nil
+func NewMaintenanceMode(
+	meter metric.Meter,
+	endpoints MaintenanceEndpoints,
+) *MaintenanceMode {
+	served, err := meter.Int64Counter(
+		maintenanceServedMetricName,
+		metric.WithDescription("Requests served the maintenance response "+
+			"as their route is in maintenance mode"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to initialize %s metric",
+			maintenanceServedMetricName)
+	}
+	return &MaintenanceMode{
+		endpoints: endpoints,
+		served:    served,
+		mutex:     sync.RWMutex{},
+		active:    nil,
+	}
+}
+
+// Apply activates the maintenance mode, or clears it if it is not active
+func (mode *MaintenanceMode) Apply(data network.MaintenanceModeData) error {
+	if !data.Active {
+		mode.Clear()
+		return nil
+	}
+	return mode.Activate(data)
+}
+
+// Activate puts the given routes in maintenance mode, replacing the routes
+// and response of the maintenance mode if it was already active
+func (mode *MaintenanceMode) Activate(data network.MaintenanceModeData) error {
+	active, err := buildActiveMaintenance(data)
+	if err != nil {
+		return err
+	}
+	if mode.endpoints != nil {
+		if err := mode.endpoints.Pin(active.endpoints); err != nil {
+			return fmt.Errorf("failed to manage maintenance routes: %w", err)
+		}
+	}
+
+	mode.mutex.Lock()
+	previous := mode.active
+	mode.active = active
+	mode.mutex.Unlock()
+
+	if previous != nil && mode.endpoints != nil {
+		mode.endpoints.Unpin(previous.endpoints)
+	}
+	log.Warn().
+		Str("routes", describeMaintenanceRoutes(active.data.Routes)).
+		Int("status_code", active.action.Status).
+		Msg("Maintenance mode is ACTIVE, routes are served the maintenance response")
+	return nil
+}
+
+// Clear deactivates the maintenance mode, returning whether it was active
+func (mode *MaintenanceMode) Clear() bool {
+	mode.mutex.Lock()
+	previous := mode.active
+	mode.active = nil
+	mode.mutex.Unlock()
+	if previous == nil {
+		return false
+	}
+
+	if mode.endpoints != nil {
+		mode.endpoints.Unpin(previous.endpoints)
+	}
+	log.Warn().
+		Str("routes", describeMaintenanceRoutes(previous.data.Routes)).
+		Msg("Maintenance mode is cleared")
+	return true
+}
+
+// Current returns the maintenance mode as it was applied
+func (mode *MaintenanceMode) Current() network.MaintenanceModeData {
+	mode.mutex.RLock()
+	defer mode.mutex.RUnlock()
+	if mode.active == nil {
+		return network.MaintenanceModeData{} //nolint:exhaustruct
+	}
+	return mode.active.data
+}
+
+// response returns the maintenance response of the given request,
+// if its route is in maintenance
+func (mode *MaintenanceMode) response(
+	args messages.OnRequest,
+) (actions.ReqLunarAction, bool) {
+	if mode == nil {
+		return nil, false
+	}
+	mode.mutex.RLock()
+	active := mode.active
+	mode.mutex.RUnlock()
+	if active == nil {
+		return nil, false
+	}
+
+	lookup := active.routes.Lookup(args.URL)
+	if !lookup.Match || lookup.Value == nil {
+		return nil, false
+	}
+	methods := *lookup.Value
+	_, anyMethod := methods[""]
+	_, method := methods[strings.ToUpper(args.Method)]
+	if !anyMethod && !method {
+		return nil, false
+	}
+
+	if mode.served != nil {
+		mode.served.Add(context.Background(), 1, metric.WithAttributes(
+			attribute.String("method", args.Method),
+			attribute.String("normalized_url", lookup.NormalizedURL),
+		))
+	}
+	return active.action, true
+}
+
+// maintenanceResponse returns the actions serving the given request the
+// maintenance response, if its route is in maintenance
+func maintenanceResponse(
+	args messages.OnRequest,
+	mode *MaintenanceMode,
+) ([]spoe.Action, bool) {
+	action, inMaintenance := mode.response(args)
+	if !inMaintenance {
+		return nil, false
+	}
+	log.Trace().Str("request-id", args.ID).
+		Msg("Route is in maintenance mode, serving the maintenance response")
+	return getSPOEReqActions(args, []actions.ReqLunarAction{action}), true
+}
+
+func buildActiveMaintenance(
+	data network.MaintenanceModeData,
+) (*activeMaintenance, error) {
+	if len(data.Routes) == 0 {
+		return nil, errors.New("maintenance mode requires at least one route")
+	}
+	statusCode := data.Response.StatusCode
+	if statusCode == 0 {
+		statusCode = defaultMaintenanceStatusCode
+	}
+	if statusCode < 100 || statusCode > 599 {
+		return nil, fmt.Errorf("invalid maintenance status code %d", statusCode)
+	}
+	body := data.Response.Body
+	if body == "" {
+		body = defaultMaintenanceBody
+	}
+	headers := map[string]string{"Content-Type": "text/plain"}
+	for name, value := range data.Response.Headers {
+		headers[name] = value
+	}
+
+	// Routes of the same URL share a node in the tree, so their methods
+	// are merged before being inserted
+	routesMethods := map[string]maintenanceMethods{}
+	endpoints := []string{}
+	for _, route := range data.Routes {
+		if route.URL == "" {
+			return nil, errors.New("maintenance route requires a URL")
+		}
+		method := strings.ToUpper(route.Method)
+		if _, found := routesMethods[route.URL]; !found {
+			routesMethods[route.URL] = maintenanceMethods{}
+		}
+		routesMethods[route.URL][method] = struct{}{}
+		haproxyMethods := []string{method}
+		if method == "" {
+			haproxyMethods = maintenanceHAProxyMethods
+		}
+		for _, haproxyMethod := range haproxyMethods {
+			endpoints = append(endpoints,
+				config.HaproxyEndpointFormat(haproxyMethod, route.URL))
+		}
+	}
+	routes := urltree.NewURLTree[maintenanceMethods](false, 0)
+	for url, methods := range routesMethods {
+		methods := methods
+		if err := routes.Insert(url, &methods); err != nil {
+			return nil, fmt.Errorf("invalid maintenance route %s: %w", url, err)
+		}
+	}
+
+	return &activeMaintenance{
+		data:   data,
+		routes: routes,
+		action: &actions.EarlyResponseAction{
+			Status:  statusCode,
+			Body:    body,
+			Headers: headers,
+		},
+		endpoints: endpoints,
+	}, nil
+}
+
+func describeMaintenanceRoutes(routes []network.MaintenanceRoute) string {
+	described := make([]string, 0, len(routes))
+	for _, route := range routes {
+		method := route.Method
+		if method == "" {
+			method = "*"
+		}
+		described = append(described, method+" "+route.URL)
+	}
+	return strings.Join(described, ", ")
+}
+
+// haproxyMaintenanceEndpoints pins the routes in maintenance in HAProxy,
+// unmanaging them once cleared unless the policies config manages them
+type haproxyMaintenanceEndpoints struct {
+	managed func() *config.HAProxyEndpointsRequest
+}
+
+func (endpoints haproxyMaintenanceEndpoints) Pin(haproxyEndpoints []string) error {
+	return config.PinHAProxyEndpoints(haproxyEndpoints)
+}
+
+func (endpoints haproxyMaintenanceEndpoints) Unpin(haproxyEndpoints []string) {
+	config.UnpinHAProxyEndpoints(haproxyEndpoints, endpoints.managed())
+}
@@ -0,0 +1,164 @@
+package routing
+
+import (
+	"context"
+	"lunar/engine/actions"
+	"lunar/engine/messages"
+	"lunar/toolkit-core/network"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+type fakeMaintenanceEndpoints struct {
+	pinned map[string]int
+}
+
+func (endpoints *fakeMaintenanceEndpoints) Pin(haproxyEndpoints []string) error {
+	for _, endpoint := range haproxyEndpoints {
+		endpoints.pinned[endpoint]++
+	}
+	return nil
+}
+
+func (endpoints *fakeMaintenanceEndpoints) Unpin(haproxyEndpoints []string) {
+	for _, endpoint := range haproxyEndpoints {
+		endpoints.pinned[endpoint]--
+		if endpoints.pinned[endpoint] == 0 {
+			delete(endpoints.pinned, endpoint)
+		}
+	}
+}
+
+func maintenanceRequest(method string, url string) messages.OnRequest {
+	return messages.OnRequest{ID: "1234", Method: method, URL: url}
+}
+
+func TestMaintenanceModeServesTheResponseOnlyToMatchedRoutes(t *testing.T) {
+	mode := NewMaintenanceMode(noop.NewMeterProvider().Meter("test"), nil)
+	_, inMaintenance := mode.response(maintenanceRequest("GET", "api.com/users"))
+	require.False(t, inMaintenance)
+
+	require.NoError(t, mode.Activate(network.MaintenanceModeData{
+		Active: true,
+		Routes: []network.MaintenanceRoute{
+			{Method: "GET", URL: "api.com/users/{id}"},
+			{Method: "post", URL: "api.com/users/{id}"},
+			{URL: "api.com/orders/*"},
+		},
+		Response: network.MaintenanceResponse{
+			Body:    `{"message": "back soon"}`,
+			Headers: map[string]string{"Content-Type": "application/json"},
+		},
+	}))
+
+	action, inMaintenance := mode.response(maintenanceRequest("GET", "api.com/users/7"))
+	require.True(t, inMaintenance)
+	require.Equal(t, &actions.EarlyResponseAction{
+		Status:  503,
+		Body:    `{"message": "back soon"}`,
+		Headers: map[string]string{"Content-Type": "application/json"},
+	}, action)
+	_, inMaintenance = mode.response(maintenanceRequest("POST", "api.com/users/7"))
+	require.True(t, inMaintenance)
+	_, inMaintenance = mode.response(maintenanceRequest("DELETE", "api.com/orders/9/items"))
+	require.True(t, inMaintenance)
+
+	for _, unmatched := range []messages.OnRequest{
+		maintenanceRequest("DELETE", "api.com/users/7"),
+		maintenanceRequest("GET", "api.com/users"),
+		maintenanceRequest("GET", "api.com/products/7"),
+		maintenanceRequest("GET", "other.com/users/7"),
+	} {
+		_, inMaintenance = mode.response(unmatched)
+		require.False(t, inMaintenance, "%s %s", unmatched.Method, unmatched.URL)
+	}
+}
+
+func TestMaintenanceModeStaysActiveUntilCleared(t *testing.T) {
+	endpoints := &fakeMaintenanceEndpoints{pinned: map[string]int{}}
+	mode := NewMaintenanceMode(noop.NewMeterProvider().Meter("test"), endpoints)
+	data := network.MaintenanceModeData{
+		Active: true,
+		Routes: []network.MaintenanceRoute{{Method: "GET", URL: "api.com/users"}},
+	}
+	require.NoError(t, mode.Apply(data))
+	require.Len(t, endpoints.pinned, 1)
+	require.Equal(t, data, mode.Current())
+
+	// Replacing the routes unpins the previous ones
+	data.Routes = []network.MaintenanceRoute{{Method: "GET", URL: "api.com/orders"}}
+	require.NoError(t, mode.Apply(data))
+	require.Len(t, endpoints.pinned, 1)
+	_, inMaintenance := mode.response(maintenanceRequest("GET", "api.com/users"))
+	require.False(t, inMaintenance)
+	_, inMaintenance = mode.response(maintenanceRequest("GET", "api.com/orders"))
+	require.True(t, inMaintenance)
+
+	require.NoError(t, mode.Apply(network.MaintenanceModeData{})) //nolint:exhaustruct
+	require.Empty(t, endpoints.pinned)
+	require.False(t, mode.Current().Active)
+	_, inMaintenance = mode.response(maintenanceRequest("GET", "api.com/orders"))
+	require.False(t, inMaintenance)
+	require.False(t, mode.Clear())
+}
+
+func TestMaintenanceModeRejectsInvalidSettings(t *testing.T) {
+	endpoints := &fakeMaintenanceEndpoints{pinned: map[string]int{}}
+	mode := NewMaintenanceMode(noop.NewMeterProvider().Meter("test"), endpoints)
+	route := []network.MaintenanceRoute{{Method: "GET", URL: "api.com/users"}}
+
+	require.Error(t, mode.Activate(network.MaintenanceModeData{Active: true}))
+	require.Error(t, mode.Activate(network.MaintenanceModeData{
+		Active:   true,
+		Routes:   route,
+		Response: network.MaintenanceResponse{StatusCode: 700},
+	}))
+	require.Error(t, mode.Activate(network.MaintenanceModeData{
+		Active: true,
+		Routes: []network.MaintenanceRoute{{Method: "GET"}},
+	}))
+	require.Empty(t, endpoints.pinned)
+	require.False(t, mode.Current().Active)
+}
+
+func TestMaintenanceModeCountsServedRequests(t *testing.T) {
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	mode := NewMaintenanceMode(meter, nil)
+	require.NoError(t, mode.Activate(network.MaintenanceModeData{
+		Active: true,
+		Routes: []network.MaintenanceRoute{{URL: "api.com/users/{id}"}},
+	}))
+
+	for i := 0; i < 3; i++ {
+		response, inMaintenance := maintenanceResponse(
+			maintenanceRequest("GET", "api.com/users/7"), mode)
+		require.True(t, inMaintenance)
+		require.True(t, isEarlyResponse(response))
+	}
+	_, inMaintenance := maintenanceResponse(
+		maintenanceRequest("GET", "api.com/orders"), mode)
+	require.False(t, inMaintenance)
+
+	var data metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &data))
+	served := map[string]int64{}
+	for _, scopeMetrics := range data.ScopeMetrics {
+		for _, metric := range scopeMetrics.Metrics {
+			if metric.Name != maintenanceServedMetricName {
+				continue
+			}
+			sum, ok := metric.Data.(metricdata.Sum[int64])
+			require.True(t, ok)
+			for _, point := range sum.DataPoints {
+				url, _ := point.Attributes.Value("normalized_url")
+				served[url.AsString()] += point.Value
+			}
+		}
+	}
+	require.Equal(t, map[string]int64{"api.com/users/{id}": 3}, served)
+}
@@ -11,7 +11,9 @@ import (
 	"lunar/engine/utils"
 	contextmanager "lunar/toolkit-core/context-manager"
 	"lunar/toolkit-core/otel"
+	"net"
 	"reflect"
+	"time"
 
 	spoe "github.com/TheLunarCompany/haproxy-spoe-go"
 	"github.com/rs/zerolog/log"
@@ -102,32 +104,75 @@ func processMessage(msg spoe.Message, data *HandlingDataManager) ([]spoe.Action,
 
 		args := readRequestArgs(msg.Args)
 		log.Trace().Msgf("On request args: %+v\n", args)
+		data.lunarHub.ObserveTransaction()
+		if rejection, rejected := shutdownRejection(args, data.shutdownState); rejected {
+			span.End()
+			return rejection, nil
+		}
+		if response, inMaintenance := maintenanceResponse(args, data.maintenanceMode); inMaintenance {
+			data.shutdownState.Complete(args.ID)
+			span.End()
+			return response, nil
+		}
+		data.requestDeadlines.Apply(&args)
+		traceAction := data.upstreamTracer.StartSpan(ctxMng.GetContext(), args)
+		args.SpanContext = data.upstreamTracer.SpanContext(args.ID)
+		var policiesData *config.PoliciesData
 		if data.IsStreamsEnabled() {
 			apiStream := streamtypes.NewRequestAPIStream(args)
 			flowActions := &streamconfig.StreamActions{
 				Request: &streamconfig.RequestStream{},
 			}
 			if err = runner.RunFlow(data.stream, apiStream, flowActions); err == nil {
-				actions = getSPOEReqActions(args, flowActions.Request.Actions)
+				actions = getSPOEReqActions(
+					args,
+					prependAction(traceAction, flowActions.Request.Actions),
+				)
 			}
 		} else {
-			policiesData := data.GetTxnPoliciesAccessor().GetTxnPoliciesData(config.TxnID(args.ID))
+			policiesData = data.GetTxnPoliciesAccessor().GetTxnPoliciesData(config.TxnID(args.ID))
 			log.Trace().Msgf("On request policies: %+v\n", policiesData)
+			args.ConfigVersion = policiesData.ConfigVersion
 			actions, err = runner.DispatchOnRequest(
 				args,
 				&policiesData.EndpointPolicyTree,
 				&policiesData.Config,
 				data.policiesServices,
 				data.diagnosisWorker,
+				traceAction,
 			)
+			data.GetTxnPoliciesAccessor().RecordCanaryRequest(
+				config.TxnID(args.ID), isEarlyResponse(actions))
+		}
+		if err == nil {
+			var deadlineExceeded bool
+			actions, deadlineExceeded = data.requestDeadlines.Bound(args, actions)
+			// HAProxy returns the early response without passing it through
+			// the response handling, so remedies release what they hold here
+			if deadlineExceeded && policiesData != nil {
+				runner.DispatchOnDeadlineExceeded(
+					args,
+					&policiesData.EndpointPolicyTree,
+					&policiesData.Config.Global,
+					data.policiesServices,
+					data.diagnosisWorker,
+				)
+			}
+		}
+		if err != nil || isEarlyResponse(actions) {
+			data.upstreamTracer.EndSpan(args.ID)
+			data.shutdownState.Complete(args.ID)
 		}
-		log.Trace().Str("request-id", args.ID).Msg("On request finished")
+		log.Trace().Str("request-id", args.ID).
+			Str("config-version", args.ConfigVersion).
+			Msg("On request finished")
 		span.End()
 	case lunarOnResponseMessage:
 		_, span := otel.Tracer(ctxMng.GetContext(), "routing#lunarOnResponseMessage")
 
 		args := readResponseArgs(msg.Args)
 		log.Trace().Msgf("On response args: %+v\n", args)
+		data.upstreamTracer.EndSpanWithResponse(args)
 		if data.IsStreamsEnabled() {
 			apiStream := streamtypes.NewResponseAPIStream(args)
 			flowActions := &streamconfig.StreamActions{
@@ -139,6 +184,7 @@ func processMessage(msg spoe.Message, data *HandlingDataManager) ([]spoe.Action,
 		} else {
 			policiesData := data.GetTxnPoliciesAccessor().GetTxnPoliciesData(config.TxnID(args.ID))
 			log.Trace().Msgf("On response policies: %+v\n", policiesData)
+			args.ConfigVersion = policiesData.ConfigVersion
 			actions, err = runner.DispatchOnResponse(
 				args,
 				&policiesData.EndpointPolicyTree,
@@ -146,13 +192,55 @@ func processMessage(msg spoe.Message, data *HandlingDataManager) ([]spoe.Action,
 				data.policiesServices,
 				data.diagnosisWorker,
 			)
+			data.GetTxnPoliciesAccessor().RecordCanaryResponse(
+				config.TxnID(args.ID), args.UpstreamDuration)
 		}
-		log.Trace().Str("response-id", args.ID).Msg("On response finished")
+		data.shutdownState.Complete(args.ID)
+		log.Trace().Str("response-id", args.ID).
+			Str("config-version", args.ConfigVersion).
+			Msg("On response finished")
 		span.End()
 	}
 	return actions, err
 }
 
+// shutdownRejection returns the actions responding to the given request
+// if it cannot be admitted as the engine is shutting down
+func shutdownRejection(
+	args messages.OnRequest,
+	state *ShutdownState,
+) ([]spoe.Action, bool) {
+	if state.Admit(args.ID) {
+		return nil, false
+	}
+	log.Debug().Str("request-id", args.ID).
+		Msg("Engine is shutting down, rejecting request")
+	return getSPOEReqActions(
+		args,
+		[]actions.ReqLunarAction{state.RejectionAction()},
+	), true
+}
+
+func prependAction(
+	action actions.ReqLunarAction,
+	lunarActions []actions.ReqLunarAction,
+) []actions.ReqLunarAction {
+	if action == nil {
+		return lunarActions
+	}
+	return append([]actions.ReqLunarAction{action}, lunarActions...)
+}
+
+func isEarlyResponse(spoeActions []spoe.Action) bool {
+	for _, action := range spoeActions {
+		setVar, valid := action.(spoe.ActionSetVar)
+		if valid && setVar.Name == actions.ReturnEarlyResponseActionName {
+			return true
+		}
+	}
+	return false
+}
+
 func extractArg[T any](arg *spoe.Arg) T {
 	var res T
 
@@ -175,6 +263,7 @@ func readRequestArgs(args *spoe.ArgIterator) messages.OnRequest {
 	//nolint:exhaustruct
 	onRequest := messages.OnRequest{}
 	onRequest.Time = contextmanager.Get().GetClock().Now()
+	clientCertificate := newClientCertificateArgs()
 
 	for args.Next() {
 		arg := args.Arg
@@ -183,6 +272,15 @@ func readRequestArgs(args *spoe.ArgIterator) messages.OnRequest {
 			onRequest.ID = extractArg[string](&arg)
 		case "sequence_id":
 			onRequest.SequenceID = extractArg[string](&arg)
+		case "connection_id":
+			onRequest.ConnectionID = extractArg[string](&arg)
+		case "client_cert_used", "client_cert_verify",
+			"client_cert_subject", "client_cert_fingerprint":
+			clientCertificate.read(&arg)
+		case "client_ip":
+			if clientIP := extractArg[net.IP](&arg); clientIP != nil {
+				onRequest.ClientIP = clientIP.String()
+			}
 		case "method":
 			onRequest.Method = extractArg[string](&arg)
 		case "scheme":
@@ -195,12 +293,13 @@ func readRequestArgs(args *spoe.ArgIterator) messages.OnRequest {
 			onRequest.Query = extractArg[string](&arg)
 		case "headers":
 			rawValue := extractArg[string](&arg)
-			onRequest.Headers = utils.ParseHeaders(&rawValue)
+			onRequest.Headers, onRequest.HeaderValues = utils.ParseHeadersWithValues(&rawValue)
 		case "body":
 			rawValue := extractArg[[]byte](&arg)
 			onRequest.Body = bytes.NewBuffer(rawValue).String()
 		}
 	}
+	onRequest.ClientCertificate = clientCertificate.verified()
 	return onRequest
 }
 
@@ -208,6 +307,7 @@ func readResponseArgs(args *spoe.ArgIterator) messages.OnResponse {
 	//nolint:exhaustruct
 	onResponse := messages.OnResponse{}
 	onResponse.Time = contextmanager.Get().GetClock().Now()
+	clientCertificate := newClientCertificateArgs()
 
 	for args.Next() {
 		arg := args.Arg
@@ -219,6 +319,12 @@ func readResponseArgs(args *spoe.ArgIterator) messages.OnResponse {
 		case "sequence_id":
 			value := extractArg[string](&arg)
 			onResponse.SequenceID = value
+		case "connection_id":
+			value := extractArg[string](&arg)
+			onResponse.ConnectionID = value
+		case "client_cert_used", "client_cert_verify",
+			"client_cert_subject", "client_cert_fingerprint":
+			clientCertificate.read(&arg)
 		case "method":
 			value := extractArg[string](&arg)
 			onResponse.Method = value
@@ -230,12 +336,19 @@ func readResponseArgs(args *spoe.ArgIterator) messages.OnResponse {
 			onResponse.Status = value
 		case "headers":
 			rawValue := extractArg[string](&arg)
-			onResponse.Headers = utils.ParseHeaders(&rawValue)
+			onResponse.Headers, onResponse.HeaderValues = utils.ParseHeadersWithValues(&rawValue)
 		case "body":
 			rawValue := extractArg[[]byte](&arg)
 			onResponse.Body = bytes.NewBuffer(rawValue).String()
+		case "accept_encoding":
+			value := extractArg[string](&arg)
+			onResponse.AcceptEncoding = value
+		case "upstream_time":
+			value := extractArg[int](&arg)
+			onResponse.UpstreamDuration = time.Duration(value) * time.Millisecond
 		}
 	}
 
+	onResponse.ClientCertificate = clientCertificate.verified()
 	return onResponse
 }
@@ -0,0 +1,95 @@
+package routing
+
+import (
+	"context"
+	"lunar/engine/utils/environment"
+	"lunar/engine/utils/writers"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// reopenFlushTimeout bounds how long the records written so far are waited
+// for to be delivered, before the files are reopened regardless
+const reopenFlushTimeout = 10 * time.Second
+
+// ReopenSignal flushes the export writer and reopens the files it writes to
+// on SIGHUP, as log-producing daemons do, so files rotated by an external
+// logrotate are not written once moved. The records written before the
+// signal are delivered to the files as they were, and the ones written
+// afterwards to the new files, so none are dropped.
+type ReopenSignal struct {
+	writer  writers.Writer
+	signals chan os.Signal
+	handled chan struct{}
+
+	stopChannel chan struct{}
+	stopOnce    sync.Once
+}
+
+func NewReopenSignal(writer writers.Writer) *ReopenSignal {
+	return &ReopenSignal{
+		writer:      writer,
+		signals:     make(chan os.Signal, 1),
+		handled:     make(chan struct{}, 1),
+		stopChannel: make(chan struct{}),
+		stopOnce:    sync.Once{},
+	}
+}
+
+// newReopenSignalFromEnv handles SIGHUP if enabled, it is not by default
+func newReopenSignalFromEnv(writer writers.Writer) *ReopenSignal {
+	if !environment.IsReopenWritersOnSIGHUPEnabled() {
+		return nil
+	}
+	return NewReopenSignal(writer)
+}
+
+// Run handles SIGHUP in the background, until stopped
+func (reopenSignal *ReopenSignal) Run() {
+	signal.Notify(reopenSignal.signals, syscall.SIGHUP)
+	log.Info().Msg("Export writers are flushed and reopened on SIGHUP")
+	go func() {
+		for {
+			select {
+			case <-reopenSignal.stopChannel:
+				return
+			case <-reopenSignal.signals:
+				reopenSignal.Reopen()
+			}
+		}
+	}()
+}
+
+// Reopen flushes the writer and reopens its files, as done on SIGHUP
+func (reopenSignal *ReopenSignal) Reopen() {
+	ctx, cancel := context.WithTimeout(context.Background(), reopenFlushTimeout)
+	defer cancel()
+	start := time.Now()
+	if err := writers.FlushAndReopen(ctx, reopenSignal.writer); err != nil {
+		log.Error().Err(err).Msg("Failed to flush and reopen export writers on SIGHUP")
+	} else {
+		log.Info().Dur("took", time.Since(start)).
+			Msg("Flushed and reopened export writers on SIGHUP")
+	}
+	select {
+	case reopenSignal.handled <- struct{}{}:
+	default:
+	}
+}
+
+// Handled is notified once a signal was handled
+func (reopenSignal *ReopenSignal) Handled() <-chan struct{} {
+	return reopenSignal.handled
+}
+
+func (reopenSignal *ReopenSignal) Stop() {
+	reopenSignal.stopOnce.Do(func() {
+		signal.Stop(reopenSignal.signals)
+		close(reopenSignal.stopChannel)
+	})
+}
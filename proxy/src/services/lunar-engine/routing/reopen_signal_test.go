@@ -0,0 +1,49 @@
+package routing
+
+import (
+	"lunar/engine/utils/writers"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+func TestReopenSignalReopensTheDeadLetterFileOnSIGHUP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letter.log")
+	sink, err := writers.NewDeadLetterSink(writers.DeadLetterSinkConfig{
+		Path:        path,
+		MaxFileSize: 1 << 20,
+		MaxFiles:    1,
+	}, func() metric.Meter { return noop.NewMeterProvider().Meter("test") })
+	require.NoError(t, err)
+	defer sink.Close()
+	writer := writers.WithDeadLetterSink(writers.NewNullWriter(), sink)
+	reopenSignal := NewReopenSignal(writer)
+	reopenSignal.Run()
+	defer reopenSignal.Stop()
+
+	writers.DeadLetter(writer, "file", []byte("before"))
+	rotated := path + ".1"
+	require.NoError(t, os.Rename(path, rotated))
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	select {
+	case <-reopenSignal.Handled():
+	case <-time.After(time.Second):
+		t.Fatal("SIGHUP was not handled")
+	}
+	writers.DeadLetter(writer, "file", []byte("after"))
+	require.NoError(t, sink.Close())
+
+	before, err := os.ReadFile(rotated)
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(string(before), " file before\n"))
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(string(after), " file after\n"))
+}
@@ -0,0 +1,117 @@
+package routing
+
+import (
+	"lunar/engine/actions"
+	"lunar/engine/messages"
+	"lunar/engine/utils/environment"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"net/http"
+	"strconv"
+	"time"
+
+	spoe "github.com/TheLunarCompany/haproxy-spoe-go"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultRequestTimeoutHeader     = "X-Lunar-Timeout-Ms"
+	defaultRequestTimeoutStatusCode = http.StatusGatewayTimeout
+	// upstreamTimeoutVarName holds the time left of the request's deadline
+	// (in milliseconds), which HAProxy bounds the upstream call by
+	upstreamTimeoutVarName = "upstream_timeout_ms"
+)
+
+// RequestDeadlines derives the deadline of a request of the timeout its
+// client sent in the request timeout header, in milliseconds.
+// The timeout is clamped to the max one, so clients cannot hold on to queue
+// slots past it. Requests without the header are bound by the proxy timeout.
+type RequestDeadlines struct {
+	clock      clock.Clock
+	header     string
+	maxTimeout time.Duration
+	statusCode int
+}
+
+func NewRequestDeadlines(
+	clock clock.Clock,
+	header string,
+	maxTimeout time.Duration,
+	statusCode int,
+) *RequestDeadlines {
+	return &RequestDeadlines{
+		clock:      clock,
+		header:     header,
+		maxTimeout: maxTimeout,
+		statusCode: statusCode,
+	}
+}
+
+// newRequestDeadlinesFromEnv reads the request timeout configuration,
+// by default client timeouts are clamped to the proxy timeout
+func newRequestDeadlinesFromEnv(
+	clock clock.Clock,
+	proxyTimeout time.Duration,
+) *RequestDeadlines {
+	header := environment.GetRequestTimeoutHeader()
+	if header == "" {
+		header = defaultRequestTimeoutHeader
+	}
+	maxTimeout, err := environment.GetRequestTimeoutMax()
+	if err != nil || maxTimeout <= 0 {
+		maxTimeout = proxyTimeout
+	}
+	statusCode, err := environment.GetRequestTimeoutStatusCode()
+	if err != nil || http.StatusText(statusCode) == "" {
+		statusCode = defaultRequestTimeoutStatusCode
+	}
+	return NewRequestDeadlines(clock, header, maxTimeout, statusCode)
+}
+
+// Apply sets the deadline of the request, unless it has no valid timeout
+func (deadlines *RequestDeadlines) Apply(onRequest *messages.OnRequest) {
+	rawTimeout, found := onRequest.Header(deadlines.header, sharedConfig.MultiValueHeaderFirst)
+	if !found {
+		return
+	}
+	millis, err := strconv.Atoi(rawTimeout)
+	if err != nil || millis <= 0 {
+		log.Debug().Str("request-id", onRequest.ID).Str("timeout", rawTimeout).
+			Msg("Ignoring invalid request timeout")
+		return
+	}
+	timeout := time.Duration(millis) * time.Millisecond
+	if timeout > deadlines.maxTimeout {
+		timeout = deadlines.maxTimeout
+	}
+	onRequest.Deadline = messages.RequestDeadline{
+		At:                 onRequest.Time.Add(timeout),
+		ExceededStatusCode: deadlines.statusCode,
+	}
+}
+
+// Bound bounds the upstream call of a request sent upstream by the time left
+// of its deadline, or responds to it once the deadline passed while the
+// request was handled, telling whether it did
+func (deadlines *RequestDeadlines) Bound(
+	onRequest messages.OnRequest,
+	spoeActions []spoe.Action,
+) ([]spoe.Action, bool) {
+	if !onRequest.Deadline.IsSet() || isEarlyResponse(spoeActions) {
+		return spoeActions, false
+	}
+	remaining := onRequest.Deadline.Remaining(deadlines.clock.Now())
+	// HAProxy is told whole milliseconds, so less than one is as good as none
+	if remaining < time.Millisecond {
+		log.Debug().Str("request-id", onRequest.ID).
+			Msg("Request deadline exceeded, will return early response")
+		return getSPOEReqActions(onRequest, []actions.ReqLunarAction{
+			actions.NewDeadlineExceededAction(onRequest.Deadline),
+		}), true
+	}
+	return append(spoeActions, spoe.ActionSetVar{
+		Name:  upstreamTimeoutVarName,
+		Scope: spoe.VarScopeRequest,
+		Value: int(remaining.Milliseconds()),
+	}), false
+}
@@ -0,0 +1,75 @@
+package routing
+
+import (
+	"lunar/engine/messages"
+	"lunar/toolkit-core/clock"
+	"testing"
+	"time"
+
+	spoe "github.com/TheLunarCompany/haproxy-spoe-go"
+	"github.com/stretchr/testify/require"
+)
+
+func requestWithTimeout(clock clock.Clock, timeout string) messages.OnRequest {
+	return messages.OnRequest{
+		ID:      "1234",
+		Method:  "GET",
+		URL:     "api.com/users",
+		Time:    clock.Now(),
+		Headers: map[string]string{defaultRequestTimeoutHeader: timeout},
+	}
+}
+
+func TestRequestDeadlinesClampTheClientTimeout(t *testing.T) {
+	clock := clock.NewMockClock()
+	deadlines := NewRequestDeadlines(clock, defaultRequestTimeoutHeader, 5*time.Second, 504)
+
+	request := requestWithTimeout(clock, "1500")
+	deadlines.Apply(&request)
+	require.Equal(t, clock.Now().Add(1500*time.Millisecond), request.Deadline.At)
+	require.Equal(t, 504, request.Deadline.ExceededStatusCode)
+
+	request = requestWithTimeout(clock, "3600000")
+	deadlines.Apply(&request)
+	require.Equal(t, clock.Now().Add(5*time.Second), request.Deadline.At)
+
+	for _, invalid := range []string{"", "-1", "soon"} {
+		request = requestWithTimeout(clock, invalid)
+		deadlines.Apply(&request)
+		require.False(t, request.Deadline.IsSet())
+	}
+}
+
+func TestRequestDeadlinesBoundTheUpstreamCallByTheTimeLeft(t *testing.T) {
+	clock := clock.NewMockClock()
+	deadlines := NewRequestDeadlines(clock, defaultRequestTimeoutHeader, 5*time.Second, 504)
+	request := requestWithTimeout(clock, "2000")
+	deadlines.Apply(&request)
+
+	clock.AdvanceTime(500 * time.Millisecond)
+	bounded, exceeded := deadlines.Bound(request, nil)
+	require.False(t, exceeded)
+	require.Equal(t, []spoe.Action{spoe.ActionSetVar{
+		Name:  upstreamTimeoutVarName,
+		Scope: spoe.VarScopeRequest,
+		Value: 1500,
+	}}, bounded)
+
+	// Once the deadline passed while handling the request, it is responded to
+	clock.AdvanceTime(2 * time.Second)
+	bounded, exceeded = deadlines.Bound(request, nil)
+	require.True(t, exceeded)
+	require.True(t, isEarlyResponse(bounded))
+}
+
+func TestRequestDeadlinesLeaveRequestsWithoutATimeoutUnbound(t *testing.T) {
+	clock := clock.NewMockClock()
+	deadlines := NewRequestDeadlines(clock, defaultRequestTimeoutHeader, 5*time.Second, 504)
+	request := tracedRequest()
+	deadlines.Apply(&request)
+
+	require.False(t, request.Deadline.IsSet())
+	bounded, exceeded := deadlines.Bound(request, nil)
+	require.False(t, exceeded)
+	require.Empty(t, bounded)
+}
@@ -0,0 +1,291 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"lunar/engine/config"
+	"lunar/engine/messages"
+	"lunar/engine/runner"
+	"lunar/engine/services"
+	"lunar/engine/utils"
+	"lunar/engine/utils/environment"
+	"lunar/toolkit-core/clock"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	selfTestRunsMetricName    = "lunar_self_test.runs"
+	selfTestPassingMetricName = "lunar_self_test.passing"
+	// SelfTestRequestIDPrefix prefixes the IDs of the synthetic requests,
+	// telling them apart from real traffic in the logs
+	SelfTestRequestIDPrefix = "lunar-self-test-"
+	// SelfTestHeader is set on the synthetic requests, for remedies scoped
+	// by headers to tell them apart from real traffic
+	SelfTestHeader     = "x-lunar-self-test"
+	defaultSelfTestURL = "lunar-self-test.local/"
+)
+
+// SelfTestRequest is the synthetic request the self-test runs, whose URL
+// and headers select the scopes of the remedies it exercises
+type SelfTestRequest struct {
+	Method  string
+	URL     string
+	Headers map[string]string
+}
+
+// SelfTestResult is the result of the latest run of the self-test
+type SelfTestResult struct {
+	Passed        bool      `json:"passed"`
+	Error         string    `json:"error,omitempty"`
+	RequestID     string    `json:"request_id"`
+	ConfigVersion string    `json:"config_version"`
+	RanAt         time.Time `json:"ran_at"`
+}
+
+// SelfTest periodically runs a synthetic request through the remedies of
+// the current config, without forwarding it upstream, to detect remedies
+// which error or panic before real traffic does. It runs on remedies of
+// their own state, so it does not consume the quota of real traffic, and
+// its failures are reported by metrics and logs of its own.
+type SelfTest struct {
+	clock    clock.Clock
+	interval time.Duration
+	request  SelfTestRequest
+	policies func() *config.PoliciesData
+	remedies *services.RemedyPlugins
+	runs     metric.Int64Counter
+
+	mutex       sync.RWMutex
+	sequence    int
+	result      *SelfTestResult
+	stopChannel chan struct{}
+	stopOnce    sync.Once
+}
+
+func NewSelfTest(
+	clock clock.Clock,
+	meter metric.Meter,
+	interval time.Duration,
+	request SelfTestRequest,
+	policies func() *config.PoliciesData,
+	remedies *services.RemedyPlugins,
+) *SelfTest {
+	selfTest := &SelfTest{
+		clock:       clock,
+		interval:    interval,
+		request:     request,
+		policies:    policies,
+		remedies:    remedies,
+		runs:        nil,
+		mutex:       sync.RWMutex{},
+		sequence:    0,
+		result:      nil,
+		stopChannel: make(chan struct{}),
+		stopOnce:    sync.Once{},
+	}
+	runs, err := meter.Int64Counter(
+		selfTestRunsMetricName,
+		metric.WithDescription("Runs of the self-test by result, "+
+			"failing if a remedy errored or panicked on the synthetic request"),
+		metric.WithUnit("{run}"),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to initialize %s metric",
+			selfTestRunsMetricName)
+	}
+	selfTest.runs = runs
+	_, err = meter.Int64ObservableGauge(
+		selfTestPassingMetricName,
+		metric.WithDescription("Whether the latest run of the self-test passed"),
+		metric.WithInt64Callback(
+			func(_ context.Context, observer metric.Int64Observer) error {
+				result, ran := selfTest.Result()
+				if !ran {
+					return nil
+				}
+				passing := int64(0)
+				if result.Passed {
+					passing = 1
+				}
+				observer.Observe(passing)
+				return nil
+			}),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to initialize %s metric",
+			selfTestPassingMetricName)
+	}
+	return selfTest
+}
+
+// newSelfTestFromEnv creates the self-test if an interval is configured,
+// by default its synthetic request is a GET of a URL no endpoint matches,
+// exercising the global remedies only
+func newSelfTestFromEnv(
+	clock clock.Clock,
+	meter metric.Meter,
+	proxyTimeout time.Duration,
+	policies func() *config.PoliciesData,
+) (*SelfTest, error) {
+	interval, err := environment.GetSelfTestInterval()
+	if err != nil || interval <= 0 {
+		log.Debug().Msg("Self-test interval not set, the self-test is disabled")
+		return nil, nil
+	}
+	remedies, err := services.InitializeSelfTestRemedies(proxyTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize self-test remedies: %w", err)
+	}
+	headers, err := parseSelfTestHeaders(environment.GetSelfTestHeaders())
+	if err != nil {
+		return nil, err
+	}
+	request := SelfTestRequest{
+		Method:  environment.GetSelfTestMethod(),
+		URL:     environment.GetSelfTestURL(),
+		Headers: headers,
+	}
+	if request.Method == "" {
+		request.Method = http.MethodGet
+	}
+	if request.URL == "" {
+		request.URL = defaultSelfTestURL
+	}
+	return NewSelfTest(clock, meter, interval, request, policies, remedies), nil
+}
+
+func parseSelfTestHeaders(raw string) (map[string]string, error) {
+	headers := map[string]string{}
+	if strings.TrimSpace(raw) == "" {
+		return headers, nil
+	}
+	for _, pair := range strings.Split(raw, ",") {
+		name, value, found := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid self-test header %q, "+
+				"headers are set as name=value pairs", pair)
+		}
+		headers[name] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// Run runs the self-test in the background every interval, until stopped
+func (selfTest *SelfTest) Run() {
+	log.Info().Msgf("Running the self-test with %s %s every %v",
+		selfTest.request.Method, selfTest.request.URL, selfTest.interval)
+	go func() {
+		for {
+			selfTest.RunOnce()
+			select {
+			case <-selfTest.stopChannel:
+				return
+			case <-selfTest.clock.After(selfTest.interval):
+			}
+		}
+	}()
+}
+
+func (selfTest *SelfTest) Stop() {
+	selfTest.stopOnce.Do(func() {
+		close(selfTest.stopChannel)
+	})
+}
+
+// RunOnce runs the synthetic request through the remedies of the current
+// config, returning the result
+func (selfTest *SelfTest) RunOnce() SelfTestResult {
+	selfTest.mutex.Lock()
+	selfTest.sequence++
+	requestID := fmt.Sprintf("%s%d", SelfTestRequestIDPrefix, selfTest.sequence)
+	selfTest.mutex.Unlock()
+
+	policiesData := selfTest.policies()
+	onRequest := selfTest.buildRequest(requestID, policiesData.ConfigVersion)
+	err := runner.DispatchSelfTest(onRequest, &policiesData.EndpointPolicyTree,
+		&policiesData.Config, selfTest.remedies)
+
+	result := SelfTestResult{
+		Passed:        err == nil,
+		Error:         "",
+		RequestID:     requestID,
+		ConfigVersion: policiesData.ConfigVersion,
+		RanAt:         onRequest.Time,
+	}
+	outcome := "passed"
+	if err != nil {
+		result.Error = err.Error()
+		outcome = "failed"
+		log.Error().Err(err).
+			Str("request-id", requestID).
+			Str("config-version", policiesData.ConfigVersion).
+			Msg("Self-test failed, remedies could not handle the synthetic request")
+	}
+	if selfTest.runs != nil {
+		selfTest.runs.Add(context.Background(), 1, metric.WithAttributes(
+			attribute.String("result", outcome)))
+	}
+
+	selfTest.mutex.Lock()
+	previous := selfTest.result
+	selfTest.result = &result
+	selfTest.mutex.Unlock()
+	if err == nil && previous != nil && !previous.Passed {
+		log.Info().Str("config-version", policiesData.ConfigVersion).
+			Msg("Self-test passes again")
+	}
+	return result
+}
+
+// Result returns the result of the latest run, if the self-test ran
+func (selfTest *SelfTest) Result() (SelfTestResult, bool) {
+	if selfTest == nil {
+		return SelfTestResult{}, false //nolint:exhaustruct
+	}
+	selfTest.mutex.RLock()
+	defer selfTest.mutex.RUnlock()
+	if selfTest.result == nil {
+		return SelfTestResult{}, false //nolint:exhaustruct
+	}
+	return *selfTest.result, true
+}
+
+func (selfTest *SelfTest) buildRequest(
+	requestID string,
+	configVersion string,
+) messages.OnRequest {
+	url, query, _ := strings.Cut(selfTest.request.URL, "?")
+	path := "/"
+	if index := strings.Index(url, "/"); index >= 0 {
+		path = url[index:]
+	}
+	headers := map[string]string{SelfTestHeader: "true"}
+	for name, value := range selfTest.request.Headers {
+		headers[name] = value
+	}
+	headerValues := utils.HeaderValues{}
+	for name, value := range headers {
+		headerValues.SetHeaderValue(name, value)
+	}
+	return messages.OnRequest{ //nolint:exhaustruct
+		ID:            requestID,
+		SequenceID:    requestID,
+		Method:        strings.ToUpper(selfTest.request.Method),
+		Scheme:        "http",
+		URL:           url,
+		Path:          path,
+		Query:         query,
+		Headers:       headers,
+		HeaderValues:  headerValues,
+		Time:          selfTest.clock.Now(),
+		ConfigVersion: configVersion,
+	}
+}
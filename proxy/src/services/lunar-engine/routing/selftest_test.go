@@ -0,0 +1,110 @@
+package routing
+
+import (
+	"context"
+	"lunar/engine/config"
+	"lunar/engine/services"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+const selfTestProxyTimeout = 5 * time.Second
+
+func selfTestPolicies(t *testing.T, remedies ...sharedConfig.Remedy) func() *config.PoliciesData {
+	policiesData, err := config.BuildPolicyData(&sharedConfig.PoliciesConfig{ //nolint:exhaustruct
+		Global: sharedConfig.Global{Remedies: remedies}, //nolint:exhaustruct
+	})
+	require.NoError(t, err)
+	return func() *config.PoliciesData { return policiesData }
+}
+
+func collectSelfTestRuns(t *testing.T, reader *sdkMetric.ManualReader) map[string]int64 {
+	var data metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &data))
+	runs := map[string]int64{}
+	for _, scopeMetrics := range data.ScopeMetrics {
+		for _, metric := range scopeMetrics.Metrics {
+			if metric.Name != selfTestRunsMetricName {
+				continue
+			}
+			sum, ok := metric.Data.(metricdata.Sum[int64])
+			require.True(t, ok)
+			for _, point := range sum.DataPoints {
+				result, _ := point.Attributes.Value("result")
+				runs[result.AsString()] += point.Value
+			}
+		}
+	}
+	return runs
+}
+
+func TestSelfTestPassesWhenRemediesHandleTheSyntheticRequest(t *testing.T) {
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	remedies, err := services.InitializeSelfTestRemedies(selfTestProxyTimeout)
+	require.NoError(t, err)
+	policies := selfTestPolicies(t, sharedConfig.Remedy{ //nolint:exhaustruct
+		Enabled: true,
+		Name:    "fixed",
+		Config: sharedConfig.RemedyConfig{ //nolint:exhaustruct
+			FixedResponse: &sharedConfig.FixedResponseConfig{StatusCode: 418},
+		},
+	})
+	selfTest := NewSelfTest(clock.NewMockClock(), meter, 0, SelfTestRequest{
+		Method:  "get",
+		URL:     "api.com/users?page=1",
+		Headers: map[string]string{"Early-Response": "true"},
+	}, policies, remedies)
+
+	_, ran := selfTest.Result()
+	require.False(t, ran)
+	result := selfTest.RunOnce()
+	require.True(t, result.Passed)
+	require.True(t, strings.HasPrefix(result.RequestID, SelfTestRequestIDPrefix))
+	require.Equal(t, policies().ConfigVersion, result.ConfigVersion)
+	latest, ran := selfTest.Result()
+	require.True(t, ran)
+	require.Equal(t, result, latest)
+	require.Equal(t, map[string]int64{"passed": 1}, collectSelfTestRuns(t, reader))
+}
+
+func TestSelfTestFailsWhenARemedyErrors(t *testing.T) {
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	remedies, err := services.InitializeSelfTestRemedies(selfTestProxyTimeout)
+	require.NoError(t, err)
+	policies := selfTestPolicies(t, sharedConfig.Remedy{ //nolint:exhaustruct
+		Enabled: true,
+		Name:    "misconfigured",
+	})
+	selfTest := NewSelfTest(clock.NewMockClock(), meter, 0,
+		SelfTestRequest{Method: "GET", URL: defaultSelfTestURL}, policies, remedies)
+
+	// The self-test circuit never disables the remedy, so every run fails
+	for i := 0; i < 3; i++ {
+		result := selfTest.RunOnce()
+		require.False(t, result.Passed)
+		require.Contains(t, result.Error, "request remedies failed")
+	}
+	require.Equal(t, map[string]int64{"failed": 3}, collectSelfTestRuns(t, reader))
+}
+
+func TestParseSelfTestHeaders(t *testing.T) {
+	headers, err := parseSelfTestHeaders(" X-Tenant = acme ,X-Plan=pro")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"X-Tenant": "acme", "X-Plan": "pro"}, headers)
+
+	headers, err = parseSelfTestHeaders("")
+	require.NoError(t, err)
+	require.Empty(t, headers)
+
+	_, err = parseSelfTestHeaders("X-Tenant")
+	require.Error(t, err)
+}
@@ -0,0 +1,135 @@
+package routing
+
+import (
+	"lunar/engine/actions"
+	"lunar/toolkit-core/clock"
+	"lunar/toolkit-core/vacuum"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	inFlightVacuumName         = "InFlightTransactionsVacuum"
+	inFlightVacuumTick         = 5 * time.Second
+	inFlightDrainTick          = 100 * time.Millisecond
+	defaultShutdownRetryAfter  = 5 * time.Second
+	shutdownResponseBody       = "The proxy is shutting down"
+	retryAfterHeader           = "Retry-After"
+	shutdownResponseStatusCode = 503
+)
+
+// ShutdownState keeps track of the transactions which are in flight,
+// so on graceful shutdown new requests can be rejected while the admitted
+// ones (including those waiting in queues) complete.
+// Transactions which never get a response are vacuumed once the proxy
+// timeout has passed.
+type ShutdownState struct {
+	clock       clock.Clock
+	retryAfter  time.Duration
+	gracePeriod time.Duration
+
+	mutex          *sync.RWMutex
+	isShuttingDown bool
+	inFlight       map[string]struct{}
+	inFlightVacuum *vacuum.MapVacuum[string, struct{}]
+}
+
+func NewShutdownState(
+	clock clock.Clock,
+	proxyTimeout time.Duration,
+	retryAfter time.Duration,
+	gracePeriod time.Duration,
+) *ShutdownState {
+	inFlight := map[string]struct{}{}
+	mutex := sync.RWMutex{}
+	inFlightVacuum := vacuum.NewMapVacuum(
+		inFlightVacuumName,
+		clock,
+		proxyTimeout,
+		inFlightVacuumTick,
+		inFlight,
+		&mutex,
+	)
+	return &ShutdownState{
+		clock:          clock,
+		retryAfter:     retryAfter,
+		gracePeriod:    gracePeriod,
+		mutex:          &mutex,
+		isShuttingDown: false,
+		inFlight:       inFlight,
+		inFlightVacuum: &inFlightVacuum,
+	}
+}
+
+// Begin marks the engine as shutting down,
+// from now on new requests will not be admitted
+func (state *ShutdownState) Begin() {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	state.isShuttingDown = true
+}
+
+func (state *ShutdownState) IsShuttingDown() bool {
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+	return state.isShuttingDown
+}
+
+// Admit tracks the given transaction as in flight, unless the engine
+// is shutting down, in which case the request should be rejected
+func (state *ShutdownState) Admit(transactionID string) bool {
+	state.mutex.Lock()
+	if state.isShuttingDown {
+		state.mutex.Unlock()
+		return false
+	}
+	state.inFlight[transactionID] = struct{}{}
+	state.mutex.Unlock()
+	state.inFlightVacuum.VacuumKey(transactionID)
+	return true
+}
+
+// Complete stops tracking the given transaction
+func (state *ShutdownState) Complete(transactionID string) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	delete(state.inFlight, transactionID)
+}
+
+func (state *ShutdownState) InFlightCount() int {
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+	return len(state.inFlight)
+}
+
+// WaitForInFlight waits until all in flight transactions are completed,
+// or until the grace period has passed.
+// It returns whether all transactions were completed.
+func (state *ShutdownState) WaitForInFlight() bool {
+	deadline := state.clock.Now().Add(state.gracePeriod)
+	for state.InFlightCount() > 0 {
+		if !state.clock.Now().Before(deadline) {
+			return false
+		}
+		<-state.clock.After(inFlightDrainTick)
+	}
+	return true
+}
+
+// RejectionAction responds to requests arriving during shutdown,
+// so clients back off rather than fail on a closed connection
+func (state *ShutdownState) RejectionAction() actions.ReqLunarAction {
+	retryAfterSeconds := int(state.retryAfter.Seconds())
+	if retryAfterSeconds < 0 {
+		retryAfterSeconds = 0
+	}
+	return &actions.EarlyResponseAction{
+		Status: shutdownResponseStatusCode,
+		Body:   shutdownResponseBody,
+		Headers: map[string]string{
+			"Content-Type":   "text/plain",
+			retryAfterHeader: strconv.Itoa(retryAfterSeconds),
+		},
+	}
+}
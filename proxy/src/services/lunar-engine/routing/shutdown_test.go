@@ -0,0 +1,120 @@
+package routing
+
+import (
+	"lunar/engine/actions"
+	"lunar/toolkit-core/clock"
+	"testing"
+	"time"
+
+	spoe "github.com/TheLunarCompany/haproxy-spoe-go"
+	"github.com/stretchr/testify/require"
+)
+
+const testGracePeriod = 10 * time.Second
+
+func TestShutdownStateRejectsRequestsOnlyOnceShuttingDown(t *testing.T) {
+	state := NewShutdownState(
+		clock.NewMockClock(), testProxyTimeout, 7*time.Second, testGracePeriod)
+
+	require.False(t, state.IsShuttingDown())
+	rejection, rejected := shutdownRejection(tracedRequest(), state)
+	require.False(t, rejected)
+	require.Nil(t, rejection)
+	require.Equal(t, 1, state.InFlightCount())
+
+	state.Begin()
+	require.True(t, state.IsShuttingDown())
+	rejection, rejected = shutdownRejection(tracedRequest(), state)
+	require.True(t, rejected)
+	require.True(t, isEarlyResponse(rejection))
+	require.Equal(t, 1, state.InFlightCount())
+}
+
+func TestShutdownRejectionRespondsWithRetryAfter(t *testing.T) {
+	state := NewShutdownState(
+		clock.NewMockClock(), testProxyTimeout, 7*time.Second, testGracePeriod)
+
+	require.Equal(t, &actions.EarlyResponseAction{
+		Status: 503,
+		Body:   shutdownResponseBody,
+		Headers: map[string]string{
+			"Content-Type": "text/plain",
+			"Retry-After":  "7",
+		},
+	}, state.RejectionAction())
+
+	state.Begin()
+	rejection, _ := shutdownRejection(tracedRequest(), state)
+	var status int
+	for _, action := range rejection {
+		setVar, valid := action.(spoe.ActionSetVar)
+		if valid && setVar.Name == actions.StatusCodeActionName {
+			status, _ = setVar.Value.(int)
+		}
+	}
+	require.Equal(t, 503, status)
+}
+
+func TestWaitForInFlightReturnsOnceTransactionsComplete(t *testing.T) {
+	clock := clock.NewMockClock()
+	state := NewShutdownState(clock, testProxyTimeout, time.Second, testGracePeriod)
+	require.True(t, state.Admit("queued"))
+	require.True(t, state.Admit("forwarded"))
+	state.Begin()
+
+	drained := make(chan bool)
+	go func() { drained <- state.WaitForInFlight() }()
+
+	state.Complete("queued")
+	clock.AdvanceTime(inFlightDrainTick)
+	time.Sleep(1 * time.Millisecond)
+	require.Equal(t, 1, state.InFlightCount())
+
+	state.Complete("forwarded")
+	require.Eventually(t, func() bool {
+		clock.AdvanceTime(inFlightDrainTick)
+		select {
+		case result := <-drained:
+			require.True(t, result)
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+}
+
+func TestWaitForInFlightGivesUpAfterGracePeriod(t *testing.T) {
+	clock := clock.NewMockClock()
+	state := NewShutdownState(clock, time.Minute, time.Second, testGracePeriod)
+	require.True(t, state.Admit("stuck"))
+	state.Begin()
+
+	drained := make(chan bool)
+	go func() { drained <- state.WaitForInFlight() }()
+
+	require.Eventually(t, func() bool {
+		clock.AdvanceTime(inFlightDrainTick)
+		select {
+		case result := <-drained:
+			require.False(t, result)
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	require.Equal(t, 1, state.InFlightCount())
+}
+
+func TestUnansweredTransactionIsForgottenAfterProxyTimeout(t *testing.T) {
+	clock := clock.NewMockClock()
+	state := NewShutdownState(clock, testProxyTimeout, time.Second, testGracePeriod)
+	require.True(t, state.Admit("unanswered"))
+
+	for elapsed := time.Duration(0); elapsed <= testProxyTimeout; elapsed += time.Second {
+		clock.AdvanceTime(inFlightVacuumTick)
+		time.Sleep(1 * time.Millisecond)
+	}
+	require.Eventually(t, func() bool {
+		return state.InFlightCount() == 0
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,146 @@
+package routing
+
+import (
+	"context"
+	"lunar/engine/actions"
+	"lunar/engine/messages"
+	"lunar/toolkit-core/clock"
+	"lunar/toolkit-core/otel"
+	"lunar/toolkit-core/vacuum"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	upstreamSpanName          = "routing#upstreamCall"
+	upstreamSpansVacuumName   = "UpstreamSpansVacuum"
+	upstreamSpansVacuumTick   = 5 * time.Second
+	upstreamSpanStatusCodeKey = "http.status_code"
+	upstreamSpanTimeoutStatus = "no response within the proxy timeout"
+)
+
+// UpstreamTracer keeps track of the spans opened for forwarded requests,
+// so a span can be ended once the response of the upstream call arrives.
+// Spans of transactions which never get a response are ended with an error
+// status and vacuumed once the proxy timeout has passed.
+type UpstreamTracer struct {
+	spans       map[string]trace.Span
+	spansMutex  *sync.RWMutex
+	spansVacuum *vacuum.MapVacuum[string, trace.Span]
+	forceTrace  otel.ForceTraceConfig
+}
+
+func NewUpstreamTracer(
+	clock clock.Clock,
+	proxyTimeout time.Duration,
+	forceTrace otel.ForceTraceConfig,
+) *UpstreamTracer {
+	spans := map[string]trace.Span{}
+	mutex := sync.RWMutex{}
+	spansVacuum := vacuum.NewMapVacuum(
+		upstreamSpansVacuumName,
+		clock,
+		proxyTimeout,
+		upstreamSpansVacuumTick,
+		spans,
+		&mutex,
+	)
+	spansVacuum.OnVacuum(func(_ string, span trace.Span) {
+		span.SetStatus(codes.Error, upstreamSpanTimeoutStatus)
+		span.End()
+	})
+	return &UpstreamTracer{
+		spans:       spans,
+		spansMutex:  &mutex,
+		spansVacuum: &spansVacuum,
+		forceTrace:  forceTrace,
+	}
+}
+
+// StartSpan opens a client span for the upstream call of the given request,
+// continuing the trace context the client sent (if any). It returns an action
+// which injects the W3C trace context and baggage headers into the forwarded
+// request, or nil when tracing is disabled.
+// Requests of trusted sources may force their span to be sampled.
+func (tracer *UpstreamTracer) StartSpan(
+	ctx context.Context,
+	onRequest messages.OnRequest,
+) actions.ReqLunarAction {
+	ctx = otel.ExtractContext(ctx, onRequest.Headers)
+	attributes := []attribute.KeyValue{
+		attribute.String("http.method", onRequest.Method),
+		attribute.String("http.url", onRequest.URL),
+	}
+	if tracer.forceTrace.IsForced(onRequest.ClientIP, onRequest.Headers) {
+		attributes = append(attributes,
+			attribute.Bool(otel.ForceTraceAttributeKey, true))
+	}
+	ctx, span := otel.Tracer(ctx, upstreamSpanName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...),
+	)
+
+	propagationHeaders := otel.InjectContext(ctx)
+	if len(propagationHeaders) == 0 {
+		span.End()
+		return nil
+	}
+
+	tracer.spansMutex.Lock()
+	tracer.spans[onRequest.ID] = span
+	tracer.spansMutex.Unlock()
+	tracer.spansVacuum.VacuumKey(onRequest.ID)
+
+	return &actions.ModifyRequestAction{HeadersToSet: propagationHeaders}
+}
+
+// EndSpan ends the span of a request that was never forwarded upstream,
+// e.g. since an early response was returned to the client.
+func (tracer *UpstreamTracer) EndSpan(transactionID string) {
+	if span, found := tracer.popSpan(transactionID); found {
+		span.End()
+	}
+}
+
+// EndSpanWithResponse ends the span of the upstream call the given
+// response belongs to, recording its status code.
+func (tracer *UpstreamTracer) EndSpanWithResponse(
+	onResponse messages.OnResponse,
+) {
+	span, found := tracer.popSpan(onResponse.ID)
+	if !found {
+		return
+	}
+	span.SetAttributes(attribute.Int(upstreamSpanStatusCodeKey, onResponse.Status))
+	if onResponse.Status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(onResponse.Status))
+	}
+	span.End()
+}
+
+// SpanContext is of the span opened for the upstream call of the given
+// request, it is invalid if none was
+func (tracer *UpstreamTracer) SpanContext(transactionID string) trace.SpanContext {
+	tracer.spansMutex.RLock()
+	defer tracer.spansMutex.RUnlock()
+	span, found := tracer.spans[transactionID]
+	if !found {
+		return trace.SpanContext{}
+	}
+	return span.SpanContext()
+}
+
+func (tracer *UpstreamTracer) popSpan(transactionID string) (trace.Span, bool) {
+	tracer.spansMutex.Lock()
+	defer tracer.spansMutex.Unlock()
+	span, found := tracer.spans[transactionID]
+	if found {
+		delete(tracer.spans, transactionID)
+	}
+	return span, found
+}
@@ -0,0 +1,221 @@
+package routing
+
+import (
+	"context"
+	"lunar/engine/actions"
+	"lunar/engine/messages"
+	"lunar/toolkit-core/clock"
+	lunarOtel "lunar/toolkit-core/otel"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	spoe "github.com/TheLunarCompany/haproxy-spoe-go"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const (
+	incomingTraceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	incomingTraceparent = "00-" + incomingTraceID + "-00f067aa0ba902b7-01"
+	testProxyTimeout    = 2 * time.Second
+)
+
+func withTracingEnabled(t *testing.T) *tracetest.SpanRecorder {
+	previousPropagator := otel.GetTextMapPropagator()
+	previousProvider := otel.GetTracerProvider()
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+		sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() {
+		otel.SetTextMapPropagator(previousPropagator)
+		otel.SetTracerProvider(previousProvider)
+	})
+	return recorder
+}
+
+func withTracingDisabled(t *testing.T) {
+	previousPropagator := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	t.Cleanup(func() { otel.SetTextMapPropagator(previousPropagator) })
+}
+
+func tracedRequest() messages.OnRequest {
+	return messages.OnRequest{
+		ID:      "1234",
+		Method:  "GET",
+		URL:     "api.com/users",
+		Headers: map[string]string{"Traceparent": incomingTraceparent},
+	}
+}
+
+func startUpstreamSpan(
+	t *testing.T,
+	tracer *UpstreamTracer,
+) *actions.ModifyRequestAction {
+	action := tracer.StartSpan(context.Background(), tracedRequest())
+	modifyAction, valid := action.(*actions.ModifyRequestAction)
+	require.True(t, valid)
+	return modifyAction
+}
+
+func TestStartSpanPropagatesIncomingTraceContext(t *testing.T) {
+	recorder := withTracingEnabled(t)
+	tracer := NewUpstreamTracer(clock.NewMockClock(), testProxyTimeout,
+		lunarOtel.ForceTraceConfig{})
+
+	action := startUpstreamSpan(t, tracer)
+	require.True(t, strings.HasPrefix(
+		action.HeadersToSet["traceparent"], "00-"+incomingTraceID+"-"))
+	require.Empty(t, recorder.Ended())
+}
+
+func TestStartSpanReturnsNilWhenTracingIsDisabled(t *testing.T) {
+	withTracingDisabled(t)
+	tracer := NewUpstreamTracer(clock.NewMockClock(), testProxyTimeout,
+		lunarOtel.ForceTraceConfig{})
+
+	action := tracer.StartSpan(context.Background(), tracedRequest())
+	require.Nil(t, action)
+	require.Empty(t, tracer.spans)
+}
+
+func TestEndSpanWithResponseRecordsResponseStatus(t *testing.T) {
+	recorder := withTracingEnabled(t)
+	tracer := NewUpstreamTracer(clock.NewMockClock(), testProxyTimeout,
+		lunarOtel.ForceTraceConfig{})
+
+	startUpstreamSpan(t, tracer)
+	tracer.EndSpanWithResponse(messages.OnResponse{ID: "1234", Status: 503})
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, upstreamSpanName, spans[0].Name())
+	require.Equal(t, incomingTraceID, spans[0].SpanContext().TraceID().String())
+	require.Contains(t, spans[0].Attributes(),
+		attribute.Int(upstreamSpanStatusCodeKey, 503))
+	require.Equal(t, codes.Error, spans[0].Status().Code)
+
+	// the span is ended only once
+	tracer.EndSpanWithResponse(messages.OnResponse{ID: "1234", Status: 200})
+	require.Len(t, recorder.Ended(), 1)
+}
+
+func TestEarlyResponseEndsSpan(t *testing.T) {
+	recorder := withTracingEnabled(t)
+	tracer := NewUpstreamTracer(clock.NewMockClock(), testProxyTimeout,
+		lunarOtel.ForceTraceConfig{})
+
+	traceAction := startUpstreamSpan(t, tracer)
+	earlyResponse := &actions.EarlyResponseAction{Status: 429}
+	spoeActions := getSPOEReqActions(tracedRequest(),
+		prependAction(traceAction, []actions.ReqLunarAction{earlyResponse}))
+	require.True(t, isEarlyResponse(spoeActions))
+
+	tracer.EndSpan("1234")
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, codes.Unset, spans[0].Status().Code)
+}
+
+func TestUnansweredSpanIsEndedAfterProxyTimeout(t *testing.T) {
+	recorder := withTracingEnabled(t)
+	clock := clock.NewMockClock()
+	tracer := NewUpstreamTracer(clock, testProxyTimeout, lunarOtel.ForceTraceConfig{})
+
+	startUpstreamSpan(t, tracer)
+	for elapsed := time.Duration(0); elapsed <= testProxyTimeout; elapsed += time.Second {
+		clock.AdvanceTime(upstreamSpansVacuumTick)
+		time.Sleep(1 * time.Millisecond)
+	}
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, codes.Error, spans[0].Status().Code)
+	require.Equal(t, upstreamSpanTimeoutStatus, spans[0].Status().Description)
+}
+
+func TestPrependActionKeepsFlowActionsPrioritized(t *testing.T) {
+	traceAction := &actions.ModifyRequestAction{
+		HeadersToSet: map[string]string{"traceparent": incomingTraceparent},
+	}
+	flowAction := &actions.ModifyRequestAction{
+		HeadersToSet: map[string]string{"X-Flow": "true"},
+	}
+
+	require.Equal(t, []actions.ReqLunarAction{flowAction},
+		prependAction(nil, []actions.ReqLunarAction{flowAction}))
+	require.Equal(t, []actions.ReqLunarAction{traceAction, flowAction},
+		prependAction(traceAction, []actions.ReqLunarAction{flowAction}))
+
+	spoeActions := getSPOEReqActions(tracedRequest(),
+		prependAction(traceAction, []actions.ReqLunarAction{flowAction}))
+	require.False(t, isEarlyResponse(spoeActions))
+	var requestHeaders string
+	for _, action := range spoeActions {
+		setVar, valid := action.(spoe.ActionSetVar)
+		if valid && setVar.Name == actions.RequestHeadersActionName {
+			requestHeaders, _ = setVar.Value.(string)
+		}
+	}
+	require.Contains(t, requestHeaders, "X-Flow:true\n")
+	require.Contains(t, requestHeaders, "traceparent:"+incomingTraceparent+"\n")
+}
+
+func TestIsEarlyResponse(t *testing.T) {
+	require.False(t, isEarlyResponse(nil))
+	require.False(t, isEarlyResponse((&actions.NoOpAction{}).ReqToSpoeActions()))
+	require.True(t, isEarlyResponse(
+		(&actions.EarlyResponseAction{Status: 200}).ReqToSpoeActions()))
+}
+
+func TestStartSpanSamplesRequestsForcingTraceOfTrustedSources(t *testing.T) {
+	previousPropagator := otel.GetTextMapPropagator()
+	previousProvider := otel.GetTracerProvider()
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(lunarOtel.NewForceTraceSampler(sdktrace.NeverSample())),
+		sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() {
+		otel.SetTextMapPropagator(previousPropagator)
+		otel.SetTracerProvider(previousProvider)
+	})
+	tracer := NewUpstreamTracer(clock.NewMockClock(), testProxyTimeout,
+		lunarOtel.ForceTraceConfig{
+			Header:         "X-Lunar-Force-Trace",
+			TrustedSources: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		})
+	request := func(id string, clientIP string) messages.OnRequest {
+		return messages.OnRequest{
+			ID:       id,
+			ClientIP: clientIP,
+			Method:   "GET",
+			URL:      "api.com/users",
+			Headers:  map[string]string{"X-Lunar-Force-Trace": "true"},
+		}
+	}
+
+	trusted, valid := tracer.StartSpan(context.Background(),
+		request("trusted", "10.0.0.1")).(*actions.ModifyRequestAction)
+	require.True(t, valid)
+	require.True(t, strings.HasSuffix(trusted.HeadersToSet["traceparent"], "-01"))
+
+	untrusted, valid := tracer.StartSpan(context.Background(),
+		request("untrusted", "172.16.0.1")).(*actions.ModifyRequestAction)
+	require.True(t, valid)
+	require.True(t, strings.HasSuffix(untrusted.HeadersToSet["traceparent"], "-00"))
+
+	tracer.EndSpan("trusted")
+	tracer.EndSpan("untrusted")
+	require.Len(t, recorder.Ended(), 1)
+}
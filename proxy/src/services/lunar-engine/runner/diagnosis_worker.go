@@ -1,16 +1,24 @@
 package runner
 
 import (
+	"context"
 	"lunar/engine/config"
 	"lunar/engine/messages"
 	"lunar/engine/services"
 	"lunar/engine/utils"
+	"lunar/engine/utils/bodybuffer"
+	"lunar/engine/utils/environment"
 	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
 	contextmanager "lunar/toolkit-core/context-manager"
+	"lunar/toolkit-core/otel"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/metric"
 )
 
 type DiagnosisTask struct {
@@ -18,9 +26,52 @@ type DiagnosisTask struct {
 	Response messages.OnResponse
 }
 
+// diagnosisEntry is a cached task, whose response body is buffered apart,
+// so large bodies are spilled to disk until the task is diagnosed
+type diagnosisEntry struct {
+	task         DiagnosisTask
+	responseBody *bodybuffer.Buffer
+}
+
+func (entry diagnosisEntry) release() {
+	if entry.responseBody != nil {
+		entry.responseBody.Release()
+	}
+}
+
 type DiagnosisWorker struct {
-	diagnosisCache utils.Cache[string, DiagnosisTask]
+	diagnosisCache utils.Cache[string, diagnosisEntry]
 	diagnosisData  chan string
+	bodySpiller    *bodybuffer.Spiller
+	// The pipeline sheds transactions above its cap, so diagnosing and
+	// exporting them does not compete with request handling on traffic spikes
+	transactionsLimiter       *transactionsLimiter
+	droppedTransactionsMetric metric.Int64Counter
+}
+
+// transactionsLimiter admits up to a number of transactions per second.
+// It is local to the pipeline, as it only protects this instance.
+type transactionsLimiter struct {
+	clock         clock.Clock
+	maxPerSecond  int64
+	mutex         sync.Mutex
+	windowEndTime time.Time
+	admitted      int64
+}
+
+func (limiter *transactionsLimiter) admit() bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	now := limiter.clock.Now()
+	if !now.Before(limiter.windowEndTime) {
+		limiter.windowEndTime = now.Truncate(time.Second).Add(time.Second)
+		limiter.admitted = 0
+	}
+	if limiter.admitted >= limiter.maxPerSecond {
+		return false
+	}
+	limiter.admitted++
+	return true
 }
 
 const (
@@ -30,15 +81,69 @@ const (
 	// channelBufferSize: The size of the channel buffer,
 	// the number of messages that can be stored without blocking the flow.
 	channelBufferSize int = 128
+	// defaultMaxTransactionsPerSec: The default cap on transactions diagnosed
+	// per second, high enough to not affect normal operation
+	defaultMaxTransactionsPerSec int64 = 10000
+
+	droppedTransactionsMetricName = "lunar_diagnosis.dropped_transactions"
 )
 
 func NewDiagnosisWorker() *DiagnosisWorker {
+	maxTransactionsPerSec, err := environment.GetDiagnosisMaxTransactionsPerSec()
+	if err != nil || maxTransactionsPerSec <= 0 {
+		maxTransactionsPerSec = defaultMaxTransactionsPerSec
+	}
+	return NewLimitedDiagnosisWorker(
+		contextmanager.Get().GetClock(),
+		otel.GetMeter(),
+		maxTransactionsPerSec,
+	).WithBodySpiller(bodybuffer.NewSpillerFromEnv())
+}
+
+// NewLimitedDiagnosisWorker builds a worker diagnosing up to the given
+// number of transactions per second, dropping the rest
+func NewLimitedDiagnosisWorker(
+	clock clock.Clock,
+	meter metric.Meter,
+	maxTransactionsPerSec int64,
+) *DiagnosisWorker {
+	droppedTransactionsMetric, err := meter.Int64Counter(
+		droppedTransactionsMetricName,
+		metric.WithDescription("Transactions not diagnosed, "+
+			"as the diagnosis pipeline was at its cap"),
+		metric.WithUnit("{transaction}"),
+	)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to create dropped transactions metric")
+	}
+	diagnosisCache := utils.NewMemoryCache[string, diagnosisEntry](clock)
+	// Spill files are removed once their task is diagnosed or dropped,
+	// or if it expires before being diagnosed
+	diagnosisCache.WithOnEvict(func(_ string, entry diagnosisEntry) {
+		entry.release()
+	})
 	return &DiagnosisWorker{
-		diagnosisCache: utils.NewMemoryCache[string, DiagnosisTask](contextmanager.Get().GetClock()),
+		diagnosisCache: diagnosisCache,
 		diagnosisData:  make(chan string, channelBufferSize),
+		bodySpiller: bodybuffer.NewSpiller(
+			bodybuffer.DefaultInMemoryThresholdBytes, ""),
+		transactionsLimiter: &transactionsLimiter{ //nolint:exhaustruct
+			clock:        clock,
+			maxPerSecond: maxTransactionsPerSec,
+		},
+		droppedTransactionsMetric: droppedTransactionsMetric,
 	}
 }
 
+// WithBodySpiller sets how the response bodies of the tasks waiting to be
+// diagnosed are buffered
+func (worker *DiagnosisWorker) WithBodySpiller(
+	spiller *bodybuffer.Spiller,
+) *DiagnosisWorker {
+	worker.bodySpiller = spiller
+	return worker
+}
+
 func (worker *DiagnosisWorker) AddRequestToTask(onRequest messages.OnRequest) {
 	var emptyResponse messages.OnResponse
 
@@ -48,7 +153,10 @@ func (worker *DiagnosisWorker) AddRequestToTask(onRequest messages.OnRequest) {
 
 	err := worker.diagnosisCache.Set(
 		cacheKey,
-		DiagnosisTask{Request: onRequest.DeepCopy(), Response: emptyResponse},
+		diagnosisEntry{
+			task:         DiagnosisTask{Request: onRequest.DeepCopy(), Response: emptyResponse},
+			responseBody: nil,
+		},
 		cacheTTL)
 	if err != nil {
 		log.Warn().
@@ -63,7 +171,7 @@ func (worker *DiagnosisWorker) AddResponseToTask(
 	onResponse messages.OnResponse,
 ) {
 	cacheKey := strings.Clone(onResponse.ID)
-	task, found := worker.diagnosisCache.Get(cacheKey)
+	entry, found := worker.diagnosisCache.Get(cacheKey)
 
 	if !found {
 		log.Warn().
@@ -72,15 +180,27 @@ func (worker *DiagnosisWorker) AddResponseToTask(
 		return
 	}
 
-	task.Response = onResponse.DeepCopy()
+	responseBody, err := worker.bodySpiller.Buffer(onResponse.Body)
+	if err != nil {
+		log.Warn().Err(err).
+			Msgf("Failed to buffer response body of %v, it will not be diagnosed",
+				cacheKey)
+		worker.diagnosisCache.Del(cacheKey)
+		return
+	}
+	// The body is buffered apart, so the response is copied without it
+	onResponse.Body = ""
+	entry.task.Response = onResponse.DeepCopy()
+	entry.responseBody = responseBody
 	log.Trace().Msgf(
 		"Adding response data to the cache with key: %v, value: %+v",
 		cacheKey,
-		task.Response,
+		entry.task.Response,
 	)
 
-	err := worker.diagnosisCache.Set(cacheKey, task, cacheTTL)
+	err = worker.diagnosisCache.Set(cacheKey, entry, cacheTTL)
 	if err != nil {
+		responseBody.Release()
 		log.Warn().
 			Msgf("Failed to cache key: %v, cache: %+v. %+v",
 				cacheKey, worker.diagnosisCache, err)
@@ -91,6 +211,15 @@ func (worker *DiagnosisWorker) AddResponseToTask(
 }
 
 func (worker *DiagnosisWorker) NotifyTaskReady(transactionID string) {
+	if !worker.transactionsLimiter.admit() {
+		log.Trace().Msgf("Diagnosis pipeline is at its cap, dropping %v",
+			transactionID)
+		worker.diagnosisCache.Del(transactionID)
+		if worker.droppedTransactionsMetric != nil {
+			worker.droppedTransactionsMetric.Add(context.Background(), 1)
+		}
+		return
+	}
 	// This is executed in a separate goroutine
 	// to avoid blocking the flow if the channel is full.
 	log.Trace().Msgf(
@@ -131,7 +260,7 @@ func (worker *DiagnosisWorker) diagnosisWorker(
 		Logger() // TODO: How to share the logger down the call stack?
 
 	for taskKey := range diagnosisTasks {
-		task, found := worker.diagnosisCache.Get(taskKey)
+		entry, found := worker.diagnosisCache.Get(taskKey)
 		if !found {
 			sublogger.Error().Msgf(
 				"Failed to find transaction for key: %v, cache keys: %+v",
@@ -144,13 +273,18 @@ func (worker *DiagnosisWorker) diagnosisWorker(
 			config.TxnID(taskKey),
 		)
 
-		RunTask(
-			task,
+		// The response body is streamed from its buffer by the diagnoses
+		runTask(
+			entry.task,
+			entry.responseBody,
 			&policiesData.EndpointPolicyTree,
 			policiesData.Config.Global.Diagnosis,
+			policiesData.Config.Exporters,
 			plugins,
 			exporters,
 		)
+		// Releases the buffered response body, removing its spill file
+		worker.diagnosisCache.Del(taskKey)
 
 		// Set the function as low priority to give more runtime to the remedy types.
 		runtime.Gosched()
@@ -161,6 +295,21 @@ func RunTask(
 	task DiagnosisTask,
 	policyTree *config.EndpointPolicyTree,
 	globalDiagnoses []sharedConfig.Diagnosis,
+	exportersConfig sharedConfig.Exporters,
+	plugins *services.DiagnosisPlugins,
+	exporters *services.Exporters,
+) {
+	runTask(task, nil, policyTree, globalDiagnoses, exportersConfig, plugins, exporters)
+}
+
+// runTask runs the diagnoses of the task, whose response body is buffered
+// apart from its response unless the buffer is nil
+func runTask(
+	task DiagnosisTask,
+	responseBody *bodybuffer.Buffer,
+	policyTree *config.EndpointPolicyTree,
+	globalDiagnoses []sharedConfig.Diagnosis,
+	exportersConfig sharedConfig.Exporters,
 	plugins *services.DiagnosisPlugins,
 	exporters *services.Exporters,
 ) {
@@ -170,9 +319,11 @@ func RunTask(
 	runOnTransaction(
 		task.Request,
 		task.Response,
+		responseBody,
 		diagnoses,
 		plugins,
 		exporters,
+		exportersConfig,
 		policyTree,
 	)
 }
@@ -3,17 +3,25 @@
 package runner_test
 
 import (
+	"context"
 	"fmt"
 	"lunar/engine/config"
 	"lunar/engine/messages"
 	"lunar/engine/runner"
 	"lunar/engine/services"
+	"lunar/engine/utils/bodybuffer"
 	sharedConfig "lunar/shared-model/config"
 	"lunar/toolkit-core/clock"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 )
 
 const (
@@ -67,6 +75,7 @@ func TestGivenOnRequestAndAMatchingHARExportDiagnosisHARDataIsWritten(
 		runner.DiagnosisTask{onRequest, onResponse},
 		policyTree,
 		globalPolicies.Diagnosis,
+		exporterConfig,
 		&services.Diagnosis,
 		&services.Exporters,
 	)
@@ -138,6 +147,7 @@ func TestGivenOnRequestAndAMatchingFixedResponseRemedyAndHARExportDiagnosisHARDa
 		runner.DiagnosisTask{onRequest, onResponse},
 		policyTree,
 		globalPolicies.Diagnosis,
+		exporterConfig,
 		&services.Diagnosis,
 		&services.Exporters,
 	)
@@ -221,6 +231,7 @@ func TestGivenOnMultipleDifferentRequestsAllAreDiagnosed(
 		runner.DiagnosisTask{onRequest1, onResponse1},
 		policyTree,
 		globalPolicies.Diagnosis,
+		exporterConfig,
 		&services.Diagnosis,
 		&services.Exporters,
 	)
@@ -228,6 +239,7 @@ func TestGivenOnMultipleDifferentRequestsAllAreDiagnosed(
 		runner.DiagnosisTask{onRequest2, onResponse2},
 		policyTree,
 		globalPolicies.Diagnosis,
+		exporterConfig,
 		&services.Diagnosis,
 		&services.Exporters,
 	)
@@ -236,3 +248,255 @@ func TestGivenOnMultipleDifferentRequestsAllAreDiagnosed(
 	assert.Contains(t, mockWriter.messages, "twitter.com/user/1234/posts")
 	fmt.Printf("HAR file content: %s \n", mockWriter.messages)
 }
+
+// syncMockWriter counts the messages written from the diagnosis worker
+type syncMockWriter struct {
+	mutex    sync.Mutex
+	messages int
+	content  strings.Builder
+}
+
+func (writer *syncMockWriter) Write(b []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.messages++
+	writer.content.Write(b)
+	return len(b), nil
+}
+
+func (writer *syncMockWriter) Close() error {
+	return nil
+}
+
+func (writer *syncMockWriter) written() int {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	return writer.messages
+}
+
+func (writer *syncMockWriter) writtenContent() string {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	return writer.content.String()
+}
+
+func collectDroppedTransactions(t *testing.T, reader *sdkMetric.ManualReader) int64 {
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, recordedMetric := range scopeMetrics.Metrics {
+			if recordedMetric.Name != "lunar_diagnosis.dropped_transactions" {
+				continue
+			}
+			sum, _ := recordedMetric.Data.(metricdata.Sum[int64])
+			var dropped int64
+			for _, dataPoint := range sum.DataPoints {
+				dropped += dataPoint.Value
+			}
+			return dropped
+		}
+	}
+	return 0
+}
+
+func TestDiagnosisWorkerDropsTransactionsAboveItsCap(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	policiesAccessor := config.SimplePolicyAccessor{
+		PoliciesData: &config.PoliciesData{
+			Config: sharedConfig.PoliciesConfig{
+				Global: *globalPolicies(),
+			},
+			EndpointPolicyTree: *diagnosisEndpointPolicyTree(),
+		},
+	}
+	writer := &syncMockWriter{}
+	services, _ := services.Initialize(writer, proxyTimeout, sharedConfig.Exporters{})
+	diagnosisWorker := runner.NewLimitedDiagnosisWorker(clock, meter, 2)
+	diagnosisWorker.Run(&policiesAccessor, &services.Diagnosis, &services.Exporters)
+	t.Cleanup(diagnosisWorker.Stop)
+
+	diagnoseTransaction := func(id string) {
+		diagnosisWorker.AddRequestToTask(messages.OnRequest{
+			ID:      id,
+			Method:  "GET",
+			Scheme:  "https",
+			URL:     "twitter.com/user/1234/messages",
+			Headers: map[string]string{},
+			Time:    clock.Now(),
+		})
+		diagnosisWorker.AddResponseToTask(messages.OnResponse{
+			ID:      id,
+			Method:  "GET",
+			URL:     "twitter.com/user/1234/messages",
+			Status:  200,
+			Headers: map[string]string{},
+			Time:    clock.Now(),
+		})
+		diagnosisWorker.NotifyTaskReady(id)
+	}
+
+	for i := 0; i < 5; i++ {
+		diagnoseTransaction(fmt.Sprintf("transaction-%d", i))
+	}
+	require.Eventually(t, func() bool { return writer.written() == 2 },
+		time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(3), collectDroppedTransactions(t, reader))
+
+	// The cap applies per second
+	clock.AdvanceTime(time.Second)
+	diagnoseTransaction("transaction-5")
+	require.Eventually(t, func() bool { return writer.written() == 3 },
+		time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(3), collectDroppedTransactions(t, reader))
+}
+
+func TestDiagnosisWorkerSpillsLargeResponseBodiesUntilDiagnosed(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	meter := sdkMetric.NewMeterProvider().Meter("test")
+	policiesAccessor := config.SimplePolicyAccessor{
+		PoliciesData: &config.PoliciesData{
+			Config: sharedConfig.PoliciesConfig{
+				Global: *globalPolicies(),
+			},
+			EndpointPolicyTree: *diagnosisEndpointPolicyTree(),
+		},
+	}
+	writer := &syncMockWriter{}
+	services, _ := services.Initialize(writer, proxyTimeout, sharedConfig.Exporters{})
+	spillDirectory := t.TempDir()
+	diagnosisWorker := runner.NewLimitedDiagnosisWorker(clock, meter, 10).
+		WithBodySpiller(bodybuffer.NewSpiller(64, spillDirectory))
+	spillFiles := func() []string {
+		files, err := filepath.Glob(filepath.Join(spillDirectory, "*"))
+		require.NoError(t, err)
+		return files
+	}
+
+	body := strings.Repeat("spilled-body-", 100)
+	diagnosisWorker.AddRequestToTask(messages.OnRequest{
+		ID:      txnID,
+		Method:  "GET",
+		Scheme:  "https",
+		URL:     "twitter.com/user/1234/messages",
+		Headers: map[string]string{},
+		Time:    clock.Now(),
+	})
+	diagnosisWorker.AddResponseToTask(messages.OnResponse{
+		ID:      txnID,
+		Method:  "GET",
+		URL:     "twitter.com/user/1234/messages",
+		Status:  200,
+		Headers: map[string]string{},
+		Body:    body,
+		Time:    clock.Now(),
+	})
+	// The body waits on disk until the transaction is diagnosed
+	require.Len(t, spillFiles(), 1)
+
+	diagnosisWorker.Run(&policiesAccessor, &services.Diagnosis, &services.Exporters)
+	t.Cleanup(diagnosisWorker.Stop)
+	diagnosisWorker.NotifyTaskReady(txnID)
+
+	require.Eventually(t, func() bool { return writer.written() == 1 },
+		time.Second, 10*time.Millisecond)
+	require.Contains(t, writer.writtenContent(), body)
+	require.Eventually(t, func() bool { return len(spillFiles()) == 0 },
+		time.Second, 10*time.Millisecond)
+}
+
+func TestDiagnosisWorkerRemovesSpilledBodiesOfDroppedTransactions(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	meter := sdkMetric.NewMeterProvider().Meter("test")
+	spillDirectory := t.TempDir()
+	// No transaction is admitted, so all of them are dropped
+	diagnosisWorker := runner.NewLimitedDiagnosisWorker(clock, meter, 0).
+		WithBodySpiller(bodybuffer.NewSpiller(0, spillDirectory))
+
+	diagnosisWorker.AddRequestToTask(messages.OnRequest{
+		ID: txnID, Method: "GET", URL: "twitter.com/user/1234/messages",
+	})
+	diagnosisWorker.AddResponseToTask(messages.OnResponse{
+		ID: txnID, Method: "GET", URL: "twitter.com/user/1234/messages",
+		Status: 200, Body: "dropped body",
+	})
+	files, err := filepath.Glob(filepath.Join(spillDirectory, "*"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	diagnosisWorker.NotifyTaskReady(txnID)
+	files, err = filepath.Glob(filepath.Join(spillDirectory, "*"))
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestEachExporterRecordsTheTransactionsOfItsRules(t *testing.T) {
+	t.Parallel()
+	harDiagnosis := func(name string, export string) sharedConfig.Diagnosis {
+		return sharedConfig.Diagnosis{
+			Name:    name,
+			Enabled: true,
+			Config: sharedConfig.DiagnosisConfig{
+				HARExporter: &sharedConfig.HARExporterConfig{
+					Obfuscate: sharedConfig.Obfuscate{Enabled: false},
+				},
+			},
+			Export: export,
+		}
+	}
+	globalDiagnoses := []sharedConfig.Diagnosis{
+		harDiagnosis("errors", "file"),
+		harDiagnosis("everything", "s3"),
+	}
+	exporterConfig := sharedConfig.Exporters{
+		Rules: map[string]sharedConfig.ExportRules{
+			"file": {
+				Include: []sharedConfig.TransactionMatch{{
+					StatusCode: []sharedConfig.Range[int]{{From: 500, To: 599}},
+				}},
+			},
+		},
+	}
+	policyTree, err := config.BuildEndpointPolicyTree([]sharedConfig.EndpointConfig{})
+	require.NoError(t, err)
+	mockWriter := newMockWriter()
+	services, _ := services.Initialize(mockWriter, proxyTimeout, exporterConfig)
+
+	for index, status := range []int{200, 404, 503} {
+		onRequest := messages.OnRequest{
+			ID:      fmt.Sprintf("txn-%d", index),
+			Method:  "GET",
+			Scheme:  "https",
+			URL:     "twitter.com/user/1234/messages",
+			Headers: map[string]string{},
+		}
+		onResponse := messages.OnResponse{
+			ID:      onRequest.ID,
+			Method:  "GET",
+			URL:     onRequest.URL,
+			Status:  status,
+			Headers: map[string]string{},
+		}
+		runner.RunTask(
+			runner.DiagnosisTask{onRequest, onResponse},
+			policyTree,
+			globalDiagnoses,
+			exporterConfig,
+			&services.Diagnosis,
+			&services.Exporters,
+		)
+	}
+
+	perExporter := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSpace(mockWriter.messages), "\n") {
+		exporterName, _, _ := strings.Cut(line, " ")
+		perExporter[exporterName]++
+	}
+	assert.Equal(t, map[string]int{"file": 1, "s3": 3}, perExporter)
+	assert.Contains(t, mockWriter.messages, `"status":503`)
+}
@@ -36,17 +36,22 @@ func (a *activeRemediesActionName) String() string {
 	return res
 }
 
+// DispatchOnRequest runs the relevant remedies on the given request.
+// `baseAction` (may be nil) is applied to the request before any remedy,
+// so remedies' actions are prioritized over it.
 func DispatchOnRequest(
 	onRequest messages.OnRequest,
 	policyTree *config.EndpointPolicyTree,
 	policiesConfig *sharedConfig.PoliciesConfig,
 	services *services.PoliciesServices,
 	diagnosisWorker *DiagnosisWorker,
+	baseAction actions.ReqLunarAction,
 ) ([]spoe.Action, error) {
 	remedies := getRemedies(
 		onRequest.Method, onRequest.URL, policyTree, &policiesConfig.Global)
 	reqRunResult, err := runOnRequest(
-		onRequest, remedies, &services.Remedies, policiesConfig.Accounts)
+		onRequest, remedies, &services.Remedies, policiesConfig.Accounts, baseAction)
+	services.Remedies.TransactionRemedies.Record(onRequest.ID, reqRunResult.ranRemedies)
 	if err != nil {
 		if shouldDiagnose(
 			onRequest.Method,
@@ -101,6 +106,36 @@ func DispatchOnRequest(
 	return spoeActions, nil
 }
 
+// DispatchOnDeadlineExceeded runs the remedies the request ran on, on the
+// response it is given once its deadline passed after the remedies let it
+// through, so they release the slots and state they took for it
+func DispatchOnDeadlineExceeded(
+	onRequest messages.OnRequest,
+	policyTree *config.EndpointPolicyTree,
+	globalPolicies *sharedConfig.Global,
+	services *services.PoliciesServices,
+	diagnosisWorker *DiagnosisWorker,
+) {
+	earlyResponseAction := actions.NewDeadlineExceededAction(onRequest.Deadline)
+	onResponse := messages.OnResponse{
+		ID:            onRequest.ID,
+		SequenceID:    onRequest.SequenceID,
+		Method:        onRequest.Method,
+		URL:           onRequest.URL,
+		Status:        earlyResponseAction.Status,
+		Headers:       earlyResponseAction.Headers,
+		Body:          earlyResponseAction.Body,
+		Time:          onRequest.Time,
+		ConfigVersion: onRequest.ConfigVersion,
+	}
+	if _, err := getOnResponseRunResult(
+		onResponse, policyTree, globalPolicies, services, diagnosisWorker,
+	); err != nil {
+		log.Error().Err(err).Str("request-id", onRequest.ID).
+			Msg("Failed to run remedies on response of exceeded deadline")
+	}
+}
+
 type modifiedEarlyResponse struct {
 	modifiedRequestRunResult requestRunResult
 	spoeActions              []spoe.Action
@@ -176,6 +211,7 @@ func DispatchOnResponse(
 	services *services.PoliciesServices,
 	diagnosisWorker *DiagnosisWorker,
 ) ([]spoe.Action, error) {
+	services.Remedies.UpstreamHealth.Observe(onResponse)
 	runResult, err := getOnResponseRunResult(
 		onResponse, policyTree, globalPolicies, services, diagnosisWorker)
 	if err != nil {
@@ -202,8 +238,14 @@ func getOnResponseRunResult(
 	services *services.PoliciesServices,
 	diagnosisWorker *DiagnosisWorker,
 ) (responseRunResult, error) {
-	scopedRemedies := getRemedies(
-		onResponse.Method, onResponse.URL, policyTree, globalPolicies)
+	scopedRemedies, recorded := services.Remedies.TransactionRemedies.Take(onResponse.ID)
+	if !recorded {
+		scopedRemedies = applicableRemedies(
+			getRemedies(onResponse.Method, onResponse.URL, policyTree, globalPolicies),
+			onResponse,
+			&services.Remedies,
+		)
+	}
 	runResult, err := runOnResponse(
 		onResponse, scopedRemedies, &services.Remedies)
 	if err != nil {
@@ -3,15 +3,19 @@
 package runner_test
 
 import (
+	"errors"
+	"lunar/engine/actions"
 	"lunar/engine/config"
 	"lunar/engine/messages"
 	"lunar/engine/runner"
 	"lunar/engine/services"
+	"lunar/engine/services/remedies"
 	sharedActions "lunar/shared-model/actions"
 	sharedConfig "lunar/shared-model/config"
 	"lunar/toolkit-core/clock"
 	"lunar/toolkit-core/urltree"
 	"testing"
+	"time"
 
 	spoe "github.com/TheLunarCompany/haproxy-spoe-go"
 	"github.com/goccy/go-json"
@@ -80,6 +84,7 @@ func TestGivenOnRequestAndNoMatchingPoliciesASingleEmptyActionIsReturned(
 		&policiesAccessor.PoliciesData.Config,
 		services,
 		diagnosisWorker,
+		nil,
 	)
 
 	assert.Nil(t, err)
@@ -138,6 +143,7 @@ func TestGivenOnRequestAndGlobalFixedResponseRemedyWithoutHeaderASingleEmptyActi
 		&policiesAccessor.PoliciesData.Config,
 		services,
 		diagnosisWorker,
+		nil,
 	)
 
 	assert.Nil(t, err)
@@ -199,6 +205,7 @@ func TestGivenOnRequestAndGlobalFixedResponseRemedyWithHeaderEarlyResponseAction
 		&policiesAccessor.PoliciesData.Config,
 		services,
 		diagnosisWorker,
+		nil,
 	)
 	assert.Nil(t, err)
 
@@ -260,6 +267,7 @@ func TestGivenOnRequestAndAMatchingFixedResponseRemedyWithHeaderEarlyResponseAct
 		&policiesAccessor.PoliciesData.Config,
 		services,
 		diagnosisWorker,
+		nil,
 	)
 	assert.Nil(t, err)
 
@@ -267,6 +275,105 @@ func TestGivenOnRequestAndAMatchingFixedResponseRemedyWithHeaderEarlyResponseAct
 	assert.Equal(t, wantActions, actions)
 }
 
+func TestGivenOnRequestWithBaseActionAndNoMatchingPoliciesBaseActionIsReturned(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	onRequest := messages.OnRequest{
+		ID:         "1234-5678-9012-3456",
+		SequenceID: "1234-5678-9012-3456",
+		Method:     "GET",
+		Scheme:     "http",
+		URL:        "twitter.com/post/1234",
+		Path:       "/post/1234",
+		Query:      "",
+		Headers:    map[string]string{"Host": "twitter.com"},
+		Body:       "",
+		Time:       clock.Now(),
+	}
+	policyTree := fixedRemedyEndpointPolicyTree()
+	globalPolicies := globalPolicies()
+	accounts := accounts()
+	mockWriter := newMockWriter()
+	exporterConfig := sharedConfig.Exporters{}
+	services, _ := services.Initialize(
+		mockWriter,
+		proxyTimeout,
+		exporterConfig,
+	)
+	diagnosisWorker := runner.NewDiagnosisWorker()
+	policiesConfig := sharedConfig.PoliciesConfig{
+		Global:   *globalPolicies,
+		Accounts: accounts,
+	}
+
+	spoeActions, err := runner.DispatchOnRequest(
+		onRequest,
+		policyTree,
+		&policiesConfig,
+		services,
+		diagnosisWorker,
+		traceBaseAction(),
+	)
+
+	assert.Nil(t, err)
+	wantedActions := append(
+		traceBaseAction().ReqToSpoeActions(),
+		requestActiveRemediesAction,
+	)
+	assert.Equal(t, wantedActions, spoeActions)
+}
+
+func TestGivenOnRequestWithBaseActionAndFixedResponseRemedyEarlyResponseActionsAreReturned(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	onRequest := messages.OnRequest{
+		ID:         "1234-5678-9012-3456",
+		SequenceID: "1234-5678-9012-3456",
+		Method:     "GET",
+		Scheme:     "http",
+		URL:        "twitter.com/user/1234/messages",
+		Path:       "/user/1234/messages",
+		Query:      "",
+		Headers: map[string]string{
+			"Host":           "twitter.com",
+			"Early-Response": "true",
+		},
+		Body: "",
+		Time: clock.Now(),
+	}
+	policyTree := fixedRemedyEndpointPolicyTree()
+	globalPolicies := globalPolicies()
+	accounts := accounts()
+	mockWriter := newMockWriter()
+	exporterConfig := sharedConfig.Exporters{}
+	services, _ := services.Initialize(
+		mockWriter,
+		proxyTimeout,
+		exporterConfig,
+	)
+	diagnosisWorker := runner.NewDiagnosisWorker()
+	policiesConfig := sharedConfig.PoliciesConfig{
+		Global:   *globalPolicies,
+		Accounts: accounts,
+	}
+
+	spoeActions, err := runner.DispatchOnRequest(
+		onRequest,
+		policyTree,
+		&policiesConfig,
+		services,
+		diagnosisWorker,
+		traceBaseAction(),
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, fixedEarlyResponseActions(), spoeActions)
+}
+
 func TestGivenOnResponseASingleNilErrorIsNil(t *testing.T) {
 	t.Parallel()
 	clock := clock.NewMockClock()
@@ -378,11 +485,453 @@ func TestGivenMultipleGlobalRemediesWhenOnRequestIsCalledItReturnsOnlyEnabledRem
 		&policiesAccessor.PoliciesData.Config,
 		services,
 		diagnosisWorker,
+		nil,
 	)
 	assert.Nil(t, err)
 	assert.Equal(t, fixedEarlyResponseActions(), actions)
 }
 
+func TestGivenWebSocketUpgradeTheHandshakeIsThrottledButNotItsStream(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	onRequest := messages.OnRequest{
+		ID:         "1234-5678-9012-3456",
+		SequenceID: "1234-5678-9012-3456",
+		Method:     "GET",
+		Scheme:     "http",
+		URL:        "twitter.com/stream",
+		Path:       "/stream",
+		Query:      "",
+		Headers: map[string]string{
+			"Host":       "twitter.com",
+			"Connection": "Upgrade",
+			"Upgrade":    "websocket",
+		},
+		Body: "",
+		Time: clock.Now(),
+	}
+	policyTree := fixedRemedyEndpointPolicyTree()
+	globalPolicies := globalPoliciesWithThrottlingAndBodyRewrite()
+	services, _ := services.Initialize(
+		newMockWriter(),
+		proxyTimeout,
+		sharedConfig.Exporters{},
+	)
+	diagnosisWorker := runner.NewDiagnosisWorker()
+	policiesAccessor := config.SimplePolicyAccessor{
+		PoliciesData: &config.PoliciesData{
+			Config: sharedConfig.PoliciesConfig{
+				Global: *globalPolicies,
+			},
+			EndpointPolicyTree: *policyTree,
+		},
+	}
+	diagnosisWorker.Run(
+		&policiesAccessor,
+		&services.Diagnosis,
+		&services.Exporters,
+	)
+	dispatchOnRequest := func() []spoe.Action {
+		actions, err := runner.DispatchOnRequest(onRequest, policyTree,
+			&policiesAccessor.PoliciesData.Config, services, diagnosisWorker, nil)
+		assert.Nil(t, err)
+		return actions
+	}
+	dispatchOnResponse := func(onResponse messages.OnResponse) []spoe.Action {
+		actions, err := runner.DispatchOnResponse(onResponse, policyTree,
+			globalPolicies, services, diagnosisWorker)
+		assert.Nil(t, err)
+		return actions
+	}
+	onResponse := func(status int, headers map[string]string) messages.OnResponse {
+		return messages.OnResponse{
+			ID:         onRequest.ID,
+			SequenceID: onRequest.SequenceID,
+			Method:     onRequest.Method,
+			URL:        onRequest.URL,
+			Status:     status,
+			Headers:    headers,
+			Time:       clock.Now(),
+		}
+	}
+
+	// The handshake is throttled like any other request
+	assert.Equal(t, []spoe.Action{requestActiveRemediesAction}, dispatchOnRequest())
+	assert.Contains(t, dispatchOnRequest(), spoe.ActionSetVar{
+		Name:  "status_code",
+		Scope: spoe.VarScopeTransaction,
+		Value: 429,
+	})
+
+	// The upgraded connection's stream is not subjected to response remedies
+	upgrade := onResponse(101, map[string]string{
+		"Connection": "Upgrade",
+		"Upgrade":    "WebSocket",
+	})
+	assert.Equal(t, []spoe.Action{responseActiveRemediesAction},
+		dispatchOnResponse(upgrade))
+}
+
+func TestResponseBodyRemediesSkipRequestsAskingForAWebSocketOnBothPhases(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	policyTree := fixedRemedyEndpointPolicyTree()
+	globalPolicies := globalPoliciesWithThrottlingAndBodyRewrite()
+	// without throttling, so no request is responded to early
+	globalPolicies.Remedies = globalPolicies.Remedies[1:]
+	services, _ := services.Initialize(
+		newMockWriter(),
+		proxyTimeout,
+		sharedConfig.Exporters{},
+	)
+	diagnosisWorker := runner.NewDiagnosisWorker()
+	policiesConfig := sharedConfig.PoliciesConfig{Global: *globalPolicies}
+	transaction := func(id string, requestHeaders map[string]string, status int) []spoe.Action {
+		_, err := runner.DispatchOnRequest(messages.OnRequest{
+			ID:         id,
+			SequenceID: id,
+			Method:     "GET",
+			Scheme:     "http",
+			URL:        "twitter.com/stream",
+			Path:       "/stream",
+			Headers:    requestHeaders,
+			Time:       clock.Now(),
+		}, policyTree, &policiesConfig, services, diagnosisWorker, nil)
+		assert.Nil(t, err)
+		actions, err := runner.DispatchOnResponse(messages.OnResponse{
+			ID:         id,
+			SequenceID: id,
+			Method:     "GET",
+			URL:        "twitter.com/stream",
+			Status:     status,
+			Headers:    map[string]string{},
+			Time:       clock.Now(),
+		}, policyTree, globalPolicies, services, diagnosisWorker)
+		assert.Nil(t, err)
+		return actions
+	}
+	upgradeHeaders := map[string]string{
+		"Host":       "twitter.com",
+		"Connection": "Upgrade",
+		"Upgrade":    "websocket",
+	}
+	rewritten := spoe.ActionSetVar{
+		Name:  "response_body",
+		Scope: spoe.VarScopeResponse,
+		Value: []byte("rewritten"),
+	}
+
+	// Skipped on the response of a request asking to upgrade,
+	// even if the upgrade was refused
+	assert.NotContains(t, transaction("upgrade", upgradeHeaders, 101), rewritten)
+	assert.NotContains(t, transaction("refused", upgradeHeaders, 200), rewritten)
+	// While the responses of requests which did not ask to upgrade are rewritten
+	assert.Contains(t,
+		transaction("plain", map[string]string{"Host": "twitter.com"}, 200), rewritten)
+}
+
+func TestRemediesRunOnTheResponseOfTheRequestsTheyRanOn(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	policyTree := fixedRemedyEndpointPolicyTree()
+	globalPolicies := &sharedConfig.Global{
+		Remedies: []sharedConfig.Remedy{
+			{
+				Name:      "rewrite",
+				Enabled:   true,
+				ApplyWhen: "upstream_healthy",
+				Config: sharedConfig.RemedyConfig{
+					ResponseBodyRewrite: &sharedConfig.ResponseBodyRewriteConfig{
+						StatusCode: []sharedConfig.Range[int]{{From: 100, To: 299}},
+						Body:       "rewritten",
+					},
+				},
+			},
+		},
+		Diagnosis: []sharedConfig.Diagnosis{},
+	}
+	services, _ := services.Initialize(
+		newMockWriter(),
+		proxyTimeout,
+		sharedConfig.Exporters{},
+	)
+	diagnosisWorker := runner.NewDiagnosisWorker()
+	policiesConfig := sharedConfig.PoliciesConfig{Global: *globalPolicies}
+	onRequest := func(id string) messages.OnRequest {
+		return messages.OnRequest{
+			ID:         id,
+			SequenceID: id,
+			Method:     "GET",
+			Scheme:     "http",
+			URL:        "twitter.com/stream",
+			Path:       "/stream",
+			Headers:    map[string]string{"Host": "twitter.com"},
+			Time:       clock.Now(),
+		}
+	}
+	dispatchOnResponse := func(id string) []spoe.Action {
+		actions, err := runner.DispatchOnResponse(messages.OnResponse{
+			ID:         id,
+			SequenceID: id,
+			Method:     "GET",
+			URL:        "twitter.com/stream",
+			Status:     200,
+			Headers:    map[string]string{},
+			Time:       clock.Now(),
+		}, policyTree, globalPolicies, services, diagnosisWorker)
+		assert.Nil(t, err)
+		return actions
+	}
+	rewritten := spoe.ActionSetVar{
+		Name:  "response_body",
+		Scope: spoe.VarScopeResponse,
+		Value: []byte("rewritten"),
+	}
+	observeResponses := func(status int) {
+		for i := 0; i < 10; i++ {
+			services.Remedies.UpstreamHealth.Observe(messages.OnResponse{
+				URL:    "twitter.com/stream",
+				Status: status,
+			})
+		}
+	}
+	appliesWhenHealthy := func() bool {
+		return services.Remedies.UpstreamHealth.Applies(
+			config.ScopedRemedy{Remedy: &globalPolicies.Remedies[0]}, //nolint:exhaustruct
+			"twitter.com/stream")
+	}
+
+	observeResponses(200)
+	assert.Eventually(t, appliesWhenHealthy, time.Second, time.Millisecond)
+	_, err := runner.DispatchOnRequest(onRequest("healthy"), policyTree,
+		&policiesConfig, services, diagnosisWorker, nil)
+	assert.Nil(t, err)
+
+	observeResponses(502)
+	assert.Eventually(t, func() bool { return !appliesWhenHealthy() },
+		time.Second, time.Millisecond)
+	_, err = runner.DispatchOnRequest(onRequest("degraded"), policyTree,
+		&policiesConfig, services, diagnosisWorker, nil)
+	assert.Nil(t, err)
+
+	// The remedy ran on the request while the upstream was healthy,
+	// so it runs on its response even though the upstream degraded since
+	assert.Contains(t, dispatchOnResponse("healthy"), rewritten)
+	// While requests it did not run on do not have their response rewritten
+	assert.NotContains(t, dispatchOnResponse("degraded"), rewritten)
+}
+
+func TestRemediesDisabledByTheirCircuitRunOnTheResponseOfRequestsTheyRanOn(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	policyTree := fixedRemedyEndpointPolicyTree()
+	globalPolicies := globalPoliciesWithThrottlingAndBodyRewrite()
+	// without throttling, so no request is responded to early
+	globalPolicies.Remedies = globalPolicies.Remedies[1:]
+	services, _ := services.Initialize(
+		newMockWriter(),
+		proxyTimeout,
+		sharedConfig.Exporters{},
+	)
+	diagnosisWorker := runner.NewDiagnosisWorker()
+	policiesConfig := sharedConfig.PoliciesConfig{Global: *globalPolicies}
+	dispatchOnRequest := func(id string) {
+		_, err := runner.DispatchOnRequest(messages.OnRequest{
+			ID:         id,
+			SequenceID: id,
+			Method:     "GET",
+			Scheme:     "http",
+			URL:        "twitter.com/stream",
+			Path:       "/stream",
+			Headers:    map[string]string{"Host": "twitter.com"},
+			Time:       clock.Now(),
+		}, policyTree, &policiesConfig, services, diagnosisWorker, nil)
+		assert.Nil(t, err)
+	}
+	dispatchOnResponse := func(id string) []spoe.Action {
+		actions, err := runner.DispatchOnResponse(messages.OnResponse{
+			ID:         id,
+			SequenceID: id,
+			Method:     "GET",
+			URL:        "twitter.com/stream",
+			Status:     200,
+			Headers:    map[string]string{},
+			Time:       clock.Now(),
+		}, policyTree, globalPolicies, services, diagnosisWorker)
+		assert.Nil(t, err)
+		return actions
+	}
+	rewritten := spoe.ActionSetVar{
+		Name:  "response_body",
+		Scope: spoe.VarScopeResponse,
+		Value: []byte("rewritten"),
+	}
+	rewrite := config.ScopedRemedy{Remedy: &globalPolicies.Remedies[0]} //nolint:exhaustruct
+
+	dispatchOnRequest("before-disabled")
+	for i := 0; i < remedies.DefaultRemedyCircuitConsecutiveErrors; i++ {
+		services.Remedies.Circuit.Record(rewrite, errors.New("remedy failed"))
+	}
+	assert.False(t, services.Remedies.Circuit.Allows(rewrite))
+	dispatchOnRequest("while-disabled")
+
+	// The remedy ran on the request before it was disabled,
+	// so it still runs on its response
+	assert.Contains(t, dispatchOnResponse("before-disabled"), rewritten)
+	// While requests it was disabled for skip it on their response too
+	assert.NotContains(t, dispatchOnResponse("while-disabled"), rewritten)
+}
+
+func TestRemediesReleaseTheSlotsOfRequestsWhoseDeadlineExceeded(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	policyTree := fixedRemedyEndpointPolicyTree()
+	globalPolicies := &sharedConfig.Global{
+		Remedies: []sharedConfig.Remedy{
+			{
+				Name:    "concurrency",
+				Enabled: true,
+				Config: sharedConfig.RemedyConfig{
+					ConcurrencyBasedThrottling: &sharedConfig.ConcurrencyBasedThrottlingConfig{
+						MaxConcurrentRequests: 1,
+						ResponseStatusCode:    429,
+					},
+				},
+			},
+		},
+		Diagnosis: []sharedConfig.Diagnosis{},
+	}
+	services, _ := services.Initialize(
+		newMockWriter(),
+		proxyTimeout,
+		sharedConfig.Exporters{},
+	)
+	diagnosisWorker := runner.NewDiagnosisWorker()
+	policiesConfig := sharedConfig.PoliciesConfig{Global: *globalPolicies}
+	onRequest := func(id string) messages.OnRequest {
+		return messages.OnRequest{
+			ID:         id,
+			SequenceID: id,
+			Method:     "GET",
+			Scheme:     "http",
+			URL:        "twitter.com/stream",
+			Path:       "/stream",
+			Headers:    map[string]string{"Host": "twitter.com"},
+			Time:       clock.Now(),
+			Deadline: messages.RequestDeadline{
+				At:                 clock.Now(),
+				ExceededStatusCode: 504,
+			},
+		}
+	}
+	throttled := spoe.ActionSetVar{
+		Name:  "status_code",
+		Scope: spoe.VarScopeTransaction,
+		Value: 429,
+	}
+
+	actions, err := runner.DispatchOnRequest(onRequest("exceeded"), policyTree,
+		&policiesConfig, services, diagnosisWorker, nil)
+	assert.Nil(t, err)
+	assert.NotContains(t, actions, throttled)
+
+	// The request took the only slot, but is responded to without reaching
+	// the upstream, so no response of it is ever handled
+	runner.DispatchOnDeadlineExceeded(onRequest("exceeded"), policyTree,
+		globalPolicies, services, diagnosisWorker)
+
+	actions, err = runner.DispatchOnRequest(onRequest("next"), policyTree,
+		&policiesConfig, services, diagnosisWorker, nil)
+	assert.Nil(t, err)
+	assert.NotContains(t, actions, throttled)
+}
+
+func TestMaskedQueryIsKeptOnRequestsGeneratedByOAuth(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	policyTree := fixedRemedyEndpointPolicyTree()
+	masking := sharedConfig.Remedy{
+		Name:    "masking",
+		Enabled: true,
+		Config: sharedConfig.RemedyConfig{
+			QueryParamMasking: &sharedConfig.QueryParamMaskingConfig{
+				Parameters: []sharedConfig.QueryParamMasking{
+					{Name: "api_key", Action: "strip"},
+				},
+			},
+		},
+	}
+	oAuth := sharedConfig.Remedy{
+		Name:    "oauth",
+		Enabled: true,
+		Config: sharedConfig.RemedyConfig{
+			Authentication: &sharedConfig.AuthConfig{Account: "oauth"},
+		},
+	}
+	accounts := map[sharedConfig.AccountID]sharedConfig.Account{
+		"oauth": {Authentication: sharedConfig.Authentication{
+			OAuth: &sharedConfig.OAuth{Tokens: []sharedConfig.Body{
+				{Name: "client_secret", Value: "secret"},
+			}},
+			AuthType: sharedConfig.AuthOAuth,
+		}},
+	}
+	maskedQuery := spoe.ActionSetVar{
+		Name:  "request_query",
+		Scope: spoe.VarScopeRequest,
+		Value: "page=2",
+	}
+
+	// Regardless of which of the remedies runs first
+	for _, remedies := range [][]sharedConfig.Remedy{
+		{masking, oAuth},
+		{oAuth, masking},
+	} {
+		services, _ := services.Initialize(
+			newMockWriter(),
+			proxyTimeout,
+			sharedConfig.Exporters{},
+		)
+		policiesConfig := sharedConfig.PoliciesConfig{
+			Global:   sharedConfig.Global{Remedies: remedies},
+			Accounts: accounts,
+		}
+		actions, err := runner.DispatchOnRequest(messages.OnRequest{
+			ID:         "1234-5678-9012-3456",
+			SequenceID: "1234-5678-9012-3456",
+			Method:     "POST",
+			Scheme:     "http",
+			URL:        "twitter.com/oauth",
+			Path:       "/oauth",
+			Query:      "api_key=leaked&page=2",
+			Headers:    map[string]string{"Host": "twitter.com"},
+			Body:       "{}",
+			Time:       clock.Now(),
+		}, policyTree, &policiesConfig, services, runner.NewDiagnosisWorker(), nil)
+		assert.Nil(t, err)
+		assert.Contains(t, actions, spoe.ActionSetVar{
+			Name:  "generate_request",
+			Scope: spoe.VarScopeRequest,
+			Value: true,
+		}, remedies[0].Name)
+		assert.Contains(t, actions, maskedQuery, remedies[0].Name)
+	}
+}
+
+func traceBaseAction() *actions.ModifyRequestAction {
+	return &actions.ModifyRequestAction{
+		HeadersToSet: map[string]string{
+			"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+	}
+}
+
 func fixedEarlyResponseActions() []spoe.Action {
 	requestActiveRemedies := map[sharedConfig.RemedyType][]sharedActions.RemedyReqRunResult{
 		sharedConfig.RemedyFixedResponse: {
@@ -478,6 +1027,36 @@ func globalPoliciesWithMultipleRemedies() *sharedConfig.Global {
 	return globalPolicies
 }
 
+func globalPoliciesWithThrottlingAndBodyRewrite() *sharedConfig.Global {
+	globalPolicies := &sharedConfig.Global{
+		Remedies: []sharedConfig.Remedy{
+			{
+				Name:    "throttling",
+				Enabled: true,
+				Config: sharedConfig.RemedyConfig{
+					StrategyBasedThrottling: &sharedConfig.StrategyBasedThrottlingConfig{
+						AllowedRequestCount: 1,
+						WindowSizeInSeconds: 3600,
+						ResponseStatusCode:  429,
+					},
+				},
+			},
+			{
+				Name:    "rewrite",
+				Enabled: true,
+				Config: sharedConfig.RemedyConfig{
+					ResponseBodyRewrite: &sharedConfig.ResponseBodyRewriteConfig{
+						StatusCode: []sharedConfig.Range[int]{{From: 100, To: 299}},
+						Body:       "rewritten",
+					},
+				},
+			},
+		},
+		Diagnosis: []sharedConfig.Diagnosis{},
+	}
+	return globalPolicies
+}
+
 func globalPolicies() *sharedConfig.Global {
 	globalPolicies := &sharedConfig.Global{
 		Remedies:  []sharedConfig.Remedy{},
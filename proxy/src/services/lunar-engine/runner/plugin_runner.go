@@ -7,8 +7,11 @@ import (
 	"lunar/engine/messages"
 	"lunar/engine/services"
 	"lunar/engine/services/diagnoses"
+	"lunar/engine/utils/bodybuffer"
+	"lunar/engine/utils/writers"
 	sharedActions "lunar/shared-model/actions"
 	sharedConfig "lunar/shared-model/config"
+	"net/http"
 
 	"github.com/rs/zerolog/log"
 )
@@ -18,9 +21,24 @@ const (
 		"Unknown or undefined remedy type: %v"
 )
 
+// responseBodyRemedies act on the body of responses, so they do not apply to
+// a request upgrading its connection to a WebSocket, whose response has no
+// body but a stream they would break. They are skipped on both phases, so
+// they hold no state for a response they do not run on. Other remedies still
+// apply, so the handshake is handled like any other request (e.g. releasing
+// the slot it was allowed by).
+var responseBodyRemedies = map[sharedConfig.RemedyType]bool{
+	sharedConfig.RemedyCaching:                 true,
+	sharedConfig.RemedyResponseBasedThrottling: true,
+	sharedConfig.RemedyRetry:                   true,
+	sharedConfig.RemedyResponseBodyRewrite:     true,
+}
+
 type runResult[A any, R any] struct {
 	action         A
 	activeRemedies map[sharedConfig.RemedyType][]R
+	// ranRemedies are the remedies which ran, and so run on the response
+	ranRemedies []config.ScopedRemedy
 }
 
 type (
@@ -33,17 +51,36 @@ func runOnRequest(
 	remedies []config.ScopedRemedy,
 	services *services.RemedyPlugins,
 	accounts map[sharedConfig.AccountID]sharedConfig.Account,
+	baseAction actions.ReqLunarAction,
 ) (requestRunResult, error) {
 	var prioritizedAction actions.ReqLunarAction = &actions.NoOpAction{}
+	if baseAction != nil {
+		prioritizedAction = baseAction
+	}
 	activeRemedies := map[sharedConfig.RemedyType][]sharedActions.RemedyReqRunResult{}
+	ranRemedies := []config.ScopedRemedy{}
+	isWebSocketUpgrade := args.IsWebSocketUpgrade()
 	for _, remedy := range remedies {
+		if isWebSocketUpgrade && responseBodyRemedies[remedy.Remedy.Type()] {
+			log.Trace().Str("remedy", remedy.Remedy.Name).
+				Msgf("Skipping remedy on WebSocket upgrade of %v", args.ID)
+			continue
+		}
+		if !services.UpstreamHealth.Applies(remedy, args.URL) ||
+			!services.Circuit.Allows(remedy) {
+			continue
+		}
 		action, err := remedyOnRequest(args, remedy, accounts, services)
+		services.Circuit.Record(remedy, err)
+		ranRemedies = append(ranRemedies, remedy)
 		if err != nil {
 			return requestRunResult{
 				action:         nil,
 				activeRemedies: map[sharedConfig.RemedyType][]sharedActions.RemedyReqRunResult{},
+				ranRemedies:    ranRemedies,
 			}, err
 		}
+		action = services.Warmup.OnRequest(remedy, action)
 
 		if action.ReqRunResult() != sharedActions.ReqNoOp {
 			activeRemedies[remedy.Remedy.Type()] = append(
@@ -57,9 +94,12 @@ func runOnRequest(
 	return requestRunResult{
 		action:         prioritizedAction,
 		activeRemedies: activeRemedies,
+		ranRemedies:    ranRemedies,
 	}, nil
 }
 
+// runOnResponse runs the given remedies, which are the ones which ran on the
+// request of the transaction, so they are not gated again
 func runOnResponse(
 	args messages.OnResponse,
 	remedies []config.ScopedRemedy,
@@ -69,12 +109,15 @@ func runOnResponse(
 	activeRemedies := map[sharedConfig.RemedyType][]sharedActions.RemedyRespRunResult{}
 	for _, remedy := range remedies {
 		action, err := remedyOnResponse(args, remedy, services)
+		services.Circuit.Record(remedy, err)
 		if err != nil {
 			return responseRunResult{
 				action:         nil,
 				activeRemedies: map[sharedConfig.RemedyType][]sharedActions.RemedyRespRunResult{},
+				ranRemedies:    nil,
 			}, err
 		}
+		action = services.Warmup.OnResponse(remedy, action)
 
 		if action.RespRunResult() != sharedActions.RespNoOp {
 			activeRemedies[remedy.Remedy.Type()] = append(
@@ -90,21 +133,49 @@ func runOnResponse(
 	return responseRunResult{
 		action:         prioritizedAction,
 		activeRemedies: activeRemedies,
+		ranRemedies:    nil,
 	}, nil
 }
 
+// applicableRemedies are the remedies which apply to the transaction,
+// for responses whose request ran no remedies of this engine
+func applicableRemedies(
+	remedies []config.ScopedRemedy,
+	onResponse messages.OnResponse,
+	services *services.RemedyPlugins,
+) []config.ScopedRemedy {
+	applicable := []config.ScopedRemedy{}
+	isWebSocketUpgrade := onResponse.IsWebSocketUpgrade()
+	for _, remedy := range remedies {
+		if isWebSocketUpgrade && responseBodyRemedies[remedy.Remedy.Type()] {
+			continue
+		}
+		if services.UpstreamHealth.Applies(remedy, onResponse.URL) &&
+			services.Circuit.Allows(remedy) {
+			applicable = append(applicable, remedy)
+		}
+	}
+	return applicable
+}
+
 func runOnTransaction(
 	onRequest messages.OnRequest,
 	onResponse messages.OnResponse,
+	responseBody *bodybuffer.Buffer,
 	diagnoses []*config.ScopedDiagnosis,
 	services *services.DiagnosisPlugins,
 	exporters *services.Exporters,
+	exportersConfig sharedConfig.Exporters,
 	policyTree *config.EndpointPolicyTree,
 ) {
 	for _, diagnosis := range diagnoses {
+		if !exporterRecords(exportersConfig, diagnosis, onRequest, onResponse) {
+			continue
+		}
 		output := diagnosisOnTransaction(
 			onRequest,
 			onResponse,
+			responseBody,
 			diagnosis,
 			services,
 			policyTree,
@@ -114,10 +185,29 @@ func runOnTransaction(
 				Msg("could not obtain diagnosis output, will not export anything")
 			return
 		}
-		exportDiagnosisOutput(output, diagnosis, exporters)
+		exportDiagnosisOutput(output, diagnosis, exporters,
+			transactionSeverity(onResponse))
 	}
 }
 
+// exporterRecords is whether the exporter of the diagnosis records the
+// transaction by its rules, evaluated before the diagnosis processes it
+func exporterRecords(
+	exportersConfig sharedConfig.Exporters,
+	diagnosis *config.ScopedDiagnosis,
+	onRequest messages.OnRequest,
+	onResponse messages.OnResponse,
+) bool {
+	exporterType := diagnosis.Diagnosis.ExporterType()
+	rules, found := exportersConfig.RulesOf(exporterType)
+	if !found || rules.Records(onRequest.ID, onRequest.Method, onResponse.Status, &onRequest) {
+		return true
+	}
+	log.Trace().Msgf("Exporter %v does not record transaction %v of diagnosis %v",
+		exporterType.Name(), onRequest.ID, diagnosis.Diagnosis.Name)
+	return false
+}
+
 func remedyOnRequest(
 	args messages.OnRequest,
 	scopedRemedy config.ScopedRemedy,
@@ -173,6 +263,21 @@ func remedyOnRequest(
 			accounts,
 		)
 
+	case sharedConfig.RemedyResponseBodyRewrite:
+		return services.ResponseBodyRewritePlugin.OnRequest(
+			args,
+			remedy.Config.ResponseBodyRewrite,
+		)
+
+	case sharedConfig.RemedyDecisionHook:
+		return services.DecisionHookPlugin.OnRequest(args, scopedRemedy)
+
+	case sharedConfig.RemedyQueryParamMasking:
+		return services.QueryParamMaskingPlugin.OnRequest(
+			args,
+			remedy.Config.QueryParamMasking,
+		)
+
 	case sharedConfig.RemedyUndefined:
 		return nil,
 			fmt.Errorf(unknownRemedyError, remedy, remedyType)
@@ -228,6 +333,18 @@ func remedyOnResponse(
 
 	case sharedConfig.RemedyAuth:
 		return services.AuthPlugin.OnResponse()
+	case sharedConfig.RemedyResponseBodyRewrite:
+		return services.ResponseBodyRewritePlugin.OnResponse(
+			args,
+			remedy.Config.ResponseBodyRewrite,
+		)
+	case sharedConfig.RemedyDecisionHook:
+		return services.DecisionHookPlugin.OnResponse()
+	case sharedConfig.RemedyQueryParamMasking:
+		return services.QueryParamMaskingPlugin.OnResponse(
+			args,
+			remedy.Config.QueryParamMasking,
+		)
 	case sharedConfig.RemedyUndefined:
 		return nil, fmt.Errorf(unknownRemedyError, remedy, remedyType)
 	default:
@@ -238,6 +355,7 @@ func remedyOnResponse(
 func diagnosisOnTransaction(
 	onRequest messages.OnRequest,
 	onResponse messages.OnResponse,
+	responseBody *bodybuffer.Buffer,
 	scopedDiagnosis *config.ScopedDiagnosis,
 	diagnosisPlugins *services.DiagnosisPlugins,
 	policyTree *config.EndpointPolicyTree,
@@ -249,6 +367,7 @@ func diagnosisOnTransaction(
 		diagnosisOutput, err = diagnosisPlugins.HARGeneratorPlugin.OnTransaction(
 			onRequest,
 			onResponse,
+			responseBody,
 			policyTree,
 			scopedDiagnosis,
 		)
@@ -261,6 +380,7 @@ func diagnosisOnTransaction(
 		diagnosisOutput, err = diagnosisPlugins.MetricsCollector.OnTransaction(
 			onRequest,
 			onResponse,
+			responseBody,
 			policyTree,
 			scopedDiagnosis,
 		)
@@ -273,6 +393,7 @@ func diagnosisOnTransaction(
 		diagnosisOutput, err = diagnosisPlugins.Void.OnTransaction(
 			onRequest,
 			onResponse,
+			responseBody,
 			policyTree,
 			scopedDiagnosis,
 		)
@@ -293,12 +414,13 @@ func exportDiagnosisOutput(
 	diagnosisOutput *diagnoses.DiagnosisOutput,
 	diagnosis *config.ScopedDiagnosis,
 	exporter *services.Exporters,
+	severity writers.Severity,
 ) {
 	var err error
 	exporterType := diagnosis.Diagnosis.ExporterType()
 	switch diagnosis.Diagnosis.ExporterKind() {
 	case sharedConfig.ExporterKindRawData:
-		err = exporter.Content.Export(*diagnosisOutput, exporterType)
+		err = exporter.Content.Export(*diagnosisOutput, exporterType, severity)
 	case sharedConfig.ExporterKindMetrics:
 		err = exporter.Prometheus.Export(*diagnosisOutput)
 	case sharedConfig.ExporterKindUndefined:
@@ -311,3 +433,15 @@ func exportDiagnosisOutput(
 			Msgf("Failed to export output from diagnosis %v", diagnosis.Diagnosis.Name)
 	}
 }
+
+// transactionSeverity is the severity exported transactions are routed by
+func transactionSeverity(onResponse messages.OnResponse) writers.Severity {
+	switch {
+	case onResponse.Status >= http.StatusInternalServerError:
+		return writers.SeverityError
+	case onResponse.Status >= http.StatusBadRequest:
+		return writers.SeverityWarning
+	default:
+		return writers.SeverityInfo
+	}
+}
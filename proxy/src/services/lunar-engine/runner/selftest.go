@@ -0,0 +1,56 @@
+package runner
+
+import (
+	"fmt"
+	"lunar/engine/actions"
+	"lunar/engine/config"
+	"lunar/engine/messages"
+	"lunar/engine/services"
+	sharedConfig "lunar/shared-model/config"
+	"net/http"
+)
+
+// DispatchSelfTest runs a synthetic request through the remedies of its
+// scope, as DispatchOnRequest does, and then through their response
+// remedies as if the upstream responded, since it is never forwarded.
+// Unlike real transactions it is never diagnosed, and a remedy panicking
+// fails it rather than the engine.
+func DispatchSelfTest(
+	onRequest messages.OnRequest,
+	policyTree *config.EndpointPolicyTree,
+	policiesConfig *sharedConfig.PoliciesConfig,
+	remedies *services.RemedyPlugins,
+) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("remedy panicked: %v", recovered)
+		}
+	}()
+
+	requestRemedies := getRemedies(
+		onRequest.Method, onRequest.URL, policyTree, &policiesConfig.Global)
+	reqRunResult, err := runOnRequest(
+		onRequest, requestRemedies, remedies, policiesConfig.Accounts, nil)
+	if err != nil {
+		return fmt.Errorf("request remedies failed: %w", err)
+	}
+
+	onResponse := messages.OnResponse{ //nolint:exhaustruct
+		ID:         onRequest.ID,
+		SequenceID: onRequest.SequenceID,
+		Method:     onRequest.Method,
+		URL:        onRequest.URL,
+		Status:     http.StatusOK,
+		Headers:    map[string]string{},
+		Time:       onRequest.Time,
+	}
+	if earlyResponse, valid := reqRunResult.action.(*actions.EarlyResponseAction); valid {
+		onResponse.Status = earlyResponse.Status
+		onResponse.Headers = earlyResponse.Headers
+		onResponse.Body = earlyResponse.Body
+	}
+	if _, err = runOnResponse(onResponse, reqRunResult.ranRemedies, remedies); err != nil {
+		return fmt.Errorf("response remedies failed: %w", err)
+	}
+	return nil
+}
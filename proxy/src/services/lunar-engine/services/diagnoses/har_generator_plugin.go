@@ -1,10 +1,13 @@
 package diagnoses
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"lunar/engine/config"
 	"lunar/engine/formats/har"
 	"lunar/engine/messages"
+	"lunar/engine/utils/bodybuffer"
 	"lunar/engine/utils/compression"
 	"lunar/engine/utils/obfuscation"
 	sharedConfig "lunar/shared-model/config"
@@ -29,6 +32,10 @@ const (
 	limitationHTTPVersion            string = "HTTP:/1.1"
 	defaultContentEncodingHeaderName        = "Content-Encoding"
 	gzipContentEncoding                     = "gzip"
+	defaultErrorMinStatusCode               = 500
+	defaultErrorMaxBodySize                 = 1 << 20
+	// NDJSONOutputFormat outputs each HAR entry as a standalone JSON line
+	NDJSONOutputFormat = "ndjson"
 )
 
 type HARGeneratorPlugin struct {
@@ -52,12 +59,51 @@ func (plugin *HARGeneratorPlugin) validate(
 	if diagnoseConfig.TransactionMaxSize < 0 {
 		return fmt.Errorf("TransactionMaxSize must be a positive integer")
 	}
+	onError := diagnoseConfig.CaptureBodiesOnError
+	if onError != nil && (onError.MinStatusCode < 0 || onError.MaxBodySize < 0) {
+		return fmt.Errorf("CaptureBodiesOnError sizes must be positive integers")
+	}
 	return nil
 }
 
+// bodyCapture is whether the bodies of a transaction are captured,
+// bodies larger than maxSize bytes (if set) are not captured
+type bodyCapture struct {
+	enabled bool
+	maxSize int
+}
+
+// bodyCaptureFor resolves how bodies are captured given the response status,
+// as capturing them on errors overrides not capturing them otherwise
+func bodyCaptureFor(
+	diagnosisConfig *sharedConfig.HARExporterConfig,
+	status int,
+) bodyCapture {
+	if diagnosisConfig.CaptureBodies == nil || *diagnosisConfig.CaptureBodies {
+		return bodyCapture{enabled: true, maxSize: 0}
+	}
+	onError := diagnosisConfig.CaptureBodiesOnError
+	if onError == nil || !onError.Enabled {
+		return bodyCapture{enabled: false, maxSize: 0}
+	}
+	minStatusCode := onError.MinStatusCode
+	if minStatusCode == 0 {
+		minStatusCode = defaultErrorMinStatusCode
+	}
+	maxSize := onError.MaxBodySize
+	if maxSize == 0 {
+		maxSize = defaultErrorMaxBodySize
+	}
+	return bodyCapture{enabled: status >= minStatusCode, maxSize: maxSize}
+}
+
+// OnTransaction generates the HAR of the transaction. The response body is
+// streamed from the given buffer if it is buffered apart from the response,
+// otherwise it is the body of the response.
 func (plugin *HARGeneratorPlugin) OnTransaction(
 	onRequest messages.OnRequest,
 	onResponse messages.OnResponse,
+	responseBody *bodybuffer.Buffer,
 	policyTree *config.EndpointPolicyTree,
 	scopedDiagnosis *config.ScopedDiagnosis,
 ) (*DiagnosisOutput, error) {
@@ -66,9 +112,10 @@ func (plugin *HARGeneratorPlugin) OnTransaction(
 		return nil, err
 	}
 
-	HARObject, generationErr := plugin.GenerateHAR(
+	HARObject, generationErr := plugin.generateHAR(
 		onRequest,
 		onResponse,
+		responseBody,
 		policyTree,
 		diagnoseConfig,
 	)
@@ -93,8 +140,12 @@ func (plugin *HARGeneratorPlugin) OnTransaction(
 	// take into account error chaining.
 	switch scopedDiagnosis.Diagnosis.ExporterKind() {
 	case sharedConfig.ExporterKindRawData:
-		marshalledRecord, er := json.Marshal(HARObject)
-		err = er
+		var marshalledRecord []byte
+		if diagnoseConfig.OutputFormat == NDJSONOutputFormat {
+			marshalledRecord, err = marshalNDJSON(HARObject)
+		} else {
+			marshalledRecord, err = json.Marshal(HARObject)
+		}
 		diagnosisOutput.RawData = &marshalledRecord
 	case sharedConfig.ExporterKindMetrics, sharedConfig.ExporterKindUndefined:
 		err = fmt.Errorf("unsupported exporter type")
@@ -106,6 +157,20 @@ func (plugin *HARGeneratorPlugin) OnTransaction(
 	return &diagnosisOutput, err
 }
 
+// marshalNDJSON marshals each entry of the HAR as a line of its own,
+// so each line can be parsed as it is written, with no HAR to complete
+func marshalNDJSON(HARObject *har.HAR) ([]byte, error) {
+	lines := make([][]byte, 0, len(HARObject.Log.Entries))
+	for _, entry := range HARObject.Log.Entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return bytes.Join(lines, []byte("\n")), nil
+}
+
 func ensureTransactionSize(HARObject *har.HAR, maxSize int) error {
 	size := 0
 	for _, value := range HARObject.Log.Entries {
@@ -143,7 +208,21 @@ func (plugin *HARGeneratorPlugin) GenerateHAR(
 	policyTree *config.EndpointPolicyTree,
 	diagnosisConfig *sharedConfig.HARExporterConfig,
 ) (*har.HAR, error) {
-	obfuscateConfig := diagnosisConfig.Obfuscate
+	return plugin.generateHAR(request, response, nil, policyTree, diagnosisConfig)
+}
+
+func (plugin *HARGeneratorPlugin) generateHAR(
+	request messages.OnRequest,
+	response messages.OnResponse,
+	responseBody *bodybuffer.Buffer,
+	policyTree *config.EndpointPolicyTree,
+	diagnosisConfig *sharedConfig.HARExporterConfig,
+) (*har.HAR, error) {
+	obfuscateConfig := config.ObfuscateForResponseStatus(
+		diagnosisConfig.Obfuscate,
+		response.Status,
+	)
+	capture := bodyCaptureFor(diagnosisConfig, response.Status)
 	buildRequestHeader := buildHeaderBuilder(
 		config.ShouldObfuscateRequestHeader(obfuscateConfig),
 		plugin.obfuscator.ObfuscateString,
@@ -184,6 +263,7 @@ func (plugin *HARGeneratorPlugin) GenerateHAR(
 		QueryString: query,
 		Body: plugin.extractBody(
 			request.Body,
+			capture,
 			obfuscateConfig.Enabled,
 			obfuscateConfig.Exclusions.RequestBodyPaths,
 			requestContentEncodingValue,
@@ -202,8 +282,10 @@ func (plugin *HARGeneratorPlugin) GenerateHAR(
 		StatusText:  http.StatusText(response.Status),
 		HTTPVersion: limitationHTTPVersion,
 		Headers:     headersResponse,
-		Content: plugin.extractBody(
+		Content: plugin.extractResponseBody(
 			response.Body,
+			responseBody,
+			capture,
 			obfuscateConfig.Enabled,
 			obfuscateConfig.Exclusions.ResponseBodyPaths,
 			responseContentEncodingValue,
@@ -218,6 +300,7 @@ func (plugin *HARGeneratorPlugin) GenerateHAR(
 		Time:            response.Time.Sub(request.Time),
 		Request:         req,
 		Response:        res,
+		ConfigVersion:   request.ConfigVersion,
 	}
 
 	har := &har.HAR{
@@ -381,11 +464,18 @@ func (plugin *HARGeneratorPlugin) extractURL(
 
 func (plugin *HARGeneratorPlugin) extractBody(
 	rawBody string,
+	capture bodyCapture,
 	obfuscationEnabled bool,
 	obfuscationExcludedBodyPath []string,
 	contentEncodingHeaderValue string,
 ) string {
+	if !capture.enabled || exceedsMaxSize(len(rawBody), capture) {
+		return ""
+	}
 	body := ensureDecompressedBody(rawBody, contentEncodingHeaderValue)
+	if exceedsMaxSize(len(body), capture) {
+		return ""
+	}
 
 	if !obfuscationEnabled {
 		return body
@@ -403,6 +493,55 @@ func (plugin *HARGeneratorPlugin) extractBody(
 	return obfuscatedJSON
 }
 
+// extractResponseBody extracts the response body, streaming it from its
+// buffer if it is buffered apart from the response.
+// A buffered body larger than captured is not read at all.
+func (plugin *HARGeneratorPlugin) extractResponseBody(
+	rawBody string,
+	responseBody *bodybuffer.Buffer,
+	capture bodyCapture,
+	obfuscationEnabled bool,
+	obfuscationExcludedBodyPath []string,
+	contentEncodingHeaderValue string,
+) string {
+	if responseBody != nil {
+		if !capture.enabled || exceedsMaxSize(responseBody.Size(), capture) {
+			return ""
+		}
+		var err error
+		if rawBody, err = readBody(responseBody); err != nil {
+			log.Warn().Err(err).Msg("Failed to read buffered response body, not capturing it")
+			return ""
+		}
+	}
+	return plugin.extractBody(
+		rawBody,
+		capture,
+		obfuscationEnabled,
+		obfuscationExcludedBodyPath,
+		contentEncodingHeaderValue,
+	)
+}
+
+func readBody(buffer *bodybuffer.Buffer) (string, error) {
+	reader, err := buffer.Open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	body, err := io.ReadAll(reader)
+	return string(body), err
+}
+
+func exceedsMaxSize(size int, capture bodyCapture) bool {
+	if capture.maxSize == 0 || size <= capture.maxSize {
+		return false
+	}
+	log.Debug().Msgf("Body of %v bytes exceeds the max size of %v, not capturing it",
+		size, capture.maxSize)
+	return true
+}
+
 // This function currently works only for `gzip` content encoding.
 // A more complete implementation is reflected in
 // https://lunar-shots.atlassian.net/browse/MK-412
@@ -6,10 +6,12 @@ import (
 	"lunar/engine/formats/har"
 	"lunar/engine/messages"
 	"lunar/engine/services/diagnoses"
+	"lunar/engine/utils/bodybuffer"
 	"lunar/engine/utils/obfuscation"
 	sharedConfig "lunar/shared-model/config"
 	"lunar/toolkit-core/clock"
 	"lunar/toolkit-core/testutils"
+	"strings"
 	"testing"
 	"time"
 
@@ -454,6 +456,206 @@ func TestGenerateHARWithObfuscationDisabledAndExcludedFieldsDefinedDoesNothing(
 		t, `{"message": "Success"}`, harData.Log.Entries[0].Response.Content)
 }
 
+func TestGenerateHARWithConditionalObfuscation(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	obfuscator := obfuscation.Obfuscator{
+		Hasher: obfuscation.FixedHasher{Value: obfuscatedValue},
+	}
+	plugin := diagnoses.NewHARGeneratorPlugin(clock, obfuscator)
+	tree, err := config.BuildEndpointPolicyTree(
+		[]sharedConfig.EndpointConfig{},
+	)
+	assert.Nil(t, err)
+	// Only successful responses are obfuscated, except for the excluded fields
+	diagnosisConfig := sharedConfig.HARExporterConfig{
+		Obfuscate: sharedConfig.Obfuscate{
+			Enabled: true,
+			Exclusions: sharedConfig.ObfuscationExclusions{
+				RequestBodyPaths: []string{".key"},
+			},
+			Conditions: &sharedConfig.ObfuscationConditions{
+				StatusCode: []sharedConfig.Range[int]{{From: 100, To: 399}},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name                    string
+		status                  int
+		expectedRequestBody     string
+		expectedResponseContent string
+	}{
+		{
+			name:                    "Successful response is obfuscated",
+			status:                  200,
+			expectedRequestBody:     `{"key":"value","secret":"<obfuscated>"}`,
+			expectedResponseContent: `{"message":"<obfuscated>"}`,
+		},
+		{
+			name:                    "Error response is not obfuscated",
+			status:                  500,
+			expectedRequestBody:     `{"key": "value", "secret": "s3cr3t"}`,
+			expectedResponseContent: `{"message": "Internal error"}`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			onRequest := messages.OnRequest{
+				ID:         "test-1",
+				SequenceID: "1",
+				Method:     "GET",
+				Scheme:     "http",
+				URL:        "example.com/api/v1/endpoint",
+				Path:       "api/v1/endpoint",
+				Query:      "param1=value1",
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       `{"key": "value", "secret": "s3cr3t"}`,
+				Time:       time.Now(),
+			}
+			onResponse := messages.OnResponse{
+				ID:         "test-1",
+				SequenceID: "1",
+				Method:     "GET",
+				URL:        "example.com/api/v1/endpoint",
+				Status:     testCase.status,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       `{"message": "` + responseMessage(testCase.status) + `"}`,
+				Time:       time.Now(),
+			}
+
+			harData, err := plugin.GenerateHAR(
+				onRequest,
+				onResponse,
+				tree,
+				&diagnosisConfig,
+			)
+			assert.Nil(t, err)
+
+			entry := harData.Log.Entries[0]
+			assert.Equal(t, testCase.expectedRequestBody, entry.Request.Body)
+			assert.Equal(t, testCase.expectedResponseContent, entry.Response.Content)
+		})
+	}
+}
+
+func responseMessage(status int) string {
+	if status >= 500 {
+		return "Internal error"
+	}
+	return "Success"
+}
+
+func TestGenerateHARCapturesBodiesOnlyOnError(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	obfuscator := obfuscation.Obfuscator{
+		Hasher: obfuscation.FixedHasher{Value: obfuscatedValue},
+	}
+	plugin := diagnoses.NewHARGeneratorPlugin(clock, obfuscator)
+	tree, err := config.BuildEndpointPolicyTree(
+		[]sharedConfig.EndpointConfig{},
+	)
+	assert.Nil(t, err)
+	diagnosisConfig := sharedConfig.HARExporterConfig{
+		Obfuscate: sharedConfig.Obfuscate{
+			Enabled: true,
+			Exclusions: sharedConfig.ObfuscationExclusions{
+				RequestBodyPaths: []string{".key"},
+			},
+		},
+		CaptureBodies: lo.ToPtr(false),
+		CaptureBodiesOnError: &sharedConfig.CaptureBodiesOnError{
+			Enabled:       true,
+			MinStatusCode: 400,
+			MaxBodySize:   64,
+		},
+	}
+
+	testCases := []struct {
+		name                    string
+		status                  int
+		responseBody            string
+		expectedRequestBody     string
+		expectedResponseContent string
+	}{
+		{
+			name:                    "Bodies of successful response are not captured",
+			status:                  200,
+			responseBody:            `{"message": "Success"}`,
+			expectedRequestBody:     "",
+			expectedResponseContent: "",
+		},
+		{
+			name:                    "Bodies of error response are captured obfuscated",
+			status:                  404,
+			responseBody:            `{"message": "Not found"}`,
+			expectedRequestBody:     `{"key":"value","secret":"<obfuscated>"}`,
+			expectedResponseContent: `{"message":"<obfuscated>"}`,
+		},
+		{
+			name:   "Bodies of error response larger than the max size are not captured",
+			status: 500,
+			responseBody: `{"message": "` +
+				strings.Repeat("stack trace ", 10) + `"}`,
+			expectedRequestBody:     `{"key":"value","secret":"<obfuscated>"}`,
+			expectedResponseContent: "",
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			onRequest := messages.OnRequest{
+				ID:         "test-1",
+				SequenceID: "1",
+				Method:     "POST",
+				Scheme:     "http",
+				URL:        "example.com/api/v1/endpoint",
+				Path:       "api/v1/endpoint",
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       `{"key": "value", "secret": "s3cr3t"}`,
+				Time:       time.Now(),
+			}
+			onResponse := messages.OnResponse{
+				ID:         "test-1",
+				SequenceID: "1",
+				Method:     "POST",
+				URL:        "example.com/api/v1/endpoint",
+				Status:     testCase.status,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       testCase.responseBody,
+				Time:       time.Now(),
+			}
+
+			harData, err := plugin.GenerateHAR(
+				onRequest,
+				onResponse,
+				tree,
+				&diagnosisConfig,
+			)
+			assert.Nil(t, err)
+
+			entry := harData.Log.Entries[0]
+			assert.Equal(t, testCase.expectedRequestBody, entry.Request.Body)
+			assert.Equal(t, testCase.expectedResponseContent, entry.Response.Content)
+		})
+	}
+}
+
+func TestObfuscateForResponseStatusIsUnconditionalByDefault(t *testing.T) {
+	t.Parallel()
+	obfuscate := sharedConfig.Obfuscate{Enabled: true}
+
+	for _, status := range []int{200, 404, 500} {
+		assert.True(t, config.ObfuscateForResponseStatus(obfuscate, status).Enabled)
+	}
+}
+
 func TestItDecompressGzipIfResponseContentEncodingHeaderIsExactlyGzip(
 	t *testing.T,
 ) {
@@ -644,3 +846,106 @@ func getHARHeaderValue(
 	}
 	return harHeader.Value, true
 }
+
+func TestHARGeneratorOutputsEachEntryAsAnNDJSONLine(t *testing.T) {
+	t.Parallel()
+	obfuscator := obfuscation.Obfuscator{
+		Hasher: obfuscation.FixedHasher{Value: obfuscatedValue},
+	}
+	plugin := diagnoses.NewHARGeneratorPlugin(clock.NewMockClock(), obfuscator)
+	tree, err := config.BuildEndpointPolicyTree([]sharedConfig.EndpointConfig{})
+	assert.Nil(t, err)
+	scopedDiagnosis := &config.ScopedDiagnosis{ //nolint:exhaustruct
+		Diagnosis: &sharedConfig.Diagnosis{
+			Enabled: true,
+			Name:    "har",
+			Config: sharedConfig.DiagnosisConfig{
+				HARExporter: &sharedConfig.HARExporterConfig{
+					Obfuscate:    sharedConfig.Obfuscate{Enabled: true},
+					OutputFormat: diagnoses.NDJSONOutputFormat,
+				},
+			},
+			Export: "file",
+		},
+	}
+	requestTime := time.Date(2023, 8, 21, 17, 0, 31, 0, time.UTC)
+
+	var lines []string
+	for _, url := range []string{"users.example.com/api/v1", "orders.example.com/api/v1"} {
+		onResponse := buildOnResponse(requestTime.Add(time.Second))
+		onResponse.URL = url
+		output, err := plugin.OnTransaction(
+			buildOnRequest(requestTime, url), onResponse, nil, tree, scopedDiagnosis)
+		assert.Nil(t, err)
+		lines = append(lines, strings.Split(string(*output.RawData), "\n")...)
+	}
+
+	assert.Len(t, lines, 2)
+	for index, line := range lines {
+		var entry har.Entry
+		assert.Nil(t, json.Unmarshal([]byte(line), &entry), line)
+		assert.Equal(t, "GET", entry.Request.Method)
+		assert.Contains(t, entry.Request.URL, []string{"users.", "orders."}[index])
+		// Bodies are obfuscated per entry as they are in a HAR object
+		assert.Equal(t, `{"key":"<obfuscated>"}`, entry.Request.Body)
+
+		var record map[string]json.RawMessage
+		assert.Nil(t, json.Unmarshal([]byte(line), &record))
+		assert.NotContains(t, record, "log")
+	}
+}
+
+func TestHARGeneratorStreamsTheBufferedResponseBody(t *testing.T) {
+	t.Parallel()
+	plugin := diagnoses.NewHARGeneratorPlugin(clock.NewMockClock(), obfuscation.Obfuscator{})
+	tree, err := config.BuildEndpointPolicyTree([]sharedConfig.EndpointConfig{})
+	assert.Nil(t, err)
+	captureBodies := false
+	scopedDiagnosis := func(
+		harConfig *sharedConfig.HARExporterConfig,
+	) *config.ScopedDiagnosis {
+		harConfig.OutputFormat = diagnoses.NDJSONOutputFormat
+		return &config.ScopedDiagnosis{ //nolint:exhaustruct
+			Diagnosis: &sharedConfig.Diagnosis{
+				Enabled: true,
+				Name:    "har",
+				Config:  sharedConfig.DiagnosisConfig{HARExporter: harConfig},
+				Export:  "file",
+			},
+		}
+	}
+	requestTime := time.Date(2023, 8, 21, 17, 0, 31, 0, time.UTC)
+	spiller := bodybuffer.NewSpiller(4, t.TempDir())
+	responseContent := func(
+		status int,
+		body string,
+		harConfig *sharedConfig.HARExporterConfig,
+	) interface{} {
+		responseBody, err := spiller.Buffer(body)
+		assert.Nil(t, err)
+		defer responseBody.Release()
+		assert.True(t, responseBody.IsSpilled())
+		onResponse := buildOnResponse(requestTime.Add(time.Second))
+		onResponse.Status = status
+		output, err := plugin.OnTransaction(buildOnRequest(requestTime, validRequestURL),
+			onResponse, responseBody, tree, scopedDiagnosis(harConfig))
+		assert.Nil(t, err)
+		var entry har.Entry
+		assert.Nil(t, json.Unmarshal(*output.RawData, &entry))
+		return entry.Response.Content
+	}
+
+	assert.Equal(t, `{"key":"value"}`,
+		responseContent(200, `{"key":"value"}`, &sharedConfig.HARExporterConfig{}))
+
+	// Bodies larger than captured are left in their spill file
+	onError := &sharedConfig.HARExporterConfig{ //nolint:exhaustruct
+		CaptureBodies: &captureBodies,
+		CaptureBodiesOnError: &sharedConfig.CaptureBodiesOnError{
+			Enabled:     true,
+			MaxBodySize: 8,
+		},
+	}
+	assert.Equal(t, "", responseContent(500, `{"key":"value"}`, onError))
+	assert.Equal(t, "an error", responseContent(500, "an error", onError))
+}
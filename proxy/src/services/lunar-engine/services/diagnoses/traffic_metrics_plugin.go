@@ -5,6 +5,8 @@ import (
 	"lunar/engine/config"
 	"lunar/engine/messages"
 	"lunar/engine/utils"
+	"lunar/engine/utils/bodybuffer"
+	"lunar/engine/utils/bodysize"
 	sharedConfig "lunar/shared-model/config"
 	"strconv"
 	"strings"
@@ -30,6 +32,15 @@ type MetricsCollectorRecord struct {
 	RequestHeaders  map[string]string `json:"request_headers"`
 	ResponseHeaders map[string]string `json:"response_headers"`
 	Counters        []Counter         `json:"counters"`
+	// The version of the config which handled the transaction
+	ConfigVersion string `json:"config_version"`
+	// SLOs the transaction counts towards, exported along with the metrics
+	SLOs []sharedConfig.SLO `json:"-"`
+	// The estimated sizes of the request and response bodies, which are
+	// never buffered for it, so their source is unknown if not buffered
+	// otherwise and their Content-Length is not trustworthy
+	RequestSize  bodysize.Size `json:"-"`
+	ResponseSize bodysize.Size `json:"-"`
 }
 
 type Counter struct {
@@ -40,6 +51,7 @@ type Counter struct {
 func (plugin *MetricsCollectorPlugin) OnTransaction(
 	onRequest messages.OnRequest,
 	onResponse messages.OnResponse,
+	responseBody *bodybuffer.Buffer,
 	_ *config.EndpointPolicyTree,
 	scopedDiagnosis *config.ScopedDiagnosis,
 ) (*DiagnosisOutput, error) {
@@ -120,6 +132,10 @@ func (plugin *MetricsCollectorPlugin) OnTransaction(
 		RequestHeaders:  requestHeaders,
 		ResponseHeaders: responseHeaders,
 		Counters:        counters,
+		ConfigVersion:   onRequest.ConfigVersion,
+		SLOs:            diagnosisConfig.SLOs,
+		RequestSize:     onRequest.BodySize(bodysize.EstimationContentLength),
+		ResponseSize:    responseBodySize(&onResponse, responseBody),
 	}
 	log.Trace().Msgf("Extracted MetricsCollectorRecord: %+v", record)
 
@@ -139,3 +155,28 @@ func (plugin *MetricsCollectorPlugin) OnTransaction(
 
 	return &diagnosisOutput, nil
 }
+
+// responseBodySize estimates the size of the response body, counting it as
+// it is streamed from its buffer if it is buffered apart from the response
+func responseBodySize(
+	onResponse *messages.OnResponse,
+	responseBody *bodybuffer.Buffer,
+) bodysize.Size {
+	if responseBody == nil {
+		return onResponse.BodySize(bodysize.EstimationContentLength)
+	}
+	if _, trustworthy := bodysize.ContentLength(onResponse); trustworthy {
+		return bodysize.Estimate(onResponse, nil, bodysize.EstimationContentLength,
+			bodysize.DefaultMaxCountedBytes)
+	}
+	reader, err := responseBody.Open()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to read buffered response body, " +
+			"its size is unknown")
+		return bodysize.Estimate(onResponse, nil, bodysize.EstimationContentLength,
+			bodysize.DefaultMaxCountedBytes)
+	}
+	defer reader.Close()
+	return bodysize.Estimate(onResponse, reader, bodysize.EstimationContentLength,
+		bodysize.DefaultMaxCountedBytes)
+}
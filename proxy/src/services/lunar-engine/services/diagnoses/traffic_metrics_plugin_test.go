@@ -1,10 +1,12 @@
 package diagnoses_test
 
 import (
+	"encoding/json"
 	"lunar/engine/config"
 	"lunar/engine/messages"
 	"lunar/engine/services/diagnoses"
 	"lunar/engine/utils"
+	"lunar/engine/utils/bodysize"
 	sharedConfig "lunar/shared-model/config"
 	"testing"
 	"time"
@@ -26,7 +28,7 @@ func TestItReturnsRequestMethodResponseStatusAndTransactionDuration(
 	tree, err := config.BuildEndpointPolicyTree([]sharedConfig.EndpointConfig{})
 	assert.Nil(t, err)
 	policy := buildMetricsCollectorPolicy(utils.ScopeGlobal, false)
-	res, err := plugin.OnTransaction(onRequest, onResponse, tree, &policy)
+	res, err := plugin.OnTransaction(onRequest, onResponse, nil, tree, &policy)
 	assert.Nil(t, err)
 	assert.Equal(t, "GET", res.Metrics.Method)
 	assert.Equal(t, 202, res.Metrics.StatusCode)
@@ -45,7 +47,7 @@ func TestItReturnsRequestHostAsNormalizedURLWhenPluginIsInScopeGlobal(
 	tree, err := config.BuildEndpointPolicyTree([]sharedConfig.EndpointConfig{})
 	assert.Nil(t, err)
 	policy := buildMetricsCollectorPolicy(utils.ScopeGlobal, false)
-	res, err := plugin.OnTransaction(onRequest, onResponse, tree, &policy)
+	res, err := plugin.OnTransaction(onRequest, onResponse, nil, tree, &policy)
 	assert.Nil(t, err)
 	assert.Equal(t, "example.com", res.Metrics.NormalizedURL)
 }
@@ -62,7 +64,7 @@ func TestItReturnsDiagnosisNormalizedURLAsNormalizedURLWhenPluginIsInScopeEndpoi
 	tree, err := config.BuildEndpointPolicyTree([]sharedConfig.EndpointConfig{})
 	assert.Nil(t, err)
 	policy := buildMetricsCollectorPolicy(utils.ScopeEndpoint, false)
-	res, err := plugin.OnTransaction(onRequest, onResponse, tree, &policy)
+	res, err := plugin.OnTransaction(onRequest, onResponse, nil, tree, &policy)
 	assert.Nil(t, err)
 	assert.Equal(
 		t,
@@ -83,7 +85,7 @@ func TestItReturnsNAAsNormalizedURLWhenPluginIsInScopeGlobalAndRequestURLIsInval
 	tree, err := config.BuildEndpointPolicyTree([]sharedConfig.EndpointConfig{})
 	assert.Nil(t, err)
 	policy := buildMetricsCollectorPolicy(utils.ScopeGlobal, false)
-	res, err := plugin.OnTransaction(onRequest, onResponse, tree, &policy)
+	res, err := plugin.OnTransaction(onRequest, onResponse, nil, tree, &policy)
 	assert.Nil(t, err)
 	assert.Equal(t, "N/A", res.Metrics.NormalizedURL)
 }
@@ -101,7 +103,7 @@ func TestItReturnsUserDefinedCounterOnResponseHeaderValue(
 	tree, err := config.BuildEndpointPolicyTree([]sharedConfig.EndpointConfig{})
 	assert.Nil(t, err)
 	policy := buildMetricsCollectorPolicy(utils.ScopeGlobal, true)
-	res, err := plugin.OnTransaction(onRequest, onResponse, tree, &policy)
+	res, err := plugin.OnTransaction(onRequest, onResponse, nil, tree, &policy)
 	assert.Nil(t, err)
 	assert.Len(t, res.Metrics.Counters, 1)
 	assert.Equal(
@@ -125,7 +127,7 @@ func TestItReturnsNoUserDefinedCounterOnResponseHeaderValueWhichIsNotIntParsable
 	tree, err := config.BuildEndpointPolicyTree([]sharedConfig.EndpointConfig{})
 	assert.Nil(t, err)
 	policy := buildMetricsCollectorPolicy(utils.ScopeGlobal, true)
-	res, err := plugin.OnTransaction(onRequest, onResponse, tree, &policy)
+	res, err := plugin.OnTransaction(onRequest, onResponse, nil, tree, &policy)
 	assert.Nil(t, err)
 	assert.Empty(t, res.Metrics.Counters)
 }
@@ -144,11 +146,52 @@ func TestItReturnsNoUserDefinedCounterOnResponseHeaderValueWhenHeaderIsNotFound(
 	tree, err := config.BuildEndpointPolicyTree([]sharedConfig.EndpointConfig{})
 	assert.Nil(t, err)
 	policy := buildMetricsCollectorPolicy(utils.ScopeGlobal, true)
-	res, err := plugin.OnTransaction(onRequest, onResponse, tree, &policy)
+	res, err := plugin.OnTransaction(onRequest, onResponse, nil, tree, &policy)
 	assert.Nil(t, err)
 	assert.Empty(t, res.Metrics.Counters)
 }
 
+func TestItReturnsConfigVersionOfRequestInRawRecord(t *testing.T) {
+	t.Parallel()
+	plugin := diagnoses.MetricsCollectorPlugin{}
+	requestTime := time.Now()
+	onRequest := buildOnRequest(requestTime, validRequestURL)
+	onRequest.ConfigVersion = "3f2a9c1b7d4e"
+	onResponse := buildOnResponse(requestTime.Add(time.Millisecond))
+	tree, err := config.BuildEndpointPolicyTree([]sharedConfig.EndpointConfig{})
+	assert.Nil(t, err)
+	policy := buildMetricsCollectorPolicy(utils.ScopeGlobal, false)
+	policy.Diagnosis.Export = "file"
+	res, err := plugin.OnTransaction(onRequest, onResponse, nil, tree, &policy)
+	assert.Nil(t, err)
+	assert.NotNil(t, res.RawData)
+
+	var record map[string]interface{}
+	assert.Nil(t, json.Unmarshal(*res.RawData, &record))
+	assert.Equal(t, "3f2a9c1b7d4e", record["config_version"])
+}
+
+func TestItReturnsBodySizesNotTrustingConflictingContentLengths(t *testing.T) {
+	t.Parallel()
+	plugin := diagnoses.MetricsCollectorPlugin{}
+	requestTime := time.Now()
+	onRequest := buildOnRequest(requestTime, validRequestURL)
+	onRequest.Body = ""
+	onRequest.Headers = map[string]string{"Content-Length": "2048"}
+	onResponse := buildOnResponse(requestTime.Add(time.Millisecond))
+	onResponse.Headers = map[string]string{"Content-Length": "10, 20"}
+	tree, err := config.BuildEndpointPolicyTree([]sharedConfig.EndpointConfig{})
+	assert.Nil(t, err)
+	policy := buildMetricsCollectorPolicy(utils.ScopeGlobal, false)
+	res, err := plugin.OnTransaction(onRequest, onResponse, nil, tree, &policy)
+	assert.Nil(t, err)
+
+	assert.Equal(t, bodysize.Size{
+		Bytes: 2048, Source: bodysize.SourceContentLength, Capped: false,
+	}, res.Metrics.RequestSize)
+	assert.Equal(t, bodysize.SourceUnknown, res.Metrics.ResponseSize.Source)
+}
+
 func buildOnRequest(requestTime time.Time, url string) messages.OnRequest {
 	return messages.OnRequest{
 		ID:         "test-1",
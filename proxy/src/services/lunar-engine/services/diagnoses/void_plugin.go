@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"lunar/engine/config"
 	"lunar/engine/messages"
+	"lunar/engine/utils/bodybuffer"
 	sharedConfig "lunar/shared-model/config"
 )
 
@@ -15,6 +16,7 @@ type VoidPlugin struct{}
 func (plugin *VoidPlugin) OnTransaction(
 	_ messages.OnRequest,
 	_ messages.OnResponse,
+	_ *bodybuffer.Buffer,
 	_ *config.EndpointPolicyTree,
 	scopedDiagnosis *config.ScopedDiagnosis,
 ) (*DiagnosisOutput, error) {
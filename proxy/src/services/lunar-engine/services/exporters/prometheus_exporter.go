@@ -4,7 +4,11 @@ import (
 	"context"
 	"fmt"
 	"lunar/engine/services/diagnoses"
+	"lunar/engine/utils/bodysize"
+	"lunar/engine/utils/upstreamhost"
 	"lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"sync"
 
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel/attribute"
@@ -12,10 +16,16 @@ import (
 )
 
 const (
-	labelNormalizedURL         = "normalized_url"
-	labelMethod                = "method"
-	labelStatusCode            = "status_code"
+	labelNormalizedURL = "normalized_url"
+	labelMethod        = "method"
+	labelStatusCode    = "status_code"
+	labelConfigVersion = "config_version"
+	// maxConfigVersionLabels bounds the config versions metrics are labeled
+	// by at a time, the oldest one being evicted for any later version
+	maxConfigVersionLabels     = 16
 	lunarTransactionMetricName = "lunar_transaction"
+	requestSizeMetricName      = "lunar_proxy.request_size_bytes"
+	responseSizeMetricName     = "lunar_proxy.response_size_bytes"
 	requestPrefix              = "request_"
 	responsePrefix             = "response_"
 )
@@ -33,15 +43,71 @@ var defaultBucketBoundaries = []float64{
 	10000,
 }
 
+// These are the default bucket boundaries of the size histograms, in bytes,
+// spanning typical API payloads from empty bodies up to megabytes
+var defaultSizeBucketBoundaries = []float64{
+	0,
+	128,
+	512,
+	1 << 10,
+	4 << 10,
+	16 << 10,
+	64 << 10,
+	256 << 10,
+	1 << 20,
+	4 << 20,
+	16 << 20,
+}
+
+// PrometheusExporter records the metrics of every exported transaction.
+// Metrics are sampling-independent: trace sampling may skip per-request
+// work, but never what is recorded here, so metrics reflect all traffic
+// and never undercount. Recording is not gated by the span of the recording
+// context, which only exemplars may be attached from; any such attachment
+// is the only part of recording which may depend on sampling.
 type PrometheusExporter struct {
-	ctx              context.Context
-	meter            metric.Meter
-	prometheusConfig config.PrometheusConfig
-	histogramMetric  metric.Int64Histogram
+	ctx               context.Context
+	meter             metric.Meter
+	prometheusConfig  config.PrometheusConfig
+	histogramMetric   metric.Int64Histogram
+	requestSizeMetric metric.Int64Histogram
+	// nil unless response sizes are recorded
+	responseSizeMetric metric.Int64Histogram
+	slos               *sloTracker
+	configVersions     *configVersionLabels
+	// nil unless transactions are labeled by upstream host,
+	// shared with the remedies so both label hosts alike
+	hosts *upstreamhost.Labels
+}
+
+// configVersionLabels keeps the latest config versions metrics were labeled
+// by, in the order they were first seen, so the versions recorded at a time
+// stay bounded however often the config is reloaded. The current version is
+// always labeled by its own, evicting the oldest version once all are kept.
+type configVersionLabels struct {
+	mutex    sync.Mutex
+	versions map[string]struct{}
+	order    []string
+}
+
+func (labels *configVersionLabels) of(version string) string {
+	labels.mutex.Lock()
+	defer labels.mutex.Unlock()
+	if _, found := labels.versions[version]; found {
+		return version
+	}
+	if len(labels.order) >= maxConfigVersionLabels {
+		delete(labels.versions, labels.order[0])
+		labels.order = labels.order[1:]
+	}
+	labels.versions[version] = struct{}{}
+	labels.order = append(labels.order, version)
+	return version
 }
 
 func NewPrometheusExporter(
 	ctx context.Context,
+	clock clock.Clock,
 	meter metric.Meter,
 	prometheusConfig config.PrometheusConfig,
 ) *PrometheusExporter {
@@ -65,14 +131,62 @@ func NewPrometheusExporter(
 		log.Error().Err(err).Msg("Failed to create histogram")
 	}
 
+	requestSizeMetric, responseSizeMetric := newSizeHistograms(meter, prometheusConfig)
+
 	return &PrometheusExporter{
-		ctx:              ctx,
-		meter:            meter,
-		prometheusConfig: prometheusConfig,
-		histogramMetric:  histogramMetric,
+		ctx:                ctx,
+		meter:              meter,
+		prometheusConfig:   prometheusConfig,
+		histogramMetric:    histogramMetric,
+		requestSizeMetric:  requestSizeMetric,
+		responseSizeMetric: responseSizeMetric,
+		slos:               newSLOTracker(clock, meter),
+		configVersions: &configVersionLabels{
+			mutex:    sync.Mutex{},
+			versions: map[string]struct{}{},
+			order:    []string{},
+		},
 	}
 }
 
+// newSizeHistograms creates the request size histogram, and the response
+// size one if enabled
+func newSizeHistograms(
+	meter metric.Meter,
+	prometheusConfig config.PrometheusConfig,
+) (metric.Int64Histogram, metric.Int64Histogram) {
+	bucketBoundaries := prometheusConfig.SizeBucketBoundaries
+	if len(bucketBoundaries) == 0 {
+		bucketBoundaries = defaultSizeBucketBoundaries
+	}
+	newHistogram := func(name string, description string) metric.Int64Histogram {
+		histogram, err := meter.Int64Histogram(
+			name,
+			metric.WithDescription(description),
+			metric.WithUnit("By"),
+			metric.WithExplicitBucketBoundaries(bucketBoundaries...),
+		)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to create %s histogram", name)
+			return nil
+		}
+		return histogram
+	}
+
+	requestSize := newHistogram(requestSizeMetricName,
+		"Histogram of request body sizes, by method and normalized URL")
+	if !prometheusConfig.ResponseSizeEnabled {
+		return requestSize, nil
+	}
+	return requestSize, newHistogram(responseSizeMetricName,
+		"Histogram of response body sizes, by method and normalized URL")
+}
+
+// SetHostLabels has transactions be labeled by their upstream host
+func (exporter *PrometheusExporter) SetHostLabels(hosts *upstreamhost.Labels) {
+	exporter.hosts = hosts
+}
+
 func (exporter *PrometheusExporter) Export(
 	diagnosisOutput diagnoses.DiagnosisOutput,
 ) error {
@@ -86,12 +200,19 @@ func (exporter *PrometheusExporter) Export(
 		attribute.Key(labelMethod).String(record.Method),
 		attribute.Key(labelStatusCode).Int(record.StatusCode),
 	}
+	baseAttrs = append(baseAttrs, exporter.hosts.Attributes(record.NormalizedURL)...)
+	if record.ConfigVersion != "" {
+		baseAttrs = append(baseAttrs, attribute.Key(labelConfigVersion).
+			String(exporter.configVersions.of(record.ConfigVersion)))
+	}
 
 	err := exporter.recordLunarTransaction(record, baseAttrs)
 	if err != nil {
 		log.Debug().Err(err).Msg("Could not record lunar transaction")
 	}
+	exporter.recordSizes(record)
 	exporter.incrementUserDefinedCounters(record, baseAttrs)
+	exporter.slos.record(record)
 
 	log.Trace().Msg("📀 Successfully updated Prometheus metrics")
 
@@ -123,6 +244,28 @@ func (exporter *PrometheusExporter) recordLunarTransaction(
 	return nil
 }
 
+// recordSizes records the body sizes of the transaction by its method and
+// normalized URL only, keeping the histograms' cardinality low. A size of
+// unknown source, as its Content-Length was not trustworthy and the body was
+// not buffered, is not recorded rather than recorded as empty. A counted
+// size which reached the cap is recorded as the cap, a lower bound.
+func (exporter *PrometheusExporter) recordSizes(record *diagnoses.MetricsCollectorRecord) {
+	attrs := metric.WithAttributes(
+		attribute.Key(labelNormalizedURL).String(record.NormalizedURL),
+		attribute.Key(labelMethod).String(record.Method),
+	)
+	if exporter.requestSizeMetric != nil &&
+		record.RequestSize.Source != bodysize.SourceUnknown {
+		exporter.requestSizeMetric.Record(context.Background(),
+			record.RequestSize.Bytes, attrs)
+	}
+	if exporter.responseSizeMetric != nil &&
+		record.ResponseSize.Source != bodysize.SourceUnknown {
+		exporter.responseSizeMetric.Record(context.Background(),
+			record.ResponseSize.Bytes, attrs)
+	}
+}
+
 func (exporter PrometheusExporter) incrementUserDefinedCounters(
 	record *diagnoses.MetricsCollectorRecord,
 	baseAttrs []attribute.KeyValue,
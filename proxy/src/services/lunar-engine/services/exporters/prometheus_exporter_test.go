@@ -0,0 +1,336 @@
+package exporters_test
+
+import (
+	"context"
+	"fmt"
+	"lunar/engine/services/diagnoses"
+	"lunar/engine/services/exporters"
+	"lunar/engine/utils/bodysize"
+	"lunar/engine/utils/upstreamhost"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdkTrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+var checkoutSLO = sharedConfig.SLO{
+	Name:          "checkout",
+	WindowSeconds: 60,
+	Latency: &sharedConfig.LatencyObjective{
+		ThresholdMillis: 500,
+		Target:          0.99,
+	},
+	ErrorRate: &sharedConfig.ErrorRateObjective{Target: 0.01},
+}
+
+func exportTransaction(
+	t *testing.T,
+	exporter *exporters.PrometheusExporter,
+	statusCode int,
+	durationMillis int64,
+) {
+	err := exporter.Export(diagnoses.DiagnosisOutput{ //nolint:exhaustruct
+		Metrics: &diagnoses.MetricsCollectorRecord{
+			Method:          "POST",
+			NormalizedURL:   "api.com/checkout",
+			StatusCode:      statusCode,
+			DurationMillis:  durationMillis,
+			RequestHeaders:  map[string]string{},
+			ResponseHeaders: map[string]string{},
+			Counters:        []diagnoses.Counter{},
+			ConfigVersion:   "",
+			SLOs:            []sharedConfig.SLO{checkoutSLO},
+		},
+	})
+	require.Nil(t, err)
+}
+
+// collectSLOGauge returns the values of an SLO gauge by objective
+func collectSLOGauge(
+	t *testing.T,
+	reader *sdkMetric.ManualReader,
+	name string,
+) map[string]float64 {
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+	values := map[string]float64{}
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, collected := range scopeMetrics.Metrics {
+			if collected.Name != name {
+				continue
+			}
+			gauge, ok := collected.Data.(metricdata.Gauge[float64])
+			require.True(t, ok)
+			for _, dataPoint := range gauge.DataPoints {
+				objective, _ := dataPoint.Attributes.Value("objective")
+				slo, _ := dataPoint.Attributes.Value("slo")
+				assert.Equal(t, "checkout", slo.AsString())
+				values[objective.AsString()] = dataPoint.Value
+			}
+		}
+	}
+	return values
+}
+
+func TestPrometheusExporterReportsRollingSLOCompliance(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	exporter := exporters.NewPrometheusExporter(
+		context.Background(), clock, meter, sharedConfig.PrometheusConfig{})
+
+	exportTransaction(t, exporter, 200, 100)
+	exportTransaction(t, exporter, 200, 499)
+	exportTransaction(t, exporter, 201, 800)
+	exportTransaction(t, exporter, 503, 100)
+
+	assert.Equal(t,
+		map[string]float64{"latency": 0.75, "error_rate": 0.75},
+		collectSLOGauge(t, reader, "lunar_slo.compliance"))
+	targets := collectSLOGauge(t, reader, "lunar_slo.target")
+	assert.InDelta(t, 0.99, targets["latency"], 1e-9)
+	assert.InDelta(t, 0.99, targets["error_rate"], 1e-9)
+
+	// Transactions roll out of the window as it moves on
+	clock.AdvanceTime(30 * time.Second)
+	exportTransaction(t, exporter, 200, 100)
+	clock.AdvanceTime(31 * time.Second)
+	assert.Equal(t,
+		map[string]float64{"latency": 1, "error_rate": 1},
+		collectSLOGauge(t, reader, "lunar_slo.compliance"))
+
+	clock.AdvanceTime(time.Minute)
+	assert.Empty(t, collectSLOGauge(t, reader, "lunar_slo.compliance"))
+}
+
+func TestPrometheusExporterCountsEveryTransactionRegardlessOfTraceSampling(
+	t *testing.T,
+) {
+	t.Parallel()
+	tracerProvider := sdkTrace.NewTracerProvider(
+		sdkTrace.WithSampler(sdkTrace.NeverSample()))
+	ctx, span := tracerProvider.Tracer("test").Start(context.Background(), "transaction")
+	defer span.End()
+	require.False(t, span.SpanContext().IsSampled())
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	exporter := exporters.NewPrometheusExporter(ctx, clock.NewMockClock(), meter,
+		sharedConfig.PrometheusConfig{}) //nolint:exhaustruct
+
+	const transactions = 25
+	for i := 0; i < transactions; i++ {
+		err := exporter.Export(diagnoses.DiagnosisOutput{ //nolint:exhaustruct
+			Metrics: &diagnoses.MetricsCollectorRecord{ //nolint:exhaustruct
+				Method:        "GET",
+				NormalizedURL: "api.com/items",
+				StatusCode:    200,
+				Counters:      []diagnoses.Counter{{Name: "items_requests", Increment: 1}},
+			},
+		})
+		require.Nil(t, err)
+	}
+
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+	counts := map[string]int64{}
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, collected := range scopeMetrics.Metrics {
+			switch data := collected.Data.(type) {
+			case metricdata.Histogram[int64]:
+				for _, dataPoint := range data.DataPoints {
+					counts[collected.Name] += int64(dataPoint.Count)
+				}
+			case metricdata.Sum[int64]:
+				for _, dataPoint := range data.DataPoints {
+					counts[collected.Name] += dataPoint.Value
+				}
+			}
+		}
+	}
+	assert.Equal(t, map[string]int64{
+		"lunar_transaction": transactions,
+		"items_requests":    transactions,
+	}, counts)
+}
+
+func TestPrometheusExporterLabelsTheCurrentConfigVersionBeyondTheBound(t *testing.T) {
+	t.Parallel()
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	exporter := exporters.NewPrometheusExporter(context.Background(),
+		clock.NewMockClock(), meter, sharedConfig.PrometheusConfig{}) //nolint:exhaustruct
+
+	const versions = 20
+	for i := 0; i < versions; i++ {
+		err := exporter.Export(diagnoses.DiagnosisOutput{ //nolint:exhaustruct
+			Metrics: &diagnoses.MetricsCollectorRecord{ //nolint:exhaustruct
+				Method:        "GET",
+				NormalizedURL: "api.com/items",
+				StatusCode:    200,
+				ConfigVersion: fmt.Sprintf("version-%d", i),
+			},
+		})
+		require.Nil(t, err)
+	}
+
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+	labeled := map[string]uint64{}
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, collected := range scopeMetrics.Metrics {
+			histogram, ok := collected.Data.(metricdata.Histogram[int64])
+			if !ok {
+				continue
+			}
+			for _, dataPoint := range histogram.DataPoints {
+				version, _ := dataPoint.Attributes.Value("config_version")
+				labeled[version.AsString()] += dataPoint.Count
+			}
+		}
+	}
+	// Versions beyond the bound evicted the oldest ones, rather than
+	// being labeled alike, so the current version is always told apart
+	assert.Len(t, labeled, versions)
+	assert.Equal(t, uint64(1), labeled["version-0"])
+	assert.Equal(t, uint64(1), labeled[fmt.Sprintf("version-%d", versions-1)])
+	assert.NotContains(t, labeled, "other")
+}
+
+func TestPrometheusExporterLabelsTheUpstreamHostLikeTheRemedies(t *testing.T) {
+	t.Parallel()
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	exporter := exporters.NewPrometheusExporter(context.Background(),
+		clock.NewMockClock(), meter, sharedConfig.PrometheusConfig{}) //nolint:exhaustruct
+	exporter.SetHostLabels(upstreamhost.NewLabels(10))
+
+	err := exporter.Export(diagnoses.DiagnosisOutput{ //nolint:exhaustruct
+		Metrics: &diagnoses.MetricsCollectorRecord{ //nolint:exhaustruct
+			Method:        "GET",
+			NormalizedURL: "API.com:443/items/{id}",
+			StatusCode:    200,
+		},
+	})
+	require.Nil(t, err)
+
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+	hosts := []string{}
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, collected := range scopeMetrics.Metrics {
+			histogram, ok := collected.Data.(metricdata.Histogram[int64])
+			if !ok {
+				continue
+			}
+			for _, dataPoint := range histogram.DataPoints {
+				host, _ := dataPoint.Attributes.Value(upstreamhost.AttributeName)
+				hosts = append(hosts, host.AsString())
+			}
+		}
+	}
+	assert.Equal(t, []string{"api.com"}, hosts)
+}
+
+// collectSizeBuckets returns the bucket counts of a size histogram,
+// keyed by the upper boundary of each bucket, along with its total count
+func collectSizeBuckets(
+	t *testing.T,
+	reader *sdkMetric.ManualReader,
+	name string,
+) (map[float64]uint64, uint64) {
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+	buckets := map[float64]uint64{}
+	total := uint64(0)
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, collected := range scopeMetrics.Metrics {
+			if collected.Name != name {
+				continue
+			}
+			histogram, ok := collected.Data.(metricdata.Histogram[int64])
+			require.True(t, ok)
+			for _, dataPoint := range histogram.DataPoints {
+				method, _ := dataPoint.Attributes.Value("method")
+				assert.Equal(t, "POST", method.AsString())
+				_, labeledByStatus := dataPoint.Attributes.Value("status_code")
+				assert.False(t, labeledByStatus)
+				for index, count := range dataPoint.BucketCounts {
+					if count == 0 || index >= len(dataPoint.Bounds) {
+						continue
+					}
+					buckets[dataPoint.Bounds[index]] += count
+				}
+				total += dataPoint.Count
+			}
+		}
+	}
+	return buckets, total
+}
+
+func exportSizedTransaction(
+	t *testing.T,
+	exporter *exporters.PrometheusExporter,
+	requestSize bodysize.Size,
+	responseSize bodysize.Size,
+) {
+	err := exporter.Export(diagnoses.DiagnosisOutput{ //nolint:exhaustruct
+		Metrics: &diagnoses.MetricsCollectorRecord{ //nolint:exhaustruct
+			Method:        "POST",
+			NormalizedURL: "api.com/uploads",
+			StatusCode:    200,
+			RequestSize:   requestSize,
+			ResponseSize:  responseSize,
+		},
+	})
+	require.Nil(t, err)
+}
+
+func TestPrometheusExporterRecordsAKnownRequestSizeInItsBucket(t *testing.T) {
+	t.Parallel()
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	exporter := exporters.NewPrometheusExporter(context.Background(),
+		clock.NewMockClock(), meter, sharedConfig.PrometheusConfig{}) //nolint:exhaustruct
+
+	known := bodysize.Size{Bytes: 3000, Source: bodysize.SourceContentLength, Capped: false}
+	exportSizedTransaction(t, exporter, known, known)
+	// an untrustworthy Content-Length on a body which was not buffered
+	// leaves the size unknown, which is not recorded as an empty body
+	unknown := bodysize.Size{Bytes: 0, Source: bodysize.SourceUnknown, Capped: false}
+	exportSizedTransaction(t, exporter, unknown, unknown)
+
+	buckets, total := collectSizeBuckets(t, reader, "lunar_proxy.request_size_bytes")
+	assert.Equal(t, map[float64]uint64{4 << 10: 1}, buckets)
+	assert.Equal(t, uint64(1), total)
+	// the response size is only recorded if enabled
+	_, total = collectSizeBuckets(t, reader, "lunar_proxy.response_size_bytes")
+	assert.Zero(t, total)
+}
+
+func TestPrometheusExporterRecordsSizesByTheConfiguredBuckets(t *testing.T) {
+	t.Parallel()
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	exporter := exporters.NewPrometheusExporter(context.Background(),
+		clock.NewMockClock(), meter, sharedConfig.PrometheusConfig{ //nolint:exhaustruct
+			SizeBucketBoundaries: []float64{100, 1000},
+			ResponseSizeEnabled:  true,
+		})
+
+	exportSizedTransaction(t, exporter,
+		bodysize.Size{Bytes: 50, Source: bodysize.SourceCounted, Capped: false},
+		bodysize.Size{Bytes: 500, Source: bodysize.SourceContentLength, Capped: false})
+
+	buckets, _ := collectSizeBuckets(t, reader, "lunar_proxy.request_size_bytes")
+	assert.Equal(t, map[float64]uint64{100: 1}, buckets)
+	buckets, _ = collectSizeBuckets(t, reader, "lunar_proxy.response_size_bytes")
+	assert.Equal(t, map[float64]uint64{1000: 1}, buckets)
+}
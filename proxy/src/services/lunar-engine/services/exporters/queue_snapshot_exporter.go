@@ -0,0 +1,39 @@
+package exporters
+
+import (
+	"encoding/json"
+	"lunar/engine/services/remedies"
+	"lunar/engine/utils/writers"
+	"time"
+)
+
+// QueueSnapshot records the requests waiting in the queues at a point in
+// time, e.g. once the engine starts shutting down, so what was in flight
+// during a cutover can be reconstructed. Its JSON format is stable:
+// fields are only ever added, and queues and requests are sorted.
+type QueueSnapshot struct {
+	Timestamp time.Time               `json:"timestamp"`
+	Queues    []remedies.PendingQueue `json:"queues"`
+}
+
+// WriteQueueSnapshot writes a snapshot of the given queues, prefixed with
+// the given exporter name the same way the RawDataExporter routes its content
+func WriteQueueSnapshot(
+	writer writers.Writer,
+	exporterName string,
+	snapshot QueueSnapshot,
+) error {
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	var messageBytes []byte
+	messageBytes = append(messageBytes, []byte(exporterName)...)
+	messageBytes = append(messageBytes, ' ')
+	messageBytes = append(messageBytes, content...)
+	_, err = writers.WriteRouted(writer, writers.Route{
+		DataType: writers.DataTypeQueueSnapshots,
+		Severity: writers.SeverityInfo,
+	}, messageBytes)
+	return err
+}
@@ -0,0 +1,45 @@
+package exporters_test
+
+import (
+	"lunar/engine/services/exporters"
+	"lunar/engine/services/remedies"
+	sharedConfig "lunar/shared-model/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteQueueSnapshotWritesStableJSON(t *testing.T) {
+	t.Parallel()
+	writer := &syncMockWriter{}
+	snapshot := exporters.QueueSnapshot{
+		Timestamp: time.Unix(1000, 0).UTC(),
+		Queues: []remedies.PendingQueue{
+			{
+				RemedyName:        "queue",
+				WindowQuota:       10,
+				WindowSizeSeconds: 60,
+				Requests: []remedies.PendingQueuedRequest{
+					{
+						ID:                  "a",
+						Priority:            1,
+						EnqueuedAt:          time.Unix(998, 0).UTC(),
+						RemainingTTLSeconds: 3,
+					},
+				},
+			},
+		},
+	}
+
+	err := exporters.WriteQueueSnapshot(writer, sharedConfig.ExporterNameFile, snapshot)
+
+	require.NoError(t, err)
+	require.Len(t, writer.messages, 1)
+	assert.Equal(t, `file {"timestamp":"1970-01-01T00:16:40Z","queues":[`+
+		`{"remedy_name":"queue","window_quota":10,"window_size_seconds":60,`+
+		`"requests":[{"id":"a","priority":1,"enqueued_at":"1970-01-01T00:16:38Z",`+
+		`"remaining_ttl_seconds":3}]}]}`,
+		string(writer.messages[0]))
+}
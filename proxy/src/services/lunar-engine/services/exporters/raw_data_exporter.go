@@ -21,9 +21,12 @@ func NewRawDataExporter(writer writers.Writer) *RawDataExporter {
 	}
 }
 
+// Export writes the raw data of a transaction to the given exporter,
+// with the given severity (by the transaction's response) to route it by
 func (exporter *RawDataExporter) Export(
 	diagnosisOutput diagnoses.DiagnosisOutput,
 	exporterType sharedConfig.ExporterType,
+	severity writers.Severity,
 ) error {
 	exporterName := exporterType.Name()
 	if exporterName == sharedConfig.ExporterNameUndefined {
@@ -40,10 +43,12 @@ func (exporter *RawDataExporter) Export(
 		content:      *content,
 		exporterName: []byte(exporterName),
 	}
-	err := exporter.writeMessage(message)
+	route := writers.Route{DataType: writers.DataTypeTransactions, Severity: severity}
+	err := exporter.writeMessage(message, route)
 	if err != nil {
 		log.Error().Err(err).
 			Msgf("Failed to export to %s", exporterName)
+		writers.DeadLetter(exporter.writer, exporterName, message.bytes())
 		return err
 	}
 
@@ -51,26 +56,56 @@ func (exporter *RawDataExporter) Export(
 	return nil
 }
 
+// ExportContent writes the given content to the given exporter,
+// for content which is not the output of a diagnosis
+func (exporter *RawDataExporter) ExportContent(
+	content []byte,
+	exporterType sharedConfig.ExporterType,
+	route writers.Route,
+) error {
+	exporterName := exporterType.Name()
+	if exporterName == sharedConfig.ExporterNameUndefined {
+		return fmt.Errorf("exporter type %v is not defined", exporterType)
+	}
+	message := message{
+		content:      content,
+		exporterName: []byte(exporterName),
+	}
+	err := exporter.writeMessage(message, route)
+	if err != nil {
+		writers.DeadLetter(exporter.writer, exporterName, message.bytes())
+	}
+	return err
+}
+
 type message struct {
 	content      []byte
 	exporterName []byte
 }
 
-func (exporter *RawDataExporter) writeMessage(message message) error {
+func (message message) bytes() []byte {
 	var space byte = ' '
 	var messageBytes []byte
 	messageBytes = append(messageBytes, message.exporterName...)
 	messageBytes = append(messageBytes, space)
 	messageBytes = append(messageBytes, message.content...)
-	return exporter.writeMessageWithRetry(messageBytes)
+	return messageBytes
+}
+
+func (exporter *RawDataExporter) writeMessage(
+	message message,
+	route writers.Route,
+) error {
+	return exporter.writeMessageWithRetry(message.bytes(), route)
 }
 
 func (exporter *RawDataExporter) writeMessageWithRetry(
 	content []byte,
+	route writers.Route,
 ) error {
 	var err error
 	for attempt := 0; attempt < exporter.retryCount; attempt++ {
-		err = exporter.writeBytes(content)
+		err = exporter.writeBytes(content, route)
 		if err == nil {
 			return nil
 		}
@@ -80,7 +115,10 @@ func (exporter *RawDataExporter) writeMessageWithRetry(
 	return err
 }
 
-func (exporter *RawDataExporter) writeBytes(content []byte) error {
-	_, err := exporter.writer.Write(content)
+func (exporter *RawDataExporter) writeBytes(
+	content []byte,
+	route writers.Route,
+) error {
+	_, err := writers.WriteRouted(exporter.writer, route, content)
 	return err
 }
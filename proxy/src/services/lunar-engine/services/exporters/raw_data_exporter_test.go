@@ -2,8 +2,10 @@ package exporters_test
 
 import (
 	"bytes"
+	"errors"
 	"lunar/engine/services/diagnoses"
 	"lunar/engine/services/exporters"
+	"lunar/engine/utils/writers"
 	sharedConfig "lunar/shared-model/config"
 	"testing"
 
@@ -59,10 +61,40 @@ func testExporterType(
 	b := []byte("test")
 	wantData := diagnoses.DiagnosisOutput{RawData: &b}
 
-	err := exporter.Export(wantData, exporterType)
+	err := exporter.Export(wantData, exporterType, writers.SeverityInfo)
 	assert.Nil(t, err)
 
 	var space byte = ' '
 	event := bytes.Split(mockWriter.content, []byte{space})
 	return wantData, event
 }
+
+// deadLetteringWriter fails all writes, recording the dead lettered records
+type deadLetteringWriter struct {
+	deadLetters []string
+}
+
+func (writer *deadLetteringWriter) Close() error {
+	return nil
+}
+
+func (writer *deadLetteringWriter) Write([]byte) (int, error) {
+	return 0, errors.New("undeliverable")
+}
+
+func (writer *deadLetteringWriter) DeadLetter(source string, record []byte) {
+	writer.deadLetters = append(writer.deadLetters, source+": "+string(record))
+}
+
+func TestWhenExportFailsTheMessageIsDeadLettered(t *testing.T) {
+	t.Parallel()
+	writer := &deadLetteringWriter{}
+	exporter := exporters.NewRawDataExporter(writer)
+	content := []byte("test")
+
+	err := exporter.Export(diagnoses.DiagnosisOutput{RawData: &content},
+		sharedConfig.ExporterS3, writers.SeverityInfo)
+
+	assert.Error(t, err)
+	assert.Equal(t, []string{"s3: s3 test"}, writer.deadLetters)
+}
@@ -0,0 +1,170 @@
+package exporters
+
+import (
+	"encoding/json"
+	"lunar/engine/config"
+	"lunar/engine/services/remedies"
+	"lunar/engine/utils/obfuscation"
+	"lunar/engine/utils/writers"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// RejectedRequestSample is the exported sample of a rejected request.
+// Header values and path segments are obfuscated, unless the obfuscation
+// excludes them or the status the request was rejected with, so the same
+// value is always exported with the same hash and samples can be correlated
+// without exposing them.
+type RejectedRequestSample struct {
+	Timestamp  time.Time         `json:"timestamp"`
+	RequestID  string            `json:"request_id"`
+	RemedyName string            `json:"remedy_name"`
+	Reason     string            `json:"reason"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Headers    map[string]string `json:"headers"`
+}
+
+// RejectedRequestSamplesExporter writes samples of the requests rejected by
+// queues to a raw data exporter, for understanding which traffic was shed.
+// Only a sample of the given rate is exported, and no more than
+// samplesPerMinute, so it is safe to keep enabled through an incident.
+type RejectedRequestSamplesExporter struct {
+	clock            clock.Clock
+	rawDataExporter  *RawDataExporter
+	exporterType     sharedConfig.ExporterType
+	obfuscator       obfuscation.Obfuscator
+	obfuscateConfig  sharedConfig.Obfuscate
+	sampleRate       float64
+	samplesPerMinute int
+
+	mutex        sync.Mutex
+	sampleCredit float64
+	// the samples which may still be exported, refilled over each minute
+	tokens     float64
+	refilledAt time.Time
+}
+
+var _ remedies.RejectedRequestsExporter = &RejectedRequestSamplesExporter{}
+
+func NewRejectedRequestSamplesExporter(
+	clock clock.Clock,
+	rawDataExporter *RawDataExporter,
+	exporterType sharedConfig.ExporterType,
+	obfuscator obfuscation.Obfuscator,
+	obfuscateConfig sharedConfig.Obfuscate,
+	sampleRate float64,
+	samplesPerMinute int,
+) *RejectedRequestSamplesExporter {
+	return &RejectedRequestSamplesExporter{
+		clock:            clock,
+		rawDataExporter:  rawDataExporter,
+		exporterType:     exporterType,
+		obfuscator:       obfuscator,
+		obfuscateConfig:  obfuscateConfig,
+		sampleRate:       sampleRate,
+		samplesPerMinute: samplesPerMinute,
+		mutex:            sync.Mutex{},
+		sampleCredit:     0,
+		tokens:           float64(samplesPerMinute),
+		refilledAt:       clock.Now(),
+	}
+}
+
+// ExportRejectedRequest exports the sample in the background,
+// so the rejected request is not held back by the exporter
+func (exporter *RejectedRequestSamplesExporter) ExportRejectedRequest(
+	rejectedRequest remedies.RejectedRequest,
+) {
+	if !exporter.sample() {
+		return
+	}
+	obfuscateConfig := config.ObfuscateForResponseStatus(
+		exporter.obfuscateConfig, rejectedRequest.StatusCode)
+	shouldObfuscateHeader := config.ShouldObfuscateRequestHeader(obfuscateConfig)
+	headers := make(map[string]string, len(rejectedRequest.Headers))
+	for name, value := range rejectedRequest.Headers {
+		headers[name] = value
+		if shouldObfuscateHeader(name) {
+			headers[name] = exporter.obfuscator.ObfuscateString(value)
+		}
+	}
+	path := rejectedRequest.Path
+	if obfuscateConfig.Enabled {
+		path = exporter.obfuscatePath(path)
+	}
+	sample := RejectedRequestSample{
+		Timestamp:  exporter.clock.Now(),
+		RequestID:  rejectedRequest.RequestID,
+		RemedyName: rejectedRequest.RemedyName,
+		Reason:     rejectedRequest.Reason,
+		Method:     rejectedRequest.Method,
+		Path:       path,
+		Headers:    headers,
+	}
+	go func() {
+		if err := exporter.export(sample); err != nil {
+			log.Debug().Err(err).Msgf("Failed to export sample of rejected request %v",
+				sample.RequestID)
+		}
+	}()
+}
+
+// obfuscatePath obfuscates each segment of the path, so samples of the
+// same endpoint keep the same shape
+func (exporter *RejectedRequestSamplesExporter) obfuscatePath(path string) string {
+	segments := strings.Split(path, "/")
+	for index, segment := range segments {
+		if segment != "" {
+			segments[index] = exporter.obfuscator.ObfuscateString(segment)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// sample spreads the exported samples evenly over the rejected requests,
+// exporting no more than samplesPerMinute over any minute
+func (exporter *RejectedRequestSamplesExporter) sample() bool {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+	exporter.sampleCredit += exporter.sampleRate
+	if exporter.sampleCredit < 1 {
+		return false
+	}
+	exporter.sampleCredit--
+
+	now := exporter.clock.Now()
+	exporter.tokens += now.Sub(exporter.refilledAt).Minutes() *
+		float64(exporter.samplesPerMinute)
+	if exporter.tokens > float64(exporter.samplesPerMinute) {
+		exporter.tokens = float64(exporter.samplesPerMinute)
+	}
+	exporter.refilledAt = now
+	if exporter.tokens < 1 {
+		return false
+	}
+	exporter.tokens--
+	return true
+}
+
+func (exporter *RejectedRequestSamplesExporter) export(
+	sample RejectedRequestSample,
+) error {
+	content, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	return exporter.rawDataExporter.ExportContent(
+		content,
+		exporter.exporterType,
+		writers.Route{
+			DataType: writers.DataTypeRejectedRequestSamples,
+			Severity: writers.SeverityWarning,
+		},
+	)
+}
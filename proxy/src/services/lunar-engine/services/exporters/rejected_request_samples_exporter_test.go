@@ -0,0 +1,165 @@
+package exporters_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"lunar/engine/services/exporters"
+	"lunar/engine/services/remedies"
+	"lunar/engine/utils/obfuscation"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRejectedRequestSamplesExporterObfuscatesHeadersAndPathConsistently(
+	t *testing.T,
+) {
+	t.Parallel()
+	writer := &syncMockWriter{}
+	obfuscator := obfuscation.Obfuscator{Hasher: obfuscation.MD5Hasher{}}
+	exporter := exporters.NewRejectedRequestSamplesExporter(clock.NewMockClock(),
+		exporters.NewRawDataExporter(writer), sharedConfig.ExporterFile,
+		obfuscator, sharedConfig.Obfuscate{Enabled: true}, 1, 10)
+
+	for _, requestID := range []string{"request-1", "request-2"} {
+		exporter.ExportRejectedRequest(remedies.RejectedRequest{
+			RequestID:  requestID,
+			RemedyName: "queue",
+			Reason:     "queue_full",
+			Method:     "POST",
+			Path:       "/orders/secret-id",
+			Headers:    map[string]string{"Authorization": "Bearer secret"},
+		})
+	}
+
+	require.Eventually(t, func() bool {
+		return len(rejectedRequestSamples(t, writer)) == 2
+	}, time.Second, time.Millisecond)
+	for _, sample := range rejectedRequestSamples(t, writer) {
+		assert.Equal(t, "POST", sample.Method)
+		assert.Equal(t, "/"+obfuscator.ObfuscateString("orders")+"/"+
+			obfuscator.ObfuscateString("secret-id"), sample.Path)
+		assert.Equal(t, map[string]string{
+			"Authorization": obfuscator.ObfuscateString("Bearer secret"),
+		}, sample.Headers)
+	}
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	assert.NotContains(t, string(bytes.Join(writer.messages, nil)), "secret")
+}
+
+func TestRejectedRequestSamplesExporterObfuscatesAsConfiguredForTheStatus(
+	t *testing.T,
+) {
+	t.Parallel()
+	writer := &syncMockWriter{}
+	obfuscator := obfuscation.Obfuscator{Hasher: obfuscation.MD5Hasher{}}
+	obfuscateConfig := sharedConfig.Obfuscate{
+		Enabled: true,
+		Exclusions: sharedConfig.ObfuscationExclusions{
+			RequestHeaders: []string{"X-Tenant"},
+		},
+		Conditions: &sharedConfig.ObfuscationConditions{
+			StatusCode: []sharedConfig.Range[int]{{From: 500, To: 599}},
+		},
+	}
+	exporter := exporters.NewRejectedRequestSamplesExporter(clock.NewMockClock(),
+		exporters.NewRawDataExporter(writer), sharedConfig.ExporterFile,
+		obfuscator, obfuscateConfig, 1, 10)
+	headers := map[string]string{"Authorization": "secret", "X-Tenant": "acme"}
+
+	exporter.ExportRejectedRequest(remedies.RejectedRequest{
+		RequestID:  "unavailable",
+		StatusCode: 503,
+		Path:       "/orders/secret-id",
+		Headers:    headers,
+	})
+	exporter.ExportRejectedRequest(remedies.RejectedRequest{
+		RequestID:  "too-many-requests",
+		StatusCode: 429,
+		Path:       "/orders/secret-id",
+		Headers:    headers,
+	})
+
+	require.Eventually(t, func() bool {
+		return len(rejectedRequestSamples(t, writer)) == 2
+	}, time.Second, time.Millisecond)
+	samples := map[string]exporters.RejectedRequestSample{}
+	for _, sample := range rejectedRequestSamples(t, writer) {
+		samples[sample.RequestID] = sample
+	}
+	assert.Equal(t, "/"+obfuscator.ObfuscateString("orders")+"/"+
+		obfuscator.ObfuscateString("secret-id"), samples["unavailable"].Path)
+	assert.Equal(t, map[string]string{
+		"Authorization": obfuscator.ObfuscateString("secret"),
+		"X-Tenant":      "acme",
+	}, samples["unavailable"].Headers)
+	assert.Equal(t, "/orders/secret-id", samples["too-many-requests"].Path)
+	assert.Equal(t, headers, samples["too-many-requests"].Headers)
+}
+
+func TestRejectedRequestSamplesExporterStopsAtItsCapPerMinute(t *testing.T) {
+	t.Parallel()
+	writer := &syncMockWriter{}
+	clock := clock.NewMockClock()
+	exporter := exporters.NewRejectedRequestSamplesExporter(clock,
+		exporters.NewRawDataExporter(writer), sharedConfig.ExporterFile,
+		obfuscation.Obfuscator{Hasher: obfuscation.MD5Hasher{}},
+		sharedConfig.Obfuscate{Enabled: true}, 0.5, 3)
+	reject := func(from int, to int) {
+		for i := from; i < to; i++ {
+			exporter.ExportRejectedRequest(remedies.RejectedRequest{
+				RequestID: strconv.Itoa(i),
+				Reason:    "ttl_expired",
+			})
+		}
+	}
+	sampledRequestIDs := func(count int) []string {
+		require.Eventually(t, func() bool {
+			return len(rejectedRequestSamples(t, writer)) == count
+		}, time.Second, time.Millisecond)
+		time.Sleep(10 * time.Millisecond)
+		requestIDs := []string{}
+		for _, sample := range rejectedRequestSamples(t, writer) {
+			requestIDs = append(requestIDs, sample.RequestID)
+		}
+		return requestIDs
+	}
+
+	reject(0, 20)
+	assert.ElementsMatch(t, []string{"1", "3", "5"}, sampledRequestIDs(3))
+
+	// A third of the minute refilled a single sample
+	clock.AdvanceTime(20 * time.Second)
+	reject(20, 40)
+	assert.ElementsMatch(t, []string{"1", "3", "5", "21"}, sampledRequestIDs(4))
+
+	// The cap is not exceeded however long no request was rejected
+	clock.AdvanceTime(time.Hour)
+	reject(40, 60)
+	assert.ElementsMatch(t, []string{"1", "3", "5", "21", "41", "43", "45"},
+		sampledRequestIDs(7))
+}
+
+func rejectedRequestSamples(
+	t *testing.T,
+	writer *syncMockWriter,
+) []exporters.RejectedRequestSample {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	samples := []exporters.RejectedRequestSample{}
+	for _, message := range writer.messages {
+		name, content, found := bytes.Cut(message, []byte{' '})
+		require.True(t, found)
+		require.Equal(t, sharedConfig.ExporterNameFile, string(name))
+		var sample exporters.RejectedRequestSample
+		require.NoError(t, json.Unmarshal(bytes.TrimSpace(content), &sample))
+		samples = append(samples, sample)
+	}
+	return samples
+}
@@ -0,0 +1,112 @@
+package exporters
+
+import (
+	"encoding/json"
+	"lunar/engine/services/remedies"
+	"lunar/engine/utils/writers"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// RejectedRequestRecord is the structured record exported per rejected request
+type RejectedRequestRecord struct {
+	Timestamp  time.Time `json:"timestamp"`
+	RequestID  string    `json:"request_id"`
+	RemedyName string    `json:"remedy_name"`
+	Priority   float64   `json:"priority"`
+	Tenant     string    `json:"tenant"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	WaitTimeMs float64   `json:"wait_time_ms"`
+	Reason     string    `json:"reason"`
+}
+
+// RejectedRequestsExporter writes a record of the requests rejected by queues
+// to a raw data exporter. Only a sample of the given rate is exported,
+// so an incident does not flood the exporter.
+type RejectedRequestsExporter struct {
+	clock           clock.Clock
+	rawDataExporter *RawDataExporter
+	exporterType    sharedConfig.ExporterType
+	sampleRate      float64
+
+	mutex        sync.Mutex
+	sampleCredit float64
+}
+
+var _ remedies.RejectedRequestsExporter = &RejectedRequestsExporter{}
+
+func NewRejectedRequestsExporter(
+	clock clock.Clock,
+	rawDataExporter *RawDataExporter,
+	exporterType sharedConfig.ExporterType,
+	sampleRate float64,
+) *RejectedRequestsExporter {
+	return &RejectedRequestsExporter{
+		clock:           clock,
+		rawDataExporter: rawDataExporter,
+		exporterType:    exporterType,
+		sampleRate:      sampleRate,
+		mutex:           sync.Mutex{},
+		sampleCredit:    0,
+	}
+}
+
+// ExportRejectedRequest exports the record in the background,
+// so the rejected request is not held back by the exporter
+func (exporter *RejectedRequestsExporter) ExportRejectedRequest(
+	rejectedRequest remedies.RejectedRequest,
+) {
+	if !exporter.sample() {
+		return
+	}
+	record := RejectedRequestRecord{
+		Timestamp:  exporter.clock.Now(),
+		RequestID:  rejectedRequest.RequestID,
+		RemedyName: rejectedRequest.RemedyName,
+		Priority:   rejectedRequest.Priority,
+		Tenant:     rejectedRequest.TenantID,
+		EnqueuedAt: rejectedRequest.EnqueuedAt,
+		WaitTimeMs: float64(rejectedRequest.WaitTime) / float64(time.Millisecond),
+		Reason:     rejectedRequest.Reason,
+	}
+	go func() {
+		if err := exporter.export(record); err != nil {
+			log.Debug().Err(err).Msgf("Failed to export rejected request %v",
+				record.RequestID)
+		}
+	}()
+}
+
+// sample spreads the exported records evenly over the rejected requests,
+// e.g. a rate of 0.25 exports every fourth rejected request
+func (exporter *RejectedRequestsExporter) sample() bool {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+	exporter.sampleCredit += exporter.sampleRate
+	if exporter.sampleCredit < 1 {
+		return false
+	}
+	exporter.sampleCredit--
+	return true
+}
+
+func (exporter *RejectedRequestsExporter) export(
+	record RejectedRequestRecord,
+) error {
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return exporter.rawDataExporter.ExportContent(
+		content,
+		exporter.exporterType,
+		writers.Route{
+			DataType: writers.DataTypeRejectedRequests,
+			Severity: writers.SeverityWarning,
+		},
+	)
+}
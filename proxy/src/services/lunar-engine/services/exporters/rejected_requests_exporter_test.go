@@ -0,0 +1,103 @@
+package exporters_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"lunar/engine/services/exporters"
+	"lunar/engine/services/remedies"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRejectedRequestsExporterWritesRecordToRawDataExporter(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1010, 0))
+	writer := &syncMockWriter{}
+	exporter := exporters.NewRejectedRequestsExporter(clock,
+		exporters.NewRawDataExporter(writer), sharedConfig.ExporterS3, 1)
+
+	exporter.ExportRejectedRequest(remedies.RejectedRequest{
+		RequestID:  "request-1",
+		RemedyName: "queue",
+		Priority:   2,
+		TenantID:   "acme",
+		EnqueuedAt: time.Unix(1005, 0),
+		WaitTime:   5 * time.Second,
+		Reason:     "ttl_expired",
+	})
+
+	require.Eventually(t, func() bool {
+		return len(rejectedRequestRecords(t, writer, sharedConfig.ExporterNameS3)) == 1
+	}, time.Second, time.Millisecond)
+	record := rejectedRequestRecords(t, writer, sharedConfig.ExporterNameS3)[0]
+	assert.Equal(t, exporters.RejectedRequestRecord{
+		Timestamp:  time.Unix(1010, 0),
+		RequestID:  "request-1",
+		RemedyName: "queue",
+		Priority:   2,
+		Tenant:     "acme",
+		EnqueuedAt: time.Unix(1005, 0),
+		WaitTimeMs: 5000,
+		Reason:     "ttl_expired",
+	}, normalizeRecordTimes(record))
+}
+
+func TestRejectedRequestsExporterExportsSampleOfRejectedRequests(t *testing.T) {
+	t.Parallel()
+	writer := &syncMockWriter{}
+	exporter := exporters.NewRejectedRequestsExporter(clock.NewMockClock(),
+		exporters.NewRawDataExporter(writer), sharedConfig.ExporterFile, 0.25)
+
+	for i := 0; i < 8; i++ {
+		exporter.ExportRejectedRequest(remedies.RejectedRequest{
+			RequestID: strconv.Itoa(i),
+			Reason:    "queue_full",
+		})
+	}
+
+	require.Eventually(t, func() bool {
+		return len(rejectedRequestRecords(t, writer, sharedConfig.ExporterNameFile)) == 2
+	}, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	requestIDs := []string{}
+	for _, record := range rejectedRequestRecords(t, writer, sharedConfig.ExporterNameFile) {
+		requestIDs = append(requestIDs, record.RequestID)
+	}
+	assert.ElementsMatch(t, []string{"3", "7"}, requestIDs)
+}
+
+func rejectedRequestRecords(
+	t *testing.T,
+	writer *syncMockWriter,
+	exporterName string,
+) []exporters.RejectedRequestRecord {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	records := []exporters.RejectedRequestRecord{}
+	for _, message := range writer.messages {
+		name, content, found := bytes.Cut(message, []byte{' '})
+		require.True(t, found)
+		require.Equal(t, exporterName, string(name))
+		var record exporters.RejectedRequestRecord
+		require.NoError(t, json.Unmarshal(bytes.TrimSpace(content), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+// normalizeRecordTimes drops the location decoded from JSON,
+// so times can be compared with the expected ones
+func normalizeRecordTimes(
+	record exporters.RejectedRequestRecord,
+) exporters.RejectedRequestRecord {
+	record.Timestamp = time.Unix(record.Timestamp.Unix(), 0)
+	record.EnqueuedAt = time.Unix(record.EnqueuedAt.Unix(), 0)
+	return record
+}
@@ -0,0 +1,247 @@
+package exporters
+
+import (
+	"context"
+	"lunar/engine/services/diagnoses"
+	"lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	sloComplianceMetricName = "lunar_slo.compliance"
+	sloTargetMetricName     = "lunar_slo.target"
+	labelSLO                = "slo"
+	labelObjective          = "objective"
+	objectiveLatency        = "latency"
+	objectiveErrorRate      = "error_rate"
+
+	// each rolling window is counted in this many buckets, so the window
+	// rolls by a bucket at a time while its memory stays fixed
+	sloWindowBuckets = 60
+	// SLOs are tracked for at most this many endpoints and SLO names,
+	// transactions of further ones are not tracked
+	maxTrackedSLOs = 1000
+)
+
+type sloKey struct {
+	method        string
+	normalizedURL string
+	name          string
+}
+
+type sloBucket struct {
+	// index of the bucket since the epoch, telling if it is still in the window
+	index        int64
+	transactions int64
+	fast         int64
+	errors       int64
+}
+
+// sloWindow counts the transactions of an SLO over its rolling window
+type sloWindow struct {
+	slo            config.SLO
+	bucketDuration time.Duration
+	buckets        [sloWindowBuckets]sloBucket
+}
+
+// sloTracker tracks the compliance of transactions with their SLOs over
+// the rolling windows of the SLOs, using buckets so its memory is bounded
+// by the number of tracked SLOs. The compliance is exported as the ratio of
+// the transactions meeting each objective, along with the objective's target,
+// so SLO burn can be alerted on by comparing the two.
+type sloTracker struct {
+	clock   clock.Clock
+	mutex   sync.Mutex
+	windows map[sloKey]*sloWindow
+}
+
+func newSLOTracker(clock clock.Clock, meter metric.Meter) *sloTracker {
+	tracker := &sloTracker{
+		clock:   clock,
+		mutex:   sync.Mutex{},
+		windows: map[sloKey]*sloWindow{},
+	}
+	_, err := meter.Float64ObservableGauge(
+		sloComplianceMetricName,
+		metric.WithDescription("Ratio of the transactions meeting an objective "+
+			"of an SLO over its rolling window"),
+		metric.WithUnit("1"),
+		metric.WithFloat64Callback(tracker.observeCompliance),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create %s metric", sloComplianceMetricName)
+	}
+	_, err = meter.Float64ObservableGauge(
+		sloTargetMetricName,
+		metric.WithDescription("Ratio of the transactions which should meet "+
+			"an objective of an SLO over its rolling window"),
+		metric.WithUnit("1"),
+		metric.WithFloat64Callback(tracker.observeTarget),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create %s metric", sloTargetMetricName)
+	}
+	return tracker
+}
+
+// record counts a transaction towards the SLOs it is in the scope of
+func (tracker *sloTracker) record(record *diagnoses.MetricsCollectorRecord) {
+	if len(record.SLOs) == 0 {
+		return
+	}
+	now := tracker.clock.Now()
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	for _, slo := range record.SLOs {
+		key := sloKey{
+			method:        record.Method,
+			normalizedURL: record.NormalizedURL,
+			name:          slo.Name,
+		}
+		window, found := tracker.windows[key]
+		// A window is reset once its SLO changed how it is counted
+		if !found || !countedAlike(window.slo, slo) {
+			if !found && len(tracker.windows) >= maxTrackedSLOs {
+				tracker.evictIdle(now)
+				if len(tracker.windows) >= maxTrackedSLOs {
+					log.Debug().Msgf("Too many SLOs tracked, will not track %v", key)
+					continue
+				}
+			}
+			window = newSLOWindow(slo)
+			tracker.windows[key] = window
+		}
+		window.slo = slo
+		window.record(now, record)
+	}
+}
+
+func newSLOWindow(slo config.SLO) *sloWindow {
+	bucketDuration := time.Duration(slo.WindowSeconds) * time.Second / sloWindowBuckets
+	return &sloWindow{slo: slo, bucketDuration: bucketDuration} //nolint:exhaustruct
+}
+
+func (window *sloWindow) record(
+	now time.Time,
+	record *diagnoses.MetricsCollectorRecord,
+) {
+	index := now.UnixNano() / int64(window.bucketDuration)
+	bucket := &window.buckets[index%sloWindowBuckets]
+	if bucket.index != index {
+		*bucket = sloBucket{index: index} //nolint:exhaustruct
+	}
+	bucket.transactions++
+	if window.slo.Latency != nil &&
+		record.DurationMillis < window.slo.Latency.ThresholdMillis {
+		bucket.fast++
+	}
+	if record.StatusCode >= http.StatusInternalServerError {
+		bucket.errors++
+	}
+}
+
+// totals sums the buckets of the window ending now
+func (window *sloWindow) totals(now time.Time) sloBucket {
+	index := now.UnixNano() / int64(window.bucketDuration)
+	totals := sloBucket{index: index} //nolint:exhaustruct
+	for _, bucket := range window.buckets {
+		if bucket.index > index-sloWindowBuckets && bucket.index <= index {
+			totals.transactions += bucket.transactions
+			totals.fast += bucket.fast
+			totals.errors += bucket.errors
+		}
+	}
+	return totals
+}
+
+// evictIdle stops tracking the SLOs with no transactions in their window.
+// Must be called while holding the mutex.
+func (tracker *sloTracker) evictIdle(now time.Time) {
+	for key, window := range tracker.windows {
+		if window.totals(now).transactions == 0 {
+			delete(tracker.windows, key)
+		}
+	}
+}
+
+func (tracker *sloTracker) observeCompliance(
+	_ context.Context,
+	observer metric.Float64Observer,
+) error {
+	tracker.forEachObjective(func(
+		attributes []attribute.KeyValue,
+		compliance float64,
+		_ float64,
+	) {
+		observer.Observe(compliance, metric.WithAttributes(attributes...))
+	})
+	return nil
+}
+
+func (tracker *sloTracker) observeTarget(
+	_ context.Context,
+	observer metric.Float64Observer,
+) error {
+	tracker.forEachObjective(func(
+		attributes []attribute.KeyValue,
+		_ float64,
+		target float64,
+	) {
+		observer.Observe(target, metric.WithAttributes(attributes...))
+	})
+	return nil
+}
+
+// forEachObjective calls the given function with the compliance and target
+// of each objective of the SLOs with transactions in their window
+func (tracker *sloTracker) forEachObjective(
+	observe func(attributes []attribute.KeyValue, compliance float64, target float64),
+) {
+	now := tracker.clock.Now()
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	for key, window := range tracker.windows {
+		totals := window.totals(now)
+		if totals.transactions == 0 {
+			continue
+		}
+		attributes := func(objective string) []attribute.KeyValue {
+			return []attribute.KeyValue{
+				attribute.Key(labelNormalizedURL).String(key.normalizedURL),
+				attribute.Key(labelMethod).String(key.method),
+				attribute.Key(labelSLO).String(key.name),
+				attribute.Key(labelObjective).String(objective),
+			}
+		}
+		transactions := float64(totals.transactions)
+		if window.slo.Latency != nil {
+			observe(attributes(objectiveLatency),
+				float64(totals.fast)/transactions, window.slo.Latency.Target)
+		}
+		if window.slo.ErrorRate != nil {
+			observe(attributes(objectiveErrorRate),
+				1-float64(totals.errors)/transactions, 1-window.slo.ErrorRate.Target)
+		}
+	}
+}
+
+// countedAlike returns whether the transactions of the SLOs are counted
+// alike, so a window counted for one applies to the other
+func countedAlike(slo config.SLO, other config.SLO) bool {
+	return slo.WindowSeconds == other.WindowSeconds &&
+		latencyThreshold(slo) == latencyThreshold(other)
+}
+
+func latencyThreshold(slo config.SLO) int64 {
+	if slo.Latency == nil {
+		return 0
+	}
+	return slo.Latency.ThresholdMillis
+}
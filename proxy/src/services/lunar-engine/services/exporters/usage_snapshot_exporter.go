@@ -0,0 +1,193 @@
+package exporters
+
+import (
+	"encoding/json"
+	"lunar/engine/services/remedies"
+	"lunar/engine/utils/writers"
+	"lunar/toolkit-core/clock"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// UsageSnapshotExporterName prefixes the snapshots written
+	// to a dedicated endpoint
+	UsageSnapshotExporterName = "usage_snapshot"
+	usageSnapshotSampleTick   = time.Second
+)
+
+// UsageSnapshot summarizes the window usage of a remedy over an interval
+type UsageSnapshot struct {
+	Timestamp       time.Time `json:"timestamp"`
+	IntervalSeconds float64   `json:"interval_seconds"`
+	RemedyName      string    `json:"remedy_name"`
+	Quota           int64     `json:"quota"`
+	PeakUsed        int64     `json:"peak_used"`
+	AverageUsed     float64   `json:"average_used"`
+	Rejected        int64     `json:"rejected"`
+}
+
+type usageAccumulator struct {
+	quota    int64
+	peak     int64
+	usedSum  int64
+	samples  int64
+	rejected int64
+}
+
+// UsageSnapshotExporter periodically samples the window usage of remedies and
+// writes a snapshot per remedy for each interval, as a durable record for
+// right-sizing quotas. Sampling only reads the remedies' state.
+// Snapshots are prefixed with the given exporter name, the same way
+// the RawDataExporter routes its content.
+type UsageSnapshotExporter struct {
+	clock        clock.Clock
+	writer       writers.Writer
+	exporterName string
+	interval     time.Duration
+	sampleTick   time.Duration
+	reporters    []remedies.WindowUsageReporter
+
+	mutex         sync.Mutex
+	accumulators  map[string]*usageAccumulator
+	lastRejected  map[string]int64
+	intervalStart time.Time
+	stop          chan struct{}
+	stopOnce      sync.Once
+}
+
+func NewUsageSnapshotExporter(
+	clock clock.Clock,
+	writer writers.Writer,
+	exporterName string,
+	interval time.Duration,
+	reporters ...remedies.WindowUsageReporter,
+) *UsageSnapshotExporter {
+	sampleTick := usageSnapshotSampleTick
+	if interval < sampleTick {
+		sampleTick = interval
+	}
+	return &UsageSnapshotExporter{
+		clock:         clock,
+		writer:        writer,
+		exporterName:  exporterName,
+		interval:      interval,
+		sampleTick:    sampleTick,
+		reporters:     reporters,
+		mutex:         sync.Mutex{},
+		accumulators:  map[string]*usageAccumulator{},
+		lastRejected:  map[string]int64{},
+		intervalStart: clock.Now(),
+		stop:          make(chan struct{}),
+		stopOnce:      sync.Once{},
+	}
+}
+
+// Run samples and exports the usage in the background until Stop is called
+func (exporter *UsageSnapshotExporter) Run() {
+	log.Info().Msgf("Exporting remedies usage snapshots every %v",
+		exporter.interval)
+	go func() {
+		for {
+			select {
+			case <-exporter.stop:
+				return
+			case <-exporter.clock.After(exporter.sampleTick):
+				exporter.sample()
+				if exporter.clock.Now().Sub(exporter.intervalStart) >= exporter.interval {
+					exporter.flush()
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the exporting, the usage of the current interval is not exported.
+// It is safe to call on a nil exporter and more than once.
+func (exporter *UsageSnapshotExporter) Stop() {
+	if exporter == nil {
+		return
+	}
+	exporter.stopOnce.Do(func() { close(exporter.stop) })
+}
+
+func (exporter *UsageSnapshotExporter) sample() {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	for _, reporter := range exporter.reporters {
+		for _, usage := range reporter.WindowUsages() {
+			accumulator, found := exporter.accumulators[usage.RemedyName]
+			if !found {
+				accumulator = &usageAccumulator{} //nolint:exhaustruct
+				exporter.accumulators[usage.RemedyName] = accumulator
+			}
+			accumulator.quota = usage.Quota
+			accumulator.usedSum += usage.Used
+			accumulator.samples++
+			if usage.Used > accumulator.peak {
+				accumulator.peak = usage.Used
+			}
+			accumulator.rejected = usage.Rejected
+		}
+	}
+}
+
+// flush writes the snapshots of the interval which just ended
+// and starts a new one
+func (exporter *UsageSnapshotExporter) flush() {
+	exporter.mutex.Lock()
+	now := exporter.clock.Now()
+	intervalSeconds := now.Sub(exporter.intervalStart).Seconds()
+	snapshots := make([]UsageSnapshot, 0, len(exporter.accumulators))
+	for remedyName, accumulator := range exporter.accumulators {
+		rejected := accumulator.rejected - exporter.lastRejected[remedyName]
+		if rejected < 0 {
+			// the remedy was re-initialized, so its count restarted
+			rejected = accumulator.rejected
+		}
+		exporter.lastRejected[remedyName] = accumulator.rejected
+		snapshots = append(snapshots, UsageSnapshot{
+			Timestamp:       now,
+			IntervalSeconds: intervalSeconds,
+			RemedyName:      remedyName,
+			Quota:           accumulator.quota,
+			PeakUsed:        accumulator.peak,
+			AverageUsed: float64(accumulator.usedSum) /
+				float64(accumulator.samples),
+			Rejected: rejected,
+		})
+	}
+	exporter.accumulators = map[string]*usageAccumulator{}
+	exporter.intervalStart = now
+	exporter.mutex.Unlock()
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].RemedyName < snapshots[j].RemedyName
+	})
+	for _, snapshot := range snapshots {
+		if err := exporter.write(snapshot); err != nil {
+			log.Debug().Err(err).Msgf("Failed to export usage snapshot of %v",
+				snapshot.RemedyName)
+		}
+	}
+}
+
+func (exporter *UsageSnapshotExporter) write(snapshot UsageSnapshot) error {
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	var messageBytes []byte
+	messageBytes = append(messageBytes, []byte(exporter.exporterName)...)
+	messageBytes = append(messageBytes, ' ')
+	messageBytes = append(messageBytes, content...)
+	_, err = writers.WriteRouted(exporter.writer, writers.Route{
+		DataType: writers.DataTypeUsageSnapshots,
+		Severity: writers.SeverityInfo,
+	}, messageBytes)
+	return err
+}
@@ -0,0 +1,145 @@
+package exporters_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"lunar/engine/services/exporters"
+	"lunar/engine/services/remedies"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockWindowUsageReporter struct {
+	mutex sync.Mutex
+	calls int64
+}
+
+// WindowUsages reports a usage which grows with every sample
+func (reporter *mockWindowUsageReporter) WindowUsages() []remedies.RemedyWindowUsage {
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+	reporter.calls++
+	return []remedies.RemedyWindowUsage{
+		{
+			RemedyName: "queue",
+			Quota:      10,
+			Used:       reporter.calls * 2,
+			Rejected:   reporter.calls,
+		},
+	}
+}
+
+func (reporter *mockWindowUsageReporter) callCount() int64 {
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+	return reporter.calls
+}
+
+type syncMockWriter struct {
+	mutex    sync.Mutex
+	messages [][]byte
+}
+
+func (writer *syncMockWriter) Write(b []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.messages = append(writer.messages, append([]byte{}, b...))
+	return len(b), nil
+}
+
+func (writer *syncMockWriter) Close() error {
+	return nil
+}
+
+func (writer *syncMockWriter) snapshots(t *testing.T) []exporters.UsageSnapshot {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	snapshots := []exporters.UsageSnapshot{}
+	for _, message := range writer.messages {
+		exporterName, content, found := bytes.Cut(message, []byte{' '})
+		require.True(t, found)
+		require.Equal(t, sharedConfig.ExporterNameFile, string(exporterName))
+		var snapshot exporters.UsageSnapshot
+		require.NoError(t, json.Unmarshal(content, &snapshot))
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots
+}
+
+func advanceSamples(
+	t *testing.T,
+	clock *clock.MockClock,
+	reporter *mockWindowUsageReporter,
+	samples int64,
+) {
+	for sample := int64(1); sample <= samples; sample++ {
+		wantCalls := reporter.callCount() + 1
+		clock.AdvanceTime(time.Second)
+		assert.Eventually(t, func() bool {
+			return reporter.callCount() == wantCalls
+		}, time.Second, time.Millisecond)
+		time.Sleep(1 * time.Millisecond)
+	}
+}
+
+func TestUsageSnapshotExporterWritesIntervalSummary(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	reporter := &mockWindowUsageReporter{}
+	writer := &syncMockWriter{}
+	exporter := exporters.NewUsageSnapshotExporter(
+		clock, writer, sharedConfig.ExporterNameFile, 3*time.Second, reporter)
+	exporter.Run()
+	defer exporter.Stop()
+	time.Sleep(1 * time.Millisecond)
+
+	advanceSamples(t, clock, reporter, 3)
+	assert.Eventually(t, func() bool {
+		return len(writer.snapshots(t)) == 1
+	}, time.Second, time.Millisecond)
+
+	snapshot := writer.snapshots(t)[0]
+	assert.Equal(t, "queue", snapshot.RemedyName)
+	assert.Equal(t, 3.0, snapshot.IntervalSeconds)
+	assert.Equal(t, int64(10), snapshot.Quota)
+	assert.Equal(t, int64(6), snapshot.PeakUsed)
+	assert.Equal(t, 4.0, snapshot.AverageUsed)
+	assert.Equal(t, int64(3), snapshot.Rejected)
+
+	// Rejections are counted per interval
+	advanceSamples(t, clock, reporter, 3)
+	assert.Eventually(t, func() bool {
+		return len(writer.snapshots(t)) == 2
+	}, time.Second, time.Millisecond)
+	snapshot = writer.snapshots(t)[1]
+	assert.Equal(t, int64(12), snapshot.PeakUsed)
+	assert.Equal(t, 10.0, snapshot.AverageUsed)
+	assert.Equal(t, int64(3), snapshot.Rejected)
+}
+
+func TestUsageSnapshotExporterStopsSampling(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	reporter := &mockWindowUsageReporter{}
+	exporter := exporters.NewUsageSnapshotExporter(
+		clock, &syncMockWriter{}, sharedConfig.ExporterNameFile, time.Minute, reporter)
+	exporter.Run()
+	time.Sleep(1 * time.Millisecond)
+	advanceSamples(t, clock, reporter, 1)
+
+	exporter.Stop()
+	exporter.Stop()
+	time.Sleep(1 * time.Millisecond)
+	clock.AdvanceTime(time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int64(1), reporter.callCount())
+
+	var disabled *exporters.UsageSnapshotExporter
+	disabled.Stop()
+}
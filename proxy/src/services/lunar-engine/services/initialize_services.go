@@ -3,6 +3,8 @@
 package services
 
 import (
+	"context"
+	"lunar/engine/services/remedies"
 	"lunar/engine/utils/limit"
 	"lunar/engine/utils/queue"
 	"lunar/engine/utils/writers"
@@ -12,6 +14,7 @@ import (
 	"time"
 
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/metric/noop"
 )
 
 func Initialize(
@@ -41,3 +44,37 @@ func Initialize(
 		exportersConfig,
 	)
 }
+
+// InitializeSelfTestRemedies creates remedies of their own state, for the
+// self-test to run its synthetic requests through without consuming the
+// quota of real traffic or being reported along with its metrics.
+// Its circuit never disables a remedy, so every run exercises all of them.
+func InitializeSelfTestRemedies(proxyTimeout time.Duration) (*RemedyPlugins, error) {
+	clock := contextmanager.Get().GetClock()
+	contextLogger := logging.ContextLogger{Logger: log.Logger}
+	meter := noop.NewMeterProvider().Meter("self-test")
+	delayedPriorityQueueFactory := func(
+		queueKey queue.QueueKey,
+	) queue.DelayedPriorityQueueable {
+		return queue.NewInMemoryDelayedPriorityQueue(
+			queueKey,
+			clock,
+			contextLogger,
+		)
+	}
+	remedyPlugins, err := initializeRemedies(
+		context.Background(),
+		clock,
+		meter,
+		contextLogger,
+		proxyTimeout,
+		limit.NewRateLimitState(clock, contextLogger),
+		delayedPriorityQueueFactory,
+		nil,
+		remedies.NewRemedyCircuit(clock, nil, 0, 0),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &remedyPlugins, nil
+}
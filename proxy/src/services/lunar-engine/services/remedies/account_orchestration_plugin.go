@@ -66,7 +66,8 @@ func modifyRequestToUseAccount(
 ) actions.ReqLunarAction {
 	headers := map[string]string{}
 	for _, token := range accountToUse.Tokens {
-		if value, found := onRequestArgs.Headers[token.Header.Name]; found {
+		if value, found := onRequestArgs.Header(
+			token.Header.Name, sharedConfig.MultiValueHeaderFirst); found {
 			if value == token.Header.Value {
 				continue // Token already present in request
 			}
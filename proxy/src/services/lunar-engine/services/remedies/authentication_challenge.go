@@ -0,0 +1,190 @@
+package remedies
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"lunar/engine/actions"
+	"lunar/engine/messages"
+	sharedConfig "lunar/shared-model/config"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// authFailureMode is the way a request failed the verification
+// of its client credentials
+type authFailureMode string
+
+const (
+	authMissingCredentials authFailureMode = "missing_credentials"
+	authInvalidCredentials authFailureMode = "invalid_credentials"
+	authExpiredCredentials authFailureMode = "expired_credentials"
+	authUnauthorized       authFailureMode = "unauthorized"
+
+	authSchemeBasic  = "basic"
+	authSchemeBearer = "bearer"
+
+	authorizationHeader   = "Authorization"
+	wwwAuthenticateHeader = "WWW-Authenticate"
+	defaultAuthRealm      = "lunar"
+
+	authFailureModeAttribute = "mode"
+)
+
+// defaultStatusCode is the status code a request failing the verification
+// this way is responded with, unless the challenge configures another one:
+// requests of unknown clients are unauthenticated (401), while requests of
+// known clients which may not call the endpoint are forbidden (403)
+func (mode authFailureMode) defaultStatusCode() int {
+	if mode == authUnauthorized {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+func (mode authFailureMode) response(
+	challenge *sharedConfig.AuthChallengeConfig,
+) sharedConfig.AuthChallengeResponse {
+	var response sharedConfig.AuthChallengeResponse
+	switch mode {
+	case authMissingCredentials:
+		response = challenge.MissingCredentials
+	case authInvalidCredentials:
+		response = challenge.InvalidCredentials
+	case authExpiredCredentials:
+		response = challenge.ExpiredCredentials
+	case authUnauthorized:
+		response = challenge.Unauthorized
+	}
+	return response
+}
+
+// verifyClientCredentials verifies the credentials the request carries in
+// its Authorization header against the clients of the challenge, returning
+// the way it failed if it did.
+// The credentials are compared against all the clients of their scheme in
+// constant time, so the time it takes does not tell how close they are to
+// the credentials of any client.
+func verifyClientCredentials(
+	onRequest messages.OnRequest,
+	challenge *sharedConfig.AuthChallengeConfig,
+	now time.Time,
+) (authFailureMode, bool) {
+	authorization := strings.TrimSpace(onRequest.Headers[authorizationHeader])
+	if authorization == "" {
+		return authMissingCredentials, false
+	}
+	scheme, credentials, _ := strings.Cut(authorization, " ")
+	scheme = strings.ToLower(scheme)
+	credentials = strings.TrimSpace(credentials)
+	if credentials == "" {
+		return authMissingCredentials, false
+	}
+	if scheme == authSchemeBasic {
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err != nil {
+			return authInvalidCredentials, false
+		}
+		credentials = string(decoded)
+	}
+
+	credentialsDigest := sha256.Sum256([]byte(credentials))
+	var matched *sharedConfig.AuthClient
+	for index := range challenge.Clients {
+		client := &challenge.Clients[index]
+		if client.Scheme != scheme {
+			continue
+		}
+		clientDigest := sha256.Sum256([]byte(clientCredentials(client)))
+		if subtle.ConstantTimeCompare(credentialsDigest[:], clientDigest[:]) == 1 &&
+			matched == nil {
+			matched = client
+		}
+	}
+
+	switch {
+	case matched == nil:
+		return authInvalidCredentials, false
+	case matched.ExpiresAt != nil && !now.Before(*matched.ExpiresAt):
+		return authExpiredCredentials, false
+	case !matched.IsAuthorized():
+		return authUnauthorized, false
+	}
+	return "", true
+}
+
+func clientCredentials(client *sharedConfig.AuthClient) string {
+	if client.Scheme == authSchemeBasic {
+		return client.Username + ":" + client.Password
+	}
+	return client.Token
+}
+
+// buildChallengeResponse builds the response to a request failing the
+// verification, challenging the client to authenticate by the schemes the
+// challenge accepts if the response is unauthenticated (401)
+func buildChallengeResponse(
+	challenge *sharedConfig.AuthChallengeConfig,
+	mode authFailureMode,
+) *actions.EarlyResponseAction {
+	response := mode.response(challenge)
+	status := response.StatusCode
+	if status == 0 {
+		status = mode.defaultStatusCode()
+	}
+	headers := map[string]string{}
+	body := response.Body
+	if body == "" {
+		body = fmt.Sprintf(`{"error": %q}`, mode)
+		headers["Content-Type"] = "application/json"
+	}
+	if status == http.StatusUnauthorized {
+		headers[wwwAuthenticateHeader] = buildAuthenticateChallenges(challenge, mode)
+	}
+	return &actions.EarlyResponseAction{
+		Status:  status,
+		Body:    body,
+		Headers: headers,
+	}
+}
+
+// buildAuthenticateChallenges builds a challenge of each scheme the challenge
+// accepts, in the order of its clients.
+// Bearer challenges tell the token was invalid or expired (RFC 6750), the
+// token being sent in the first place.
+func buildAuthenticateChallenges(
+	challenge *sharedConfig.AuthChallengeConfig,
+	mode authFailureMode,
+) string {
+	realm := challenge.Realm
+	if realm == "" {
+		realm = defaultAuthRealm
+	}
+	seen := map[string]bool{}
+	var challenges []string
+	for _, client := range challenge.Clients {
+		if seen[client.Scheme] {
+			continue
+		}
+		seen[client.Scheme] = true
+
+		switch client.Scheme {
+		case authSchemeBasic:
+			challenges = append(challenges,
+				fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, realm))
+		case authSchemeBearer:
+			bearer := fmt.Sprintf(`Bearer realm=%q`, realm)
+			switch mode { //nolint:exhaustive
+			case authInvalidCredentials:
+				bearer += `, error="invalid_token"`
+			case authExpiredCredentials:
+				bearer += `, error="invalid_token", ` +
+					`error_description="The access token expired"`
+			}
+			challenges = append(challenges, bearer)
+		}
+	}
+	return strings.Join(challenges, ", ")
+}
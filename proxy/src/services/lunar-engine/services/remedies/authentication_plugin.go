@@ -1,22 +1,40 @@
 package remedies
 
 import (
+	"context"
 	"fmt"
 	"lunar/engine/actions"
 	"lunar/engine/config"
 	"lunar/engine/messages"
 	"lunar/engine/services/authentication"
 	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
 
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 )
 
 type AuthPlugin struct {
-	auth *authentication.AuthMechanism
+	ctx              context.Context
+	clock            clock.Clock
+	auth             *authentication.AuthMechanism
+	rejectedRequests metric.Int64Counter
 }
 
-func NewAuthPlugin() *AuthPlugin {
-	return &AuthPlugin{authentication.NewAuthMechanism()}
+func NewAuthPlugin(
+	ctx context.Context,
+	clock clock.Clock,
+	meter metric.Meter,
+) *AuthPlugin {
+	plugin := &AuthPlugin{
+		ctx:              ctx,
+		clock:            clock,
+		auth:             authentication.NewAuthMechanism(),
+		rejectedRequests: nil,
+	}
+	plugin.rejectedRequests = plugin.initializeRejectedRequestsMetric(meter)
+	return plugin
 }
 
 func (plugin *AuthPlugin) OnRequest(
@@ -31,7 +49,18 @@ func (plugin *AuthPlugin) OnRequest(
 
 	log.Trace().Msgf("Starting authentication process for: %s - %s",
 		endpoint.Method, endpoint.URL)
-	accountID := scopedRemedy.Remedy.Config.Authentication.Account
+	authConfig := scopedRemedy.Remedy.Config.Authentication
+	if challenge := authConfig.Challenge; challenge != nil {
+		mode, verified := verifyClientCredentials(onRequest, challenge, plugin.clock.Now())
+		if !verified {
+			log.Trace().Msgf("Request %v failed the authentication challenge: %v",
+				onRequest.ID, mode)
+			plugin.incrementRejectedRequestsMetric(scopedRemedy.Remedy, mode)
+			return buildChallengeResponse(challenge, mode), nil
+		}
+	}
+
+	accountID := authConfig.Account
 
 	account, found := accounts[accountID]
 	if !found {
@@ -47,3 +76,34 @@ func (plugin *AuthPlugin) OnRequest(
 func (plugin *AuthPlugin) OnResponse() (actions.RespLunarAction, error) {
 	return &actions.NoOpAction{}, nil
 }
+
+func (plugin *AuthPlugin) initializeRejectedRequestsMetric(
+	meter metric.Meter,
+) metric.Int64Counter {
+	counter, err := meter.Int64Counter(
+		authRejectedRequestsInstrument.name,
+		authRejectedRequestsInstrument.withDescription(),
+		authRejectedRequestsInstrument.withUnit(),
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create authentication rejected requests metric")
+	}
+	return counter
+}
+
+func (plugin *AuthPlugin) incrementRejectedRequestsMetric(
+	remedy *sharedConfig.Remedy,
+	mode authFailureMode,
+) {
+	if plugin.rejectedRequests == nil {
+		return
+	}
+	plugin.rejectedRequests.Add(
+		plugin.ctx,
+		1,
+		metric.WithAttributes(
+			attribute.String(remedyAttribute, remedy.Name),
+			attribute.String(authFailureModeAttribute, string(mode)),
+		),
+	)
+}
@@ -1,6 +1,7 @@
 package remedies
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
@@ -10,11 +11,17 @@ import (
 	"lunar/shared-model/config"
 	sharedConfig "lunar/shared-model/config"
 	"lunar/toolkit-core/clock"
+	"lunar/toolkit-core/vacuum"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/metric"
 )
 
+const pendingContentHashesVacuumName = "CachingPendingContentHashesVacuum"
+
 type CachingPluginKey struct {
 	Method               string
 	URL                  string
@@ -24,15 +31,40 @@ type CachingPluginKey struct {
 type CachingPlugin struct {
 	responseCache utils.Cache[CachingPluginKey, CachedResponse]
 	clock         clock.Clock
+	metrics       *deduplicationMetrics
+
+	// content hashes of the requests which missed the cache, by transaction ID,
+	// until their response is stored. Transactions which never get a
+	// response are vacuumed after the proxy timeout
+	pendingContentHashes       map[string]string
+	pendingContentHashesMutex  *sync.RWMutex
+	pendingContentHashesVacuum *vacuum.MapVacuum[string, string]
 }
 
 func NewCachingPlugin(
 	clock clock.Clock,
+	proxyTimeout time.Duration,
+	meter metric.Meter,
 ) *CachingPlugin {
+	responseCache := utils.NewMemoryCache[CachingPluginKey, CachedResponse](clock)
+	pendingContentHashes := map[string]string{}
+	pendingContentHashesMutex := sync.RWMutex{}
+	pendingContentHashesVacuum := vacuum.NewMapVacuum(
+		pendingContentHashesVacuumName,
+		clock,
+		proxyTimeout,
+		vacuumTick,
+		pendingContentHashes,
+		&pendingContentHashesMutex,
+	)
 	return &CachingPlugin{
-		responseCache: utils.NewMemoryCache[CachingPluginKey,
-			CachedResponse](clock),
-		clock: clock,
+		responseCache: responseCache,
+		clock:         clock,
+		metrics: newDeduplicationMetrics(meter, cachingInstruments,
+			func() int64 { return int64(responseCache.Len()) }),
+		pendingContentHashes:       pendingContentHashes,
+		pendingContentHashesMutex:  &pendingContentHashesMutex,
+		pendingContentHashesVacuum: &pendingContentHashesVacuum,
 	}
 }
 
@@ -46,11 +78,36 @@ func (plugin *CachingPlugin) OnRequest(
 		extractHashedPathParams(pathParams, remedyConfig.RequestPayloadPaths),
 	}
 
+	// The method and URL are part of the caching key, so only the body tells
+	// requests of the same key apart. An empty body is taken as not buffered,
+	// so it is not compared, as the same request may or may not be buffered.
+	contentHash := ""
+	if onRequest.Body != "" {
+		contentHash = requestContentHash(
+			onRequest.Method, onRequest.URL, onRequest.Body)
+	}
 	cachedResponse, found := plugin.responseCache.Get(cachingKey)
 	if !found {
+		plugin.metrics.miss(context.Background())
+		plugin.pendingContentHashesMutex.Lock()
+		plugin.pendingContentHashes[onRequest.ID] = contentHash
+		plugin.pendingContentHashesMutex.Unlock()
+		plugin.pendingContentHashesVacuum.VacuumKey(onRequest.ID)
 		return &actions.NoOpAction{}, nil
 	}
 
+	plugin.metrics.hit(context.Background())
+	if cachedResponse.RequestContentHash != "" && contentHash != "" &&
+		cachedResponse.RequestContentHash != contentHash {
+		plugin.metrics.collision(context.Background())
+		log.Warn().
+			Str("requestID", onRequest.ID).
+			Str("cachedRequestID", cachedResponse.ID).
+			Msgf("Request to %v %v reuses the caching key of a request "+
+				"with a different content, check the configured payload paths",
+				onRequest.Method, onRequest.URL)
+	}
+
 	log.Debug().Msgf(
 		"📦 Serving cached response with status code %v",
 		cachedResponse.Status,
@@ -70,6 +127,11 @@ func (plugin *CachingPlugin) OnResponse(
 	remedyConfig *sharedConfig.CachingConfig,
 	pathParams map[string]string,
 ) (actions.RespLunarAction, error) {
+	plugin.pendingContentHashesMutex.Lock()
+	contentHash := plugin.pendingContentHashes[onResponse.ID]
+	delete(plugin.pendingContentHashes, onResponse.ID)
+	plugin.pendingContentHashesMutex.Unlock()
+
 	bodySize := len([]byte(onResponse.Body))
 	if bodySize > remedyConfig.MaxRecordSizeBytes {
 		log.Debug().Msgf("Response too big, received body size: %+v, "+
@@ -86,11 +148,12 @@ func (plugin *CachingPlugin) OnResponse(
 	}
 
 	cachedResponse := CachedResponse{
-		ID:           onResponse.ID,
-		Body:         onResponse.Body,
-		Headers:      onResponse.Headers,
-		Status:       onResponse.Status,
-		CreationTime: plugin.clock.Now(),
+		ID:                 onResponse.ID,
+		Body:               onResponse.Body,
+		Headers:            onResponse.Headers,
+		Status:             onResponse.Status,
+		CreationTime:       plugin.clock.Now(),
+		RequestContentHash: contentHash,
 	}
 
 	log.Info().Msgf(
@@ -149,6 +212,7 @@ func calculateSize(cachingKey CachingPluginKey,
 	// Calculate size for CachedResponse
 	size += int64(len(cachingValue.ID))
 	size += int64(len(cachingValue.Body))
+	size += int64(len(cachingValue.RequestContentHash))
 
 	for key, value := range cachingValue.Headers {
 		size += int64(len(key) + len(value)) // add the length of strings in the map
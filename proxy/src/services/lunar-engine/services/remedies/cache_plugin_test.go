@@ -11,6 +11,9 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
 )
 
 func TestCachePluginOnRequestWithNoCachedResponseReturnNoOpAction(
@@ -18,7 +21,7 @@ func TestCachePluginOnRequestWithNoCachedResponseReturnNoOpAction(
 ) {
 	t.Parallel()
 	clock := clock.NewMockClock()
-	plugin := remedies.NewCachingPlugin(clock)
+	plugin := newCachingPlugin(clock)
 	remedyConfig := basicCachingRemedyConfig()
 	onRequestArgs := onRequestArgs()
 
@@ -34,7 +37,7 @@ func TestCachingPluginOnRequestWithCachedResponseReturnEarlyResponseAction(
 ) {
 	t.Parallel()
 	clock := clock.NewMockClock()
-	plugin := remedies.NewCachingPlugin(clock)
+	plugin := newCachingPlugin(clock)
 	remedyConfig := basicCachingRemedyConfig()
 	onRequestArgs := onRequestArgs()
 	onResponseArgs := responseArgs(map[string]string{"Retry-After": "1"})
@@ -62,7 +65,7 @@ func TestCachingPluginOnRequestWithCachedResponseReturnEarlyResponseAction(
 func TestCachingResponseWithIrrelevantUserIDIsNotCached(t *testing.T) {
 	t.Parallel()
 	clock := clock.NewMockClock()
-	plugin := remedies.NewCachingPlugin(clock)
+	plugin := newCachingPlugin(clock)
 	remedyConfig := basicCachingRemedyConfig()
 	onResponseArgs := basicResponseArgs(404, "999",
 		map[string]string{"Retry-After": "1"})
@@ -108,7 +111,7 @@ func TestCachingResponseWithIrrelevantUserIDIsNotCached(t *testing.T) {
 func TestCacheResponseAfterTTLPassed(t *testing.T) {
 	t.Parallel()
 	clock := clock.NewMockClock()
-	plugin := remedies.NewCachingPlugin(clock)
+	plugin := newCachingPlugin(clock)
 	remedyConfig := cachingRemedyConfig(float32(5), 1000, 1000)
 
 	onResponseArgs := basicResponseArgs(200, "999", map[string]string{})
@@ -141,7 +144,7 @@ func TestCacheResponseAfterTTLPassed(t *testing.T) {
 func TestNotCachingResponsesWhichExceedMaxRecordSize(t *testing.T) {
 	t.Parallel()
 	clock := clock.NewMockClock()
-	plugin := remedies.NewCachingPlugin(clock)
+	plugin := newCachingPlugin(clock)
 	remedyConfig := cachingRemedyConfig(float32(5), 1, 1000)
 
 	onResponseArgs := basicResponseArgs(200, "success", map[string]string{})
@@ -165,7 +168,7 @@ func TestNotCachingResponsesWhichExceedMaxRecordSize(t *testing.T) {
 func TestCacheResponseCacheFull(t *testing.T) {
 	t.Parallel()
 	clock := clock.NewMockClock()
-	plugin := remedies.NewCachingPlugin(clock)
+	plugin := newCachingPlugin(clock)
 	remedyConfig := cachingRemedyConfig(float32(5), 10240, 0.1)
 
 	onResponseArgs := basicResponseArgs(200, generateBody(10240),
@@ -191,6 +194,71 @@ func TestCacheResponseCacheFull(t *testing.T) {
 	assert.Equal(t, &actions.NoOpAction{}, action)
 }
 
+func TestCachingPluginCountsHitsMissesAndCollisions(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	reader := sdkMetric.NewManualReader()
+	plugin := newCachingPluginWithMeter(clock,
+		sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test"))
+	remedyConfig := basicCachingRemedyConfig()
+	pathParams := map[string]string{"userID": "999"}
+	collect := func(measure string) int64 {
+		total := int64(0)
+		for _, value := range collectPerAttribute(t, reader,
+			"lunar_remedies.caching."+measure, "none") {
+			total += value
+		}
+		return total
+	}
+
+	action, err := plugin.OnRequest(onRequestArgs(), &remedyConfig, pathParams)
+	assert.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+	_, err = plugin.OnResponse(basicResponseArgs(200, "cached", map[string]string{}),
+		&remedyConfig, pathParams)
+	assert.Nil(t, err)
+
+	sameRequest := onRequestArgs()
+	sameRequest.ID = "same"
+	action, err = plugin.OnRequest(sameRequest, &remedyConfig, pathParams)
+	assert.Nil(t, err)
+	assert.IsType(t, &actions.EarlyResponseAction{}, action)
+	assert.Equal(t, int64(0), collect("collisions"))
+
+	// The same request whose body was not buffered is not a collision
+	unbufferedRequest := onRequestArgs()
+	unbufferedRequest.ID = "unbuffered"
+	unbufferedRequest.Body = ""
+	action, err = plugin.OnRequest(unbufferedRequest, &remedyConfig, pathParams)
+	assert.Nil(t, err)
+	assert.IsType(t, &actions.EarlyResponseAction{}, action)
+	assert.Equal(t, int64(0), collect("collisions"))
+
+	// The same key with a different body is served, but counted as a collision
+	collidingRequest := onRequestArgs()
+	collidingRequest.ID = "colliding"
+	collidingRequest.Body = "{ \"other\": \"json\" }"
+	action, err = plugin.OnRequest(collidingRequest, &remedyConfig, pathParams)
+	assert.Nil(t, err)
+	assert.IsType(t, &actions.EarlyResponseAction{}, action)
+
+	assert.Equal(t, int64(1), collect("misses"))
+	assert.Equal(t, int64(3), collect("hits"))
+	assert.Equal(t, int64(1), collect("collisions"))
+	assert.Equal(t, int64(1), collect("stored_entries"))
+}
+
+func newCachingPlugin(clock clock.Clock) *remedies.CachingPlugin {
+	return newCachingPluginWithMeter(clock, noop.NewMeterProvider().Meter("test"))
+}
+
+func newCachingPluginWithMeter(
+	clock clock.Clock,
+	meter metric.Meter,
+) *remedies.CachingPlugin {
+	return remedies.NewCachingPlugin(clock, queueProxyTimeout, meter)
+}
+
 func generateBody(sizeB int) string {
 	var buffer bytes.Buffer
 
@@ -1,13 +1,38 @@
 package remedies
 
 import (
+	"context"
 	"errors"
 	"lunar/engine/actions"
+	"lunar/engine/config"
+	"lunar/engine/messages"
+	sharedConfig "lunar/shared-model/config"
+	"math"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var ErrMissingConfig = errors.New("missing required remedy config")
 
+const (
+	requestIDVariable  = "request_id"
+	retryAfterVariable = "retry_after"
+
+	traceIDLogKey = "trace-id"
+)
+
+// rejectionDetails are the values substituted in the error template
+// a rejecting remedy references. RetryAfter is 0 when the remedy
+// cannot tell when the request may be admitted.
+type rejectionDetails struct {
+	RequestID  string
+	RetryAfter time.Duration
+}
+
 func plainTextTooManyRequestsAction(
 	statusCode int,
 ) actions.EarlyResponseAction {
@@ -20,10 +45,88 @@ func plainTextTooManyRequestsAction(
 	}
 }
 
+// tooManyRequestsAction builds the response of a remedy rejecting a request,
+// out of the error template it references, if any
+func tooManyRequestsAction(
+	remedy *sharedConfig.Remedy,
+	statusCode int,
+	details rejectionDetails,
+) actions.EarlyResponseAction {
+	if remedy == nil || remedy.ResolvedErrorTemplate == nil {
+		return plainTextTooManyRequestsAction(statusCode)
+	}
+	return errorTemplateAction(*remedy.ResolvedErrorTemplate, statusCode, details)
+}
+
+func errorTemplateAction(
+	template sharedConfig.ErrorTemplate,
+	statusCode int,
+	details rejectionDetails,
+) actions.EarlyResponseAction {
+	statusCode, headers, body := template.Render(statusCode, map[string]string{
+		requestIDVariable: details.RequestID,
+		retryAfterVariable: strconv.FormatInt(
+			int64(math.Ceil(details.RetryAfter.Seconds())), 10),
+	})
+	return actions.EarlyResponseAction{
+		Status:  statusCode,
+		Body:    body,
+		Headers: headers,
+	}
+}
+
+// withTraceID has a rejection log carry the ID of the trace the request is
+// traced in, so operators can find the trace of a rejected request.
+// The ID is left out unless the trace is sampled, as it would not be found.
+func withTraceID(event *zerolog.Event, onRequest messages.OnRequest) *zerolog.Event {
+	if !onRequest.SpanContext.IsSampled() {
+		return event
+	}
+	return event.Str(traceIDLogKey, onRequest.SpanContext.TraceID().String())
+}
+
+// tracedContext returns a copy of ctx carrying the span the request is traced
+// in, so measurements recorded with it may link to the trace as exemplars
+func tracedContext(ctx context.Context, onRequest messages.OnRequest) context.Context {
+	if !onRequest.SpanContext.IsValid() {
+		return ctx
+	}
+	return trace.ContextWithSpanContext(ctx, onRequest.SpanContext)
+}
+
+// untilNextWindow is the time left until the next window begins,
+// as windows are aligned to the epoch
+func untilNextWindow(now time.Time, windowSize time.Duration) time.Duration {
+	if windowSize <= 0 {
+		return 0
+	}
+	elapsed := now.Sub(time.Unix(0, 0)) % windowSize
+	return windowSize - elapsed
+}
+
 type CachedResponse struct {
 	ID           string
 	Body         string
 	Headers      map[string]string
 	Status       int
 	CreationTime time.Time
+	// RequestContentHash is the hash of the request the response was served
+	// for, if known, so requests reusing its key with a different content
+	// can be detected without keeping the request around
+	RequestContentHash string
+}
+
+// providerOf returns the provider (i.e. host) a request URL is sent to
+// targetOf is the URL the remedy protects for the request, the URL of the
+// request itself for remedies of global scope, which have no target
+func targetOf(scopedRemedy config.ScopedRemedy, onRequest messages.OnRequest) string {
+	if scopedRemedy.NormalizedURL != "" {
+		return scopedRemedy.NormalizedURL
+	}
+	return onRequest.URL
+}
+
+func providerOf(url string) string {
+	provider, _, _ := strings.Cut(url, "/")
+	return provider
 }
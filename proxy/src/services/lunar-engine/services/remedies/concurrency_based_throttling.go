@@ -5,8 +5,10 @@ import (
 	"lunar/engine/config"
 	"lunar/engine/messages"
 	"lunar/engine/utils/limit/concurrency"
+	sharedConfig "lunar/shared-model/config"
 	"lunar/toolkit-core/clock"
 	"lunar/toolkit-core/concurrentmap"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -22,8 +24,10 @@ func NewConcurrencyBasedThrottlingPlugin(
 	return &ConcurrencyBasedThrottlingPlugin{
 		limiters: concurrentmap.NewConcurrentMap[config.Endpoint,
 			concurrency.Limiter](),
+		isolatedLimiters: concurrentmap.NewConcurrentMap[config.Endpoint,
+			isolatedLimiter](),
 		transactionsInProgress: concurrentmap.NewConcurrentMap[
-			string, config.Endpoint](),
+			string, transactionInProgress](),
 		clock:        clock,
 		proxyTimeout: proxyTimeout,
 	}
@@ -32,9 +36,24 @@ func NewConcurrencyBasedThrottlingPlugin(
 type ConcurrencyBasedThrottlingPlugin struct {
 	limiters concurrentmap.ConcurrentMap[
 		config.Endpoint, concurrency.Limiter]
-	transactionsInProgress concurrentmap.ConcurrentMap[string, config.Endpoint]
-	clock                  clock.Clock
-	proxyTimeout           time.Duration
+	isolatedLimiters concurrentmap.ConcurrentMap[
+		config.Endpoint, isolatedLimiter]
+	transactionsInProgress concurrentmap.ConcurrentMap[
+		string, transactionInProgress]
+	clock        clock.Clock
+	proxyTimeout time.Duration
+}
+
+// isolatedLimiter limits the endpoints of a remedy isolating scopes,
+// along with the isolation it is partitioned by
+type isolatedLimiter struct {
+	limiter   *concurrency.IsolatedLimiter
+	isolation *sharedConfig.ConcurrencyScopeIsolation
+}
+
+type transactionInProgress struct {
+	endpoint   config.Endpoint
+	isIsolated bool
 }
 
 func (plugin *ConcurrencyBasedThrottlingPlugin) OnRequest(
@@ -51,6 +70,44 @@ func (plugin *ConcurrencyBasedThrottlingPlugin) OnRequest(
 		URL:    scopedRemedy.NormalizedURL,
 	}
 
+	var tookSlot bool
+	if isolation := remedyConfig.ScopeIsolation; isolation != nil {
+		endpointLimiter := plugin.isolatedEndpointLimiter(endpoint, isolation)
+		tookSlot = endpointLimiter.TryTakeSlot(
+			onRequest.ID, isolatedScope(onRequest, isolation))
+	} else {
+		endpointLimiter := plugin.endpointLimiter(endpoint, remedyConfig)
+		tookSlot = endpointLimiter.TryTakeSlot(onRequest.ID)
+	}
+
+	if tookSlot {
+		log.Trace().
+			Msgf("Concurrency based throttling managed to get slot for txn %s",
+				onRequest.ID)
+		plugin.transactionsInProgress.Assign(onRequest.ID, transactionInProgress{
+			endpoint:   endpoint,
+			isIsolated: remedyConfig.ScopeIsolation != nil,
+		})
+
+		return &actions.NoOpAction{}, nil
+	}
+
+	withTraceID(log.Trace(), onRequest).
+		Msgf("Concurrency based throttling couldn't get slot for txn %s",
+			onRequest.ID)
+
+	action := tooManyRequestsAction(
+		scopedRemedy.Remedy,
+		remedyConfig.ResponseStatusCode,
+		rejectionDetails{RequestID: onRequest.ID}, //nolint:exhaustruct
+	)
+	return &action, nil
+}
+
+func (plugin *ConcurrencyBasedThrottlingPlugin) endpointLimiter(
+	endpoint config.Endpoint,
+	remedyConfig *sharedConfig.ConcurrencyBasedThrottlingConfig,
+) concurrency.Limiter {
 	endpointLimiter, found := plugin.limiters.Lookup(endpoint)
 
 	if found {
@@ -69,22 +126,69 @@ func (plugin *ConcurrencyBasedThrottlingPlugin) OnRequest(
 		)
 		endpointLimiter = plugin.limiters.LookupOrAssign(endpoint, newLimiter)
 	}
+	return endpointLimiter
+}
 
-	if endpointLimiter.TryTakeSlot(onRequest.ID) {
-		log.Trace().
-			Msgf("Concurrency based throttling managed to get slot for txn %s",
-				onRequest.ID)
-		plugin.transactionsInProgress.Assign(onRequest.ID, endpoint)
+// isolatedEndpointLimiter is the endpoint's limiter partitioned by the
+// isolation, which is partitioned anew once the isolation is reloaded
+func (plugin *ConcurrencyBasedThrottlingPlugin) isolatedEndpointLimiter(
+	endpoint config.Endpoint,
+	isolation *sharedConfig.ConcurrencyScopeIsolation,
+) *concurrency.IsolatedLimiter {
+	endpointLimiter, found := plugin.isolatedLimiters.Lookup(endpoint)
+	if !found {
+		endpointLimiter = plugin.isolatedLimiters.LookupOrAssign(endpoint,
+			isolatedLimiter{
+				limiter: concurrency.NewIsolatedLimiter(
+					reservedSlotsByScope(isolation),
+					isolation.SharedSlots,
+					plugin.proxyTimeout,
+					vacuumTick,
+					plugin.clock,
+				),
+				isolation: isolation,
+			})
+	}
+	if endpointLimiter.isolation != isolation {
+		endpointLimiter.limiter.SetPartition(
+			reservedSlotsByScope(isolation), isolation.SharedSlots)
+		endpointLimiter.isolation = isolation
+		plugin.isolatedLimiters.Assign(endpoint, endpointLimiter)
+	}
+	return endpointLimiter.limiter
+}
 
-		return &actions.NoOpAction{}, nil
+func reservedSlotsByScope(
+	isolation *sharedConfig.ConcurrencyScopeIsolation,
+) map[string]int {
+	reservedSlots := map[string]int{}
+	for _, scope := range isolation.Scopes {
+		reservedSlots[scopeKey(scope)] += scope.ReservedSlots
 	}
+	return reservedSlots
+}
 
-	log.Trace().
-		Msgf("Concurrency based throttling couldn't get slot for txn %s",
-			onRequest.ID)
+func scopeKey(scope sharedConfig.ConcurrencyScope) string {
+	return scope.Method + " " + scope.URL
+}
 
-	action := plainTextTooManyRequestsAction(remedyConfig.ResponseStatusCode)
-	return &action, nil
+// isolatedScope is the key of the first scope the request is in, a scope
+// holding the requests to its URL or to the paths under it. Requests in none
+// of the scopes are keyed by an empty scope, which has no reserved slots.
+func isolatedScope(
+	onRequest messages.OnRequest,
+	isolation *sharedConfig.ConcurrencyScopeIsolation,
+) string {
+	for _, scope := range isolation.Scopes {
+		if scope.Method != "" && !strings.EqualFold(scope.Method, onRequest.Method) {
+			continue
+		}
+		url := strings.TrimSuffix(scope.URL, "/")
+		if onRequest.URL == url || strings.HasPrefix(onRequest.URL, url+"/") {
+			return scopeKey(scope)
+		}
+	}
+	return ""
 }
 
 func (plugin *ConcurrencyBasedThrottlingPlugin) OnResponse(
@@ -96,7 +200,7 @@ func (plugin *ConcurrencyBasedThrottlingPlugin) OnResponse(
 		return &actions.NoOpAction{}, ErrMissingConfig
 	}
 
-	endpoint, found := plugin.transactionsInProgress.Lookup(onResponse.ID)
+	transaction, found := plugin.transactionsInProgress.Lookup(onResponse.ID)
 
 	if !found {
 		log.Trace().
@@ -108,7 +212,12 @@ func (plugin *ConcurrencyBasedThrottlingPlugin) OnResponse(
 
 	plugin.transactionsInProgress.Delete(onResponse.ID)
 
-	limiter, found := plugin.limiters.Lookup(endpoint)
+	if transaction.isIsolated {
+		plugin.releaseIsolatedSlot(transaction.endpoint, onResponse.ID)
+		return &actions.NoOpAction{}, nil
+	}
+
+	limiter, found := plugin.limiters.Lookup(transaction.endpoint)
 	if !found {
 		log.Warn().
 			Msg("Endpoint limiter required but not found, " +
@@ -122,3 +231,21 @@ func (plugin *ConcurrencyBasedThrottlingPlugin) OnResponse(
 
 	return &actions.NoOpAction{}, nil
 }
+
+func (plugin *ConcurrencyBasedThrottlingPlugin) releaseIsolatedSlot(
+	endpoint config.Endpoint,
+	transactionID string,
+) {
+	endpointLimiter, found := plugin.isolatedLimiters.Lookup(endpoint)
+	if !found {
+		log.Warn().
+			Msg("Endpoint isolated limiter required but not found, " +
+				"limiter will not be released")
+		return
+	}
+
+	endpointLimiter.limiter.ReleaseSlot(transactionID)
+	log.Trace().
+		Msgf("Concurrency based throttling released isolated slot for txn %s",
+			transactionID)
+}
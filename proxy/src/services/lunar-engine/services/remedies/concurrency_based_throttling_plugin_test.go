@@ -221,3 +221,51 @@ func buildConcurrencyBasedThrottlingScopedRemedy(
 		Remedy:        &remedy,
 	}
 }
+
+func TestItKeepsTheReservedSlotsOfAScopeWhenAnotherScopeIsSaturated(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	proxyTimeout := 5 * time.Second
+	plugin := remedies.NewConcurrencyBasedThrottlingPlugin(clock, proxyTimeout)
+	scopedRemedy := buildConcurrencyBasedThrottlingScopedRemedy(0, 429)
+	scopedRemedy.NormalizedURL = ""
+	scopedRemedy.Remedy.Config.ConcurrencyBasedThrottling.ScopeIsolation =
+		&sharedConfig.ConcurrencyScopeIsolation{
+			Scopes: []sharedConfig.ConcurrencyScope{
+				// not in scope, as it is of another method
+				{URL: "test.com", Method: "POST", ReservedSlots: 1}, //nolint:exhaustruct
+				{URL: "test.com/search", ReservedSlots: 1},          //nolint:exhaustruct
+				{URL: "test.com/checkout", ReservedSlots: 1},        //nolint:exhaustruct
+			},
+			SharedSlots: 1,
+		}
+	requestTo := func(id string, url string) actions.ReqLunarAction {
+		onRequest := onRequestArgs()
+		onRequest.ID = id
+		onRequest.URL = url
+		action, err := plugin.OnRequest(onRequest, scopedRemedy)
+		assert.Nil(t, err)
+		return action
+	}
+
+	// search saturates its reserved slot and the shared one
+	assert.Equal(t, &actions.NoOpAction{}, requestTo("1", "test.com/search"))
+	assert.Equal(t, &actions.NoOpAction{}, requestTo("2", "test.com/search/items"))
+	assert.Equal(t, &earlyResponseAction, requestTo("3", "test.com/search"))
+	// requests in none of the scopes take shared slots only
+	assert.Equal(t, &earlyResponseAction, requestTo("4", "test.com/searches"))
+
+	// the other scope still gets its reserved slot
+	assert.Equal(t, &actions.NoOpAction{}, requestTo("5", "test.com/checkout"))
+	assert.Equal(t, &earlyResponseAction, requestTo("6", "test.com/checkout"))
+
+	// the released shared slot is available to every scope again
+	response := basicResponseArgs(200, "", map[string]string{})
+	response.ID = "2"
+	respAction, err := plugin.OnResponse(response, scopedRemedy)
+	assert.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, respAction)
+	assert.Equal(t, &actions.NoOpAction{}, requestTo("6", "test.com/checkout"))
+}
@@ -0,0 +1,365 @@
+package remedies
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lunar/engine/actions"
+	"lunar/engine/config"
+	"lunar/engine/messages"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	defaultDecisionHookTimeout            = 100 * time.Millisecond
+	defaultDecisionHookDeniedStatus       = http.StatusForbidden
+	defaultDecisionHookFailedClosedStatus = http.StatusServiceUnavailable
+	minDecisionStatus                     = 100
+	maxDecisionStatus                     = 599
+
+	decisionAttribute = "decision"
+)
+
+// DecisionVerdict is what a decision hook decided on a request
+type DecisionVerdict string
+
+const (
+	DecisionAllow  DecisionVerdict = "allow"
+	DecisionDeny   DecisionVerdict = "deny"
+	DecisionModify DecisionVerdict = "modify"
+)
+
+// decision hook outcomes which are not a verdict, for the decisions metric
+const (
+	decisionTimeout = "timeout"
+	decisionError   = "error"
+)
+
+var errDecisionHookTimeout = errors.New("decision hook did not decide in time")
+
+// DecisionHookRequest is the metadata of the request a decision hook decides on
+type DecisionHookRequest struct {
+	RemedyName string            `json:"remedy_name"`
+	ID         string            `json:"id"`
+	Method     string            `json:"method"`
+	URL        string            `json:"url"`
+	Path       string            `json:"path"`
+	Query      string            `json:"query"`
+	Headers    map[string]string `json:"headers"`
+}
+
+// Decision is what a decision hook decided on a request.
+// Denied requests are responded with the given status and body (if set),
+// modified requests proceed with the given headers set.
+type Decision struct {
+	Verdict      DecisionVerdict   `json:"decision"`
+	StatusCode   int               `json:"status_code"`
+	Body         string            `json:"body"`
+	HeadersToSet map[string]string `json:"headers_to_set"`
+}
+
+// DecisionHook decides whether requests are allowed, denied or modified,
+// for the decision hook remedies using it.
+// Decide is abandoned once its context is done, so it should return by then.
+type DecisionHook interface {
+	Decide(ctx context.Context, request DecisionHookRequest) (Decision, error)
+}
+
+var (
+	decisionHooksMutex sync.RWMutex
+	decisionHooks      = map[string]DecisionHook{}
+)
+
+// RegisterDecisionHook registers an in-process hook under the given name,
+// for decision hook remedies to use by it. Hooks are expected to be
+// registered as the engine starts, e.g. in the init of the package defining them.
+func RegisterDecisionHook(name string, hook DecisionHook) {
+	decisionHooksMutex.Lock()
+	defer decisionHooksMutex.Unlock()
+	decisionHooks[name] = hook
+}
+
+// IsDecisionHookRegistered returns whether a hook is registered under the name
+func IsDecisionHookRegistered(name string) bool {
+	_, found := registeredDecisionHook(name)
+	return found
+}
+
+func registeredDecisionHook(name string) (DecisionHook, bool) {
+	decisionHooksMutex.RLock()
+	defer decisionHooksMutex.RUnlock()
+	hook, found := decisionHooks[name]
+	return hook, found
+}
+
+// endpointDecisionHook is an out-of-process hook, which is posted the
+// request metadata as JSON and responds with the decision as JSON
+type endpointDecisionHook struct {
+	client   *http.Client
+	endpoint string
+}
+
+func (hook endpointDecisionHook) Decide(
+	ctx context.Context,
+	request DecisionHookRequest,
+) (Decision, error) {
+	content, err := json.Marshal(request)
+	if err != nil {
+		return Decision{}, err //nolint:exhaustruct
+	}
+	hookRequest, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, hook.endpoint, bytes.NewReader(content))
+	if err != nil {
+		return Decision{}, err //nolint:exhaustruct
+	}
+	hookRequest.Header.Set("Content-Type", "application/json")
+	response, err := hook.client.Do(hookRequest)
+	if err != nil {
+		return Decision{}, err //nolint:exhaustruct
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return Decision{}, fmt.Errorf( //nolint:exhaustruct
+			"decision hook responded with status code %d", response.StatusCode)
+	}
+	var decision Decision
+	if err := json.NewDecoder(response.Body).Decode(&decision); err != nil {
+		return Decision{}, fmt.Errorf( //nolint:exhaustruct
+			"failed to decode decision: %w", err)
+	}
+	return decision, nil
+}
+
+// DecisionHookPlugin consults the decision hook of its remedies on requests.
+// A hook which errors, or does not decide within the remedy's timeout,
+// fails open or closed as the remedy is configured to.
+type DecisionHookPlugin struct {
+	ctx       context.Context
+	clock     clock.Clock
+	client    *http.Client
+	decisions metric.Int64Counter
+}
+
+func NewDecisionHookPlugin(
+	ctx context.Context,
+	clock clock.Clock,
+	meter metric.Meter,
+) *DecisionHookPlugin {
+	plugin := &DecisionHookPlugin{
+		ctx:       ctx,
+		clock:     clock,
+		client:    &http.Client{}, //nolint:exhaustruct
+		decisions: nil,
+	}
+	if meter == nil {
+		return plugin
+	}
+	decisions, err := meter.Int64Counter(
+		decisionHookDecisionsInstrument.name,
+		decisionHookDecisionsInstrument.withDescription(),
+		decisionHookDecisionsInstrument.withUnit(),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create %s metric",
+			decisionHookDecisionsInstrument.name)
+	}
+	plugin.decisions = decisions
+	return plugin
+}
+
+// SetHookClient sets the client hook endpoints are called with,
+// so hook calls reuse pooled connections
+func (plugin *DecisionHookPlugin) SetHookClient(client *http.Client) {
+	if client == nil {
+		return
+	}
+	plugin.client = client
+}
+
+func (plugin *DecisionHookPlugin) OnRequest(
+	onRequest messages.OnRequest,
+	scopedRemedy config.ScopedRemedy,
+) (actions.ReqLunarAction, error) {
+	remedy := scopedRemedy.Remedy
+	remedyConfig := remedy.Config.DecisionHook
+	if remedyConfig == nil {
+		return &actions.NoOpAction{}, ErrMissingConfig
+	}
+
+	decision, err := plugin.decide(onRequest, remedy.Name, remedyConfig)
+	if err != nil {
+		outcome := decisionError
+		if errors.Is(err, errDecisionHookTimeout) {
+			outcome = decisionTimeout
+		}
+		plugin.countDecision(onRequest, remedy, outcome)
+		withTraceID(log.Warn(), onRequest).Err(err).Str("remedy", remedy.Name).
+			Bool("fail-open", remedyConfig.FailOpen).
+			Msg("Decision hook failed")
+		if remedyConfig.FailOpen {
+			return &actions.NoOpAction{}, nil
+		}
+		status := remedyConfig.ResponseStatusCode
+		if status == 0 {
+			status = defaultDecisionHookFailedClosedStatus
+		}
+		return deniedAction(remedy, onRequest.ID, Decision{ //nolint:exhaustruct
+			Verdict:    DecisionDeny,
+			StatusCode: status,
+		}), nil
+	}
+
+	plugin.countDecision(onRequest, remedy, string(decision.Verdict))
+	switch decision.Verdict {
+	case DecisionDeny:
+		if decision.StatusCode == 0 {
+			decision.StatusCode = remedyConfig.ResponseStatusCode
+		}
+		if decision.StatusCode == 0 {
+			decision.StatusCode = defaultDecisionHookDeniedStatus
+		}
+		withTraceID(log.Trace(), onRequest).Str("requestID", onRequest.ID).
+			Str("remedy", remedy.Name).
+			Msg("decision hook denied the request, will return early response")
+		return deniedAction(remedy, onRequest.ID, decision), nil
+	case DecisionModify:
+		return &actions.ModifyRequestAction{ //nolint:exhaustruct
+			HeadersToSet: decision.HeadersToSet,
+		}, nil
+	default:
+		return &actions.NoOpAction{}, nil
+	}
+}
+
+func (plugin *DecisionHookPlugin) OnResponse() (actions.RespLunarAction, error) {
+	return &actions.NoOpAction{}, nil
+}
+
+// decide consults the remedy's hook, abandoning it once the timeout passed
+func (plugin *DecisionHookPlugin) decide(
+	onRequest messages.OnRequest,
+	remedyName string,
+	remedyConfig *sharedConfig.DecisionHookConfig,
+) (Decision, error) {
+	hook, err := plugin.hookOf(remedyConfig)
+	if err != nil {
+		return Decision{}, err //nolint:exhaustruct
+	}
+	timeout := time.Duration(remedyConfig.TimeoutMilliseconds) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultDecisionHookTimeout
+	}
+
+	ctx, cancel := context.WithCancel(plugin.ctx)
+	defer cancel()
+	type result struct {
+		decision Decision
+		err      error
+	}
+	// the headers are copied, as an abandoned hook may still read them
+	// while later remedies modify the request
+	headers := make(map[string]string, len(onRequest.Headers))
+	for name, value := range onRequest.Headers {
+		headers[name] = value
+	}
+	request := DecisionHookRequest{
+		RemedyName: remedyName,
+		ID:         onRequest.ID,
+		Method:     onRequest.Method,
+		URL:        onRequest.URL,
+		Path:       onRequest.Path,
+		Query:      onRequest.Query,
+		Headers:    headers,
+	}
+	results := make(chan result, 1)
+	go func() {
+		decision, err := hook.Decide(ctx, request)
+		results <- result{decision: decision, err: err}
+	}()
+
+	select {
+	case result := <-results:
+		if result.err != nil {
+			return Decision{}, result.err //nolint:exhaustruct
+		}
+		switch result.decision.Verdict {
+		case DecisionDeny:
+			status := result.decision.StatusCode
+			if status != 0 && (status < minDecisionStatus || status > maxDecisionStatus) {
+				return Decision{}, fmt.Errorf( //nolint:exhaustruct
+					"decision hook denied with an invalid status code %d", status)
+			}
+			return result.decision, nil
+		case DecisionAllow, DecisionModify:
+			return result.decision, nil
+		default:
+			return Decision{}, fmt.Errorf( //nolint:exhaustruct
+				"decision hook decided on an unknown verdict '%v'",
+				result.decision.Verdict)
+		}
+	case <-plugin.clock.After(timeout):
+		return Decision{}, errDecisionHookTimeout //nolint:exhaustruct
+	}
+}
+
+func (plugin *DecisionHookPlugin) hookOf(
+	remedyConfig *sharedConfig.DecisionHookConfig,
+) (DecisionHook, error) {
+	if remedyConfig.Endpoint != "" {
+		return endpointDecisionHook{
+			client:   plugin.client,
+			endpoint: remedyConfig.Endpoint,
+		}, nil
+	}
+	hook, found := registeredDecisionHook(remedyConfig.Hook)
+	if !found {
+		return nil, fmt.Errorf("decision hook '%v' is not registered",
+			remedyConfig.Hook)
+	}
+	return hook, nil
+}
+
+func deniedAction(
+	remedy *sharedConfig.Remedy,
+	requestID string,
+	decision Decision,
+) *actions.EarlyResponseAction {
+	if decision.Body == "" && remedy.ResolvedErrorTemplate != nil {
+		action := errorTemplateAction(*remedy.ResolvedErrorTemplate,
+			decision.StatusCode, rejectionDetails{RequestID: requestID}) //nolint:exhaustruct
+		return &action
+	}
+	body := decision.Body
+	if body == "" {
+		body = http.StatusText(decision.StatusCode)
+	}
+	return &actions.EarlyResponseAction{
+		Status:  decision.StatusCode,
+		Body:    body,
+		Headers: map[string]string{contentTypeHeaderName: "text/plain"},
+	}
+}
+
+func (plugin *DecisionHookPlugin) countDecision(
+	onRequest messages.OnRequest,
+	remedy *sharedConfig.Remedy,
+	decision string,
+) {
+	if plugin.decisions == nil {
+		return
+	}
+	plugin.decisions.Add(tracedContext(plugin.ctx, onRequest), 1, metric.WithAttributes(
+		attribute.String(remedyAttribute, remedy.Name),
+		attribute.String(decisionAttribute, decision),
+	))
+}
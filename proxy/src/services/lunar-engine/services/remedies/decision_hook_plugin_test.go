@@ -0,0 +1,250 @@
+package remedies_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"lunar/engine/actions"
+	"lunar/engine/config"
+	"lunar/engine/services/remedies"
+	"lunar/engine/utils"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+type decisionHookFunc func(
+	ctx context.Context,
+	request remedies.DecisionHookRequest,
+) (remedies.Decision, error)
+
+func (hook decisionHookFunc) Decide(
+	ctx context.Context,
+	request remedies.DecisionHookRequest,
+) (remedies.Decision, error) {
+	return hook(ctx, request)
+}
+
+func buildDecisionHookScopedRemedy(
+	remedyConfig *sharedConfig.DecisionHookConfig,
+) config.ScopedRemedy {
+	return config.ScopedRemedy{
+		Scope:         utils.ScopeEndpoint,
+		Method:        "GET",
+		NormalizedURL: "test.com/some/path",
+		Remedy: &sharedConfig.Remedy{
+			Name: "hook",
+			Config: sharedConfig.RemedyConfig{
+				DecisionHook: remedyConfig,
+			},
+		},
+	}
+}
+
+func TestDecisionHookAppliesTheDecisionOfARegisteredHook(t *testing.T) {
+	t.Parallel()
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	plugin := remedies.NewDecisionHookPlugin(
+		context.Background(), clock.NewMockClock(), meter)
+	remedies.RegisterDecisionHook("by-header", decisionHookFunc(
+		func(_ context.Context, request remedies.DecisionHookRequest) (
+			remedies.Decision, error,
+		) {
+			assert.Equal(t, "hook", request.RemedyName)
+			assert.Equal(t, "/some/path", request.Path)
+			switch request.Headers["X-Verdict"] {
+			case "deny":
+				return remedies.Decision{ //nolint:exhaustruct
+					Verdict:    remedies.DecisionDeny,
+					StatusCode: http.StatusTooManyRequests,
+				}, nil
+			case "modify":
+				return remedies.Decision{ //nolint:exhaustruct
+					Verdict:      remedies.DecisionModify,
+					HeadersToSet: map[string]string{"X-Tier": "gold"},
+				}, nil
+			default:
+				return remedies.Decision{Verdict: remedies.DecisionAllow}, nil //nolint:exhaustruct
+			}
+		}))
+	scopedRemedy := buildDecisionHookScopedRemedy(
+		&sharedConfig.DecisionHookConfig{Hook: "by-header"}) //nolint:exhaustruct
+	requestWithVerdict := func(verdict string) (actions.ReqLunarAction, error) {
+		onRequest := onRequestArgs()
+		onRequest.Headers = map[string]string{"X-Verdict": verdict}
+		return plugin.OnRequest(onRequest, scopedRemedy)
+	}
+
+	action, err := requestWithVerdict("allow")
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+
+	action, err = requestWithVerdict("deny")
+	require.Nil(t, err)
+	assert.Equal(t, &actions.EarlyResponseAction{
+		Status:  http.StatusTooManyRequests,
+		Body:    "Too Many Requests",
+		Headers: map[string]string{"Content-Type": "text/plain"},
+	}, action)
+
+	action, err = requestWithVerdict("modify")
+	require.Nil(t, err)
+	assert.Equal(t, &actions.ModifyRequestAction{ //nolint:exhaustruct
+		HeadersToSet: map[string]string{"X-Tier": "gold"},
+	}, action)
+
+	assert.Equal(t, map[string]int64{"allow": 1, "deny": 1, "modify": 1},
+		collectPerAttribute(t, reader,
+			"lunar_remedies.decision_hook.decisions", "decision"))
+}
+
+func TestDecisionHookFailsOpenOrClosedWhenTheHookErrors(t *testing.T) {
+	t.Parallel()
+	plugin := remedies.NewDecisionHookPlugin(
+		context.Background(), clock.NewMockClock(), nil)
+	remedies.RegisterDecisionHook("failing", decisionHookFunc(
+		func(context.Context, remedies.DecisionHookRequest) (remedies.Decision, error) {
+			return remedies.Decision{}, errors.New("failed") //nolint:exhaustruct
+		}))
+
+	action, err := plugin.OnRequest(onRequestArgs(), buildDecisionHookScopedRemedy(
+		&sharedConfig.DecisionHookConfig{Hook: "failing", FailOpen: true})) //nolint:exhaustruct
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+
+	action, err = plugin.OnRequest(onRequestArgs(), buildDecisionHookScopedRemedy(
+		&sharedConfig.DecisionHookConfig{Hook: "failing"})) //nolint:exhaustruct
+	require.Nil(t, err)
+	require.IsType(t, &actions.EarlyResponseAction{}, action)
+	assert.Equal(t, http.StatusServiceUnavailable,
+		action.(*actions.EarlyResponseAction).Status)
+
+	// A hook which is not registered fails as well
+	action, err = plugin.OnRequest(onRequestArgs(), buildDecisionHookScopedRemedy(
+		&sharedConfig.DecisionHookConfig{Hook: "missing", FailOpen: true})) //nolint:exhaustruct
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+}
+
+func TestDecisionHookFailsWhenTheHookDeniesWithAnInvalidStatus(t *testing.T) {
+	t.Parallel()
+	plugin := remedies.NewDecisionHookPlugin(
+		context.Background(), clock.NewMockClock(), nil)
+	remedies.RegisterDecisionHook("invalid-status", decisionHookFunc(
+		func(context.Context, remedies.DecisionHookRequest) (remedies.Decision, error) {
+			return remedies.Decision{ //nolint:exhaustruct
+				Verdict:    remedies.DecisionDeny,
+				StatusCode: 1000,
+			}, nil
+		}))
+
+	action, err := plugin.OnRequest(onRequestArgs(), buildDecisionHookScopedRemedy(
+		&sharedConfig.DecisionHookConfig{Hook: "invalid-status"})) //nolint:exhaustruct
+	require.Nil(t, err)
+	require.IsType(t, &actions.EarlyResponseAction{}, action)
+	assert.Equal(t, http.StatusServiceUnavailable,
+		action.(*actions.EarlyResponseAction).Status)
+
+	action, err = plugin.OnRequest(onRequestArgs(), buildDecisionHookScopedRemedy(
+		&sharedConfig.DecisionHookConfig{ //nolint:exhaustruct
+			Hook:     "invalid-status",
+			FailOpen: true,
+		}))
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+}
+
+func TestDecisionHookFailsClosedWhenTheHookTimesOut(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	plugin := remedies.NewDecisionHookPlugin(context.Background(), clock, nil)
+	abandoned := make(chan struct{})
+	remedies.RegisterDecisionHook("slow", decisionHookFunc(
+		func(ctx context.Context, _ remedies.DecisionHookRequest) (
+			remedies.Decision, error,
+		) {
+			<-ctx.Done()
+			close(abandoned)
+			return remedies.Decision{Verdict: remedies.DecisionAllow}, nil //nolint:exhaustruct
+		}))
+	scopedRemedy := buildDecisionHookScopedRemedy(&sharedConfig.DecisionHookConfig{ //nolint:exhaustruct
+		Hook:                "slow",
+		TimeoutMilliseconds: 50,
+		ResponseStatusCode:  http.StatusTooManyRequests,
+	})
+
+	results := make(chan actions.ReqLunarAction, 1)
+	go func() {
+		action, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+		assert.Nil(t, err)
+		results <- action
+	}()
+
+	var action actions.ReqLunarAction
+	require.Eventually(t, func() bool {
+		clock.AdvanceTime(50 * time.Millisecond)
+		select {
+		case action = <-results:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	require.IsType(t, &actions.EarlyResponseAction{}, action)
+	assert.Equal(t, http.StatusTooManyRequests,
+		action.(*actions.EarlyResponseAction).Status)
+	// The hook's context is done once it was abandoned
+	require.Eventually(t, func() bool {
+		select {
+		case <-abandoned:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+}
+
+func TestDecisionHookCallsTheConfiguredEndpoint(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			var hookRequest remedies.DecisionHookRequest
+			require.NoError(t, json.NewDecoder(request.Body).Decode(&hookRequest))
+			if hookRequest.Method != http.MethodGet {
+				writer.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = writer.Write([]byte(`{"decision": "deny", "body": "Not today"}`))
+		}))
+	defer server.Close()
+	plugin := remedies.NewDecisionHookPlugin(
+		context.Background(), clock.NewRealClock(), nil)
+	scopedRemedy := buildDecisionHookScopedRemedy(&sharedConfig.DecisionHookConfig{ //nolint:exhaustruct
+		Endpoint:            server.URL,
+		TimeoutMilliseconds: 5000,
+	})
+
+	action, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+	assert.Equal(t, &actions.EarlyResponseAction{
+		Status:  http.StatusForbidden,
+		Body:    "Not today",
+		Headers: map[string]string{"Content-Type": "text/plain"},
+	}, action)
+
+	// The endpoint failing to decide fails open
+	scopedRemedy.Remedy.Config.DecisionHook.FailOpen = true
+	onRequest := onRequestArgs()
+	onRequest.Method = http.MethodPost
+	action, err = plugin.OnRequest(onRequest, scopedRemedy)
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+}
@@ -0,0 +1,86 @@
+package remedies
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// deduplicationMetrics counts how a remedy serving stored responses decided
+// the requests it handled, and reports the number of responses it stores
+type deduplicationMetrics struct {
+	hits       metric.Int64Counter
+	misses     metric.Int64Counter
+	collisions metric.Int64Counter
+}
+
+func newDeduplicationMetrics(
+	meter metric.Meter,
+	instruments deduplicationInstruments,
+	storedEntries func() int64,
+) *deduplicationMetrics {
+	counter := func(definition instrumentDefinition) metric.Int64Counter {
+		counter, err := meter.Int64Counter(
+			definition.name,
+			definition.withDescription(),
+			definition.withUnit(),
+		)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to create %s metric", definition.name)
+		}
+		return counter
+	}
+	_, err := meter.Int64ObservableGauge(
+		instruments.storedEntries.name,
+		instruments.storedEntries.withDescription(),
+		instruments.storedEntries.withUnit(),
+		metric.WithInt64Callback(func(
+			_ context.Context,
+			observer metric.Int64Observer,
+		) error {
+			observer.Observe(storedEntries())
+			return nil
+		}),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create %s metric",
+			instruments.storedEntries.name)
+	}
+	return &deduplicationMetrics{
+		hits:       counter(instruments.hits),
+		misses:     counter(instruments.misses),
+		collisions: counter(instruments.collisions),
+	}
+}
+
+func (metrics *deduplicationMetrics) hit(ctx context.Context) {
+	if metrics.hits != nil {
+		metrics.hits.Add(ctx, 1)
+	}
+}
+
+func (metrics *deduplicationMetrics) miss(ctx context.Context) {
+	if metrics.misses != nil {
+		metrics.misses.Add(ctx, 1)
+	}
+}
+
+func (metrics *deduplicationMetrics) collision(ctx context.Context) {
+	if metrics.collisions != nil {
+		metrics.collisions.Add(ctx, 1)
+	}
+}
+
+// requestContentHash hashes the parts of a request which make up its content,
+// so requests can be compared without keeping their bodies
+func requestContentHash(method string, url string, body string) string {
+	hash := sha256.New()
+	for _, part := range []string{method, url, body} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,169 @@
+package remedies
+
+import (
+	"context"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	// DefaultDynamicQuotasMaxAge is how long the quotas of a dynamic source
+	// apply since they were last set, before falling back to static quotas
+	DefaultDynamicQuotasMaxAge = 5 * time.Minute
+
+	dynamicQuotaSourceAttribute = "source"
+	dynamicQuotaSourceDynamic   = "dynamic"
+	dynamicQuotaSourceStatic    = "static"
+)
+
+// effectiveQuota is the allowed request count a remedy applies
+// until the end of its window
+type effectiveQuota struct {
+	allowedRequestCount int64
+	isDynamic           bool
+	windowSize          time.Duration
+	windowEnd           time.Time
+}
+
+// DynamicQuotas holds the quotas set by a dynamic source (Lunar Hub or a
+// polled endpoint), which override the allowed request count of remedies
+// with a dynamic quota. The quota of a remedy is resolved once per window,
+// so a changed quota applies from the next window, and the requests already
+// admitted or queued are not affected. Once the source did not set the
+// quotas for maxAge (e.g. it is unavailable), the static quotas apply.
+type DynamicQuotas struct {
+	clock  clock.Clock
+	maxAge time.Duration
+
+	mutex     sync.Mutex
+	quotas    map[string]int64
+	updatedAt time.Time
+	effective map[string]effectiveQuota
+}
+
+func NewDynamicQuotas(
+	clock clock.Clock,
+	meter metric.Meter,
+	maxAge time.Duration,
+) *DynamicQuotas {
+	dynamicQuotas := &DynamicQuotas{
+		clock:     clock,
+		maxAge:    maxAge,
+		mutex:     sync.Mutex{},
+		quotas:    map[string]int64{},
+		updatedAt: time.Time{},
+		effective: map[string]effectiveQuota{},
+	}
+	if meter == nil {
+		return dynamicQuotas
+	}
+	_, err := meter.Int64ObservableGauge(
+		dynamicQuotaAllowedRequestsInstrument.name,
+		dynamicQuotaAllowedRequestsInstrument.withDescription(),
+		dynamicQuotaAllowedRequestsInstrument.withUnit(),
+		metric.WithInt64Callback(dynamicQuotas.observeEffective),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create %s metric",
+			dynamicQuotaAllowedRequestsInstrument.name)
+	}
+	return dynamicQuotas
+}
+
+// Set replaces the quotas of the dynamic source, by their keys
+func (dynamicQuotas *DynamicQuotas) Set(quotas map[string]int64) {
+	if dynamicQuotas == nil {
+		return
+	}
+	dynamicQuotas.mutex.Lock()
+	defer dynamicQuotas.mutex.Unlock()
+	dynamicQuotas.quotas = make(map[string]int64, len(quotas))
+	for key, quota := range quotas {
+		dynamicQuotas.quotas[key] = quota
+	}
+	dynamicQuotas.updatedAt = dynamicQuotas.clock.Now()
+	log.Debug().Msgf("Set %d dynamic quotas", len(quotas))
+}
+
+// AllowedRequestCount returns the allowed request count the remedy (or the
+// shared limit group of remedies, by its name) applies in its current window:
+// the quota of its dynamic quota's key, bounded by its min and max, if the
+// source has a fresh one, or its static count
+func (dynamicQuotas *DynamicQuotas) AllowedRequestCount(
+	remedyName string,
+	staticCount int64,
+	dynamicQuota *sharedConfig.DynamicQuota,
+	windowSize time.Duration,
+) int64 {
+	if dynamicQuotas == nil || dynamicQuota == nil {
+		return staticCount
+	}
+	now := dynamicQuotas.clock.Now()
+	dynamicQuotas.mutex.Lock()
+	defer dynamicQuotas.mutex.Unlock()
+	if current, found := dynamicQuotas.effective[remedyName]; found &&
+		current.windowSize == windowSize && now.Before(current.windowEnd) {
+		return current.allowedRequestCount
+	}
+
+	resolved := effectiveQuota{
+		allowedRequestCount: staticCount,
+		isDynamic:           false,
+		windowSize:          windowSize,
+		windowEnd:           now.Add(untilNextWindow(now, windowSize)),
+	}
+	quota, found := dynamicQuotas.quotas[dynamicQuota.Key]
+	if found && now.Sub(dynamicQuotas.updatedAt) < dynamicQuotas.maxAge {
+		resolved.allowedRequestCount = boundDynamicQuota(quota, dynamicQuota)
+		resolved.isDynamic = true
+	}
+	if previous, found := dynamicQuotas.effective[remedyName]; !found ||
+		previous.allowedRequestCount != resolved.allowedRequestCount {
+		log.Debug().Str("remedy", remedyName).
+			Bool("dynamic", resolved.isDynamic).
+			Msgf("Allowed request count is %d", resolved.allowedRequestCount)
+	}
+	dynamicQuotas.effective[remedyName] = resolved
+	return resolved.allowedRequestCount
+}
+
+func boundDynamicQuota(quota int64, dynamicQuota *sharedConfig.DynamicQuota) int64 {
+	if quota < dynamicQuota.MinAllowedRequestCount {
+		return dynamicQuota.MinAllowedRequestCount
+	}
+	if quota > dynamicQuota.MaxAllowedRequestCount {
+		return dynamicQuota.MaxAllowedRequestCount
+	}
+	return quota
+}
+
+// observeEffective reports the quotas of the remedies which resolved them
+// in their current or previous window, so removed remedies are not reported
+func (dynamicQuotas *DynamicQuotas) observeEffective(
+	_ context.Context,
+	observer metric.Int64Observer,
+) error {
+	now := dynamicQuotas.clock.Now()
+	dynamicQuotas.mutex.Lock()
+	defer dynamicQuotas.mutex.Unlock()
+	for remedyName, effective := range dynamicQuotas.effective {
+		if !now.Before(effective.windowEnd.Add(effective.windowSize)) {
+			continue
+		}
+		source := dynamicQuotaSourceStatic
+		if effective.isDynamic {
+			source = dynamicQuotaSourceDynamic
+		}
+		observer.Observe(effective.allowedRequestCount, metric.WithAttributes(
+			attribute.String(remedyAttribute, remedyName),
+			attribute.String(dynamicQuotaSourceAttribute, source),
+		))
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package remedies
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"lunar/toolkit-core/clock"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	dynamicQuotasFailuresMetricName = "lunar_remedies.dynamic_quota.failed_fetches"
+	dynamicQuotasRequestTimeout     = 10 * time.Second
+)
+
+// dynamicQuotasResponse is the content of the polled endpoint,
+// as of the dynamic quotas event of Lunar Hub
+type dynamicQuotasResponse struct {
+	Quotas map[string]int64 `json:"quotas"`
+}
+
+// DynamicQuotasPoller polls an HTTP endpoint for the dynamic quotas, and sets
+// them whenever fetched. Failed fetches keep the last quotas, which no longer
+// apply once their max age passed.
+type DynamicQuotasPoller struct {
+	clock         clock.Clock
+	client        *http.Client
+	url           string
+	interval      time.Duration
+	dynamicQuotas *DynamicQuotas
+
+	failedFetches  metric.Int64Counter
+	stopChannel    chan struct{}
+	stopOnce       sync.Once
+	runningContext context.Context
+	cancel         context.CancelFunc
+}
+
+func NewDynamicQuotasPoller(
+	clock clock.Clock,
+	url string,
+	interval time.Duration,
+	meter metric.Meter,
+	dynamicQuotas *DynamicQuotas,
+) *DynamicQuotasPoller {
+	failedFetches, err := meter.Int64Counter(
+		dynamicQuotasFailuresMetricName,
+		metric.WithDescription("The number of failed fetches of the dynamic quotas"),
+		metric.WithUnit("{fetch}"),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create %s metric",
+			dynamicQuotasFailuresMetricName)
+	}
+	runningContext, cancel := context.WithCancel(context.Background())
+	return &DynamicQuotasPoller{
+		clock:          clock,
+		client:         &http.Client{Timeout: dynamicQuotasRequestTimeout},
+		url:            url,
+		interval:       interval,
+		dynamicQuotas:  dynamicQuotas,
+		failedFetches:  failedFetches,
+		stopChannel:    make(chan struct{}),
+		stopOnce:       sync.Once{},
+		runningContext: runningContext,
+		cancel:         cancel,
+	}
+}
+
+// Run polls the endpoint in the background, until stopped
+func (poller *DynamicQuotasPoller) Run() {
+	log.Info().Msgf("Polling dynamic quotas from %s every %v",
+		poller.url, poller.interval)
+	go func() {
+		for {
+			if err := poller.Poll(); err != nil {
+				log.Warn().Err(err).Msg("Failed to update dynamic quotas")
+			}
+			select {
+			case <-poller.stopChannel:
+				return
+			case <-poller.clock.After(poller.interval):
+			}
+		}
+	}()
+}
+
+func (poller *DynamicQuotasPoller) Stop() {
+	poller.stopOnce.Do(func() {
+		poller.cancel()
+		close(poller.stopChannel)
+	})
+}
+
+// Poll fetches the dynamic quotas once, and sets them
+func (poller *DynamicQuotasPoller) Poll() error {
+	quotas, err := poller.fetch()
+	if err != nil {
+		if poller.failedFetches != nil {
+			poller.failedFetches.Add(context.Background(), 1)
+		}
+		return err
+	}
+	poller.dynamicQuotas.Set(quotas)
+	return nil
+}
+
+func (poller *DynamicQuotasPoller) fetch() (map[string]int64, error) {
+	request, err := http.NewRequestWithContext(
+		poller.runningContext, http.MethodGet, poller.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := poller.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch dynamic quotas: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch dynamic quotas, "+
+			"status code: %d", response.StatusCode)
+	}
+	var content dynamicQuotasResponse
+	if err := json.NewDecoder(response.Body).Decode(&content); err != nil {
+		return nil, fmt.Errorf("failed to decode dynamic quotas: %w", err)
+	}
+	if content.Quotas == nil {
+		return nil, fmt.Errorf("dynamic quotas are missing")
+	}
+	return content.Quotas, nil
+}
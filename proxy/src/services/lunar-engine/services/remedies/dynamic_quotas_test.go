@@ -0,0 +1,181 @@
+package remedies_test
+
+import (
+	"context"
+	"lunar/engine/actions"
+	"lunar/engine/config"
+	"lunar/engine/services/remedies"
+	"lunar/engine/utils"
+	"lunar/engine/utils/limit"
+	"lunar/engine/utils/obfuscation"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"lunar/toolkit-core/logging"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+var testDynamicQuota = &sharedConfig.DynamicQuota{
+	Key:                    "upstream",
+	MinAllowedRequestCount: 2,
+	MaxAllowedRequestCount: 50,
+}
+
+func TestDynamicQuotasApplyTheBoundedQuotaFromTheNextWindow(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	dynamicQuotas := remedies.NewDynamicQuotas(clock, nil, time.Hour)
+	allowedRequestCount := func() int64 {
+		return dynamicQuotas.AllowedRequestCount(
+			"test", 10, testDynamicQuota, 10*time.Second)
+	}
+
+	assert.Equal(t, int64(10), allowedRequestCount())
+	dynamicQuotas.Set(map[string]int64{"upstream": 20})
+	clock.AdvanceTime(5 * time.Second)
+	assert.Equal(t, int64(10), allowedRequestCount())
+
+	clock.AdvanceTime(5 * time.Second)
+	assert.Equal(t, int64(20), allowedRequestCount())
+
+	dynamicQuotas.Set(map[string]int64{"upstream": 1000})
+	clock.AdvanceTime(10 * time.Second)
+	assert.Equal(t, int64(50), allowedRequestCount())
+
+	dynamicQuotas.Set(map[string]int64{"upstream": 0})
+	clock.AdvanceTime(10 * time.Second)
+	assert.Equal(t, int64(2), allowedRequestCount())
+
+	// Remedies without a dynamic quota keep their static one
+	assert.Equal(t, int64(10), dynamicQuotas.AllowedRequestCount(
+		"static", 10, nil, 10*time.Second))
+}
+
+func TestDynamicQuotasFallBackToTheStaticQuotaWhenUnavailable(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	dynamicQuotas := remedies.NewDynamicQuotas(clock, meter, 30*time.Second)
+	allowedRequestCount := func() int64 {
+		return dynamicQuotas.AllowedRequestCount(
+			"test", 10, testDynamicQuota, 10*time.Second)
+	}
+	effective := func() map[string]int64 {
+		return collectPerAttribute(t, reader,
+			"lunar_remedies.dynamic_quota.allowed_requests", "source")
+	}
+
+	// The key of the remedy is missing from the source
+	dynamicQuotas.Set(map[string]int64{"other": 30})
+	assert.Equal(t, int64(10), allowedRequestCount())
+	assert.Equal(t, map[string]int64{"static": 10}, effective())
+
+	dynamicQuotas.Set(map[string]int64{"upstream": 30})
+	clock.AdvanceTime(10 * time.Second)
+	assert.Equal(t, int64(30), allowedRequestCount())
+	assert.Equal(t, map[string]int64{"dynamic": 30}, effective())
+
+	// The source did not set the quotas for longer than their max age
+	clock.AdvanceTime(30 * time.Second)
+	assert.Equal(t, int64(10), allowedRequestCount())
+	assert.Equal(t, map[string]int64{"static": 10}, effective())
+
+	// Remedies which no longer resolve their quota are not reported
+	clock.AdvanceTime(20 * time.Second)
+	assert.Empty(t, effective())
+}
+
+func TestStrategyBasedThrottlingAppliesTheDynamicQuota(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	plugin, err := remedies.NewStrategyBasedThrottlingPlugin(
+		context.Background(),
+		clock,
+		nil,
+		nil,
+		limit.NewRateLimitState(clock, logging.ContextLogger{}),
+		obfuscation.Obfuscator{Hasher: obfuscation.IdentityHasher{}},
+	)
+	require.Nil(t, err)
+	dynamicQuotas := remedies.NewDynamicQuotas(clock, nil, time.Hour)
+	plugin.SetDynamicQuotas(dynamicQuotas)
+	remedyConfig := strategyBasedThrottlingRemedyConfig(5, 10, nil, false)
+	remedyConfig.DynamicQuota = testDynamicQuota
+	scopedRemedy := config.ScopedRemedy{
+		Scope:         utils.ScopeEndpoint,
+		Method:        "GET",
+		NormalizedURL: "test.com/some/path",
+		Remedy: &sharedConfig.Remedy{
+			Name: "test",
+			Config: sharedConfig.RemedyConfig{
+				StrategyBasedThrottling: remedyConfig,
+			},
+		},
+	}
+
+	// The static quota applies until the end of the window it applied in
+	assertNoOpAction(2, plugin, onRequestArgs(), scopedRemedy, t)
+	dynamicQuotas.Set(map[string]int64{"upstream": 3})
+	assertNoOpAction(3, plugin, onRequestArgs(), scopedRemedy, t)
+	action, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+	assert.IsType(t, &actions.EarlyResponseAction{}, action)
+
+	clock.AdvanceTime(11 * time.Second)
+	assertNoOpAction(3, plugin, onRequestArgs(), scopedRemedy, t)
+	action, err = plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+	assert.IsType(t, &actions.EarlyResponseAction{}, action)
+}
+
+func TestDynamicQuotasPollerSetsTheFetchedQuotas(t *testing.T) {
+	t.Parallel()
+	var isAvailable atomic.Bool
+	isAvailable.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, _ *http.Request) {
+			if !isAvailable.Load() {
+				writer.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = writer.Write([]byte(`{"quotas": {"upstream": 20}}`))
+		}))
+	defer server.Close()
+
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	dynamicQuotas := remedies.NewDynamicQuotas(clock, nil, 30*time.Second)
+	poller := remedies.NewDynamicQuotasPoller(
+		clock, server.URL, time.Second, noop.NewMeterProvider().Meter("test"),
+		dynamicQuotas)
+	defer poller.Stop()
+	allowedRequestCount := func() int64 {
+		return dynamicQuotas.AllowedRequestCount(
+			"test", 10, testDynamicQuota, 10*time.Second)
+	}
+
+	require.NoError(t, poller.Poll())
+	assert.Equal(t, int64(20), allowedRequestCount())
+
+	// Once the endpoint is unavailable for the max age, the static quota applies
+	isAvailable.Store(false)
+	clock.AdvanceTime(10 * time.Second)
+	require.Error(t, poller.Poll())
+	assert.Equal(t, int64(20), allowedRequestCount())
+
+	clock.AdvanceTime(20 * time.Second)
+	require.Error(t, poller.Poll())
+	assert.Equal(t, int64(10), allowedRequestCount())
+}
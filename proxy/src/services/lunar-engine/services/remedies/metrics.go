@@ -0,0 +1,216 @@
+package remedies
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// instrumentDefinition describes a metric instrument of the remedy plugins.
+// All instruments are defined here, so their names, descriptions and units
+// are consistent. Units follow UCUM, as backends format values by it:
+// counts are annotated with what they count (e.g. "{request}").
+type instrumentDefinition struct {
+	name        string
+	description string
+	unit        string
+}
+
+const (
+	requestUnit = "{request}"
+	secondsUnit = "s"
+	entryUnit   = "{entry}"
+)
+
+var (
+	queueRequestsInQueueInstrument = instrumentDefinition{
+		name:        "lunar_remedies.strategy_based_queue.requests_in_queue",
+		description: "Current number of requests in queue",
+		unit:        requestUnit,
+	}
+	queueRequestsInQueueTotalInstrument = instrumentDefinition{
+		name: "lunar_remedies.strategy_based_queue.requests_in_queue_total",
+		description: "Current number of requests in queue per remedy, " +
+			"summed across priorities and tenants",
+		unit: requestUnit,
+	}
+	queueRequestsInstrument = instrumentDefinition{
+		name: "lunar_remedies.strategy_based_queue.requests",
+		description: "Requests handled by strategy based queue, by the reason " +
+			"they were admitted or rejected; requests processed_in_window took " +
+			"the fast path, admitted without being queued",
+		unit: requestUnit,
+	}
+	queueOldestRequestAgeInstrument = instrumentDefinition{
+		name:        "lunar_remedies.strategy_based_queue.oldest_request_age",
+		description: "Age of the oldest request in queue",
+		unit:        secondsUnit,
+	}
+	queueRejectionRatioInstrument = instrumentDefinition{
+		name: "lunar_remedies.strategy_based_queue.rejection_ratio",
+		description: "Ratio of the requests rejected by strategy based queue " +
+			"out of those it handled, over a rolling window",
+		unit: "1",
+	}
+	queueStrategyInfoInstrument = instrumentDefinition{
+		name: "lunar_remedies.strategy_based_queue.strategy_info",
+		description: "Effective strategy of each strategy based queue, " +
+			"1 per remedy labeled by its window quota and size",
+		unit: "{queue}",
+	}
+	queueShadowRequestsInstrument = instrumentDefinition{
+		name: "lunar_remedies.strategy_based_queue.shadow_requests",
+		description: "Requests evaluated by the shadow quota of strategy " +
+			"based queue, by whether it would have rejected them",
+		unit: requestUnit,
+	}
+	queuePrioritizedRequestsInstrument = instrumentDefinition{
+		name: "lunar_remedies.strategy_based_queue.prioritized_requests",
+		description: "Requests of strategy based queues with prioritization " +
+			"configured, by whether their priority matched or fell back " +
+			"to the default",
+		unit: requestUnit,
+	}
+	queueEvictedRequestsInstrument = instrumentDefinition{
+		name: "lunar_remedies.strategy_based_queue.evicted_requests",
+		description: "Requests evicted from a full strategy based queue " +
+			"to admit newer ones",
+		unit: requestUnit,
+	}
+	authRejectedRequestsInstrument = instrumentDefinition{
+		name: "lunar_remedies.authentication.rejected_requests",
+		description: "Requests rejected by the authentication challenge, " +
+			"by the way their client credentials failed the verification",
+		unit: requestUnit,
+	}
+	warmupRequestsInstrument = instrumentDefinition{
+		name: "lunar_remedies.warmup.requests",
+		description: "Requests observed by remedies in their warmup, " +
+			"by the action they would have applied",
+		unit: requestUnit,
+	}
+	circuitOpenInstrument = instrumentDefinition{
+		name: "lunar_remedies.circuit.open",
+		description: "Remedies disabled after erroring repeatedly, " +
+			"1 while disabled",
+		unit: "{remedy}",
+	}
+	dynamicQuotaAllowedRequestsInstrument = instrumentDefinition{
+		name: "lunar_remedies.dynamic_quota.allowed_requests",
+		description: "Effective allowed request count of remedies with " +
+			"a dynamic quota, by whether it is dynamic or the static fallback",
+		unit: requestUnit,
+	}
+	decisionHookDecisionsInstrument = instrumentDefinition{
+		name: "lunar_remedies.decision_hook.decisions",
+		description: "Requests decided on by decision hooks, by their " +
+			"decision, or whether the hook timed out or errored",
+		unit: requestUnit,
+	}
+	throttlingQuotaUsedInstrument = instrumentDefinition{
+		name:        "lunar_remedies.strategy_based_throttling.quota_used",
+		description: "Used quota for strategy based throttling",
+		unit:        requestUnit,
+	}
+	throttlingQuotaLimitInstrument = instrumentDefinition{
+		name:        "lunar_remedies.strategy_based_throttling.quota_limit",
+		description: "Quota limit for strategy based throttling",
+		unit:        requestUnit,
+	}
+	throttlingRequestsInstrument = instrumentDefinition{
+		name:        "lunar_remedies.strategy_based_throttling.requests",
+		description: "Requests handled by strategy based throttling",
+		unit:        requestUnit,
+	}
+)
+
+// deduplicationInstruments are the instruments of remedies serving repeated
+// requests out of the responses they stored, which share a naming convention:
+// `lunar_remedies.<remedy>.{hits,misses,collisions,stored_entries}`.
+// A collision is a request reusing the key of a stored response
+// while its content differs from the request the response was stored for.
+type deduplicationInstruments struct {
+	hits          instrumentDefinition
+	misses        instrumentDefinition
+	collisions    instrumentDefinition
+	storedEntries instrumentDefinition
+}
+
+func newDeduplicationInstruments(remedy string) deduplicationInstruments {
+	prefix := "lunar_remedies." + remedy + "."
+	return deduplicationInstruments{
+		hits: instrumentDefinition{
+			name:        prefix + "hits",
+			description: "Requests served out of a stored response by " + remedy,
+			unit:        requestUnit,
+		},
+		misses: instrumentDefinition{
+			name:        prefix + "misses",
+			description: "Requests with no stored response to serve by " + remedy,
+			unit:        requestUnit,
+		},
+		collisions: instrumentDefinition{
+			name: prefix + "collisions",
+			description: "Requests reusing the key of a stored response " +
+				"with a different content by " + remedy,
+			unit: requestUnit,
+		},
+		storedEntries: instrumentDefinition{
+			name:        prefix + "stored_entries",
+			description: "Current number of responses stored by " + remedy,
+			unit:        entryUnit,
+		},
+	}
+}
+
+var cachingInstruments = newDeduplicationInstruments("caching")
+
+func (definition instrumentDefinition) withDescription() metric.InstrumentOption {
+	return metric.WithDescription(definition.description)
+}
+
+func (definition instrumentDefinition) withUnit() metric.InstrumentOption {
+	return metric.WithUnit(definition.unit)
+}
+
+// sampledCounter counts requests of remedies configured with
+// `requests_metric_sampling` of N by recording 1 in every N of them,
+// scaled by N. Each attribute set is sampled separately, so the total of
+// each series lags behind the exact count by less than N.
+type sampledCounter struct {
+	counter metric.Int64Counter
+	mutex   sync.Mutex
+	pending map[attribute.Distinct]int64
+}
+
+func newSampledCounter(counter metric.Int64Counter) *sampledCounter {
+	return &sampledCounter{
+		counter: counter,
+		mutex:   sync.Mutex{},
+		pending: map[attribute.Distinct]int64{},
+	}
+}
+
+func (sampled *sampledCounter) add(
+	ctx context.Context,
+	sampling int,
+	attributes ...attribute.KeyValue,
+) {
+	set := attribute.NewSet(attributes...)
+	if sampling <= 1 {
+		sampled.counter.Add(ctx, 1, metric.WithAttributeSet(set))
+		return
+	}
+
+	sampled.mutex.Lock()
+	sampled.pending[set.Equivalent()]++
+	if sampled.pending[set.Equivalent()] < int64(sampling) {
+		sampled.mutex.Unlock()
+		return
+	}
+	delete(sampled.pending, set.Equivalent())
+	sampled.mutex.Unlock()
+	sampled.counter.Add(ctx, int64(sampling), metric.WithAttributeSet(set))
+}
@@ -0,0 +1,141 @@
+package remedies_test
+
+import (
+	"context"
+	"lunar/engine/config"
+	"lunar/engine/services/remedies"
+	"lunar/engine/utils"
+	"lunar/engine/utils/limit"
+	"lunar/engine/utils/obfuscation"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"lunar/toolkit-core/logging"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestRemedyPluginInstrumentsDeclareUnitAndDescription(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+
+	queuePlugin := newStrategyBasedQueuePluginWithMeter(clock, meter, nil)
+	_, err := queuePlugin.OnRequest(
+		onRequestArgs(), buildStrategyBasedQueueScopedRemedy(1, 10, nil))
+	require.Nil(t, err)
+
+	throttlingPlugin, err := remedies.NewStrategyBasedThrottlingPlugin(
+		context.Background(),
+		clock,
+		meter,
+		nil,
+		limit.NewRateLimitState(clock, logging.ContextLogger{}),
+		obfuscation.Obfuscator{Hasher: obfuscation.IdentityHasher{}},
+	)
+	require.Nil(t, err)
+	_, err = throttlingPlugin.OnRequest(onRequestArgs(), config.ScopedRemedy{
+		Scope:         utils.ScopeEndpoint,
+		Method:        "GET",
+		NormalizedURL: "test.com/some/path",
+		Remedy: &sharedConfig.Remedy{
+			Name: "test",
+			Config: sharedConfig.RemedyConfig{
+				StrategyBasedThrottling: strategyBasedThrottlingRemedyConfig(
+					1, 10, nil, false),
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+	units := map[string]string{}
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, recordedMetric := range scopeMetrics.Metrics {
+			if !strings.HasPrefix(recordedMetric.Name, "lunar_remedies.") {
+				continue
+			}
+			assert.NotEmpty(t, recordedMetric.Description, recordedMetric.Name)
+			units[recordedMetric.Name] = recordedMetric.Unit
+		}
+	}
+
+	assert.Equal(t, map[string]string{
+		"lunar_remedies.strategy_based_queue.requests_in_queue":       "{request}",
+		"lunar_remedies.strategy_based_queue.requests_in_queue_total": "{request}",
+		"lunar_remedies.strategy_based_queue.requests":                "{request}",
+		"lunar_remedies.strategy_based_queue.oldest_request_age":      "s",
+		"lunar_remedies.strategy_based_queue.strategy_info":           "{queue}",
+		"lunar_remedies.strategy_based_queue.rejection_ratio":         "1",
+		"lunar_remedies.strategy_based_throttling.quota_used":         "{request}",
+		"lunar_remedies.strategy_based_throttling.quota_limit":        "{request}",
+		"lunar_remedies.strategy_based_throttling.requests":           "{request}",
+	}, units)
+}
+
+func TestSampledRequestsCounterScalesIncrements(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	throttlingPlugin, err := remedies.NewStrategyBasedThrottlingPlugin(
+		context.Background(),
+		clock,
+		meter,
+		nil,
+		limit.NewRateLimitState(clock, logging.ContextLogger{}),
+		obfuscation.Obfuscator{Hasher: obfuscation.IdentityHasher{}},
+	)
+	require.Nil(t, err)
+
+	scopedRemedy := func(name string, sampling int) config.ScopedRemedy {
+		return config.ScopedRemedy{
+			Scope:         utils.ScopeEndpoint,
+			Method:        "GET",
+			NormalizedURL: "test.com/some/path",
+			Remedy: &sharedConfig.Remedy{
+				Name:                   name,
+				RequestsMetricSampling: sampling,
+				Config: sharedConfig.RemedyConfig{
+					StrategyBasedThrottling: strategyBasedThrottlingRemedyConfig(
+						1000, 10, nil, false),
+				},
+			},
+		}
+	}
+	for i := 0; i < 95; i++ {
+		_, err = throttlingPlugin.OnRequest(onRequestArgs(), scopedRemedy("sampled", 10))
+		require.Nil(t, err)
+		_, err = throttlingPlugin.OnRequest(onRequestArgs(), scopedRemedy("exact", 0))
+		require.Nil(t, err)
+	}
+
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+	totals := map[string]int64{}
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, recordedMetric := range scopeMetrics.Metrics {
+			if recordedMetric.Name != "lunar_remedies.strategy_based_throttling.requests" {
+				continue
+			}
+			sum, ok := recordedMetric.Data.(metricdata.Sum[int64])
+			require.True(t, ok)
+			for _, point := range sum.DataPoints {
+				remedyName, _ := point.Attributes.Value("remedy")
+				totals[remedyName.AsString()] += point.Value
+			}
+		}
+	}
+
+	// The sampled total lags behind the exact one by less than the sampling
+	assert.Equal(t, map[string]int64{"sampled": 90, "exact": 95}, totals)
+}
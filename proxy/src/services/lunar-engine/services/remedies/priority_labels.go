@@ -0,0 +1,88 @@
+package remedies
+
+import (
+	"lunar/engine/config"
+	sharedConfig "lunar/shared-model/config"
+	"sort"
+	"sync"
+)
+
+// priorityLabels bounds the distinct values of the `priority` attribute of
+// the queue metrics, both of the requests counter and of the in queue gauge,
+// which has a series per priority. Priorities are labeled by the configured
+// priority nearest to them, as a combined (weighted sum) priority may not be
+// configured itself. Priorities computed by an expression are not known in
+// advance, so they are labeled as computed, up to the max priority groups,
+// after which they are labeled by the nearest one already labeled.
+type priorityLabels struct {
+	mutex    sync.Mutex
+	byRemedy map[string]*remedyPriorityLabels
+}
+
+type remedyPriorityLabels struct {
+	prioritization *sharedConfig.GroupPrioritization
+	// sorted in ascending order
+	labels    []float64
+	maxLabels int
+}
+
+func newPriorityLabels() *priorityLabels {
+	return &priorityLabels{
+		mutex:    sync.Mutex{},
+		byRemedy: map[string]*remedyPriorityLabels{},
+	}
+}
+
+func (priorityLabels *priorityLabels) label(
+	remedyName string,
+	prioritization *sharedConfig.GroupPrioritization,
+	priority float64,
+) float64 {
+	if prioritization == nil {
+		return priority
+	}
+	priorityLabels.mutex.Lock()
+	defer priorityLabels.mutex.Unlock()
+
+	remedyLabels, found := priorityLabels.byRemedy[remedyName]
+	// Labels are rebuilt once the policies were reloaded
+	if !found || remedyLabels.prioritization != prioritization {
+		remedyLabels = &remedyPriorityLabels{
+			prioritization: prioritization,
+			labels:         prioritization.Priorities(),
+			maxLabels:      0,
+		}
+		if prioritization.Expression != "" {
+			remedyLabels.maxLabels = config.MaxPriorityGroups()
+		}
+		priorityLabels.byRemedy[remedyName] = remedyLabels
+	}
+	return remedyLabels.label(priority)
+}
+
+func (remedyLabels *remedyPriorityLabels) label(priority float64) float64 {
+	index := sort.SearchFloat64s(remedyLabels.labels, priority)
+	if index < len(remedyLabels.labels) && remedyLabels.labels[index] == priority {
+		return priority
+	}
+	if len(remedyLabels.labels) < remedyLabels.maxLabels {
+		remedyLabels.labels = append(remedyLabels.labels, 0)
+		copy(remedyLabels.labels[index+1:], remedyLabels.labels[index:])
+		remedyLabels.labels[index] = priority
+		return priority
+	}
+
+	// The nearest label is either right below or right above the priority
+	if index == len(remedyLabels.labels) {
+		return remedyLabels.labels[index-1]
+	}
+	above := remedyLabels.labels[index]
+	if index == 0 {
+		return above
+	}
+	below := remedyLabels.labels[index-1]
+	if priority-below <= above-priority {
+		return below
+	}
+	return above
+}
@@ -0,0 +1,88 @@
+package remedies
+
+import (
+	"lunar/engine/actions"
+	"lunar/engine/messages"
+	"lunar/engine/utils/obfuscation"
+	sharedConfig "lunar/shared-model/config"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// QueryParamMaskingPlugin strips or hashes the configured query parameters
+// of requests before they are forwarded upstream, so sensitive values sent
+// by clients do not reach it. The response to the client is left as is.
+type QueryParamMaskingPlugin struct {
+	obfuscator obfuscation.Obfuscator
+}
+
+func NewQueryParamMaskingPlugin(
+	obfuscator obfuscation.Obfuscator,
+) *QueryParamMaskingPlugin {
+	return &QueryParamMaskingPlugin{obfuscator: obfuscator}
+}
+
+func (plugin *QueryParamMaskingPlugin) OnRequest(
+	onRequest messages.OnRequest,
+	remedyConfig *sharedConfig.QueryParamMaskingConfig,
+) (actions.ReqLunarAction, error) {
+	query, masked := plugin.maskQuery(onRequest.Query, remedyConfig)
+	if !masked {
+		return &actions.NoOpAction{}, nil
+	}
+	log.Trace().Msgf("Masked query parameters of request %v", onRequest.ID)
+	return &actions.ModifyRequestAction{ //nolint:exhaustruct
+		HeadersToSet: map[string]string{},
+		QueryToSet:   &query,
+	}, nil
+}
+
+func (plugin *QueryParamMaskingPlugin) OnResponse(
+	_ messages.OnResponse,
+	_ *sharedConfig.QueryParamMaskingConfig,
+) (actions.RespLunarAction, error) {
+	return &actions.NoOpAction{}, nil
+}
+
+// maskQuery returns the query with its configured parameters masked, and
+// whether any was. Parameters are kept in the order they were sent, and the
+// ones which are not masked are kept as they were encoded.
+func (plugin *QueryParamMaskingPlugin) maskQuery(
+	rawQuery string,
+	remedyConfig *sharedConfig.QueryParamMaskingConfig,
+) (string, bool) {
+	if rawQuery == "" {
+		return rawQuery, false
+	}
+	actionsByName := map[string]sharedConfig.QueryParamMaskingAction{}
+	for _, parameter := range remedyConfig.Parameters {
+		actionsByName[parameter.Name] = parameter.MaskingAction()
+	}
+
+	masked := false
+	parameters := []string{}
+	for _, parameter := range strings.Split(rawQuery, "&") {
+		rawName, rawValue, _ := strings.Cut(parameter, "=")
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			name = rawName
+		}
+		switch actionsByName[name] {
+		case sharedConfig.QueryParamMaskingStrip:
+			masked = true
+		case sharedConfig.QueryParamMaskingHash:
+			value, err := url.QueryUnescape(rawValue)
+			if err != nil {
+				value = rawValue
+			}
+			parameters = append(parameters,
+				rawName+"="+url.QueryEscape(plugin.obfuscator.ObfuscateString(value)))
+			masked = true
+		case sharedConfig.QueryParamMaskingKeep, sharedConfig.QueryParamMaskingUndefined:
+			parameters = append(parameters, parameter)
+		}
+	}
+	return strings.Join(parameters, "&"), masked
+}
@@ -0,0 +1,88 @@
+package remedies_test
+
+import (
+	"lunar/engine/actions"
+	"lunar/engine/services/remedies"
+	"lunar/engine/utils/obfuscation"
+	sharedConfig "lunar/shared-model/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func queryParamMaskingConfig() sharedConfig.QueryParamMaskingConfig {
+	return sharedConfig.QueryParamMaskingConfig{
+		Parameters: []sharedConfig.QueryParamMasking{
+			{Name: "api_key", Action: "strip"},
+			{Name: "email", Action: "hash"},
+			{Name: "page", Action: "keep"},
+		},
+	}
+}
+
+func newQueryParamMaskingPlugin() *remedies.QueryParamMaskingPlugin {
+	return remedies.NewQueryParamMaskingPlugin(
+		obfuscation.Obfuscator{Hasher: obfuscation.FixedHasher{Value: "hashed"}})
+}
+
+func TestQueryParamMaskingStripsAndHashesConfiguredParameters(t *testing.T) {
+	t.Parallel()
+	plugin := newQueryParamMaskingPlugin()
+	remedyConfig := queryParamMaskingConfig()
+	onRequest := onRequestArgs()
+	onRequest.Query = "page=2&api_key=secret&email=jane%40example.com&sort=name%20asc"
+
+	action, err := plugin.OnRequest(onRequest, &remedyConfig)
+	require.Nil(t, err)
+
+	// Other parameters are forwarded in their order, as they were encoded
+	wantQuery := "page=2&email=hashed&sort=name%20asc"
+	require.IsType(t, &actions.ModifyRequestAction{}, action)
+	assert.Equal(t, &wantQuery, action.(*actions.ModifyRequestAction).QueryToSet)
+	action.EnsureRequestIsUpdated(&onRequest)
+	assert.Equal(t, wantQuery, onRequest.Query)
+}
+
+func TestQueryParamMaskingRemovesTheQueryOnceAllParametersWereStripped(
+	t *testing.T,
+) {
+	t.Parallel()
+	plugin := newQueryParamMaskingPlugin()
+	remedyConfig := queryParamMaskingConfig()
+	onRequest := onRequestArgs()
+	onRequest.Query = "api_key=secret"
+
+	action, err := plugin.OnRequest(onRequest, &remedyConfig)
+	require.Nil(t, err)
+
+	wantQuery := ""
+	assert.Equal(t, &wantQuery, action.(*actions.ModifyRequestAction).QueryToSet)
+}
+
+func TestQueryParamMaskingLeavesRequestsWithoutTheParametersUnmodified(
+	t *testing.T,
+) {
+	t.Parallel()
+	plugin := newQueryParamMaskingPlugin()
+	remedyConfig := queryParamMaskingConfig()
+
+	for _, query := range []string{"", "page=2&sort=name"} {
+		onRequest := onRequestArgs()
+		onRequest.Query = query
+		action, err := plugin.OnRequest(onRequest, &remedyConfig)
+		require.Nil(t, err)
+		assert.IsType(t, &actions.NoOpAction{}, action, query)
+	}
+}
+
+func TestQueryParamMaskingLeavesTheResponseUnmodified(t *testing.T) {
+	t.Parallel()
+	plugin := newQueryParamMaskingPlugin()
+	remedyConfig := queryParamMaskingConfig()
+
+	action, err := plugin.OnResponse(
+		basicResponseArgs(200, "{}", map[string]string{}), &remedyConfig)
+	require.Nil(t, err)
+	assert.IsType(t, &actions.NoOpAction{}, action)
+}
@@ -0,0 +1,61 @@
+package remedies
+
+import "time"
+
+// RejectedRequest describes a request which a queue
+// did not allow to proceed
+type RejectedRequest struct {
+	RequestID  string
+	RemedyName string
+	Priority   float64
+	TenantID   string
+	EnqueuedAt time.Time
+	WaitTime   time.Duration
+	// Reason is the outcome of the request's enqueueing,
+	// e.g. `ttl_expired` or `queue_full`
+	Reason string
+	// StatusCode is the status of the response the request was rejected with
+	StatusCode int
+	// the request as received, which exporters must obfuscate
+	Method  string
+	Path    string
+	Headers map[string]string
+}
+
+// RejectedRequestsExporter exports a record of each rejected request,
+// for reconstructing who was affected by a throttling incident.
+// Exporting must not block the rejection of the request.
+type RejectedRequestsExporter interface {
+	ExportRejectedRequest(rejectedRequest RejectedRequest)
+}
+
+type rejectedRequestsExporters []RejectedRequestsExporter
+
+// CombineRejectedRequestsExporters exports each rejected request to all the
+// given exporters which are not nil. It returns nil if all of them are nil.
+func CombineRejectedRequestsExporters(
+	exporters ...RejectedRequestsExporter,
+) RejectedRequestsExporter {
+	combined := rejectedRequestsExporters{}
+	for _, exporter := range exporters {
+		if exporter != nil {
+			combined = append(combined, exporter)
+		}
+	}
+	switch len(combined) {
+	case 0:
+		return nil
+	case 1:
+		return combined[0]
+	default:
+		return combined
+	}
+}
+
+func (exporters rejectedRequestsExporters) ExportRejectedRequest(
+	rejectedRequest RejectedRequest,
+) {
+	for _, exporter := range exporters {
+		exporter.ExportRejectedRequest(rejectedRequest)
+	}
+}
@@ -0,0 +1,158 @@
+package remedies
+
+import (
+	"context"
+	"lunar/toolkit-core/clock"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	DefaultRejectionRatioWindow = time.Minute
+	// the window is tracked in as many buckets, so it rolls by a tenth of it
+	rejectionRatioBuckets = 10
+)
+
+// rejectionRatioBucket counts the requests of a remedy which arrived
+// within a bucket of the window, starting at start
+type rejectionRatioBucket struct {
+	start    time.Time
+	total    int64
+	rejected int64
+}
+
+// rejectionRatios reports the ratio of the requests each remedy rejected
+// out of those it handled over a rolling window, so alerting on the
+// rejection rate needs no division of the requests counters.
+// Each remedy keeps no more than a window's worth of buckets, and
+// remedies which handled no requests in the window report 0,
+// until they are removed from the policies.
+type rejectionRatios struct {
+	clock   clock.Clock
+	mutex   sync.Mutex
+	window  time.Duration
+	buckets map[string][]rejectionRatioBucket
+}
+
+func newRejectionRatios(clock clock.Clock, meter metric.Meter) *rejectionRatios {
+	ratios := &rejectionRatios{
+		clock:   clock,
+		mutex:   sync.Mutex{},
+		window:  DefaultRejectionRatioWindow,
+		buckets: map[string][]rejectionRatioBucket{},
+	}
+	_, err := meter.Float64ObservableGauge(
+		queueRejectionRatioInstrument.name,
+		queueRejectionRatioInstrument.withDescription(),
+		queueRejectionRatioInstrument.withUnit(),
+		metric.WithFloat64Callback(ratios.observe),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create %s metric",
+			queueRejectionRatioInstrument.name)
+	}
+	return ratios
+}
+
+// setWindow sets the window the ratio is computed over,
+// dropping the requests counted so far
+func (ratios *rejectionRatios) setWindow(window time.Duration) {
+	if window < time.Second {
+		return
+	}
+	ratios.mutex.Lock()
+	defer ratios.mutex.Unlock()
+	ratios.window = window
+	for remedyName := range ratios.buckets {
+		ratios.buckets[remedyName] = nil
+	}
+}
+
+// record counts a request the remedy handled, and whether it rejected it
+func (ratios *rejectionRatios) record(remedyName string, rejected bool) {
+	ratios.mutex.Lock()
+	defer ratios.mutex.Unlock()
+	now := ratios.clock.Now()
+	buckets := ratios.expire(remedyName, now)
+	start := now.Truncate(ratios.window / rejectionRatioBuckets)
+	if len(buckets) == 0 || !buckets[len(buckets)-1].start.Equal(start) {
+		buckets = append(buckets, rejectionRatioBucket{start: start, total: 0, rejected: 0})
+	}
+	current := &buckets[len(buckets)-1]
+	current.total++
+	if rejected {
+		current.rejected++
+	}
+	ratios.buckets[remedyName] = buckets
+}
+
+// forgetRemovedRemedies drops the requests counted by the remedies
+// which are not configured anymore, so they stop being reported
+func (ratios *rejectionRatios) forgetRemovedRemedies(configured map[string]struct{}) {
+	ratios.mutex.Lock()
+	defer ratios.mutex.Unlock()
+	for remedyName := range ratios.buckets {
+		if _, found := configured[remedyName]; !found {
+			delete(ratios.buckets, remedyName)
+		}
+	}
+}
+
+// ratio returns the ratio of requests the remedy rejected in the window,
+// which is 0 if it handled none
+func (ratios *rejectionRatios) ratio(remedyName string) float64 {
+	ratios.mutex.Lock()
+	defer ratios.mutex.Unlock()
+	return ratios.ratioOf(ratios.expire(remedyName, ratios.clock.Now()))
+}
+
+func (ratios *rejectionRatios) ratioOf(buckets []rejectionRatioBucket) float64 {
+	total, rejected := int64(0), int64(0)
+	for _, bucket := range buckets {
+		total += bucket.total
+		rejected += bucket.rejected
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(rejected) / float64(total)
+}
+
+// expire drops the buckets of the remedy which rolled out of the window,
+// and returns the rest. Must be called while holding the mutex.
+func (ratios *rejectionRatios) expire(
+	remedyName string,
+	now time.Time,
+) []rejectionRatioBucket {
+	buckets := ratios.buckets[remedyName]
+	windowStart := now.Add(-ratios.window)
+	expired := 0
+	for expired < len(buckets) && !buckets[expired].start.After(windowStart) {
+		expired++
+	}
+	buckets = buckets[expired:]
+	if _, found := ratios.buckets[remedyName]; found {
+		ratios.buckets[remedyName] = buckets
+	}
+	return buckets
+}
+
+func (ratios *rejectionRatios) observe(
+	_ context.Context,
+	observer metric.Float64Observer,
+) error {
+	ratios.mutex.Lock()
+	defer ratios.mutex.Unlock()
+	now := ratios.clock.Now()
+	for remedyName := range ratios.buckets {
+		observer.Observe(
+			ratios.ratioOf(ratios.expire(remedyName, now)),
+			metric.WithAttributes(attribute.String(remedyAttribute, remedyName)),
+		)
+	}
+	return nil
+}
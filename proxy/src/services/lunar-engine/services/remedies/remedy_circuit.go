@@ -0,0 +1,168 @@
+package remedies
+
+import (
+	"context"
+	"lunar/engine/config"
+	"lunar/toolkit-core/clock"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	DefaultRemedyCircuitConsecutiveErrors = 10
+	DefaultRemedyCircuitCooldown          = time.Minute
+)
+
+type remedyCircuitKey struct {
+	method        string
+	normalizedURL string
+	name          string
+}
+
+// remedyCircuitState is only kept for remedies which errored
+// since they last succeeded
+type remedyCircuitState struct {
+	consecutiveErrors int
+	// while open, the remedy is disabled until then
+	openUntil time.Time
+	isOpen    bool
+	// a disabled remedy is re-enabled once a probe succeeds
+	isProbing bool
+}
+
+// RemedyCircuit disables a remedy once it returned consecutiveErrors errors
+// in a row, so a broken remedy does not flood the logs and metrics.
+// Disabled remedies fail open: they are skipped as if they returned NoOp.
+// Once the cooldown passed, a single transaction probes the remedy, which
+// is re-enabled if it succeeds, or disabled for another cooldown otherwise.
+type RemedyCircuit struct {
+	clock             clock.Clock
+	consecutiveErrors int
+	cooldown          time.Duration
+
+	mutex  sync.Mutex
+	states map[remedyCircuitKey]*remedyCircuitState
+}
+
+// NewRemedyCircuit creates a circuit which never disables remedies
+// if consecutiveErrors is not positive
+func NewRemedyCircuit(
+	clock clock.Clock,
+	meter metric.Meter,
+	consecutiveErrors int,
+	cooldown time.Duration,
+) *RemedyCircuit {
+	circuit := &RemedyCircuit{
+		clock:             clock,
+		consecutiveErrors: consecutiveErrors,
+		cooldown:          cooldown,
+		mutex:             sync.Mutex{},
+		states:            map[remedyCircuitKey]*remedyCircuitState{},
+	}
+	if meter == nil {
+		return circuit
+	}
+	_, err := meter.Int64ObservableGauge(
+		circuitOpenInstrument.name,
+		circuitOpenInstrument.withDescription(),
+		circuitOpenInstrument.withUnit(),
+		metric.WithInt64Callback(circuit.observeOpen),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create %s metric",
+			circuitOpenInstrument.name)
+	}
+	return circuit
+}
+
+func circuitKeyOf(scopedRemedy config.ScopedRemedy) remedyCircuitKey {
+	return remedyCircuitKey{
+		method:        scopedRemedy.Method,
+		normalizedURL: scopedRemedy.NormalizedURL,
+		name:          scopedRemedy.Remedy.Name,
+	}
+}
+
+// Allows returns whether the remedy should run, and not be skipped
+// as it is disabled
+func (circuit *RemedyCircuit) Allows(scopedRemedy config.ScopedRemedy) bool {
+	if circuit == nil || circuit.consecutiveErrors <= 0 {
+		return true
+	}
+	circuit.mutex.Lock()
+	defer circuit.mutex.Unlock()
+	state, found := circuit.states[circuitKeyOf(scopedRemedy)]
+	if !found || !state.isOpen {
+		return true
+	}
+	if state.isProbing || circuit.clock.Now().Before(state.openUntil) {
+		return false
+	}
+	state.isProbing = true
+	log.Info().Str("remedy", scopedRemedy.Remedy.Name).
+		Msg("Probing disabled remedy to re-enable it")
+	return true
+}
+
+// Record records the outcome of running the remedy, disabling it once it
+// errored repeatedly, or re-enabling it once its probe succeeded
+func (circuit *RemedyCircuit) Record(scopedRemedy config.ScopedRemedy, err error) {
+	if circuit == nil || circuit.consecutiveErrors <= 0 {
+		return
+	}
+	key := circuitKeyOf(scopedRemedy)
+	circuit.mutex.Lock()
+	defer circuit.mutex.Unlock()
+	state, found := circuit.states[key]
+	if err == nil {
+		if found && state.isOpen {
+			log.Warn().Str("remedy", scopedRemedy.Remedy.Name).
+				Msg("Remedy succeeded again, it is re-enabled")
+		}
+		delete(circuit.states, key)
+		return
+	}
+	if !found {
+		state = &remedyCircuitState{} //nolint:exhaustruct
+		circuit.states[key] = state
+	}
+	state.consecutiveErrors++
+	if !state.isProbing && (state.isOpen ||
+		state.consecutiveErrors < circuit.consecutiveErrors) {
+		return
+	}
+	state.isOpen = true
+	state.isProbing = false
+	state.openUntil = circuit.clock.Now().Add(circuit.cooldown)
+	log.Error().Err(err).
+		Str("remedy", scopedRemedy.Remedy.Name).
+		Str("method", scopedRemedy.Method).
+		Str("url", scopedRemedy.NormalizedURL).
+		Int("consecutive_errors", state.consecutiveErrors).
+		Msgf("Remedy keeps failing, it is DISABLED (failing open) until %v",
+			state.openUntil)
+}
+
+func (circuit *RemedyCircuit) observeOpen(
+	_ context.Context,
+	observer metric.Int64Observer,
+) error {
+	circuit.mutex.Lock()
+	defer circuit.mutex.Unlock()
+	for key, state := range circuit.states {
+		isOpen := int64(0)
+		if state.isOpen {
+			isOpen = 1
+		}
+		observer.Observe(isOpen, metric.WithAttributes(
+			attribute.String(remedyAttribute, key.name),
+			attribute.String("method", key.method),
+			attribute.String("normalized_url", key.normalizedURL),
+		))
+	}
+	return nil
+}
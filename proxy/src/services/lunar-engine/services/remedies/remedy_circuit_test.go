@@ -0,0 +1,90 @@
+package remedies_test
+
+import (
+	"context"
+	"lunar/engine/config"
+	"lunar/engine/services/remedies"
+	"lunar/engine/utils"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestRemedyCircuitDisablesAnErroringRemedyAndReprobesIt(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	circuit := remedies.NewRemedyCircuit(clock, meter, 3, 10*time.Second)
+	plugin := newStrategyBasedQueuePlugin(clock)
+	// A partial config, missing the remedy's own config
+	scopedRemedy := config.ScopedRemedy{
+		Scope:         utils.ScopeEndpoint,
+		Method:        "GET",
+		NormalizedURL: "test.com/some/path",
+		Remedy: &sharedConfig.Remedy{
+			Name:   "broken",
+			Config: sharedConfig.RemedyConfig{},
+		},
+	}
+	run := func() {
+		require.True(t, circuit.Allows(scopedRemedy))
+		_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+		circuit.Record(scopedRemedy, err)
+	}
+	circuitOpen := func() int64 {
+		var resourceMetrics metricdata.ResourceMetrics
+		require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+		require.Len(t, resourceMetrics.ScopeMetrics, 1)
+		gauge, ok := resourceMetrics.ScopeMetrics[0].Metrics[0].Data.(metricdata.Gauge[int64])
+		require.True(t, ok)
+		require.Len(t, gauge.DataPoints, 1)
+		return gauge.DataPoints[0].Value
+	}
+
+	run()
+	run()
+	assert.True(t, circuit.Allows(scopedRemedy))
+	assert.Equal(t, int64(0), circuitOpen())
+	run()
+	assert.False(t, circuit.Allows(scopedRemedy))
+	assert.Equal(t, int64(1), circuitOpen())
+
+	// Once the cooldown passes, a single probe runs, failing again
+	clock.AdvanceTime(10 * time.Second)
+	run()
+	assert.False(t, circuit.Allows(scopedRemedy))
+	clock.AdvanceTime(5 * time.Second)
+	assert.False(t, circuit.Allows(scopedRemedy))
+
+	// Once fixed, the next probe re-enables the remedy
+	scopedRemedy.Remedy.Config.StrategyBasedQueue = &sharedConfig.StrategyBasedQueueConfig{
+		AllowedRequestCount: 10,
+		WindowSizeInSeconds: 10,
+		ResponseStatusCode:  429,
+		TTLSeconds:          5,
+		QueueSize:           10,
+	}
+	clock.AdvanceTime(5 * time.Second)
+	run()
+	assert.True(t, circuit.Allows(scopedRemedy))
+	assert.True(t, circuit.Allows(scopedRemedy))
+}
+
+func TestDisabledRemedyCircuitNeverDisablesRemedies(t *testing.T) {
+	t.Parallel()
+	circuit := remedies.NewRemedyCircuit(clock.NewMockClock(), nil, 0, time.Second)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(1, 1, nil)
+
+	for i := 0; i < 100; i++ {
+		circuit.Record(scopedRemedy, remedies.ErrMissingConfig)
+	}
+	assert.True(t, circuit.Allows(scopedRemedy))
+}
@@ -0,0 +1,73 @@
+package remedies
+
+import (
+	"context"
+	"lunar/engine/actions"
+	"lunar/engine/config"
+	"lunar/toolkit-core/clock"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const warmupActionAttribute = "action"
+
+// RemedyWarmup withholds the actions of remedies in their warmup, so they
+// only observe requests. The withheld request actions are counted by the
+// action the remedy would have applied, to tell how it will enforce.
+type RemedyWarmup struct {
+	ctx      context.Context
+	clock    clock.Clock
+	requests metric.Int64Counter
+}
+
+func NewRemedyWarmup(
+	ctx context.Context,
+	clock clock.Clock,
+	meter metric.Meter,
+) *RemedyWarmup {
+	warmup := &RemedyWarmup{ctx: ctx, clock: clock, requests: nil}
+	if meter == nil {
+		return warmup
+	}
+	requests, err := meter.Int64Counter(
+		warmupRequestsInstrument.name,
+		warmupRequestsInstrument.withDescription(),
+		warmupRequestsInstrument.withUnit(),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create %s metric",
+			warmupRequestsInstrument.name)
+	}
+	warmup.requests = requests
+	return warmup
+}
+
+// OnRequest returns the action to apply out of the one of the given remedy
+func (warmup *RemedyWarmup) OnRequest(
+	scopedRemedy config.ScopedRemedy,
+	action actions.ReqLunarAction,
+) actions.ReqLunarAction {
+	if warmup == nil || !scopedRemedy.Remedy.IsWarmingUp(warmup.clock.Now()) {
+		return action
+	}
+	if warmup.requests != nil {
+		warmup.requests.Add(warmup.ctx, 1, metric.WithAttributes(
+			attribute.String(remedyAttribute, scopedRemedy.Remedy.Name),
+			attribute.String(warmupActionAttribute, action.ReqRunResult().String()),
+		))
+	}
+	return &actions.NoOpAction{}
+}
+
+// OnResponse returns the action to apply out of the one of the given remedy
+func (warmup *RemedyWarmup) OnResponse(
+	scopedRemedy config.ScopedRemedy,
+	action actions.RespLunarAction,
+) actions.RespLunarAction {
+	if warmup == nil || !scopedRemedy.Remedy.IsWarmingUp(warmup.clock.Now()) {
+		return action
+	}
+	return &actions.NoOpAction{}
+}
@@ -0,0 +1,72 @@
+package remedies_test
+
+import (
+	"context"
+	"lunar/engine/actions"
+	"lunar/engine/config"
+	"lunar/engine/services/remedies"
+	"lunar/engine/utils"
+	"lunar/engine/utils/limit"
+	"lunar/engine/utils/obfuscation"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"lunar/toolkit-core/logging"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+func TestRemedyWarmupObservesRequestsUntilItEnds(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	plugin, err := remedies.NewStrategyBasedThrottlingPlugin(
+		context.Background(),
+		clock,
+		meter,
+		nil,
+		limit.NewRateLimitState(clock, logging.ContextLogger{}),
+		obfuscation.Obfuscator{Hasher: obfuscation.IdentityHasher{}},
+	)
+	require.Nil(t, err)
+	warmup := remedies.NewRemedyWarmup(context.Background(), clock, meter)
+	scopedRemedy := config.ScopedRemedy{
+		Scope:         utils.ScopeEndpoint,
+		Method:        "GET",
+		NormalizedURL: "test.com/some/path",
+		Remedy: &sharedConfig.Remedy{
+			Name:          "my remedy",
+			WarmupSeconds: 10,
+			WarmupEndsAt:  clock.Now().Add(10 * time.Second),
+			Config: sharedConfig.RemedyConfig{
+				StrategyBasedThrottling: strategyBasedThrottlingRemedyConfig(
+					1, 10, nil, false),
+			},
+		},
+	}
+	onRequest := func() actions.ReqLunarAction {
+		action, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+		require.Nil(t, err)
+		return warmup.OnRequest(scopedRemedy, action)
+	}
+	wantBlocked := getEarlyResponseAction()
+
+	// In its warmup, the remedy counts requests without enforcing its quota
+	assert.Equal(t, &actions.NoOpAction{}, onRequest())
+	assert.Equal(t, &actions.NoOpAction{}, onRequest())
+	assert.Equal(t, map[string]int64{"no_op": 1, "obtained_response": 1},
+		collectPerAttribute(t, reader, "lunar_remedies.warmup.requests", "action"))
+
+	// Once the warmup ended, requests of the next windows are enforced on
+	clock.AdvanceTime(20 * time.Second)
+	assert.Equal(t, &actions.NoOpAction{}, onRequest())
+	assert.Equal(t, &wantBlocked, onRequest())
+	// Enforced requests are no longer counted as observed
+	assert.Equal(t, map[string]int64{"no_op": 1, "obtained_response": 1},
+		collectPerAttribute(t, reader, "lunar_remedies.warmup.requests", "action"))
+}
@@ -6,6 +6,7 @@ import (
 	"lunar/engine/actions"
 	"lunar/engine/messages"
 	"lunar/engine/utils"
+	"lunar/engine/utils/events"
 	sharedConfig "lunar/shared-model/config"
 	"lunar/toolkit-core/clock"
 	"strconv"
@@ -23,6 +24,8 @@ type CacheKey struct {
 type ResponseBasedThrottlingPlugin struct {
 	responseCache utils.Cache[CacheKey, CachedResponse]
 	clock         clock.Clock
+	// nil unless throttled providers should be published
+	events *events.Bus
 }
 
 func NewResponseBasedThrottlingPlugin(
@@ -31,9 +34,15 @@ func NewResponseBasedThrottlingPlugin(
 	return &ResponseBasedThrottlingPlugin{
 		responseCache: utils.NewMemoryCache[CacheKey, CachedResponse](clock),
 		clock:         clock,
+		events:        nil,
 	}
 }
 
+// SetEventBus publishes the providers throttling requests to the given bus
+func (plugin *ResponseBasedThrottlingPlugin) SetEventBus(bus *events.Bus) {
+	plugin.events = bus
+}
+
 func (plugin *ResponseBasedThrottlingPlugin) OnRequest(
 	onRequest messages.OnRequest,
 	remedyConfig *sharedConfig.ResponseBasedThrottlingConfig,
@@ -111,7 +120,13 @@ func (plugin *ResponseBasedThrottlingPlugin) OnResponse(
 	err = plugin.responseCache.Set(cacheKey, cachedResponse, retryAfterSeconds)
 	if err != nil {
 		log.Warn().Msgf("Cannot add item to cache: %+v", err)
+		return &actions.NoOpAction{}, nil
 	}
+	plugin.events.Publish(events.ProviderThrottled{
+		Provider: providerOf(onResponse.URL),
+		Until: plugin.clock.Now().Add(
+			time.Duration(retryAfterSeconds * float64(time.Second))),
+	})
 	return &actions.NoOpAction{}, nil
 }
 
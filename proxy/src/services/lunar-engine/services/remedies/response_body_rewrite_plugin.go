@@ -0,0 +1,56 @@
+package remedies
+
+import (
+	"lunar/engine/actions"
+	"lunar/engine/messages"
+	sharedConfig "lunar/shared-model/config"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	contentTypeHeaderName           = "Content-Type"
+	contentLengthHeaderName         = "Content-Length"
+	defaultRewrittenBodyContentType = "text/plain"
+)
+
+// ResponseBodyRewritePlugin replaces the body of responses with the
+// configured status codes, so upstream error details are not disclosed
+// to clients while the status they rely on is kept
+type ResponseBodyRewritePlugin struct{}
+
+func NewResponseBodyRewritePlugin() *ResponseBodyRewritePlugin {
+	return &ResponseBodyRewritePlugin{}
+}
+
+func (plugin *ResponseBodyRewritePlugin) OnRequest(
+	_ messages.OnRequest,
+	_ *sharedConfig.ResponseBodyRewriteConfig,
+) (actions.ReqLunarAction, error) {
+	return &actions.NoOpAction{}, nil
+}
+
+func (plugin *ResponseBodyRewritePlugin) OnResponse(
+	onResponse messages.OnResponse,
+	remedyConfig *sharedConfig.ResponseBodyRewriteConfig,
+) (actions.RespLunarAction, error) {
+	if !sharedConfig.StatusCodeMatches(onResponse.Status, remedyConfig.StatusCode) {
+		return &actions.NoOpAction{}, nil
+	}
+
+	contentType := remedyConfig.ContentType
+	if contentType == "" {
+		contentType = defaultRewrittenBodyContentType
+	}
+	body := remedyConfig.Body
+	log.Trace().Msgf("Rewriting body of %v response of %v",
+		onResponse.Status, onResponse.URL)
+	return &actions.ModifyResponseAction{
+		HeadersToSet: map[string]string{
+			contentTypeHeaderName:   contentType,
+			contentLengthHeaderName: strconv.Itoa(len(body)),
+		},
+		Body: &body,
+	}, nil
+}
@@ -0,0 +1,90 @@
+package remedies_test
+
+import (
+	"lunar/engine/actions"
+	"lunar/engine/services/remedies"
+	sharedConfig "lunar/shared-model/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func responseBodyRewriteConfig() sharedConfig.ResponseBodyRewriteConfig {
+	return sharedConfig.ResponseBodyRewriteConfig{
+		StatusCode: []sharedConfig.Range[int]{
+			{From: 500, To: 599},
+			{From: 404},
+		},
+		Body:        `{"error": "Something went wrong"}`,
+		ContentType: "application/json",
+	}
+}
+
+func TestResponseBodyRewriteSanitizesBodyOfMatchingStatus(t *testing.T) {
+	t.Parallel()
+	plugin := remedies.NewResponseBodyRewritePlugin()
+	remedyConfig := responseBodyRewriteConfig()
+	onResponse := basicResponseArgs(500, "panic: connection to db-internal:5432 refused",
+		map[string]string{"Content-Type": "text/plain", "Content-Length": "44"})
+
+	action, err := plugin.OnResponse(onResponse, &remedyConfig)
+	require.Nil(t, err)
+
+	wantBody := `{"error": "Something went wrong"}`
+	assert.Equal(t, &actions.ModifyResponseAction{
+		HeadersToSet: map[string]string{
+			"Content-Type":   "application/json",
+			"Content-Length": "33",
+		},
+		Body: &wantBody,
+	}, action)
+	action.EnsureResponseIsUpdated(&onResponse)
+	assert.Equal(t, 500, onResponse.Status)
+	assert.Equal(t, wantBody, onResponse.Body)
+}
+
+func TestResponseBodyRewriteMatchesSingleStatusCode(t *testing.T) {
+	t.Parallel()
+	plugin := remedies.NewResponseBodyRewritePlugin()
+	remedyConfig := responseBodyRewriteConfig()
+
+	action, err := plugin.OnResponse(
+		basicResponseArgs(404, "no such table: users", map[string]string{}),
+		&remedyConfig)
+	require.Nil(t, err)
+	assert.IsType(t, &actions.ModifyResponseAction{}, action)
+
+	action, err = plugin.OnResponse(
+		basicResponseArgs(403, "Forbidden", map[string]string{}),
+		&remedyConfig)
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+}
+
+func TestResponseBodyRewritePassesSuccessfulResponseThrough(t *testing.T) {
+	t.Parallel()
+	plugin := remedies.NewResponseBodyRewritePlugin()
+	remedyConfig := responseBodyRewriteConfig()
+
+	action, err := plugin.OnResponse(
+		basicResponseArgs(200, `{"id": 1}`, map[string]string{}),
+		&remedyConfig)
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+}
+
+func TestResponseBodyRewriteDefaultsToPlainTextContentType(t *testing.T) {
+	t.Parallel()
+	plugin := remedies.NewResponseBodyRewritePlugin()
+	remedyConfig := responseBodyRewriteConfig()
+	remedyConfig.ContentType = ""
+
+	action, err := plugin.OnResponse(
+		basicResponseArgs(503, "upstream overloaded", map[string]string{}),
+		&remedyConfig)
+	require.Nil(t, err)
+	modify, ok := action.(*actions.ModifyResponseAction)
+	require.True(t, ok)
+	assert.Equal(t, "text/plain", modify.HeadersToSet["Content-Type"])
+}
@@ -0,0 +1,126 @@
+package remedies
+
+import (
+	"context"
+	sharedConfig "lunar/shared-model/config"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const shadowBlockedAttribute = "shadow_blocked"
+
+// shadowQuotaKey identifies the shadow limiter of a remedy,
+// which restarts once its window or quota were reconfigured.
+// Remedies in their warmup count their enforced quota on a shadow limiter
+// as well, which is kept apart from the one of their shadow quota.
+type shadowQuotaKey struct {
+	remedyName string
+	windowSize time.Duration
+	quota      int64
+	enforced   bool
+}
+
+// shadowLimiter counts the requests of the current window against the
+// shadow quota. Windows are aligned to the epoch, like those of the queues.
+type shadowLimiter struct {
+	windowEnd time.Time
+	count     int64
+}
+
+// shadowQuotas evaluates the shadow quotas of the queue remedies, without
+// affecting admission: requests arriving once the shadow quota of their
+// window was used up are recorded as requests it would have rejected
+type shadowQuotas struct {
+	mutex    sync.Mutex
+	limiters map[shadowQuotaKey]*shadowLimiter
+	requests metric.Int64Counter
+}
+
+func newShadowQuotas(meter metric.Meter) *shadowQuotas {
+	requests, _ := meter.Int64Counter(
+		queueShadowRequestsInstrument.name,
+		queueShadowRequestsInstrument.withDescription(),
+		queueShadowRequestsInstrument.withUnit(),
+	)
+	return &shadowQuotas{
+		mutex:    sync.Mutex{},
+		limiters: map[shadowQuotaKey]*shadowLimiter{},
+		requests: requests,
+	}
+}
+
+// evaluate returns whether the shadow quota would have rejected the request,
+// and false if the remedy has no active shadow quota
+func (shadow *shadowQuotas) evaluate(
+	now time.Time,
+	remedyName string,
+	remedyConfig sharedConfig.StrategyBasedQueueConfig,
+) (blocked bool, active bool) {
+	shadowQuota := remedyConfig.ShadowQuota
+	if shadowQuota == nil || !shadowQuota.Enabled {
+		return false, false
+	}
+	key := shadowQuotaKey{
+		remedyName: remedyName,
+		windowSize: time.Duration(remedyConfig.WindowSizeInSeconds) * time.Second,
+		quota:      shadowQuota.AllowedRequestCount,
+		enforced:   false,
+	}
+	return shadow.exceeds(now, key), true
+}
+
+// evaluateEnforced returns whether the enforced quota of the remedy would
+// have been used up by the request, had the remedy rejected requests once
+// their window was used up rather than queueing them
+func (shadow *shadowQuotas) evaluateEnforced(
+	now time.Time,
+	remedyName string,
+	remedyConfig sharedConfig.StrategyBasedQueueConfig,
+) bool {
+	return shadow.exceeds(now, shadowQuotaKey{
+		remedyName: remedyName,
+		windowSize: time.Duration(remedyConfig.WindowSizeInSeconds) * time.Second,
+		quota:      remedyConfig.AllowedRequestCount,
+		enforced:   true,
+	})
+}
+
+// exceeds counts the request on the limiter of the given key,
+// returning whether it exceeds the quota of the current window
+func (shadow *shadowQuotas) exceeds(now time.Time, key shadowQuotaKey) bool {
+	shadow.mutex.Lock()
+	defer shadow.mutex.Unlock()
+	limiter, found := shadow.limiters[key]
+	if !found {
+		limiter = &shadowLimiter{windowEnd: time.Time{}, count: 0}
+		shadow.limiters[key] = limiter
+	}
+	if !now.Before(limiter.windowEnd) {
+		limiter.windowEnd = now.Add(untilNextWindow(now, key.windowSize))
+		limiter.count = 0
+	}
+	limiter.count++
+	return limiter.count > key.quota
+}
+
+// record counts a request evaluated by the shadow quota,
+// along with the decision of the enforced quota to compare with
+func (shadow *shadowQuotas) record(
+	ctx context.Context,
+	remedyName string,
+	shadowBlocked bool,
+	blocked bool,
+) {
+	shadow.requests.Add(
+		ctx,
+		1,
+		metric.WithAttributes(
+			attribute.String(remedyAttribute, remedyName),
+			attribute.Bool(shadowBlockedAttribute, shadowBlocked),
+			attribute.Bool(blockedAttribute, blocked),
+		),
+	)
+}
@@ -1,8 +1,16 @@
 package remedies_test
 
 import (
+	"context"
 	"lunar/engine/messages"
+	"lunar/engine/utils/tenant"
+	"testing"
 	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 )
 
 // TODO: rename (remove 1)
@@ -55,3 +63,72 @@ func basicResponseArgs(
 		Time:       time.Now(),
 	}
 }
+
+// collectPerTenant returns the values recorded on the given metric per tenant
+func collectPerTenant(
+	t *testing.T,
+	reader *sdkMetric.ManualReader,
+	metricName string,
+) map[string]int64 {
+	return collectPerAttribute(t, reader, metricName, tenant.AttributeName)
+}
+
+// collectPerAttribute returns the values recorded on the given metric
+// per value of the given attribute
+func collectPerAttribute(
+	t *testing.T,
+	reader *sdkMetric.ManualReader,
+	metricName string,
+	attributeName attribute.Key,
+) map[string]int64 {
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+
+	perAttribute := map[string]int64{}
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, recordedMetric := range scopeMetrics.Metrics {
+			if recordedMetric.Name != metricName {
+				continue
+			}
+			var dataPoints []metricdata.DataPoint[int64]
+			switch data := recordedMetric.Data.(type) {
+			case metricdata.Sum[int64]:
+				dataPoints = data.DataPoints
+			case metricdata.Gauge[int64]:
+				dataPoints = data.DataPoints
+			}
+			for _, dataPoint := range dataPoints {
+				value, _ := dataPoint.Attributes.Value(attributeName)
+				perAttribute[value.Emit()] += dataPoint.Value
+			}
+		}
+	}
+	return perAttribute
+}
+
+// collectFloatPerAttribute returns the values recorded on the given
+// float gauge per value of the given attribute
+func collectFloatPerAttribute(
+	t *testing.T,
+	reader *sdkMetric.ManualReader,
+	metricName string,
+	attributeName attribute.Key,
+) map[string]float64 {
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+
+	perAttribute := map[string]float64{}
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, recordedMetric := range scopeMetrics.Metrics {
+			if recordedMetric.Name != metricName {
+				continue
+			}
+			gauge, _ := recordedMetric.Data.(metricdata.Gauge[float64])
+			for _, dataPoint := range gauge.DataPoints {
+				value, _ := dataPoint.Attributes.Value(attributeName)
+				perAttribute[value.Emit()] += dataPoint.Value
+			}
+		}
+	}
+	return perAttribute
+}
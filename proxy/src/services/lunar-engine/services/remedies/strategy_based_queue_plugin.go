@@ -2,13 +2,23 @@ package remedies
 
 import (
 	"context"
+	"fmt"
 	"lunar/engine/actions"
 	"lunar/engine/config"
 	"lunar/engine/messages"
+	"lunar/engine/utils/events"
+	"lunar/engine/utils/expression"
+	"lunar/engine/utils/limit/concurrency"
 	"lunar/engine/utils/queue"
+	"lunar/engine/utils/tenant"
+	"lunar/engine/utils/upstreamhost"
 	sharedConfig "lunar/shared-model/config"
 	"lunar/toolkit-core/clock"
 	"lunar/toolkit-core/logging"
+	"lunar/toolkit-core/vacuum"
+	"math"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -25,20 +35,132 @@ type StrategyBasedQueuePlugin struct {
 	metrics     strategyBasedQueueMetrics
 	initQueue   InitializeQueueFunc
 	cl          logging.ContextLogger
+	tenants     *tenant.Resolver
+	// the queue each remedy (or shared limit group) last used, by its name.
+	// Guarded by the queues mutex; its strategy is the effective one, while
+	// prior queues of the remedy may still drain waiting requests
+	activeQueueKeys map[string]queue.QueueKey
+
+	// requests currently waiting on a queue, counted per tenant
+	inQueueMutex  sync.Mutex
+	inQueueCounts map[inQueueKey]int64
+
+	// requests which were not allowed to proceed, counted per remedy
+	rejectedMutex  sync.Mutex
+	rejectedCounts map[string]int64
+	// the rejected requests out of those handled, over a rolling window
+	rejectionRatios *rejectionRatios
+	// nil unless rejected requests should be exported
+	rejectedRequestsExporter RejectedRequestsExporter
+
+	// queues of transactions which were allowed to proceed, by transaction ID.
+	// Transactions which never get a response are vacuumed after the proxy timeout
+	proceededTransactions      map[string]queue.QueueKey
+	proceededTransactionsMutex *sync.RWMutex
+	proceededVacuum            *vacuum.MapVacuum[string, queue.QueueKey]
+
+	// compiled priority expressions, by their source
+	priorityExpressionsMutex sync.RWMutex
+	priorityExpressions      map[string]*expression.Expression
+
+	// bounds the priorities the metrics are labeled with
+	priorityLabels *priorityLabels
+	shadowQuotas   *shadowQuotas
+
+	// providers which throttled requests, by until when
+	throttledProvidersMutex sync.RWMutex
+	throttledProviders      map[string]time.Time
+
+	// limiters of the requests in flight, by remedy
+	concurrencySlotsMutex sync.Mutex
+	concurrencySlots      map[string]*concurrency.PriorityLimiter
+	proxyTimeout          time.Duration
+
+	// nil unless quotas may be overridden by a dynamic source
+	dynamicQuotas *DynamicQuotas
+	// nil unless the requests metric is labeled by upstream host
+	hosts *upstreamhost.Labels
 }
 
 const (
-	requestsInQueueMetricName = "lunar_remedies.strategy_based_queue.requests_in_queue"
-	requestsMetricName        = "lunar_remedies.strategy_based_queue.requests"
-	// deepcode ignore HardcodedPassword: <This is not a password>
-	ttlPassedAttribute = "ttl_passed"
-	remedyAttribute    = "remedy"
-	priorityAttribute  = "priority"
+	// reason the request proceeded or was rejected, of queue.Outcome or
+	// one of the rejection reasons of the plugin, so its values are bounded
+	reasonAttribute      = "reason"
+	remedyAttribute      = "remedy"
+	priorityAttribute    = "priority"
+	windowQuotaAttribute = "window_quota"
+	windowSizeAttribute  = "window_size"
+	// window size in whole seconds, as configured
+	windowSizeSecondsAttribute = "window_size_seconds"
+	// how the priority of a request was resolved, one of the priority matches
+	priorityMatchAttribute = "match"
+
+	// the priority was evaluated by the prioritization expression
+	priorityMatchExpression = "expression"
+	// the priority is of the priority groups the request matched
+	priorityMatchGroup = "group"
+	// the request matched no priority group, falling back to the default
+	priorityMatchDefault = "default"
+
+	proceededTransactionsVacuumName = "StrategyBasedQueueProceededVacuum"
+
+	// provider throttled events kept pending for the plugin to handle
+	throttledProvidersBufferSize = 100
+
+	// priorityExpressionTimeout guards the evaluation of a priority expression
+	priorityExpressionTimeout = 10 * time.Millisecond
+
+	defaultPriorityAgingInterval = time.Second
+	// rejection reason of requests which found no concurrency slot in their TTL
+	concurrencySlotTTLExpiredReason = "concurrency_slot_ttl_expired"
+	// rejection reason of requests whose client's deadline passed first
+	deadlineExceededReason = "deadline_exceeded"
+	// rejection reason of requests shed without queueing them,
+	// as their provider is throttled
+	providerThrottledReason = "provider_throttled"
+
+	defaultRateLimitLimitHeader     = "X-RateLimit-Limit"
+	defaultRateLimitRemainingHeader = "X-RateLimit-Remaining"
+	defaultRateLimitResetHeader     = "X-RateLimit-Reset"
 )
 
+type inQueueKey struct {
+	remedyName string
+	priority   float64
+	tenantID   string
+}
+
 type strategyBasedQueueMetrics struct {
 	requestsInQueue metric.Int64ObservableGauge
-	requests        metric.Int64Counter
+	// the requests in queue per remedy, observed along with requestsInQueue
+	requestsInQueueTotal metric.Int64ObservableGauge
+	requests             *sampledCounter
+	oldestRequestAge     metric.Float64ObservableGauge
+	strategyInfo         metric.Int64ObservableGauge
+	evictedRequests      metric.Int64Counter
+	// only counts requests of remedies with prioritization configured
+	prioritizedRequests metric.Int64Counter
+}
+
+// newQueueKey keys the queue of the remedy's strategy by the remedy's shared
+// limit group if it has one, so the remedies of the group share the queue
+func newQueueKey(
+	remedyName string,
+	remedyConfig sharedConfig.StrategyBasedQueueConfig,
+	strategy queue.Strategy,
+) queue.QueueKey {
+	if remedyConfig.SharedLimitGroup != "" {
+		return queue.QueueKey{
+			RemedyName:       "",
+			SharedLimitGroup: remedyConfig.SharedLimitGroup,
+			Strategy:         strategy,
+		}
+	}
+	return queue.QueueKey{
+		RemedyName:       remedyName,
+		SharedLimitGroup: "",
+		Strategy:         strategy,
+	}
 }
 
 type InitializeQueueFunc func(
@@ -48,10 +170,23 @@ type InitializeQueueFunc func(
 func NewStrategyBasedQueuePlugin(
 	ctx context.Context,
 	clock clock.Clock,
+	proxyTimeout time.Duration,
 	contextLogger logging.ContextLogger,
 	meter metric.Meter,
+	tenantResolver *tenant.Resolver,
+	rejectedRequestsExporter RejectedRequestsExporter,
 	initializeQueueFunc InitializeQueueFunc,
 ) *StrategyBasedQueuePlugin {
+	proceededTransactions := map[string]queue.QueueKey{}
+	proceededTransactionsMutex := sync.RWMutex{}
+	proceededVacuum := vacuum.NewMapVacuum(
+		proceededTransactionsVacuumName,
+		clock,
+		proxyTimeout,
+		vacuumTick,
+		proceededTransactions,
+		&proceededTransactionsMutex,
+	)
 	plugin := &StrategyBasedQueuePlugin{ //nolint:exhaustruct
 		clock:       clock,
 		queuesMutex: sync.RWMutex{},
@@ -59,11 +194,44 @@ func NewStrategyBasedQueuePlugin(
 		ctx:         ctx,
 		cl:          contextLogger.WithComponent("strategy-based-queue"),
 		initQueue:   initializeQueueFunc,
+		tenants:     tenantResolver,
+
+		activeQueueKeys: map[string]queue.QueueKey{},
+
+		inQueueMutex:  sync.Mutex{},
+		inQueueCounts: map[inQueueKey]int64{},
+
+		rejectedMutex:            sync.Mutex{},
+		rejectedCounts:           map[string]int64{},
+		rejectedRequestsExporter: rejectedRequestsExporter,
+
+		throttledProvidersMutex: sync.RWMutex{},
+		throttledProviders:      map[string]time.Time{},
+
+		concurrencySlotsMutex: sync.Mutex{},
+		concurrencySlots:      map[string]*concurrency.PriorityLimiter{},
+		proxyTimeout:          proxyTimeout,
+
+		proceededTransactions:      proceededTransactions,
+		proceededTransactionsMutex: &proceededTransactionsMutex,
+		proceededVacuum:            &proceededVacuum,
+
+		priorityExpressionsMutex: sync.RWMutex{},
+		priorityExpressions:      map[string]*expression.Expression{},
+
+		priorityLabels: newPriorityLabels(),
 	}
-	plugin.metrics.requestsInQueue = plugin.initializeRequestsInQueueMetric(
+	plugin.metrics.requestsInQueue, plugin.metrics.requestsInQueueTotal =
+		plugin.initializeRequestsInQueueMetrics(meter)
+	plugin.metrics.requests = plugin.initializeRequestsMetric(meter)
+	plugin.metrics.evictedRequests = plugin.initializeEvictedRequestsMetric(meter)
+	plugin.metrics.prioritizedRequests = plugin.initializePrioritizedRequestsMetric(meter)
+	plugin.shadowQuotas = newShadowQuotas(meter)
+	plugin.rejectionRatios = newRejectionRatios(clock, meter)
+	plugin.metrics.oldestRequestAge = plugin.initializeOldestRequestAgeMetric(
 		meter,
 	)
-	plugin.metrics.requests = plugin.initializeRequestsMetric(meter)
+	plugin.metrics.strategyInfo = plugin.initializeStrategyInfoMetric(meter)
 	return plugin
 }
 
@@ -79,39 +247,125 @@ func (plugin *StrategyBasedQueuePlugin) OnRequest(
 		return &actions.NoOpAction{}, ErrMissingConfig
 	}
 
-	strategy := queue.Strategy{
-		WindowQuota: remedyConfig.AllowedRequestCount,
-		WindowSize: time.Duration(
-			remedyConfig.WindowSizeInSeconds,
-		) * time.Second,
+	// In its warmup, the remedy admits requests without queueing them,
+	// so only the requests beyond its quota are rejected, to be observed
+	if scopedRemedy.Remedy.IsWarmingUp(plugin.clock.Now()) {
+		if !plugin.shadowQuotas.evaluateEnforced(
+			plugin.clock.Now(), scopedRemedy.Remedy.Name, *remedyConfig) {
+			return &actions.NoOpAction{}, nil
+		}
+		action := tooManyRequestsAction(
+			scopedRemedy.Remedy,
+			remedyConfig.ResponseStatusCode,
+			rejectionDetails{
+				RequestID: onRequest.ID,
+				RetryAfter: untilNextWindow(
+					plugin.clock.Now(),
+					time.Duration(remedyConfig.WindowSizeInSeconds)*time.Second,
+				),
+			},
+		)
+		return &action, nil
 	}
 
-	queueKey := queue.QueueKey{
-		RemedyName: scopedRemedy.Remedy.Name,
-		Strategy:   strategy,
-	}
+	windowSize := time.Duration(remedyConfig.WindowSizeInSeconds) * time.Second
+	queueKey := newQueueKey(scopedRemedy.Remedy.Name, *remedyConfig,
+		queue.Strategy{WindowQuota: 0, WindowSize: windowSize})
+	// The dynamic quota is resolved by the queue's name, so the remedies of
+	// a shared limit group share a single quota, as they share the queue
+	queueKey.Strategy.WindowQuota = plugin.dynamicQuotas.AllowedRequestCount(
+		queueKey.Name(),
+		remedyConfig.AllowedRequestCount,
+		remedyConfig.DynamicQuota,
+		windowSize,
+	)
+	strategy := queueKey.Strategy
 
 	plugin.queuesMutex.Lock()
 	relevantQueue, found := plugin.queues[queueKey]
+	if !found {
+		relevantQueue, found = plugin.transitionQuota(queueKey)
+	}
 	if !found {
 		relevantQueue = plugin.initQueue(queueKey)
+		// A dynamic quota's new queue carries over the usage of its prior one,
+		// so an increased quota does not admit a burst
+		if remedyConfig.WarmStart || remedyConfig.DynamicQuota != nil {
+			plugin.warmStart(queueKey, relevantQueue)
+		}
 		plugin.cl.Logger.Trace().
 			Msgf("Initialized delayed prioritized queue for %s (%+v)",
-				scopedRemedy.Remedy.Name, strategy)
+				queueKey.Name(), strategy)
 		plugin.queues[queueKey] = relevantQueue
 	}
+	plugin.activeQueueKeys[queueKey.Name()] = queueKey
 	plugin.queuesMutex.Unlock()
 
-	priority := extractPriority(onRequest, *remedyConfig)
+	priority, match := plugin.extractPriority(onRequest, *remedyConfig)
 	plugin.cl.Logger.Trace().Str("requestID", onRequest.ID).
-		Msgf("extracted priority %f", priority)
+		Msgf("extracted priority %f (%s)", priority, match)
+	if remedyConfig.Prioritization != nil {
+		plugin.incrementPrioritizedRequestsMetric(scopedRemedy.Remedy, match)
+	}
 
+	priorityLabel := plugin.priorityLabels.label(
+		scopedRemedy.Remedy.Name, remedyConfig.Prioritization, priority)
+	tenantID := plugin.tenants.Resolve(onRequest.Headers)
+	inQueue := inQueueKey{
+		remedyName: scopedRemedy.Remedy.Name,
+		priority:   priorityLabel,
+		tenantID:   tenantID,
+	}
+	if remedyConfig.ShedWhileProviderThrottled {
+		if until, throttled := plugin.providerThrottledUntil(
+			providerOf(onRequest.URL)); throttled {
+			return plugin.shedRequest(onRequest, scopedRemedy, priority,
+				priorityLabel, tenantID, until.Sub(plugin.clock.Now())), nil
+		}
+	}
+	// The shadow quota is evaluated as requests arrive, as the enforced one
+	shadowBlocked, shadowActive := plugin.shadowQuotas.evaluate(
+		plugin.clock.Now(), scopedRemedy.Remedy.Name, *remedyConfig)
 	request := queue.NewRequest(onRequest.ID, priority, plugin.clock)
+	ttl := time.Duration(remedyConfig.TTLSeconds) * time.Second
+	grace := time.Duration(remedyConfig.TTLGraceMilliseconds) * time.Millisecond
+	// A client which gives up before the TTL passes is not kept waiting for,
+	// nor given the grace, as it would not be there to be responded to
+	boundedByDeadline := false
+	if onRequest.Deadline.IsSet() {
+		if remaining := onRequest.Deadline.Remaining(plugin.clock.Now()); remaining < ttl {
+			ttl, grace, boundedByDeadline = remaining, 0, true
+		}
+	}
+	if !boundedByDeadline && ttlExtensionEligible(remedyConfig.TTLExtension, priority) {
+		request.AllowTTLExtension(
+			time.Duration(remedyConfig.TTLExtension.MaxMilliseconds) * time.Millisecond)
+	}
+	plugin.updateInQueueCount(inQueue, 1)
 	canProceed, err := relevantQueue.Enqueue(
 		request,
-		time.Duration(remedyConfig.TTLSeconds)*time.Second,
+		ttl,
+		grace,
 		remedyConfig.QueueSize,
+		queueOverflowPolicy(*remedyConfig),
 	)
+	rejectionReason := request.Outcome().String()
+	if err == nil && canProceed && remedyConfig.MaxConcurrentRequests > 0 {
+		// Requests admitted by the quota still wait for a concurrency slot by
+		// priority, so higher priority requests win both stages
+		canProceed = plugin.concurrencySlotsOf(scopedRemedy.Remedy.Name,
+			*remedyConfig).TakeSlot(onRequest.ID, priority, ttl-request.WaitTime())
+		if !canProceed {
+			rejectionReason = concurrencySlotTTLExpiredReason
+		}
+	}
+	deadlineExceeded := boundedByDeadline && !canProceed &&
+		(request.Outcome() == queue.OutcomeTTLExpired ||
+			rejectionReason == concurrencySlotTTLExpiredReason)
+	if deadlineExceeded {
+		rejectionReason = deadlineExceededReason
+	}
+	plugin.updateInQueueCount(inQueue, -1)
 	if err != nil {
 		plugin.cl.Logger.Error().Err(err).
 			Msg("failed enqueueing request")
@@ -122,101 +376,860 @@ func (plugin *StrategyBasedQueuePlugin) OnRequest(
 		Str("requestID", onRequest.ID).
 		Msgf("can proceed response: %v", canProceed)
 
+	if shadowActive {
+		plugin.shadowQuotas.record(plugin.ctx, scopedRemedy.Remedy.Name,
+			shadowBlocked, !canProceed)
+	}
+	tenantAttribute := attribute.String(tenant.AttributeName, tenantID)
+	tracedCtx := tracedContext(plugin.ctx, onRequest)
 	if canProceed {
 		plugin.incrementRequestsMetric(
-			scopedRemedy.Remedy.Name,
-			priority,
-			false,
+			onRequest,
+			scopedRemedy,
+			priorityLabel,
+			request.Outcome().String(),
+			tenantAttribute,
 		)
+		plugin.rejectionRatios.record(scopedRemedy.Remedy.Name, false)
+		plugin.proceededTransactionsMutex.Lock()
+		plugin.proceededTransactions[onRequest.ID] = queueKey
+		plugin.proceededTransactionsMutex.Unlock()
+		plugin.proceededVacuum.VacuumKey(onRequest.ID)
 		return &actions.NoOpAction{}, nil
 	}
-	plugin.incrementRequestsMetric(scopedRemedy.Remedy.Name, priority, true)
+	plugin.incrementRequestsMetric(
+		onRequest,
+		scopedRemedy,
+		priorityLabel,
+		rejectionReason,
+		tenantAttribute,
+	)
+	if request.Outcome() == queue.OutcomeEvicted {
+		plugin.metrics.evictedRequests.Add(tracedCtx, 1, metric.WithAttributes(
+			attribute.String(remedyAttribute, scopedRemedy.Remedy.Name),
+			attribute.Float64(priorityAttribute, priorityLabel),
+			tenantAttribute,
+		))
+	}
+	plugin.rejectedMutex.Lock()
+	plugin.rejectedCounts[scopedRemedy.Remedy.Name]++
+	plugin.rejectedMutex.Unlock()
+	plugin.rejectionRatios.record(scopedRemedy.Remedy.Name, true)
 
-	plugin.cl.Logger.Trace().Str("requestID", onRequest.ID).
+	withTraceID(plugin.cl.Logger.Trace(), onRequest).Str("requestID", onRequest.ID).
 		Msgf("request cannot be processed, will return early response")
-	action := plainTextTooManyRequestsAction(
+	action := tooManyRequestsAction(
+		scopedRemedy.Remedy,
 		remedyConfig.ResponseStatusCode,
+		rejectionDetails{
+			RequestID: onRequest.ID,
+			RetryAfter: untilNextWindow(
+				plugin.clock.Now(),
+				time.Duration(remedyConfig.WindowSizeInSeconds)*time.Second,
+			),
+		},
 	)
+	if deadlineExceeded {
+		action = *actions.NewDeadlineExceededAction(onRequest.Deadline)
+	}
+	if plugin.rejectedRequestsExporter != nil {
+		plugin.rejectedRequestsExporter.ExportRejectedRequest(RejectedRequest{
+			RequestID:  onRequest.ID,
+			RemedyName: scopedRemedy.Remedy.Name,
+			Priority:   priority,
+			TenantID:   tenantID,
+			EnqueuedAt: request.EnqueuedAt(),
+			WaitTime:   request.WaitTime(),
+			Reason:     rejectionReason,
+			StatusCode: action.Status,
+			Method:     onRequest.Method,
+			Path:       onRequest.Path,
+			Headers:    onRequest.Headers,
+		})
+	}
 	return &action, nil
 }
 
+// SetDynamicQuotas has the remedies with a dynamic quota
+// apply the quotas of the given source
+func (plugin *StrategyBasedQueuePlugin) SetDynamicQuotas(dynamicQuotas *DynamicQuotas) {
+	plugin.dynamicQuotas = dynamicQuotas
+}
+
+// SetHostLabels has the requests metric be labeled by the upstream host
+// of the remedies' target
+func (plugin *StrategyBasedQueuePlugin) SetHostLabels(hosts *upstreamhost.Labels) {
+	plugin.hosts = hosts
+}
+
+// SetRejectionRatioWindow sets the rolling window the rejection ratio
+// metric is computed over
+func (plugin *StrategyBasedQueuePlugin) SetRejectionRatioWindow(window time.Duration) {
+	plugin.rejectionRatios.setWindow(window)
+}
+
+// ForgetRemovedRemedies forgets the queue strategies of the remedies
+// (or shared limit groups), and the rejection ratios of the remedies,
+// the given policies no longer configure
+func (plugin *StrategyBasedQueuePlugin) ForgetRemovedRemedies(
+	policies *sharedConfig.PoliciesConfig,
+) {
+	configured := map[string]struct{}{}
+	configuredRemedies := map[string]struct{}{}
+	configure := func(remedies []sharedConfig.Remedy) {
+		for _, remedy := range remedies {
+			remedyConfig := remedy.Config.StrategyBasedQueue
+			if remedyConfig == nil {
+				continue
+			}
+			queueKey := newQueueKey(remedy.Name, *remedyConfig,
+				queue.Strategy{WindowQuota: 0, WindowSize: 0})
+			configured[queueKey.Name()] = struct{}{}
+			configuredRemedies[remedy.Name] = struct{}{}
+		}
+	}
+	configure(policies.Global.Remedies)
+	for _, endpoint := range policies.Endpoints {
+		configure(endpoint.Remedies)
+	}
+	plugin.rejectionRatios.forgetRemovedRemedies(configuredRemedies)
+
+	plugin.queuesMutex.Lock()
+	defer plugin.queuesMutex.Unlock()
+	for name := range plugin.activeQueueKeys {
+		if _, found := configured[name]; !found {
+			delete(plugin.activeQueueKeys, name)
+		}
+	}
+}
+
+// SubscribeToEvents has the plugin react to the events of the other plugins
+func (plugin *StrategyBasedQueuePlugin) SubscribeToEvents(bus *events.Bus) {
+	bus.Subscribe("strategy_based_queue", events.KindProviderThrottled,
+		throttledProvidersBufferSize, plugin.onProviderThrottled)
+}
+
+func (plugin *StrategyBasedQueuePlugin) onProviderThrottled(event events.Event) {
+	throttled, ok := event.(events.ProviderThrottled)
+	if !ok {
+		return
+	}
+	now := plugin.clock.Now()
+	plugin.throttledProvidersMutex.Lock()
+	defer plugin.throttledProvidersMutex.Unlock()
+	for provider, until := range plugin.throttledProviders {
+		if !now.Before(until) {
+			delete(plugin.throttledProviders, provider)
+		}
+	}
+	if throttled.Until.After(plugin.throttledProviders[throttled.Provider]) {
+		plugin.throttledProviders[throttled.Provider] = throttled.Until
+	}
+}
+
+func (plugin *StrategyBasedQueuePlugin) providerThrottledUntil(
+	provider string,
+) (time.Time, bool) {
+	plugin.throttledProvidersMutex.RLock()
+	until, found := plugin.throttledProviders[provider]
+	plugin.throttledProvidersMutex.RUnlock()
+	return until, found && plugin.clock.Now().Before(until)
+}
+
+// ttlExtensionEligible is whether requests of the priority may have their
+// TTL extended, as configured
+func ttlExtensionEligible(extension *sharedConfig.TTLExtension, priority float64) bool {
+	if extension == nil {
+		return false
+	}
+	for _, eligible := range extension.EligiblePriorities {
+		if eligible == priority {
+			return true
+		}
+	}
+	return false
+}
+
+// shedRequest rejects a request without queueing it, as its provider
+// would throttle it anyway
+func (plugin *StrategyBasedQueuePlugin) shedRequest(
+	onRequest messages.OnRequest,
+	scopedRemedy config.ScopedRemedy,
+	priority float64,
+	priorityLabel float64,
+	tenantID string,
+	retryAfter time.Duration,
+) actions.ReqLunarAction {
+	withTraceID(plugin.cl.Logger.Trace(), onRequest).Str("requestID", onRequest.ID).
+		Msg("provider is throttled, will return early response")
+	plugin.incrementRequestsMetric(
+		onRequest,
+		scopedRemedy,
+		priorityLabel,
+		providerThrottledReason,
+		attribute.String(tenant.AttributeName, tenantID),
+	)
+	plugin.rejectedMutex.Lock()
+	plugin.rejectedCounts[scopedRemedy.Remedy.Name]++
+	plugin.rejectedMutex.Unlock()
+	plugin.rejectionRatios.record(scopedRemedy.Remedy.Name, true)
+	action := tooManyRequestsAction(
+		scopedRemedy.Remedy,
+		scopedRemedy.Remedy.Config.StrategyBasedQueue.ResponseStatusCode,
+		rejectionDetails{RequestID: onRequest.ID, RetryAfter: retryAfter},
+	)
+	if plugin.rejectedRequestsExporter != nil {
+		plugin.rejectedRequestsExporter.ExportRejectedRequest(RejectedRequest{
+			RequestID:  onRequest.ID,
+			RemedyName: scopedRemedy.Remedy.Name,
+			Priority:   priority,
+			TenantID:   tenantID,
+			EnqueuedAt: time.Time{},
+			WaitTime:   0,
+			Reason:     providerThrottledReason,
+			StatusCode: action.Status,
+			Method:     onRequest.Method,
+			Path:       onRequest.Path,
+			Headers:    onRequest.Headers,
+		})
+	}
+	return &action
+}
+
+// concurrencySlotsOf returns the limiter of the requests in flight of a
+// remedy, applying its current limit. Slots of transactions which never
+// get a response are released after the proxy timeout.
+func (plugin *StrategyBasedQueuePlugin) concurrencySlotsOf(
+	remedyName string,
+	remedyConfig sharedConfig.StrategyBasedQueueConfig,
+) *concurrency.PriorityLimiter {
+	plugin.concurrencySlotsMutex.Lock()
+	defer plugin.concurrencySlotsMutex.Unlock()
+	limiter, found := plugin.concurrencySlots[remedyName]
+	if !found {
+		agingInterval := defaultPriorityAgingInterval
+		if remedyConfig.PriorityAgingSeconds > 0 {
+			agingInterval = time.Duration(
+				remedyConfig.PriorityAgingSeconds * float32(time.Second))
+		}
+		limiter = concurrency.NewPriorityLimiter(
+			remedyConfig.MaxConcurrentRequests,
+			plugin.proxyTimeout,
+			agingInterval,
+			plugin.clock,
+		)
+		plugin.concurrencySlots[remedyName] = limiter
+	} else if limiter.ConcurrencyLimit() != remedyConfig.MaxConcurrentRequests {
+		limiter.SetConcurrencyLimit(remedyConfig.MaxConcurrentRequests)
+	}
+	return limiter
+}
+
+func (plugin *StrategyBasedQueuePlugin) releaseConcurrencySlot(
+	remedyName string,
+	transactionID string,
+) {
+	plugin.concurrencySlotsMutex.Lock()
+	limiter, found := plugin.concurrencySlots[remedyName]
+	plugin.concurrencySlotsMutex.Unlock()
+	if found {
+		limiter.ReleaseSlot(transactionID)
+	}
+}
+
+func queueOverflowPolicy(
+	remedyConfig sharedConfig.StrategyBasedQueueConfig,
+) queue.OverflowPolicy {
+	if remedyConfig.Overflow() == sharedConfig.OverflowPolicyRejectOldest {
+		return queue.OverflowRejectOldest
+	}
+	return queue.OverflowRejectNew
+}
+
 // If priority is not defined/find, it will default to 0,
 // which is the highest priority.
-func extractPriority(
+// A configured expression takes precedence over the group mapping,
+// which is fallen back to if the expression fails to evaluate.
+// The priority is returned along with how it was resolved.
+func (plugin *StrategyBasedQueuePlugin) extractPriority(
 	onRequest messages.OnRequest,
 	remedyConfig sharedConfig.StrategyBasedQueueConfig,
-) float64 {
+) (float64, string) {
 	if remedyConfig.Prioritization == nil {
-		return 0
+		return 0, priorityMatchDefault
+	}
+	if source := remedyConfig.Prioritization.Expression; source != "" {
+		priority, err := plugin.evaluatePriorityExpression(source, onRequest)
+		if err == nil {
+			return priority, priorityMatchExpression
+		}
+		plugin.cl.Logger.Warn().Err(err).Str("requestID", onRequest.ID).
+			Msgf("Failed evaluating priority expression '%v', "+
+				"falling back to priority groups", source)
 	}
-	headerName := remedyConfig.Prioritization.GroupBy.HeaderName
-	headerValue := onRequest.Headers[headerName]
-	prioritization := remedyConfig.Prioritization.Groups[headerValue]
+	priority, matched := remedyConfig.Prioritization.MatchPriorityOf(
+		onRequest.Path, &onRequest)
+	if !matched {
+		return priority, priorityMatchDefault
+	}
+	return priority, priorityMatchGroup
+}
 
-	return prioritization.Priority
+func (plugin *StrategyBasedQueuePlugin) evaluatePriorityExpression(
+	source string,
+	onRequest messages.OnRequest,
+) (float64, error) {
+	compiled, err := plugin.priorityExpression(source)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(plugin.ctx, priorityExpressionTimeout)
+	defer cancel()
+	priority, err := compiled.EvaluateInt(ctx, expression.Attributes{
+		Method:  onRequest.Method,
+		Path:    onRequest.Path,
+		Headers: onRequest.Headers,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if priority < 0 {
+		return 0, fmt.Errorf("priority must not be negative, got %d", priority)
+	}
+	return float64(priority), nil
 }
 
+// priorityExpression returns the compiled expression of the given source.
+// Expressions are validated on configuration load,
+// so they are compiled here once and reused.
+func (plugin *StrategyBasedQueuePlugin) priorityExpression(
+	source string,
+) (*expression.Expression, error) {
+	plugin.priorityExpressionsMutex.RLock()
+	compiled, found := plugin.priorityExpressions[source]
+	plugin.priorityExpressionsMutex.RUnlock()
+	if found {
+		return compiled, nil
+	}
+
+	compiled, err := expression.Compile(source)
+	if err != nil {
+		return nil, err
+	}
+	plugin.priorityExpressionsMutex.Lock()
+	plugin.priorityExpressions[source] = compiled
+	plugin.priorityExpressionsMutex.Unlock()
+	return compiled, nil
+}
+
+// OnResponse annotates responses of transactions which were allowed
+// to proceed with the usage of the window they were processed in.
 func (plugin *StrategyBasedQueuePlugin) OnResponse(
-	_ messages.OnResponse,
-	_ config.ScopedRemedy,
+	onResponse messages.OnResponse,
+	scopedRemedy config.ScopedRemedy,
 ) (actions.RespLunarAction, error) {
-	return &actions.NoOpAction{}, nil
+	remedyConfig := scopedRemedy.Remedy.Config.StrategyBasedQueue
+	if remedyConfig == nil {
+		plugin.cl.Logger.Error().
+			Err(ErrMissingConfig).
+			Msg("Remedy config missing")
+		return &actions.NoOpAction{}, ErrMissingConfig
+	}
+
+	plugin.proceededTransactionsMutex.Lock()
+	queueKey, found := plugin.proceededTransactions[onResponse.ID]
+	delete(plugin.proceededTransactions, onResponse.ID)
+	plugin.proceededTransactionsMutex.Unlock()
+	if !found {
+		plugin.cl.Logger.Trace().Str("requestID", onResponse.ID).
+			Msg("remedy did not apply to transaction, no rate limit headers")
+		return &actions.NoOpAction{}, nil
+	}
+	plugin.releaseConcurrencySlot(scopedRemedy.Remedy.Name, onResponse.ID)
+
+	plugin.queuesMutex.RLock()
+	relevantQueue, found := plugin.queues[queueKey]
+	plugin.queuesMutex.RUnlock()
+	if !found {
+		plugin.cl.Logger.Warn().Str("requestID", onResponse.ID).
+			Msgf("Queue for %s not found, no rate limit headers",
+				queueKey.Name())
+		return &actions.NoOpAction{}, nil
+	}
+
+	return &actions.ModifyResponseAction{
+		HeadersToSet: plugin.rateLimitHeaders(
+			relevantQueue.WindowUsage(),
+			remedyConfig.RateLimitHeaders,
+		),
+	}, nil
+}
+
+func (plugin *StrategyBasedQueuePlugin) rateLimitHeaders(
+	usage queue.WindowUsage,
+	headerNames *sharedConfig.RateLimitHeaders,
+) map[string]string {
+	limitHeader := defaultRateLimitLimitHeader
+	remainingHeader := defaultRateLimitRemainingHeader
+	resetHeader := defaultRateLimitResetHeader
+	if headerNames != nil {
+		limitHeader = valueOrDefault(headerNames.Limit, limitHeader)
+		remainingHeader = valueOrDefault(headerNames.Remaining, remainingHeader)
+		resetHeader = valueOrDefault(headerNames.Reset, resetHeader)
+	}
+
+	remaining := usage.Quota - usage.Used
+	if remaining < 0 {
+		remaining = 0
+	}
+	// Reset is reported as the number of seconds left in the current window
+	resetSeconds := math.Ceil(usage.EndTime.Sub(plugin.clock.Now()).Seconds())
+	if resetSeconds < 0 {
+		resetSeconds = 0
+	}
+
+	return map[string]string{
+		limitHeader:     strconv.FormatInt(usage.Quota, 10),
+		remainingHeader: strconv.FormatInt(remaining, 10),
+		resetHeader:     strconv.FormatInt(int64(resetSeconds), 10),
+	}
+}
+
+func valueOrDefault(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
 }
 
-func (plugin *StrategyBasedQueuePlugin) initializeRequestsInQueueMetric(
+// initializeRequestsInQueueMetrics only registers the callback observing the
+// gauges once they were created, so a failure to create them leaves no
+// callback behind and the requests are handled without the metrics.
+// Both gauges are observed by the same callback, so the total of each remedy
+// is always the sum of its per-priority counts.
+func (plugin *StrategyBasedQueuePlugin) initializeRequestsInQueueMetrics(
 	meter metric.Meter,
-) metric.Int64ObservableGauge {
+) (metric.Int64ObservableGauge, metric.Int64ObservableGauge) {
 	gauge, err := meter.Int64ObservableGauge(
-		requestsInQueueMetricName,
-		metric.WithDescription("Current number of requests in queue"),
-		metric.WithInt64Callback(plugin.observeRequestsInQueue),
+		queueRequestsInQueueInstrument.name,
+		queueRequestsInQueueInstrument.withDescription(),
+		queueRequestsInQueueInstrument.withUnit(),
 	)
-	if err != nil {
+	if err != nil || gauge == nil {
 		log.Error().Err(err).Msg("Failed to create requests in queue metric")
+		gauge = nil
 	}
-	return gauge
+	totalGauge, err := meter.Int64ObservableGauge(
+		queueRequestsInQueueTotalInstrument.name,
+		queueRequestsInQueueTotalInstrument.withDescription(),
+		queueRequestsInQueueTotalInstrument.withUnit(),
+	)
+	if err != nil || totalGauge == nil {
+		log.Error().Err(err).Msg("Failed to create requests in queue total metric")
+		totalGauge = nil
+	}
+
+	instruments := []metric.Observable{}
+	if gauge != nil {
+		instruments = append(instruments, gauge)
+	}
+	if totalGauge != nil {
+		instruments = append(instruments, totalGauge)
+	}
+	if len(instruments) == 0 {
+		return nil, nil
+	}
+	observe := func(ctx context.Context, observer metric.Observer) error {
+		return plugin.observeRequestsInQueue(ctx, observer, gauge, totalGauge)
+	}
+	if _, err := meter.RegisterCallback(observe, instruments...); err != nil {
+		log.Error().Err(err).Msg("Failed to register requests in queue metric callback")
+		return nil, nil
+	}
+	return gauge, totalGauge
 }
 
 func (plugin *StrategyBasedQueuePlugin) initializeRequestsMetric(
 	meter metric.Meter,
+) *sampledCounter {
+	counter, _ := meter.Int64Counter(
+		queueRequestsInstrument.name,
+		queueRequestsInstrument.withDescription(),
+		queueRequestsInstrument.withUnit(),
+	)
+	return newSampledCounter(counter)
+}
+
+func (plugin *StrategyBasedQueuePlugin) initializeEvictedRequestsMetric(
+	meter metric.Meter,
 ) metric.Int64Counter {
-	counter, _ := meter.Int64Counter(requestsMetricName)
+	counter, err := meter.Int64Counter(
+		queueEvictedRequestsInstrument.name,
+		queueEvictedRequestsInstrument.withDescription(),
+		queueEvictedRequestsInstrument.withUnit(),
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create evicted requests metric")
+	}
+	return counter
+}
+
+func (plugin *StrategyBasedQueuePlugin) initializePrioritizedRequestsMetric(
+	meter metric.Meter,
+) metric.Int64Counter {
+	counter, err := meter.Int64Counter(
+		queuePrioritizedRequestsInstrument.name,
+		queuePrioritizedRequestsInstrument.withDescription(),
+		queuePrioritizedRequestsInstrument.withUnit(),
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create prioritized requests metric")
+	}
 	return counter
 }
 
+func (plugin *StrategyBasedQueuePlugin) initializeOldestRequestAgeMetric(
+	meter metric.Meter,
+) metric.Float64ObservableGauge {
+	gauge, err := meter.Float64ObservableGauge(
+		queueOldestRequestAgeInstrument.name,
+		queueOldestRequestAgeInstrument.withDescription(),
+		queueOldestRequestAgeInstrument.withUnit(),
+		metric.WithFloat64Callback(plugin.observeOldestRequestAge),
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create oldest request age metric")
+	}
+	return gauge
+}
+
+func (plugin *StrategyBasedQueuePlugin) initializeStrategyInfoMetric(
+	meter metric.Meter,
+) metric.Int64ObservableGauge {
+	gauge, err := meter.Int64ObservableGauge(
+		queueStrategyInfoInstrument.name,
+		queueStrategyInfoInstrument.withDescription(),
+		queueStrategyInfoInstrument.withUnit(),
+	)
+	if err != nil || gauge == nil {
+		log.Error().Err(err).Msg("Failed to create strategy info metric")
+		return nil
+	}
+	observe := func(ctx context.Context, observer metric.Observer) error {
+		return plugin.observeStrategyInfo(ctx, observer, gauge)
+	}
+	if _, err := meter.RegisterCallback(observe, gauge); err != nil {
+		log.Error().Err(err).Msg("Failed to register strategy info metric callback")
+		return nil
+	}
+	return gauge
+}
+
+// observeRequestsInQueue reports the requests in queue per priority and
+// tenant on the given gauge, and their sum per remedy on the total gauge
+// (either gauge may be nil if it failed to be created)
 func (plugin *StrategyBasedQueuePlugin) observeRequestsInQueue(
 	_ context.Context,
-	observer metric.Int64Observer,
+	observer metric.Observer,
+	gauge metric.Int64ObservableGauge,
+	totalGauge metric.Int64ObservableGauge,
+) error {
+	if observer == nil || (gauge == nil && totalGauge == nil) {
+		return nil
+	}
+	plugin.inQueueMutex.Lock()
+	defer plugin.inQueueMutex.Unlock()
+
+	totals := map[string]int64{}
+	for key, count := range plugin.inQueueCounts {
+		totals[key.remedyName] += count
+		if gauge == nil {
+			continue
+		}
+		observer.ObserveInt64(
+			gauge,
+			count,
+			metric.WithAttributes(
+				attribute.String(remedyAttribute, key.remedyName),
+				attribute.Float64(priorityAttribute, key.priority),
+				attribute.String(tenant.AttributeName, key.tenantID),
+			),
+		)
+	}
+	if totalGauge == nil {
+		return nil
+	}
+	for remedyName, total := range totals {
+		observer.ObserveInt64(
+			totalGauge,
+			total,
+			metric.WithAttributes(attribute.String(remedyAttribute, remedyName)),
+		)
+	}
+	return nil
+}
+
+// observeOldestRequestAge reports the age of the oldest request per queue,
+// which nears the TTL before requests start to be rejected. Empty queues
+// report zero.
+func (plugin *StrategyBasedQueuePlugin) observeOldestRequestAge(
+	_ context.Context,
+	observer metric.Float64Observer,
 ) error {
+	now := plugin.clock.Now()
 	plugin.queuesMutex.RLock()
 	defer plugin.queuesMutex.RUnlock()
 
-	for queueKey, q := range plugin.queues {
-		for priority, count := range q.Counts() {
-			observer.Observe(
-				int64(count),
-				metric.WithAttributes(
-					attribute.String(remedyAttribute, queueKey.RemedyName),
-					attribute.Float64(priorityAttribute, priority),
-				),
-			)
+	for queueKey, relevantQueue := range plugin.queues {
+		age := 0.0
+		if oldest := relevantQueue.Snapshot().OldestEnqueuedAt; !oldest.IsZero() {
+			age = now.Sub(oldest).Seconds()
 		}
+		observer.Observe(
+			age,
+			metric.WithAttributes(
+				attribute.String(remedyAttribute, queueKey.Name()),
+				attribute.Int64(windowQuotaAttribute, queueKey.Strategy.WindowQuota),
+				attribute.String(windowSizeAttribute, queueKey.Strategy.WindowSize.String()),
+			),
+		)
+	}
+	return nil
+}
+
+// observeStrategyInfo reports the effective strategy of each remedy's queue,
+// as of the latest request it queued. The strategies of prior queues are not
+// observed, so their series are gone once a reload changed the strategy,
+// nor are the ones of remedies a reload removed.
+func (plugin *StrategyBasedQueuePlugin) observeStrategyInfo(
+	_ context.Context,
+	observer metric.Observer,
+	gauge metric.Int64ObservableGauge,
+) error {
+	plugin.queuesMutex.RLock()
+	defer plugin.queuesMutex.RUnlock()
+
+	for name, queueKey := range plugin.activeQueueKeys {
+		observer.ObserveInt64(
+			gauge,
+			1,
+			metric.WithAttributes(
+				attribute.String(remedyAttribute, name),
+				attribute.Int64(windowQuotaAttribute, queueKey.Strategy.WindowQuota),
+				attribute.Int64(windowSizeSecondsAttribute,
+					int64(queueKey.Strategy.WindowSize.Seconds())),
+			),
+		)
 	}
 	return nil
 }
 
+// updateInQueueCount tracks requests while they are enqueued, so the
+// requests in queue metric can be broken down per tenant
+func (plugin *StrategyBasedQueuePlugin) updateInQueueCount(
+	key inQueueKey,
+	delta int64,
+) {
+	plugin.inQueueMutex.Lock()
+	defer plugin.inQueueMutex.Unlock()
+
+	plugin.inQueueCounts[key] += delta
+}
+
 func (plugin *StrategyBasedQueuePlugin) incrementRequestsMetric(
-	remedyName string,
+	onRequest messages.OnRequest,
+	scopedRemedy config.ScopedRemedy,
 	priority float64,
-	ttlPassed bool,
+	reason string,
+	tenantAttribute attribute.KeyValue,
+) {
+	attributes := append([]attribute.KeyValue{
+		attribute.String(reasonAttribute, reason),
+		attribute.String(remedyAttribute, scopedRemedy.Remedy.Name),
+		attribute.Float64(priorityAttribute, priority),
+		tenantAttribute,
+	}, plugin.hosts.Attributes(targetOf(scopedRemedy, onRequest))...)
+	plugin.metrics.requests.add(
+		tracedContext(plugin.ctx, onRequest),
+		scopedRemedy.Remedy.RequestsMetricSampling,
+		attributes...,
+	)
+}
+
+func (plugin *StrategyBasedQueuePlugin) incrementPrioritizedRequestsMetric(
+	remedy *sharedConfig.Remedy,
+	match string,
 ) {
-	plugin.metrics.requests.Add(
+	if plugin.metrics.prioritizedRequests == nil {
+		return
+	}
+	plugin.metrics.prioritizedRequests.Add(
 		plugin.ctx,
 		1,
 		metric.WithAttributes(
-			attribute.Bool(ttlPassedAttribute, ttlPassed),
-			attribute.String(remedyAttribute, remedyName),
-			attribute.Float64(priorityAttribute, priority),
+			attribute.String(remedyAttribute, remedy.Name),
+			attribute.String(priorityMatchAttribute, match),
 		),
 	)
 }
+
+// transitionQuota moves the remedy's queue of the same window size over to
+// the given key, if the key's quota is lower (a reload reduced the quota).
+// The queue applies the reduced quota from its next window, so the requests
+// it already admitted are not followed by a burst under the new quota,
+// nor are its waiting requests rejected. Increased quotas get a new queue,
+// which may be warm started. Must be called while holding the queues mutex.
+func (plugin *StrategyBasedQueuePlugin) transitionQuota(
+	queueKey queue.QueueKey,
+) (queue.DelayedPriorityQueueable, bool) {
+	for priorQueueKey, priorQueue := range plugin.queues {
+		if !priorQueueKey.SameOwner(queueKey) ||
+			priorQueueKey.Strategy.WindowSize != queueKey.Strategy.WindowSize ||
+			priorQueueKey.Strategy.WindowQuota <= queueKey.Strategy.WindowQuota {
+			continue
+		}
+		plugin.cl.Logger.Info().
+			Msgf("Transitioning queue of %s from a quota of %d to %d",
+				queueKey.Name(), priorQueueKey.Strategy.WindowQuota,
+				queueKey.Strategy.WindowQuota)
+		priorQueue.UpdateQuota(queueKey.Strategy.WindowQuota)
+		delete(plugin.queues, priorQueueKey)
+		plugin.queues[queueKey] = priorQueue
+
+		plugin.proceededTransactionsMutex.Lock()
+		for transactionID, proceededQueueKey := range plugin.proceededTransactions {
+			if proceededQueueKey == priorQueueKey {
+				plugin.proceededTransactions[transactionID] = queueKey
+			}
+		}
+		plugin.proceededTransactionsMutex.Unlock()
+		return priorQueue, true
+	}
+	return nil, false
+}
+
+// warmStart carries the window usage of the remedy's prior queues over to
+// its new queue. Must be called while holding the queues mutex.
+func (plugin *StrategyBasedQueuePlugin) warmStart(
+	queueKey queue.QueueKey,
+	newQueue queue.DelayedPriorityQueueable,
+) {
+	var prior queue.WindowUsage
+	found := false
+	for priorQueueKey, priorQueue := range plugin.queues {
+		if !priorQueueKey.SameOwner(queueKey) {
+			continue
+		}
+		windowUsage := priorQueue.WindowUsage()
+		prior.Used += windowUsage.Used
+		if windowUsage.Quota > prior.Quota {
+			prior.Quota = windowUsage.Quota
+		}
+		if windowUsage.EndTime.After(prior.EndTime) {
+			prior.EndTime = windowUsage.EndTime
+		}
+		found = true
+	}
+	if !found {
+		return
+	}
+	plugin.cl.Logger.Trace().
+		Msgf("Warm starting queue for %s with prior window usage %+v",
+			queueKey.Name(), prior)
+	newQueue.WarmStart(prior)
+}
+
+var _ WindowUsageReporter = &StrategyBasedQueuePlugin{} //nolint:exhaustruct
+
+// WindowUsages reports the usage of the current window of each remedy's queue.
+// If a remedy has several queues (e.g. after its strategy was changed),
+// their usage is summed up and the highest quota is reported.
+func (plugin *StrategyBasedQueuePlugin) WindowUsages() []RemedyWindowUsage {
+	usages := map[string]*RemedyWindowUsage{}
+
+	plugin.queuesMutex.RLock()
+	for queueKey, relevantQueue := range plugin.queues {
+		windowUsage := relevantQueue.WindowUsage()
+		usage := windowUsageOf(usages, queueKey.Name())
+		usage.Used += windowUsage.Used
+		if windowUsage.Quota > usage.Quota {
+			usage.Quota = windowUsage.Quota
+		}
+	}
+	plugin.queuesMutex.RUnlock()
+
+	plugin.rejectedMutex.Lock()
+	for remedyName, rejected := range plugin.rejectedCounts {
+		windowUsageOf(usages, remedyName).Rejected = rejected
+	}
+	plugin.rejectedMutex.Unlock()
+
+	return sortedWindowUsages(usages)
+}
+
+// PendingQueue is a read-only view on the requests waiting in a queue
+type PendingQueue struct {
+	RemedyName        string                 `json:"remedy_name,omitempty"`
+	SharedLimitGroup  string                 `json:"shared_limit_group,omitempty"`
+	WindowQuota       int64                  `json:"window_quota"`
+	WindowSizeSeconds float64                `json:"window_size_seconds"`
+	Requests          []PendingQueuedRequest `json:"requests"`
+}
+
+type PendingQueuedRequest struct {
+	ID                  string    `json:"id"`
+	Priority            float64   `json:"priority"`
+	EnqueuedAt          time.Time `json:"enqueued_at"`
+	RemainingTTLSeconds float64   `json:"remaining_ttl_seconds"`
+}
+
+// PendingQueues lists the requests waiting in each queue, in the order they
+// would be processed in. Listing them does not affect their admission;
+// in particular, they are still decided on as they are drained.
+func (plugin *StrategyBasedQueuePlugin) PendingQueues() []PendingQueue {
+	now := plugin.clock.Now()
+	pendingQueues := []PendingQueue{}
+	plugin.queuesMutex.RLock()
+	for queueKey, relevantQueue := range plugin.queues {
+		pending := relevantQueue.Pending()
+		if len(pending) == 0 {
+			continue
+		}
+		requests := make([]PendingQueuedRequest, 0, len(pending))
+		for _, request := range pending {
+			remainingTTL := request.ExpiresAt.Sub(now)
+			if remainingTTL < 0 {
+				remainingTTL = 0
+			}
+			requests = append(requests, PendingQueuedRequest{
+				ID:                  request.ID,
+				Priority:            request.Priority,
+				EnqueuedAt:          request.EnqueuedAt,
+				RemainingTTLSeconds: remainingTTL.Seconds(),
+			})
+		}
+		pendingQueues = append(pendingQueues, PendingQueue{
+			RemedyName:        queueKey.RemedyName,
+			SharedLimitGroup:  queueKey.SharedLimitGroup,
+			WindowQuota:       queueKey.Strategy.WindowQuota,
+			WindowSizeSeconds: queueKey.Strategy.WindowSize.Seconds(),
+			Requests:          requests,
+		})
+	}
+	plugin.queuesMutex.RUnlock()
+
+	sort.Slice(pendingQueues, func(i, j int) bool {
+		if pendingQueues[i].RemedyName != pendingQueues[j].RemedyName {
+			return pendingQueues[i].RemedyName < pendingQueues[j].RemedyName
+		}
+		if pendingQueues[i].SharedLimitGroup != pendingQueues[j].SharedLimitGroup {
+			return pendingQueues[i].SharedLimitGroup < pendingQueues[j].SharedLimitGroup
+		}
+		if pendingQueues[i].WindowSizeSeconds != pendingQueues[j].WindowSizeSeconds {
+			return pendingQueues[i].WindowSizeSeconds < pendingQueues[j].WindowSizeSeconds
+		}
+		return pendingQueues[i].WindowQuota < pendingQueues[j].WindowQuota
+	})
+	return pendingQueues
+}
@@ -0,0 +1,1700 @@
+package remedies_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"lunar/engine/actions"
+	"lunar/engine/config"
+	"lunar/engine/messages"
+	"lunar/engine/services/remedies"
+	"lunar/engine/utils"
+	"lunar/engine/utils/events"
+	"lunar/engine/utils/queue"
+	"lunar/engine/utils/tenant"
+	"lunar/engine/utils/upstreamhost"
+	sharedConfig "lunar/shared-model/config"
+	"lunar/toolkit-core/clock"
+	"lunar/toolkit-core/logging"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const queueProxyTimeout = 2 * time.Minute
+
+func TestStrategyBasedQueueOnResponseSetsRateLimitHeaders(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	plugin := newStrategyBasedQueuePlugin(clock)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(3, 10, nil)
+
+	action, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	assert.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+
+	clock.AdvanceTime(3 * time.Second)
+	respAction, err := plugin.OnResponse(
+		basicResponseArgs(200, "", map[string]string{}),
+		scopedRemedy,
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, &actions.ModifyResponseAction{
+		HeadersToSet: map[string]string{
+			"X-RateLimit-Limit":     "3",
+			"X-RateLimit-Remaining": "2",
+			"X-RateLimit-Reset":     "7",
+		},
+	}, respAction)
+}
+
+func TestStrategyBasedQueueOnResponseUsesConfiguredHeaderNames(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	plugin := newStrategyBasedQueuePlugin(clock)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(
+		3,
+		10,
+		&sharedConfig.RateLimitHeaders{
+			Limit:     "RateLimit-Limit",
+			Remaining: "RateLimit-Remaining",
+		},
+	)
+
+	_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	assert.Nil(t, err)
+
+	respAction, err := plugin.OnResponse(
+		basicResponseArgs(200, "", map[string]string{}),
+		scopedRemedy,
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, &actions.ModifyResponseAction{
+		HeadersToSet: map[string]string{
+			"RateLimit-Limit":     "3",
+			"RateLimit-Remaining": "2",
+			"X-RateLimit-Reset":   "10",
+		},
+	}, respAction)
+}
+
+func TestStrategyBasedQueueOnResponseWithoutRequestReturnsNoOpAction(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	plugin := newStrategyBasedQueuePlugin(clock)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(3, 10, nil)
+
+	respAction, err := plugin.OnResponse(
+		basicResponseArgs(200, "", map[string]string{}),
+		scopedRemedy,
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, respAction)
+}
+
+func TestStrategyBasedQueueForgetsTransactionsAfterProxyTimeout(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	proxyTimeout := 2 * time.Second
+	plugin := newStrategyBasedQueuePluginWithTimeout(
+		clock, proxyTimeout, noop.NewMeterProvider().Meter("test"), nil)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(3, 10, nil)
+
+	_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	assert.Nil(t, err)
+
+	// The response never arrived in time, so the transaction is vacuumed.
+	// Time advances well past the timeout, so the vacuum runs even if some
+	// of its ticks are scheduled late.
+	for elapsed := time.Duration(0); elapsed <= 3*proxyTimeout; elapsed += time.Second {
+		clock.AdvanceTime(time.Second)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	respAction, err := plugin.OnResponse(
+		basicResponseArgs(200, "", map[string]string{}),
+		scopedRemedy,
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, respAction)
+}
+
+func TestStrategyBasedQueueReportsWindowUsage(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	plugin := newStrategyBasedQueuePlugin(clock)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(3, 10, nil)
+	assert.Empty(t, plugin.WindowUsages())
+
+	for i := 0; i < 2; i++ {
+		_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, []remedies.RemedyWindowUsage{
+		{RemedyName: "test", Quota: 3, Used: 2, Rejected: 0},
+	}, plugin.WindowUsages())
+}
+
+func TestStrategyBasedQueueMetricsCarryTenant(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	plugin := newStrategyBasedQueuePluginWithMeter(
+		clock, meter, tenant.NewResolver("X-Tenant-ID", 10))
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(1, 10, nil)
+
+	acmeRequest := onRequestArgs()
+	acmeRequest.Headers = map[string]string{"X-Tenant-ID": "acme"}
+	_, err := plugin.OnRequest(acmeRequest, scopedRemedy)
+	require.Nil(t, err)
+
+	// The window quota is used up, so the next request waits in the queue
+	anonymousRequest := onRequestArgs()
+	anonymousRequest.ID = "anonymous"
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := plugin.OnRequest(anonymousRequest, scopedRemedy)
+		assert.Nil(t, err)
+	}()
+
+	requestsInQueueMetric := "lunar_remedies.strategy_based_queue.requests_in_queue"
+	assert.Eventually(t, func() bool {
+		return collectPerTenant(t, reader, requestsInQueueMetric)[tenant.DefaultTenant] == 1
+	}, time.Second, 10*time.Millisecond)
+
+	clock.AdvanceTime(10 * time.Second)
+	<-done
+
+	assert.Equal(t, map[string]int64{"acme": 0, tenant.DefaultTenant: 0},
+		collectPerTenant(t, reader, requestsInQueueMetric))
+	assert.Equal(t, map[string]int64{"acme": 1, tenant.DefaultTenant: 1},
+		collectPerTenant(t, reader, "lunar_remedies.strategy_based_queue.requests"))
+}
+
+func TestStrategyBasedQueueMetricsCarryTheNormalizedUpstreamHost(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	plugin := newStrategyBasedQueuePluginWithMeter(clock, meter, nil)
+	plugin.SetHostLabels(upstreamhost.NewLabels(10))
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(10, 10, nil)
+	scopedRemedy.NormalizedURL = "Test.com:443/some/{param}"
+
+	_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+	// Remedies of global scope have no target, so are of the request's host
+	scopedRemedy.NormalizedURL = ""
+	request := onRequestArgs()
+	request.URL = "other.com/some/path"
+	_, err = plugin.OnRequest(request, scopedRemedy)
+	require.Nil(t, err)
+
+	assert.Equal(t, map[string]int64{"test.com": 1, "other.com": 1},
+		collectPerAttribute(t, reader, "lunar_remedies.strategy_based_queue.requests",
+			upstreamhost.AttributeName))
+}
+
+// gaugeFailingMeter fails to create observable int64 gauges, counting the
+// callbacks registered on it
+type gaugeFailingMeter struct {
+	metric.Meter
+	registeredCallbacks int
+}
+
+func (meter *gaugeFailingMeter) Int64ObservableGauge(
+	string,
+	...metric.Int64ObservableGaugeOption,
+) (metric.Int64ObservableGauge, error) {
+	return nil, errors.New("gauge creation failed")
+}
+
+func (meter *gaugeFailingMeter) RegisterCallback(
+	callback metric.Callback,
+	instruments ...metric.Observable,
+) (metric.Registration, error) {
+	meter.registeredCallbacks++
+	return meter.Meter.RegisterCallback(callback, instruments...)
+}
+
+func TestStrategyBasedQueueHandlesRequestsWhenTheRequestsInQueueGaugeFails(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	reader := sdkMetric.NewManualReader()
+	meter := &gaugeFailingMeter{
+		Meter: sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test"),
+	}
+	plugin := newStrategyBasedQueuePluginWithMeter(clock, meter, nil)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(1, 10, nil)
+
+	_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+
+	queuedRequest := onRequestArgs()
+	queuedRequest.ID = "queued"
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := plugin.OnRequest(queuedRequest, scopedRemedy)
+		assert.Nil(t, err)
+	}()
+	assert.Eventually(t, func() bool {
+		return len(plugin.PendingQueues()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	clock.AdvanceTime(10 * time.Second)
+	<-done
+
+	assert.Zero(t, meter.registeredCallbacks)
+	assert.Empty(t, collectPerTenant(t, reader,
+		"lunar_remedies.strategy_based_queue.requests_in_queue"))
+	assert.Equal(t, map[string]int64{tenant.DefaultTenant: 2},
+		collectPerTenant(t, reader, "lunar_remedies.strategy_based_queue.requests"))
+}
+
+func TestStrategyBasedQueueReportsTheTotalRequestsInQueueAcrossPriorities(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	plugin := newStrategyBasedQueuePluginWithMeter(clock, meter, nil)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(1, 10, nil)
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.Prioritization = &sharedConfig.GroupPrioritization{
+		GroupBy: sharedConfig.GroupBy{HeaderName: "X-Group"},
+		Groups: map[string]sharedConfig.Prioritization{
+			"production": {Priority: 1},
+			"staging":    {Priority: 2},
+		},
+	}
+	request := func(group string) messages.OnRequest {
+		onRequest := onRequestArgs()
+		onRequest.Headers = map[string]string{"X-Group": group}
+		return onRequest
+	}
+	requestsInQueue := func() (map[string]int64, map[string]int64) {
+		return collectPerAttribute(t, reader,
+				"lunar_remedies.strategy_based_queue.requests_in_queue", "priority"),
+			collectPerAttribute(t, reader,
+				"lunar_remedies.strategy_based_queue.requests_in_queue_total", "remedy")
+	}
+
+	_, err := plugin.OnRequest(request("production"), scopedRemedy)
+	require.Nil(t, err)
+
+	// The window quota is used up, so the next requests wait in the queue
+	var waiting sync.WaitGroup
+	for _, group := range []string{"production", "staging", "staging"} {
+		waiting.Add(1)
+		go func(group string) {
+			defer waiting.Done()
+			_, err := plugin.OnRequest(request(group), scopedRemedy)
+			assert.Nil(t, err)
+		}(group)
+	}
+	require.Eventually(t, func() bool {
+		_, total := requestsInQueue()
+		return total["test"] == 3
+	}, time.Second, time.Millisecond)
+	perPriority, total := requestsInQueue()
+	assert.Equal(t, map[string]int64{"1": 1, "2": 2}, perPriority)
+	assert.Equal(t, map[string]int64{"test": 3}, total)
+
+	done := make(chan struct{})
+	go func() {
+		waiting.Wait()
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		clock.AdvanceTime(10 * time.Second)
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	perPriority, total = requestsInQueue()
+	assert.Equal(t, map[string]int64{"1": 0, "2": 0}, perPriority)
+	assert.Equal(t, map[string]int64{"test": 0}, total)
+}
+
+func TestStrategyBasedQueuePrioritizesByExpression(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	plugin := newStrategyBasedQueuePluginWithMeter(clock, meter, nil)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(10, 10, nil)
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.Prioritization = &sharedConfig.GroupPrioritization{
+		GroupBy: sharedConfig.GroupBy{HeaderName: "X-Group"},
+		Groups: map[string]sharedConfig.Prioritization{
+			"production": {Priority: 1},
+		},
+		Expression: `method == "POST" ? 3 : int(headers["X-Tier"]) * 2`,
+	}
+
+	postRequest := onRequestArgs()
+	postRequest.Method = "POST"
+	tierRequest := onRequestArgs()
+	tierRequest.Headers = map[string]string{"X-Tier": "2"}
+	// The expression fails to evaluate, so the groups are used instead
+	invalidTierRequest := onRequestArgs()
+	invalidTierRequest.Headers = map[string]string{
+		"X-Tier":  "gold",
+		"X-Group": "production",
+	}
+
+	for _, request := range []messages.OnRequest{
+		postRequest, tierRequest, invalidTierRequest,
+	} {
+		action, err := plugin.OnRequest(request, scopedRemedy)
+		require.Nil(t, err)
+		require.Equal(t, &actions.NoOpAction{}, action)
+	}
+
+	assert.Equal(t, map[string]int64{"3": 1, "4": 1, "1": 1},
+		collectPerAttribute(t, reader,
+			"lunar_remedies.strategy_based_queue.requests", "priority"))
+}
+
+func TestStrategyBasedQueuePrioritizesByDuplicateMixedCaseHeader(t *testing.T) {
+	t.Parallel()
+	rawHeaders := "x-TIER: free\nX-Tier: premium\n"
+	for multiValue, priority := range map[string]string{
+		"":      "2",
+		"first": "2",
+		"last":  "1",
+		"join":  "3",
+	} {
+		clock := clock.NewMockClock()
+		reader := sdkMetric.NewManualReader()
+		meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+		plugin := newStrategyBasedQueuePluginWithMeter(clock, meter, nil)
+		scopedRemedy := buildStrategyBasedQueueScopedRemedy(10, 10, nil)
+		scopedRemedy.Remedy.Config.StrategyBasedQueue.Prioritization = &sharedConfig.GroupPrioritization{
+			GroupBy: sharedConfig.GroupBy{HeaderName: "X-Tier", MultiValue: multiValue},
+			Groups: map[string]sharedConfig.Prioritization{
+				"premium":       {Priority: 1},
+				"free":          {Priority: 2},
+				"free, premium": {Priority: 3},
+			},
+		}
+		request := onRequestArgs()
+		request.Headers, request.HeaderValues = utils.ParseHeadersWithValues(&rawHeaders)
+
+		action, err := plugin.OnRequest(request, scopedRemedy)
+		require.Nil(t, err)
+		require.Equal(t, &actions.NoOpAction{}, action)
+		assert.Equal(t, map[string]int64{priority: 1},
+			collectPerAttribute(t, reader,
+				"lunar_remedies.strategy_based_queue.requests", "priority"),
+			"multi_value: %q", multiValue)
+	}
+}
+
+func TestStrategyBasedQueueExportsRequestRejectedOnFullQueue(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	exporter := &mockRejectedRequestsExporter{}
+	plugin := newStrategyBasedQueuePluginWithExporter(clock, queueProxyTimeout,
+		noop.NewMeterProvider().Meter("test"), nil, exporter)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(1, 10, nil)
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.QueueSize = 0
+
+	_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+	require.Empty(t, exporter.exported())
+
+	rejectedRequest := onRequestArgs()
+	rejectedRequest.ID = "rejected"
+	action, err := plugin.OnRequest(rejectedRequest, scopedRemedy)
+	require.Nil(t, err)
+	require.IsType(t, &actions.EarlyResponseAction{}, action)
+
+	assert.Equal(t, []remedies.RejectedRequest{{
+		RequestID:  "rejected",
+		RemedyName: "test",
+		Priority:   0,
+		TenantID:   tenant.DefaultTenant,
+		EnqueuedAt: time.Unix(1000, 0),
+		WaitTime:   0,
+		Reason:     "queue_full",
+		StatusCode: 429,
+		Method:     "GET",
+		Path:       "/some/path",
+		Headers:    map[string]string{},
+	}}, exporter.exported())
+}
+
+func TestStrategyBasedQueueReportsTheRejectionRatioOverARollingWindow(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	plugin := newStrategyBasedQueuePluginWithMeter(clock, meter, nil)
+	plugin.SetRejectionRatioWindow(20 * time.Second)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(1, 10, nil)
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.QueueSize = 0
+	rejectionRatio := func() map[string]float64 {
+		return collectFloatPerAttribute(t, reader,
+			"lunar_remedies.strategy_based_queue.rejection_ratio", "remedy")
+	}
+	require.Empty(t, rejectionRatio())
+
+	// The first request is admitted, while the rest find the queue full
+	for i := 0; i < 4; i++ {
+		_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+		require.Nil(t, err)
+	}
+	assert.Equal(t, map[string]float64{"test": 0.75}, rejectionRatio())
+
+	// Once the requests rolled out of the window, there is no traffic to
+	// reject out of, which is reported as 0 rather than NaN
+	for i := 0; i < 3; i++ {
+		clock.AdvanceTime(10 * time.Second)
+	}
+	assert.Equal(t, map[string]float64{"test": 0}, rejectionRatio())
+
+	_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]float64{"test": 0}, rejectionRatio())
+
+	// The remedy is still configured, so its ratio is kept
+	policies := sharedConfig.PoliciesConfig{} //nolint:exhaustruct
+	policies.Global.Remedies = []sharedConfig.Remedy{*scopedRemedy.Remedy}
+	plugin.ForgetRemovedRemedies(&policies)
+	assert.Equal(t, map[string]float64{"test": 0}, rejectionRatio())
+
+	// A reload removed the remedy, so its ratio is no longer reported
+	plugin.ForgetRemovedRemedies(&sharedConfig.PoliciesConfig{}) //nolint:exhaustruct
+	assert.Empty(t, rejectionRatio())
+}
+
+func TestStrategyBasedQueueLogsTheTraceIDOfRejectedRequests(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	var logs bytes.Buffer
+	plugin := remedies.NewStrategyBasedQueuePlugin(
+		context.Background(),
+		clock,
+		queueProxyTimeout,
+		logging.ContextLogger{Logger: zerolog.New(zerolog.SyncWriter(&logs))},
+		noop.NewMeterProvider().Meter("test"),
+		nil,
+		nil,
+		func(queueKey queue.QueueKey) queue.DelayedPriorityQueueable {
+			return queue.NewInMemoryDelayedPriorityQueue(
+				queueKey, clock, logging.ContextLogger{})
+		},
+	)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(1, 10, nil)
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.QueueSize = 0
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{ //nolint:exhaustruct
+		TraceID:    trace.TraceID{0x0a, 0xf7, 0x65, 0x19},
+		SpanID:     trace.SpanID{0x01},
+		TraceFlags: trace.FlagsSampled,
+	})
+	requestWithSpan := func(id string, spanContext trace.SpanContext) {
+		onRequest := onRequestArgs()
+		onRequest.ID = id
+		onRequest.SpanContext = spanContext
+		action, err := plugin.OnRequest(onRequest, scopedRemedy)
+		require.Nil(t, err)
+		require.IsType(t, &actions.EarlyResponseAction{}, action)
+	}
+
+	_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+	requestWithSpan("traced", spanContext)
+	assert.Contains(t, logs.String(),
+		`"trace-id":"`+spanContext.TraceID().String()+`"`)
+
+	// Without an active span, as tracing is off, the trace ID is left out
+	logs.Reset()
+	requestWithSpan("untraced", trace.SpanContext{})
+	assert.Contains(t, logs.String(), "untraced")
+	assert.NotContains(t, logs.String(), "trace-id")
+}
+
+func TestStrategyBasedQueueCountsRequestsPerPriorityAndReason(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	plugin := newStrategyBasedQueuePluginWithMeter(clock, meter, nil)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(1, 10, nil)
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.QueueSize = 0
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.Prioritization = &sharedConfig.GroupPrioritization{
+		GroupBy: sharedConfig.GroupBy{HeaderName: "X-Group"},
+		Groups: map[string]sharedConfig.Prioritization{
+			"production": {Priority: 1},
+			"staging":    {Priority: 2},
+		},
+	}
+	request := func(group string) messages.OnRequest {
+		onRequest := onRequestArgs()
+		onRequest.Headers = map[string]string{"X-Group": group}
+		return onRequest
+	}
+
+	for _, group := range []string{"production", "staging", "staging", "production"} {
+		_, err := plugin.OnRequest(request(group), scopedRemedy)
+		require.Nil(t, err)
+	}
+
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+	perPriorityAndReason := map[string]int64{}
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, recordedMetric := range scopeMetrics.Metrics {
+			if recordedMetric.Name != "lunar_remedies.strategy_based_queue.requests" {
+				continue
+			}
+			sum, ok := recordedMetric.Data.(metricdata.Sum[int64])
+			require.True(t, ok)
+			for _, point := range sum.DataPoints {
+				priority, _ := point.Attributes.Value("priority")
+				reason, _ := point.Attributes.Value("reason")
+				perPriorityAndReason[priority.Emit()+"/"+reason.AsString()] += point.Value
+			}
+		}
+	}
+	assert.Equal(t, map[string]int64{
+		"1/processed_in_window": 1,
+		"1/queue_full":          1,
+		"2/queue_full":          2,
+	}, perPriorityAndReason)
+}
+
+func TestStrategyBasedQueueCountsPrioritizedRequestsByMatch(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	plugin := newStrategyBasedQueuePluginWithMeter(clock, meter, nil)
+	prioritizedRemedy := buildStrategyBasedQueueScopedRemedy(10, 10, nil)
+	prioritizedRemedy.Remedy.Config.StrategyBasedQueue.Prioritization = &sharedConfig.GroupPrioritization{
+		GroupBy: sharedConfig.GroupBy{HeaderName: "X-Group"},
+		Groups: map[string]sharedConfig.Prioritization{
+			"production": {Priority: 0},
+			"staging":    {Priority: 2},
+		},
+	}
+	unprioritizedRemedy := buildStrategyBasedQueueScopedRemedy(10, 10, nil)
+	unprioritizedRemedy.Remedy.Name = "unprioritized"
+	request := func(group string) messages.OnRequest {
+		onRequest := onRequestArgs()
+		onRequest.Headers = map[string]string{"X-Group": group}
+		return onRequest
+	}
+
+	// A matched priority of 0 is told apart from the default priority
+	for _, group := range []string{"production", "staging", "unknown", "unknown"} {
+		_, err := plugin.OnRequest(request(group), prioritizedRemedy)
+		require.Nil(t, err)
+		_, err = plugin.OnRequest(request(group), unprioritizedRemedy)
+		require.Nil(t, err)
+	}
+
+	metricName := "lunar_remedies.strategy_based_queue.prioritized_requests"
+	assert.Equal(t, map[string]int64{"group": 2, "default": 2},
+		collectPerAttribute(t, reader, metricName, "match"))
+	assert.Equal(t, map[string]int64{prioritizedRemedy.Remedy.Name: 4},
+		collectPerAttribute(t, reader, metricName, "remedy"))
+}
+
+func TestStrategyBasedQueuePrioritizesUnmatchedRequestsByTheDefaultPriority(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	plugin := newStrategyBasedQueuePluginWithMeter(clock, meter, nil)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(10, 10, nil)
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.Prioritization = &sharedConfig.GroupPrioritization{
+		GroupBy: sharedConfig.GroupBy{HeaderName: "X-Group"},
+		Groups: map[string]sharedConfig.Prioritization{
+			"production": {Priority: 0},
+			"staging":    {Priority: 2},
+		},
+		DefaultPriority: 2,
+	}
+
+	withoutHeader := onRequestArgs()
+	withoutHeader.Headers = map[string]string{}
+	withUnknownGroup := onRequestArgs()
+	withUnknownGroup.Headers = map[string]string{"X-Group": "unknown"}
+	withProductionGroup := onRequestArgs()
+	withProductionGroup.Headers = map[string]string{"X-Group": "production"}
+	for _, onRequest := range []messages.OnRequest{
+		withoutHeader, withUnknownGroup, withProductionGroup,
+	} {
+		_, err := plugin.OnRequest(onRequest, scopedRemedy)
+		require.Nil(t, err)
+	}
+
+	assert.Equal(t, map[string]int64{"0": 1, "2": 2}, collectPerAttribute(t, reader,
+		"lunar_remedies.strategy_based_queue.requests", "priority"))
+}
+
+func TestStrategyBasedQueueExportsWaitTimeOfExpiredRequest(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	exporter := &mockRejectedRequestsExporter{}
+	plugin := newStrategyBasedQueuePluginWithExporter(
+		clock, queueProxyTimeout, meter, nil, exporter)
+	// The window is long enough for the TTL to expire before it ends
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(1, 60, nil)
+
+	_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+
+	// The window quota is used up, so the next request waits until its TTL
+	go func() {
+		_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+		assert.Nil(t, err)
+	}()
+	requestsInQueueMetric := "lunar_remedies.strategy_based_queue.requests_in_queue"
+	require.Eventually(t, func() bool {
+		return collectPerTenant(t, reader, requestsInQueueMetric)[tenant.DefaultTenant] == 1
+	}, time.Second, time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		clock.AdvanceTime(time.Second)
+		return len(exporter.exported()) == 1
+	}, time.Second, time.Millisecond)
+
+	rejected := exporter.exported()[0]
+	assert.Equal(t, "ttl_expired", rejected.Reason)
+	ttl := time.Duration(
+		scopedRemedy.Remedy.Config.StrategyBasedQueue.TTLSeconds) * time.Second
+	assert.GreaterOrEqual(t, rejected.WaitTime, ttl)
+}
+
+func TestStrategyBasedQueueRejectsRequestsOnceTheirClientDeadlinePassed(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	exporter := &mockRejectedRequestsExporter{}
+	plugin := newStrategyBasedQueuePluginWithExporter(
+		clock, queueProxyTimeout, meter, nil, exporter)
+	// The window is long enough for the TTL to expire before it ends
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(1, 60, nil)
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.TTLGraceMilliseconds = 1000
+
+	_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+
+	// The client waits for less than the TTL, so the request leaves the queue
+	// once its deadline passes, instead of once the TTL does
+	request := onRequestArgs()
+	request.Deadline = messages.RequestDeadline{
+		At:                 clock.Now().Add(2 * time.Second),
+		ExceededStatusCode: 504,
+	}
+	actionChan := make(chan actions.ReqLunarAction, 1)
+	go func() {
+		action, err := plugin.OnRequest(request, scopedRemedy)
+		assert.Nil(t, err)
+		actionChan <- action
+	}()
+	requestsInQueueMetric := "lunar_remedies.strategy_based_queue.requests_in_queue"
+	require.Eventually(t, func() bool {
+		return collectPerTenant(t, reader, requestsInQueueMetric)[tenant.DefaultTenant] == 1
+	}, time.Second, time.Millisecond)
+
+	var action actions.ReqLunarAction
+	require.Eventually(t, func() bool {
+		select {
+		case action = <-actionChan:
+			return true
+		default:
+			clock.AdvanceTime(time.Second)
+			return false
+		}
+	}, time.Second, time.Millisecond)
+
+	earlyResponse, ok := action.(*actions.EarlyResponseAction)
+	require.True(t, ok)
+	assert.Equal(t, 504, earlyResponse.Status)
+	rejected := exporter.exported()[0]
+	assert.Equal(t, "deadline_exceeded", rejected.Reason)
+	ttl := time.Duration(
+		scopedRemedy.Remedy.Config.StrategyBasedQueue.TTLSeconds) * time.Second
+	assert.Less(t, rejected.WaitTime, ttl)
+}
+
+func TestStrategyBasedQueueReportsOldestRequestAge(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	plugin := newStrategyBasedQueuePluginWithMeter(clock, meter, nil)
+	// The window is long enough for the TTL to expire before it ends
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(1, 60, nil)
+	oldestRequestAge := func() map[string]float64 {
+		return collectFloatPerAttribute(t, reader,
+			"lunar_remedies.strategy_based_queue.oldest_request_age", "remedy")
+	}
+
+	_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+	// The queue is empty, as the request was processed in the window
+	assert.Equal(t, map[string]float64{"test": 0}, oldestRequestAge())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+		assert.Nil(t, err)
+	}()
+	requestsInQueueMetric := "lunar_remedies.strategy_based_queue.requests_in_queue"
+	require.Eventually(t, func() bool {
+		return collectPerTenant(t, reader, requestsInQueueMetric)[tenant.DefaultTenant] == 1
+	}, time.Second, time.Millisecond)
+
+	clock.AdvanceTime(3 * time.Second)
+	assert.Equal(t, map[string]float64{"test": 3}, oldestRequestAge())
+
+	require.Eventually(t, func() bool {
+		clock.AdvanceTime(time.Second)
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	// The request's TTL expired, so the queue is empty again
+	assert.Equal(t, map[string]float64{"test": 0}, oldestRequestAge())
+}
+
+func TestStrategyBasedQueueReportsTheEffectiveStrategyOfEachRemedy(t *testing.T) {
+	t.Parallel()
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	plugin := newStrategyBasedQueuePluginWithMeter(clock.NewMockClock(), meter, nil)
+	strategyInfo := func(attributeName attribute.Key) map[string]int64 {
+		return collectPerAttribute(t, reader,
+			"lunar_remedies.strategy_based_queue.strategy_info", attributeName)
+	}
+
+	_, err := plugin.OnRequest(onRequestArgs(), buildStrategyBasedQueueScopedRemedy(3, 10, nil))
+	require.Nil(t, err)
+	assert.Equal(t, map[string]int64{"test": 1}, strategyInfo("remedy"))
+	assert.Equal(t, map[string]int64{"3": 1}, strategyInfo("window_quota"))
+	assert.Equal(t, map[string]int64{"10": 1}, strategyInfo("window_size_seconds"))
+
+	// A reload changed the strategy, so the series of the prior one is gone
+	_, err = plugin.OnRequest(onRequestArgs(), buildStrategyBasedQueueScopedRemedy(5, 20, nil))
+	require.Nil(t, err)
+	assert.Equal(t, map[string]int64{"test": 1}, strategyInfo("remedy"))
+	assert.Equal(t, map[string]int64{"5": 1}, strategyInfo("window_quota"))
+	assert.Equal(t, map[string]int64{"20": 1}, strategyInfo("window_size_seconds"))
+
+	// The remedy is still configured, so its series is kept
+	policies := sharedConfig.PoliciesConfig{} //nolint:exhaustruct
+	policies.Global.Remedies = []sharedConfig.Remedy{
+		*buildStrategyBasedQueueScopedRemedy(5, 20, nil).Remedy,
+	}
+	plugin.ForgetRemovedRemedies(&policies)
+	assert.Equal(t, map[string]int64{"test": 1}, strategyInfo("remedy"))
+
+	// A reload removed the remedy, so its series is gone
+	plugin.ForgetRemovedRemedies(&sharedConfig.PoliciesConfig{}) //nolint:exhaustruct
+	assert.Empty(t, strategyInfo("remedy"))
+}
+
+func TestStrategyBasedQueueAdmitsRequestsAtTheirTTLOnlyWithAGrace(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range []struct {
+		ttlGraceMilliseconds int
+		admitted             bool
+	}{
+		{ttlGraceMilliseconds: 0, admitted: false},
+		{ttlGraceMilliseconds: 500, admitted: true},
+	} {
+		clock := clock.NewMockClock()
+		clock.Set(time.Unix(1000, 0))
+		plugin := newStrategyBasedQueuePlugin(clock)
+		// The TTL of a queued request passes right as the next window starts
+		scopedRemedy := buildStrategyBasedQueueScopedRemedy(1, 10, nil)
+		scopedRemedy.Remedy.Config.StrategyBasedQueue.TTLSeconds = 10
+		scopedRemedy.Remedy.Config.StrategyBasedQueue.TTLGraceMilliseconds =
+			testCase.ttlGraceMilliseconds
+
+		_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+		require.Nil(t, err)
+
+		queuedRequest := onRequestArgs()
+		queuedRequest.ID = "queued"
+		var action actions.ReqLunarAction
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			action, err = plugin.OnRequest(queuedRequest, scopedRemedy)
+		}()
+		require.Eventually(t, func() bool {
+			return len(plugin.PendingQueues()) == 1
+		}, time.Second, 10*time.Millisecond)
+
+		clock.AdvanceTime(10 * time.Second)
+		<-done
+		require.Nil(t, err)
+		_, admitted := action.(*actions.NoOpAction)
+		assert.Equal(t, testCase.admitted, admitted, testCase.ttlGraceMilliseconds)
+	}
+}
+
+func TestStrategyBasedQueueExtendsTheTTLOfAHighPriorityRequestAboutToBeAdmitted(
+	t *testing.T,
+) {
+	t.Parallel()
+	for _, testCase := range []struct {
+		ttlExtension *sharedConfig.TTLExtension
+		admitted     bool
+	}{
+		{ttlExtension: nil, admitted: false},
+		{
+			ttlExtension: &sharedConfig.TTLExtension{
+				MaxMilliseconds:    3000,
+				EligiblePriorities: []float64{1},
+			},
+			admitted: false,
+		},
+		{
+			ttlExtension: &sharedConfig.TTLExtension{
+				MaxMilliseconds:    3000,
+				EligiblePriorities: []float64{0},
+			},
+			admitted: true,
+		},
+	} {
+		clock := clock.NewMockClock()
+		clock.Set(time.Unix(1000, 0))
+		plugin := newStrategyBasedQueuePlugin(clock)
+		// The TTL of a queued request passes 2 seconds before the next window starts
+		scopedRemedy := buildStrategyBasedQueueScopedRemedy(1, 10, nil)
+		scopedRemedy.Remedy.Config.StrategyBasedQueue.TTLSeconds = 8
+		scopedRemedy.Remedy.Config.StrategyBasedQueue.TTLExtension = testCase.ttlExtension
+
+		_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+		require.Nil(t, err)
+
+		queuedRequest := onRequestArgs()
+		queuedRequest.ID = "queued"
+		var action actions.ReqLunarAction
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			action, err = plugin.OnRequest(queuedRequest, scopedRemedy)
+		}()
+		require.Eventually(t, func() bool {
+			return len(plugin.PendingQueues()) == 1
+		}, time.Second, 10*time.Millisecond)
+
+		// The request is at the head of the line as its TTL passes,
+		// so if eligible, it is extended once to be admitted in the next window
+		clock.AdvanceTime(8 * time.Second)
+		clock.AdvanceTime(2 * time.Second)
+		<-done
+		require.Nil(t, err)
+		_, admitted := action.(*actions.NoOpAction)
+		assert.Equal(t, testCase.admitted, admitted, testCase.ttlExtension)
+	}
+}
+
+func TestStrategyBasedQueueDoesNotExtendATTLPassingAsTheWindowEnds(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	plugin := newStrategyBasedQueuePlugin(clock)
+	// The TTL of a queued request passes right as the next window starts,
+	// while its extension would outlast that window
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(1, 10, nil)
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.TTLSeconds = 10
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.TTLExtension = &sharedConfig.TTLExtension{
+		MaxMilliseconds:    15000,
+		EligiblePriorities: []float64{0},
+	}
+
+	_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+
+	queuedRequest := onRequestArgs()
+	queuedRequest.ID = "queued"
+	var action actions.ReqLunarAction
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		action, err = plugin.OnRequest(queuedRequest, scopedRemedy)
+	}()
+	require.Eventually(t, func() bool {
+		return len(plugin.PendingQueues()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Whether the queue or the request notices first, it expired
+	clock.AdvanceTime(10 * time.Second)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the TTL of the expired request was extended")
+	}
+	require.Nil(t, err)
+	assert.IsType(t, &actions.EarlyResponseAction{}, action)
+}
+
+func TestStrategyBasedQueueAdmitsRequestsWithoutQueueingWithinTheQuota(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	plugin := newStrategyBasedQueuePluginWithMeter(clock, meter, nil)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(4, 10, nil)
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.TTLSeconds = 20
+	requestsPerReason := func() map[string]int64 {
+		return collectPerAttribute(t, reader,
+			"lunar_remedies.strategy_based_queue.requests", "reason")
+	}
+
+	// Within the quota, requests take the fast path, not being queued
+	for i := 0; i < 4; i++ {
+		action, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+		require.Nil(t, err)
+		require.IsType(t, &actions.NoOpAction{}, action)
+	}
+	assert.Equal(t, map[string]int64{
+		"processed_in_window": 4,
+	}, requestsPerReason())
+	assert.Empty(t, plugin.PendingQueues())
+
+	// Beyond the quota, requests are queued as usual
+	queuedRequest := onRequestArgs()
+	queuedRequest.ID = "queued"
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := plugin.OnRequest(queuedRequest, scopedRemedy)
+		assert.Nil(t, err)
+	}()
+	require.Eventually(t, func() bool {
+		return len(plugin.PendingQueues()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	clock.AdvanceTime(10 * time.Second)
+	<-done
+	assert.Equal(t, map[string]int64{
+		"processed_in_window":  4,
+		"processed_from_queue": 1,
+	}, requestsPerReason())
+}
+
+func TestStrategyBasedQueueWarmStartPreservesRemainingBudget(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	plugin := newStrategyBasedQueuePlugin(clock)
+
+	allowed := recreateQueueWithinWindow(t, clock, plugin, true)
+
+	// One request was left of the prior window's budget
+	assert.Equal(t, 1, allowed)
+}
+
+func TestStrategyBasedQueueWithoutWarmStartResetsBudget(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	plugin := newStrategyBasedQueuePlugin(clock)
+
+	allowed := recreateQueueWithinWindow(t, clock, plugin, false)
+
+	assert.Equal(t, 5, allowed)
+}
+
+func TestStrategyBasedQueueRemediesOfASharedLimitGroupShareOneQuota(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range []struct {
+		name             string
+		sharedLimitGroup string
+		wantAllowed      int
+	}{
+		{name: "shared limit group", sharedLimitGroup: "upstream", wantAllowed: 3},
+		{name: "no group", sharedLimitGroup: "", wantAllowed: 4},
+	} {
+		clock := clock.NewMockClock()
+		clock.Set(time.Unix(1000, 0))
+		plugin := newStrategyBasedQueuePlugin(clock)
+
+		allowed := 0
+		for _, remedyName := range []string{"first", "second"} {
+			scopedRemedy := buildStrategyBasedQueueScopedRemedy(3, 10, nil)
+			scopedRemedy.Remedy.Name = remedyName
+			queueConfig := scopedRemedy.Remedy.Config.StrategyBasedQueue
+			queueConfig.QueueSize = 0
+			queueConfig.SharedLimitGroup = testCase.sharedLimitGroup
+			for i := 0; i < 2; i++ {
+				action, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+				require.Nil(t, err)
+				if _, proceeded := action.(*actions.NoOpAction); proceeded {
+					allowed++
+				}
+			}
+		}
+		assert.Equal(t, testCase.wantAllowed, allowed, testCase.name)
+	}
+}
+
+func TestStrategyBasedQueueRemediesOfASharedLimitGroupShareOneDynamicQuota(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	plugin := newStrategyBasedQueuePlugin(clock)
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	dynamicQuotas := remedies.NewDynamicQuotas(clock, meter, time.Hour)
+	plugin.SetDynamicQuotas(dynamicQuotas)
+	dynamicQuotas.Set(map[string]int64{"upstream": 2})
+
+	allowed := func(remedyName string) int {
+		scopedRemedy := buildStrategyBasedQueueScopedRemedy(3, 10, nil)
+		scopedRemedy.Remedy.Name = remedyName
+		queueConfig := scopedRemedy.Remedy.Config.StrategyBasedQueue
+		queueConfig.QueueSize = 0
+		queueConfig.SharedLimitGroup = "upstream"
+		queueConfig.DynamicQuota = &sharedConfig.DynamicQuota{
+			Key:                    "upstream",
+			MinAllowedRequestCount: 1,
+			MaxAllowedRequestCount: 10,
+		}
+		allowed := 0
+		for i := 0; i < 2; i++ {
+			action, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+			require.Nil(t, err)
+			if _, proceeded := action.(*actions.NoOpAction); proceeded {
+				allowed++
+			}
+		}
+		return allowed
+	}
+
+	assert.Equal(t, 2, allowed("first"))
+	// The quota changing mid-window applies to the whole group from the next
+	// window, rather than to the remedy of the group resolving it first
+	dynamicQuotas.Set(map[string]int64{"upstream": 5})
+	assert.Equal(t, 0, allowed("second"))
+	// So the group reports its one effective quota
+	assert.Equal(t, map[string]int64{"upstream": 2}, collectPerAttribute(t, reader,
+		"lunar_remedies.dynamic_quota.allowed_requests", "remedy"))
+}
+
+// recreateQueueWithinWindow uses 2 of 3 requests of the window, then changes
+// the remedy's quota to 5, recreating its queue, and returns how many of
+// 5 further requests in the same window are allowed
+func TestStrategyBasedQueueReducedQuotaAppliesFromNextWindow(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	plugin := newStrategyBasedQueuePluginWithMeter(clock, meter, nil)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(3, 10, nil)
+	for i := 0; i < 3; i++ {
+		action, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+		require.Nil(t, err)
+		require.IsType(t, &actions.NoOpAction{}, action)
+	}
+
+	// The quota is reduced mid-window, the window's quota is already used up
+	clock.AdvanceTime(8 * time.Second)
+	reducedRemedy := buildStrategyBasedQueueScopedRemedy(1, 10, nil)
+	results := make(chan actions.ReqLunarAction, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			action, err := plugin.OnRequest(onRequestArgs(), reducedRemedy)
+			assert.Nil(t, err)
+			results <- action
+		}()
+	}
+	// Both requests wait, rather than bursting under the reduced quota
+	requestsInQueueMetric := "lunar_remedies.strategy_based_queue.requests_in_queue"
+	require.Eventually(t, func() bool {
+		return collectPerTenant(t, reader, requestsInQueueMetric)[tenant.DefaultTenant] == 2
+	}, time.Second, time.Millisecond)
+	assert.Empty(t, results)
+	assert.Equal(t, []remedies.RemedyWindowUsage{
+		{RemedyName: "test", Quota: 3, Used: 3, Rejected: 0},
+	}, plugin.WindowUsages())
+
+	// From the next window, the reduced quota admits a single waiting request
+	clock.AdvanceTime(2 * time.Second)
+	require.IsType(t, &actions.NoOpAction{}, <-results)
+	assert.Equal(t, []remedies.RemedyWindowUsage{
+		{RemedyName: "test", Quota: 1, Used: 1, Rejected: 0},
+	}, plugin.WindowUsages())
+	select {
+	case action := <-results:
+		assert.Fail(t, "request proceeded beyond the reduced quota", action)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func recreateQueueWithinWindow(
+	t *testing.T,
+	clock *clock.MockClock,
+	plugin *remedies.StrategyBasedQueuePlugin,
+	warmStart bool,
+) int {
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(3, 10, nil)
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.WarmStart = warmStart
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.QueueSize = 0
+	for i := 0; i < 2; i++ {
+		_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+		require.Nil(t, err)
+	}
+
+	clock.AdvanceTime(3 * time.Second)
+	recreatedRemedy := buildStrategyBasedQueueScopedRemedy(5, 10, nil)
+	recreatedRemedy.Remedy.Config.StrategyBasedQueue.WarmStart = warmStart
+	recreatedRemedy.Remedy.Config.StrategyBasedQueue.QueueSize = 0
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		action, err := plugin.OnRequest(onRequestArgs(), recreatedRemedy)
+		require.Nil(t, err)
+		if _, proceeded := action.(*actions.NoOpAction); proceeded {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+type mockRejectedRequestsExporter struct {
+	mutex            sync.Mutex
+	rejectedRequests []remedies.RejectedRequest
+}
+
+func (exporter *mockRejectedRequestsExporter) ExportRejectedRequest(
+	rejectedRequest remedies.RejectedRequest,
+) {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+	exporter.rejectedRequests = append(exporter.rejectedRequests, rejectedRequest)
+}
+
+func (exporter *mockRejectedRequestsExporter) exported() []remedies.RejectedRequest {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+	return append([]remedies.RejectedRequest{}, exporter.rejectedRequests...)
+}
+
+func newStrategyBasedQueuePlugin(
+	clock clock.Clock,
+) *remedies.StrategyBasedQueuePlugin {
+	return newStrategyBasedQueuePluginWithMeter(
+		clock, noop.NewMeterProvider().Meter("test"), nil)
+}
+
+func newStrategyBasedQueuePluginWithMeter(
+	clock clock.Clock,
+	meter metric.Meter,
+	tenantResolver *tenant.Resolver,
+) *remedies.StrategyBasedQueuePlugin {
+	return newStrategyBasedQueuePluginWithTimeout(
+		clock, queueProxyTimeout, meter, tenantResolver)
+}
+
+func newStrategyBasedQueuePluginWithTimeout(
+	clock clock.Clock,
+	proxyTimeout time.Duration,
+	meter metric.Meter,
+	tenantResolver *tenant.Resolver,
+) *remedies.StrategyBasedQueuePlugin {
+	return newStrategyBasedQueuePluginWithExporter(
+		clock, proxyTimeout, meter, tenantResolver, nil)
+}
+
+func newStrategyBasedQueuePluginWithExporter(
+	clock clock.Clock,
+	proxyTimeout time.Duration,
+	meter metric.Meter,
+	tenantResolver *tenant.Resolver,
+	rejectedRequestsExporter remedies.RejectedRequestsExporter,
+) *remedies.StrategyBasedQueuePlugin {
+	return remedies.NewStrategyBasedQueuePlugin(
+		context.Background(),
+		clock,
+		proxyTimeout,
+		logging.ContextLogger{},
+		meter,
+		tenantResolver,
+		rejectedRequestsExporter,
+		func(queueKey queue.QueueKey) queue.DelayedPriorityQueueable {
+			return queue.NewInMemoryDelayedPriorityQueue(
+				queueKey,
+				clock,
+				logging.ContextLogger{},
+			)
+		},
+	)
+}
+
+func buildStrategyBasedQueueScopedRemedy(
+	allowedRequestCount int64,
+	windowSizeInSeconds int,
+	rateLimitHeaders *sharedConfig.RateLimitHeaders,
+) config.ScopedRemedy {
+	remedyConfig := sharedConfig.StrategyBasedQueueConfig{
+		AllowedRequestCount: allowedRequestCount,
+		WindowSizeInSeconds: windowSizeInSeconds,
+		ResponseStatusCode:  429,
+		TTLSeconds:          5,
+		QueueSize:           10,
+		RateLimitHeaders:    rateLimitHeaders,
+	}
+	remedy := sharedConfig.Remedy{
+		Enabled: true,
+		Name:    "test",
+		Config: sharedConfig.RemedyConfig{
+			StrategyBasedQueue: &remedyConfig,
+		},
+	}
+	return config.ScopedRemedy{
+		Scope:         utils.ScopeEndpoint,
+		Method:        "GET",
+		NormalizedURL: "test.com/some/path",
+		Remedy:        &remedy,
+	}
+}
+
+func TestStrategyBasedQueueLabelsPrioritiesByTheNearestConfiguredOne(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	plugin := newStrategyBasedQueuePluginWithMeter(clock, meter, nil)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(10, 10, nil)
+	halfWeight := 0.5
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.Prioritization = &sharedConfig.GroupPrioritization{
+		Combine: "weighted_sum",
+		Dimensions: []sharedConfig.PrioritizationDimension{
+			{
+				GroupBy: sharedConfig.GroupBy{HeaderName: "X-Tier"},
+				Groups: map[string]sharedConfig.Prioritization{
+					"premium": {Priority: 1},
+					"free":    {Priority: 5},
+				},
+				Weight: &halfWeight,
+			},
+			{
+				GroupBy: sharedConfig.GroupBy{HeaderName: "X-Cost"},
+				Groups: map[string]sharedConfig.Prioritization{
+					"cheap":     {Priority: 2},
+					"expensive": {Priority: 4},
+				},
+				Weight: &halfWeight,
+			},
+		},
+	}
+
+	request := func(tier string, cost string) messages.OnRequest {
+		onRequest := onRequestArgs()
+		onRequest.Headers = map[string]string{"X-Tier": tier, "X-Cost": cost}
+		return onRequest
+	}
+	// Combined into 2.5 and 3.5, which are not configured priorities
+	for _, onRequest := range []messages.OnRequest{
+		request("premium", "expensive"), request("free", "cheap"),
+	} {
+		action, err := plugin.OnRequest(onRequest, scopedRemedy)
+		require.Nil(t, err)
+		require.Equal(t, &actions.NoOpAction{}, action)
+	}
+
+	assert.Equal(t, map[string]int64{"2": 1, "4": 1},
+		collectPerAttribute(t, reader,
+			"lunar_remedies.strategy_based_queue.requests", "priority"))
+}
+
+func TestStrategyBasedQueueRecordsShadowQuotaDecisionsWithoutEnforcingThem(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	plugin := newStrategyBasedQueuePluginWithMeter(clock, meter, nil)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(2, 10, nil)
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.QueueSize = 0
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.ShadowQuota = &sharedConfig.ShadowQuota{
+		Enabled:             true,
+		AllowedRequestCount: 1,
+	}
+
+	proceeded := []bool{}
+	for i := 0; i < 3; i++ {
+		action, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+		require.Nil(t, err)
+		_, noOp := action.(*actions.NoOpAction)
+		proceeded = append(proceeded, noOp)
+	}
+	// The real quota of 2 is enforced, rather than the shadow quota of 1
+	assert.Equal(t, []bool{true, true, false}, proceeded)
+
+	assert.Equal(t, map[string]int64{"false": 1, "true": 2},
+		collectPerAttribute(t, reader,
+			"lunar_remedies.strategy_based_queue.shadow_requests", "shadow_blocked"))
+	assert.Equal(t, map[string]int64{"false": 2, "true": 1},
+		collectPerAttribute(t, reader,
+			"lunar_remedies.strategy_based_queue.shadow_requests", "blocked"))
+
+	// A new window restarts the shadow quota
+	clock.AdvanceTime(10 * time.Second)
+	_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]int64{"false": 2, "true": 2},
+		collectPerAttribute(t, reader,
+			"lunar_remedies.strategy_based_queue.shadow_requests", "shadow_blocked"))
+}
+
+func TestStrategyBasedQueueRejectsNewRequestOnFullQueueByDefault(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	exporter := &mockRejectedRequestsExporter{}
+	plugin := newStrategyBasedQueuePluginWithExporter(
+		clock, queueProxyTimeout, meter, nil, exporter)
+	scopedRemedy, oldestDone := fillStrategyBasedQueue(t, plugin, reader, "")
+
+	newest := onRequestArgs()
+	newest.ID = "newest"
+	action, err := plugin.OnRequest(newest, scopedRemedy)
+	require.Nil(t, err)
+	require.IsType(t, &actions.EarlyResponseAction{}, action)
+	require.Len(t, exporter.exported(), 1)
+	assert.Equal(t, "newest", exporter.exported()[0].RequestID)
+	assert.Equal(t, "queue_full", exporter.exported()[0].Reason)
+
+	// The oldest request keeps waiting until its TTL expires
+	select {
+	case <-oldestDone:
+		t.Fatal("oldest request should still be waiting")
+	default:
+	}
+	require.Eventually(t, func() bool {
+		clock.AdvanceTime(time.Second)
+		return len(exporter.exported()) == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "ttl_expired", exporter.exported()[1].Reason)
+}
+
+func TestStrategyBasedQueueEvictsOldestRequestOnFullQueue(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	exporter := &mockRejectedRequestsExporter{}
+	plugin := newStrategyBasedQueuePluginWithExporter(
+		clock, queueProxyTimeout, meter, nil, exporter)
+	scopedRemedy, oldestDone := fillStrategyBasedQueue(
+		t, plugin, reader, "reject_oldest")
+
+	newestDone := make(chan struct{})
+	go func() {
+		defer close(newestDone)
+		newest := onRequestArgs()
+		newest.ID = "newest"
+		_, err := plugin.OnRequest(newest, scopedRemedy)
+		assert.Nil(t, err)
+	}()
+
+	// The oldest request is rejected right away to admit the newest one
+	select {
+	case <-oldestDone:
+	case <-time.After(time.Second):
+		t.Fatal("oldest request should have been evicted")
+	}
+	require.Len(t, exporter.exported(), 1)
+	assert.Equal(t, "oldest", exporter.exported()[0].RequestID)
+	assert.Equal(t, "evicted", exporter.exported()[0].Reason)
+	assert.Equal(t, map[string]int64{"test": 1}, collectPerAttribute(t, reader,
+		"lunar_remedies.strategy_based_queue.evicted_requests", "remedy"))
+
+	// The newest request waits in the queue in its place
+	requestsInQueueMetric := "lunar_remedies.strategy_based_queue.requests_in_queue"
+	assert.Equal(t, int64(1),
+		collectPerTenant(t, reader, requestsInQueueMetric)[tenant.DefaultTenant])
+	require.Eventually(t, func() bool {
+		clock.AdvanceTime(time.Second)
+		select {
+		case <-newestDone:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "ttl_expired", exporter.exported()[1].Reason)
+}
+
+// fillStrategyBasedQueue uses up the window quota of a remedy with a queue
+// of a single request, then enqueues the "oldest" request to fill the queue.
+// The returned channel is closed once the oldest request is decided.
+func fillStrategyBasedQueue(
+	t *testing.T,
+	plugin *remedies.StrategyBasedQueuePlugin,
+	reader *sdkMetric.ManualReader,
+	overflowPolicy string,
+) (config.ScopedRemedy, <-chan struct{}) {
+	// The window is long enough for the TTL to expire before it ends
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(1, 60, nil)
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.QueueSize = 1
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.OverflowPolicy = overflowPolicy
+
+	_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+
+	oldestDone := make(chan struct{})
+	go func() {
+		defer close(oldestDone)
+		oldest := onRequestArgs()
+		oldest.ID = "oldest"
+		_, err := plugin.OnRequest(oldest, scopedRemedy)
+		assert.Nil(t, err)
+	}()
+	requestsInQueueMetric := "lunar_remedies.strategy_based_queue.requests_in_queue"
+	require.Eventually(t, func() bool {
+		return collectPerTenant(t, reader, requestsInQueueMetric)[tenant.DefaultTenant] == 1
+	}, time.Second, time.Millisecond)
+	return scopedRemedy, oldestDone
+}
+
+func TestStrategyBasedQueueAdmitsRequestsWithoutQueueingInWarmup(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	plugin := newStrategyBasedQueuePlugin(clock)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(1, 10, nil)
+	scopedRemedy.Remedy.WarmupSeconds = 10
+	scopedRemedy.Remedy.WarmupEndsAt = clock.Now().Add(10 * time.Second)
+
+	action, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+	// Beyond its quota, the request is rejected right away rather than
+	// waiting for the next window, for the rejection to be observed
+	action, err = plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+	wantBlocked := getEarlyResponseAction()
+	assert.Equal(t, &wantBlocked, action)
+}
+
+func TestStrategyBasedQueueShedsRequestsWhileTheirProviderIsThrottled(
+	t *testing.T,
+) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	bus := events.NewBus(ctx, noop.NewMeterProvider().Meter("test"))
+	throttlingPlugin := remedies.NewResponseBasedThrottlingPlugin(clock)
+	throttlingPlugin.SetEventBus(bus)
+	exporter := &mockRejectedRequestsExporter{}
+	queuePlugin := newStrategyBasedQueuePluginWithExporter(clock, queueProxyTimeout,
+		noop.NewMeterProvider().Meter("test"), nil, exporter)
+	queuePlugin.SubscribeToEvents(bus)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(100, 10, nil)
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.ShedWhileProviderThrottled = true
+
+	throttlingConfig := basicRemedyConfig()
+	_, err := throttlingPlugin.OnResponse(
+		responseArgs(map[string]string{"Retry-After": "5"}), &throttlingConfig)
+	require.Nil(t, err)
+
+	wantShed := getEarlyResponseAction()
+	assert.Eventually(t, func() bool {
+		action, err := queuePlugin.OnRequest(onRequestArgs(), scopedRemedy)
+		return err == nil && assert.ObjectsAreEqual(&wantShed, action)
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []remedies.RejectedRequest{{
+		RequestID:  onRequestArgs().ID,
+		RemedyName: "test",
+		Priority:   0,
+		TenantID:   tenant.DefaultTenant,
+		EnqueuedAt: time.Time{},
+		WaitTime:   0,
+		Reason:     "provider_throttled",
+		StatusCode: 429,
+		Method:     "GET",
+		Path:       "/some/path",
+		Headers:    map[string]string{},
+	}}, exporter.exported())
+
+	clock.AdvanceTime(5 * time.Second)
+	action, err := queuePlugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+}
+
+func TestStrategyBasedQueueAdmittedRequestsWaitForAConcurrencySlot(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	plugin := newStrategyBasedQueuePlugin(clock)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(100, 10, nil)
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.MaxConcurrentRequests = 1
+
+	inFlight := onRequestArgs()
+	action, err := plugin.OnRequest(inFlight, scopedRemedy)
+	require.Nil(t, err)
+	require.Equal(t, &actions.NoOpAction{}, action)
+
+	waiting := onRequestArgs()
+	waiting.ID = "waiting"
+	proceeded := make(chan actions.ReqLunarAction, 1)
+	go func() {
+		action, err := plugin.OnRequest(waiting, scopedRemedy)
+		assert.Nil(t, err)
+		proceeded <- action
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, proceeded)
+
+	// Once the response of the request in flight frees its slot
+	_, err = plugin.OnResponse(basicResponseArgs(200, "", nil), scopedRemedy)
+	require.Nil(t, err)
+	select {
+	case action := <-proceeded:
+		assert.Equal(t, &actions.NoOpAction{}, action)
+	case <-time.After(time.Second):
+		require.Fail(t, "request did not proceed once a slot was freed")
+	}
+
+	// Requests which find no slot within their TTL are rejected
+	rejected := make(chan actions.ReqLunarAction, 1)
+	go func() {
+		action, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+		assert.Nil(t, err)
+		rejected <- action
+	}()
+	time.Sleep(10 * time.Millisecond)
+	clock.AdvanceTime(5 * time.Second)
+	wantRejected := getEarlyResponseAction()
+	select {
+	case action := <-rejected:
+		assert.Equal(t, &wantRejected, action)
+	case <-time.After(time.Second):
+		require.Fail(t, "request kept waiting for a slot after its TTL")
+	}
+}
+
+func TestStrategyBasedQueueListsPendingRequestsWithoutDecidingThem(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	plugin := newStrategyBasedQueuePlugin(clock)
+	scopedRemedy := buildStrategyBasedQueueScopedRemedy(1, 10, nil)
+	scopedRemedy.Remedy.Config.StrategyBasedQueue.Prioritization = &sharedConfig.GroupPrioritization{
+		GroupBy: sharedConfig.GroupBy{HeaderName: "X-Group"},
+		Groups: map[string]sharedConfig.Prioritization{
+			"urgent": {Priority: 0},
+			"batch":  {Priority: 2},
+		},
+	}
+	assert.Empty(t, plugin.PendingQueues())
+
+	_, err := plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+
+	decided := make(chan actions.ReqLunarAction, 2)
+	enqueue := func(id string, group string) {
+		args := onRequestArgs()
+		args.ID = id
+		args.Headers = map[string]string{"X-Group": group}
+		go func() {
+			action, err := plugin.OnRequest(args, scopedRemedy)
+			assert.Nil(t, err)
+			decided <- action
+		}()
+	}
+	enqueue("batch", "batch")
+	require.Eventually(t, func() bool {
+		pending := plugin.PendingQueues()
+		return len(pending) == 1 && len(pending[0].Requests) == 1
+	}, time.Second, time.Millisecond)
+	clock.AdvanceTime(time.Second)
+	enqueue("urgent", "urgent")
+	require.Eventually(t, func() bool {
+		pending := plugin.PendingQueues()
+		return len(pending) == 1 && len(pending[0].Requests) == 2
+	}, time.Second, time.Millisecond)
+
+	pending := plugin.PendingQueues()[0]
+	assert.Equal(t, "test", pending.RemedyName)
+	assert.Equal(t, int64(1), pending.WindowQuota)
+	assert.Equal(t, 10.0, pending.WindowSizeSeconds)
+	assert.Equal(t, []remedies.PendingQueuedRequest{
+		{ID: "urgent", Priority: 0, EnqueuedAt: time.Unix(1001, 0), RemainingTTLSeconds: 5},
+		{ID: "batch", Priority: 2, EnqueuedAt: time.Unix(1000, 0), RemainingTTLSeconds: 4},
+	}, pending.Requests)
+	assert.Empty(t, decided)
+
+	// The pending requests are still decided as the queue drains
+	clock.AdvanceTime(10 * time.Second)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-decided:
+		case <-time.After(time.Second):
+			require.Fail(t, "pending request was not decided")
+		}
+	}
+	assert.Empty(t, plugin.PendingQueues())
+}
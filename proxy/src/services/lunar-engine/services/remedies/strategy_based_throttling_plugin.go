@@ -6,8 +6,11 @@ import (
 	"lunar/engine/config"
 	"lunar/engine/messages"
 	"lunar/engine/utils"
+	"lunar/engine/utils/events"
 	"lunar/engine/utils/limit"
 	"lunar/engine/utils/obfuscation"
+	"lunar/engine/utils/tenant"
+	"lunar/engine/utils/upstreamhost"
 	sharedConfig "lunar/shared-model/config"
 	"lunar/toolkit-core/clock"
 	"strings"
@@ -21,9 +24,10 @@ import (
 
 const (
 	defaultResponseStatusCode = 429
-	quotaUsedMetricName       = "lunar_remedies.strategy_based_throttling.quota_used"
-	quotaLimitMetricName      = "lunar_remedies.strategy_based_throttling.quota_limit"
+	blockedAttribute          = "blocked"
 	consumerTag               = "x-lunar-consumer-tag"
+	connectionGroupPrefix     = "connection:"
+	clientCertGroupPrefix     = "client_certificate:"
 )
 
 type StrategyBasedThrottlingPlugin struct {
@@ -34,18 +38,38 @@ type StrategyBasedThrottlingPlugin struct {
 	nextWindowTime time.Time
 
 	definedQuotas map[string]int64
-	mutex         sync.RWMutex
+	// tenants seen per limiter, and the tenant owning each quota group
+	limiterTenants map[string]map[string]struct{}
+	groupTenants   map[limit.RequestArguments]string
+	// requests blocked per limiter
+	rejectedCounts map[string]int64
+	// per connection quota groups, which are forgotten once their window
+	// ended, as connections are not known to be closed otherwise
+	connectionGroups     map[limit.RequestArguments]connectionGroup
+	nextConnectionsSweep time.Time
+	// end of the window each limiter was last saturated in
+	saturatedUntil map[string]time.Time
+	mutex          sync.RWMutex
+	// nil unless saturated quotas should be published
+	events *events.Bus
+	// nil unless quotas may be overridden by a dynamic source
+	dynamicQuotas *DynamicQuotas
+	// nil unless the requests metric is labeled by upstream host
+	hosts *upstreamhost.Labels
 
 	obfuscator obfuscation.Obfuscator
+	tenants    *tenant.Resolver
 
 	quotaUsedMetric  metric.Int64ObservableGauge
 	quotaLimitMetric metric.Int64ObservableGauge
+	requestsMetric   *sampledCounter
 }
 
 func NewStrategyBasedThrottlingPlugin(
 	ctx context.Context,
 	clock clock.Clock,
 	meter metric.Meter,
+	tenantResolver *tenant.Resolver,
 	rateLimitState limit.IncrementableRateLimitState,
 	obfuscator obfuscation.Obfuscator,
 ) (*StrategyBasedThrottlingPlugin, error) {
@@ -55,10 +79,17 @@ func NewStrategyBasedThrottlingPlugin(
 		rateLimitState: rateLimitState,
 		nextWindowTime: clock.Now(),
 
-		definedQuotas: map[string]int64{},
-		mutex:         sync.RWMutex{},
+		definedQuotas:  map[string]int64{},
+		limiterTenants: map[string]map[string]struct{}{},
+		groupTenants:   map[limit.RequestArguments]string{},
+		rejectedCounts: map[string]int64{},
+		mutex:          sync.RWMutex{},
+
+		connectionGroups: map[limit.RequestArguments]connectionGroup{},
+		saturatedUntil:   map[string]time.Time{},
 
 		obfuscator: obfuscator,
+		tenants:    tenantResolver,
 	}
 
 	if meter != nil {
@@ -71,8 +102,18 @@ func NewStrategyBasedThrottlingPlugin(
 			return nil, err
 		}
 
+		requestsMetric, err := meter.Int64Counter(
+			throttlingRequestsInstrument.name,
+			throttlingRequestsInstrument.withDescription(),
+			throttlingRequestsInstrument.withUnit(),
+		)
+		if err != nil {
+			return nil, err
+		}
+
 		plugin.quotaUsedMetric = quotaUsedMetric
 		plugin.quotaLimitMetric = quotaLimitMetric
+		plugin.requestsMetric = newSampledCounter(requestsMetric)
 	}
 
 	return plugin, nil
@@ -83,9 +124,18 @@ func (plugin *StrategyBasedThrottlingPlugin) OnRequest(
 	scopedRemedy config.ScopedRemedy,
 ) (actions.ReqLunarAction, error) {
 	remedyConfig := scopedRemedy.Remedy.Config.StrategyBasedThrottling
+	tenantID := plugin.tenants.Resolve(onRequest.Headers)
+
+	windowSize := time.Duration(remedyConfig.WindowSizeInSeconds) * time.Second
+	allowedRequestCount := plugin.dynamicQuotas.AllowedRequestCount(
+		scopedRemedy.Remedy.Name,
+		remedyConfig.AllowedRequestCount,
+		remedyConfig.DynamicQuota,
+		windowSize,
+	)
 
 	plugin.mutex.Lock()
-	plugin.definedQuotas[scopedRemedy.Remedy.Name] = remedyConfig.AllowedRequestCount
+	plugin.definedQuotas[scopedRemedy.Remedy.Name] = allowedRequestCount
 	plugin.mutex.Unlock()
 
 	responseStatusCode := defaultResponseStatusCode
@@ -104,6 +154,10 @@ func (plugin *StrategyBasedThrottlingPlugin) OnRequest(
 		Grouping:  grouping,
 		GroupID:   groupID,
 	}
+	if remedyConfig.PerConnection && onRequest.ConnectionID != "" {
+		plugin.recordConnectionGroup(requestArgs, onRequest.ConnectionID, windowSize)
+	}
+	plugin.recordTenant(requestArgs, tenantID)
 
 	quotaAllocationRatio := float64(1)
 	if remedyConfig.GroupQuotaAllocation != nil {
@@ -122,23 +176,28 @@ func (plugin *StrategyBasedThrottlingPlugin) OnRequest(
 
 			switch remedyConfig.GroupQuotaAllocation.DefaultBehavior() {
 			case sharedConfig.DefaultQuotaGroupBehaviorAllow:
+				plugin.incrementRequestsMetric(onRequest, scopedRemedy, tenantID, false)
 				return &actions.NoOpAction{}, nil
 			case sharedConfig.DefaultQuotaGroupBehaviorBlock:
-				action := plainTextTooManyRequestsAction(responseStatusCode)
+				plugin.incrementRequestsMetric(onRequest, scopedRemedy, tenantID, true)
+				action := tooManyRequestsAction(
+					scopedRemedy.Remedy,
+					responseStatusCode,
+					rejectionDetails{RequestID: onRequest.ID}, //nolint:exhaustruct
+				)
 				return &action, nil
 			case sharedConfig.DefaultQuotaGroupBehaviorUseDefaultAllocation:
 				quotaAllocationRatio = remedyConfig.GroupQuotaAllocation.DefaultAllocationPercentage / 100
 			case sharedConfig.DefaultQuotaGroupBehaviorUndefined:
+				plugin.incrementRequestsMetric(onRequest, scopedRemedy, tenantID, false)
 				return &actions.NoOpAction{}, nil
 			}
 		}
 	}
 
 	windowData := limit.WindowData{
-		WindowSize: time.Duration(
-			remedyConfig.WindowSizeInSeconds,
-		) * time.Second,
-		AllowedRequestCount:  remedyConfig.AllowedRequestCount,
+		WindowSize:           windowSize,
+		AllowedRequestCount:  allowedRequestCount,
 		QuotaAllocationRatio: quotaAllocationRatio,
 		SpilloverRenewOnDay:  remedyConfig.SpilloverConfig.RenewOnDay,
 		SpilloverEnabled:     remedyConfig.SpilloverConfig.Enabled,
@@ -163,20 +222,125 @@ func (plugin *StrategyBasedThrottlingPlugin) OnRequest(
 	}
 
 	if currentLimitState.LimitSate == limit.Block {
-		action := plainTextTooManyRequestsAction(responseStatusCode)
+		plugin.incrementRequestsMetric(onRequest, scopedRemedy, tenantID, true)
+		plugin.publishQuotaSaturated(scopedRemedy.Remedy.Name, windowData.WindowSize)
+		action := tooManyRequestsAction(
+			scopedRemedy.Remedy,
+			responseStatusCode,
+			rejectionDetails{
+				RequestID:  onRequest.ID,
+				RetryAfter: untilNextWindow(plugin.clock.Now(), windowData.WindowSize),
+			},
+		)
 		return &action, err
 	}
 
+	plugin.incrementRequestsMetric(onRequest, scopedRemedy, tenantID, false)
 	return &actions.NoOpAction{}, err
 }
 
+// SetEventBus publishes the quotas used up by requests to the given bus
+func (plugin *StrategyBasedThrottlingPlugin) SetEventBus(bus *events.Bus) {
+	plugin.events = bus
+}
+
+// SetDynamicQuotas has the remedies with a dynamic quota
+// apply the quotas of the given source
+func (plugin *StrategyBasedThrottlingPlugin) SetDynamicQuotas(
+	dynamicQuotas *DynamicQuotas,
+) {
+	plugin.dynamicQuotas = dynamicQuotas
+}
+
+// SetHostLabels has the requests metric be labeled by the upstream host
+// of the remedies' target
+func (plugin *StrategyBasedThrottlingPlugin) SetHostLabels(hosts *upstreamhost.Labels) {
+	plugin.hosts = hosts
+}
+
+// publishQuotaSaturated publishes the first request blocked in each window
+// of a limiter, rather than every blocked request
+func (plugin *StrategyBasedThrottlingPlugin) publishQuotaSaturated(
+	limiterID string,
+	windowSize time.Duration,
+) {
+	if plugin.events == nil {
+		return
+	}
+	now := plugin.clock.Now()
+	plugin.mutex.Lock()
+	if now.Before(plugin.saturatedUntil[limiterID]) {
+		plugin.mutex.Unlock()
+		return
+	}
+	windowEnd := now.Add(untilNextWindow(now, windowSize))
+	plugin.saturatedUntil[limiterID] = windowEnd
+	plugin.mutex.Unlock()
+	plugin.events.Publish(events.QuotaSaturated{
+		RemedyName: limiterID,
+		WindowEnd:  windowEnd,
+	})
+}
+
+func (plugin *StrategyBasedThrottlingPlugin) incrementRequestsMetric(
+	onRequest messages.OnRequest,
+	scopedRemedy config.ScopedRemedy,
+	tenantID string,
+	blocked bool,
+) {
+	if blocked {
+		withTraceID(log.Trace(), onRequest).Str("requestID", onRequest.ID).
+			Str("remedy", scopedRemedy.Remedy.Name).
+			Msg("quota is used up, will return early response")
+		plugin.mutex.Lock()
+		plugin.rejectedCounts[scopedRemedy.Remedy.Name]++
+		plugin.mutex.Unlock()
+	}
+	if plugin.requestsMetric == nil {
+		return
+	}
+	attributes := append([]attribute.KeyValue{
+		attribute.String(remedyAttribute, scopedRemedy.Remedy.Name),
+		attribute.Bool(blockedAttribute, blocked),
+		attribute.String(tenant.AttributeName, tenantID),
+	}, plugin.hosts.Attributes(targetOf(scopedRemedy, onRequest))...)
+	plugin.requestsMetric.add(
+		tracedContext(plugin.ctx, onRequest),
+		scopedRemedy.Remedy.RequestsMetricSampling,
+		attributes...,
+	)
+}
+
+// recordTenant records the tenant of a request counted on the given quota
+// group. Groups counting requests of several tenants are labeled as shared.
+func (plugin *StrategyBasedThrottlingPlugin) recordTenant(
+	requestArgs limit.RequestArguments,
+	tenantID string,
+) {
+	plugin.mutex.Lock()
+	defer plugin.mutex.Unlock()
+
+	if _, found := plugin.limiterTenants[requestArgs.LimiterID]; !found {
+		plugin.limiterTenants[requestArgs.LimiterID] = map[string]struct{}{}
+	}
+	plugin.limiterTenants[requestArgs.LimiterID][tenantID] = struct{}{}
+
+	groupTenant, found := plugin.groupTenants[requestArgs]
+	if !found {
+		plugin.groupTenants[requestArgs] = tenantID
+	} else if groupTenant != tenantID {
+		plugin.groupTenants[requestArgs] = tenant.SharedTenant
+	}
+}
+
 func getQuotaAllocationRatio(
 	remedyConfig *sharedConfig.StrategyBasedThrottlingConfig,
 	onRequest messages.OnRequest,
 ) (float64, bool) {
+	groupBy := remedyConfig.GroupQuotaAllocation.GroupBy
+	headerValue, _ := onRequest.Header(groupBy.HeaderName, groupBy.MultiValueHeader())
 	for _, allocation := range remedyConfig.GroupQuotaAllocation.Groups {
-		if allocation.GroupHeaderValue ==
-			onRequest.Headers[remedyConfig.GroupQuotaAllocation.GroupBy.HeaderName] {
+		if allocation.GroupHeaderValue == headerValue {
 			return allocation.AllocationPercentage / 100, true
 		}
 	}
@@ -194,20 +358,98 @@ func buildGroupID(
 	remedyConfig *sharedConfig.StrategyBasedThrottlingConfig,
 	onRequest messages.OnRequest,
 	obfuscator obfuscation.Obfuscator,
+) (limit.GroupID, limit.Grouping) {
+	groupID, grouping := buildHeaderGroupID(remedyConfig, onRequest, obfuscator)
+	if remedyConfig.PerClientCertificate &&
+		onRequest.ClientCertificate.Fingerprint != "" {
+		groupID = clientCertGroupPrefix + onRequest.ClientCertificate.Fingerprint
+		grouping = limit.Grouped
+	}
+	// Requests not sent on a known connection share the quota
+	if !remedyConfig.PerConnection || onRequest.ConnectionID == "" {
+		return groupID, grouping
+	}
+
+	connectionGroupID := connectionGroupPrefix + onRequest.ConnectionID
+	if grouping == limit.Grouped {
+		connectionGroupID = groupID + "|" + connectionGroupID
+	}
+	return connectionGroupID, limit.Grouped
+}
+
+func buildHeaderGroupID(
+	remedyConfig *sharedConfig.StrategyBasedThrottlingConfig,
+	onRequest messages.OnRequest,
+	obfuscator obfuscation.Obfuscator,
 ) (limit.GroupID, limit.Grouping) {
 	if remedyConfig.GroupQuotaAllocation == nil {
 		return limit.UngroupedLimit, limit.Ungrouped
 	}
 
-	groupHeaderName := remedyConfig.GroupQuotaAllocation.GroupBy.HeaderName
-	headerValue := onRequest.Headers[groupHeaderName]
+	groupBy := remedyConfig.GroupQuotaAllocation.GroupBy
+	headerValue, _ := onRequest.Header(groupBy.HeaderName, groupBy.MultiValueHeader())
 	obfuscatedHeaderValue := obfuscator.ObfuscateString(headerValue)
-	headerName := strings.ToLower(groupHeaderName)
+	headerName := strings.ToLower(groupBy.HeaderName)
 	groupID := headerName + ":" + strings.TrimSpace(obfuscatedHeaderValue)
 
 	return groupID, limit.Grouped
 }
 
+// connectionGroup is a per connection quota group,
+// labeled on metrics by the group it is a part of
+type connectionGroup struct {
+	label     string
+	windowEnd time.Time
+}
+
+// recordConnectionGroup keeps track of a per connection quota group until
+// its window ends. Once the earliest window ended, the groups of connections
+// which had no requests since their window ended (e.g. as the connection was
+// closed) are forgotten, as their next request would reset them anyway.
+func (plugin *StrategyBasedThrottlingPlugin) recordConnectionGroup(
+	requestArgs limit.RequestArguments,
+	connectionID string,
+	windowSize time.Duration,
+) {
+	now := plugin.clock.Now()
+	label := strings.TrimSuffix(strings.TrimSuffix(requestArgs.GroupID,
+		connectionGroupPrefix+connectionID), "|")
+	if label == "" {
+		label = limit.UngroupedLimit
+	}
+
+	plugin.mutex.Lock()
+	defer plugin.mutex.Unlock()
+	if !now.Before(plugin.nextConnectionsSweep) {
+		plugin.nextConnectionsSweep = time.Time{}
+		for otherArgs, group := range plugin.connectionGroups {
+			if now.Before(group.windowEnd) {
+				plugin.scheduleConnectionsSweep(group.windowEnd)
+				continue
+			}
+			plugin.rateLimitState.Forget(otherArgs)
+			delete(plugin.connectionGroups, otherArgs)
+			delete(plugin.groupTenants, otherArgs)
+		}
+	}
+
+	group := connectionGroup{
+		label:     label,
+		windowEnd: now.Add(untilNextWindow(now, windowSize)),
+	}
+	plugin.connectionGroups[requestArgs] = group
+	plugin.scheduleConnectionsSweep(group.windowEnd)
+}
+
+func (plugin *StrategyBasedThrottlingPlugin) scheduleConnectionsSweep(
+	windowEnd time.Time,
+) {
+	if plugin.nextConnectionsSweep.IsZero() ||
+		windowEnd.Before(plugin.nextConnectionsSweep) {
+		plugin.nextConnectionsSweep = windowEnd
+	}
+}
+
 func logRateLimitState(
 	scopedRemedy config.ScopedRemedy,
 	grouping limit.Grouping,
@@ -256,12 +498,35 @@ func logRateLimitState(
 	}
 }
 
+var _ WindowUsageReporter = &StrategyBasedThrottlingPlugin{} //nolint:exhaustruct
+
+// WindowUsages reports the usage of the current window of each limiter,
+// summed up over all of its quota groups
+func (plugin *StrategyBasedThrottlingPlugin) WindowUsages() []RemedyWindowUsage {
+	usages := map[string]*RemedyWindowUsage{}
+	counters := plugin.rateLimitState.Counters()
+
+	plugin.mutex.RLock()
+	defer plugin.mutex.RUnlock()
+	for limiterID, quota := range plugin.definedQuotas {
+		windowUsageOf(usages, limiterID).Quota = quota
+	}
+	for requestArgs, counter := range counters {
+		windowUsageOf(usages, requestArgs.LimiterID).Used += counter
+	}
+	for limiterID, rejected := range plugin.rejectedCounts {
+		windowUsageOf(usages, limiterID).Rejected = rejected
+	}
+	return sortedWindowUsages(usages)
+}
+
 func (plugin *StrategyBasedThrottlingPlugin) initializeQuotaUsedMetric(
 	meter metric.Meter,
 ) (metric.Int64ObservableGauge, error) {
 	quotaUsedMetric, err := meter.Int64ObservableGauge(
-		quotaUsedMetricName,
-		metric.WithDescription("Used quota for strategy based throttling"),
+		throttlingQuotaUsedInstrument.name,
+		throttlingQuotaUsedInstrument.withDescription(),
+		throttlingQuotaUsedInstrument.withUnit(),
 		metric.WithInt64Callback(plugin.observeQuotaUsed),
 	)
 	if err != nil {
@@ -274,8 +539,9 @@ func (plugin *StrategyBasedThrottlingPlugin) initializeQuotaLimitMetric(
 	meter metric.Meter,
 ) (metric.Int64ObservableGauge, error) {
 	quotaLimitMetric, err := meter.Int64ObservableGauge(
-		quotaLimitMetricName,
-		metric.WithDescription("Quota limit for strategy based throttling"),
+		throttlingQuotaLimitInstrument.name,
+		throttlingQuotaLimitInstrument.withDescription(),
+		throttlingQuotaLimitInstrument.withUnit(),
 		metric.WithInt64Callback(plugin.observeQuotaLimit),
 	)
 	if err != nil {
@@ -292,12 +558,15 @@ func (plugin *StrategyBasedThrottlingPlugin) observeQuotaLimit(
 	defer plugin.mutex.RUnlock()
 
 	for limiterID, quota := range plugin.definedQuotas {
-		observer.Observe(
-			int64(quota),
-			metric.WithAttributes(
-				attribute.String("remedy_name", limiterID),
-			),
-		)
+		for tenantID := range plugin.limiterTenants[limiterID] {
+			observer.Observe(
+				int64(quota),
+				metric.WithAttributes(
+					attribute.String("remedy_name", limiterID),
+					attribute.String(tenant.AttributeName, tenantID),
+				),
+			)
+		}
 	}
 
 	return nil
@@ -310,13 +579,38 @@ func (plugin *StrategyBasedThrottlingPlugin) observeQuotaUsed(
 	plugin.mutex.RLock()
 	defer plugin.mutex.RUnlock()
 
+	// Per connection groups are summed up by the group they are a part of,
+	// so connections do not add up to unbounded series
+	type quotaUsedKey struct {
+		groupID  string
+		limiter  string
+		tenantID string
+	}
+	quotaUsed := map[quotaUsedKey]int64{}
 	for requestArgs, counter := range plugin.rateLimitState.Counters() {
+		tenantID, found := plugin.groupTenants[requestArgs]
+		if !found {
+			tenantID = tenant.DefaultTenant
+		}
+		groupID := requestArgs.GroupID
+		if group, found := plugin.connectionGroups[requestArgs]; found {
+			groupID = group.label
+		}
+		quotaUsed[quotaUsedKey{
+			groupID:  groupID,
+			limiter:  requestArgs.LimiterID,
+			tenantID: tenantID,
+		}] += counter
+	}
+
+	for key, counter := range quotaUsed {
 		attributes := []attribute.KeyValue{
-			attribute.String("group_id", string(requestArgs.GroupID)),
-			attribute.String("remedy_name", requestArgs.LimiterID),
+			attribute.String("group_id", key.groupID),
+			attribute.String("remedy_name", key.limiter),
+			attribute.String(tenant.AttributeName, key.tenantID),
 		}
 
-		observer.Observe(int64(counter), metric.WithAttributes(attributes...))
+		observer.Observe(counter, metric.WithAttributes(attributes...))
 	}
 	return nil
 }
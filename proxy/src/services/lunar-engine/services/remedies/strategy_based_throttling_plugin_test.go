@@ -11,6 +11,7 @@ import (
 	"lunar/engine/utils"
 	"lunar/engine/utils/limit"
 	"lunar/engine/utils/obfuscation"
+	"lunar/engine/utils/tenant"
 	sharedConfig "lunar/shared-model/config"
 	"lunar/toolkit-core/clock"
 	"lunar/toolkit-core/logging"
@@ -19,6 +20,9 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
 )
 
 func TestWhenOnRequestIsCalledMoreThanAllowedRequestsRateLimitWithSpillover(
@@ -80,7 +84,7 @@ func setTest(
 	ctx := context.Background()
 	rateLimitState := limit.NewRateLimitState(clock, logging.ContextLogger{})
 	plugin, _ := remedies.NewStrategyBasedThrottlingPlugin(
-		ctx, clock, nil, rateLimitState, obfuscator)
+		ctx, clock, nil, nil, rateLimitState, obfuscator)
 	remedyConfig := strategyBasedThrottlingRemedyConfig(
 		allowedRequests, windowSizeInSeconds, nil, spilloverEnabled)
 	onRequestArgs := onRequestArgs()
@@ -141,7 +145,7 @@ func TestWhenGroupQuotaAllocationIsDefinedAndOnRequestIsCalledMoreThanAllowedReq
 	ctx := context.Background()
 	rateLimitState := limit.NewRateLimitState(clock, logging.ContextLogger{})
 	plugin, _ := remedies.NewStrategyBasedThrottlingPlugin(
-		ctx, clock, nil, rateLimitState, obfuscator)
+		ctx, clock, nil, nil, rateLimitState, obfuscator)
 
 	groupBy := sharedConfig.GroupBy{
 		HeaderName: xGroupHeaderName,
@@ -248,7 +252,7 @@ func TestWhenRequestFromUnknownGroupArrivesDefaultBehaviorIsUsed(
 	ctx := context.Background()
 	rateLimitState := limit.NewRateLimitState(clock, logging.ContextLogger{})
 	plugin, _ := remedies.NewStrategyBasedThrottlingPlugin(
-		ctx, clock, nil, rateLimitState, obfuscator)
+		ctx, clock, nil, nil, rateLimitState, obfuscator)
 
 	groupBy := sharedConfig.GroupBy{
 		HeaderName: xGroupHeaderName,
@@ -434,3 +438,270 @@ func strategyBasedThrottlingRemedyConfig(
 		SpilloverConfig:      spilloverConfig,
 	}
 }
+
+func TestStrategyBasedThrottlingMetricsCarryTenant(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	rateLimitState := limit.NewRateLimitState(clock, logging.ContextLogger{})
+	plugin, err := remedies.NewStrategyBasedThrottlingPlugin(
+		context.Background(),
+		clock,
+		meter,
+		tenant.NewResolver("X-Tenant-ID", 10),
+		rateLimitState,
+		obfuscation.Obfuscator{Hasher: obfuscation.IdentityHasher{}},
+	)
+	require.Nil(t, err)
+	scopedRemedy := config.ScopedRemedy{
+		Scope:         utils.ScopeEndpoint,
+		Method:        "GET",
+		NormalizedURL: "test.com/some/path",
+		Remedy: &sharedConfig.Remedy{
+			Name: "my remedy",
+			Config: sharedConfig.RemedyConfig{
+				StrategyBasedThrottling: strategyBasedThrottlingRemedyConfig(
+					1, 10, nil, false),
+			},
+		},
+	}
+
+	acmeRequest := onRequestArgs()
+	acmeRequest.Headers = map[string]string{"X-Tenant-ID": "acme"}
+	for i := 0; i < 2; i++ {
+		_, err := plugin.OnRequest(acmeRequest, scopedRemedy)
+		require.Nil(t, err)
+	}
+	_, err = plugin.OnRequest(onRequestArgs(), scopedRemedy)
+	require.Nil(t, err)
+
+	assert.Equal(t, map[string]int64{"acme": 2, tenant.DefaultTenant: 1},
+		collectPerTenant(t, reader, "lunar_remedies.strategy_based_throttling.requests"))
+	// Requests of both tenants are counted on the same ungrouped quota
+	assert.Equal(t, map[string]int64{tenant.SharedTenant: 1},
+		collectPerTenant(t, reader, "lunar_remedies.strategy_based_throttling.quota_used"))
+	assert.Equal(t, map[string]int64{"acme": 1, tenant.DefaultTenant: 1},
+		collectPerTenant(t, reader, "lunar_remedies.strategy_based_throttling.quota_limit"))
+}
+
+func TestStrategyBasedThrottlingReportsWindowUsage(t *testing.T) {
+	t.Parallel()
+	clock, plugin, onRequestArgs, scopedRemedy := setTest(2, 10, false)
+	for i := 0; i < 3; i++ {
+		_, err := plugin.OnRequest(onRequestArgs, scopedRemedy)
+		require.Nil(t, err)
+	}
+
+	assert.Equal(t, []remedies.RemedyWindowUsage{
+		{RemedyName: "my remedy", Quota: 2, Used: 2, Rejected: 1},
+	}, plugin.WindowUsages())
+
+	// Reporting the usage does not affect admission
+	_, err := plugin.OnRequest(onRequestArgs, scopedRemedy)
+	require.Nil(t, err)
+	clock.AdvanceTime(10 * time.Second)
+	action, err := plugin.OnRequest(onRequestArgs, scopedRemedy)
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+	assert.Equal(t, []remedies.RemedyWindowUsage{
+		{RemedyName: "my remedy", Quota: 2, Used: 1, Rejected: 2},
+	}, plugin.WindowUsages())
+}
+
+func TestStrategyBasedThrottlingRejectsWithReferencedErrorTemplate(t *testing.T) {
+	t.Parallel()
+	clock, plugin, onRequestArgs, scopedRemedy := setTest(1, 60, false)
+	clock.Set(time.Unix(1015, 0))
+	scopedRemedy.Remedy.ErrorTemplate = "rate_limited"
+	scopedRemedy.Remedy.ResolvedErrorTemplate = &sharedConfig.ErrorTemplate{
+		Headers: map[string]string{"Retry-After": "{{retry_after}}"},
+		Body:    `{"request_id": "{{request_id}}", "retry_after": {{retry_after}}}`,
+	}
+	onRequestArgs.ID = "some-request"
+
+	assertNoOpAction(1, plugin, onRequestArgs, scopedRemedy, t)
+	action, err := plugin.OnRequest(onRequestArgs, scopedRemedy)
+
+	require.Nil(t, err)
+	// The window of 60 seconds started at 960, so it ends 5 seconds from now
+	assert.Equal(t, &actions.EarlyResponseAction{
+		Status: 429,
+		Headers: map[string]string{
+			"Retry-After":  "5",
+			"Content-Type": "application/json",
+		},
+		Body: `{"request_id": "some-request", "retry_after": 5}`,
+	}, action)
+}
+
+func TestStrategyBasedThrottlingGivesEachConnectionItsOwnQuota(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	rateLimitState := limit.NewRateLimitState(clock, logging.ContextLogger{})
+	plugin, err := remedies.NewStrategyBasedThrottlingPlugin(
+		context.Background(),
+		clock,
+		meter,
+		nil,
+		rateLimitState,
+		obfuscation.Obfuscator{Hasher: obfuscation.IdentityHasher{}},
+	)
+	require.Nil(t, err)
+	remedyConfig := strategyBasedThrottlingRemedyConfig(1, 10, nil, false)
+	remedyConfig.PerConnection = true
+	scopedRemedy := config.ScopedRemedy{
+		Scope:         utils.ScopeEndpoint,
+		Method:        "GET",
+		NormalizedURL: "test.com/some/path",
+		Remedy: &sharedConfig.Remedy{
+			Name: "my remedy",
+			Config: sharedConfig.RemedyConfig{
+				StrategyBasedThrottling: remedyConfig,
+			},
+		},
+	}
+	onConnection := func(connectionID string) messages.OnRequest {
+		onRequest := onRequestArgs()
+		onRequest.ConnectionID = connectionID
+		return onRequest
+	}
+	wantBlocked := getEarlyResponseAction()
+
+	action, err := plugin.OnRequest(onConnection("first"), scopedRemedy)
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+	action, err = plugin.OnRequest(onConnection("first"), scopedRemedy)
+	require.Nil(t, err)
+	assert.Equal(t, &wantBlocked, action)
+	action, err = plugin.OnRequest(onConnection("second"), scopedRemedy)
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+
+	// Connections are summed up on the quota used metric
+	assert.Equal(t, map[string]int64{limit.UngroupedLimit: 2},
+		collectPerAttribute(t, reader,
+			"lunar_remedies.strategy_based_throttling.quota_used", "group_id"))
+
+	// Once their window ended, the quota groups of connections
+	// without further requests (e.g. closed ones) are forgotten
+	clock.AdvanceTime(10 * time.Second)
+	action, err = plugin.OnRequest(onConnection("third"), scopedRemedy)
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+	assert.Equal(t, map[limit.RequestArguments]int64{{
+		LimiterID: "my remedy",
+		Grouping:  limit.Grouped,
+		GroupID:   "connection:third",
+	}: 1}, rateLimitState.Counters())
+}
+
+func TestStrategyBasedThrottlingKeepsRemediesOfDifferentScopesApart(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	plugin, err := remedies.NewStrategyBasedThrottlingPlugin(
+		context.Background(),
+		clock,
+		meter,
+		nil,
+		limit.NewRateLimitState(clock, logging.ContextLogger{}),
+		obfuscation.Obfuscator{Hasher: obfuscation.IdentityHasher{}},
+	)
+	require.Nil(t, err)
+	scopedRemedy := func(name string, url string) config.ScopedRemedy {
+		return config.ScopedRemedy{
+			Scope:         utils.ScopeEndpoint,
+			Method:        "GET",
+			NormalizedURL: url,
+			Remedy: &sharedConfig.Remedy{
+				Name: name,
+				Config: sharedConfig.RemedyConfig{
+					StrategyBasedThrottling: strategyBasedThrottlingRemedyConfig(
+						1, 10, nil, false),
+				},
+			},
+		}
+	}
+	users := scopedRemedy("users throttling", "test.com/users")
+	orders := scopedRemedy("orders throttling", "test.com/orders")
+	wantBlocked := getEarlyResponseAction()
+
+	action, err := plugin.OnRequest(onRequestArgs(), users)
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+	action, err = plugin.OnRequest(onRequestArgs(), users)
+	require.Nil(t, err)
+	assert.Equal(t, &wantBlocked, action)
+	// The quota of a remedy of the same type on another scope is untouched
+	action, err = plugin.OnRequest(onRequestArgs(), orders)
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+
+	assert.Equal(t, map[string]int64{"users throttling": 2, "orders throttling": 1},
+		collectPerAttribute(t, reader,
+			"lunar_remedies.strategy_based_throttling.requests", "remedy"))
+}
+
+func TestStrategyBasedThrottlingGroupsByVerifiedClientCertificate(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	clock.Set(time.Unix(1000, 0))
+	rateLimitState := limit.NewRateLimitState(clock, logging.ContextLogger{})
+	plugin, err := remedies.NewStrategyBasedThrottlingPlugin(
+		context.Background(),
+		clock,
+		noop.NewMeterProvider().Meter("test"),
+		nil,
+		rateLimitState,
+		obfuscation.Obfuscator{Hasher: obfuscation.IdentityHasher{}},
+	)
+	require.Nil(t, err)
+	remedyConfig := strategyBasedThrottlingRemedyConfig(1, 10, nil, false)
+	remedyConfig.PerClientCertificate = true
+	scopedRemedy := config.ScopedRemedy{
+		Scope:         utils.ScopeEndpoint,
+		Method:        "GET",
+		NormalizedURL: "test.com/some/path",
+		Remedy: &sharedConfig.Remedy{
+			Name: "my remedy",
+			Config: sharedConfig.RemedyConfig{
+				StrategyBasedThrottling: remedyConfig,
+			},
+		},
+	}
+	withCertificate := func(fingerprint string) messages.OnRequest {
+		onRequest := onRequestArgs()
+		if fingerprint != "" {
+			onRequest.ClientCertificate = messages.ClientCertificate{
+				Subject:     "/CN=" + fingerprint,
+				Fingerprint: fingerprint,
+			}
+		}
+		return onRequest
+	}
+	wantBlocked := getEarlyResponseAction()
+
+	action, err := plugin.OnRequest(withCertificate("aa11"), scopedRemedy)
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+	action, err = plugin.OnRequest(withCertificate("aa11"), scopedRemedy)
+	require.Nil(t, err)
+	assert.Equal(t, &wantBlocked, action)
+	action, err = plugin.OnRequest(withCertificate("bb22"), scopedRemedy)
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+
+	// Requests without a client certificate fall back to sharing the quota
+	action, err = plugin.OnRequest(withCertificate(""), scopedRemedy)
+	require.Nil(t, err)
+	assert.Equal(t, &actions.NoOpAction{}, action)
+	action, err = plugin.OnRequest(withCertificate(""), scopedRemedy)
+	require.Nil(t, err)
+	assert.Equal(t, &wantBlocked, action)
+}
@@ -0,0 +1,73 @@
+package remedies
+
+import (
+	"lunar/engine/config"
+	"lunar/toolkit-core/clock"
+	"lunar/toolkit-core/vacuum"
+	"sync"
+	"time"
+)
+
+const transactionRemediesVacuumName = "TransactionRemediesVacuum"
+
+// TransactionRemedies records the remedies each transaction ran on its
+// request, so the same remedies run on its response. Whether a remedy applies
+// to a transaction is decided once, on its request, so a remedy which
+// took a slot or state on the request always releases it on the response,
+// even if its gating changed in between. Transactions whose response never
+// arrives are forgotten once the proxy timeout elapsed.
+type TransactionRemedies struct {
+	remedies       map[string][]config.ScopedRemedy
+	remediesMutex  *sync.RWMutex
+	remediesVacuum *vacuum.MapVacuum[string, []config.ScopedRemedy]
+}
+
+func NewTransactionRemedies(
+	clock clock.Clock,
+	proxyTimeout time.Duration,
+) *TransactionRemedies {
+	remedies := map[string][]config.ScopedRemedy{}
+	remediesMutex := sync.RWMutex{}
+	remediesVacuum := vacuum.NewMapVacuum(
+		transactionRemediesVacuumName,
+		clock,
+		proxyTimeout,
+		vacuumTick,
+		remedies,
+		&remediesMutex,
+	)
+	return &TransactionRemedies{
+		remedies:       remedies,
+		remediesMutex:  &remediesMutex,
+		remediesVacuum: &remediesVacuum,
+	}
+}
+
+// Record records the remedies the transaction ran on its request
+func (transactionRemedies *TransactionRemedies) Record(
+	transactionID string,
+	remedies []config.ScopedRemedy,
+) {
+	if transactionRemedies == nil {
+		return
+	}
+	transactionRemedies.remediesMutex.Lock()
+	transactionRemedies.remedies[transactionID] = remedies
+	transactionRemedies.remediesMutex.Unlock()
+	transactionRemedies.remediesVacuum.VacuumKey(transactionID)
+}
+
+// Take returns the remedies the transaction ran on its request, forgetting
+// them, and whether they were recorded
+func (transactionRemedies *TransactionRemedies) Take(
+	transactionID string,
+) ([]config.ScopedRemedy, bool) {
+	if transactionRemedies == nil {
+		return nil, false
+	}
+	transactionRemedies.remediesMutex.Lock()
+	defer transactionRemedies.remediesMutex.Unlock()
+	remedies, found := transactionRemedies.remedies[transactionID]
+	delete(transactionRemedies.remedies, transactionID)
+	return remedies, found
+}
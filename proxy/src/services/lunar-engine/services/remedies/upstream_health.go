@@ -0,0 +1,153 @@
+package remedies
+
+import (
+	"lunar/engine/config"
+	"lunar/engine/messages"
+	"lunar/engine/utils/events"
+	sharedConfig "lunar/shared-model/config"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// outcomes of the recent responses of each provider to classify it by
+	upstreamHealthWindowSize = 50
+	// a provider is only classified once enough of its responses were observed
+	upstreamHealthMinSamples = 10
+	// a provider is degraded once at least this ratio of its recent
+	// responses failed or were slow
+	upstreamDegradedRatio = 0.5
+	// responses whose headers took longer to arrive are considered slow
+	upstreamSlowResponseThreshold = 5 * time.Second
+
+	// upstream health events kept pending for the remedies to handle
+	upstreamHealthBufferSize = 100
+)
+
+// UpstreamHealth classifies each provider as healthy or degraded by the
+// status and latency of its recent responses, so remedies configured with
+// `apply_when` are applied according to the health of their upstream.
+// Classification changes are published to the event bus, and the health
+// the remedies gate on is the one received from it, so the remedies share
+// a single classification. Until a provider is classified, or without an
+// event bus, the remedies are applied as if `apply_when` was `always`.
+type UpstreamHealth struct {
+	events *events.Bus
+
+	outcomesMutex sync.Mutex
+	outcomes      map[string]*upstreamOutcomes
+
+	degradedMutex sync.RWMutex
+	degraded      map[string]bool
+}
+
+// upstreamOutcomes holds whether each of the recent responses of a provider
+// failed or was slow, in a ring of upstreamHealthWindowSize entries
+type upstreamOutcomes struct {
+	failed      [upstreamHealthWindowSize]bool
+	next        int
+	samples     int
+	failedCount int
+	degraded    bool
+}
+
+func NewUpstreamHealth(bus *events.Bus) *UpstreamHealth {
+	health := &UpstreamHealth{
+		events:        bus,
+		outcomesMutex: sync.Mutex{},
+		outcomes:      map[string]*upstreamOutcomes{},
+		degradedMutex: sync.RWMutex{},
+		degraded:      map[string]bool{},
+	}
+	bus.Subscribe("upstream_health", events.KindUpstreamHealth,
+		upstreamHealthBufferSize, health.onUpstreamHealth)
+	return health
+}
+
+// Observe records the outcome of a response of the upstream,
+// publishing the health of its provider once its classification changed
+func (health *UpstreamHealth) Observe(onResponse messages.OnResponse) {
+	if health == nil {
+		return
+	}
+	failed := onResponse.Status >= http.StatusInternalServerError ||
+		onResponse.UpstreamDuration > upstreamSlowResponseThreshold
+	provider := providerOf(onResponse.URL)
+
+	health.outcomesMutex.Lock()
+	outcomes, found := health.outcomes[provider]
+	if !found {
+		outcomes = &upstreamOutcomes{} //nolint:exhaustruct
+		health.outcomes[provider] = outcomes
+	}
+	changed := outcomes.record(failed)
+	degraded := outcomes.degraded
+	health.outcomesMutex.Unlock()
+
+	if changed {
+		health.events.Publish(events.UpstreamHealth{
+			Provider: provider,
+			Degraded: degraded,
+		})
+	}
+}
+
+// record adds an outcome to the window and returns whether
+// the classification of the provider changed
+func (outcomes *upstreamOutcomes) record(failed bool) bool {
+	if outcomes.samples == upstreamHealthWindowSize {
+		if outcomes.failed[outcomes.next] {
+			outcomes.failedCount--
+		}
+	} else {
+		outcomes.samples++
+	}
+	outcomes.failed[outcomes.next] = failed
+	if failed {
+		outcomes.failedCount++
+	}
+	outcomes.next = (outcomes.next + 1) % upstreamHealthWindowSize
+
+	if outcomes.samples < upstreamHealthMinSamples {
+		return false
+	}
+	degraded := float64(outcomes.failedCount) >=
+		upstreamDegradedRatio*float64(outcomes.samples)
+	// the first classification is published even if healthy,
+	// as it makes the provider known to the remedies
+	changed := degraded != outcomes.degraded ||
+		outcomes.samples == upstreamHealthMinSamples
+	outcomes.degraded = degraded
+	return changed
+}
+
+func (health *UpstreamHealth) onUpstreamHealth(event events.Event) {
+	upstreamHealth, ok := event.(events.UpstreamHealth)
+	if !ok {
+		return
+	}
+	health.degradedMutex.Lock()
+	defer health.degradedMutex.Unlock()
+	health.degraded[upstreamHealth.Provider] = upstreamHealth.Degraded
+}
+
+// Applies returns whether the given remedy should be applied
+// to a transaction sent to the given URL
+func (health *UpstreamHealth) Applies(
+	scopedRemedy config.ScopedRemedy,
+	url string,
+) bool {
+	applicability := scopedRemedy.Remedy.Applicability()
+	if health == nil || applicability == sharedConfig.ApplyWhenAlways ||
+		applicability == sharedConfig.ApplyWhenUndefined {
+		return true
+	}
+	health.degradedMutex.RLock()
+	degraded, known := health.degraded[providerOf(url)]
+	health.degradedMutex.RUnlock()
+	if !known {
+		return true
+	}
+	return degraded == (applicability == sharedConfig.ApplyWhenUpstreamDegraded)
+}
@@ -0,0 +1,107 @@
+package remedies_test
+
+import (
+	"context"
+	"lunar/engine/config"
+	"lunar/engine/messages"
+	"lunar/engine/services/remedies"
+	"lunar/engine/utils"
+	"lunar/engine/utils/events"
+	sharedConfig "lunar/shared-model/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+func newTestUpstreamHealth(t *testing.T) *remedies.UpstreamHealth {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return remedies.NewUpstreamHealth(events.NewBus(ctx, noop.NewMeterProvider().Meter("test")))
+}
+
+func applyWhenRemedy(applyWhen string) config.ScopedRemedy {
+	return config.ScopedRemedy{
+		Scope:         utils.ScopeEndpoint,
+		Method:        "GET",
+		NormalizedURL: "test.com/some/path",
+		Remedy: &sharedConfig.Remedy{
+			Name:      "my remedy",
+			ApplyWhen: applyWhen,
+			Config: sharedConfig.RemedyConfig{
+				FixedResponse: &sharedConfig.FixedResponseConfig{StatusCode: 503},
+			},
+		},
+	}
+}
+
+func observeResponses(health *remedies.UpstreamHealth, status int, count int) {
+	for i := 0; i < count; i++ {
+		health.Observe(messages.OnResponse{
+			URL:    "test.com/some/path",
+			Status: status,
+		})
+	}
+}
+
+func TestRemedyAppliedWhenUpstreamDegradedOnlyAppliesOnceItIsDegraded(t *testing.T) {
+	t.Parallel()
+	health := newTestUpstreamHealth(t)
+	degradedRemedy := applyWhenRemedy("upstream_degraded")
+	healthyRemedy := applyWhenRemedy("upstream_healthy")
+	applies := func(remedy config.ScopedRemedy) func() bool {
+		return func() bool { return health.Applies(remedy, "test.com/some/path") }
+	}
+
+	// Until the upstream is classified, remedies are always applied
+	assert.True(t, applies(degradedRemedy)())
+	assert.True(t, applies(healthyRemedy)())
+
+	observeResponses(health, 200, 10)
+	assert.Eventually(t, func() bool {
+		return !applies(degradedRemedy)() && applies(healthyRemedy)()
+	}, time.Second, time.Millisecond)
+
+	observeResponses(health, 502, 10)
+	assert.Eventually(t, func() bool {
+		return applies(degradedRemedy)() && !applies(healthyRemedy)()
+	}, time.Second, time.Millisecond)
+
+	// Upstreams of other providers are classified separately
+	assert.True(t, health.Applies(healthyRemedy, "other.com/some/path"))
+}
+
+func TestSlowResponsesDegradeTheUpstream(t *testing.T) {
+	t.Parallel()
+	health := newTestUpstreamHealth(t)
+	degradedRemedy := applyWhenRemedy("upstream_degraded")
+
+	for i := 0; i < 10; i++ {
+		health.Observe(messages.OnResponse{
+			URL:              "test.com/some/path",
+			Status:           200,
+			UpstreamDuration: 10 * time.Second,
+		})
+	}
+
+	assert.Eventually(t, func() bool {
+		return health.Applies(degradedRemedy, "test.com/some/path")
+	}, time.Second, time.Millisecond)
+}
+
+func TestRemediesAreAlwaysAppliedWithoutUpstreamHealth(t *testing.T) {
+	t.Parallel()
+	withoutBus := remedies.NewUpstreamHealth(nil)
+	var withoutHealth *remedies.UpstreamHealth
+
+	observeResponses(withoutBus, 502, 20)
+	withoutHealth.Observe(messages.OnResponse{URL: "test.com/some/path", Status: 502})
+
+	for _, health := range []*remedies.UpstreamHealth{withoutBus, withoutHealth} {
+		assert.True(t, health.Applies(applyWhenRemedy("upstream_healthy"),
+			"test.com/some/path"))
+		assert.True(t, health.Applies(applyWhenRemedy("always"),
+			"test.com/some/path"))
+	}
+}
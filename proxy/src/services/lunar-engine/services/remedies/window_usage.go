@@ -0,0 +1,45 @@
+package remedies
+
+import "sort"
+
+// RemedyWindowUsage is a read-only view on the quota usage
+// of the current window of a remedy
+type RemedyWindowUsage struct {
+	RemedyName string
+	Quota      int64
+	Used       int64
+	// Requests rejected by the remedy since it was initialized
+	Rejected int64
+}
+
+// WindowUsageReporter is implemented by remedies admitting requests
+// according to a windowed quota. Reporting the usage must not affect
+// their admission decisions.
+type WindowUsageReporter interface {
+	WindowUsages() []RemedyWindowUsage
+}
+
+func windowUsageOf(
+	usages map[string]*RemedyWindowUsage,
+	remedyName string,
+) *RemedyWindowUsage {
+	usage, found := usages[remedyName]
+	if !found {
+		usage = &RemedyWindowUsage{RemedyName: remedyName} //nolint:exhaustruct
+		usages[remedyName] = usage
+	}
+	return usage
+}
+
+func sortedWindowUsages(
+	usages map[string]*RemedyWindowUsage,
+) []RemedyWindowUsage {
+	res := make([]RemedyWindowUsage, 0, len(usages))
+	for _, usage := range usages {
+		res = append(res, *usage)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].RemedyName < res[j].RemedyName
+	})
+	return res
+}
@@ -16,6 +16,20 @@ type RemedyPlugins struct {
 	RetryPlugin                      *remedies.RetryPlugin
 	AuthPlugin                       *remedies.AuthPlugin
 	CachingPlugin                    *remedies.CachingPlugin
+	ResponseBodyRewritePlugin        *remedies.ResponseBodyRewritePlugin
+	DecisionHookPlugin               *remedies.DecisionHookPlugin
+	QueryParamMaskingPlugin          *remedies.QueryParamMaskingPlugin
+	// Warmup withholds the actions of remedies in their warmup
+	Warmup *remedies.RemedyWarmup
+	// UpstreamHealth gates remedies on the health of their upstream
+	UpstreamHealth *remedies.UpstreamHealth
+	// Circuit disables remedies which keep erroring
+	Circuit *remedies.RemedyCircuit
+	// TransactionRemedies records the remedies each request ran,
+	// so its response runs the same ones
+	TransactionRemedies *remedies.TransactionRemedies
+	// DynamicQuotas override the quotas of remedies with a dynamic quota
+	DynamicQuotas *remedies.DynamicQuotas
 }
 
 type DiagnosisPlugins struct {
@@ -27,6 +41,8 @@ type DiagnosisPlugins struct {
 type Exporters struct {
 	Content    exporters.RawDataExporter
 	Prometheus exporters.PrometheusExporter
+	// nil unless usage snapshots are enabled
+	UsageSnapshot *exporters.UsageSnapshotExporter
 }
 
 type PoliciesServices struct {
@@ -5,14 +5,28 @@ import (
 	"lunar/engine/services/diagnoses"
 	"lunar/engine/services/exporters"
 	"lunar/engine/services/remedies"
+	"lunar/engine/utils/environment"
+	"lunar/engine/utils/events"
+	"lunar/engine/utils/hookpool"
 	"lunar/engine/utils/limit"
 	"lunar/engine/utils/obfuscation"
+	"lunar/engine/utils/tenant"
+	"lunar/engine/utils/upstreamhost"
 	"lunar/engine/utils/writers"
 	"lunar/shared-model/config"
 	"lunar/toolkit-core/clock"
 	"lunar/toolkit-core/logging"
 	"lunar/toolkit-core/otel"
 	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	defaultQueueRejectionsSampleRate       = 1.0
+	defaultRejectedRequestSamplesRate      = 0.01
+	defaultRejectedRequestSamplesPerMinute = 60
 )
 
 func initializeServices(
@@ -25,9 +39,6 @@ func initializeServices(
 	exportersConfig config.Exporters,
 ) (*PoliciesServices, error) {
 	md5Obfuscator := obfuscation.Obfuscator{Hasher: obfuscation.MD5Hasher{}}
-	identityObfuscator := obfuscation.Obfuscator{
-		Hasher: obfuscation.IdentityHasher{},
-	}
 	ctx := context.Background()
 
 	prometheusConfig := config.PrometheusConfig{}
@@ -35,41 +46,34 @@ func initializeServices(
 		prometheusConfig = *exportersConfig.Prometheus
 	}
 	meter := otel.GetMeter()
+	rawDataExporter := exporters.NewRawDataExporter(syslogWriter)
 
-	strategyBasedThrottlingPlugin, err := remedies.NewStrategyBasedThrottlingPlugin(
+	hostLabels := newHostLabels()
+	remedyPlugins, err := initializeRemedies(
 		ctx,
 		clock,
 		meter,
+		contextLogger,
+		proxyTimeout,
 		rateLimitState,
-		identityObfuscator,
+		delayedPriorityQueueFactory,
+		remedies.CombineRejectedRequestsExporters(
+			newRejectedRequestsExporter(clock, rawDataExporter),
+			newRejectedRequestSamplesExporter(clock, rawDataExporter, md5Obfuscator,
+				exportersConfig.Obfuscate),
+		),
+		newRemedyCircuit(clock, meter),
 	)
 	if err != nil {
 		return nil, err
 	}
+	remedyPlugins.StrategyBasedThrottlingPlugin.SetHostLabels(hostLabels)
+	remedyPlugins.StrategyBasedQueuePlugin.SetHostLabels(hostLabels)
+	prometheusExporter := exporters.NewPrometheusExporter(ctx, clock, meter, prometheusConfig)
+	prometheusExporter.SetHostLabels(hostLabels)
 
 	return &PoliciesServices{
-		Remedies: RemedyPlugins{
-			FixedResponsePlugin: remedies.NewFixedResponsePlugin(clock),
-			ResponseBasedThrottlingPlugin: remedies.NewResponseBasedThrottlingPlugin(
-				clock,
-			),
-			StrategyBasedThrottlingPlugin: strategyBasedThrottlingPlugin,
-			ConcurrencyBasedThrottlingPlugin: remedies.NewConcurrencyBasedThrottlingPlugin(
-				clock,
-				proxyTimeout,
-			),
-			StrategyBasedQueuePlugin: remedies.NewStrategyBasedQueuePlugin(
-				ctx,
-				clock,
-				contextLogger,
-				meter,
-				delayedPriorityQueueFactory,
-			),
-			AccountOrchestrationPlugin: remedies.NewAccountOrchestrationPlugin(),
-			RetryPlugin:                remedies.NewRetryPlugin(clock),
-			AuthPlugin:                 remedies.NewAuthPlugin(),
-			CachingPlugin:              remedies.NewCachingPlugin(clock),
-		},
+		Remedies: remedyPlugins,
 		Diagnosis: DiagnosisPlugins{
 			HARGeneratorPlugin: diagnoses.NewHARGeneratorPlugin(
 				clock,
@@ -79,8 +83,301 @@ func initializeServices(
 			Void:             &diagnoses.VoidPlugin{},
 		},
 		Exporters: Exporters{
-			Content:    *exporters.NewRawDataExporter(syslogWriter),
-			Prometheus: *exporters.NewPrometheusExporter(ctx, meter, prometheusConfig),
+			Content:    *rawDataExporter,
+			Prometheus: *prometheusExporter,
+			UsageSnapshot: newUsageSnapshotExporter(
+				clock,
+				syslogWriter,
+				remedyPlugins.StrategyBasedThrottlingPlugin,
+				remedyPlugins.StrategyBasedQueuePlugin,
+			),
 		},
 	}, nil
 }
+
+func initializeRemedies(
+	ctx context.Context,
+	clock clock.Clock,
+	meter metric.Meter,
+	contextLogger logging.ContextLogger,
+	proxyTimeout time.Duration,
+	rateLimitState limit.IncrementableRateLimitState,
+	delayedPriorityQueueFactory remedies.InitializeQueueFunc,
+	rejectedRequestsExporter remedies.RejectedRequestsExporter,
+	circuit *remedies.RemedyCircuit,
+) (RemedyPlugins, error) {
+	identityObfuscator := obfuscation.Obfuscator{
+		Hasher: obfuscation.IdentityHasher{},
+	}
+	tenantResolver := newTenantResolver()
+
+	bus := events.NewBus(ctx, meter)
+	dynamicQuotas := newDynamicQuotas(clock, meter)
+	responseBasedThrottlingPlugin := remedies.NewResponseBasedThrottlingPlugin(clock)
+	responseBasedThrottlingPlugin.SetEventBus(bus)
+
+	strategyBasedThrottlingPlugin, err := remedies.NewStrategyBasedThrottlingPlugin(
+		ctx,
+		clock,
+		meter,
+		tenantResolver,
+		rateLimitState,
+		identityObfuscator,
+	)
+	if err != nil {
+		return RemedyPlugins{}, err //nolint:exhaustruct
+	}
+	strategyBasedThrottlingPlugin.SetEventBus(bus)
+	strategyBasedThrottlingPlugin.SetDynamicQuotas(dynamicQuotas)
+
+	strategyBasedQueuePlugin := remedies.NewStrategyBasedQueuePlugin(
+		ctx,
+		clock,
+		proxyTimeout,
+		contextLogger,
+		meter,
+		tenantResolver,
+		rejectedRequestsExporter,
+		delayedPriorityQueueFactory,
+	)
+	strategyBasedQueuePlugin.SubscribeToEvents(bus)
+	strategyBasedQueuePlugin.SetDynamicQuotas(dynamicQuotas)
+	if window, err := environment.GetRejectionRatioWindow(); err == nil {
+		strategyBasedQueuePlugin.SetRejectionRatioWindow(window)
+	}
+
+	decisionHookPlugin := remedies.NewDecisionHookPlugin(ctx, clock, meter)
+	decisionHookPlugin.SetHookClient(newDecisionHookPool(meter).Client(0))
+
+	queryParamMaskingPlugin := remedies.NewQueryParamMaskingPlugin(
+		obfuscation.Obfuscator{Hasher: obfuscation.MD5Hasher{}},
+	)
+
+	return RemedyPlugins{
+		FixedResponsePlugin:           remedies.NewFixedResponsePlugin(clock),
+		ResponseBasedThrottlingPlugin: responseBasedThrottlingPlugin,
+		StrategyBasedThrottlingPlugin: strategyBasedThrottlingPlugin,
+		ConcurrencyBasedThrottlingPlugin: remedies.NewConcurrencyBasedThrottlingPlugin(
+			clock,
+			proxyTimeout,
+		),
+		StrategyBasedQueuePlugin:   strategyBasedQueuePlugin,
+		AccountOrchestrationPlugin: remedies.NewAccountOrchestrationPlugin(),
+		RetryPlugin:                remedies.NewRetryPlugin(clock),
+		AuthPlugin:                 remedies.NewAuthPlugin(ctx, clock, meter),
+		CachingPlugin:              remedies.NewCachingPlugin(clock, proxyTimeout, meter),
+		ResponseBodyRewritePlugin:  remedies.NewResponseBodyRewritePlugin(),
+		DecisionHookPlugin:         decisionHookPlugin,
+		QueryParamMaskingPlugin:    queryParamMaskingPlugin,
+		Warmup:                     remedies.NewRemedyWarmup(ctx, clock, meter),
+		UpstreamHealth:             remedies.NewUpstreamHealth(bus),
+		Circuit:                    circuit,
+		TransactionRemedies:        remedies.NewTransactionRemedies(clock, proxyTimeout),
+		DynamicQuotas:              dynamicQuotas,
+	}, nil
+}
+
+// newRemedyCircuit reads the circuit configuration, by default remedies
+// are disabled for a minute once they errored 10 times in a row
+func newRemedyCircuit(clock clock.Clock, meter metric.Meter) *remedies.RemedyCircuit {
+	consecutiveErrors, err := environment.GetRemedyCircuitConsecutiveErrors()
+	if err != nil || consecutiveErrors < 0 {
+		consecutiveErrors = remedies.DefaultRemedyCircuitConsecutiveErrors
+	}
+	cooldown, err := environment.GetRemedyCircuitCooldown()
+	if err != nil || cooldown <= 0 {
+		cooldown = remedies.DefaultRemedyCircuitCooldown
+	}
+	return remedies.NewRemedyCircuit(clock, meter, consecutiveErrors, cooldown)
+}
+
+// newDynamicQuotas reads how long dynamic quotas apply once set,
+// by default they fall back to the static quotas after 5 minutes
+func newDynamicQuotas(clock clock.Clock, meter metric.Meter) *remedies.DynamicQuotas {
+	maxAge, err := environment.GetDynamicQuotasMaxAge()
+	if err != nil || maxAge <= 0 {
+		maxAge = remedies.DefaultDynamicQuotasMaxAge
+	}
+	return remedies.NewDynamicQuotas(clock, meter, maxAge)
+}
+
+// newDecisionHookPool reads the settings of the connections hooks are called with,
+// each falling back to its default if unset or invalid
+func newDecisionHookPool(meter metric.Meter) *hookpool.Pool {
+	settings := hookpool.DefaultSettings()
+	if maxIdleConns, err := environment.GetDecisionHookMaxIdleConns(); err == nil &&
+		maxIdleConns >= 0 {
+		settings.MaxIdleConns = maxIdleConns
+	}
+	if maxConnsPerHost, err := environment.GetDecisionHookMaxConnsPerHost(); err == nil &&
+		maxConnsPerHost >= 0 {
+		settings.MaxConnsPerHost = maxConnsPerHost
+	}
+	if idleConnTimeout, err := environment.GetDecisionHookIdleConnTimeout(); err == nil &&
+		idleConnTimeout > 0 {
+		settings.IdleConnTimeout = idleConnTimeout
+	}
+	if forceHTTP2, err := environment.GetDecisionHookForceHTTP2(); err == nil {
+		settings.ForceHTTP2 = forceHTTP2
+	}
+	return hookpool.NewPool(settings, meter)
+}
+
+func newTenantResolver() *tenant.Resolver {
+	tenantHeader := environment.GetMetricsTenantHeader()
+	if tenantHeader == "" {
+		log.Debug().Msg("Metrics tenant header not set, using default tenant")
+	}
+	maxCardinality, err := environment.GetMetricsTenantMaxCardinality()
+	if err != nil {
+		maxCardinality = tenant.DefaultMaxCardinality
+	}
+	return tenant.NewResolver(tenantHeader, maxCardinality)
+}
+
+// newHostLabels labels metrics by upstream host if enabled,
+// by default up to 100 distinct hosts
+func newHostLabels() *upstreamhost.Labels {
+	if !environment.IsMetricsHostLabelEnabled() {
+		return nil
+	}
+	maxCardinality, err := environment.GetMetricsHostMaxCardinality()
+	if err != nil {
+		maxCardinality = upstreamhost.DefaultMaxCardinality
+	}
+	return upstreamhost.NewLabels(maxCardinality)
+}
+
+// newUsageSnapshotExporter runs an exporter of the remedies' window usage
+// if an interval is configured. Snapshots are written to the configured
+// endpoint, or otherwise along with the other exported data,
+// to the configured raw data exporter (file, s3 or s3_minio).
+func newUsageSnapshotExporter(
+	clock clock.Clock,
+	syslogWriter writers.Writer,
+	reporters ...remedies.WindowUsageReporter,
+) *exporters.UsageSnapshotExporter {
+	interval, err := environment.GetUsageSnapshotInterval()
+	if err != nil || interval <= 0 {
+		log.Debug().Msg("Usage snapshot interval not set, snapshots are disabled")
+		return nil
+	}
+	writer := syslogWriter
+	exporterName := exporters.UsageSnapshotExporterName
+	if endpoint := environment.GetUsageSnapshotEndpoint(); endpoint != "" {
+		writer = writers.Dial("tcp", endpoint, clock)
+	} else {
+		exporterType := config.ParseExporterType(
+			environment.GetUsageSnapshotExporter())
+		if !isRawDataExporter(exporterType) {
+			log.Warn().Msg("Usage snapshot endpoint or raw data exporter " +
+				"not set, snapshots are disabled")
+			return nil
+		}
+		exporterName = exporterType.Name()
+	}
+	exporter := exporters.NewUsageSnapshotExporter(
+		clock, writer, exporterName, interval, reporters...)
+	exporter.Run()
+	return exporter
+}
+
+// newRejectedRequestsExporter exports a record of the requests rejected
+// by queues to the configured raw data exporter (file, s3 or s3_minio).
+// It returns nil, disabling the export, if no such exporter is configured.
+func newRejectedRequestsExporter(
+	clock clock.Clock,
+	rawDataExporter *exporters.RawDataExporter,
+) remedies.RejectedRequestsExporter {
+	exporterType := config.ParseExporterType(
+		environment.GetQueueRejectionsExporter())
+	if !isRawDataExporter(exporterType) {
+		log.Debug().Msg("Queue rejections exporter not set, " +
+			"rejected requests are not exported")
+		return nil
+	}
+	sampleRate, err := environment.GetQueueRejectionsSampleRate()
+	if err != nil || sampleRate <= 0 || sampleRate > 1 {
+		log.Debug().Msgf("Queue rejections sample rate is not within (0, 1], "+
+			"using %v", defaultQueueRejectionsSampleRate)
+		sampleRate = defaultQueueRejectionsSampleRate
+	}
+	return exporters.NewRejectedRequestsExporter(
+		clock, rawDataExporter, exporterType, sampleRate)
+}
+
+// newRejectedRequestSamplesExporter exports samples of the requests rejected
+// by queues, with their headers and path obfuscated as the exporters
+// obfuscation sets (unconditionally if unset), to the configured raw
+// data exporter (file, s3 or s3_minio). By default 1% of the rejected requests
+// are sampled, up to 60 samples per minute. It returns nil, disabling the
+// samples, if no such exporter is configured.
+func newRejectedRequestSamplesExporter(
+	clock clock.Clock,
+	rawDataExporter *exporters.RawDataExporter,
+	obfuscator obfuscation.Obfuscator,
+	obfuscateConfig *config.Obfuscate,
+) remedies.RejectedRequestsExporter {
+	exporterType := config.ParseExporterType(
+		environment.GetRejectedRequestSamplesExporter())
+	if !isRawDataExporter(exporterType) {
+		log.Debug().Msg("Rejected request samples exporter not set, " +
+			"rejected requests are not sampled")
+		return nil
+	}
+	sampleRate, err := environment.GetRejectedRequestSamplesRate()
+	if err != nil || sampleRate <= 0 || sampleRate > 1 {
+		log.Debug().Msgf("Rejected request samples rate is not within (0, 1], "+
+			"using %v", defaultRejectedRequestSamplesRate)
+		sampleRate = defaultRejectedRequestSamplesRate
+	}
+	samplesPerMinute, err := environment.GetRejectedRequestSamplesPerMinute()
+	if err != nil || samplesPerMinute <= 0 {
+		samplesPerMinute = defaultRejectedRequestSamplesPerMinute
+	}
+	if obfuscateConfig == nil {
+		obfuscateConfig = &config.Obfuscate{Enabled: true} //nolint:exhaustruct
+	}
+	return exporters.NewRejectedRequestSamplesExporter(clock, rawDataExporter,
+		exporterType, obfuscator, *obfuscateConfig, sampleRate, samplesPerMinute)
+}
+
+func isRawDataExporter(exporterType config.ExporterType) bool {
+	switch exporterType { //nolint:exhaustive
+	case config.ExporterFile, config.ExporterS3, config.ExporterS3Minio:
+		return true
+	default:
+		return false
+	}
+}
+
+// ExportQueueSnapshot writes the requests waiting in the queues to the
+// raw data exporter configured for shutdown snapshots, if any.
+// The requests are only read, and are still drained with a decision.
+func ExportQueueSnapshot(
+	clock clock.Clock,
+	syslogWriter writers.Writer,
+	queuePlugin *remedies.StrategyBasedQueuePlugin,
+) {
+	rawExporter := environment.GetShutdownQueueSnapshotExporter()
+	if rawExporter == "" || queuePlugin == nil {
+		return
+	}
+	exporterType := config.ParseExporterType(rawExporter)
+	if !isRawDataExporter(exporterType) {
+		log.Warn().Msgf("Queue snapshot exporter %v is not a raw data exporter, "+
+			"queue snapshot is disabled", rawExporter)
+		return
+	}
+	snapshot := exporters.QueueSnapshot{
+		Timestamp: clock.Now(),
+		Queues:    queuePlugin.PendingQueues(),
+	}
+	err := exporters.WriteQueueSnapshot(syslogWriter, exporterType.Name(), snapshot)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to export queue snapshot")
+		return
+	}
+	log.Info().Msgf("Exported snapshot of %d queues with waiting requests",
+		len(snapshot.Queues))
+}
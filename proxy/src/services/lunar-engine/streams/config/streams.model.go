@@ -8,7 +8,8 @@ import (
 type FlowRepresentation struct {
 	Name       string               `yaml:"name"`
 	Filters    Filter               `yaml:"filters"`
-	Processors map[string]Processor `yaml:"processors"` // key (processor key)
+	Default    bool                 `yaml:"default,omitempty"` // handles streams no other flow selects
+	Processors map[string]Processor `yaml:"processors"`        // key (processor key)
 	Flow       Flow                 `yaml:"flow"`
 	Data       network.ConfigurationPayload
 }
@@ -52,6 +53,25 @@ type Filter struct {
 
 // This will assist in comparing the filters, we drop the name as it is not relevant for comparison.
 type Processor struct {
-	Processor  string                  `yaml:"processor"`
-	Parameters []*publictypes.KeyValue `yaml:"parameters,omitempty"`
+	Processor  string                   `yaml:"processor"`
+	Parameters []*publictypes.KeyValue  `yaml:"parameters,omitempty"`
+	Retry      *publictypes.RetryPolicy `yaml:"retry,omitempty"`
+	Parallel   *ParallelProcessors      `yaml:"parallel,omitempty"`
 }
+
+// ParallelProcessors executes processors of the flow concurrently,
+// joining their results before the flow proceeds
+type ParallelProcessors struct {
+	Processors      []string        `yaml:"processors"` // processor keys
+	ConditionPolicy ConditionPolicy `yaml:"condition_policy,omitempty"`
+	MaxConcurrency  int             `yaml:"max_concurrency,omitempty"`
+	TimeoutMillis   int             `yaml:"timeout_millis,omitempty"`
+}
+
+// ConditionPolicy determines whether parallel processors succeeded as a whole
+type ConditionPolicy string
+
+const (
+	ConditionPolicyAll ConditionPolicy = "all" // all processors succeeded
+	ConditionPolicyAny ConditionPolicy = "any" // any processor succeeded
+)
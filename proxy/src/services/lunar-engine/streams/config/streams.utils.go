@@ -159,6 +159,10 @@ func (p *Processor) GetName() string {
 	return p.Processor
 }
 
+func (p *Processor) GetRetryPolicy() *publictypes.RetryPolicy {
+	return p.Retry
+}
+
 func GetFlows() ([]*FlowRepresentation, error) {
 	var flows []*FlowRepresentation
 	flowsDir := environment.GetStreamsFlowsDirectory()
@@ -181,6 +185,9 @@ func GetFlows() ([]*FlowRepresentation, error) {
 		}
 		flows = append(flows, flow)
 	}
+	if err := validateFlowsSelection(flows); err != nil {
+		return nil, err
+	}
 	return flows, nil
 }
 
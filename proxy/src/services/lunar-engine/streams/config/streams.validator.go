@@ -21,6 +21,12 @@ func validateFlowRepresentation(flowRepresentation *FlowRepresentation) error {
 
 	for processorName, processor := range flowRepresentation.Processors {
 		processorValidationErr := validateProcessor(&processor)
+		if processor.Parallel != nil {
+			processorValidationErr = validateParallelProcessors(
+				processor.Parallel,
+				flowRepresentation.Processors,
+			)
+		}
 		if processorValidationErr != nil {
 			return fmt.Errorf("processor %s: %s", processorName, processorValidationErr)
 		}
@@ -51,6 +57,40 @@ func validateProcessor(processor publictypes.ProcessorDataI) error {
 	return nil
 }
 
+func validateParallelProcessors(
+	parallel *ParallelProcessors,
+	processors map[string]Processor,
+) error {
+	if len(parallel.Processors) == 0 {
+		return fmt.Errorf("parallel processors are required")
+	}
+	for _, processorKey := range parallel.Processors {
+		processor, found := processors[processorKey]
+		if !found {
+			return fmt.Errorf("parallel processor %s is not defined", processorKey)
+		}
+		if processor.Parallel != nil {
+			return fmt.Errorf("parallel processor %s cannot be parallel itself",
+				processorKey)
+		}
+	}
+
+	switch parallel.ConditionPolicy {
+	case "", ConditionPolicyAll, ConditionPolicyAny:
+	default:
+		return fmt.Errorf("condition policy %s is not supported",
+			parallel.ConditionPolicy)
+	}
+
+	if parallel.MaxConcurrency < 0 {
+		return fmt.Errorf("max concurrency cannot be negative")
+	}
+	if parallel.TimeoutMillis < 0 {
+		return fmt.Errorf("timeout cannot be negative")
+	}
+	return nil
+}
+
 func validateFilter(filter *Filter) error {
 	if filter.URL == "" {
 		return fmt.Errorf("filter url is required")
@@ -151,3 +191,34 @@ func validateProcessorRef(processorRef *ProcessorRef) error {
 
 	return nil
 }
+
+// validateFlowsSelection validates the loaded flows together,
+// so each stream is selected by a single flow
+func validateFlowsSelection(flowRepresentations []*FlowRepresentation) error {
+	flowNames := map[string]struct{}{}
+	selectors := map[publictypes.ComparableFilter]string{}
+	defaultFlow := ""
+	for _, flowRepresentation := range flowRepresentations {
+		if _, found := flowNames[flowRepresentation.Name]; found {
+			return fmt.Errorf("flow name %s is not unique", flowRepresentation.Name)
+		}
+		flowNames[flowRepresentation.Name] = struct{}{}
+
+		selector := flowRepresentation.Filters.ToComparable()
+		if otherFlow, found := selectors[selector]; found {
+			return fmt.Errorf("flows %s and %s have the same filters",
+				otherFlow, flowRepresentation.Name)
+		}
+		selectors[selector] = flowRepresentation.Name
+
+		if !flowRepresentation.Default {
+			continue
+		}
+		if defaultFlow != "" {
+			return fmt.Errorf("flows %s and %s are both set as the default flow",
+				defaultFlow, flowRepresentation.Name)
+		}
+		defaultFlow = flowRepresentation.Name
+	}
+	return nil
+}
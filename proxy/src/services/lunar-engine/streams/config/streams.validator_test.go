@@ -291,6 +291,65 @@ func TestMissingProcessorIdentifier(t *testing.T) {
 	}
 }
 
+func TestParallelProcessorsValidation(t *testing.T) {
+	testCases := []struct {
+		name        string
+		parallel    *ParallelProcessors
+		expectError bool
+	}{
+		{
+			name:        "Valid parallel processors",
+			parallel:    &ParallelProcessors{Processors: []string{"a", "b"}, ConditionPolicy: ConditionPolicyAny},
+			expectError: false,
+		},
+		{
+			name:        "Missing parallel processors",
+			parallel:    &ParallelProcessors{},
+			expectError: true,
+		},
+		{
+			name:        "Undefined parallel processor",
+			parallel:    &ParallelProcessors{Processors: []string{"a", "c"}},
+			expectError: true,
+		},
+		{
+			name:        "Nested parallel processors",
+			parallel:    &ParallelProcessors{Processors: []string{"a", "parallel"}},
+			expectError: true,
+		},
+		{
+			name:        "Unsupported condition policy",
+			parallel:    &ParallelProcessors{Processors: []string{"a"}, ConditionPolicy: "most"},
+			expectError: true,
+		},
+		{
+			name:        "Negative max concurrency",
+			parallel:    &ParallelProcessors{Processors: []string{"a"}, MaxConcurrency: -1},
+			expectError: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			flow := &FlowRepresentation{
+				Name:    "test",
+				Filters: Filter{Name: "test", URL: "test"},
+				Processors: map[string]Processor{
+					"a":        {Processor: "a"},
+					"b":        {Processor: "b"},
+					"parallel": {Parallel: testCase.parallel},
+				},
+			}
+			err := validateFlowRepresentation(flow)
+			if testCase.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
 func TestMissingStreamName(t *testing.T) {
 	flow := &FlowRepresentation{
 		Name: "test",
@@ -336,6 +395,41 @@ func TestMissingStreamName(t *testing.T) {
 	}
 }
 
+func TestFlowsSelectionValidation(t *testing.T) {
+	newFlow := func(name, url string, methods []string, isDefault bool) *FlowRepresentation {
+		return &FlowRepresentation{
+			Name:    name,
+			Filters: Filter{Name: name, URL: url, Method: methods},
+			Default: isDefault,
+		}
+	}
+
+	err := validateFlowsSelection([]*FlowRepresentation{
+		newFlow("get", "api.com/users", []string{"GET"}, false),
+		newFlow("post", "api.com/users", []string{"POST"}, false),
+		newFlow("fallback", "api.com/fallback", nil, true),
+	})
+	require.NoError(t, err)
+
+	err = validateFlowsSelection([]*FlowRepresentation{
+		newFlow("users", "api.com/users", nil, false),
+		newFlow("users", "api.com/orders", nil, false),
+	})
+	require.ErrorContains(t, err, "not unique")
+
+	err = validateFlowsSelection([]*FlowRepresentation{
+		newFlow("first", "api.com/users", []string{"GET"}, false),
+		newFlow("second", "api.com/users", []string{"GET"}, false),
+	})
+	require.ErrorContains(t, err, "same filters")
+
+	err = validateFlowsSelection([]*FlowRepresentation{
+		newFlow("first", "api.com/users", nil, true),
+		newFlow("second", "api.com/orders", nil, true),
+	})
+	require.ErrorContains(t, err, "default flow")
+}
+
 func TestParseYaml(t *testing.T) {
 	testCases := []struct {
 		name           string
@@ -3,6 +3,7 @@ package streamfilter
 import (
 	internal_types "lunar/engine/streams/internal-types"
 	publictypes "lunar/engine/streams/public-types"
+	"sort"
 )
 
 type FilterNode struct {
@@ -31,3 +32,44 @@ func (node *FilterNode) getFlow(apiStream publictypes.APIStreamI) internal_types
 
 	return node.flow
 }
+
+// specificity is the number of constraints the filter selects streams by,
+// beyond its URL
+func (node *FilterNode) specificity() int {
+	specificity := len(node.filter.GetAllowedHeaders()) +
+		len(node.filter.GetAllowedQueryParams())
+	if len(node.filter.GetAllowedMethods()) > 0 {
+		specificity++
+	}
+	if len(node.filter.GetAllowedStatusCodes()) > 0 {
+		specificity++
+	}
+	return specificity
+}
+
+// FilterCandidates are the filters sharing a URL, ordered by the precedence
+// their flows are selected in: the more specific filter first,
+// then by flow name
+type FilterCandidates []*FilterNode
+
+func (candidates *FilterCandidates) add(node *FilterNode) {
+	*candidates = append(*candidates, node)
+	sort.SliceStable(*candidates, func(i, j int) bool {
+		left, right := (*candidates)[i], (*candidates)[j]
+		if left.specificity() != right.specificity() {
+			return left.specificity() > right.specificity()
+		}
+		return left.flow.GetName() < right.flow.GetName()
+	})
+}
+
+func (candidates FilterCandidates) getFlow(
+	apiStream publictypes.APIStreamI,
+) internal_types.FlowI {
+	for _, node := range candidates {
+		if flow := node.getFlow(apiStream); flow != nil {
+			return flow
+		}
+	}
+	return nil
+}
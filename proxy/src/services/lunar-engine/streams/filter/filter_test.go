@@ -369,6 +369,122 @@ func TestFilterTreeGetRelevantFlowWithStatusCodeNoMatch(t *testing.T) {
 	}
 }
 
+func newSelectionTestStream(method, url string, headers map[string]string) publictypes.APIStreamI {
+	apiStream := streamtypes.NewAPIStream("APIStreamName", publictypes.StreamTypeRequest)
+	apiStream.SetRequest(streamtypes.NewRequest(messages.OnRequest{
+		Method:  method,
+		Scheme:  "https",
+		URL:     url,
+		Headers: headers,
+	}))
+	apiStream.SetContext(streamtypes.NewLunarContext(streamtypes.NewContext()))
+	return apiStream
+}
+
+func TestFilterTreeSelectsMostSpecificFlowOfSameURL(t *testing.T) {
+	urlOnlyFilter := createFilter("URLOnly", "api.google.com/path1", 0)
+	methodFilter := createFilter("Method", "api.google.com/path1", 0)
+	methodFilter.Method = []string{"POST"}
+	headerFilter := createFilter("Header", "api.google.com/path1", 0)
+	headerFilter.Method = []string{"POST"}
+	headerFilter.Headers = []publictypes.KeyValue{
+		*publictypes.NewKeyValue("x-tenant", "acme"),
+	}
+
+	urlOnlyFlow := streamflow.NewFlow(nil,
+		&streamconfig.FlowRepresentation{Name: "url-only", Filters: urlOnlyFilter}, nil)
+	methodFlow := streamflow.NewFlow(nil,
+		&streamconfig.FlowRepresentation{Name: "method", Filters: methodFilter}, nil)
+	headerFlow := streamflow.NewFlow(nil,
+		&streamconfig.FlowRepresentation{Name: "header", Filters: headerFilter}, nil)
+
+	filterTree := NewFilterTree()
+	// Added least specific first, so precedence does not follow load order
+	for _, flow := range []*streamflow.Flow{urlOnlyFlow, methodFlow, headerFlow} {
+		if err := filterTree.AddFlow(flow); err != nil {
+			t.Errorf("Expected %v, but got %v", nil, err)
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		method   string
+		headers  map[string]string
+		expected *streamflow.Flow
+	}{
+		{"header and method match", "POST", map[string]string{"x-tenant": "acme"}, headerFlow},
+		{"only method matches", "POST", map[string]string{"x-tenant": "other"}, methodFlow},
+		{"only URL matches", "GET", map[string]string{"x-tenant": "acme"}, urlOnlyFlow},
+	}
+	for _, testCase := range testCases {
+		apiStream := newSelectionTestStream(testCase.method, "api.google.com/path1",
+			testCase.headers)
+		result := filterTree.GetFlow(apiStream)
+		if result != testCase.expected {
+			t.Errorf("%s: expected flow %v, but got %v",
+				testCase.name, testCase.expected.GetName(), result)
+		}
+	}
+}
+
+func TestFilterTreeFallsBackToDefaultFlow(t *testing.T) {
+	postFilter := createFilter("Post", "api.google.com/path1", 0)
+	postFilter.Method = []string{"POST"}
+	defaultFilter := createFilter("Default", "api.google.com/default", 0)
+
+	postFlow := streamflow.NewFlow(nil,
+		&streamconfig.FlowRepresentation{Name: "post", Filters: postFilter}, nil)
+	defaultFlow := streamflow.NewFlow(nil, &streamconfig.FlowRepresentation{
+		Name:    "default",
+		Filters: defaultFilter,
+		Default: true,
+	}, nil)
+
+	filterTree := NewFilterTree()
+	for _, flow := range []*streamflow.Flow{postFlow, defaultFlow} {
+		if err := filterTree.AddFlow(flow); err != nil {
+			t.Errorf("Expected %v, but got %v", nil, err)
+		}
+	}
+
+	result := filterTree.GetFlow(newSelectionTestStream("POST", "api.google.com/path1", nil))
+	if result != postFlow {
+		t.Errorf("Expected %v, but got %v", postFlow, result)
+	}
+
+	// Same URL but unmatched method, and an unknown URL, both fall through
+	for _, apiStream := range []publictypes.APIStreamI{
+		newSelectionTestStream("GET", "api.google.com/path1", nil),
+		newSelectionTestStream("GET", "api.other.com/path", nil),
+	} {
+		result = filterTree.GetFlow(apiStream)
+		if result != defaultFlow {
+			t.Errorf("Expected %v, but got %v", defaultFlow, result)
+		}
+	}
+}
+
+func TestFilterTreeRejectsSecondDefaultFlow(t *testing.T) {
+	filterTree := NewFilterTree()
+	first := streamflow.NewFlow(nil, &streamconfig.FlowRepresentation{
+		Name:    "first",
+		Filters: createFilter("First", "api.google.com/first", 0),
+		Default: true,
+	}, nil)
+	second := streamflow.NewFlow(nil, &streamconfig.FlowRepresentation{
+		Name:    "second",
+		Filters: createFilter("Second", "api.google.com/second", 0),
+		Default: true,
+	}, nil)
+
+	if err := filterTree.AddFlow(first); err != nil {
+		t.Errorf("Expected %v, but got %v", nil, err)
+	}
+	if err := filterTree.AddFlow(second); err == nil {
+		t.Errorf("Expected an error adding a second default flow")
+	}
+}
+
 func createFilter(name, url string, statusCode int) streamconfig.Filter {
 	filter := streamconfig.Filter{
 		Name:        name,
@@ -1,6 +1,7 @@
 package streamfilter
 
 import (
+	"fmt"
 	internal_types "lunar/engine/streams/internal-types"
 	publictypes "lunar/engine/streams/public-types"
 	"lunar/toolkit-core/urltree"
@@ -12,32 +13,65 @@ import (
 var _ internal_types.FilterTreeI = &FilterTree{}
 
 type FilterTree struct {
-	tree *urltree.URLTree[FilterNode]
+	tree        *urltree.URLTree[FilterCandidates]
+	candidates  map[string]*FilterCandidates // key (filter URL)
+	defaultFlow internal_types.FlowI
 }
 
 func NewFilterTree() internal_types.FilterTreeI {
 	return &FilterTree{
-		tree: urltree.NewURLTree[FilterNode](false, 0),
+		tree:        urltree.NewURLTree[FilterCandidates](false, 0),
+		candidates:  map[string]*FilterCandidates{},
+		defaultFlow: nil,
 	}
 }
 
-// Add a flow with specified filter to the filter tree
+// Add a flow with specified filter to the filter tree,
+// flows sharing the URL of their filter are selected by precedence
 func (f *FilterTree) AddFlow(flow internal_types.FlowI) error {
+	if flow.IsDefault() {
+		if f.defaultFlow != nil && f.defaultFlow.GetName() != flow.GetName() {
+			return fmt.Errorf("flows %s and %s are both set as the default flow",
+				f.defaultFlow.GetName(), flow.GetName())
+		}
+		f.defaultFlow = flow
+	}
+
 	filter := flow.GetFilter()
-	return f.tree.Insert(filter.GetURL(), &FilterNode{
+	node := &FilterNode{
 		filter: &filter,
 		flow:   flow,
-	})
+	}
+	if candidates, found := f.candidates[filter.GetURL()]; found {
+		candidates.add(node)
+		return nil
+	}
+
+	candidates := &FilterCandidates{}
+	candidates.add(node)
+	if err := f.tree.Insert(filter.GetURL(), candidates); err != nil {
+		return err
+	}
+	f.candidates[filter.GetURL()] = candidates
+	return nil
 }
 
-// Get flow based on the API stream
+// Get flow based on the API stream, falling back to the default flow
+// if no flow selects the stream
 func (f *FilterTree) GetFlow(APIStream publictypes.APIStreamI) internal_types.FlowI {
 	url := APIStream.GetURL()
 	lookupResult := f.tree.Lookup(url)
-	if lookupResult.Value == nil {
-		log.Trace().Msgf("No filter found for %v", url)
-		return nil
+	if lookupResult.Value != nil {
+		if flow := lookupResult.Value.getFlow(APIStream); flow != nil {
+			return flow
+		}
+	}
+
+	if f.defaultFlow != nil {
+		log.Trace().Msgf("No flow selected %v, using default flow %v",
+			url, f.defaultFlow.GetName())
+		return f.defaultFlow
 	}
-	filterNode := *lookupResult.Value
-	return filterNode.getFlow(APIStream)
+	log.Trace().Msgf("No filter found for %v", url)
+	return nil
 }
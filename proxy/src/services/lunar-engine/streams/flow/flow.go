@@ -46,6 +46,11 @@ func (fl *Flow) GetFilter() streamconfig.Filter {
 	return fl.flowRep.Filters
 }
 
+// IsDefault returns whether the flow handles streams no other flow selects.
+func (fl *Flow) IsDefault() bool {
+	return fl.flowRep.Default
+}
+
 // GetName returns the name of the flow.
 func (fl *Flow) GetName() string {
 	return fl.flowRep.Name
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	streamconfig "lunar/engine/streams/config"
 	"lunar/engine/streams/processors"
+	streamtypes "lunar/engine/streams/types"
 )
 
 type graphNodeBuilder struct {
@@ -32,7 +33,14 @@ func (fgb *graphNodeBuilder) buildNode(flowRepName, processorKey string) (*FlowG
 		return nil, fmt.Errorf("processor %s not found in flow %s", processorKey, flowRepName)
 	}
 
-	proc, err := fgb.processorManager.CreateProcessor(&procConf)
+	var proc streamtypes.Processor
+	var err error
+	if procConf.Parallel != nil {
+		proc, err = fgb.processorManager.CreateParallelProcessor(
+			processorKey, procConf.Parallel, flowRep.Processors)
+	} else {
+		proc, err = fgb.processorManager.CreateProcessor(&procConf)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create processor %s: %w", processorKey, err)
 	}
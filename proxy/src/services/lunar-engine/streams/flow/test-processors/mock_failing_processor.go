@@ -0,0 +1,30 @@
+package testprocessors
+
+import (
+	"errors"
+	publictypes "lunar/engine/streams/public-types"
+	streamtypes "lunar/engine/streams/types"
+	"net/http"
+)
+
+const FailingProcessorMessage = `quota store "main" is unavailable`
+
+func NewMockFailingProcessor(metadata *streamtypes.ProcessorMetaData) (streamtypes.Processor, error) { //nolint:lll
+	return &MockFailingProcessor{Name: metadata.Name, Metadata: metadata}, nil
+}
+
+// MockFailingProcessor always fails, with a service unavailable code
+type MockFailingProcessor struct {
+	Name     string
+	Metadata *streamtypes.ProcessorMetaData
+}
+
+func (p *MockFailingProcessor) Execute(apiStream publictypes.APIStreamI) (streamtypes.ProcessorIO, error) { //nolint:lll
+	signInExecution(apiStream, p.Name)
+	return streamtypes.ProcessorIO{}, streamtypes.NewCodedError(
+		http.StatusServiceUnavailable, errors.New(FailingProcessorMessage))
+}
+
+func (p *MockFailingProcessor) GetName() string {
+	return p.Name
+}
@@ -30,10 +30,11 @@ func (p *MockProcessor) GetName() string {
 }
 
 func signInExecution(apiStream publictypes.APIStreamI, name string) {
-	outVal, _ := apiStream.GetContext().GetGlobalContext().
-		Get(GlobalKeyExecutionOrder)
-
-	execOrder, _ := outVal.([]string)
-	execOrder = append(execOrder, name)
-	apiStream.GetContext().GetGlobalContext().Set(GlobalKeyExecutionOrder, execOrder) //nolint:errcheck
+	apiStream.GetContext().GetGlobalContext().Update( //nolint:errcheck
+		GlobalKeyExecutionOrder,
+		func(outVal interface{}) interface{} {
+			execOrder, _ := outVal.([]string)
+			return append(execOrder, name)
+		},
+	)
 }
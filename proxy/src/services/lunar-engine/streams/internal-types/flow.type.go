@@ -21,6 +21,7 @@ type FlowDirectionI interface {
 type FlowI interface {
 	GetFilter() streamconfig.Filter
 	GetName() string
+	IsDefault() bool
 
 	GetExecutionContext() publictypes.LunarContextI
 	GetResourceManagement() publictypes.ResourceManagementI
@@ -0,0 +1,318 @@
+package processorcompress
+
+import (
+	"fmt"
+	"lunar/engine/actions"
+	"lunar/engine/streams/processors/utils"
+	publictypes "lunar/engine/streams/public-types"
+	streamtypes "lunar/engine/streams/types"
+	"lunar/engine/utils/compression"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	CompressedConditionName = "compressed"
+	SkippedConditionName    = "skipped"
+
+	AlgorithmsParam       = "algorithms"
+	MinSizeBytesParam     = "min_size_bytes"
+	SkipContentTypesParam = "skip_content_types"
+
+	// Feature flags operators toggle compression with at runtime,
+	// the min size flag overriding the min size parameter
+	EnabledFlag      = "compress.enabled"
+	MinSizeBytesFlag = "compress.min_size_bytes"
+
+	GZipAlgorithm   = "gzip"
+	BrotliAlgorithm = "br"
+
+	defaultMinSizeBytes = 1024
+
+	acceptEncodingHeader   = "Accept-Encoding"
+	cacheControlHeader     = "Cache-Control"
+	contentEncodingHeader  = "Content-Encoding"
+	contentLengthHeader    = "Content-Length"
+	contentTypeHeader      = "Content-Type"
+	transferEncodingHeader = "Transfer-Encoding"
+	varyHeader             = "Vary"
+)
+
+var (
+	defaultAlgorithms = []string{BrotliAlgorithm, GZipAlgorithm}
+	// Content types which are compressed already, so compressing them
+	// again only costs CPU time
+	defaultSkipContentTypes = []string{
+		"image/",
+		"video/",
+		"audio/",
+		"font/woff",
+		"application/zip",
+		"application/gzip",
+		"application/x-gzip",
+		"application/x-bzip2",
+		"application/x-7z-compressed",
+		"application/x-rar-compressed",
+		"application/pdf",
+	}
+	compressors = map[string]func(string) (string, error){
+		GZipAlgorithm:   compression.CompressGZip,
+		BrotliAlgorithm: compression.CompressBrotli,
+	}
+)
+
+type compressProcessor struct {
+	name             string
+	algorithms       []string
+	minSizeBytes     int
+	skipContentTypes []string
+	metaData         *streamtypes.ProcessorMetaData
+}
+
+func NewProcessor(
+	metaData *streamtypes.ProcessorMetaData,
+) (streamtypes.Processor, error) {
+	proc := &compressProcessor{
+		name:     metaData.Name,
+		metaData: metaData,
+	}
+
+	if err := proc.init(); err != nil {
+		return nil, err
+	}
+
+	return proc, nil
+}
+
+func (p *compressProcessor) GetName() string {
+	return p.name
+}
+
+func (p *compressProcessor) Execute(
+	apiStream publictypes.APIStreamI,
+) (streamtypes.ProcessorIO, error) {
+	if !apiStream.GetType().IsResponseType() {
+		return streamtypes.ProcessorIO{}, fmt.Errorf(
+			"invalid stream type: %s", apiStream.GetType())
+	}
+
+	if p.metaData.FeatureFlags != nil &&
+		!p.metaData.FeatureFlags.GetBool(EnabledFlag, true) {
+		log.Trace().Msgf("%v skipped compression of %v: disabled by feature flag",
+			p.name, apiStream.GetURL())
+		return skippedIO(), nil
+	}
+
+	algorithm, reason := p.selectAlgorithm(apiStream)
+	if algorithm == "" {
+		log.Trace().Msgf("%v skipped compression of %v: %v",
+			p.name, apiStream.GetURL(), reason)
+		return skippedIO(), nil
+	}
+
+	body := apiStream.GetBody()
+	compressed, err := compressors[algorithm](body)
+	if err != nil {
+		log.Warn().Err(err).Msgf("%v failed to compress response with %v",
+			p.name, algorithm)
+		return skippedIO(), nil
+	}
+	if len(compressed) >= len(body) {
+		log.Trace().Msgf("%v skipped compression of %v: %v did not reduce size",
+			p.name, apiStream.GetURL(), algorithm)
+		return skippedIO(), nil
+	}
+
+	vary, _ := getHeader(apiStream.GetHeaders(), varyHeader)
+	return streamtypes.ProcessorIO{
+		Type: publictypes.StreamTypeResponse,
+		RespAction: &actions.ModifyResponseAction{
+			HeadersToSet: map[string]string{
+				contentEncodingHeader: algorithm,
+				contentLengthHeader:   strconv.Itoa(len(compressed)),
+				varyHeader:            withAcceptEncodingVary(vary),
+			},
+			Body: &compressed,
+		},
+		Name: CompressedConditionName,
+	}, nil
+}
+
+func (p *compressProcessor) init() error {
+	if err := utils.ExtractListOfStringParam(p.metaData.Parameters,
+		AlgorithmsParam,
+		&p.algorithms); err != nil {
+		log.Trace().Msgf("algorithms not defined for %v", p.metaData.Name)
+	}
+	if len(p.algorithms) == 0 {
+		p.algorithms = append([]string{}, defaultAlgorithms...)
+	}
+	for index, algorithm := range p.algorithms {
+		algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+		if _, found := compressors[algorithm]; !found {
+			return fmt.Errorf("unsupported compression algorithm %v for %v",
+				algorithm, p.metaData.Name)
+		}
+		p.algorithms[index] = algorithm
+	}
+
+	p.minSizeBytes = defaultMinSizeBytes
+	if err := utils.ExtractIntParam(p.metaData.Parameters,
+		MinSizeBytesParam,
+		&p.minSizeBytes); err != nil {
+		log.Trace().Msgf("min size not defined for %v", p.metaData.Name)
+	}
+	if p.minSizeBytes < 0 {
+		return fmt.Errorf("min size must not be negative for %v", p.metaData.Name)
+	}
+
+	if err := utils.ExtractListOfStringParam(p.metaData.Parameters,
+		SkipContentTypesParam,
+		&p.skipContentTypes); err != nil {
+		log.Trace().Msgf("skip content types not defined for %v", p.metaData.Name)
+	}
+	if len(p.skipContentTypes) == 0 {
+		p.skipContentTypes = append([]string{}, defaultSkipContentTypes...)
+	}
+	return nil
+}
+
+// getMinSizeBytes returns the min size of compressed bodies,
+// as overridden by the feature flag if it is set to a valid size
+func (p *compressProcessor) getMinSizeBytes() int {
+	if p.metaData.FeatureFlags == nil {
+		return p.minSizeBytes
+	}
+	minSizeBytes := p.metaData.FeatureFlags.GetInt(MinSizeBytesFlag, p.minSizeBytes)
+	if minSizeBytes < 0 {
+		return p.minSizeBytes
+	}
+	return minSizeBytes
+}
+
+// selectAlgorithm returns the preferred algorithm the response
+// should be compressed with, or the reason it should not be compressed
+func (p *compressProcessor) selectAlgorithm(
+	apiStream publictypes.APIStreamI,
+) (string, string) {
+	headers := apiStream.GetHeaders()
+	if encoding, found := getHeader(headers, contentEncodingHeader); found &&
+		!strings.EqualFold(strings.TrimSpace(encoding), "identity") {
+		return "", "response is already encoded"
+	}
+	if cacheControl, found := getHeader(headers, cacheControlHeader); found &&
+		strings.Contains(strings.ToLower(cacheControl), "no-transform") {
+		return "", "response does not allow transformations"
+	}
+	contentType, _ := getHeader(headers, contentTypeHeader)
+	if p.isSkippedContentType(contentType) {
+		return "", fmt.Sprintf("content type %v is skipped", contentType)
+	}
+	bodySize := len(apiStream.GetBody())
+	minSizeBytes := p.getMinSizeBytes()
+	if bodySize == 0 || bodySize < minSizeBytes {
+		return "", fmt.Sprintf("body size %d is below %d bytes",
+			bodySize, minSizeBytes)
+	}
+	// The body is received only up to HAProxy's buffer size, so unless its
+	// full length is known and received, it cannot be safely replaced
+	if _, found := getHeader(headers, transferEncodingHeader); found {
+		return "", "response has a transfer encoding"
+	}
+	contentLength, found := getHeader(headers, contentLengthHeader)
+	if !found {
+		return "", "response has no content length"
+	}
+	if strings.TrimSpace(contentLength) != strconv.Itoa(bodySize) {
+		return "", fmt.Sprintf("body was received partially (%d of %v bytes)",
+			bodySize, contentLength)
+	}
+
+	accepted := parseAcceptEncoding(apiStream.GetAcceptEncoding())
+	for _, algorithm := range p.algorithms {
+		if isAccepted(accepted, algorithm) {
+			return algorithm, ""
+		}
+	}
+	return "", "client does not accept any of the configured algorithms"
+}
+
+func (p *compressProcessor) isSkippedContentType(contentType string) bool {
+	mediaType := strings.ToLower(
+		strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if mediaType == "" {
+		return false
+	}
+	for _, skipped := range p.skipContentTypes {
+		if strings.HasPrefix(mediaType, strings.ToLower(skipped)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAcceptEncoding parses an Accept-Encoding header value
+// (e.g. `gzip;q=0.8, br`) into its encodings and their quality values
+func parseAcceptEncoding(acceptEncoding string) map[string]float64 {
+	accepted := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		encoding, params, _ := strings.Cut(part, ";")
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if encoding == "" {
+			continue
+		}
+		quality := 1.0
+		if rawQuality, found := strings.CutPrefix(
+			strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(rawQuality, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		accepted[encoding] = quality
+	}
+	return accepted
+}
+
+func isAccepted(accepted map[string]float64, algorithm string) bool {
+	if quality, found := accepted[algorithm]; found {
+		return quality > 0
+	}
+	quality, found := accepted["*"]
+	return found && quality > 0
+}
+
+// withAcceptEncodingVary adds Accept-Encoding to the given Vary header value,
+// so caches will not serve the compressed response to other clients
+func withAcceptEncodingVary(vary string) string {
+	if strings.TrimSpace(vary) == "" {
+		return acceptEncodingHeader
+	}
+	for _, value := range strings.Split(vary, ",") {
+		value = strings.TrimSpace(value)
+		if value == "*" || strings.EqualFold(value, acceptEncodingHeader) {
+			return vary
+		}
+	}
+	return vary + ", " + acceptEncodingHeader
+}
+
+func getHeader(headers map[string]string, name string) (string, bool) {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func skippedIO() streamtypes.ProcessorIO {
+	return streamtypes.ProcessorIO{
+		Type:       publictypes.StreamTypeResponse,
+		RespAction: &actions.NoOpAction{},
+		Name:       SkippedConditionName,
+	}
+}
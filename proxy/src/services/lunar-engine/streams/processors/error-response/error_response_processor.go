@@ -0,0 +1,121 @@
+package processorerrorresponse
+
+import (
+	"fmt"
+	"lunar/engine/actions"
+	"lunar/engine/streams/processors/utils"
+	publictypes "lunar/engine/streams/public-types"
+	streamtypes "lunar/engine/streams/types"
+	sharedConfig "lunar/shared-model/config"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	statusParam = "status"
+	bodyParam   = "body"
+
+	processorVariable    = "processor"
+	errorMessageVariable = "error_message"
+	errorCodeVariable    = "error_code"
+	requestIDVariable    = "request_id"
+
+	defaultBody = `{"error": "{{error_message}}", "processor": "{{processor}}"}`
+)
+
+// errorResponseProcessor responds with the latest error a processor failed
+// with on the transaction, formatted by an error template. The template
+// may reference the variables {{processor}}, {{error_message}},
+// {{error_code}} and {{request_id}}. Without a status code, the code of the
+// error is used.
+type errorResponseProcessor struct {
+	name     string
+	template sharedConfig.ErrorTemplate
+	metaData *streamtypes.ProcessorMetaData
+}
+
+func NewProcessor(
+	metaData *streamtypes.ProcessorMetaData,
+) (streamtypes.Processor, error) {
+	proc := &errorResponseProcessor{
+		name:     metaData.Name,
+		metaData: metaData,
+		template: sharedConfig.ErrorTemplate{
+			StatusCode: 0,
+			Headers:    make(map[string]string),
+			Body:       defaultBody,
+		},
+	}
+
+	// status code
+	if err := utils.ExtractIntParam(metaData.Parameters,
+		statusParam,
+		&proc.template.StatusCode); err != nil {
+		log.Trace().Err(err).Msgf("status code not defined for %v", metaData.Name)
+	}
+
+	// body
+	if err := utils.ExtractStrParam(metaData.Parameters,
+		bodyParam,
+		&proc.template.Body); err != nil {
+		log.Trace().Err(err).Msgf("body not defined for %v", metaData.Name)
+	}
+
+	return proc, nil
+}
+
+func (p *errorResponseProcessor) GetName() string {
+	return p.name
+}
+
+func (p *errorResponseProcessor) Execute(
+	apiStream publictypes.APIStreamI,
+) (streamtypes.ProcessorIO, error) {
+	if apiStream.GetType() == publictypes.StreamTypeRequest {
+		return p.onRequest(apiStream)
+	} else if apiStream.GetType() == publictypes.StreamTypeResponse {
+		return p.onResponse(apiStream)
+	}
+	return streamtypes.ProcessorIO{}, fmt.Errorf("invalid stream type: %s", apiStream.GetType())
+}
+
+func (p *errorResponseProcessor) onRequest(
+	apiStream publictypes.APIStreamI,
+) (streamtypes.ProcessorIO, error) {
+	processorError, found := streamtypes.LatestProcessorError(apiStream)
+	if !found {
+		log.Debug().Msgf("%s found no processor error of %s, responding with %d",
+			p.name, apiStream.GetID(), streamtypes.DefaultProcessorErrorCode)
+		processorError.Code = streamtypes.DefaultProcessorErrorCode
+	}
+
+	statusCode, headers, body := p.template.Render(processorError.Code,
+		map[string]string{
+			processorVariable:    processorError.Processor,
+			errorMessageVariable: processorError.Message,
+			errorCodeVariable:    strconv.Itoa(processorError.Code),
+			requestIDVariable:    apiStream.GetID(),
+		})
+
+	var action actions.ReqLunarAction = &actions.EarlyResponseAction{
+		Status:  statusCode,
+		Body:    body,
+		Headers: headers,
+	}
+	return streamtypes.ProcessorIO{
+		Type:      publictypes.StreamTypeResponse,
+		ReqAction: action,
+		Name:      "",
+	}, nil
+}
+
+func (p *errorResponseProcessor) onResponse(
+	_ publictypes.APIStreamI,
+) (streamtypes.ProcessorIO, error) {
+	return streamtypes.ProcessorIO{
+		Type:       publictypes.StreamTypeResponse,
+		RespAction: &actions.NoOpAction{},
+		Name:       "",
+	}, nil
+}
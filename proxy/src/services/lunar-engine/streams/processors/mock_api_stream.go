@@ -3,6 +3,7 @@ package processors
 import publictypes "lunar/engine/streams/public-types"
 
 type mockAPIStream struct {
+	id         string
 	url        string
 	method     string
 	body       string
@@ -11,6 +12,9 @@ type mockAPIStream struct {
 	context    publictypes.LunarContextI
 	request    publictypes.TransactionI
 	response   publictypes.TransactionI
+	upstream   *publictypes.UpstreamTarget
+	// acceptEncoding of the request, used by response streams
+	acceptEncoding string
 }
 
 func (m *mockAPIStream) WithLunarContext(context publictypes.LunarContextI) publictypes.APIStreamI {
@@ -64,7 +68,7 @@ func (m *mockAPIStream) SetContext(context publictypes.LunarContextI) {
 }
 
 func (m *mockAPIStream) GetID() string {
-	return ""
+	return m.id
 }
 
 func (m *mockAPIStream) GetName() string {
@@ -82,3 +86,15 @@ func (m *mockAPIStream) SetRequest(request publictypes.TransactionI) {
 func (m *mockAPIStream) GetResponse() publictypes.TransactionI {
 	return nil
 }
+
+func (m *mockAPIStream) GetUpstreamTarget() *publictypes.UpstreamTarget {
+	return m.upstream
+}
+
+func (m *mockAPIStream) SetUpstreamTarget(target *publictypes.UpstreamTarget) {
+	m.upstream = target
+}
+
+func (m *mockAPIStream) GetAcceptEncoding() string {
+	return m.acceptEncoding
+}
@@ -0,0 +1,217 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	streamconfig "lunar/engine/streams/config"
+	publictypes "lunar/engine/streams/public-types"
+	streamtypes "lunar/engine/streams/types"
+	contextmanager "lunar/toolkit-core/context-manager"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const defaultParallelMaxConcurrency = 4
+
+var errBranchTimeout = errors.New("parallel processor timed out")
+
+// parallelProcessor executes its branches concurrently and joins their results.
+// The branches share the API stream, so they may read it and write to its
+// (synchronized) contexts, but should not modify the request or response.
+type parallelProcessor struct {
+	name            string
+	ctx             context.Context
+	branches        []streamtypes.Processor
+	conditionPolicy streamconfig.ConditionPolicy
+	maxConcurrency  int
+	timeout         time.Duration
+}
+
+type branchResult struct {
+	procIO streamtypes.ProcessorIO
+	err    error
+}
+
+// CreateParallelProcessor creates a processor executing the given processors
+// of the flow concurrently
+func (pm *ProcessorManager) CreateParallelProcessor(
+	processorKey string,
+	parallel *streamconfig.ParallelProcessors,
+	procConfs map[string]streamconfig.Processor,
+) (streamtypes.Processor, error) {
+	log.Debug().Msgf("Creating parallel processor %s", processorKey)
+	var branches []streamtypes.Processor
+	for _, branchKey := range parallel.Processors {
+		procConf, found := procConfs[branchKey]
+		if !found {
+			return nil, fmt.Errorf("parallel processor %s not found", branchKey)
+		}
+		branch, err := pm.CreateProcessor(&procConf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create parallel processor %s: %w",
+				branchKey, err)
+		}
+		branches = append(branches, branch)
+	}
+	return newParallelProcessor(
+		contextmanager.Get().GetContext(), processorKey, parallel, branches), nil
+}
+
+func newParallelProcessor(
+	ctx context.Context,
+	name string,
+	parallel *streamconfig.ParallelProcessors,
+	branches []streamtypes.Processor,
+) *parallelProcessor {
+	conditionPolicy := parallel.ConditionPolicy
+	if conditionPolicy == "" {
+		conditionPolicy = streamconfig.ConditionPolicyAll
+	}
+	maxConcurrency := parallel.MaxConcurrency
+	if maxConcurrency == 0 {
+		maxConcurrency = defaultParallelMaxConcurrency
+	}
+	if maxConcurrency > len(branches) {
+		maxConcurrency = len(branches)
+	}
+	return &parallelProcessor{
+		name:            name,
+		ctx:             ctx,
+		branches:        branches,
+		conditionPolicy: conditionPolicy,
+		maxConcurrency:  maxConcurrency,
+		timeout:         time.Duration(parallel.TimeoutMillis) * time.Millisecond,
+	}
+}
+
+func (p *parallelProcessor) GetName() string {
+	return p.name
+}
+
+// Execute fans the branches out, at most maxConcurrency at a time.
+// A branch which does not complete within the timeout is considered failed,
+// it keeps running in the background but its result is ignored.
+func (p *parallelProcessor) Execute(
+	apiStream publictypes.APIStreamI,
+) (streamtypes.ProcessorIO, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if p.timeout > 0 {
+		ctx, cancel = context.WithTimeout(p.ctx, p.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(p.ctx)
+	}
+	defer cancel()
+
+	slots := make(chan struct{}, p.maxConcurrency)
+	results := make([]branchResult, len(p.branches))
+	var waitGroup sync.WaitGroup
+	for i, branch := range p.branches {
+		waitGroup.Add(1)
+		go func(i int, branch streamtypes.Processor) {
+			defer waitGroup.Done()
+			results[i] = executeBranch(ctx, slots, branch, apiStream)
+		}(i, branch)
+	}
+	waitGroup.Wait()
+
+	return p.join(apiStream, results), nil
+}
+
+func executeBranch(
+	ctx context.Context,
+	slots chan struct{},
+	branch streamtypes.Processor,
+	apiStream publictypes.APIStreamI,
+) branchResult {
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return branchResult{err: contextError(ctx)}
+	}
+
+	done := make(chan branchResult, 1)
+	go func() {
+		defer func() { <-slots }()
+		procIO, err := branch.Execute(apiStream)
+		done <- branchResult{procIO: procIO, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		return result
+	case <-ctx.Done():
+		return branchResult{err: contextError(ctx)}
+	}
+}
+
+func contextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errBranchTimeout
+	}
+	return ctx.Err()
+}
+
+// join combines the results of the branches according to the condition policy.
+// On success the condition is the one all succeeded branches agree on
+// (none otherwise), and the first action of the branches (in their declared
+// order) is taken. On failure the error condition is returned, the errors of
+// the failed branches being recorded for the flow to handle them.
+func (p *parallelProcessor) join(
+	apiStream publictypes.APIStreamI,
+	results []branchResult,
+) streamtypes.ProcessorIO {
+	succeeded := 0
+	var condition *string
+	conditionsAgree := true
+	joined := streamtypes.ProcessorIO{Type: publictypes.StreamTypeAny}
+	actionTaken := false
+	for i, result := range results {
+		if result.err != nil {
+			log.Debug().Err(result.err).Msgf("Parallel processor %s of %s failed",
+				p.branches[i].GetName(), p.name)
+			continue
+		}
+		succeeded++
+		if condition == nil {
+			condition = &results[i].procIO.Name
+		} else if *condition != result.procIO.Name {
+			conditionsAgree = false
+		}
+		if !actionTaken && (result.procIO.IsRequestActionAvailable() ||
+			result.procIO.IsResponseActionAvailable()) {
+			joined.Type = result.procIO.Type
+			joined.ReqAction = result.procIO.ReqAction
+			joined.RespAction = result.procIO.RespAction
+			actionTaken = true
+		}
+	}
+
+	if !p.hasSucceeded(succeeded, len(results)) {
+		log.Debug().Msgf("%d of %d parallel processors of %s succeeded",
+			succeeded, len(results), p.name)
+		for i, result := range results {
+			if result.err != nil {
+				streamtypes.RecordProcessorError(apiStream, p.branches[i].GetName(), result.err)
+			}
+		}
+		return streamtypes.ProcessorIO{
+			Name: ErrorConditionName,
+			Type: publictypes.StreamTypeAny,
+		}
+	}
+	if condition != nil && conditionsAgree {
+		joined.Name = *condition
+	}
+	return joined
+}
+
+func (p *parallelProcessor) hasSucceeded(succeeded, total int) bool {
+	if p.conditionPolicy == streamconfig.ConditionPolicyAny {
+		return succeeded > 0
+	}
+	return succeeded == total
+}
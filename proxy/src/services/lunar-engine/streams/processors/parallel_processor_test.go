@@ -0,0 +1,292 @@
+package processors
+
+import (
+	"context"
+	"lunar/engine/actions"
+	streamconfig "lunar/engine/streams/config"
+	publictypes "lunar/engine/streams/public-types"
+	streamtypes "lunar/engine/streams/types"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const globalKeyBranches = "branches"
+
+// branchProcessor signs in the global context and returns its condition,
+// or fails, or blocks until released
+type branchProcessor struct {
+	name      string
+	condition string
+	err       error
+	release   chan struct{}
+	action    actions.ReqLunarAction
+	tracker   *concurrencyTracker
+}
+
+func (p *branchProcessor) Execute(
+	apiStream publictypes.APIStreamI,
+) (streamtypes.ProcessorIO, error) {
+	if p.tracker != nil {
+		p.tracker.start()
+		defer p.tracker.end()
+	}
+	if p.release != nil {
+		<-p.release
+	}
+	if p.err != nil {
+		return streamtypes.ProcessorIO{}, p.err
+	}
+	apiStream.GetContext().GetGlobalContext().Update( //nolint:errcheck
+		globalKeyBranches,
+		func(value interface{}) interface{} {
+			branches, _ := value.([]string)
+			return append(branches, p.name)
+		},
+	)
+	return streamtypes.ProcessorIO{
+		Name:      p.condition,
+		Type:      publictypes.StreamTypeRequest,
+		ReqAction: p.action,
+	}, nil
+}
+
+func (p *branchProcessor) GetName() string {
+	return p.name
+}
+
+// concurrencyTracker records the peak number of branches executed at once
+type concurrencyTracker struct {
+	mutex   sync.Mutex
+	running int
+	peak    int
+}
+
+func (tracker *concurrencyTracker) start() {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.running++
+	if tracker.running > tracker.peak {
+		tracker.peak = tracker.running
+	}
+}
+
+func (tracker *concurrencyTracker) end() {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.running--
+}
+
+func (tracker *concurrencyTracker) peakConcurrency() int {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	return tracker.peak
+}
+
+func newParallelTestAPIStream() *mockAPIStream {
+	return &mockAPIStream{
+		streamType: publictypes.StreamTypeRequest,
+		context:    streamtypes.NewLunarContext(streamtypes.NewContext()),
+	}
+}
+
+func signedBranches(t *testing.T, apiStream *mockAPIStream) []string {
+	value, err := apiStream.GetContext().GetGlobalContext().Get(globalKeyBranches)
+	require.NoError(t, err)
+	branches, _ := value.([]string)
+	return branches
+}
+
+func TestParallelProcessorExecutesBranchesConcurrently(t *testing.T) {
+	// Each branch is only released once all branches started
+	var started sync.WaitGroup
+	release := make(chan struct{})
+	var branches []streamtypes.Processor
+	for i := 0; i < 3; i++ {
+		started.Add(1)
+		branches = append(branches, &startSignalingProcessor{
+			branchProcessor: branchProcessor{name: strconv.Itoa(i), release: release},
+			started:         &started,
+		})
+	}
+	go func() {
+		started.Wait()
+		close(release)
+	}()
+	processor := newParallelProcessor(context.Background(), "Parallel",
+		&streamconfig.ParallelProcessors{TimeoutMillis: 1000}, branches)
+	apiStream := newParallelTestAPIStream()
+
+	procIO, err := processor.Execute(apiStream)
+
+	require.NoError(t, err)
+	require.Equal(t, "", procIO.Name)
+	require.ElementsMatch(t, []string{"0", "1", "2"}, signedBranches(t, apiStream))
+}
+
+type startSignalingProcessor struct {
+	branchProcessor
+	started *sync.WaitGroup
+}
+
+func (p *startSignalingProcessor) Execute(
+	apiStream publictypes.APIStreamI,
+) (streamtypes.ProcessorIO, error) {
+	p.started.Done()
+	return p.branchProcessor.Execute(apiStream)
+}
+
+func TestParallelProcessorBoundsConcurrency(t *testing.T) {
+	tracker := &concurrencyTracker{}
+	release := make(chan struct{})
+	var branches []streamtypes.Processor
+	for i := 0; i < 6; i++ {
+		branches = append(branches, &branchProcessor{
+			name: strconv.Itoa(i), release: release, tracker: tracker,
+		})
+	}
+	go func() {
+		for range branches {
+			release <- struct{}{}
+		}
+	}()
+	processor := newParallelProcessor(context.Background(), "Parallel",
+		&streamconfig.ParallelProcessors{MaxConcurrency: 2}, branches)
+	apiStream := newParallelTestAPIStream()
+
+	_, err := processor.Execute(apiStream)
+
+	require.NoError(t, err)
+	require.LessOrEqual(t, tracker.peakConcurrency(), 2)
+	require.Len(t, signedBranches(t, apiStream), 6)
+}
+
+func TestParallelProcessorJoinsConditions(t *testing.T) {
+	testCases := []struct {
+		name              string
+		policy            streamconfig.ConditionPolicy
+		branches          []streamtypes.Processor
+		expectedCondition string
+	}{
+		{
+			name:   "Agreeing conditions are kept",
+			policy: streamconfig.ConditionPolicyAll,
+			branches: []streamtypes.Processor{
+				&branchProcessor{name: "a", condition: "hit"},
+				&branchProcessor{name: "b", condition: "hit"},
+			},
+			expectedCondition: "hit",
+		},
+		{
+			name:   "Disagreeing conditions are dropped",
+			policy: streamconfig.ConditionPolicyAll,
+			branches: []streamtypes.Processor{
+				&branchProcessor{name: "a", condition: "hit"},
+				&branchProcessor{name: "b", condition: "miss"},
+			},
+			expectedCondition: "",
+		},
+		{
+			name:   "All policy fails on any failed branch",
+			policy: streamconfig.ConditionPolicyAll,
+			branches: []streamtypes.Processor{
+				&branchProcessor{name: "a", condition: "hit"},
+				&branchProcessor{name: "b", err: errTransient},
+			},
+			expectedCondition: ErrorConditionName,
+		},
+		{
+			name:   "Any policy succeeds with the succeeded branches",
+			policy: streamconfig.ConditionPolicyAny,
+			branches: []streamtypes.Processor{
+				&branchProcessor{name: "a", condition: "hit"},
+				&branchProcessor{name: "b", err: errTransient},
+			},
+			expectedCondition: "hit",
+		},
+		{
+			name:   "Any policy fails if all branches failed",
+			policy: streamconfig.ConditionPolicyAny,
+			branches: []streamtypes.Processor{
+				&branchProcessor{name: "a", err: errTransient},
+				&branchProcessor{name: "b", err: errTransient},
+			},
+			expectedCondition: ErrorConditionName,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			processor := newParallelProcessor(context.Background(), "Parallel",
+				&streamconfig.ParallelProcessors{ConditionPolicy: testCase.policy},
+				testCase.branches)
+
+			procIO, err := processor.Execute(newParallelTestAPIStream())
+
+			require.NoError(t, err)
+			require.Equal(t, testCase.expectedCondition, procIO.Name)
+		})
+	}
+}
+
+func TestParallelProcessorTakesFirstBranchAction(t *testing.T) {
+	firstAction := &actions.EarlyResponseAction{Status: 429}
+	processor := newParallelProcessor(context.Background(), "Parallel",
+		&streamconfig.ParallelProcessors{},
+		[]streamtypes.Processor{
+			&branchProcessor{name: "a"},
+			&branchProcessor{name: "b", action: firstAction},
+			&branchProcessor{name: "c", action: &actions.EarlyResponseAction{Status: 503}},
+		})
+
+	procIO, err := processor.Execute(newParallelTestAPIStream())
+
+	require.NoError(t, err)
+	require.Equal(t, firstAction, procIO.ReqAction)
+	require.Equal(t, publictypes.StreamTypeRequest, procIO.Type)
+}
+
+func TestParallelProcessorTimesOutBranchesIndividually(t *testing.T) {
+	stuck := make(chan struct{})
+	defer close(stuck)
+	branches := []streamtypes.Processor{
+		&branchProcessor{name: "fast", condition: "hit"},
+		&branchProcessor{name: "stuck", condition: "hit", release: stuck},
+	}
+
+	for policy, expectedCondition := range map[streamconfig.ConditionPolicy]string{
+		streamconfig.ConditionPolicyAll: ErrorConditionName,
+		streamconfig.ConditionPolicyAny: "hit",
+	} {
+		processor := newParallelProcessor(context.Background(), "Parallel",
+			&streamconfig.ParallelProcessors{ConditionPolicy: policy, TimeoutMillis: 20},
+			branches)
+		apiStream := newParallelTestAPIStream()
+
+		start := time.Now()
+		procIO, err := processor.Execute(apiStream)
+
+		require.NoError(t, err)
+		require.Less(t, time.Since(start), time.Second)
+		require.Equal(t, expectedCondition, procIO.Name)
+		require.Equal(t, []string{"fast"}, signedBranches(t, apiStream))
+	}
+}
+
+func TestParallelProcessorSynchronizesContextWrites(t *testing.T) {
+	var branches []streamtypes.Processor
+	for i := 0; i < 50; i++ {
+		branches = append(branches, &branchProcessor{name: strconv.Itoa(i)})
+	}
+	processor := newParallelProcessor(context.Background(), "Parallel",
+		&streamconfig.ParallelProcessors{MaxConcurrency: 50}, branches)
+	apiStream := newParallelTestAPIStream()
+
+	_, err := processor.Execute(apiStream)
+
+	require.NoError(t, err)
+	require.Len(t, signedBranches(t, apiStream), 50)
+}
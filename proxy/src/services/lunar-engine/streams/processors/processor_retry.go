@@ -0,0 +1,141 @@
+package processors
+
+import (
+	"context"
+	"fmt"
+	publictypes "lunar/engine/streams/public-types"
+	streamtypes "lunar/engine/streams/types"
+	"lunar/toolkit-core/otel"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	// ErrorConditionName is the condition a retried processor routes to
+	// once its retries are exhausted (if its definition declares it),
+	// and the condition parallel processors route to once they failed
+	ErrorConditionName = streamtypes.ErrorConditionName
+
+	processorRetriesMetricName = "lunar_streams.processor.retries"
+	processorAttributeName     = "processor"
+)
+
+// retryingProcessor re-executes a failing processor according to its
+// retry policy, backing off in between retries. Its retries are taken out of
+// the retry budget of the transaction, so a transaction passing through
+// several retrying processors is not retried by each of them in full.
+type retryingProcessor struct {
+	streamtypes.Processor
+	ctx             context.Context
+	clock           publictypes.ClockI
+	policy          publictypes.RetryPolicy
+	errorCondition  *streamtypes.ProcessorIO
+	retriesMetric   metric.Int64Counter
+	metricAttribute attribute.KeyValue
+	// the retries allowed to a transaction, shared with the other
+	// retrying processors of its flow
+	retryBudget int
+	budgets     *retryBudgets
+}
+
+// withRetry wraps the processor with its retry policy, if it has one.
+// Only idempotent processors are retried, so retries do not duplicate
+// their side effects.
+func withRetry(
+	ctx context.Context,
+	processor streamtypes.Processor,
+	metaData *streamtypes.ProcessorMetaData,
+) streamtypes.Processor {
+	policy := metaData.RetryPolicy
+	if policy == nil || policy.Attempts <= 0 {
+		return processor
+	}
+	if !metaData.ProcessorDefinition.Idempotent {
+		log.Warn().Msgf("Processor %s is not idempotent, it will not be retried",
+			metaData.Name)
+		return processor
+	}
+
+	retriesMetric, err := otel.GetMeter().Int64Counter(
+		processorRetriesMetricName,
+		metric.WithDescription("The number of retries of processors within flows"),
+		metric.WithUnit("{retry}"),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to initialize %s metric",
+			processorRetriesMetricName)
+	}
+	return &retryingProcessor{
+		Processor:       processor,
+		ctx:             ctx,
+		clock:           metaData.GetClock(),
+		policy:          *policy,
+		errorCondition:  findErrorCondition(metaData.ProcessorDefinition),
+		retriesMetric:   retriesMetric,
+		metricAttribute: attribute.String(processorAttributeName, metaData.Name),
+		retryBudget:     retryBudgetFromEnv(),
+		budgets:         transactionRetryBudgets,
+	}
+}
+
+func (p *retryingProcessor) Execute(
+	apiStream publictypes.APIStreamI,
+) (streamtypes.ProcessorIO, error) {
+	backoff := time.Duration(p.policy.BackoffMillis) * time.Millisecond
+	procIO, err := p.Processor.Execute(apiStream)
+	for retry := 1; err != nil && retry <= p.policy.Attempts; retry++ {
+		if !p.budgets.take(apiStream.GetID(), p.retryBudget, p.clock.Now()) {
+			log.Debug().Err(err).Msgf("Processor %s failed, the retry budget "+
+				"of the transaction is spent", p.GetName())
+			return p.giveUp(apiStream, fmt.Errorf("retry budget spent: %w", err))
+		}
+		log.Debug().Err(err).Msgf("Processor %s failed, retry %d of %d in %v",
+			p.GetName(), retry, p.policy.Attempts, backoff)
+		select {
+		case <-p.ctx.Done():
+			return p.giveUp(apiStream,
+				fmt.Errorf("retries aborted: %w: %w", p.ctx.Err(), err))
+		case <-p.clock.After(backoff):
+		}
+		if p.retriesMetric != nil {
+			p.retriesMetric.Add(p.ctx, 1, metric.WithAttributes(p.metricAttribute))
+		}
+		procIO, err = p.Processor.Execute(apiStream)
+		backoff *= 2
+	}
+	if err != nil {
+		return p.giveUp(apiStream, err)
+	}
+	return procIO, nil
+}
+
+// giveUp routes to the error condition if the processor declares one,
+// recording the error for the flow to handle it, otherwise the error
+// is returned
+func (p *retryingProcessor) giveUp(
+	apiStream publictypes.APIStreamI,
+	err error,
+) (streamtypes.ProcessorIO, error) {
+	if p.errorCondition == nil {
+		return streamtypes.ProcessorIO{}, err
+	}
+	streamtypes.RecordProcessorError(apiStream, p.GetName(), err)
+	log.Debug().Err(err).Msgf("Processor %s failed, routing to %s condition",
+		p.GetName(), ErrorConditionName)
+	return *p.errorCondition, nil
+}
+
+func findErrorCondition(
+	definition streamtypes.ProcessorDefinition,
+) *streamtypes.ProcessorIO {
+	for _, outputStream := range definition.OutputStreams {
+		if outputStream.Name == ErrorConditionName {
+			errorCondition := outputStream
+			return &errorCondition
+		}
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	publictypes "lunar/engine/streams/public-types"
+	streamtypes "lunar/engine/streams/types"
+	"lunar/toolkit-core/clock"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errTransient = errors.New("transient failure")
+
+// flakyProcessor fails until it was executed the given number of times
+type flakyProcessor struct {
+	mutex      sync.Mutex
+	executions int
+	failures   int
+}
+
+func (p *flakyProcessor) Execute(_ publictypes.APIStreamI) (streamtypes.ProcessorIO, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.executions++
+	if p.executions <= p.failures {
+		return streamtypes.ProcessorIO{}, errTransient
+	}
+	return streamtypes.ProcessorIO{Name: "ok", Type: publictypes.StreamTypeRequest}, nil
+}
+
+func (p *flakyProcessor) GetName() string {
+	return "Flaky"
+}
+
+func (p *flakyProcessor) executionCount() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.executions
+}
+
+func retryMetaData(
+	clock publictypes.ClockI,
+	idempotent bool,
+	policy *publictypes.RetryPolicy,
+	outputStreams ...streamtypes.ProcessorIO,
+) *streamtypes.ProcessorMetaData {
+	return &streamtypes.ProcessorMetaData{
+		Name: "Flaky",
+		ProcessorDefinition: streamtypes.ProcessorDefinition{
+			Name:          "Flaky",
+			Idempotent:    idempotent,
+			OutputStreams: outputStreams,
+		},
+		Clock:       clock,
+		RetryPolicy: policy,
+	}
+}
+
+// executeAdvancingClock executes the processor while moving the clock forward,
+// so its backoffs elapse
+func executeAdvancingClock(
+	t *testing.T,
+	clock *clock.MockClock,
+	processor streamtypes.Processor,
+) (streamtypes.ProcessorIO, error) {
+	return executeStreamAdvancingClock(t, clock, processor, &mockAPIStream{})
+}
+
+func executeStreamAdvancingClock(
+	t *testing.T,
+	clock *clock.MockClock,
+	processor streamtypes.Processor,
+	apiStream publictypes.APIStreamI,
+) (streamtypes.ProcessorIO, error) {
+	type result struct {
+		procIO streamtypes.ProcessorIO
+		err    error
+	}
+	results := make(chan result, 1)
+	go func() {
+		procIO, err := processor.Execute(apiStream)
+		results <- result{procIO, err}
+	}()
+	var executed result
+	require.Eventually(t, func() bool {
+		clock.AdvanceTime(time.Second)
+		select {
+		case executed = <-results:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	return executed.procIO, executed.err
+}
+
+func TestIdempotentProcessorIsRetriedUntilItSucceeds(t *testing.T) {
+	clock := clock.NewMockClock()
+	flaky := &flakyProcessor{failures: 2}
+	processor := withRetry(context.Background(), flaky, retryMetaData(
+		clock, true, &publictypes.RetryPolicy{Attempts: 3, BackoffMillis: 100}))
+
+	procIO, err := executeAdvancingClock(t, clock, processor)
+
+	require.NoError(t, err)
+	require.Equal(t, "ok", procIO.Name)
+	require.Equal(t, 3, flaky.executionCount())
+}
+
+func TestProcessorWithoutRetryPolicyIsExecutedOnce(t *testing.T) {
+	flaky := &flakyProcessor{failures: 1}
+	processor := withRetry(context.Background(), flaky,
+		retryMetaData(clock.NewMockClock(), true, nil))
+
+	_, err := processor.Execute(&mockAPIStream{})
+
+	require.ErrorIs(t, err, errTransient)
+	require.Equal(t, 1, flaky.executionCount())
+}
+
+func TestNonIdempotentProcessorIsNotRetried(t *testing.T) {
+	flaky := &flakyProcessor{failures: 1}
+	processor := withRetry(context.Background(), flaky, retryMetaData(
+		clock.NewMockClock(), false, &publictypes.RetryPolicy{Attempts: 3}))
+
+	_, err := processor.Execute(&mockAPIStream{})
+
+	require.ErrorIs(t, err, errTransient)
+	require.Equal(t, 1, flaky.executionCount())
+}
+
+func TestProcessorRoutesToErrorConditionOnceRetriesAreExhausted(t *testing.T) {
+	clock := clock.NewMockClock()
+	errorCondition := streamtypes.ProcessorIO{
+		Name: ErrorConditionName,
+		Type: publictypes.StreamTypeRequest,
+	}
+	flaky := &flakyProcessor{failures: 5}
+	processor := withRetry(context.Background(), flaky, retryMetaData(
+		clock, true, &publictypes.RetryPolicy{Attempts: 2, BackoffMillis: 100},
+		streamtypes.ProcessorIO{Name: "ok", Type: publictypes.StreamTypeRequest},
+		errorCondition,
+	))
+
+	procIO, err := executeAdvancingClock(t, clock, processor)
+
+	require.NoError(t, err)
+	require.Equal(t, errorCondition, procIO)
+	require.Equal(t, 3, flaky.executionCount())
+}
+
+func TestProcessorReturnsErrorOnceRetriesAreExhausted(t *testing.T) {
+	clock := clock.NewMockClock()
+	flaky := &flakyProcessor{failures: 5}
+	processor := withRetry(context.Background(), flaky, retryMetaData(
+		clock, true, &publictypes.RetryPolicy{Attempts: 2, BackoffMillis: 100}))
+
+	_, err := executeAdvancingClock(t, clock, processor)
+
+	require.ErrorIs(t, err, errTransient)
+	require.Equal(t, 3, flaky.executionCount())
+}
+
+func TestContextCancellationAbortsRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	flaky := &flakyProcessor{failures: 5}
+	// The mock clock is never moved forward, so only cancellation ends the backoff
+	processor := withRetry(ctx, flaky, retryMetaData(
+		clock.NewMockClock(), true,
+		&publictypes.RetryPolicy{Attempts: 3, BackoffMillis: 1000}))
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := processor.Execute(&mockAPIStream{})
+		errs <- err
+	}()
+	cancel()
+
+	select {
+	case err := <-errs:
+		require.ErrorIs(t, err, context.Canceled)
+		require.ErrorIs(t, err, errTransient)
+	case <-time.After(time.Second):
+		require.Fail(t, "retries were not aborted")
+	}
+	require.Equal(t, 1, flaky.executionCount())
+}
+
+func TestRetryingProcessorsShareTheRetryBudgetOfTheTransaction(t *testing.T) {
+	t.Setenv("LUNAR_FLOW_RETRY_BUDGET", "4")
+	clock := clock.NewMockClock()
+	policy := &publictypes.RetryPolicy{Attempts: 3, BackoffMillis: 100}
+	firstStage := &flakyProcessor{failures: 3}
+	secondStage := &flakyProcessor{failures: 5}
+	firstProcessor := withRetry(context.Background(), firstStage,
+		retryMetaData(clock, true, policy))
+	secondProcessor := withRetry(context.Background(), secondStage,
+		retryMetaData(clock, true, policy))
+	apiStream := &mockAPIStream{id: "shared-budget-transaction"}
+	defer ReleaseRetryBudget(apiStream.id)
+
+	// The first stage succeeds on its last retry, using 3 of the 4 retries
+	_, err := executeStreamAdvancingClock(t, clock, firstProcessor, apiStream)
+	require.NoError(t, err)
+	require.Equal(t, 4, firstStage.executionCount())
+
+	// So the second stage is retried but once, rather than by its own policy
+	_, err = executeStreamAdvancingClock(t, clock, secondProcessor, apiStream)
+	require.ErrorIs(t, err, errTransient)
+	require.Equal(t, 2, secondStage.executionCount())
+
+	// Another transaction has a budget of its own
+	otherStage := &flakyProcessor{failures: 1}
+	otherProcessor := withRetry(context.Background(), otherStage,
+		retryMetaData(clock, true, policy))
+	otherStream := &mockAPIStream{id: "other-budget-transaction"}
+	defer ReleaseRetryBudget(otherStream.id)
+	_, err = executeStreamAdvancingClock(t, clock, otherProcessor, otherStream)
+	require.NoError(t, err)
+	require.Equal(t, 2, otherStage.executionCount())
+
+	// Once the transaction ended its budget is released
+	ReleaseRetryBudget(apiStream.id)
+	releasedStage := &flakyProcessor{failures: 1}
+	releasedProcessor := withRetry(context.Background(), releasedStage,
+		retryMetaData(clock, true, policy))
+	_, err = executeStreamAdvancingClock(t, clock, releasedProcessor, apiStream)
+	require.NoError(t, err)
+	require.Equal(t, 2, releasedStage.executionCount())
+}
+
+// failingProcessor always fails, counting its executions by transaction
+type failingProcessor struct {
+	mutex      sync.Mutex
+	executions map[string]int
+}
+
+func (p *failingProcessor) Execute(
+	apiStream publictypes.APIStreamI,
+) (streamtypes.ProcessorIO, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.executions[apiStream.GetID()]++
+	return streamtypes.ProcessorIO{}, errTransient
+}
+
+func (p *failingProcessor) GetName() string {
+	return "Failing"
+}
+
+func (p *failingProcessor) executionCount(transactionID string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.executions[transactionID]
+}
+
+func TestConcurrentTransactionsOfAFlowHaveRetryBudgetsOfTheirOwn(t *testing.T) {
+	t.Setenv("LUNAR_FLOW_RETRY_BUDGET", "4")
+	policy := &publictypes.RetryPolicy{Attempts: 3, BackoffMillis: 1}
+	firstStage := &failingProcessor{executions: map[string]int{}}
+	secondStage := &failingProcessor{executions: map[string]int{}}
+	firstProcessor := withRetry(context.Background(), firstStage,
+		retryMetaData(clock.NewRealClock(), true, policy))
+	secondProcessor := withRetry(context.Background(), secondStage,
+		retryMetaData(clock.NewRealClock(), true, policy))
+
+	const transactions = 20
+	transactionIDs := make([]string, transactions)
+	waitGroup := sync.WaitGroup{}
+	for i := range transactionIDs {
+		transactionIDs[i] = fmt.Sprintf("concurrent-transaction-%d", i)
+		waitGroup.Add(1)
+		go func(apiStream *mockAPIStream) {
+			defer waitGroup.Done()
+			_, _ = firstProcessor.Execute(apiStream)
+			_, _ = secondProcessor.Execute(apiStream)
+		}(&mockAPIStream{id: transactionIDs[i]})
+	}
+	waitGroup.Wait()
+
+	for _, transactionID := range transactionIDs {
+		// each transaction is retried 3 times by the first stage and
+		// once by the second, regardless of the others
+		require.Equal(t, 4, firstStage.executionCount(transactionID), transactionID)
+		require.Equal(t, 2, secondStage.executionCount(transactionID), transactionID)
+		ReleaseRetryBudget(transactionID)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	streamtypes "lunar/engine/streams/types"
 	"lunar/engine/utils/environment"
 	"lunar/toolkit-core/configuration"
+	contextmanager "lunar/toolkit-core/context-manager"
 	"lunar/toolkit-core/network"
 	"os"
 	"path/filepath"
@@ -16,17 +17,19 @@ import (
 )
 
 type ProcessorManager struct {
-	procFactory map[string]ProcessorFactory
-	processors  map[string]*streamtypes.ProcessorDefinition
-	resources   *resources.ResourceManagement
+	procFactory  map[string]ProcessorFactory
+	processors   map[string]*streamtypes.ProcessorDefinition
+	resources    *resources.ResourceManagement
+	featureFlags publictypes.FeatureFlagsI
 }
 
 // NewProcessorManager creates a new processor manager
 func NewProcessorManager(resources *resources.ResourceManagement) *ProcessorManager {
 	return &ProcessorManager{
-		processors:  make(map[string]*streamtypes.ProcessorDefinition),
-		procFactory: make(map[string]ProcessorFactory),
-		resources:   resources,
+		processors:   make(map[string]*streamtypes.ProcessorDefinition),
+		procFactory:  make(map[string]ProcessorFactory),
+		resources:    resources,
+		featureFlags: streamtypes.NewFeatureFlags(),
 	}
 }
 
@@ -87,11 +90,15 @@ func (pm *ProcessorManager) CreateProcessor(
 		return nil, err
 	}
 
+	ctxMng := contextmanager.Get()
 	procMetadata := &streamtypes.ProcessorMetaData{
 		Name:                procDef.Name,
 		Parameters:          params,
 		ProcessorDefinition: *procDef,
 		Resources:           pm.resources,
+		Clock:               ctxMng.GetClock(),
+		RetryPolicy:         procConf.GetRetryPolicy(),
+		FeatureFlags:        pm.featureFlags,
 	}
 
 	factory, found := pm.procFactory[procConf.GetName()]
@@ -99,7 +106,11 @@ func (pm *ProcessorManager) CreateProcessor(
 		return nil, fmt.Errorf("processor factory %s not found", procConf.GetName())
 	}
 	log.Trace().Msgf("Creating processor %s with: %v", procConf.GetName(), procConf.ParamMap())
-	return factory(procMetadata)
+	processor, err := factory(procMetadata)
+	if err != nil {
+		return nil, err
+	}
+	return withRetry(ctxMng.GetContext(), processor, procMetadata), nil
 }
 
 func (pm *ProcessorManager) GetLoadedConfig() []network.ConfigurationPayload {
@@ -115,6 +126,11 @@ func (pm *ProcessorManager) GetLoadedConfig() []network.ConfigurationPayload {
 	return loadedConfig
 }
 
+// SetFeatureFlags sets the feature flags processors read at runtime
+func (pm *ProcessorManager) SetFeatureFlags(featureFlags publictypes.FeatureFlagsI) {
+	pm.featureFlags = featureFlags
+}
+
 // SetFactory sets a processor factory
 func (pm *ProcessorManager) SetFactory(name string, factory ProcessorFactory) {
 	pm.procFactory[name] = factory
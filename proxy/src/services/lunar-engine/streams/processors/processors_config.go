@@ -1,6 +1,8 @@
 package processors
 
 import (
+	processorcompress "lunar/engine/streams/processors/compress"
+	processorerrorresponse "lunar/engine/streams/processors/error-response"
 	processorfilter "lunar/engine/streams/processors/filter-processor"
 	processorgenerateresponse "lunar/engine/streams/processors/generate-response"
 	processorlimiter "lunar/engine/streams/processors/limiter"
@@ -8,6 +10,7 @@ import (
 	processorqueue "lunar/engine/streams/processors/queue"
 	processorquotadec "lunar/engine/streams/processors/quota-processor-dec"
 	processorquotainc "lunar/engine/streams/processors/quota-processor-inc"
+	processorroute "lunar/engine/streams/processors/route"
 	processoruserdefinedmetrics "lunar/engine/streams/processors/user-defined-metrics"
 	streamtypes "lunar/engine/streams/types"
 )
@@ -26,5 +29,8 @@ func init() {
 		"QuotaProcessorInc":  processorquotainc.NewProcessor,
 		"QuotaProcessorDec":  processorquotadec.NewProcessor,
 		"UserDefinedMetrics": processoruserdefinedmetrics.NewProcessor,
+		"Route":              processorroute.NewProcessor,
+		"Compress":           processorcompress.NewProcessor,
+		"ErrorResponse":      processorerrorresponse.NewProcessor,
 	}
 }
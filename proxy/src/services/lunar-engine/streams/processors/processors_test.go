@@ -1,10 +1,17 @@
 package processors
 
 import (
+	"fmt"
+	"lunar/engine/actions"
+	processorcompress "lunar/engine/streams/processors/compress"
 	filterprocessor "lunar/engine/streams/processors/filter-processor"
+	processorroute "lunar/engine/streams/processors/route"
 	publictypes "lunar/engine/streams/public-types"
 	streamtypes "lunar/engine/streams/types"
+	"lunar/engine/utils/compression"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -88,3 +95,603 @@ func createFilterProcessorParams(url, method, body, header string) map[string]st
 	}
 	return paramMap
 }
+
+func TestRouteProcessorRequiresDefaultTarget(t *testing.T) {
+	params := createRouteProcessorParams("")
+	_, err := processorroute.NewProcessor(&streamtypes.ProcessorMetaData{
+		Name:       "routeProcessor",
+		Parameters: params,
+	})
+	require.Error(t, err)
+}
+
+func TestRouteProcessorRejectsInvalidRoutes(t *testing.T) {
+	// route without a target
+	params := createRouteProcessorParams("https://default.com")
+	setRouteProcessorParam(params, processorroute.RoutesParam, []string{"blue"})
+	setRouteProcessorParam(params, processorroute.RouteHostsParam,
+		map[string]interface{}{"blue": "api.com"})
+	_, err := processorroute.NewProcessor(&streamtypes.ProcessorMetaData{
+		Name:       "routeProcessor",
+		Parameters: params,
+	})
+	require.Error(t, err)
+
+	// route without any predicate
+	params = createRouteProcessorParams("https://default.com")
+	setRouteProcessorParam(params, processorroute.RoutesParam, []string{"blue"})
+	setRouteProcessorParam(params, processorroute.RouteTargetsParam,
+		map[string]interface{}{"blue": "https://blue.com"})
+	_, err = processorroute.NewProcessor(&streamtypes.ProcessorMetaData{
+		Name:       "routeProcessor",
+		Parameters: params,
+	})
+	require.Error(t, err)
+
+	// route with an unsupported target scheme
+	setRouteProcessorParam(params, processorroute.RouteTargetsParam,
+		map[string]interface{}{"blue": "ftp://blue.com"})
+	setRouteProcessorParam(params, processorroute.RouteHostsParam,
+		map[string]interface{}{"blue": "api.com"})
+	_, err = processorroute.NewProcessor(&streamtypes.ProcessorMetaData{
+		Name:       "routeProcessor",
+		Parameters: params,
+	})
+	require.Error(t, err)
+}
+
+func TestRouteProcessorExecute(t *testing.T) {
+	params := createRouteProcessorParams("https://default.com")
+	setRouteProcessorParam(params, processorroute.RoutesParam,
+		[]string{"canary", "v2", "other-host"})
+	setRouteProcessorParam(params, processorroute.RouteTargetsParam,
+		map[string]interface{}{
+			"canary":     "https://canary.api.com",
+			"v2":         "http://v2.api.com:8080/api",
+			"other-host": "https://other.com",
+		})
+	setRouteProcessorParam(params, processorroute.RouteHeadersParam,
+		map[string]interface{}{"canary": "X-Canary=true"})
+	setRouteProcessorParam(params, processorroute.RoutePathPrefixesParam,
+		map[string]interface{}{"v2": "/v2/"})
+	setRouteProcessorParam(params, processorroute.RouteStripPathPrefixesParam,
+		map[string]interface{}{"v2": "/v2"})
+	setRouteProcessorParam(params, processorroute.RouteHostsParam,
+		map[string]interface{}{"v2": "api.com", "other-host": "other-api.com"})
+
+	processor, err := processorroute.NewProcessor(&streamtypes.ProcessorMetaData{
+		Name:       "routeProcessor",
+		Parameters: params,
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name              string
+		url               string
+		headers           map[string]string
+		expectedCondition string
+		expectedUpstream  publictypes.UpstreamTarget
+	}{
+		{
+			name:              "header predicate",
+			url:               "api.com/v2/users",
+			headers:           map[string]string{"X-Canary": "true"},
+			expectedCondition: "canary",
+			expectedUpstream: publictypes.UpstreamTarget{
+				Scheme: "https", Host: "canary.api.com", Port: 443, Path: "/v2/users",
+			},
+		},
+		{
+			name:              "host and path prefix predicates with stripping",
+			url:               "api.com/v2/users",
+			headers:           map[string]string{},
+			expectedCondition: "v2",
+			expectedUpstream: publictypes.UpstreamTarget{
+				Scheme: "http", Host: "v2.api.com", Port: 8080, Path: "/api/users",
+			},
+		},
+		{
+			name:              "host predicate",
+			url:               "other-api.com/v2/users",
+			headers:           map[string]string{},
+			expectedCondition: "other-host",
+			expectedUpstream: publictypes.UpstreamTarget{
+				Scheme: "https", Host: "other.com", Port: 443, Path: "/v2/users",
+			},
+		},
+		{
+			name:              "default route",
+			url:               "api.com/v1/users",
+			headers:           map[string]string{"X-Canary": "false"},
+			expectedCondition: processorroute.DefaultRouteConditionName,
+			expectedUpstream: publictypes.UpstreamTarget{
+				Scheme: "https", Host: "default.com", Port: 443, Path: "/v1/users",
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		apiStream := &mockAPIStream{
+			url:        testCase.url,
+			method:     "GET",
+			headers:    testCase.headers,
+			streamType: publictypes.StreamTypeRequest,
+		}
+
+		output, err := processor.Execute(apiStream)
+		require.NoError(t, err, testCase.name)
+		require.Equal(t, testCase.expectedCondition, output.Name, testCase.name)
+		require.Equal(t, &testCase.expectedUpstream, apiStream.GetUpstreamTarget(),
+			testCase.name)
+		require.Equal(t, &actions.ModifyRequestAction{
+			HeadersToSet: map[string]string{},
+			Upstream:     &testCase.expectedUpstream,
+		}, output.ReqAction, testCase.name)
+	}
+}
+
+func TestRouteProcessorDefaultRouteStripsPathPrefix(t *testing.T) {
+	params := createRouteProcessorParams("https://default.com/internal")
+	setRouteProcessorParam(params, processorroute.DefaultStripPathPrefixParam, "/public")
+
+	processor, err := processorroute.NewProcessor(&streamtypes.ProcessorMetaData{
+		Name:       "routeProcessor",
+		Parameters: params,
+	})
+	require.NoError(t, err)
+
+	apiStream := &mockAPIStream{
+		url:        "api.com/public/users",
+		method:     "GET",
+		headers:    map[string]string{},
+		streamType: publictypes.StreamTypeRequest,
+	}
+	output, err := processor.Execute(apiStream)
+	require.NoError(t, err)
+	require.Equal(t, processorroute.DefaultRouteConditionName, output.Name)
+	require.Equal(t, "/internal/users", apiStream.GetUpstreamTarget().Path)
+}
+
+func TestRouteProcessorSplitsRequestsAcrossWeightedTargets(t *testing.T) {
+	params := createRouteProcessorParams("https://default.com")
+	setRouteProcessorParam(params, processorroute.RoutesParam, []string{"split"})
+	setRouteProcessorParam(params, processorroute.RouteHostsParam,
+		map[string]interface{}{"split": "api.com"})
+	setRouteProcessorParam(params, processorroute.RouteWeightedTargetsParam,
+		map[string]interface{}{
+			"split": "https://stable.api.com=90, https://canary.api.com=10",
+		})
+	processor, err := processorroute.NewProcessor(&streamtypes.ProcessorMetaData{
+		Name:       "routeProcessor",
+		Parameters: params,
+	})
+	require.NoError(t, err)
+
+	// The split is exact over every window of the total weight
+	for window := 0; window < 3; window++ {
+		selections := map[string]int{}
+		for i := 0; i < 100; i++ {
+			apiStream := &mockAPIStream{
+				url:        "api.com/users",
+				headers:    map[string]string{},
+				streamType: publictypes.StreamTypeRequest,
+			}
+			output, err := processor.Execute(apiStream)
+			require.NoError(t, err)
+			require.Equal(t, "split", output.Name)
+			selections[apiStream.GetUpstreamTarget().Host]++
+		}
+		require.Equal(t, map[string]int{"stable.api.com": 90, "canary.api.com": 10},
+			selections)
+	}
+}
+
+func TestRouteProcessorKeepsClientsOnTheirWeightedTarget(t *testing.T) {
+	params := createRouteProcessorParams("https://default.com")
+	setRouteProcessorParam(params, processorroute.RoutesParam, []string{"split"})
+	setRouteProcessorParam(params, processorroute.RouteHostsParam,
+		map[string]interface{}{"split": "api.com"})
+	setRouteProcessorParam(params, processorroute.RouteWeightedTargetsParam,
+		map[string]interface{}{"split": "https://stable.api.com=1,https://canary.api.com=1"})
+	setRouteProcessorParam(params, processorroute.RouteStickyHeadersParam,
+		map[string]interface{}{"split": "X-Client-ID"})
+	processor, err := processorroute.NewProcessor(&streamtypes.ProcessorMetaData{
+		Name:       "routeProcessor",
+		Parameters: params,
+	})
+	require.NoError(t, err)
+
+	route := func(headers map[string]string) string {
+		apiStream := &mockAPIStream{
+			url:        "api.com/users",
+			headers:    headers,
+			streamType: publictypes.StreamTypeRequest,
+		}
+		_, err := processor.Execute(apiStream)
+		require.NoError(t, err)
+		return apiStream.GetUpstreamTarget().Host
+	}
+	clientTargets := map[string]int{}
+	for client := 0; client < 50; client++ {
+		headers := map[string]string{"X-Client-ID": fmt.Sprintf("client-%d", client)}
+		target := route(headers)
+		for i := 0; i < 5; i++ {
+			require.Equal(t, target, route(headers))
+		}
+		clientTargets[target]++
+	}
+	// Clients are spread across the targets
+	require.Len(t, clientTargets, 2)
+
+	// Requests with no client are still split
+	require.NotEqual(t, route(map[string]string{}), route(map[string]string{}))
+}
+
+func TestRouteProcessorRejectsInvalidWeightedTargets(t *testing.T) {
+	for _, testCase := range []struct {
+		name            string
+		weightedTargets string
+		target          interface{}
+		stickyHeader    interface{}
+	}{
+		{name: "missing weight", weightedTargets: "https://stable.api.com"},
+		{name: "zero weight", weightedTargets: "https://stable.api.com=0"},
+		{name: "invalid target", weightedTargets: "ftp://stable.api.com=1"},
+		{
+			name:            "both target and weighted targets",
+			weightedTargets: "https://stable.api.com=1",
+			target:          map[string]interface{}{"split": "https://other.com"},
+		},
+	} {
+		params := createRouteProcessorParams("https://default.com")
+		setRouteProcessorParam(params, processorroute.RoutesParam, []string{"split"})
+		setRouteProcessorParam(params, processorroute.RouteHostsParam,
+			map[string]interface{}{"split": "api.com"})
+		setRouteProcessorParam(params, processorroute.RouteWeightedTargetsParam,
+			map[string]interface{}{"split": testCase.weightedTargets})
+		setRouteProcessorParam(params, processorroute.RouteTargetsParam, testCase.target)
+		_, err := processorroute.NewProcessor(&streamtypes.ProcessorMetaData{
+			Name:       "routeProcessor",
+			Parameters: params,
+		})
+		require.Error(t, err, testCase.name)
+	}
+
+	// sticky header without weighted targets
+	params := createRouteProcessorParams("https://default.com")
+	setRouteProcessorParam(params, processorroute.RoutesParam, []string{"blue"})
+	setRouteProcessorParam(params, processorroute.RouteHostsParam,
+		map[string]interface{}{"blue": "api.com"})
+	setRouteProcessorParam(params, processorroute.RouteTargetsParam,
+		map[string]interface{}{"blue": "https://blue.api.com"})
+	setRouteProcessorParam(params, processorroute.RouteStickyHeadersParam,
+		map[string]interface{}{"blue": "X-Client-ID"})
+	_, err := processorroute.NewProcessor(&streamtypes.ProcessorMetaData{
+		Name:       "routeProcessor",
+		Parameters: params,
+	})
+	require.Error(t, err)
+}
+
+func TestRouteProcessorFailsOnResponseStream(t *testing.T) {
+	processor, err := processorroute.NewProcessor(&streamtypes.ProcessorMetaData{
+		Name:       "routeProcessor",
+		Parameters: createRouteProcessorParams("https://default.com"),
+	})
+	require.NoError(t, err)
+
+	_, err = processor.Execute(&mockAPIStream{
+		url:        "api.com/users",
+		streamType: publictypes.StreamTypeResponse,
+	})
+	require.Error(t, err)
+}
+
+// createRouteProcessorParams mimics the parameters passed on a flow load,
+// where all the parameters declared in the registry are set
+func createRouteProcessorParams(defaultTarget string) map[string]streamtypes.ProcessorParam {
+	paramMap := make(map[string]streamtypes.ProcessorParam)
+	for _, paramName := range []string{
+		processorroute.RoutesParam,
+		processorroute.RouteTargetsParam,
+		processorroute.RouteHostsParam,
+		processorroute.RoutePathPrefixesParam,
+		processorroute.RouteHeadersParam,
+		processorroute.RouteStripPathPrefixesParam,
+		processorroute.RouteWeightedTargetsParam,
+		processorroute.RouteStickyHeadersParam,
+		processorroute.DefaultStripPathPrefixParam,
+	} {
+		setRouteProcessorParam(paramMap, paramName, nil)
+	}
+	setRouteProcessorParam(paramMap, processorroute.DefaultTargetParam, defaultTarget)
+	return paramMap
+}
+
+func setRouteProcessorParam(
+	paramMap map[string]streamtypes.ProcessorParam,
+	paramName string,
+	value interface{},
+) {
+	paramMap[paramName] = streamtypes.ProcessorParam{
+		Name:  paramName,
+		Value: publictypes.NewParamValue(value),
+	}
+}
+
+func TestCompressProcessorCompressesResponse(t *testing.T) {
+	processor, err := processorcompress.NewProcessor(&streamtypes.ProcessorMetaData{
+		Name:       "compressProcessor",
+		Parameters: createCompressProcessorParams([]string{"br", "gzip"}, 100, nil),
+	})
+	require.NoError(t, err)
+
+	body := strings.Repeat("compress me please, ", 50)
+	testCases := []struct {
+		acceptEncoding    string
+		expectedAlgorithm string
+		decompress        func(string) (string, error)
+	}{
+		{"gzip, deflate, br", "br", compression.DecompressBrotli},
+		{"gzip, br;q=0", "gzip", compression.DecompressGZip},
+		{"*", "br", compression.DecompressBrotli},
+	}
+
+	for _, testCase := range testCases {
+		apiStream := createCompressResponseStream(testCase.acceptEncoding, body,
+			map[string]string{"Content-Type": "application/json", "Vary": "Origin"})
+
+		output, err := processor.Execute(apiStream)
+		require.NoError(t, err, testCase.acceptEncoding)
+		require.Equal(t, processorcompress.CompressedConditionName, output.Name)
+
+		action, valid := output.RespAction.(*actions.ModifyResponseAction)
+		require.True(t, valid, testCase.acceptEncoding)
+		require.NotNil(t, action.Body)
+		require.Equal(t, map[string]string{
+			"Content-Encoding": testCase.expectedAlgorithm,
+			"Content-Length":   strconv.Itoa(len(*action.Body)),
+			"Vary":             "Origin, Accept-Encoding",
+		}, action.HeadersToSet, testCase.acceptEncoding)
+
+		decompressed, err := testCase.decompress(*action.Body)
+		require.NoError(t, err, testCase.acceptEncoding)
+		require.Equal(t, body, decompressed, testCase.acceptEncoding)
+	}
+}
+
+func TestCompressProcessorRespectsAlgorithmPreference(t *testing.T) {
+	processor, err := processorcompress.NewProcessor(&streamtypes.ProcessorMetaData{
+		Name:       "compressProcessor",
+		Parameters: createCompressProcessorParams([]string{"gzip"}, 0, nil),
+	})
+	require.NoError(t, err)
+
+	apiStream := createCompressResponseStream("br, gzip",
+		strings.Repeat("a", 100), map[string]string{"Vary": "Accept-Encoding"})
+	output, err := processor.Execute(apiStream)
+	require.NoError(t, err)
+	require.Equal(t, processorcompress.CompressedConditionName, output.Name)
+
+	action := output.RespAction.(*actions.ModifyResponseAction)
+	require.Equal(t, "gzip", action.HeadersToSet["Content-Encoding"])
+	require.Equal(t, "Accept-Encoding", action.HeadersToSet["Vary"])
+}
+
+func TestCompressProcessorReadsFeatureFlags(t *testing.T) {
+	featureFlags := streamtypes.NewFeatureFlags()
+	processor, err := processorcompress.NewProcessor(&streamtypes.ProcessorMetaData{
+		Name:         "compressProcessor",
+		Parameters:   createCompressProcessorParams([]string{"gzip"}, 100, nil),
+		FeatureFlags: featureFlags,
+	})
+	require.NoError(t, err)
+
+	execute := func(body string) string {
+		apiStream := createCompressResponseStream("gzip", body,
+			map[string]string{"Content-Type": "text/plain"})
+		output, err := processor.Execute(apiStream)
+		require.NoError(t, err)
+		return output.Name
+	}
+	body := strings.Repeat("compress me please, ", 50)
+	require.Equal(t, processorcompress.CompressedConditionName, execute(body))
+
+	require.NoError(t, featureFlags.Set(map[string]interface{}{
+		processorcompress.EnabledFlag: false,
+	}))
+	require.Equal(t, processorcompress.SkippedConditionName, execute(body))
+
+	// Flags set through JSON are numbers, which are read as integers
+	require.NoError(t, featureFlags.Set(map[string]interface{}{
+		processorcompress.EnabledFlag:      "true",
+		processorcompress.MinSizeBytesFlag: float64(len(body) + 1),
+	}))
+	require.Equal(t, processorcompress.SkippedConditionName, execute(body))
+
+	featureFlags.Delete(processorcompress.MinSizeBytesFlag)
+	require.Equal(t, processorcompress.CompressedConditionName, execute(body))
+
+	require.Error(t, featureFlags.Set(map[string]interface{}{
+		processorcompress.EnabledFlag: map[string]interface{}{"nested": true},
+	}))
+	require.NoError(t, featureFlags.Replace(map[string]interface{}{}))
+	require.Empty(t, featureFlags.All())
+}
+
+func TestCompressProcessorSkipConditions(t *testing.T) {
+	processor, err := processorcompress.NewProcessor(&streamtypes.ProcessorMetaData{
+		Name:       "compressProcessor",
+		Parameters: createCompressProcessorParams(nil, 100, nil),
+	})
+	require.NoError(t, err)
+
+	compressibleBody := strings.Repeat("compress me please, ", 50)
+	testCases := []struct {
+		name           string
+		acceptEncoding string
+		body           string
+		headers        map[string]string
+	}{
+		{
+			name:           "client does not accept compression",
+			acceptEncoding: "",
+			body:           compressibleBody,
+			headers:        map[string]string{"Content-Type": "text/plain"},
+		},
+		{
+			name:           "client does not accept configured algorithms",
+			acceptEncoding: "deflate, gzip;q=0",
+			body:           compressibleBody,
+			headers:        map[string]string{"Content-Type": "text/plain"},
+		},
+		{
+			name:           "response is already compressed",
+			acceptEncoding: "gzip",
+			body:           compressibleBody,
+			headers:        map[string]string{"content-encoding": "gzip"},
+		},
+		{
+			name:           "image content type",
+			acceptEncoding: "gzip",
+			body:           compressibleBody,
+			headers:        map[string]string{"Content-Type": "image/png"},
+		},
+		{
+			name:           "video content type with parameters",
+			acceptEncoding: "gzip",
+			body:           compressibleBody,
+			headers:        map[string]string{"Content-Type": "Video/MP4; codecs=avc1"},
+		},
+		{
+			name:           "body below minimum size",
+			acceptEncoding: "gzip",
+			body:           strings.Repeat("a", 99),
+			headers:        map[string]string{"Content-Type": "text/plain"},
+		},
+		{
+			name:           "response does not allow transformations",
+			acceptEncoding: "gzip",
+			body:           compressibleBody,
+			headers:        map[string]string{"Cache-Control": "no-transform"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		apiStream := createCompressResponseStream(
+			testCase.acceptEncoding, testCase.body, testCase.headers)
+
+		output, err := processor.Execute(apiStream)
+		require.NoError(t, err, testCase.name)
+		require.Equal(t, processorcompress.SkippedConditionName, output.Name, testCase.name)
+		require.Equal(t, &actions.NoOpAction{}, output.RespAction, testCase.name)
+	}
+}
+
+func TestCompressProcessorSkipsPartialBodies(t *testing.T) {
+	processor, err := processorcompress.NewProcessor(&streamtypes.ProcessorMetaData{
+		Name:       "compressProcessor",
+		Parameters: createCompressProcessorParams(nil, 100, nil),
+	})
+	require.NoError(t, err)
+
+	body := strings.Repeat("compress me please, ", 50)
+	testCases := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{
+			name:    "body is truncated",
+			headers: map[string]string{"Content-Length": strconv.Itoa(len(body) * 2)},
+		},
+		{
+			name:    "response is chunked",
+			headers: map[string]string{"Transfer-Encoding": "chunked"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		output, err := processor.Execute(
+			createCompressResponseStream("gzip", body, testCase.headers))
+		require.NoError(t, err, testCase.name)
+		require.Equal(t, processorcompress.SkippedConditionName, output.Name, testCase.name)
+		require.Equal(t, &actions.NoOpAction{}, output.RespAction, testCase.name)
+	}
+
+	apiStream := createCompressResponseStream("gzip", body, nil)
+	delete(apiStream.headers, "Content-Length")
+	output, err := processor.Execute(apiStream)
+	require.NoError(t, err)
+	require.Equal(t, processorcompress.SkippedConditionName, output.Name)
+}
+
+func TestCompressProcessorSkipsConfiguredContentTypes(t *testing.T) {
+	processor, err := processorcompress.NewProcessor(&streamtypes.ProcessorMetaData{
+		Name: "compressProcessor",
+		Parameters: createCompressProcessorParams(
+			nil, 0, []string{"application/json"}),
+	})
+	require.NoError(t, err)
+
+	body := strings.Repeat("compress me please, ", 50)
+	output, err := processor.Execute(createCompressResponseStream("gzip", body,
+		map[string]string{"Content-Type": "application/json"}))
+	require.NoError(t, err)
+	require.Equal(t, processorcompress.SkippedConditionName, output.Name)
+
+	// configured content types replace the default ones
+	output, err = processor.Execute(createCompressResponseStream("gzip", body,
+		map[string]string{"Content-Type": "image/svg+xml"}))
+	require.NoError(t, err)
+	require.Equal(t, processorcompress.CompressedConditionName, output.Name)
+}
+
+func TestCompressProcessorRejectsUnknownAlgorithm(t *testing.T) {
+	_, err := processorcompress.NewProcessor(&streamtypes.ProcessorMetaData{
+		Name:       "compressProcessor",
+		Parameters: createCompressProcessorParams([]string{"gzip", "zstd"}, 0, nil),
+	})
+	require.Error(t, err)
+}
+
+func createCompressResponseStream(
+	acceptEncoding string,
+	body string,
+	headers map[string]string,
+) *mockAPIStream {
+	responseHeaders := map[string]string{
+		"Content-Length": strconv.Itoa(len(body)),
+	}
+	for name, value := range headers {
+		responseHeaders[name] = value
+	}
+	return &mockAPIStream{
+		url:            "api.com/users",
+		method:         "GET",
+		body:           body,
+		headers:        responseHeaders,
+		streamType:     publictypes.StreamTypeResponse,
+		acceptEncoding: acceptEncoding,
+	}
+}
+
+func createCompressProcessorParams(
+	algorithms []string,
+	minSizeBytes int,
+	skipContentTypes []string,
+) map[string]streamtypes.ProcessorParam {
+	paramMap := make(map[string]streamtypes.ProcessorParam)
+	paramMap[processorcompress.AlgorithmsParam] = streamtypes.ProcessorParam{
+		Name:  processorcompress.AlgorithmsParam,
+		Value: publictypes.NewParamValue(algorithms),
+	}
+	paramMap[processorcompress.MinSizeBytesParam] = streamtypes.ProcessorParam{
+		Name:  processorcompress.MinSizeBytesParam,
+		Value: publictypes.NewParamValue(minSizeBytes),
+	}
+	paramMap[processorcompress.SkipContentTypesParam] = streamtypes.ProcessorParam{
+		Name:  processorcompress.SkipContentTypesParam,
+		Value: publictypes.NewParamValue(skipContentTypes),
+	}
+	return paramMap
+}
@@ -125,6 +125,15 @@ func (p *queueProcessor) enqueue(req *Request) (bool, error) {
 		return true, nil
 	}
 
+	ttl := p.ttlOf(req)
+	if ttl <= 0 {
+		p.logger.Trace().Str("requestID", req.ID).
+			Msg("Request deadline exceeded, will not enqueue")
+		// We close the request channel to avoid memory leaks, as the request will not be processed
+		req.CloseChan()
+		return false, nil
+	}
+
 	if !p.enqueueIfSlotAvailable(req) {
 		// We close the request channel to avoid memory leaks, as the request will not be processed
 		req.CloseChan()
@@ -142,14 +151,27 @@ func (p *queueProcessor) enqueue(req *Request) (bool, error) {
 				Msgf("Request processing completed")
 			return true, nil
 
-		case <-p.clock.After(p.queueTTL):
+		case <-p.clock.After(ttl):
 			p.logger.Trace().Str("requestID", req.ID).
-				Msgf("Request TTLed (now: %+v, ttl: %+v)", p.clock.Now(), p.queueTTL)
+				Msgf("Request TTLed (now: %+v, ttl: %+v)", p.clock.Now(), ttl)
 			return false, nil
 		}
 	}
 }
 
+// ttlOf is how long the request may wait in the queue, which is the queue TTL
+// unless the client stops waiting for the response earlier
+func (p *queueProcessor) ttlOf(req *Request) time.Duration {
+	deadline := req.APIStream.GetRequest().GetDeadline()
+	if deadline.IsZero() {
+		return p.queueTTL
+	}
+	if remaining := deadline.Sub(p.clock.Now()); remaining < p.queueTTL {
+		return remaining
+	}
+	return p.queueTTL
+}
+
 func (p *queueProcessor) getNextProcessTime() time.Duration {
 	if p.metaData.Resources != nil {
 		quota, err := p.metaData.Resources.GetQuota(p.quotaID, "")
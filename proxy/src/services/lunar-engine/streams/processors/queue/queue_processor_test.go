@@ -221,3 +221,86 @@ func execute(
 	procIO, err := processor.Execute(APIStream)
 	resultChan <- result{procIO, err, APIStream.GetID()}
 }
+
+func TestQueueProcessor_RequestsWaitNoLongerThanTheirDeadline(t *testing.T) {
+	clk := contextmanager.Get().SetMockClock().GetMockClock()
+	strategy := &quotaresource.StrategyConfig{
+		FixedWindow: &quotaresource.FixedWindowConfig{
+			QuotaLimit: quotaresource.QuotaLimit{
+				Max:          1,
+				Interval:     10,
+				IntervalUnit: "second",
+			},
+		},
+	}
+	quotaID := "test3"
+	resources, _ := resources.NewResourceManagement()
+	resources, _ = resources.WithQuotaData(getQuotaData(strategy, quotaID))
+	metaData := &streamtypes.ProcessorMetaData{
+		Name:                quotaID,
+		Clock:               clk,
+		ProcessorDefinition: streamtypes.ProcessorDefinition{},
+		Parameters: map[string]streamtypes.ProcessorParam{
+			"quota_id": {
+				Name:  "quota_id",
+				Value: getParamValue("quota_id", quotaID),
+			},
+			"queue_size": {
+				Name:  "queue_size",
+				Value: getParamValue("queue_size", 10),
+			},
+			"ttl_seconds": {
+				Name:  "ttl_seconds",
+				Value: getParamValue("ttl_seconds", 10),
+			},
+			"priority_group_by_header": {
+				Name:  "priority_group_by_header",
+				Value: getParamValue("priority_group_by_header", nil),
+			},
+			"priority_groups": {
+				Name:  "priority_groups",
+				Value: getParamValue("priority_groups", nil),
+			},
+		},
+		Resources: resources,
+	}
+	queueProcessor, err := queueprocessor.NewProcessor(metaData)
+	assert.NoError(t, err)
+
+	procIO, err := queueProcessor.Execute(getAPIStream())
+	assert.NoError(t, err)
+	assert.Equal(t, getProcIO(allowedKey), procIO)
+
+	exceeded := getAPIStreamWithDeadline(clk.Now().Add(-time.Second))
+	procIO, err = queueProcessor.Execute(exceeded)
+	assert.NoError(t, err)
+	assert.Equal(t, getProcIO(blockedKey), procIO)
+
+	resultChan := make(chan result, 1)
+	defer close(resultChan)
+	go execute(getAPIStreamWithDeadline(clk.Now().Add(2*time.Second)), queueProcessor, resultChan)
+
+	// The queue TTL is 10 seconds, so the request is only blocked by its deadline
+	for i := 0; i < 4; i++ {
+		clk.AdvanceTime(2 * time.Second)
+		select {
+		case res := <-resultChan:
+			assert.NoError(t, res.err)
+			assert.Equal(t, getProcIO(blockedKey), res.procIO)
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	t.Fatal("request waited in queue beyond its deadline")
+}
+
+func getAPIStreamWithDeadline(deadline time.Time) publictypes.APIStreamI {
+	return streamtypes.NewRequestAPIStream(
+		messages.OnRequest{
+			ID:         getRandomString(10),
+			SequenceID: getRandomString(10),
+			URL:        "api.example.com",
+			Deadline:   messages.RequestDeadline{At: deadline},
+		},
+	)
+}
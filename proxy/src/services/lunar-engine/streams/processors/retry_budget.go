@@ -0,0 +1,108 @@
+package processors
+
+import (
+	"lunar/engine/utils/environment"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRetryBudget bounds the retries of a transaction across all
+	// the retrying processors of its flow, so they do not multiply
+	DefaultRetryBudget = 5
+	// retryBudgetMaxAge is how long the budget of a transaction is kept
+	// for its response, before it is vacuumed as one that never arrived
+	retryBudgetMaxAge       = 10 * time.Minute
+	retryBudgetVacuumPeriod = time.Minute
+)
+
+// transactionRetryBudgets holds the budgets of the transactions in flight,
+// shared by all the flows as transactions are told apart by their ID
+var transactionRetryBudgets = newRetryBudgets()
+
+// retryBudgets holds the retries left to each transaction, by its ID.
+// A transaction's budget starts on its first retry and lasts until
+// its response was processed, so both phases of it share one budget.
+type retryBudgets struct {
+	mutex      sync.Mutex
+	budgets    map[string]*transactionRetryBudget
+	lastVacuum time.Time
+}
+
+type transactionRetryBudget struct {
+	retriesLeft int
+	startedAt   time.Time
+}
+
+func newRetryBudgets() *retryBudgets {
+	return &retryBudgets{
+		mutex:      sync.Mutex{},
+		budgets:    map[string]*transactionRetryBudget{},
+		lastVacuum: time.Time{},
+	}
+}
+
+// retryBudgetFromEnv reads the retries allowed to each transaction,
+// falling back to the default if unset or invalid
+func retryBudgetFromEnv() int {
+	budget, err := environment.GetFlowRetryBudget()
+	if err != nil || budget < 0 {
+		return DefaultRetryBudget
+	}
+	return budget
+}
+
+// ReleaseRetryBudget drops the budget of the transaction, once it ended
+func ReleaseRetryBudget(transactionID string) {
+	transactionRetryBudgets.release(transactionID)
+}
+
+// take takes a retry out of the budget of the transaction, which starts at
+// the given budget on its first retry, and returns whether one was left.
+// Streams without a transaction ID are not budgeted.
+func (retryBudgets *retryBudgets) take(
+	transactionID string,
+	budget int,
+	now time.Time,
+) bool {
+	if transactionID == "" {
+		return true
+	}
+	retryBudgets.mutex.Lock()
+	defer retryBudgets.mutex.Unlock()
+	retryBudgets.vacuum(now)
+
+	transactionBudget, found := retryBudgets.budgets[transactionID]
+	if !found {
+		transactionBudget = &transactionRetryBudget{retriesLeft: budget, startedAt: now}
+		retryBudgets.budgets[transactionID] = transactionBudget
+	}
+	if transactionBudget.retriesLeft <= 0 {
+		return false
+	}
+	transactionBudget.retriesLeft--
+	return true
+}
+
+func (retryBudgets *retryBudgets) release(transactionID string) {
+	if transactionID == "" {
+		return
+	}
+	retryBudgets.mutex.Lock()
+	defer retryBudgets.mutex.Unlock()
+	delete(retryBudgets.budgets, transactionID)
+}
+
+// vacuum drops the budgets of transactions whose response never arrived,
+// checking at most once per period. Must be called with the mutex held.
+func (retryBudgets *retryBudgets) vacuum(now time.Time) {
+	if now.Sub(retryBudgets.lastVacuum) < retryBudgetVacuumPeriod {
+		return
+	}
+	retryBudgets.lastVacuum = now
+	for transactionID, transactionBudget := range retryBudgets.budgets {
+		if now.Sub(transactionBudget.startedAt) > retryBudgetMaxAge {
+			delete(retryBudgets.budgets, transactionID)
+		}
+	}
+}
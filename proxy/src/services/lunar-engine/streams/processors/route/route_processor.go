@@ -0,0 +1,304 @@
+package processorroute
+
+import (
+	"context"
+	"fmt"
+	"lunar/engine/actions"
+	"lunar/engine/streams/processors/utils"
+	publictypes "lunar/engine/streams/public-types"
+	streamtypes "lunar/engine/streams/types"
+	"lunar/toolkit-core/otel"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	DefaultRouteConditionName = "default"
+
+	RoutesParam                 = "routes"
+	RouteTargetsParam           = "route_targets"
+	RouteHostsParam             = "route_hosts"
+	RoutePathPrefixesParam      = "route_path_prefixes"
+	RouteHeadersParam           = "route_headers"
+	RouteStripPathPrefixesParam = "route_strip_path_prefixes"
+	RouteWeightedTargetsParam   = "route_weighted_targets"
+	RouteStickyHeadersParam     = "route_sticky_headers"
+	DefaultTargetParam          = "default_target"
+	DefaultStripPathPrefixParam = "default_strip_path_prefix"
+
+	targetSelectionsMetricName = "lunar_streams.route.target_selections"
+	processorAttributeName     = "processor"
+	routeAttributeName         = "route"
+	targetAttributeName        = "target"
+)
+
+type route struct {
+	name            string
+	rawTarget       string
+	target          *publictypes.UpstreamTarget
+	weightedTargets *weightedTargets
+	// requests with the same value of this header are sent to the same
+	// weighted target
+	stickyHeader    string
+	stripPathPrefix string
+	host            string
+	pathPrefix      string
+	headerKey       string
+	headerValue     string
+}
+
+type routeProcessor struct {
+	name         string
+	routes       []route
+	defaultRoute route
+	metaData     *streamtypes.ProcessorMetaData
+	selections   metric.Int64Counter
+}
+
+func NewProcessor(
+	metaData *streamtypes.ProcessorMetaData,
+) (streamtypes.Processor, error) {
+	return newProcessor(metaData, otel.GetMeter())
+}
+
+func newProcessor(
+	metaData *streamtypes.ProcessorMetaData,
+	meter metric.Meter,
+) (*routeProcessor, error) {
+	selections, err := meter.Int64Counter(
+		targetSelectionsMetricName,
+		metric.WithDescription("Requests routed to each target, by route"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to initialize %s metric",
+			targetSelectionsMetricName)
+	}
+	proc := &routeProcessor{
+		name:       metaData.Name,
+		metaData:   metaData,
+		selections: selections,
+	}
+
+	if err := proc.init(); err != nil {
+		return nil, err
+	}
+
+	return proc, nil
+}
+
+func (p *routeProcessor) GetName() string {
+	return p.name
+}
+
+func (p *routeProcessor) Execute(
+	apiStream publictypes.APIStreamI,
+) (streamtypes.ProcessorIO, error) {
+	if !apiStream.GetType().IsRequestType() {
+		return streamtypes.ProcessorIO{}, fmt.Errorf(
+			"invalid stream type: %s", apiStream.GetType())
+	}
+
+	host, path := splitURL(apiStream.GetURL())
+	matchedRoute := p.defaultRoute
+	for _, candidate := range p.routes {
+		if candidate.matches(apiStream, host, path) {
+			matchedRoute = candidate
+			break
+		}
+	}
+
+	selected := matchedRoute.selectTarget(apiStream)
+	if p.selections != nil {
+		p.selections.Add(context.Background(), 1, metric.WithAttributes(
+			attribute.String(processorAttributeName, p.name),
+			attribute.String(routeAttributeName, matchedRoute.name),
+			attribute.String(targetAttributeName, selected.raw),
+		))
+	}
+	upstream := upstreamFor(selected.target, matchedRoute.stripPathPrefix, path)
+	apiStream.SetUpstreamTarget(upstream)
+	log.Trace().Msgf("%v routed %v to %s://%s:%d%s (route: %s)",
+		p.name, apiStream.GetURL(), upstream.Scheme, upstream.Host,
+		upstream.Port, upstream.Path, matchedRoute.name)
+
+	return streamtypes.ProcessorIO{
+		Type: publictypes.StreamTypeRequest,
+		ReqAction: &actions.ModifyRequestAction{
+			HeadersToSet: map[string]string{},
+			Upstream:     upstream,
+		},
+		Name: matchedRoute.name,
+	}, nil
+}
+
+func (p *routeProcessor) init() error {
+	var rawDefaultTarget string
+	if err := utils.ExtractStrParam(p.metaData.Parameters,
+		DefaultTargetParam,
+		&rawDefaultTarget); err != nil || rawDefaultTarget == "" {
+		return fmt.Errorf("default target is required for %v", p.metaData.Name)
+	}
+	defaultTarget, err := publictypes.ParseUpstreamTarget(rawDefaultTarget)
+	if err != nil {
+		return fmt.Errorf("invalid default target for %v: %w", p.metaData.Name, err)
+	}
+	p.defaultRoute = route{
+		name:      DefaultRouteConditionName,
+		rawTarget: rawDefaultTarget,
+		target:    defaultTarget,
+	}
+	if err := utils.ExtractStrParam(p.metaData.Parameters,
+		DefaultStripPathPrefixParam,
+		&p.defaultRoute.stripPathPrefix); err != nil {
+		log.Trace().Msgf("default strip path prefix not defined for %v", p.metaData.Name)
+	}
+
+	var routeNames []string
+	if err := utils.ExtractListOfStringParam(p.metaData.Parameters,
+		RoutesParam,
+		&routeNames); err != nil {
+		log.Trace().Msgf("routes not defined for %v, only default route is used",
+			p.metaData.Name)
+	}
+
+	paramMaps := make(map[string]map[string]string)
+	for _, paramName := range []string{
+		RouteTargetsParam,
+		RouteHostsParam,
+		RoutePathPrefixesParam,
+		RouteHeadersParam,
+		RouteStripPathPrefixesParam,
+		RouteWeightedTargetsParam,
+		RouteStickyHeadersParam,
+	} {
+		paramMaps[paramName] = make(map[string]string)
+		if err := utils.ExtractMapOfStringParam(p.metaData.Parameters,
+			paramName,
+			paramMaps[paramName]); err != nil {
+			log.Trace().Msgf("%v not defined for %v", paramName, p.metaData.Name)
+		}
+	}
+
+	for _, routeName := range routeNames {
+		parsedRoute, err := buildRoute(routeName, paramMaps)
+		if err != nil {
+			return fmt.Errorf("invalid route %v for %v: %w",
+				routeName, p.metaData.Name, err)
+		}
+		p.routes = append(p.routes, parsedRoute)
+	}
+	return nil
+}
+
+func buildRoute(
+	name string,
+	paramMaps map[string]map[string]string,
+) (route, error) {
+	if name == DefaultRouteConditionName {
+		return route{}, fmt.Errorf("route name %v is reserved", name)
+	}
+	parsedRoute := route{
+		name:            name,
+		stickyHeader:    paramMaps[RouteStickyHeadersParam][name],
+		stripPathPrefix: paramMaps[RouteStripPathPrefixesParam][name],
+		host:            paramMaps[RouteHostsParam][name],
+		pathPrefix:      paramMaps[RoutePathPrefixesParam][name],
+	}
+	rawTarget, hasTarget := paramMaps[RouteTargetsParam][name]
+	rawWeightedTargets, hasWeightedTargets := paramMaps[RouteWeightedTargetsParam][name]
+	var err error
+	switch {
+	case hasTarget && hasWeightedTargets:
+		return route{}, fmt.Errorf("both a target and weighted targets defined")
+	case hasTarget:
+		parsedRoute.rawTarget = rawTarget
+		parsedRoute.target, err = publictypes.ParseUpstreamTarget(rawTarget)
+	case hasWeightedTargets:
+		parsedRoute.weightedTargets, err = parseWeightedTargets(rawWeightedTargets)
+	default:
+		return route{}, fmt.Errorf("no target defined")
+	}
+	if err != nil {
+		return route{}, err
+	}
+	if parsedRoute.stickyHeader != "" && parsedRoute.weightedTargets == nil {
+		return route{}, fmt.Errorf("sticky header defined without weighted targets")
+	}
+	if rawHeader, found := paramMaps[RouteHeadersParam][name]; found {
+		parsedRoute.headerKey, parsedRoute.headerValue = utils.ExtractKeyValuePair(rawHeader)
+		if parsedRoute.headerKey == "" {
+			return route{}, fmt.Errorf("header predicate %v is not key=value", rawHeader)
+		}
+	}
+
+	if parsedRoute.host == "" && parsedRoute.pathPrefix == "" && parsedRoute.headerKey == "" {
+		return route{}, fmt.Errorf("no host, path prefix or header predicate defined")
+	}
+	return parsedRoute, nil
+}
+
+func (r route) matches(
+	apiStream publictypes.APIStreamI,
+	host string,
+	path string,
+) bool {
+	if r.host != "" && !strings.EqualFold(r.host, host) {
+		return false
+	}
+	if r.pathPrefix != "" && !strings.HasPrefix(path, r.pathPrefix) {
+		return false
+	}
+	if r.headerKey != "" && !apiStream.DoesHeaderValueMatch(r.headerKey, r.headerValue) {
+		return false
+	}
+	return true
+}
+
+// selectTarget selects the target of the route a request is sent to:
+// its single target, or one of its weighted targets
+func (r route) selectTarget(apiStream publictypes.APIStreamI) weightedTarget {
+	if r.weightedTargets == nil {
+		return weightedTarget{raw: r.rawTarget, target: r.target, weight: 1}
+	}
+	if r.stickyHeader != "" {
+		if clientKey, found := apiStream.GetHeader(r.stickyHeader); found && clientKey != "" {
+			return r.weightedTargets.sticky(clientKey)
+		}
+	}
+	return r.weightedTargets.next()
+}
+
+// upstreamFor returns the upstream target for a request with the given path,
+// stripping the route's path prefix and prepending the target's path
+func upstreamFor(
+	target *publictypes.UpstreamTarget,
+	stripPathPrefix string,
+	path string,
+) *publictypes.UpstreamTarget {
+	if stripPathPrefix != "" {
+		path = strings.TrimPrefix(path, stripPathPrefix)
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return &publictypes.UpstreamTarget{
+		Scheme: target.Scheme,
+		Host:   target.Host,
+		Port:   target.Port,
+		Path:   strings.TrimSuffix(target.Path, "/") + path,
+	}
+}
+
+// splitURL splits a URL such as `api.com/path/to/resource` into its host and path
+func splitURL(url string) (string, string) {
+	host, path, found := strings.Cut(url, "/")
+	if !found {
+		return host, "/"
+	}
+	return host, "/" + path
+}
@@ -0,0 +1,98 @@
+package processorroute
+
+import (
+	"fmt"
+	"hash/fnv"
+	publictypes "lunar/engine/streams/public-types"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	weightedTargetsSeparator = ","
+	targetWeightSeparator    = "="
+)
+
+type weightedTarget struct {
+	raw    string
+	target *publictypes.UpstreamTarget
+	weight int
+}
+
+// weightedTargets splits the requests of a route across its targets in
+// proportion to their weights, using smooth weighted round-robin: each
+// selection adds every target's weight to its current weight, takes the
+// target with the highest current weight and lowers it by the total weight.
+// The split is thus exact over every `totalWeight` consecutive selections,
+// with the targets interleaved rather than selected in bursts.
+type weightedTargets struct {
+	targets     []weightedTarget
+	totalWeight int
+
+	mutex          sync.Mutex
+	currentWeights []int
+}
+
+// parseWeightedTargets parses targets such as
+// `https://stable.api.com=90,https://canary.api.com=10`
+func parseWeightedTargets(raw string) (*weightedTargets, error) {
+	targets := &weightedTargets{}
+	for _, rawWeightedTarget := range strings.Split(raw, weightedTargetsSeparator) {
+		rawWeightedTarget = strings.TrimSpace(rawWeightedTarget)
+		separatorIndex := strings.LastIndex(rawWeightedTarget, targetWeightSeparator)
+		if separatorIndex == -1 {
+			return nil, fmt.Errorf("weighted target %v is not target=weight",
+				rawWeightedTarget)
+		}
+		rawTarget := strings.TrimSpace(rawWeightedTarget[:separatorIndex])
+		weight, err := strconv.Atoi(
+			strings.TrimSpace(rawWeightedTarget[separatorIndex+1:]))
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("weight of target %v must be a positive integer",
+				rawTarget)
+		}
+		target, err := publictypes.ParseUpstreamTarget(rawTarget)
+		if err != nil {
+			return nil, err
+		}
+		targets.targets = append(targets.targets, weightedTarget{
+			raw:    rawTarget,
+			target: target,
+			weight: weight,
+		})
+		targets.totalWeight += weight
+	}
+	targets.currentWeights = make([]int, len(targets.targets))
+	return targets, nil
+}
+
+// next selects the target of the next request in the round-robin
+func (targets *weightedTargets) next() weightedTarget {
+	targets.mutex.Lock()
+	defer targets.mutex.Unlock()
+	selected := 0
+	for index, target := range targets.targets {
+		targets.currentWeights[index] += target.weight
+		if targets.currentWeights[index] > targets.currentWeights[selected] {
+			selected = index
+		}
+	}
+	targets.currentWeights[selected] -= targets.totalWeight
+	return targets.targets[selected]
+}
+
+// sticky selects the same target for every request of a client, clients
+// being spread across the targets in proportion to their weights
+func (targets *weightedTargets) sticky(clientKey string) weightedTarget {
+	hash := fnv.New32a()
+	hash.Write([]byte(clientKey))
+	point := int(hash.Sum32() % uint32(targets.totalWeight))
+	for _, target := range targets.targets {
+		if point < target.weight {
+			return target
+		}
+		point -= target.weight
+	}
+	return targets.targets[len(targets.targets)-1]
+}
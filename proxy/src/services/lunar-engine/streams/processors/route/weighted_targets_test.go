@@ -0,0 +1,113 @@
+package processorroute
+
+import (
+	"context"
+	"lunar/engine/messages"
+	publictypes "lunar/engine/streams/public-types"
+	streamtypes "lunar/engine/streams/types"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestWeightedTargetsInterleaveSelections(t *testing.T) {
+	targets, err := parseWeightedTargets(
+		"https://a.com=5, https://b.com=1, https://c.com=1")
+	require.NoError(t, err)
+
+	var selected []string
+	for i := 0; i < 7; i++ {
+		selected = append(selected, targets.next().raw)
+	}
+	require.Equal(t, []string{
+		"https://a.com", "https://a.com", "https://b.com", "https://a.com",
+		"https://c.com", "https://a.com", "https://a.com",
+	}, selected)
+}
+
+func TestWeightedTargetsSplitIsExactUnderConcurrency(t *testing.T) {
+	targets, err := parseWeightedTargets("https://stable.com=9,https://canary.com=1")
+	require.NoError(t, err)
+
+	const workers, selectionsPerWorker = 10, 100
+	counts := map[string]int{}
+	var mutex sync.Mutex
+	var waitGroup sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for i := 0; i < selectionsPerWorker; i++ {
+				raw := targets.next().raw
+				mutex.Lock()
+				counts[raw]++
+				mutex.Unlock()
+			}
+		}()
+	}
+	waitGroup.Wait()
+
+	require.Equal(t, map[string]int{
+		"https://stable.com": 900,
+		"https://canary.com": 100,
+	}, counts)
+}
+
+func TestRouteProcessorCountsSelectionsPerTarget(t *testing.T) {
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	params := map[string]streamtypes.ProcessorParam{}
+	for name, value := range map[string]interface{}{
+		RoutesParam:     []string{"split"},
+		RouteHostsParam: map[string]interface{}{"split": "api.com"},
+		RouteWeightedTargetsParam: map[string]interface{}{
+			"split": "https://stable.com=3,https://canary.com=1",
+		},
+		DefaultTargetParam: "https://default.com",
+	} {
+		params[name] = streamtypes.ProcessorParam{
+			Name:  name,
+			Value: publictypes.NewParamValue(value),
+		}
+	}
+	processor, err := newProcessor(&streamtypes.ProcessorMetaData{
+		Name:       "router",
+		Parameters: params,
+	}, meter)
+	require.NoError(t, err)
+
+	for _, url := range []string{
+		"api.com/users", "api.com/users", "api.com/users", "api.com/users",
+		"other.com/users",
+	} {
+		_, err := processor.Execute(streamtypes.NewRequestAPIStream(messages.OnRequest{
+			Method:  "GET",
+			URL:     url,
+			Headers: map[string]string{},
+		}))
+		require.NoError(t, err)
+	}
+
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+	require.Len(t, resourceMetrics.ScopeMetrics, 1)
+	require.Len(t, resourceMetrics.ScopeMetrics[0].Metrics, 1)
+	selections := resourceMetrics.ScopeMetrics[0].Metrics[0]
+	require.Equal(t, targetSelectionsMetricName, selections.Name)
+	sum, ok := selections.Data.(metricdata.Sum[int64])
+	require.True(t, ok)
+	counts := map[string]int64{}
+	for _, point := range sum.DataPoints {
+		route, _ := point.Attributes.Value(routeAttributeName)
+		target, _ := point.Attributes.Value(targetAttributeName)
+		counts[route.AsString()+" "+target.AsString()] = point.Value
+	}
+	require.Equal(t, map[string]int64{
+		"split https://stable.com":    3,
+		"split https://canary.com":    1,
+		"default https://default.com": 1,
+	}, counts)
+}
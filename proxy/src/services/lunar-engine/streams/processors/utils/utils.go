@@ -97,6 +97,22 @@ func ExtractMapOfIntParam(
 	return nil
 }
 
+func ExtractMapOfStringParam(
+	metaData map[string]streamtypes.ProcessorParam,
+	paramName string,
+	result map[string]string,
+) error {
+	val, err := extractInput(metaData, paramName, &result)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range val.GetMapOfString() {
+		result[k] = v
+	}
+	return nil
+}
+
 func ExtractListOfStringParam(
 	metaData map[string]streamtypes.ProcessorParam,
 	paramName string,
@@ -6,6 +6,9 @@ type ContextI interface {
 	Set(string, interface{}) error
 	Get(string) (interface{}, error)
 	Pop(string) (interface{}, error)
+	// Update atomically replaces the value with the result of the given function,
+	// which is called with the current value (nil if it does not exist)
+	Update(string, func(interface{}) interface{}) error
 
 	Exists(string) bool
 }
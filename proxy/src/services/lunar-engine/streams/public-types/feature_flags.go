@@ -0,0 +1,11 @@
+package publictypes
+
+// FeatureFlagsI is a runtime source of flags processors opt in to read,
+// which operators change without reloading the flows.
+// Reads return the given default if the flag is not set
+// or is not of the read type.
+type FeatureFlagsI interface {
+	GetBool(name string, defaultValue bool) bool
+	GetInt(name string, defaultValue int) int
+	GetString(name string, defaultValue string) string
+}
@@ -3,6 +3,15 @@ package publictypes
 type ProcessorDataI interface {
 	ParamMap() map[string]*ParamValue
 	GetName() string
+	GetRetryPolicy() *RetryPolicy
+}
+
+// RetryPolicy re-executes a failing processor within its flow.
+// Attempts is the number of retries after the first execution,
+// the backoff between them doubles after each retry.
+type RetryPolicy struct {
+	Attempts      int `yaml:"attempts"`
+	BackoffMillis int `yaml:"backoff_millis"`
 }
 
 type ConfigurationParamTypes string
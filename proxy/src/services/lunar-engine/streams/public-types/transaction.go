@@ -11,6 +11,11 @@ type TransactionI interface {
 	Size() int
 	GetID() string
 	GetSequenceID() string
+	GetConnectionID() string
+	// The verified client certificate of a mutual TLS connection,
+	// empty for connections which are not mutual TLS
+	GetClientCertSubject() string
+	GetClientCertFingerprint() string
 	GetMethod() string
 	GetURL() string
 	GetStatus() int
@@ -18,6 +23,9 @@ type TransactionI interface {
 	GetHeaders() map[string]string
 	GetBody() string
 	GetTime() time.Time
+	// Until when the client waits for the response,
+	// zero unless it told by the request timeout header
+	GetDeadline() time.Time
 }
 
 type APIStreamI interface {
@@ -38,4 +46,7 @@ type APIStreamI interface {
 	SetResponse(response TransactionI)
 	SetContext(context LunarContextI)
 	SetType(streamType StreamType)
+	GetAcceptEncoding() string
+	GetUpstreamTarget() *UpstreamTarget
+	SetUpstreamTarget(target *UpstreamTarget)
 }
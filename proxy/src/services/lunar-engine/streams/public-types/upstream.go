@@ -0,0 +1,56 @@
+package publictypes
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+const (
+	httpsScheme      = "https"
+	httpScheme       = "http"
+	defaultHTTPSPort = 443
+	defaultHTTPPort  = 80
+)
+
+// UpstreamTarget is the effective upstream a request is forwarded to
+type UpstreamTarget struct {
+	Scheme string
+	Host   string
+	Port   int
+	Path   string
+}
+
+// ParseUpstreamTarget parses a target such as `https://api.com:8443/v2`.
+// The port defaults to the default port of the scheme.
+func ParseUpstreamTarget(rawTarget string) (*UpstreamTarget, error) {
+	parsed, err := url.Parse(rawTarget)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream target %s: %w", rawTarget, err)
+	}
+	if parsed.Scheme != httpsScheme && parsed.Scheme != httpScheme {
+		return nil, fmt.Errorf("upstream target %s must use http or https", rawTarget)
+	}
+	if parsed.Hostname() == "" {
+		return nil, fmt.Errorf("upstream target %s has no host", rawTarget)
+	}
+
+	target := &UpstreamTarget{
+		Scheme: parsed.Scheme,
+		Host:   parsed.Hostname(),
+		Path:   parsed.Path,
+	}
+	if parsed.Port() == "" {
+		target.Port = defaultHTTPPort
+		if target.Scheme == httpsScheme {
+			target.Port = defaultHTTPSPort
+		}
+		return target, nil
+	}
+
+	target.Port, err = strconv.Atoi(parsed.Port())
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in upstream target %s: %w", rawTarget, err)
+	}
+	return target, nil
+}
@@ -5,6 +5,7 @@ import (
 	streamconfig "lunar/engine/streams/config"
 	internal_types "lunar/engine/streams/internal-types"
 	publictypes "lunar/engine/streams/public-types"
+	streamtypes "lunar/engine/streams/types"
 
 	"github.com/rs/zerolog/log"
 )
@@ -37,7 +38,17 @@ func (s *Stream) ExecuteFlow(
 ) error {
 	procIO, err := node.GetProcessor().Execute(apiStream)
 	if err != nil {
-		return fmt.Errorf("failed to execute processor %s: %w", node.GetProcessorKey(), err)
+		if !hasErrorEdge(node) {
+			return fmt.Errorf("failed to execute processor %s: %w", node.GetProcessorKey(), err)
+		}
+		// The flow handles the error, such as by an error response
+		log.Debug().Err(err).Msgf("Processor %s failed, routing to %s condition",
+			node.GetProcessorKey(), streamtypes.ErrorConditionName)
+		streamtypes.RecordProcessorError(apiStream, node.GetProcessorKey(), err)
+		procIO = streamtypes.ProcessorIO{
+			Type: apiStream.GetType(),
+			Name: streamtypes.ErrorConditionName,
+		}
 	}
 
 	log.Debug().Msgf("Executed processor %s. ProcIO: %+v", node.GetProcessorKey(), procIO)
@@ -85,3 +96,14 @@ func (s *Stream) ExecuteFlow(
 	}
 	return nil
 }
+
+// hasErrorEdge returns whether the flow connects the error condition
+// of the node's processor
+func hasErrorEdge(node internal_types.FlowGraphNodeI) bool {
+	for _, edge := range node.GetEdges() {
+		if edge.GetCondition() == streamtypes.ErrorConditionName {
+			return true
+		}
+	}
+	return false
+}
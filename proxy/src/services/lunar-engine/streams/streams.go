@@ -46,6 +46,13 @@ func (s *Stream) WithHub(hub *communication.HubCommunication) *Stream {
 	return s
 }
 
+// WithFeatureFlags sets the feature flags the processors of the flows read,
+// which are kept as they are when the flows are reloaded
+func (s *Stream) WithFeatureFlags(featureFlags publictypes.FeatureFlagsI) *Stream {
+	s.processorsManager.SetFeatureFlags(featureFlags)
+	return s
+}
+
 // Initialize initializes the stream engine by creating flows from the stream config.
 func (s *Stream) Initialize() error {
 	log.Info().Msg("Initializing stream engine")
@@ -105,6 +112,10 @@ func (s *Stream) ExecuteFlow(
 
 	apiStream.SetContext(flow.GetExecutionContext())
 	defer flow.CleanExecution()
+	if apiStream.GetType().IsResponseType() {
+		// the response ends the transaction, and with it its retry budget
+		defer processors.ReleaseRetryBudget(apiStream.GetID())
+	}
 
 	log.Trace().Msgf("Flow %v found for %v", flow.GetName(), apiStream.GetURL())
 	var flowDir internal_types.FlowDirectionI
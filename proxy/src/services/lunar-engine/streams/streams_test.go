@@ -1,10 +1,13 @@
 package streams
 
 import (
+	"errors"
+	"lunar/engine/actions"
 	"lunar/engine/messages"
 	streamconfig "lunar/engine/streams/config"
 	testprocessors "lunar/engine/streams/flow/test-processors"
 	"lunar/engine/streams/processors"
+	processorerrorresponse "lunar/engine/streams/processors/error-response"
 	filterprocessor "lunar/engine/streams/processors/filter-processor"
 	publictypes "lunar/engine/streams/public-types"
 	streamtypes "lunar/engine/streams/types"
@@ -323,6 +326,72 @@ func TestFilterProcessorFlow(t *testing.T) {
 	require.Equal(t, []string{"LogAPM"}, execOrder, "Execution order is not correct")
 }
 
+func TestErrorResponseFlow(t *testing.T) {
+	procMng := createTestProcessorManagerWithFactories(t, []string{"failingProcessor", "errorResponse", "LogAPM"},
+		testprocessors.NewMockFailingProcessor,
+		processorerrorresponse.NewProcessor,
+		testprocessors.NewMockProcessor,
+	)
+	stream := NewStream()
+	stream.processorsManager = procMng
+
+	flowReps := createFlowRepresentation(t, "error-response-test-case")
+	err := stream.createFlows(flowReps)
+	require.NoError(t, err, "Failed to create flows")
+
+	globalContext := streamtypes.NewContextManager().GetGlobalContext()
+	err = globalContext.Set(testprocessors.GlobalKeyExecutionOrder, []string{})
+	require.NoError(t, err, "Failed to set global context value")
+
+	apiStream := streamtypes.NewAPIStream("APIStreamName", publictypes.StreamTypeRequest)
+	apiStream.SetRequest(streamtypes.NewRequest(messages.OnRequest{
+		ID:      "error-response-txn",
+		Method:  "GET",
+		Scheme:  "https",
+		URL:     "maps.googleapis.com/maps/api/geocode/json",
+		Headers: map[string]string{},
+	}))
+	flowActions := &streamconfig.StreamActions{
+		Request:  &streamconfig.RequestStream{},
+		Response: &streamconfig.ResponseStream{},
+	}
+
+	// The failure is handled by the flow instead of aborting it
+	err = stream.ExecuteFlow(apiStream, flowActions)
+	require.NoError(t, err, "Failed to execute flow")
+
+	execOrder, err := globalContext.Get(testprocessors.GlobalKeyExecutionOrder)
+	require.NoError(t, err, "Failed to get global context value")
+	require.Equal(t, []string{"failingProcessor", "LogAPM"}, execOrder, "Execution order is not correct")
+
+	require.Len(t, flowActions.Request.Actions, 1)
+	require.Equal(t, &actions.EarlyResponseAction{
+		Status:  503,
+		Body:    `{"message": "quota store \"main\" is unavailable", "source": "failingProcessor", "code": 503}`,
+		Headers: map[string]string{"Content-Type": "application/json"},
+	}, flowActions.Request.Actions[0])
+
+	processorError, found := streamtypes.LatestProcessorError(apiStream)
+	require.True(t, found)
+	require.Equal(t, streamtypes.ProcessorError{
+		Processor:     "failingProcessor",
+		Message:       testprocessors.FailingProcessorMessage,
+		Code:          503,
+		TransactionID: "error-response-txn",
+	}, processorError)
+}
+
+func TestProcessorErrorsAreBounded(t *testing.T) {
+	apiStream := createAPIStreamForContextTest()
+	apiStream.SetContext(streamtypes.NewContextManager().WithFlowContext().GetLunarContext())
+	for i := 0; i < streamtypes.MaxProcessorErrors+5; i++ {
+		streamtypes.RecordProcessorError(apiStream, "processor", errors.New("failed"))
+	}
+	processorErrors, err := apiStream.GetContext().GetGlobalContext().Get(streamtypes.ProcessorErrorsKey)
+	require.NoError(t, err)
+	require.Len(t, processorErrors, streamtypes.MaxProcessorErrors)
+}
+
 func createTestProcessorManager(t *testing.T, processorNames []string) *processors.ProcessorManager {
 	return createTestProcessorManagerWithFactories(t, processorNames, testprocessors.NewMockProcessor)
 }
@@ -428,3 +497,39 @@ func runContextTest(t *testing.T, stream *Stream, apiStream publictypes.APIStrea
 func getExecutionContext(stream *Stream, apiStream publictypes.APIStreamI) publictypes.LunarContextI {
 	return stream.filterTree.GetFlow(apiStream).GetExecutionContext()
 }
+
+func TestParallelProcessorsFlow(t *testing.T) {
+	procMng := createTestProcessorManager(t, []string{"readXXX", "writeXXX", "removePII", "LogAPM"})
+	stream := NewStream()
+	stream.processorsManager = procMng
+
+	flowReps := createFlowRepresentation(t, "parallel-processors-test-case")
+	err := stream.createFlows(flowReps)
+	require.NoError(t, err, "Failed to create flows")
+
+	contextManager := streamtypes.NewContextManager()
+	globalContext := contextManager.GetGlobalContext()
+	err = globalContext.Set(testprocessors.GlobalKeyExecutionOrder, []string{})
+	require.NoError(t, err, "Failed to set global context value")
+
+	apiStream := streamtypes.NewAPIStream("APIStreamName", publictypes.StreamTypeRequest)
+	apiStream.SetRequest(streamtypes.NewRequest(messages.OnRequest{
+		Method:  "GET",
+		Scheme:  "https",
+		URL:     "maps.googleapis.com/maps/api/geocode/json",
+		Headers: map[string]string{},
+	}))
+	flowActions := &streamconfig.StreamActions{
+		Request: &streamconfig.RequestStream{},
+	}
+
+	err = stream.ExecuteFlow(apiStream, flowActions)
+	require.NoError(t, err, "Failed to execute flow")
+
+	// The parallel processors complete in any order, before the flow proceeds
+	execOrder, err := globalContext.Get(testprocessors.GlobalKeyExecutionOrder)
+	require.NoError(t, err, "Failed to get global context value")
+	require.Len(t, execOrder, 4)
+	require.ElementsMatch(t, []string{"readXXX", "writeXXX", "removePII"}, execOrder.([]string)[:3])
+	require.Equal(t, "LogAPM", execOrder.([]string)[3])
+}
@@ -8,13 +8,16 @@ import (
 
 var _ publictypes.ContextI = &contextMemory{}
 
+// contextMemory is safe for concurrent use,
+// as processors of a flow may be executed in parallel
 type contextMemory struct {
-	ctx sync.Map
+	mutex sync.RWMutex
+	ctx   map[string]interface{}
 }
 
 // NewContext creates a new memory context
 func NewContext() publictypes.ContextI {
-	return &contextMemory{}
+	return &contextMemory{ctx: map[string]interface{}{}}
 }
 
 // Set stores a value in the context
@@ -23,13 +26,17 @@ func (c *contextMemory) Set(key string, value interface{}) error {
 		return fmt.Errorf("key cannot be empty")
 	}
 
-	c.ctx.Store(key, value)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ctx[key] = value
 	return nil
 }
 
 // Get retrieves a value from the context.
 func (c *contextMemory) Get(key string) (interface{}, error) {
-	val, found := c.ctx.Load(key)
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	val, found := c.ctx[key]
 	if !found {
 		return nil, fmt.Errorf("key %s not found", key)
 	}
@@ -38,16 +45,35 @@ func (c *contextMemory) Get(key string) (interface{}, error) {
 
 // Exists checks if a key exists in the context
 func (c *contextMemory) Exists(key string) bool {
-	_, found := c.ctx.Load(key)
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	_, found := c.ctx[key]
 	return found
 }
 
 // Pop removes a value from the context and returns it
 func (c *contextMemory) Pop(key string) (interface{}, error) {
-	val, found := c.ctx.LoadAndDelete(key)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	val, found := c.ctx[key]
 	if !found {
 		return nil, fmt.Errorf("key %s not found", key)
 	}
-
+	delete(c.ctx, key)
 	return val, nil
 }
+
+// Update replaces a value in the context with the result of the given function
+func (c *contextMemory) Update(
+	key string,
+	update func(interface{}) interface{},
+) error {
+	if key == "" {
+		return fmt.Errorf("key cannot be empty")
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ctx[key] = update(c.ctx[key])
+	return nil
+}
@@ -0,0 +1,182 @@
+package streamtypes
+
+import (
+	"fmt"
+	publictypes "lunar/engine/streams/public-types"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Ensure interface is implemented
+var _ publictypes.FeatureFlagsI = &FeatureFlags{}
+
+// FeatureFlags keeps the feature flags in memory.
+// Changes replace the flags at once, so reads on the hot path
+// load them without taking a lock.
+type FeatureFlags struct {
+	flags atomic.Pointer[map[string]interface{}]
+	// serializes changes, so none of them is lost
+	mutex sync.Mutex
+}
+
+func NewFeatureFlags() *FeatureFlags {
+	featureFlags := &FeatureFlags{
+		flags: atomic.Pointer[map[string]interface{}]{},
+		mutex: sync.Mutex{},
+	}
+	featureFlags.flags.Store(&map[string]interface{}{})
+	return featureFlags
+}
+
+// GetBool implements publictypes.FeatureFlagsI.
+func (flags *FeatureFlags) GetBool(name string, defaultValue bool) bool {
+	switch value := flags.get(name).(type) {
+	case bool:
+		return value
+	case string:
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+// GetInt implements publictypes.FeatureFlagsI.
+func (flags *FeatureFlags) GetInt(name string, defaultValue int) int {
+	switch value := flags.get(name).(type) {
+	case int:
+		return value
+	case float64:
+		if value == math.Trunc(value) {
+			return int(value)
+		}
+	case string:
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+// GetString implements publictypes.FeatureFlagsI.
+func (flags *FeatureFlags) GetString(name string, defaultValue string) string {
+	value := flags.get(name)
+	if value == nil {
+		return defaultValue
+	}
+	if value, valid := value.(string); valid {
+		return value
+	}
+	return fmt.Sprint(value)
+}
+
+// All returns a copy of the feature flags
+func (flags *FeatureFlags) All() map[string]interface{} {
+	current := *flags.flags.Load()
+	all := make(map[string]interface{}, len(current))
+	for name, value := range current {
+		all[name] = value
+	}
+	return all
+}
+
+// Set sets the given flags, keeping the other flags as they are
+func (flags *FeatureFlags) Set(changed map[string]interface{}) error {
+	if err := validateFeatureFlags(changed); err != nil {
+		return err
+	}
+	flags.update(func(updated map[string]interface{}) {
+		for name, value := range changed {
+			updated[name] = value
+		}
+	})
+	return nil
+}
+
+// Replace replaces all the flags with the given ones
+func (flags *FeatureFlags) Replace(replacing map[string]interface{}) error {
+	if err := validateFeatureFlags(replacing); err != nil {
+		return err
+	}
+	flags.update(func(updated map[string]interface{}) {
+		for name := range updated {
+			delete(updated, name)
+		}
+		for name, value := range replacing {
+			updated[name] = value
+		}
+	})
+	return nil
+}
+
+// Delete removes the given flags, so their reads return the default
+func (flags *FeatureFlags) Delete(names ...string) {
+	flags.update(func(updated map[string]interface{}) {
+		for _, name := range names {
+			delete(updated, name)
+		}
+	})
+}
+
+func (flags *FeatureFlags) get(name string) interface{} {
+	return (*flags.flags.Load())[name]
+}
+
+func (flags *FeatureFlags) update(change func(map[string]interface{})) {
+	flags.mutex.Lock()
+	defer flags.mutex.Unlock()
+	previous := *flags.flags.Load()
+	updated := flags.All()
+	change(updated)
+	flags.flags.Store(&updated)
+	logFeatureFlagsChanges(previous, updated)
+}
+
+func validateFeatureFlags(flags map[string]interface{}) error {
+	for name, value := range flags {
+		if name == "" {
+			return fmt.Errorf("feature flag name is required")
+		}
+		switch value.(type) {
+		case bool, int, float64, string:
+		default:
+			return fmt.Errorf("feature flag %s must be a boolean, number or string, got %T",
+				name, value)
+		}
+	}
+	return nil
+}
+
+func logFeatureFlagsChanges(previous, updated map[string]interface{}) {
+	names := make([]string, 0, len(previous)+len(updated))
+	for name := range previous {
+		names = append(names, name)
+	}
+	for name := range updated {
+		if _, found := previous[name]; !found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		previousValue, wasSet := previous[name]
+		value, isSet := updated[name]
+		switch {
+		case !isSet:
+			log.Info().Str("flag", name).Interface("previous", previousValue).
+				Msg("Feature flag removed")
+		case !wasSet:
+			log.Info().Str("flag", name).Interface("value", value).
+				Msg("Feature flag set")
+		case previousValue != value:
+			log.Info().Str("flag", name).Interface("previous", previousValue).
+				Interface("value", value).Msg("Feature flag changed")
+		}
+	}
+}
@@ -13,6 +13,7 @@ type ProcessorDefinition struct {
 	Parameters    map[string]ProcessorParamDefinition `yaml:"parameters"`
 	OutputStreams []ProcessorIO                       `yaml:"output_streams"`
 	InputStream   ProcessorIO                         `yaml:"input_stream"`
+	Idempotent    bool                                `yaml:"idempotent"` // can be retried
 	Data          network.ConfigurationPayload
 }
 
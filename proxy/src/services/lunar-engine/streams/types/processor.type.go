@@ -20,4 +20,6 @@ type ProcessorMetaData struct {
 	Parameters          map[string]ProcessorParam
 	Resources           publictypes.ResourceManagementI
 	Clock               publictypes.ClockI
+	RetryPolicy         *publictypes.RetryPolicy
+	FeatureFlags        publictypes.FeatureFlagsI
 }
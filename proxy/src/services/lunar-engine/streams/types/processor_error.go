@@ -0,0 +1,107 @@
+package streamtypes
+
+import (
+	"errors"
+	publictypes "lunar/engine/streams/public-types"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// ErrorConditionName is the condition a failing processor routes to,
+	// if its flow connects it
+	ErrorConditionName = "error"
+	// ProcessorErrorsKey holds the latest errors processors failed with
+	// in the global context, oldest first
+	ProcessorErrorsKey = "lunar.processor_errors"
+	// MaxProcessorErrors bounds the errors kept in the global context
+	MaxProcessorErrors = 16
+	// DefaultProcessorErrorCode is the code of errors which are not CodedError
+	DefaultProcessorErrorCode = http.StatusInternalServerError
+)
+
+// ProcessorError is an error a processor failed with, recorded in the
+// global context for the flow to handle it, such as by an error response
+type ProcessorError struct {
+	Processor     string
+	Message       string
+	Code          int
+	TransactionID string
+}
+
+// CodedError is an error a processor fails with along with the status code
+// it maps to, processors failing with any other error map to
+// DefaultProcessorErrorCode
+type CodedError struct {
+	Code int
+	Err  error
+}
+
+func NewCodedError(code int, err error) *CodedError {
+	return &CodedError{Code: code, Err: err}
+}
+
+func (err *CodedError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *CodedError) Unwrap() error {
+	return err.Err
+}
+
+// RecordProcessorError records the error the processor failed with on the
+// given stream, only keeping the latest MaxProcessorErrors errors
+func RecordProcessorError(
+	apiStream publictypes.APIStreamI,
+	processor string,
+	err error,
+) {
+	processorError := ProcessorError{
+		Processor:     processor,
+		Message:       err.Error(),
+		Code:          DefaultProcessorErrorCode,
+		TransactionID: apiStream.GetID(),
+	}
+	var codedError *CodedError
+	if errors.As(err, &codedError) {
+		processorError.Code = codedError.Code
+	}
+
+	if apiStream.GetContext() == nil {
+		log.Debug().Err(err).Msgf("No context to record error of processor %s", processor)
+		return
+	}
+	updateErr := apiStream.GetContext().GetGlobalContext().Update(ProcessorErrorsKey,
+		func(value interface{}) interface{} {
+			processorErrors, _ := value.([]ProcessorError)
+			processorErrors = append(processorErrors, processorError)
+			if len(processorErrors) > MaxProcessorErrors {
+				processorErrors = processorErrors[len(processorErrors)-MaxProcessorErrors:]
+			}
+			// A new slice is stored, so readers of a previous one are not affected
+			return append([]ProcessorError{}, processorErrors...)
+		})
+	if updateErr != nil {
+		log.Error().Err(updateErr).Msgf("Failed to record error of processor %s", processor)
+	}
+}
+
+// LatestProcessorError returns the latest error a processor failed with
+// on the given stream, if any of the recorded errors is of it
+func LatestProcessorError(apiStream publictypes.APIStreamI) (ProcessorError, bool) {
+	if apiStream.GetContext() == nil {
+		return ProcessorError{}, false
+	}
+	value, err := apiStream.GetContext().GetGlobalContext().Get(ProcessorErrorsKey)
+	if err != nil {
+		return ProcessorError{}, false
+	}
+	processorErrors, _ := value.([]ProcessorError)
+	for index := len(processorErrors) - 1; index >= 0; index-- {
+		if processorErrors[index].TransactionID == apiStream.GetID() {
+			return processorErrors[index], true
+		}
+	}
+	return ProcessorError{}, false
+}
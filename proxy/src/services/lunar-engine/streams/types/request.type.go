@@ -1,22 +1,26 @@
 package streamtypes
 
 import (
+	"lunar/engine/messages"
 	"net/url"
 	"time"
 )
 
 type OnRequest struct {
-	id          string
-	sequenceID  string
-	method      string
-	scheme      string
-	url         string
-	path        string
-	query       string
-	headers     map[string]string
-	body        string
-	time        time.Time
-	parsedURL   *url.URL
-	parsedQuery url.Values
-	size        int
+	id           string
+	sequenceID   string
+	connectionID string
+	clientCert   messages.ClientCertificate
+	method       string
+	scheme       string
+	url          string
+	path         string
+	query        string
+	headers      map[string]string
+	body         string
+	time         time.Time
+	deadline     time.Time
+	parsedURL    *url.URL
+	parsedQuery  url.Values
+	size         int
 }
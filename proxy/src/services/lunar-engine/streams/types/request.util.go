@@ -4,8 +4,9 @@ import (
 	"fmt"
 	"lunar/engine/messages"
 	publictypes "lunar/engine/streams/public-types"
+	"lunar/engine/utils/bodysize"
+	sharedConfig "lunar/shared-model/config"
 	"net/url"
-	"strconv"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -21,16 +22,19 @@ func NewRequestAPIStream(onRequest messages.OnRequest) publictypes.APIStreamI {
 
 func NewRequest(onRequest messages.OnRequest) publictypes.TransactionI {
 	return &OnRequest{
-		id:         onRequest.ID,
-		sequenceID: onRequest.SequenceID,
-		method:     onRequest.Method,
-		scheme:     onRequest.Scheme,
-		url:        onRequest.URL,
-		path:       onRequest.Path,
-		query:      onRequest.Query,
-		headers:    onRequest.Headers,
-		body:       onRequest.Body,
-		time:       onRequest.Time,
+		id:           onRequest.ID,
+		sequenceID:   onRequest.SequenceID,
+		connectionID: onRequest.ConnectionID,
+		clientCert:   onRequest.ClientCertificate,
+		method:       onRequest.Method,
+		scheme:       onRequest.Scheme,
+		url:          onRequest.URL,
+		path:         onRequest.Path,
+		query:        onRequest.Query,
+		headers:      onRequest.Headers,
+		body:         onRequest.Body,
+		time:         onRequest.Time,
+		deadline:     onRequest.Deadline.At,
 	}
 }
 
@@ -39,12 +43,8 @@ func (req *OnRequest) init() error {
 		return nil
 	}
 
-	if sizeStr, ok := req.headers["Content-Length"]; ok {
-		size, _ := strconv.Atoi(sizeStr)
-		req.size = size
-	} else {
-		req.size = len(req.body)
-	}
+	req.size = int(bodysize.EstimateInMemory(sharedConfig.HeaderMap(req.headers),
+		req.body, bodysize.EstimationContentLength).Bytes)
 
 	urlWithQueryString := fmt.Sprintf(
 		"%s://%s?%s",
@@ -111,6 +111,22 @@ func (req *OnRequest) GetSequenceID() string {
 	return req.sequenceID
 }
 
+func (req *OnRequest) GetClientCertSubject() string {
+	return req.clientCert.Subject
+}
+
+func (req *OnRequest) GetClientCertFingerprint() string {
+	return req.clientCert.Fingerprint
+}
+
+func (req *OnRequest) GetDeadline() time.Time {
+	return req.deadline
+}
+
+func (req *OnRequest) GetConnectionID() string {
+	return req.connectionID
+}
+
 func (req *OnRequest) GetMethod() string {
 	return req.method
 }
@@ -1,15 +1,20 @@
 package streamtypes
 
-import "time"
+import (
+	"lunar/engine/messages"
+	"time"
+)
 
 type OnResponse struct {
-	id         string
-	sequenceID string
-	method     string
-	url        string
-	status     int
-	size       int
-	headers    map[string]string
-	body       string
-	time       time.Time
+	id           string
+	sequenceID   string
+	connectionID string
+	clientCert   messages.ClientCertificate
+	method       string
+	url          string
+	status       int
+	size         int
+	headers      map[string]string
+	body         string
+	time         time.Time
 }
@@ -10,14 +10,16 @@ import (
 
 func NewResponse(onResponse messages.OnResponse) publictypes.TransactionI {
 	return &OnResponse{
-		id:         onResponse.ID,
-		sequenceID: onResponse.SequenceID,
-		method:     onResponse.Method,
-		url:        onResponse.URL,
-		status:     onResponse.Status,
-		headers:    onResponse.Headers,
-		body:       onResponse.Body,
-		time:       onResponse.Time,
+		id:           onResponse.ID,
+		sequenceID:   onResponse.SequenceID,
+		connectionID: onResponse.ConnectionID,
+		clientCert:   onResponse.ClientCertificate,
+		method:       onResponse.Method,
+		url:          onResponse.URL,
+		status:       onResponse.Status,
+		headers:      onResponse.Headers,
+		body:         onResponse.Body,
+		time:         onResponse.Time,
 	}
 }
 
@@ -71,6 +73,23 @@ func (res *OnResponse) GetSequenceID() string {
 	return res.sequenceID
 }
 
+func (res *OnResponse) GetClientCertSubject() string {
+	return res.clientCert.Subject
+}
+
+func (res *OnResponse) GetClientCertFingerprint() string {
+	return res.clientCert.Fingerprint
+}
+
+// GetDeadline is zero, as the deadline is of the request
+func (res *OnResponse) GetDeadline() time.Time {
+	return time.Time{}
+}
+
+func (res *OnResponse) GetConnectionID() string {
+	return res.connectionID
+}
+
 func (res *OnResponse) GetMethod() string {
 	return res.method
 }
@@ -106,7 +125,11 @@ func (res *OnResponse) GetTime() time.Time {
 // NewResponseAPIStream creates a new APIStream with the given OnResponse
 func NewResponseAPIStream(onResponse messages.OnResponse) publictypes.APIStreamI {
 	name := fmt.Sprintf("ResponseAPIStream-%s", onResponse.ID)
-	apiStream := NewAPIStream(name, publictypes.StreamTypeResponse)
+	apiStream := &APIStream{ //nolint:exhaustruct
+		name:           name,
+		streamType:     publictypes.StreamTypeResponse,
+		acceptEncoding: onResponse.AcceptEncoding,
+	}
 	apiStream.SetResponse(NewResponse(onResponse))
 	return apiStream
 }
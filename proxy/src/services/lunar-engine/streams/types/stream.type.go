@@ -2,8 +2,11 @@ package streamtypes
 
 import (
 	publictypes "lunar/engine/streams/public-types"
+	"strings"
 )
 
+const acceptEncodingHeader = "Accept-Encoding"
+
 type APIStream struct {
 	name       string
 	streamType publictypes.StreamType
@@ -11,6 +14,9 @@ type APIStream struct {
 	response   publictypes.TransactionI
 	context    publictypes.LunarContextI
 	resources  publictypes.ResourceManagementI
+	upstream   *publictypes.UpstreamTarget
+	// Set on response streams, where the request headers are not available
+	acceptEncoding string
 }
 
 // NewAPIStream creates a new APIStream with the given name and StreamType
@@ -113,6 +119,29 @@ func (s *APIStream) SetType(streamType publictypes.StreamType) {
 	s.streamType = streamType
 }
 
+// GetUpstreamTarget returns the upstream target set by the flow,
+// nil if the request is forwarded to its original destination
+func (s *APIStream) GetUpstreamTarget() *publictypes.UpstreamTarget {
+	return s.upstream
+}
+
+func (s *APIStream) SetUpstreamTarget(target *publictypes.UpstreamTarget) {
+	s.upstream = target
+}
+
+// GetAcceptEncoding returns the Accept-Encoding header of the request
+func (s *APIStream) GetAcceptEncoding() string {
+	if s.streamType.IsResponseType() || s.request == nil {
+		return s.acceptEncoding
+	}
+	for name, value := range s.request.GetHeaders() {
+		if strings.EqualFold(name, acceptEncodingHeader) {
+			return value
+		}
+	}
+	return ""
+}
+
 func DoesHeaderExist(headers map[string]string, headerName string) bool {
 	_, found := headers[headerName]
 	return found
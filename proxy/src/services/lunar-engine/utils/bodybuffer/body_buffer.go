@@ -0,0 +1,144 @@
+package bodybuffer
+
+import (
+	"fmt"
+	"io"
+	"lunar/engine/utils/environment"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultInMemoryThresholdBytes is the size beyond which bodies are
+	// spilled to disk instead of being kept in memory
+	DefaultInMemoryThresholdBytes = 1 << 20
+
+	spillFilePattern = "lunar-body-*"
+)
+
+// Spiller buffers bodies in memory up to a threshold, spilling larger bodies
+// to temporary files, so bodies kept until they are inspected do not grow
+// the memory with the size of the responses
+type Spiller struct {
+	thresholdBytes int
+	directory      string
+}
+
+// NewSpiller creates a spiller of bodies larger than the given threshold
+// into the given directory, the default temporary directory if empty
+func NewSpiller(thresholdBytes int, directory string) *Spiller {
+	if thresholdBytes < 0 {
+		thresholdBytes = DefaultInMemoryThresholdBytes
+	}
+	return &Spiller{
+		thresholdBytes: thresholdBytes,
+		directory:      directory,
+	}
+}
+
+func NewSpillerFromEnv() *Spiller {
+	thresholdBytes, err := environment.GetBodySpillThresholdBytes()
+	if err != nil || thresholdBytes < 0 {
+		thresholdBytes = DefaultInMemoryThresholdBytes
+	}
+	return NewSpiller(thresholdBytes, environment.GetBodySpillDirectory())
+}
+
+// Buffer is a body held in memory, or spilled to a file if it is larger
+// than the threshold. It must be released once it is no longer needed,
+// for its spill file to be removed.
+type Buffer struct {
+	body string
+	size int
+
+	mutex     sync.Mutex
+	spillPath string
+	released  bool
+}
+
+// Buffer holds the given body, spilling it to a file if it is larger
+// than the threshold. No file is left behind if spilling it fails.
+func (spiller *Spiller) Buffer(body string) (*Buffer, error) {
+	buffer := &Buffer{
+		body:      "",
+		size:      len(body),
+		mutex:     sync.Mutex{},
+		spillPath: "",
+		released:  false,
+	}
+	if len(body) <= spiller.thresholdBytes {
+		buffer.body = body
+		return buffer, nil
+	}
+
+	file, err := os.CreateTemp(spiller.directory, spillFilePattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create body spill file: %w", err)
+	}
+	_, writeErr := file.WriteString(body)
+	closeErr := file.Close()
+	if writeErr != nil || closeErr != nil {
+		removeSpillFile(file.Name())
+		if writeErr == nil {
+			writeErr = closeErr
+		}
+		return nil, fmt.Errorf("failed to write body spill file: %w", writeErr)
+	}
+	log.Trace().Msgf("Spilled body of %d bytes to %v", len(body), file.Name())
+	buffer.spillPath = file.Name()
+	return buffer, nil
+}
+
+// IsSpilled returns whether the body was spilled to a file
+func (buffer *Buffer) IsSpilled() bool {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.spillPath != ""
+}
+
+func (buffer *Buffer) Size() int {
+	return buffer.size
+}
+
+// Open opens the body to be read, streaming it from its spill file if
+// spilled, so it is not read back into memory as a whole.
+// The reader must be closed before the buffer is released.
+func (buffer *Buffer) Open() (io.ReadCloser, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	if buffer.released {
+		return nil, fmt.Errorf("body buffer was already released")
+	}
+	if buffer.spillPath == "" {
+		return io.NopCloser(strings.NewReader(buffer.body)), nil
+	}
+	file, err := os.Open(buffer.spillPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open body spill file: %w", err)
+	}
+	return file, nil
+}
+
+// Release removes the spill file of the body, if any,
+// releasing it more than once has no effect
+func (buffer *Buffer) Release() {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	if buffer.released {
+		return
+	}
+	buffer.released = true
+	buffer.body = ""
+	if buffer.spillPath != "" {
+		removeSpillFile(buffer.spillPath)
+	}
+}
+
+func removeSpillFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Warn().Err(err).Msgf("Failed to remove body spill file %v", path)
+	}
+}
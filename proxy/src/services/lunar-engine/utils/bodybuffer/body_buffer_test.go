@@ -0,0 +1,70 @@
+package bodybuffer_test
+
+import (
+	"io"
+	"lunar/engine/utils/bodybuffer"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func spillFiles(t *testing.T, directory string) []string {
+	files, err := filepath.Glob(filepath.Join(directory, "lunar-body-*"))
+	require.NoError(t, err)
+	return files
+}
+
+func read(t *testing.T, buffer *bodybuffer.Buffer) string {
+	reader, err := buffer.Open()
+	require.NoError(t, err)
+	defer reader.Close()
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestBodyAboveThresholdIsSpilledAndRemovedOnRelease(t *testing.T) {
+	directory := t.TempDir()
+	spiller := bodybuffer.NewSpiller(16, directory)
+	body := strings.Repeat("a large body, ", 100)
+
+	buffer, err := spiller.Buffer(body)
+	require.NoError(t, err)
+	require.True(t, buffer.IsSpilled())
+	require.Equal(t, len(body), buffer.Size())
+	require.Len(t, spillFiles(t, directory), 1)
+
+	require.Equal(t, body, read(t, buffer))
+
+	buffer.Release()
+	require.Empty(t, spillFiles(t, directory))
+	buffer.Release()
+	_, err = buffer.Open()
+	require.Error(t, err)
+}
+
+func TestBodyUpToThresholdIsKeptInMemory(t *testing.T) {
+	directory := t.TempDir()
+	spiller := bodybuffer.NewSpiller(16, directory)
+
+	buffer, err := spiller.Buffer("a small body")
+	require.NoError(t, err)
+	require.False(t, buffer.IsSpilled())
+	require.Empty(t, spillFiles(t, directory))
+
+	require.Equal(t, "a small body", read(t, buffer))
+	buffer.Release()
+}
+
+func TestSpillingToMissingDirectoryFails(t *testing.T) {
+	directory := filepath.Join(t.TempDir(), "missing")
+	spiller := bodybuffer.NewSpiller(0, directory)
+
+	_, err := spiller.Buffer("body")
+	require.Error(t, err)
+	_, statErr := os.Stat(directory)
+	require.True(t, os.IsNotExist(statErr))
+}
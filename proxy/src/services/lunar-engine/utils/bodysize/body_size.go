@@ -0,0 +1,161 @@
+package bodysize
+
+import (
+	"io"
+	sharedConfig "lunar/shared-model/config"
+	"strconv"
+	"strings"
+)
+
+const (
+	contentLengthHeaderName    = "Content-Length"
+	transferEncodingHeaderName = "Transfer-Encoding"
+
+	// DefaultMaxCountedBytes caps the bytes counted of a body whose size
+	// is not known from its Content-Length
+	DefaultMaxCountedBytes int64 = 10 << 20
+)
+
+// Estimation is how the size of a body is estimated
+type Estimation int
+
+const (
+	// EstimationContentLength trusts the Content-Length of the body when it
+	// is trustworthy, only counting the body otherwise
+	EstimationContentLength Estimation = iota
+	// EstimationCounted counts the body, for remedies which need its exact
+	// size, unless the body was not buffered
+	EstimationCounted
+)
+
+// Source is where the estimated size of a body comes from
+type Source int
+
+const (
+	SourceUnknown Source = iota
+	SourceContentLength
+	SourceCounted
+)
+
+func (source Source) String() string {
+	var res string
+	switch source {
+	case SourceContentLength:
+		res = "content_length"
+	case SourceCounted:
+		res = "counted"
+	case SourceUnknown:
+		res = "unknown"
+	}
+
+	return res
+}
+
+// Size is the estimated size of a body
+type Size struct {
+	Bytes  int64
+	Source Source
+	// Capped is set if counting the body stopped at the cap,
+	// the body being larger than Bytes
+	Capped bool
+}
+
+// Estimate estimates the size of the body by the given estimation,
+// counting at most maxCounted bytes of it. The body is nil if it was not
+// buffered, in which case its size is only known from its Content-Length.
+func Estimate(
+	headers sharedConfig.HeaderLookup,
+	body io.Reader,
+	estimation Estimation,
+	maxCounted int64,
+) Size {
+	contentLength, trustworthy := ContentLength(headers)
+	if trustworthy && (estimation == EstimationContentLength || body == nil) {
+		return Size{Bytes: contentLength, Source: SourceContentLength, Capped: false}
+	}
+	if body == nil {
+		return Size{Bytes: 0, Source: SourceUnknown, Capped: false}
+	}
+
+	counter := NewCounter(body, maxCounted)
+	_, _ = io.Copy(io.Discard, io.LimitReader(counter, maxCounted+1))
+	return counter.Size()
+}
+
+// EstimateInMemory estimates the size of a body held in memory by the given
+// estimation. Its length is known without counting it, so it is neither
+// copied nor capped. An empty body is taken as not buffered, its size being
+// known from its Content-Length only, so bodies on the no-buffer path are
+// not taken as empty.
+func EstimateInMemory(
+	headers sharedConfig.HeaderLookup,
+	body string,
+	estimation Estimation,
+) Size {
+	if body == "" {
+		return Estimate(headers, nil, estimation, 0)
+	}
+	contentLength, trustworthy := ContentLength(headers)
+	if trustworthy && estimation == EstimationContentLength {
+		return Size{Bytes: contentLength, Source: SourceContentLength, Capped: false}
+	}
+	return Size{Bytes: int64(len(body)), Source: SourceCounted, Capped: false}
+}
+
+// ContentLength returns the Content-Length of the headers, if it is
+// trustworthy: a single valid length, or repeated identical ones, on a body
+// which is not sent with a Transfer-Encoding overriding it
+func ContentLength(headers sharedConfig.HeaderLookup) (int64, bool) {
+	if _, found := headers.Header(
+		transferEncodingHeaderName, sharedConfig.MultiValueHeaderFirst); found {
+		return 0, false
+	}
+	value, found := headers.Header(
+		contentLengthHeaderName, sharedConfig.MultiValueHeaderJoin)
+	if !found {
+		return 0, false
+	}
+
+	contentLength := int64(-1)
+	for _, rawLength := range strings.Split(value, ",") {
+		length, err := strconv.ParseInt(strings.TrimSpace(rawLength), 10, 64)
+		if err != nil || length < 0 {
+			return 0, false
+		}
+		if contentLength != -1 && length != contentLength {
+			return 0, false
+		}
+		contentLength = length
+	}
+	return contentLength, true
+}
+
+// Counter counts the bytes of a body streamed through it, up to a cap,
+// without buffering or altering them
+type Counter struct {
+	reader     io.Reader
+	maxCounted int64
+	count      int64
+}
+
+func NewCounter(reader io.Reader, maxCounted int64) *Counter {
+	return &Counter{
+		reader:     reader,
+		maxCounted: maxCounted,
+		count:      0,
+	}
+}
+
+func (counter *Counter) Read(buffer []byte) (int, error) {
+	read, err := counter.reader.Read(buffer)
+	counter.count += int64(read)
+	return read, err
+}
+
+// Size returns the bytes counted so far, capped
+func (counter *Counter) Size() Size {
+	if counter.count > counter.maxCounted {
+		return Size{Bytes: counter.maxCounted, Source: SourceCounted, Capped: true}
+	}
+	return Size{Bytes: counter.count, Source: SourceCounted, Capped: false}
+}
@@ -0,0 +1,147 @@
+package bodysize_test
+
+import (
+	"io"
+	"lunar/engine/utils/bodysize"
+	sharedConfig "lunar/shared-model/config"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type multiValueHeaders map[string][]string
+
+func (headers multiValueHeaders) Header(
+	name string,
+	multiValue sharedConfig.MultiValueHeader,
+) (string, bool) {
+	values, found := headers[strings.ToLower(name)]
+	if !found {
+		return "", false
+	}
+	return sharedConfig.ResolveHeaderValues(values, multiValue), true
+}
+
+func TestEstimateTrustsAValidContentLength(t *testing.T) {
+	t.Parallel()
+	headers := sharedConfig.HeaderMap{"content-length": "5"}
+	size := bodysize.Estimate(headers, strings.NewReader("hello"),
+		bodysize.EstimationContentLength, 100)
+	assert.Equal(t, bodysize.Size{
+		Bytes: 5, Source: bodysize.SourceContentLength, Capped: false,
+	}, size)
+}
+
+func TestEstimateCountsTheBodyOfALyingContentLength(t *testing.T) {
+	t.Parallel()
+	body := strings.Repeat("x", 50)
+	headers := sharedConfig.HeaderMap{"Content-Length": "5"}
+
+	// Trusted, the Content-Length is used as is
+	size := bodysize.Estimate(headers, strings.NewReader(body),
+		bodysize.EstimationContentLength, 100)
+	assert.Equal(t, int64(5), size.Bytes)
+
+	// Remedies which need the exact size count the body instead
+	size = bodysize.Estimate(headers, strings.NewReader(body),
+		bodysize.EstimationCounted, 100)
+	assert.Equal(t, bodysize.Size{
+		Bytes: 50, Source: bodysize.SourceCounted, Capped: false,
+	}, size)
+}
+
+func TestEstimateCountsTheBodyOfAnUntrustworthyContentLength(t *testing.T) {
+	t.Parallel()
+	for name, headers := range map[string]sharedConfig.HeaderLookup{
+		"absent":      sharedConfig.HeaderMap{},
+		"invalid":     sharedConfig.HeaderMap{"Content-Length": "five"},
+		"negative":    sharedConfig.HeaderMap{"Content-Length": "-5"},
+		"conflicting": multiValueHeaders{"content-length": {"5", "7"}},
+		"transfer encoding": sharedConfig.HeaderMap{
+			"Content-Length":    "5",
+			"Transfer-Encoding": "chunked",
+		},
+	} {
+		size := bodysize.Estimate(headers, strings.NewReader("hello, world"),
+			bodysize.EstimationContentLength, 100)
+		assert.Equal(t, bodysize.Size{
+			Bytes: 12, Source: bodysize.SourceCounted, Capped: false,
+		}, size, name)
+	}
+
+	// Repeated identical lengths are trusted
+	size := bodysize.Estimate(multiValueHeaders{"content-length": {"5", "5"}},
+		strings.NewReader("hello, world"), bodysize.EstimationContentLength, 100)
+	assert.Equal(t, bodysize.SourceContentLength, size.Source)
+}
+
+func TestEstimateStopsCountingAtTheCap(t *testing.T) {
+	t.Parallel()
+	body := &countingReader{reader: strings.NewReader(strings.Repeat("x", 1000))}
+	size := bodysize.Estimate(sharedConfig.HeaderMap{}, body,
+		bodysize.EstimationCounted, 100)
+	assert.Equal(t, bodysize.Size{
+		Bytes: 100, Source: bodysize.SourceCounted, Capped: true,
+	}, size)
+	assert.LessOrEqual(t, body.read, 101)
+}
+
+func TestEstimateOfAnUnbufferedBodyUsesTheContentLengthOnly(t *testing.T) {
+	t.Parallel()
+	size := bodysize.Estimate(sharedConfig.HeaderMap{"Content-Length": "5"},
+		nil, bodysize.EstimationCounted, 100)
+	assert.Equal(t, bodysize.Size{
+		Bytes: 5, Source: bodysize.SourceContentLength, Capped: false,
+	}, size)
+
+	size = bodysize.Estimate(sharedConfig.HeaderMap{"Content-Length": "five"},
+		nil, bodysize.EstimationCounted, 100)
+	assert.Equal(t, bodysize.SourceUnknown, size.Source)
+}
+
+func TestEstimateInMemoryTakesTheLengthOfTheBodyUncapped(t *testing.T) {
+	t.Parallel()
+	body := strings.Repeat("x", int(bodysize.DefaultMaxCountedBytes)+1)
+	size := bodysize.EstimateInMemory(sharedConfig.HeaderMap{"Content-Length": "5"},
+		body, bodysize.EstimationCounted)
+	assert.Equal(t, bodysize.Size{
+		Bytes: bodysize.DefaultMaxCountedBytes + 1, Source: bodysize.SourceCounted, Capped: false,
+	}, size)
+
+	size = bodysize.EstimateInMemory(sharedConfig.HeaderMap{"Content-Length": "5"},
+		body, bodysize.EstimationContentLength)
+	assert.Equal(t, bodysize.Size{
+		Bytes: 5, Source: bodysize.SourceContentLength, Capped: false,
+	}, size)
+
+	// An empty body was not buffered
+	size = bodysize.EstimateInMemory(sharedConfig.HeaderMap{"Content-Length": "5"},
+		"", bodysize.EstimationCounted)
+	assert.Equal(t, bodysize.Size{
+		Bytes: 5, Source: bodysize.SourceContentLength, Capped: false,
+	}, size)
+}
+
+func TestCounterStreamsTheBodyUnaltered(t *testing.T) {
+	t.Parallel()
+	counter := bodysize.NewCounter(strings.NewReader("hello, world"), 5)
+	streamed, err := io.ReadAll(counter)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(streamed))
+	assert.Equal(t, bodysize.Size{
+		Bytes: 5, Source: bodysize.SourceCounted, Capped: true,
+	}, counter.Size())
+}
+
+type countingReader struct {
+	reader io.Reader
+	read   int
+}
+
+func (reader *countingReader) Read(buffer []byte) (int, error) {
+	read, err := reader.reader.Read(buffer)
+	reader.read += read
+	return read, err
+}
@@ -15,6 +15,8 @@ type Cache[K any, V any] interface {
 	Set(key K, value V, ttlSec float64) error
 	Del(key K)
 	WithMaxCacheSize(calculateSizeFunc func(K, V) float64, maxCacheSize float64)
+	WithOnEvict(onEvict func(K, V))
+	Len() int
 }
 
 type MemoryCache[K comparable, V any] struct {
@@ -25,6 +27,7 @@ type MemoryCache[K comparable, V any] struct {
 	maxCacheSize       float64
 	currentCacheSize   float64
 	calculateSizeFunc  func(key K, value V) float64
+	onEvict            func(key K, value V)
 }
 
 type ValueWrapper[V any] struct {
@@ -136,6 +139,20 @@ func (cache *MemoryCache[K, V]) WithMaxCacheSize(
 	cache.maxCacheSize = maxCacheSize
 }
 
+// WithOnEvict calls the given function with each entry deleted
+// or expired, such as to release resources held by its value
+func (cache *MemoryCache[K, V]) WithOnEvict(onEvict func(K, V)) {
+	cache.onEvict = onEvict
+}
+
+// Len returns the number of entries currently stored
+func (cache *MemoryCache[K, V]) Len() int {
+	ensureCacheInitialized(cache)
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	return len(cache.cache)
+}
+
 // This function is used for printing the cache in debug logs
 // It is needed to avoid concurrent map read and map write errors
 func (cache *MemoryCache[K, V]) String() string {
@@ -154,6 +171,7 @@ func NewMemoryCache[K comparable, V any](clock clock.Clock) *MemoryCache[K, V] {
 		maxCacheSize:       1, // TBD - get default value
 		currentCacheSize:   0,
 		calculateSizeFunc:  nil,
+		onEvict:            nil,
 	}
 }
 
@@ -167,12 +185,14 @@ func ensureCacheInitialized[K comparable, V any](cache *MemoryCache[K, V]) {
 
 func clearKey[K comparable, V any](cache *MemoryCache[K, V], key K) {
 	cache.mutex.Lock()
-	if cache.calculateCacheSize {
-		valueWrapper, found := cache.cache[key]
-		if found {
-			cache.currentCacheSize -= cache.calculateSizeFunc(key, valueWrapper.value)
-		}
+	valueWrapper, found := cache.cache[key]
+	if cache.calculateCacheSize && found {
+		cache.currentCacheSize -= cache.calculateSizeFunc(key, valueWrapper.value)
 	}
 	delete(cache.cache, key)
 	cache.mutex.Unlock()
+
+	if found && cache.onEvict != nil {
+		cache.onEvict(key, valueWrapper.value)
+	}
 }
@@ -191,3 +191,31 @@ func testReadWrite[K comparable, V any](t *testing.T, wantKey K, wantValue V) {
 	_, found = cache.Get(wantKey)
 	assert.False(t, found)
 }
+
+func TestOnEvictIsCalledWithDeletedAndExpiredEntries(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	cache := utils.NewMemoryCache[string, int](clock)
+	evicted := make(chan string, 2)
+	cache.WithOnEvict(func(key string, _ int) { evicted <- key })
+
+	assert.Nil(t, cache.Set("deleted", 1, 10))
+	assert.Nil(t, cache.Set("expired", 2, 1))
+
+	cache.Del("deleted")
+	assert.Equal(t, "deleted", <-evicted)
+	// Deleting a missing key evicts nothing
+	cache.Del("deleted")
+
+	// The expiry timer is set in the background, so time is advanced
+	// until it fires
+	assert.Eventually(t, func() bool {
+		clock.AdvanceTime(testutils.PlusEpsilon(time.Second))
+		select {
+		case key := <-evicted:
+			return key == "expired"
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,32 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/andybalholm/brotli"
+)
+
+func CompressBrotli(input string) (string, error) {
+	var buffer bytes.Buffer
+	writer := brotli.NewWriter(&buffer)
+	if _, err := writer.Write([]byte(input)); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	return buffer.String(), nil
+}
+
+func DecompressBrotli(compressed string) (string, error) {
+	reader := brotli.NewReader(bytes.NewBuffer([]byte(compressed)))
+
+	bytes, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes), nil
+}
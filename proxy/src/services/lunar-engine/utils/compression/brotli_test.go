@@ -0,0 +1,19 @@
+package compression_test
+
+import (
+	"lunar/engine/utils/compression"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressBrotli(t *testing.T) {
+	t.Parallel()
+	originalInput := "hello, world"
+	compressed, err := compression.CompressBrotli(originalInput)
+	assert.Nil(t, err)
+	assert.NotEqual(t, compressed, originalInput)
+	decompressed, err := compression.DecompressBrotli(compressed)
+	assert.Nil(t, err)
+	assert.Equal(t, decompressed, originalInput)
+}
@@ -20,3 +20,16 @@ func DecompressGZip(compressed string) (string, error) {
 
 	return string(bytes), nil
 }
+
+func CompressGZip(input string) (string, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write([]byte(input)); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	return buffer.String(), nil
+}
@@ -17,3 +17,14 @@ func TestDecompressGZip(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, decompressed, originalInput)
 }
+
+func TestCompressGZip(t *testing.T) {
+	t.Parallel()
+	originalInput := "hello, world"
+	compressed, err := compression.CompressGZip(originalInput)
+	assert.Nil(t, err)
+	assert.NotEqual(t, compressed, originalInput)
+	decompressed, err := compression.DecompressGZip(compressed)
+	assert.Nil(t, err)
+	assert.Equal(t, decompressed, originalInput)
+}
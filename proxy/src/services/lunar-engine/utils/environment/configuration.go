@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -11,27 +12,80 @@ import (
 )
 
 const (
-	proxyVersionEnvVar               string = "LUNAR_VERSION"
-	tenantNameEnvVar                 string = "TENANT_NAME"
-	haproxyManageEndpointsPortEnvVar string = "HAPROXY_MANAGE_ENDPOINTS_PORT"
-	haproxyHealthcheckPortEnvVar     string = "LUNAR_HEALTHCHECK_PORT"
-	redisURLEnvVar                   string = "REDIS_URL"
-	redisUseCluster                  string = "REDIS_USE_CLUSTER"
-	redisPrefix                      string = "REDIS_PREFIX"
-	redisMaxRetryAttempts            string = "REDIS_MAX_RETRY_ATTEMPTS"
-	redisRetryBackoffMillis          string = "REDIS_RETRY_BACKOFF_MILLIS"
-	redisMaxOLRetryAttempts          string = "REDIS_MAX_OPTIMISTIC_LOCKING_RETRY_ATTEMPTS"
-	lunarAPIKeyEnvVar                string = "LUNAR_API_KEY"
-	lunarHubURLEnvVar                string = "LUNAR_HUB_URL"
-	lunarHubReportIntervalEnvVar     string = "HUB_REPORT_INTERVAL"
-	discoveryStateLocationEnvVar     string = "DISCOVERY_STATE_LOCATION"
-	remedyStatsStateLocationEnvVar   string = "REMEDY_STATE_LOCATION"
-	streamsFeatureFlagEnvVar         string = "LUNAR_STREAMS_ENABLED"
-	streamsFlowsDirectoryEnvVar      string = "LUNAR_PROXY_FLOW_DIRECTORY"
-	ResourcesDirectoryEnvVar         string = "LUNAR_PROXY_RESOURCES_DIRECTORY"
-	processorsDirectoryEnvVar        string = "LUNAR_PROXY_PROCESSORS_DIRECTORY"
-	userProcessorsDirectoryEnvVar    string = "LUNAR_PROXY_USER_PROCESSORS_DIRECTORY"
-	lunarEngineFailsafeEnableEnvVar  string = "LUNAR_ENGINE_FAILSAFE_ENABLED"
+	proxyVersionEnvVar                string = "LUNAR_VERSION"
+	proxyGitCommitEnvVar              string = "LUNAR_GIT_COMMIT"
+	proxyBuildDateEnvVar              string = "LUNAR_BUILD_DATE"
+	tenantNameEnvVar                  string = "TENANT_NAME"
+	haproxyManageEndpointsPortEnvVar  string = "HAPROXY_MANAGE_ENDPOINTS_PORT"
+	haproxyHealthcheckPortEnvVar      string = "LUNAR_HEALTHCHECK_PORT"
+	redisURLEnvVar                    string = "REDIS_URL"
+	redisUseCluster                   string = "REDIS_USE_CLUSTER"
+	redisPrefix                       string = "REDIS_PREFIX"
+	redisMaxRetryAttempts             string = "REDIS_MAX_RETRY_ATTEMPTS"
+	redisRetryBackoffMillis           string = "REDIS_RETRY_BACKOFF_MILLIS"
+	redisMaxOLRetryAttempts           string = "REDIS_MAX_OPTIMISTIC_LOCKING_RETRY_ATTEMPTS"
+	lunarAPIKeyEnvVar                 string = "LUNAR_API_KEY"
+	lunarHubURLEnvVar                 string = "LUNAR_HUB_URL"
+	lunarHubReportIntervalEnvVar      string = "HUB_REPORT_INTERVAL"
+	hubReportBackoffMultiplierEnvVar  string = "LUNAR_HUB_REPORT_BACKOFF_MULTIPLIER"
+	hubReportBackoffMaxEnvVar         string = "LUNAR_HUB_REPORT_BACKOFF_MAX_SEC"
+	hubFailoverAfterFailuresEnvVar    string = "LUNAR_HUB_FAILOVER_AFTER_FAILURES"
+	hubReturnToPrimaryIntervalEnvVar  string = "LUNAR_HUB_RETURN_TO_PRIMARY_INTERVAL_SEC"
+	discoveryStateLocationEnvVar      string = "DISCOVERY_STATE_LOCATION"
+	discoveryStateStreamingEnvVar     string = "LUNAR_DISCOVERY_STATE_STREAMING"
+	remedyStatsStateLocationEnvVar    string = "REMEDY_STATE_LOCATION"
+	streamsFeatureFlagEnvVar          string = "LUNAR_STREAMS_ENABLED"
+	streamsFlowsDirectoryEnvVar       string = "LUNAR_PROXY_FLOW_DIRECTORY"
+	ResourcesDirectoryEnvVar          string = "LUNAR_PROXY_RESOURCES_DIRECTORY"
+	processorsDirectoryEnvVar         string = "LUNAR_PROXY_PROCESSORS_DIRECTORY"
+	userProcessorsDirectoryEnvVar     string = "LUNAR_PROXY_USER_PROCESSORS_DIRECTORY"
+	lunarEngineFailsafeEnableEnvVar   string = "LUNAR_ENGINE_FAILSAFE_ENABLED"
+	metricsTenantHeaderEnvVar         string = "LUNAR_METRICS_TENANT_HEADER"
+	metricsTenantMaxCardinalityEnvVar string = "LUNAR_METRICS_TENANT_MAX_CARDINALITY"
+	metricsHostLabelEnvVar            string = "LUNAR_METRICS_HOST_LABEL_ENABLED"
+	metricsHostMaxCardinalityEnvVar   string = "LUNAR_METRICS_HOST_MAX_CARDINALITY"
+	rejectionRatioWindowEnvVar        string = "LUNAR_REJECTION_RATIO_WINDOW_SEC"
+	flowRetryBudgetEnvVar             string = "LUNAR_FLOW_RETRY_BUDGET"
+	discoveryStaleIntervalsEnvVar     string = "LUNAR_DISCOVERY_STALE_AFTER_INTERVALS"
+	hookMaxIdleConnsEnvVar            string = "LUNAR_DECISION_HOOK_MAX_IDLE_CONNS"
+	hookMaxConnsPerHostEnvVar         string = "LUNAR_DECISION_HOOK_MAX_CONNS_PER_HOST"
+	hookIdleConnTimeoutEnvVar         string = "LUNAR_DECISION_HOOK_IDLE_CONN_TIMEOUT_SEC"
+	hookForceHTTP2EnvVar              string = "LUNAR_DECISION_HOOK_FORCE_HTTP2"
+	reopenWritersOnSIGHUPEnvVar       string = "LUNAR_REOPEN_WRITERS_ON_SIGHUP"
+	usageSnapshotIntervalEnvVar       string = "LUNAR_USAGE_SNAPSHOT_INTERVAL_SEC"
+	usageSnapshotEndpointEnvVar       string = "LUNAR_USAGE_SNAPSHOT_ENDPOINT"
+	usageSnapshotExporterEnvVar       string = "LUNAR_USAGE_SNAPSHOT_EXPORTER"
+	shutdownGracePeriodEnvVar         string = "LUNAR_SHUTDOWN_GRACE_PERIOD_SEC"
+	shutdownRetryAfterEnvVar          string = "LUNAR_SHUTDOWN_RETRY_AFTER_SEC"
+	shutdownQueueSnapshotEnvVar       string = "LUNAR_SHUTDOWN_QUEUE_SNAPSHOT_EXPORTER"
+	queueRejectionsExporterEnvVar     string = "LUNAR_QUEUE_REJECTIONS_EXPORTER"
+	queueRejectionsSampleRateEnvVar   string = "LUNAR_QUEUE_REJECTIONS_SAMPLE_RATE"
+	rejectedSamplesExporterEnvVar     string = "LUNAR_REJECTED_REQUEST_SAMPLES_EXPORTER"
+	rejectedSamplesRateEnvVar         string = "LUNAR_REJECTED_REQUEST_SAMPLES_RATE"
+	rejectedSamplesPerMinuteEnvVar    string = "LUNAR_REJECTED_REQUEST_SAMPLES_PER_MINUTE"
+	remotePoliciesURLEnvVar           string = "LUNAR_REMOTE_POLICIES_URL"
+	remotePoliciesIntervalEnvVar      string = "LUNAR_REMOTE_POLICIES_INTERVAL_SEC"
+	dynamicQuotasURLEnvVar            string = "LUNAR_DYNAMIC_QUOTAS_URL"
+	dynamicQuotasIntervalEnvVar       string = "LUNAR_DYNAMIC_QUOTAS_INTERVAL_SEC"
+	dynamicQuotasMaxAgeEnvVar         string = "LUNAR_DYNAMIC_QUOTAS_MAX_AGE_SEC"
+	syslogTargetsEnvVar               string = "LUNAR_SYSLOG_TARGETS"
+	deadLetterFileEnvVar              string = "LUNAR_DEAD_LETTER_FILE"
+	deadLetterMaxFileSizeEnvVar       string = "LUNAR_DEAD_LETTER_MAX_FILE_SIZE_MB"
+	deadLetterMaxFilesEnvVar          string = "LUNAR_DEAD_LETTER_MAX_FILES"
+	diagnosisMaxTransactionsEnvVar    string = "LUNAR_DIAGNOSIS_MAX_TRANSACTIONS_PER_SEC"
+	maxPriorityGroupsEnvVar           string = "LUNAR_MAX_PRIORITY_GROUPS"
+	remedyCircuitErrorsEnvVar         string = "LUNAR_REMEDY_CIRCUIT_CONSECUTIVE_ERRORS"
+	remedyCircuitCooldownEnvVar       string = "LUNAR_REMEDY_CIRCUIT_COOLDOWN_SEC"
+	selfTestIntervalEnvVar            string = "LUNAR_SELF_TEST_INTERVAL_SEC"
+	selfTestMethodEnvVar              string = "LUNAR_SELF_TEST_METHOD"
+	selfTestURLEnvVar                 string = "LUNAR_SELF_TEST_URL"
+	selfTestHeadersEnvVar             string = "LUNAR_SELF_TEST_HEADERS"
+	bodySpillThresholdEnvVar          string = "LUNAR_BODY_SPILL_THRESHOLD_BYTES"
+	bodySpillDirectoryEnvVar          string = "LUNAR_BODY_SPILL_DIRECTORY"
+	adminAPITokenEnvVar               string = "LUNAR_ADMIN_API_TOKEN"
+	requestTimeoutHeaderEnvVar        string = "LUNAR_REQUEST_TIMEOUT_HEADER"
+	requestTimeoutMaxEnvVar           string = "LUNAR_REQUEST_TIMEOUT_MAX_MS"
+	requestTimeoutStatusCodeEnvVar    string = "LUNAR_REQUEST_TIMEOUT_STATUS_CODE"
 
 	lunarHubDefaultValue string = "hub.lunar.dev"
 )
@@ -40,6 +94,222 @@ func GetTenantName() string {
 	return os.Getenv(tenantNameEnvVar)
 }
 
+func GetMetricsTenantHeader() string {
+	return os.Getenv(metricsTenantHeaderEnvVar)
+}
+
+func GetMetricsTenantMaxCardinality() (int, error) {
+	return strconv.Atoi(os.Getenv(metricsTenantMaxCardinalityEnvVar))
+}
+
+// IsMetricsHostLabelEnabled returns whether remedy metrics are labeled
+// by the upstream host they are of
+func IsMetricsHostLabelEnabled() bool {
+	return parseBooleanEnvVar(metricsHostLabelEnvVar)
+}
+
+func GetMetricsHostMaxCardinality() (int, error) {
+	return strconv.Atoi(os.Getenv(metricsHostMaxCardinalityEnvVar))
+}
+
+func GetRejectionRatioWindow() (time.Duration, error) {
+	seconds, err := strconv.Atoi(os.Getenv(rejectionRatioWindowEnvVar))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// GetFlowRetryBudget returns the retries allowed to each transaction,
+// across all the retrying processors of its flow
+func GetFlowRetryBudget() (int, error) {
+	return strconv.Atoi(os.Getenv(flowRetryBudgetEnvVar))
+}
+
+func GetUsageSnapshotInterval() (time.Duration, error) {
+	seconds, err := strconv.Atoi(os.Getenv(usageSnapshotIntervalEnvVar))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func GetUsageSnapshotEndpoint() string {
+	return os.Getenv(usageSnapshotEndpointEnvVar)
+}
+
+func GetUsageSnapshotExporter() string {
+	return os.Getenv(usageSnapshotExporterEnvVar)
+}
+
+func GetQueueRejectionsExporter() string {
+	return os.Getenv(queueRejectionsExporterEnvVar)
+}
+
+func GetQueueRejectionsSampleRate() (float64, error) {
+	return strconv.ParseFloat(os.Getenv(queueRejectionsSampleRateEnvVar), 64)
+}
+
+func GetRejectedRequestSamplesExporter() string {
+	return os.Getenv(rejectedSamplesExporterEnvVar)
+}
+
+func GetRejectedRequestSamplesRate() (float64, error) {
+	return strconv.ParseFloat(os.Getenv(rejectedSamplesRateEnvVar), 64)
+}
+
+func GetRejectedRequestSamplesPerMinute() (int, error) {
+	return strconv.Atoi(os.Getenv(rejectedSamplesPerMinuteEnvVar))
+}
+
+func GetRemotePoliciesURL() string {
+	return os.Getenv(remotePoliciesURLEnvVar)
+}
+
+func GetRemotePoliciesInterval() (time.Duration, error) {
+	seconds, err := strconv.Atoi(os.Getenv(remotePoliciesIntervalEnvVar))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func GetDynamicQuotasURL() string {
+	return os.Getenv(dynamicQuotasURLEnvVar)
+}
+
+func GetDynamicQuotasInterval() (time.Duration, error) {
+	seconds, err := strconv.Atoi(os.Getenv(dynamicQuotasIntervalEnvVar))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func GetDynamicQuotasMaxAge() (time.Duration, error) {
+	seconds, err := strconv.Atoi(os.Getenv(dynamicQuotasMaxAgeEnvVar))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func GetAdminAPIToken() string {
+	return os.Getenv(adminAPITokenEnvVar)
+}
+
+func GetSyslogTargets() string {
+	return os.Getenv(syslogTargetsEnvVar)
+}
+
+func GetDeadLetterFile() string {
+	return os.Getenv(deadLetterFileEnvVar)
+}
+
+func GetDeadLetterMaxFileSize() (int64, error) {
+	megabytes, err := strconv.ParseInt(os.Getenv(deadLetterMaxFileSizeEnvVar), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return megabytes * 1024 * 1024, nil
+}
+
+func GetDeadLetterMaxFiles() (int, error) {
+	return strconv.Atoi(os.Getenv(deadLetterMaxFilesEnvVar))
+}
+
+func GetDiagnosisMaxTransactionsPerSec() (int64, error) {
+	return strconv.ParseInt(os.Getenv(diagnosisMaxTransactionsEnvVar), 10, 64)
+}
+
+func GetMaxPriorityGroups() (int, error) {
+	return strconv.Atoi(os.Getenv(maxPriorityGroupsEnvVar))
+}
+
+func GetRemedyCircuitConsecutiveErrors() (int, error) {
+	return strconv.Atoi(os.Getenv(remedyCircuitErrorsEnvVar))
+}
+
+func GetRemedyCircuitCooldown() (time.Duration, error) {
+	seconds, err := strconv.Atoi(os.Getenv(remedyCircuitCooldownEnvVar))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func GetSelfTestInterval() (time.Duration, error) {
+	seconds, err := strconv.Atoi(os.Getenv(selfTestIntervalEnvVar))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func GetSelfTestMethod() string {
+	return os.Getenv(selfTestMethodEnvVar)
+}
+
+func GetSelfTestURL() string {
+	return os.Getenv(selfTestURLEnvVar)
+}
+
+// GetSelfTestHeaders returns the headers of the self-test request,
+// set as comma separated name=value pairs
+func GetSelfTestHeaders() string {
+	return os.Getenv(selfTestHeadersEnvVar)
+}
+
+func GetBodySpillThresholdBytes() (int, error) {
+	return strconv.Atoi(os.Getenv(bodySpillThresholdEnvVar))
+}
+
+// GetBodySpillDirectory returns the directory bodies are spilled to,
+// the default temporary directory if not set
+func GetBodySpillDirectory() string {
+	return os.Getenv(bodySpillDirectoryEnvVar)
+}
+
+// GetRequestTimeoutHeader returns the header clients tell the time they
+// wait for a response in (in milliseconds) by, if configured
+func GetRequestTimeoutHeader() string {
+	return os.Getenv(requestTimeoutHeaderEnvVar)
+}
+
+func GetRequestTimeoutMax() (time.Duration, error) {
+	millis, err := strconv.Atoi(os.Getenv(requestTimeoutMaxEnvVar))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(millis) * time.Millisecond, nil
+}
+
+func GetRequestTimeoutStatusCode() (int, error) {
+	return strconv.Atoi(os.Getenv(requestTimeoutStatusCodeEnvVar))
+}
+
+func GetShutdownGracePeriod() (time.Duration, error) {
+	seconds, err := strconv.Atoi(os.Getenv(shutdownGracePeriodEnvVar))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// GetShutdownQueueSnapshotExporter returns the raw data exporter the requests
+// waiting in queues are written to on shutdown, if any
+func GetShutdownQueueSnapshotExporter() string {
+	return os.Getenv(shutdownQueueSnapshotEnvVar)
+}
+
+func GetShutdownRetryAfter() (time.Duration, error) {
+	seconds, err := strconv.Atoi(os.Getenv(shutdownRetryAfterEnvVar))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
 func IsEngineFailsafeEnabled() bool {
 	return parseBooleanEnvVar(lunarEngineFailsafeEnableEnvVar)
 }
@@ -48,10 +318,24 @@ func GetDiscoveryStateLocation() string {
 	return os.Getenv(discoveryStateLocationEnvVar)
 }
 
+// IsDiscoveryStateStreamingEnabled returns whether the discovery state
+// is decoded as it is read, instead of being read into memory first
+func IsDiscoveryStateStreamingEnabled() bool {
+	return os.Getenv(discoveryStateStreamingEnvVar) == "true"
+}
+
 func GetProxyVersion() string {
 	return os.Getenv(proxyVersionEnvVar)
 }
 
+func GetProxyGitCommit() string {
+	return os.Getenv(proxyGitCommitEnvVar)
+}
+
+func GetProxyBuildDate() string {
+	return os.Getenv(proxyBuildDateEnvVar)
+}
+
 func GetRemedyStateLocation() string {
 	return os.Getenv(remedyStatsStateLocationEnvVar)
 }
@@ -152,13 +436,32 @@ func GetRedisCACertificatePath() string {
 	return os.Getenv("REDIS_CA_CERT_PATH")
 }
 
-func GetHubURL() string {
-	lunarHubURL := os.Getenv(lunarHubURLEnvVar)
-	if lunarHubURL == "" {
+// GetHubURLs returns the Lunar Hub hosts to connect to, comma separated,
+// the first being the primary one and the rest its standbys
+func GetHubURLs() []string {
+	hubURLs := []string{}
+	for _, hubURL := range strings.Split(os.Getenv(lunarHubURLEnvVar), ",") {
+		if hubURL = strings.TrimSpace(hubURL); hubURL != "" {
+			hubURLs = append(hubURLs, hubURL)
+		}
+	}
+	if len(hubURLs) == 0 {
 		log.Warn().Msgf("Could not find Lunar Hub URL from ENV, using default: %s", lunarHubDefaultValue)
-		lunarHubURL = lunarHubDefaultValue
+		hubURLs = append(hubURLs, lunarHubDefaultValue)
 	}
-	return lunarHubURL
+	return hubURLs
+}
+
+func GetHubFailoverAfterFailures() (int, error) {
+	return strconv.Atoi(os.Getenv(hubFailoverAfterFailuresEnvVar))
+}
+
+func GetHubReturnToPrimaryInterval() (time.Duration, error) {
+	seconds, err := strconv.Atoi(os.Getenv(hubReturnToPrimaryIntervalEnvVar))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
 }
 
 func GetAPIKey() string {
@@ -169,6 +472,52 @@ func GetHubReportInterval() (int, error) {
 	return strconv.Atoi(os.Getenv(lunarHubReportIntervalEnvVar))
 }
 
+func GetHubReportBackoffMultiplier() (float64, error) {
+	return strconv.ParseFloat(os.Getenv(hubReportBackoffMultiplierEnvVar), 64)
+}
+
+func GetHubReportBackoffMax() (time.Duration, error) {
+	seconds, err := strconv.Atoi(os.Getenv(hubReportBackoffMaxEnvVar))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// GetDiscoveryStaleAfterIntervals returns after how many report intervals
+// without an update the discovery state file is stale
+func GetDiscoveryStaleAfterIntervals() (float64, error) {
+	return strconv.ParseFloat(os.Getenv(discoveryStaleIntervalsEnvVar), 64)
+}
+
+func GetDecisionHookMaxIdleConns() (int, error) {
+	return strconv.Atoi(os.Getenv(hookMaxIdleConnsEnvVar))
+}
+
+func GetDecisionHookMaxConnsPerHost() (int, error) {
+	return strconv.Atoi(os.Getenv(hookMaxConnsPerHostEnvVar))
+}
+
+func GetDecisionHookIdleConnTimeout() (time.Duration, error) {
+	seconds, err := strconv.Atoi(os.Getenv(hookIdleConnTimeoutEnvVar))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// GetDecisionHookForceHTTP2 returns whether HTTP/2 is attempted for hook calls,
+// erroring if unset so the default applies
+func GetDecisionHookForceHTTP2() (bool, error) {
+	return strconv.ParseBool(os.Getenv(hookForceHTTP2EnvVar))
+}
+
+// IsReopenWritersOnSIGHUPEnabled returns whether export writers are flushed,
+// and the files they write to reopened, on SIGHUP
+func IsReopenWritersOnSIGHUPEnabled() bool {
+	return parseBooleanEnvVar(reopenWritersOnSIGHUPEnvVar)
+}
+
 func IsLogLevelDebug() bool {
 	return log.Logger.GetLevel() == zerolog.DebugLevel
 }
@@ -0,0 +1,108 @@
+package events
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	droppedEventsMetricName = "lunar_events.dropped"
+	kindAttribute           = "kind"
+	subscriberAttribute     = "subscriber"
+)
+
+// Bus delivers the events published by plugins to their subscribers,
+// so plugins can react to each other without referencing each other.
+// Publishing never blocks: each subscription buffers a bounded number of
+// events, and events published while its buffer is full are dropped.
+type Bus struct {
+	ctx           context.Context
+	mutex         sync.RWMutex
+	subscriptions map[Kind][]*subscription
+	dropped       metric.Int64Counter
+}
+
+type subscription struct {
+	name   string
+	events chan Event
+}
+
+// NewBus creates a bus whose subscriptions are handled until
+// the given context is done
+func NewBus(ctx context.Context, meter metric.Meter) *Bus {
+	dropped, err := meter.Int64Counter(
+		droppedEventsMetricName,
+		metric.WithDescription("Events dropped as their subscriber "+
+			"had too many events pending"),
+		metric.WithUnit("{event}"),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create %s metric",
+			droppedEventsMetricName)
+	}
+	return &Bus{
+		ctx:           ctx,
+		mutex:         sync.RWMutex{},
+		subscriptions: map[Kind][]*subscription{},
+		dropped:       dropped,
+	}
+}
+
+// Subscribe handles the events of the given kind one at a time,
+// apart from the publishers. Up to `bufferSize` events are kept pending.
+// Subscribing to a nil Bus does nothing.
+func (bus *Bus) Subscribe(
+	name string,
+	kind Kind,
+	bufferSize int,
+	handle func(Event),
+) {
+	if bus == nil {
+		return
+	}
+	subscription := &subscription{name: name, events: make(chan Event, bufferSize)}
+	bus.mutex.Lock()
+	bus.subscriptions[kind] = append(bus.subscriptions[kind], subscription)
+	bus.mutex.Unlock()
+
+	go func() {
+		for {
+			select {
+			case <-bus.ctx.Done():
+				return
+			case event := <-subscription.events:
+				handle(event)
+			}
+		}
+	}()
+}
+
+// Publish delivers the event to the subscribers of its kind.
+// Publishing to a nil Bus does nothing.
+func (bus *Bus) Publish(event Event) {
+	if bus == nil {
+		return
+	}
+	bus.mutex.RLock()
+	subscriptions := bus.subscriptions[event.Kind()]
+	bus.mutex.RUnlock()
+
+	for _, subscription := range subscriptions {
+		select {
+		case subscription.events <- event:
+		default:
+			log.Debug().Msgf("Dropping %s event, as %s has too many pending",
+				event.Kind(), subscription.name)
+			if bus.dropped != nil {
+				bus.dropped.Add(bus.ctx, 1, metric.WithAttributes(
+					attribute.String(kindAttribute, string(event.Kind())),
+					attribute.String(subscriberAttribute, subscription.name),
+				))
+			}
+		}
+	}
+}
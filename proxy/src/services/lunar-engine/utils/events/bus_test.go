@@ -0,0 +1,96 @@
+package events_test
+
+import (
+	"context"
+	"lunar/engine/utils/events"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func newTestBus(t *testing.T) (*events.Bus, *sdkMetric.ManualReader) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	return events.NewBus(ctx, meter), reader
+}
+
+func TestBusDeliversEventsToSubscribersOfTheirKind(t *testing.T) {
+	t.Parallel()
+	bus, _ := newTestBus(t)
+	throttled := make(chan events.Event, 1)
+	saturated := make(chan events.Event, 1)
+	bus.Subscribe("throttled", events.KindProviderThrottled, 1,
+		func(event events.Event) { throttled <- event })
+	bus.Subscribe("saturated", events.KindQuotaSaturated, 1,
+		func(event events.Event) { saturated <- event })
+
+	event := events.ProviderThrottled{Provider: "api.com", Until: time.Unix(1000, 0)}
+	bus.Publish(event)
+
+	select {
+	case received := <-throttled:
+		assert.Equal(t, event, received)
+	case <-time.After(time.Second):
+		require.Fail(t, "event was not delivered")
+	}
+	assert.Empty(t, saturated)
+}
+
+func TestBusDropsEventsOfSubscribersWithAFullBuffer(t *testing.T) {
+	t.Parallel()
+	bus, reader := newTestBus(t)
+	handling := make(chan struct{})
+	release := make(chan struct{})
+	bus.Subscribe("slow", events.KindQuotaSaturated, 1, func(events.Event) {
+		handling <- struct{}{}
+		<-release
+	})
+	defer close(release)
+
+	event := events.QuotaSaturated{RemedyName: "test", WindowEnd: time.Unix(1000, 0)}
+	// The first event is being handled and the second one is pending
+	bus.Publish(event)
+	<-handling
+	bus.Publish(event)
+
+	publishedAll := make(chan struct{})
+	go func() {
+		bus.Publish(event)
+		bus.Publish(event)
+		close(publishedAll)
+	}()
+	select {
+	case <-publishedAll:
+	case <-time.After(time.Second):
+		require.Fail(t, "publishing blocked on a slow subscriber")
+	}
+
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+	require.Len(t, resourceMetrics.ScopeMetrics, 1)
+	require.Len(t, resourceMetrics.ScopeMetrics[0].Metrics, 1)
+	dropped := resourceMetrics.ScopeMetrics[0].Metrics[0]
+	assert.Equal(t, "lunar_events.dropped", dropped.Name)
+	sum, ok := dropped.Data.(metricdata.Sum[int64])
+	require.True(t, ok)
+	require.Len(t, sum.DataPoints, 1)
+	assert.Equal(t, int64(2), sum.DataPoints[0].Value)
+	kind, _ := sum.DataPoints[0].Attributes.Value("kind")
+	subscriber, _ := sum.DataPoints[0].Attributes.Value("subscriber")
+	assert.Equal(t, "quota_saturated", kind.AsString())
+	assert.Equal(t, "slow", subscriber.AsString())
+}
+
+func TestNilBusIgnoresEvents(t *testing.T) {
+	t.Parallel()
+	var bus *events.Bus
+
+	bus.Subscribe("test", events.KindProviderThrottled, 1, func(events.Event) {})
+	bus.Publish(events.ProviderThrottled{Provider: "api.com", Until: time.Time{}})
+}
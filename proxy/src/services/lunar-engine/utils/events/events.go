@@ -0,0 +1,51 @@
+package events
+
+import "time"
+
+// Kind identifies a type of event, which subscribers subscribe to
+type Kind string
+
+const (
+	KindProviderThrottled Kind = "provider_throttled"
+	KindQuotaSaturated    Kind = "quota_saturated"
+	KindUpstreamHealth    Kind = "upstream_health"
+)
+
+// Event is published by a plugin for the others to react to.
+// Events only carry values, so they can be handled concurrently.
+type Event interface {
+	Kind() Kind
+}
+
+// ProviderThrottled is published once a provider throttled requests,
+// asking for no requests to be sent to it until the given time
+type ProviderThrottled struct {
+	Provider string
+	Until    time.Time
+}
+
+func (ProviderThrottled) Kind() Kind {
+	return KindProviderThrottled
+}
+
+// QuotaSaturated is published once the quota of a remedy was used up
+// for the window ending at the given time
+type QuotaSaturated struct {
+	RemedyName string
+	WindowEnd  time.Time
+}
+
+func (QuotaSaturated) Kind() Kind {
+	return KindQuotaSaturated
+}
+
+// UpstreamHealth is published once the health of a provider changed,
+// as classified by its recent responses
+type UpstreamHealth struct {
+	Provider string
+	Degraded bool
+}
+
+func (UpstreamHealth) Kind() Kind {
+	return KindUpstreamHealth
+}
@@ -0,0 +1,346 @@
+// Package expression implements a small, sandboxed expression language
+// evaluated over the attributes of a request, e.g.
+//
+//	method == "POST" && startsWith(path, "/batch") ? 5 : int(headers["X-Tier"])
+//
+// Expressions can only access the attributes they are evaluated with and a
+// fixed set of pure functions, and have no loops, so their evaluation is
+// bounded by their size.
+package expression
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var ErrNotInteger = errors.New("expression result is not an integer")
+
+// Attributes are the request attributes an expression can access
+type Attributes struct {
+	Method  string
+	Path    string
+	Headers map[string]string
+}
+
+type Expression struct {
+	source string
+	root   node
+}
+
+type valueKind int
+
+const (
+	numberKind valueKind = iota
+	stringKind
+	boolKind
+)
+
+func (kind valueKind) String() string {
+	switch kind {
+	case numberKind:
+		return "number"
+	case stringKind:
+		return "string"
+	case boolKind:
+		return "bool"
+	}
+	return "unknown"
+}
+
+type evaluation struct {
+	ctx        context.Context
+	attributes Attributes
+}
+
+type node interface {
+	kind() valueKind
+	eval(evaluation *evaluation) (any, error)
+}
+
+// Compile parses the given source into an expression which evaluates to
+// a number. Syntax errors, unknown attributes or functions and
+// mismatching types are all reported here, rather than per evaluation.
+func Compile(source string) (*Expression, error) {
+	root, err := parse(source)
+	if err != nil {
+		return nil, err
+	}
+	if root.kind() != numberKind {
+		return nil, fmt.Errorf("expression must evaluate to a number, not a %v",
+			root.kind())
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+func (expression *Expression) String() string {
+	return expression.source
+}
+
+// EvaluateInt evaluates the expression against the given attributes.
+// Evaluation is aborted once the context is done.
+func (expression *Expression) EvaluateInt(
+	ctx context.Context,
+	attributes Attributes,
+) (int64, error) {
+	result, err := expression.root.eval(&evaluation{
+		ctx:        ctx,
+		attributes: attributes,
+	})
+	if err != nil {
+		return 0, err
+	}
+	number, _ := result.(float64)
+	if number != math.Trunc(number) || math.IsInf(number, 0) {
+		return 0, fmt.Errorf("%w: %v", ErrNotInteger, number)
+	}
+	return int64(number), nil
+}
+
+func (evaluation *evaluation) header(name string) string {
+	if value, found := evaluation.attributes.Headers[name]; found {
+		return value
+	}
+	for key, value := range evaluation.attributes.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+type literalNode struct {
+	valueKind valueKind
+	value     any
+}
+
+func (n *literalNode) kind() valueKind { return n.valueKind }
+
+func (n *literalNode) eval(evaluation *evaluation) (any, error) {
+	return n.value, evaluation.ctx.Err()
+}
+
+type attributeNode struct {
+	name string
+}
+
+func (n *attributeNode) kind() valueKind { return stringKind }
+
+func (n *attributeNode) eval(evaluation *evaluation) (any, error) {
+	if err := evaluation.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if n.name == methodAttribute {
+		return evaluation.attributes.Method, nil
+	}
+	return evaluation.attributes.Path, nil
+}
+
+type headerNode struct {
+	name node
+}
+
+func (n *headerNode) kind() valueKind { return stringKind }
+
+func (n *headerNode) eval(evaluation *evaluation) (any, error) {
+	name, err := n.name.eval(evaluation)
+	if err != nil {
+		return nil, err
+	}
+	return evaluation.header(name.(string)), nil
+}
+
+type unaryNode struct {
+	operator string
+	operand  node
+}
+
+func (n *unaryNode) kind() valueKind { return n.operand.kind() }
+
+func (n *unaryNode) eval(evaluation *evaluation) (any, error) {
+	operand, err := n.operand.eval(evaluation)
+	if err != nil {
+		return nil, err
+	}
+	if n.operator == "!" {
+		return !operand.(bool), nil
+	}
+	return -operand.(float64), nil
+}
+
+type binaryNode struct {
+	operator    string
+	left, right node
+	resultKind  valueKind
+}
+
+func (n *binaryNode) kind() valueKind { return n.resultKind }
+
+func (n *binaryNode) eval(evaluation *evaluation) (any, error) {
+	left, err := n.left.eval(evaluation)
+	if err != nil {
+		return nil, err
+	}
+	// logical operators short-circuit
+	switch n.operator {
+	case "&&":
+		if !left.(bool) {
+			return false, nil
+		}
+		return n.right.eval(evaluation)
+	case "||":
+		if left.(bool) {
+			return true, nil
+		}
+		return n.right.eval(evaluation)
+	}
+
+	right, err := n.right.eval(evaluation)
+	if err != nil {
+		return nil, err
+	}
+	switch n.operator {
+	case "==":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	}
+	if n.left.kind() == stringKind {
+		return evalStrings(n.operator, left.(string), right.(string)), nil
+	}
+	return evalNumbers(n.operator, left.(float64), right.(float64))
+}
+
+func evalStrings(operator string, left, right string) any {
+	switch operator {
+	case "+":
+		return left + right
+	case "<":
+		return left < right
+	case "<=":
+		return left <= right
+	case ">":
+		return left > right
+	default:
+		return left >= right
+	}
+}
+
+func evalNumbers(operator string, left, right float64) (any, error) {
+	switch operator {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/", "%":
+		if right == 0 {
+			return nil, errors.New("division by zero")
+		}
+		if operator == "%" {
+			return math.Mod(left, right), nil
+		}
+		return left / right, nil
+	case "<":
+		return left < right, nil
+	case "<=":
+		return left <= right, nil
+	case ">":
+		return left > right, nil
+	default:
+		return left >= right, nil
+	}
+}
+
+type conditionalNode struct {
+	condition, then, otherwise node
+}
+
+func (n *conditionalNode) kind() valueKind { return n.then.kind() }
+
+func (n *conditionalNode) eval(evaluation *evaluation) (any, error) {
+	condition, err := n.condition.eval(evaluation)
+	if err != nil {
+		return nil, err
+	}
+	if condition.(bool) {
+		return n.then.eval(evaluation)
+	}
+	return n.otherwise.eval(evaluation)
+}
+
+type function struct {
+	params     []valueKind
+	resultKind valueKind
+	call       func(args []any) (any, error)
+}
+
+// functions are the only functions expressions can call
+var functions = map[string]function{
+	"int": {
+		params:     []valueKind{stringKind},
+		resultKind: numberKind,
+		call: func(args []any) (any, error) {
+			value := strings.TrimSpace(args[0].(string))
+			if value == "" {
+				return float64(0), nil
+			}
+			number, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("cannot convert '%v' to int", value)
+			}
+			return float64(number), nil
+		},
+	},
+	"lower": {
+		params:     []valueKind{stringKind},
+		resultKind: stringKind,
+		call: func(args []any) (any, error) {
+			return strings.ToLower(args[0].(string)), nil
+		},
+	},
+	"contains": {
+		params:     []valueKind{stringKind, stringKind},
+		resultKind: boolKind,
+		call: func(args []any) (any, error) {
+			return strings.Contains(args[0].(string), args[1].(string)), nil
+		},
+	},
+	"startsWith": {
+		params:     []valueKind{stringKind, stringKind},
+		resultKind: boolKind,
+		call: func(args []any) (any, error) {
+			return strings.HasPrefix(args[0].(string), args[1].(string)), nil
+		},
+	},
+	"endsWith": {
+		params:     []valueKind{stringKind, stringKind},
+		resultKind: boolKind,
+		call: func(args []any) (any, error) {
+			return strings.HasSuffix(args[0].(string), args[1].(string)), nil
+		},
+	},
+}
+
+type callNode struct {
+	function function
+	args     []node
+}
+
+func (n *callNode) kind() valueKind { return n.function.resultKind }
+
+func (n *callNode) eval(evaluation *evaluation) (any, error) {
+	args := make([]any, 0, len(n.args))
+	for _, arg := range n.args {
+		value, err := arg.eval(evaluation)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, value)
+	}
+	return n.function.call(args)
+}
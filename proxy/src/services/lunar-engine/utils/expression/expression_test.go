@@ -0,0 +1,110 @@
+package expression_test
+
+import (
+	"context"
+	"lunar/engine/utils/expression"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func requestAttributes() expression.Attributes {
+	return expression.Attributes{
+		Method: "POST",
+		Path:   "/batch/users",
+		Headers: map[string]string{
+			"X-Tier":   "3",
+			"X-Client": "Mobile",
+		},
+	}
+}
+
+func TestEvaluateInt(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		source string
+		want   int64
+	}{
+		{`7`, 7},
+		{`1 + 2 * 3`, 7},
+		{`(1 + 2) * 3`, 9},
+		{`-2 + 10 % 4`, 0},
+		{`int(headers["X-Tier"]) * 2`, 6},
+		{`int(headers["x-tier"])`, 3},
+		{`int(headers["X-Missing"])`, 0},
+		{`method == "POST" ? 1 : 2`, 1},
+		{`method != "POST" ? 1 : 2`, 2},
+		{`startsWith(path, "/batch") && !contains(path, "admin") ? 5 : 0`, 5},
+		{`endsWith(path, "/orders") || lower(headers["X-Client"]) == "web" ? 1 : 9`, 9},
+		{`headers["X-" + "Client"] == 'Mobile' ? 1 : 0`, 1},
+		{`int(headers["X-Tier"]) >= 3 ? int(headers["X-Tier"]) < 5 ? 4 : 8 : 0`, 4},
+	}
+
+	for _, test := range tests {
+		compiled, err := expression.Compile(test.source)
+		require.NoError(t, err, test.source)
+		priority, err := compiled.EvaluateInt(
+			context.Background(), requestAttributes())
+		require.NoError(t, err, test.source)
+		require.Equal(t, test.want, priority, test.source)
+	}
+}
+
+func TestCompileFailsOnInvalidExpressions(t *testing.T) {
+	t.Parallel()
+	sources := []string{
+		``,
+		`1 +`,
+		`(1 + 2`,
+		`headers["X-Tier"`,
+		`"unterminated`,
+		`1 $ 2`,
+		`os.Getenv("HOME")`,
+		`body`,
+		`headers`,
+		`exec("ls")`,
+		`int()`,
+		`int(1)`,
+		`method`,
+		`method == "GET"`,
+		`1 + "1"`,
+		`method == "GET" ? 1 : "2"`,
+		`method ? 1 : 2`,
+		`!1`,
+		`1 2`,
+	}
+
+	for _, source := range sources {
+		_, err := expression.Compile(source)
+		require.Error(t, err, source)
+	}
+}
+
+func TestEvaluateIntFailsOnRuntimeErrors(t *testing.T) {
+	t.Parallel()
+	sources := []string{
+		`int(path)`,
+		`1 / (int(headers["X-Tier"]) - 3)`,
+		`7 / 2`,
+	}
+
+	for _, source := range sources {
+		compiled, err := expression.Compile(source)
+		require.NoError(t, err, source)
+		_, err = compiled.EvaluateInt(context.Background(), requestAttributes())
+		require.Error(t, err, source)
+	}
+}
+
+func TestEvaluateIntIsAbortedOnTimeout(t *testing.T) {
+	t.Parallel()
+	compiled, err := expression.Compile(`int(headers["X-Tier"]) + 1`)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	_, err = compiled.EvaluateInt(ctx, requestAttributes())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
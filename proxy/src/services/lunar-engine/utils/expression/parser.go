@@ -0,0 +1,397 @@
+package expression
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	methodAttribute  = "method"
+	pathAttribute    = "path"
+	headersAttribute = "headers"
+
+	// maxSourceLength bounds the size, and so the evaluation time,
+	// of expressions
+	maxSourceLength = 4096
+)
+
+type tokenType int
+
+const (
+	endToken tokenType = iota
+	numberToken
+	stringToken
+	identifierToken
+	operatorToken
+)
+
+type token struct {
+	tokenType tokenType
+	value     string
+	position  int
+}
+
+// operators are ordered so longer operators are matched first
+var operators = []string{
+	"==", "!=", "<=", ">=", "&&", "||",
+	"<", ">", "+", "-", "*", "/", "%", "!", "?", ":", "(", ")", "[", "]", ",",
+}
+
+func tokenize(source string) ([]token, error) {
+	tokens := []token{}
+	for position := 0; position < len(source); {
+		char := rune(source[position])
+		switch {
+		case unicode.IsSpace(char):
+			position++
+		case unicode.IsDigit(char):
+			start := position
+			for position < len(source) &&
+				(unicode.IsDigit(rune(source[position])) || source[position] == '.') {
+				position++
+			}
+			tokens = append(tokens, token{numberToken, source[start:position], start})
+		case char == '"' || char == '\'':
+			start := position
+			value, length, err := readString(source[position:])
+			if err != nil {
+				return nil, fmt.Errorf("%w at position %d", err, start)
+			}
+			position += length
+			tokens = append(tokens, token{stringToken, value, start})
+		case unicode.IsLetter(char) || char == '_':
+			start := position
+			for position < len(source) && isIdentifierChar(rune(source[position])) {
+				position++
+			}
+			tokens = append(tokens,
+				token{identifierToken, source[start:position], start})
+		default:
+			operator, found := matchOperator(source[position:])
+			if !found {
+				return nil, fmt.Errorf("unexpected character '%c' at position %d",
+					char, position)
+			}
+			tokens = append(tokens, token{operatorToken, operator, position})
+			position += len(operator)
+		}
+	}
+	return append(tokens, token{endToken, "", len(source)}), nil
+}
+
+func isIdentifierChar(char rune) bool {
+	return unicode.IsLetter(char) || unicode.IsDigit(char) || char == '_'
+}
+
+func matchOperator(source string) (string, bool) {
+	for _, operator := range operators {
+		if strings.HasPrefix(source, operator) {
+			return operator, true
+		}
+	}
+	return "", false
+}
+
+// readString reads a quoted string literal and returns its unquoted value
+// along with the length of the literal
+func readString(source string) (string, int, error) {
+	quote := source[0]
+	var value strings.Builder
+	for position := 1; position < len(source); position++ {
+		switch source[position] {
+		case quote:
+			return value.String(), position + 1, nil
+		case '\\':
+			if position+1 < len(source) {
+				position++
+				value.WriteByte(source[position])
+			}
+		default:
+			value.WriteByte(source[position])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+type parser struct {
+	tokens   []token
+	position int
+}
+
+func parse(source string) (node, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, fmt.Errorf("expression is empty")
+	}
+	if len(source) > maxSourceLength {
+		return nil, fmt.Errorf("expression is longer than %d characters",
+			maxSourceLength)
+	}
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := &parser{tokens: tokens, position: 0}
+	root, err := parser.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+	if next := parser.peek(); next.tokenType != endToken {
+		return nil, fmt.Errorf("unexpected '%v' at position %d",
+			next.value, next.position)
+	}
+	return root, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.position]
+}
+
+func (p *parser) next() token {
+	current := p.tokens[p.position]
+	if current.tokenType != endToken {
+		p.position++
+	}
+	return current
+}
+
+// accept consumes the next token if it is one of the given operators
+func (p *parser) accept(operators ...string) (string, bool) {
+	next := p.peek()
+	if next.tokenType != operatorToken {
+		return "", false
+	}
+	for _, operator := range operators {
+		if next.value == operator {
+			p.position++
+			return operator, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(operator string) error {
+	if _, found := p.accept(operator); !found {
+		next := p.peek()
+		if next.tokenType == endToken {
+			return fmt.Errorf("expected '%v' at end of expression", operator)
+		}
+		return fmt.Errorf("expected '%v' at position %d, found '%v'",
+			operator, next.position, next.value)
+	}
+	return nil
+}
+
+func (p *parser) parseConditional() (node, error) {
+	condition, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, found := p.accept("?"); !found {
+		return condition, nil
+	}
+	then, err := p.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+	if condition.kind() != boolKind {
+		return nil, fmt.Errorf("condition must be a bool, not a %v",
+			condition.kind())
+	}
+	if then.kind() != otherwise.kind() {
+		return nil, fmt.Errorf("conditional branches must be of the same type,"+
+			" found %v and %v", then.kind(), otherwise.kind())
+	}
+	return &conditionalNode{
+		condition: condition,
+		then:      then,
+		otherwise: otherwise,
+	}, nil
+}
+
+// binaryPrecedence lists the binary operators from the lowest precedence
+var binaryPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) parseBinary(level int) (node, error) {
+	if level == len(binaryPrecedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator, found := p.accept(binaryPrecedence[level]...)
+		if !found {
+			return left, nil
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newBinaryNode(operator, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func newBinaryNode(operator string, left, right node) (node, error) {
+	if left.kind() != right.kind() {
+		return nil, fmt.Errorf("cannot apply '%v' to a %v and a %v",
+			operator, left.kind(), right.kind())
+	}
+	resultKind := boolKind
+	switch operator {
+	case "&&", "||":
+		if left.kind() != boolKind {
+			return nil, fmt.Errorf("cannot apply '%v' to a %v",
+				operator, left.kind())
+		}
+	case "<", "<=", ">", ">=":
+		if left.kind() == boolKind {
+			return nil, fmt.Errorf("cannot apply '%v' to a bool", operator)
+		}
+	case "+":
+		if left.kind() == boolKind {
+			return nil, fmt.Errorf("cannot apply '+' to a bool")
+		}
+		resultKind = left.kind()
+	case "-", "*", "/", "%":
+		if left.kind() != numberKind {
+			return nil, fmt.Errorf("cannot apply '%v' to a %v",
+				operator, left.kind())
+		}
+		resultKind = numberKind
+	}
+	return &binaryNode{
+		operator:   operator,
+		left:       left,
+		right:      right,
+		resultKind: resultKind,
+	}, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	operator, found := p.accept("!", "-")
+	if !found {
+		return p.parsePrimary()
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	wantKind := numberKind
+	if operator == "!" {
+		wantKind = boolKind
+	}
+	if operand.kind() != wantKind {
+		return nil, fmt.Errorf("cannot apply '%v' to a %v",
+			operator, operand.kind())
+	}
+	return &unaryNode{operator: operator, operand: operand}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	current := p.next()
+	switch current.tokenType {
+	case numberToken:
+		number, err := strconv.ParseFloat(current.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%v' at position %d",
+				current.value, current.position)
+		}
+		return &literalNode{valueKind: numberKind, value: number}, nil
+	case stringToken:
+		return &literalNode{valueKind: stringKind, value: current.value}, nil
+	case identifierToken:
+		return p.parseIdentifier(current)
+	case operatorToken:
+		if current.value == "(" {
+			inner, err := p.parseConditional()
+			if err != nil {
+				return nil, err
+			}
+			return inner, p.expect(")")
+		}
+		return nil, fmt.Errorf("unexpected '%v' at position %d",
+			current.value, current.position)
+	case endToken:
+	}
+	return nil, fmt.Errorf("unexpected end of expression")
+}
+
+func (p *parser) parseIdentifier(identifier token) (node, error) {
+	switch identifier.value {
+	case "true", "false":
+		return &literalNode{
+			valueKind: boolKind,
+			value:     identifier.value == "true",
+		}, nil
+	case methodAttribute, pathAttribute:
+		return &attributeNode{name: identifier.value}, nil
+	case headersAttribute:
+		if err := p.expect("["); err != nil {
+			return nil, err
+		}
+		name, err := p.parseConditional()
+		if err != nil {
+			return nil, err
+		}
+		if name.kind() != stringKind {
+			return nil, fmt.Errorf("header name must be a string, not a %v",
+				name.kind())
+		}
+		return &headerNode{name: name}, p.expect("]")
+	}
+
+	function, found := functions[identifier.value]
+	if !found {
+		return nil, fmt.Errorf("unknown identifier '%v' at position %d",
+			identifier.value, identifier.position)
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	args := []node{}
+	if _, closed := p.accept(")"); !closed {
+		for {
+			arg, err := p.parseConditional()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, more := p.accept(","); !more {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+	if len(args) != len(function.params) {
+		return nil, fmt.Errorf("%v expects %d arguments, got %d",
+			identifier.value, len(function.params), len(args))
+	}
+	for index, arg := range args {
+		if arg.kind() != function.params[index] {
+			return nil, fmt.Errorf("argument %d of %v must be a %v, not a %v",
+				index+1, identifier.value, function.params[index], arg.kind())
+		}
+	}
+	return &callNode{function: function, args: args}, nil
+}
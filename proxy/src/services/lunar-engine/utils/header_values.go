@@ -0,0 +1,45 @@
+package utils
+
+import (
+	sharedConfig "lunar/shared-model/config"
+	"strings"
+)
+
+// HeaderValues holds every value each header was sent with, keyed by the
+// lowercased header name, so headers are looked up case-insensitively
+// and headers sent multiple times keep all of their values
+type HeaderValues map[string][]string
+
+// LookupHeader looks the header up case-insensitively in the given values,
+// resolving a header sent multiple times by the given policy, or in the
+// given headers for the headers which are not in the values
+func LookupHeader(
+	headers map[string]string,
+	values HeaderValues,
+	name string,
+	multiValue sharedConfig.MultiValueHeader,
+) (string, bool) {
+	if headerValues, found := values[strings.ToLower(name)]; found {
+		return sharedConfig.ResolveHeaderValues(headerValues, multiValue), true
+	}
+	return sharedConfig.HeaderMap(headers).Header(name, multiValue)
+}
+
+// SetHeaderValue sets the single value of the header in the given values
+func (values HeaderValues) SetHeaderValue(name string, value string) {
+	if values == nil {
+		return
+	}
+	values[strings.ToLower(name)] = []string{value}
+}
+
+func DeepCopyHeaderValues(values HeaderValues) HeaderValues {
+	if values == nil {
+		return nil
+	}
+	targetMap := make(HeaderValues, len(values))
+	for name, headerValues := range values {
+		targetMap[name] = append([]string{}, headerValues...)
+	}
+	return targetMap
+}
@@ -13,6 +13,13 @@ import (
 
 // Adapted from https://stackoverflow.com/a/22562773
 func ParseHeaders(raw *string) map[string]string {
+	headers, _ := ParseHeadersWithValues(raw)
+	return headers
+}
+
+// ParseHeadersWithValues parses the headers as ParseHeaders does, along with
+// every value of each header, for headers sent multiple times
+func ParseHeadersWithValues(raw *string) (map[string]string, HeaderValues) {
 	reader := bufio.NewReader(strings.NewReader(*raw + "\r\n"))
 	tp := textproto.NewReader(reader)
 
@@ -21,7 +28,7 @@ func ParseHeaders(raw *string) map[string]string {
 		log.Warn().
 			Err(err).
 			Msg("failed to parse headers, will continue without any headers")
-		return map[string]string{}
+		return map[string]string{}, HeaderValues{}
 	}
 
 	httpHeader := http.Header(mimeHeader)
@@ -33,7 +40,9 @@ func ParseHeaders(raw *string) map[string]string {
 		return strings[0]
 	}
 	res := lo.MapValues(httpHeader, getFirstValue)
-	return res
+	return res, lo.MapKeys(httpHeader, func(_ []string, name string) string {
+		return strings.ToLower(name)
+	})
 }
 
 func DumpHeaders(headers map[string]string) string {
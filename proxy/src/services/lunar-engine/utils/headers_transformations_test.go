@@ -36,6 +36,21 @@ func TestParseHeaders(t *testing.T) {
 	assert.Equal(t, res, want)
 }
 
+func TestParseHeadersWithValuesKeepsEveryValueOfDuplicateHeaders(t *testing.T) {
+	t.Parallel()
+	input := "X-Tier: free\nx-tier: premium\nContent-Type: application/json\n"
+	headers, values := ParseHeadersWithValues(&input)
+
+	assert.Equal(t, map[string]string{
+		"X-Tier":       "free",
+		"Content-Type": "application/json",
+	}, headers)
+	assert.Equal(t, HeaderValues{
+		"x-tier":       {"free", "premium"},
+		"content-type": {"application/json"},
+	}, values)
+}
+
 func TestTransformSlice(t *testing.T) {
 	t.Parallel()
 	input := []string{"hello", "world"}
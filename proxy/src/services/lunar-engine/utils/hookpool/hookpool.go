@@ -0,0 +1,253 @@
+package hookpool
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	DefaultMaxIdleConns = 100
+	// DefaultMaxConnsPerHost of 0 leaves the connections per host unbounded
+	DefaultMaxConnsPerHost = 0
+	DefaultIdleConnTimeout = 90 * time.Second
+	DefaultForceHTTP2      = true
+
+	connectionsMetricName = "lunar_remedies.decision_hook.pool.connections"
+	stateAttributeName    = "state"
+	stateIdle             = "idle"
+	stateActive           = "active"
+
+	dialTimeout   = 30 * time.Second
+	dialKeepAlive = 30 * time.Second
+)
+
+// Settings tunes the connections the engine keeps to decision hook endpoints
+type Settings struct {
+	// MaxIdleConns bounds the idle connections kept, overall and per host
+	MaxIdleConns int
+	// MaxConnsPerHost bounds the connections to each host, 0 for no bound
+	MaxConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept before closed
+	IdleConnTimeout time.Duration
+	// ForceHTTP2 attempts HTTP/2 for hooks even though the transport
+	// is customized, which would otherwise disable it
+	ForceHTTP2 bool
+}
+
+func DefaultSettings() Settings {
+	return Settings{
+		MaxIdleConns:    DefaultMaxIdleConns,
+		MaxConnsPerHost: DefaultMaxConnsPerHost,
+		IdleConnTimeout: DefaultIdleConnTimeout,
+		ForceHTTP2:      DefaultForceHTTP2,
+	}
+}
+
+// Stats counts the open connections of the pool by whether they
+// serve a request (active), or wait for one (idle)
+type Stats struct {
+	Idle   int
+	Active int
+}
+
+// Pool is the connection pool of the clients calling decision hooks.
+// Its transport tracks the connections it opens, so their number is
+// reported by state. A connection is active while any request it
+// carries did not have its response body closed yet.
+type Pool struct {
+	transport *http.Transport
+
+	mutex       sync.Mutex
+	connections map[*trackedConn]int
+}
+
+func NewPool(settings Settings, meter metric.Meter) *Pool {
+	pool := &Pool{
+		transport:   nil,
+		mutex:       sync.Mutex{},
+		connections: map[*trackedConn]int{},
+	}
+	dialer := &net.Dialer{ //nolint:exhaustruct
+		Timeout:   dialTimeout,
+		KeepAlive: dialKeepAlive,
+	}
+	pool.transport = &http.Transport{ //nolint:exhaustruct
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         pool.dialContext(dialer),
+		MaxIdleConns:        settings.MaxIdleConns,
+		MaxIdleConnsPerHost: settings.MaxIdleConns,
+		MaxConnsPerHost:     settings.MaxConnsPerHost,
+		IdleConnTimeout:     settings.IdleConnTimeout,
+		ForceAttemptHTTP2:   settings.ForceHTTP2,
+	}
+	if meter != nil {
+		pool.observeConnections(meter)
+	}
+	return pool
+}
+
+// Transport returns the underlying transport, as tuned by the settings
+func (pool *Pool) Transport() *http.Transport {
+	return pool.transport
+}
+
+// Client returns a client sending its requests through the pool
+func (pool *Pool) Client(timeout time.Duration) *http.Client {
+	return &http.Client{ //nolint:exhaustruct
+		Transport: pool,
+		Timeout:   timeout,
+	}
+}
+
+func (pool *Pool) Stats() Stats {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	stats := Stats{Idle: 0, Active: 0}
+	for _, inFlight := range pool.connections {
+		if inFlight > 0 {
+			stats.Active++
+		} else {
+			stats.Idle++
+		}
+	}
+	return stats
+}
+
+// CloseIdleConnections closes the connections which serve no request
+func (pool *Pool) CloseIdleConnections() {
+	pool.transport.CloseIdleConnections()
+}
+
+// RoundTrip sends the request through the transport, marking the connection
+// it got active until the response body is closed
+func (pool *Pool) RoundTrip(request *http.Request) (*http.Response, error) {
+	var connection *trackedConn
+	trace := &httptrace.ClientTrace{ //nolint:exhaustruct
+		GotConn: func(info httptrace.GotConnInfo) {
+			connection = unwrapConn(info.Conn)
+			pool.updateInFlight(connection, 1)
+		},
+	}
+	request = request.WithContext(httptrace.WithClientTrace(request.Context(), trace))
+	response, err := pool.transport.RoundTrip(request)
+	if err != nil {
+		pool.updateInFlight(connection, -1)
+		return nil, err
+	}
+	response.Body = &trackedBody{
+		ReadCloser: response.Body,
+		once:       sync.Once{},
+		release:    func() { pool.updateInFlight(connection, -1) },
+	}
+	return response, nil
+}
+
+func (pool *Pool) dialContext(
+	dialer *net.Dialer,
+) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		connection := &trackedConn{Conn: conn, once: sync.Once{}, pool: pool}
+		pool.mutex.Lock()
+		pool.connections[connection] = 0
+		pool.mutex.Unlock()
+		return connection, nil
+	}
+}
+
+func (pool *Pool) updateInFlight(connection *trackedConn, delta int) {
+	if connection == nil {
+		return
+	}
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	inFlight, found := pool.connections[connection]
+	if !found {
+		return
+	}
+	inFlight += delta
+	if inFlight < 0 {
+		inFlight = 0
+	}
+	pool.connections[connection] = inFlight
+}
+
+func (pool *Pool) forget(connection *trackedConn) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	delete(pool.connections, connection)
+}
+
+func (pool *Pool) observeConnections(meter metric.Meter) {
+	_, err := meter.Int64ObservableGauge(
+		connectionsMetricName,
+		metric.WithDescription("The number of open connections to decision hooks, "+
+			"by whether they serve a request (active) or wait for one (idle)"),
+		metric.WithUnit("{connection}"),
+		metric.WithInt64Callback(
+			func(_ context.Context, observer metric.Int64Observer) error {
+				stats := pool.Stats()
+				observer.Observe(int64(stats.Idle), metric.WithAttributes(
+					attribute.String(stateAttributeName, stateIdle)))
+				observer.Observe(int64(stats.Active), metric.WithAttributes(
+					attribute.String(stateAttributeName, stateActive)))
+				return nil
+			}),
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to initialize %s metric",
+			connectionsMetricName)
+	}
+}
+
+// unwrapConn returns the tracked connection under the one the transport
+// got, which TLS connections wrap
+func unwrapConn(conn net.Conn) *trackedConn {
+	for conn != nil {
+		if connection, isTracked := conn.(*trackedConn); isTracked {
+			return connection
+		}
+		wrapper, isWrapper := conn.(interface{ NetConn() net.Conn })
+		if !isWrapper {
+			return nil
+		}
+		conn = wrapper.NetConn()
+	}
+	return nil
+}
+
+// trackedConn is a connection opened by the pool, forgotten once closed
+type trackedConn struct {
+	net.Conn
+	once sync.Once
+	pool *Pool
+}
+
+func (connection *trackedConn) Close() error {
+	connection.once.Do(func() { connection.pool.forget(connection) })
+	return connection.Conn.Close()
+}
+
+// trackedBody releases the connection of its response once closed
+type trackedBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (body *trackedBody) Close() error {
+	body.once.Do(body.release)
+	return body.ReadCloser.Close()
+}
@@ -0,0 +1,130 @@
+package hookpool_test
+
+import (
+	"context"
+	"io"
+	"lunar/engine/utils/hookpool"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func collectConnections(t *testing.T, reader *sdkMetric.ManualReader) map[string]int64 {
+	t.Helper()
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+	connections := map[string]int64{}
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, collected := range scopeMetrics.Metrics {
+			if collected.Name != "lunar_remedies.decision_hook.pool.connections" {
+				continue
+			}
+			gauge, isGauge := collected.Data.(metricdata.Gauge[int64])
+			require.True(t, isGauge)
+			for _, point := range gauge.DataPoints {
+				state, _ := point.Attributes.Value("state")
+				connections[state.AsString()] = point.Value
+			}
+		}
+	}
+	return connections
+}
+
+func TestNewPoolAppliesTheSettingsToItsTransport(t *testing.T) {
+	t.Parallel()
+	pool := hookpool.NewPool(hookpool.Settings{
+		MaxIdleConns:    7,
+		MaxConnsPerHost: 3,
+		IdleConnTimeout: 5 * time.Second,
+		ForceHTTP2:      false,
+	}, nil)
+
+	transport := pool.Transport()
+	assert.Equal(t, 7, transport.MaxIdleConns)
+	assert.Equal(t, 7, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 3, transport.MaxConnsPerHost)
+	assert.Equal(t, 5*time.Second, transport.IdleConnTimeout)
+	assert.False(t, transport.ForceAttemptHTTP2)
+
+	defaults := hookpool.NewPool(hookpool.DefaultSettings(), nil).Transport()
+	assert.Equal(t, hookpool.DefaultMaxIdleConns, defaults.MaxIdleConns)
+	assert.Equal(t, hookpool.DefaultMaxConnsPerHost, defaults.MaxConnsPerHost)
+	assert.Equal(t, hookpool.DefaultIdleConnTimeout, defaults.IdleConnTimeout)
+	assert.True(t, defaults.ForceAttemptHTTP2)
+}
+
+func TestPoolReportsItsConnectionsByState(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, _ *http.Request) {
+			_, _ = writer.Write([]byte("ok"))
+		}))
+	defer server.Close()
+
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	pool := hookpool.NewPool(hookpool.DefaultSettings(), meter)
+	client := pool.Client(time.Second)
+	assert.Equal(t, map[string]int64{"idle": 0, "active": 0},
+		collectConnections(t, reader))
+
+	response, err := client.Get(server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"idle": 0, "active": 1},
+		collectConnections(t, reader))
+
+	_, err = io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+	assert.Equal(t, map[string]int64{"idle": 1, "active": 0},
+		collectConnections(t, reader))
+
+	// the idle connection is reused rather than a new one opened
+	response, err = client.Get(server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, hookpool.Stats{Idle: 0, Active: 1}, pool.Stats())
+	_, _ = io.ReadAll(response.Body)
+	require.NoError(t, response.Body.Close())
+
+	pool.CloseIdleConnections()
+	assert.Equal(t, map[string]int64{"idle": 0, "active": 0},
+		collectConnections(t, reader))
+}
+
+func TestPoolBoundsTheConnectionsPerHost(t *testing.T) {
+	t.Parallel()
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, _ *http.Request) {
+			<-release
+			_, _ = writer.Write([]byte("ok"))
+		}))
+	defer server.Close()
+	defer close(release)
+
+	settings := hookpool.DefaultSettings()
+	settings.MaxConnsPerHost = 1
+	pool := hookpool.NewPool(settings, nil)
+	client := pool.Client(0)
+
+	for i := 0; i < 2; i++ {
+		go func() {
+			response, err := client.Get(server.URL)
+			if err == nil {
+				_ = response.Body.Close()
+			}
+		}()
+	}
+	assert.Eventually(t, func() bool {
+		return pool.Stats().Active == 1
+	}, time.Second, 10*time.Millisecond)
+	// the second request waits for the connection rather than opening one
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, hookpool.Stats{Idle: 0, Active: 1}, pool.Stats())
+}
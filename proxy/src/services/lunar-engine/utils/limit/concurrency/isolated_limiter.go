@@ -0,0 +1,119 @@
+package concurrency
+
+import (
+	"lunar/toolkit-core/clock"
+	"lunar/toolkit-core/vacuum"
+	"sync"
+	"time"
+)
+
+// isolatedSlot is a slot taken of an IsolatedLimiter,
+// either one reserved for its scope or a shared one
+type isolatedSlot struct {
+	scope    string
+	isShared bool
+}
+
+// IsolatedLimiter limits concurrency by a budget partitioned by scope:
+// requests take the slots reserved for their scope first, and overflow to
+// a pool of shared slots once those are taken. A scope saturating its slots
+// and the shared ones thus cannot take the slots reserved for the others.
+// Requests of a scope with no slots reserved take shared slots only.
+type IsolatedLimiter struct {
+	mutex         *sync.RWMutex
+	slots         map[string]isolatedSlot
+	slotsVacuum   vacuum.MapVacuum[string, isolatedSlot]
+	reservedSlots map[string]int
+	sharedSlots   int
+	takenReserved map[string]int
+	takenShared   int
+}
+
+func NewIsolatedLimiter(
+	reservedSlots map[string]int,
+	sharedSlots int,
+	ttl time.Duration,
+	vacuumTick time.Duration,
+	clock clock.Clock,
+) *IsolatedLimiter {
+	slots := map[string]isolatedSlot{}
+	mutex := sync.RWMutex{}
+	limiter := &IsolatedLimiter{
+		mutex: &mutex,
+		slots: slots,
+		slotsVacuum: vacuum.NewMapVacuum[string, isolatedSlot](
+			"IsolatedConcurrencyLimiter",
+			clock,
+			ttl,
+			vacuumTick,
+			slots,
+			&mutex,
+		),
+		reservedSlots: reservedSlots,
+		sharedSlots:   sharedSlots,
+		takenReserved: map[string]int{},
+		takenShared:   0,
+	}
+	// slots left unreleased past their TTL are given back to their partition
+	limiter.slotsVacuum.OnVacuum(func(_ string, slot isolatedSlot) {
+		limiter.giveBack(slot)
+	})
+	return limiter
+}
+
+func (limiter *IsolatedLimiter) TryTakeSlot(id string, scope string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if _, found := limiter.slots[id]; found {
+		return true
+	}
+	slot := isolatedSlot{scope: scope, isShared: false}
+	switch {
+	case limiter.takenReserved[scope] < limiter.reservedSlots[scope]:
+		limiter.takenReserved[scope]++
+	case limiter.takenShared < limiter.sharedSlots:
+		slot.isShared = true
+		limiter.takenShared++
+	default:
+		return false
+	}
+
+	limiter.slots[id] = slot
+	limiter.slotsVacuum.VacuumKey(id)
+	return true
+}
+
+func (limiter *IsolatedLimiter) ReleaseSlot(id string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	slot, found := limiter.slots[id]
+	if !found {
+		return
+	}
+	delete(limiter.slots, id)
+	limiter.giveBack(slot)
+}
+
+// SetPartition partitions the budget anew. Slots already taken are kept,
+// a scope holding more than its new reservation overflowing to the shared
+// slots until enough of them were released.
+func (limiter *IsolatedLimiter) SetPartition(reservedSlots map[string]int, sharedSlots int) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.reservedSlots = reservedSlots
+	limiter.sharedSlots = sharedSlots
+}
+
+// giveBack returns the slot to the partition it was taken of,
+// it must be called while the mutex is held
+func (limiter *IsolatedLimiter) giveBack(slot isolatedSlot) {
+	if slot.isShared {
+		limiter.takenShared--
+		return
+	}
+	limiter.takenReserved[slot.scope]--
+	if limiter.takenReserved[slot.scope] == 0 {
+		delete(limiter.takenReserved, slot.scope)
+	}
+}
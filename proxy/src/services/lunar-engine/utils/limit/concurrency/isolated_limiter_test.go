@@ -0,0 +1,72 @@
+package concurrency_test
+
+import (
+	"lunar/engine/utils/limit/concurrency"
+	"lunar/toolkit-core/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsolatedLimiterKeepsTheReservedSlotsOfEachScope(t *testing.T) {
+	t.Parallel()
+	ttl := 80 * time.Millisecond
+	vacuumTick := 10 * time.Millisecond
+	limiter := concurrency.NewIsolatedLimiter(
+		map[string]int{"search": 1, "checkout": 2}, 1, ttl, vacuumTick,
+		clock.NewMockClock())
+
+	// search saturates its reserved slot and the shared one
+	assert.True(t, limiter.TryTakeSlot("search-1", "search"))
+	assert.True(t, limiter.TryTakeSlot("search-2", "search"))
+	assert.False(t, limiter.TryTakeSlot("search-3", "search"))
+	// requests in no scope take shared slots only
+	assert.False(t, limiter.TryTakeSlot("other-1", ""))
+
+	// checkout still takes its reserved slots, but no more
+	assert.True(t, limiter.TryTakeSlot("checkout-1", "checkout"))
+	assert.True(t, limiter.TryTakeSlot("checkout-2", "checkout"))
+	assert.False(t, limiter.TryTakeSlot("checkout-3", "checkout"))
+
+	// releasing the shared slot lets any scope overflow to it
+	limiter.ReleaseSlot("search-2")
+	limiter.ReleaseSlot("search-2")
+	assert.True(t, limiter.TryTakeSlot("checkout-3", "checkout"))
+	assert.False(t, limiter.TryTakeSlot("search-3", "search"))
+
+	// releasing a reserved slot gives it back to its scope only
+	limiter.ReleaseSlot("search-1")
+	assert.False(t, limiter.TryTakeSlot("checkout-4", "checkout"))
+	assert.True(t, limiter.TryTakeSlot("search-3", "search"))
+}
+
+func TestIsolatedLimiterDoesntTakeSlotTwiceForSameID(t *testing.T) {
+	t.Parallel()
+	ttl := 80 * time.Millisecond
+	vacuumTick := 10 * time.Millisecond
+	limiter := concurrency.NewIsolatedLimiter(
+		map[string]int{"search": 1}, 0, ttl, vacuumTick, clock.NewMockClock())
+
+	assert.True(t, limiter.TryTakeSlot("a", "search"))
+	assert.True(t, limiter.TryTakeSlot("a", "search"))
+
+	limiter.ReleaseSlot("a")
+	assert.True(t, limiter.TryTakeSlot("b", "search"))
+}
+
+func TestIsolatedLimiterCanBePartitionedAnew(t *testing.T) {
+	t.Parallel()
+	ttl := 80 * time.Millisecond
+	vacuumTick := 10 * time.Millisecond
+	limiter := concurrency.NewIsolatedLimiter(
+		map[string]int{"search": 1}, 0, ttl, vacuumTick, clock.NewMockClock())
+
+	assert.True(t, limiter.TryTakeSlot("a", "search"))
+	assert.False(t, limiter.TryTakeSlot("b", "search"))
+
+	limiter.SetPartition(map[string]int{"search": 2}, 1)
+	assert.True(t, limiter.TryTakeSlot("b", "search"))
+	assert.True(t, limiter.TryTakeSlot("c", "search"))
+	assert.False(t, limiter.TryTakeSlot("d", "search"))
+}
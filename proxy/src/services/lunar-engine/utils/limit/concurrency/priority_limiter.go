@@ -0,0 +1,179 @@
+package concurrency
+
+import (
+	"lunar/toolkit-core/clock"
+	"sync"
+	"time"
+)
+
+// PriorityLimiter limits the requests in flight at once, like Limiter,
+// while requests wait for a slot rather than being rejected right away.
+// A freed slot is taken by the waiting request of the highest priority
+// (the lowest value), ahead of earlier-arrived requests of lower priorities.
+// To not starve requests of low priority, a waiting request is aged:
+// each agingInterval it waits raises it by a priority level, and
+// requests of the same aged priority take slots in their arrival order.
+type PriorityLimiter struct {
+	mutex            sync.Mutex
+	clock            clock.Clock
+	ttl              time.Duration
+	agingInterval    time.Duration
+	concurrencyLimit int
+	// slots taken, by when they were taken
+	slots   map[string]time.Time
+	waiting []*slotRequest
+}
+
+type slotRequest struct {
+	id        string
+	priority  float64
+	arrivedAt time.Time
+	grantedCh chan struct{}
+	granted   bool
+}
+
+// NewPriorityLimiter creates a limiter whose slots are released
+// once taken for longer than the given TTL
+func NewPriorityLimiter(
+	concurrencyLimit int,
+	ttl time.Duration,
+	agingInterval time.Duration,
+	clock clock.Clock,
+) *PriorityLimiter {
+	return &PriorityLimiter{
+		mutex:            sync.Mutex{},
+		clock:            clock,
+		ttl:              ttl,
+		agingInterval:    agingInterval,
+		concurrencyLimit: concurrencyLimit,
+		slots:            map[string]time.Time{},
+		waiting:          []*slotRequest{},
+	}
+}
+
+// TakeSlot waits until the request takes a slot or the timeout passes,
+// and returns whether it took one
+func (limiter *PriorityLimiter) TakeSlot(
+	id string,
+	priority float64,
+	timeout time.Duration,
+) bool {
+	limiter.mutex.Lock()
+	if _, taken := limiter.slots[id]; taken {
+		limiter.mutex.Unlock()
+		return true
+	}
+	request := &slotRequest{
+		id:        id,
+		priority:  priority,
+		arrivedAt: limiter.clock.Now(),
+		grantedCh: make(chan struct{}),
+		granted:   false,
+	}
+	limiter.waiting = append(limiter.waiting, request)
+	limiter.dispatch()
+	limiter.mutex.Unlock()
+
+	select {
+	case <-request.grantedCh:
+		return true
+	case <-limiter.clock.After(timeout):
+		limiter.mutex.Lock()
+		defer limiter.mutex.Unlock()
+		// The slot might have been granted as the timeout passed
+		if request.granted {
+			return true
+		}
+		limiter.stopWaiting(request)
+		return false
+	}
+}
+
+// ReleaseSlot frees the slot of the request, for a waiting request to take
+func (limiter *PriorityLimiter) ReleaseSlot(id string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	delete(limiter.slots, id)
+	limiter.dispatch()
+}
+
+// WaitingCount returns the number of requests waiting for a slot
+func (limiter *PriorityLimiter) WaitingCount() int {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	return len(limiter.waiting)
+}
+
+func (limiter *PriorityLimiter) ConcurrencyLimit() int {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	return limiter.concurrencyLimit
+}
+
+func (limiter *PriorityLimiter) SetConcurrencyLimit(newLimit int) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.concurrencyLimit = newLimit
+	limiter.dispatch()
+}
+
+// dispatch releases the expired slots and grants the free ones to the
+// waiting requests of the highest aged priority.
+// Must be called while holding the mutex.
+func (limiter *PriorityLimiter) dispatch() {
+	now := limiter.clock.Now()
+	for id, takenAt := range limiter.slots {
+		if now.Sub(takenAt) >= limiter.ttl {
+			delete(limiter.slots, id)
+		}
+	}
+	for len(limiter.slots) < limiter.concurrencyLimit && len(limiter.waiting) > 0 {
+		next := limiter.waiting[0]
+		for _, request := range limiter.waiting[1:] {
+			if limiter.precedes(request, next, now) {
+				next = request
+			}
+		}
+		limiter.stopWaiting(next)
+		limiter.slots[next.id] = now
+		next.granted = true
+		close(next.grantedCh)
+	}
+}
+
+// precedes returns whether a waiting request takes a slot before another
+func (limiter *PriorityLimiter) precedes(
+	request *slotRequest,
+	other *slotRequest,
+	now time.Time,
+) bool {
+	agedPriority := limiter.agedPriority(request, now)
+	otherAgedPriority := limiter.agedPriority(other, now)
+	if agedPriority == otherAgedPriority {
+		return request.arrivedAt.Before(other.arrivedAt)
+	}
+	return agedPriority < otherAgedPriority
+}
+
+func (limiter *PriorityLimiter) agedPriority(
+	request *slotRequest,
+	now time.Time,
+) float64 {
+	if limiter.agingInterval <= 0 {
+		return request.priority
+	}
+	return request.priority -
+		float64(now.Sub(request.arrivedAt))/float64(limiter.agingInterval)
+}
+
+// stopWaiting removes a request from the waiting requests.
+// Must be called while holding the mutex.
+func (limiter *PriorityLimiter) stopWaiting(request *slotRequest) {
+	for index, waiting := range limiter.waiting {
+		if waiting == request {
+			limiter.waiting = append(limiter.waiting[:index],
+				limiter.waiting[index+1:]...)
+			return
+		}
+	}
+}
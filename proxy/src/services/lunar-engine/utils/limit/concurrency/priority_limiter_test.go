@@ -0,0 +1,99 @@
+package concurrency_test
+
+import (
+	"lunar/engine/utils/limit/concurrency"
+	"lunar/toolkit-core/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	slotTTL       = time.Minute
+	slotTimeout   = 30 * time.Second
+	agingInterval = 10 * time.Second
+)
+
+// takeSlotAsync takes a slot in the background, once the request waits for it
+func takeSlotAsync(
+	t *testing.T,
+	limiter *concurrency.PriorityLimiter,
+	id string,
+	priority float64,
+) <-chan bool {
+	waiting := limiter.WaitingCount()
+	taken := make(chan bool, 1)
+	go func() { taken <- limiter.TakeSlot(id, priority, slotTimeout) }()
+	require.Eventually(t, func() bool {
+		return limiter.WaitingCount() == waiting+1
+	}, time.Second, time.Millisecond)
+	return taken
+}
+
+func requireTaken(t *testing.T, taken <-chan bool) {
+	select {
+	case result := <-taken:
+		require.True(t, result)
+	case <-time.After(time.Second):
+		require.Fail(t, "slot was not taken")
+	}
+}
+
+func TestHighPriorityRequestTakesAFreedSlotAheadOfEarlierLowPriorityOne(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	limiter := concurrency.NewPriorityLimiter(1, slotTTL, agingInterval, clock)
+	require.True(t, limiter.TakeSlot("in flight", 0, slotTimeout))
+
+	lowPriority := takeSlotAsync(t, limiter, "low", 2)
+	clock.AdvanceTime(time.Second)
+	highPriority := takeSlotAsync(t, limiter, "high", 0)
+
+	limiter.ReleaseSlot("in flight")
+	requireTaken(t, highPriority)
+	assert.Empty(t, lowPriority)
+
+	// The low priority request progresses once a slot is freed again
+	limiter.ReleaseSlot("high")
+	requireTaken(t, lowPriority)
+}
+
+func TestAgedLowPriorityRequestTakesAFreedSlotAheadOfHighPriorityOne(
+	t *testing.T,
+) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	limiter := concurrency.NewPriorityLimiter(1, slotTTL, agingInterval, clock)
+	require.True(t, limiter.TakeSlot("in flight", 0, slotTimeout))
+
+	// Waiting for over 2 aging intervals raises the request above priority 0
+	lowPriority := takeSlotAsync(t, limiter, "low", 2)
+	clock.AdvanceTime(25 * time.Second)
+	highPriority := takeSlotAsync(t, limiter, "high", 0)
+
+	limiter.ReleaseSlot("in flight")
+	requireTaken(t, lowPriority)
+	assert.Empty(t, highPriority)
+}
+
+func TestRequestWaitingForASlotGivesUpOnTimeout(t *testing.T) {
+	t.Parallel()
+	clock := clock.NewMockClock()
+	limiter := concurrency.NewPriorityLimiter(1, slotTTL, agingInterval, clock)
+	require.True(t, limiter.TakeSlot("in flight", 0, slotTimeout))
+
+	taken := takeSlotAsync(t, limiter, "waiting", 0)
+	clock.AdvanceTime(slotTimeout)
+
+	select {
+	case result := <-taken:
+		assert.False(t, result)
+	case <-time.After(time.Second):
+		require.Fail(t, "request kept waiting after its timeout")
+	}
+	assert.Equal(t, 0, limiter.WaitingCount())
+}
@@ -58,6 +58,8 @@ type IncrementableRateLimitState interface {
 		windowSize WindowData,
 	) (CurrentLimitState, error)
 	Counters() map[RequestArguments]int64
+	// Forget drops the state of a quota group which is no longer used
+	Forget(requestArgs RequestArguments)
 }
 
 func validateLimitKeys(requestArgs RequestArguments) error {
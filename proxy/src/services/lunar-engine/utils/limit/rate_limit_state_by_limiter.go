@@ -54,6 +54,13 @@ func (state *RateLimitState) Counters() map[RequestArguments]int64 {
 	return counters
 }
 
+func (state *RateLimitState) Forget(requestArgs RequestArguments) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	delete(state.groupsStateByLimiter, requestArgs)
+}
+
 func (state *RateLimitState) getLimiterState(
 	requestArgs RequestArguments,
 ) *singleRateLimitState {
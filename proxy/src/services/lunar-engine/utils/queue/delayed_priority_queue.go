@@ -5,15 +5,92 @@ import (
 )
 
 type DelayedPriorityQueueable interface {
-	Enqueue(*Request, time.Duration, int64) (bool, error)
+	Enqueue(*Request, time.Duration, time.Duration, int64, OverflowPolicy) (bool, error)
 	Counts() map[float64]int64
+	WindowUsage() WindowUsage
+	WarmStart(prior WindowUsage)
+	Snapshot() Snapshot
+	Pending() []PendingRequest
+	UpdateQuota(quota int64)
+}
+
+// MaxTTLGraceFraction bounds the grace on the TTL of requests to a fraction
+// of the TTL itself
+const MaxTTLGraceFraction = 0.1
+
+// BoundTTLGrace bounds the grace on the given TTL by MaxTTLGraceFraction
+func BoundTTLGrace(ttl time.Duration, grace time.Duration) time.Duration {
+	maxGrace := time.Duration(float64(ttl) * MaxTTLGraceFraction)
+	if grace > maxGrace {
+		return maxGrace
+	}
+	if grace < 0 {
+		return 0
+	}
+	return grace
+}
+
+// OverflowPolicy decides which request is rejected once the queue is full
+type OverflowPolicy int
+
+const (
+	// OverflowRejectNew rejects the incoming request
+	OverflowRejectNew OverflowPolicy = iota
+	// OverflowRejectOldest evicts the oldest waiting request,
+	// so the incoming request is enqueued in its place
+	OverflowRejectOldest
+)
+
+// Snapshot describes the requests waiting in a queue
+type Snapshot struct {
+	Counts map[float64]int64
+	// OldestEnqueuedAt is when the oldest waiting request was enqueued,
+	// it is zero if no request is waiting
+	OldestEnqueuedAt time.Time
+}
+
+// PendingRequest describes a request waiting in a queue
+type PendingRequest struct {
+	ID         string
+	Priority   float64
+	EnqueuedAt time.Time
+	// ExpiresAt is when the TTL of the request passes, including its grace
+	ExpiresAt time.Time
+}
+
+// WindowUsage describes how much of the quota of the current window was used
+type WindowUsage struct {
+	Quota   int64
+	Used    int64
+	EndTime time.Time
 }
 
 type Strategy struct {
 	WindowQuota int64
 	WindowSize  time.Duration
 }
+
+// QueueKey identifies the queue of a remedy's strategy, or the queue of
+// a strategy shared by the remedies of a shared limit group, in which case
+// RemedyName is empty
 type QueueKey struct { //nolint: revive
-	RemedyName string
-	Strategy   Strategy
+	RemedyName       string
+	SharedLimitGroup string
+	Strategy         Strategy
+}
+
+// Name is the name of the shared limit group of the queue if it has one,
+// or of its remedy otherwise
+func (key QueueKey) Name() string {
+	if key.SharedLimitGroup != "" {
+		return key.SharedLimitGroup
+	}
+	return key.RemedyName
+}
+
+// SameOwner is whether both queues belong to the same remedy
+// or shared limit group, regardless of their strategy
+func (key QueueKey) SameOwner(other QueueKey) bool {
+	return key.RemedyName == other.RemedyName &&
+		key.SharedLimitGroup == other.SharedLimitGroup
 }
@@ -4,6 +4,7 @@ import (
 	"container/heap"
 	"lunar/toolkit-core/clock"
 	"lunar/toolkit-core/logging"
+	"sort"
 	"sync"
 	"time"
 )
@@ -12,9 +13,11 @@ var epochTime = time.Unix(0, 0)
 
 type DelayedPriorityQueue struct {
 	strategy             Strategy
+	pendingWindowQuota   *int64
 	currentWindowCounter int64
 	currentWindowEndTime time.Time
 	requestCounts        map[float64]int64
+	waitingRequests      map[*Request]struct{}
 	mutex                sync.RWMutex
 	queue                PriorityQueue
 	clock                clock.Clock
@@ -27,10 +30,11 @@ func NewInMemoryDelayedPriorityQueue(
 	contextLogger logging.ContextLogger,
 ) *DelayedPriorityQueue {
 	dpq := &DelayedPriorityQueue{ //nolint:exhaustruct
-		strategy:      queueKey.Strategy,
-		cl:            contextLogger.WithComponent("delayed-priority-queue"),
-		requestCounts: map[float64]int64{},
-		clock:         clock,
+		strategy:        queueKey.Strategy,
+		cl:              contextLogger.WithComponent("delayed-priority-queue"),
+		requestCounts:   map[float64]int64{},
+		waitingRequests: map[*Request]struct{}{},
+		clock:           clock,
 	}
 
 	heap.Init(&dpq.queue)
@@ -40,10 +44,18 @@ func NewInMemoryDelayedPriorityQueue(
 	return dpq
 }
 
+// Enqueue admits the request in the current window if its quota allows for it,
+// or waits for a later window to admit it in, within its TTL.
+// The grace (bounded by BoundTTLGrace) extends the TTL, so requests which
+// would be admitted right at the TTL, such as by a coarse clock, are admitted.
+// Requests allowed a TTL extension may have it extended once more as it
+// passes, if they are next in line to be admitted as the window ends.
 func (dpq *DelayedPriorityQueue) Enqueue(
 	req *Request,
 	ttl time.Duration,
+	ttlGrace time.Duration,
 	maxQueueSize int64,
+	overflowPolicy OverflowPolicy,
 ) (bool, error) {
 	dpq.mutex.Lock()
 
@@ -53,10 +65,12 @@ func (dpq *DelayedPriorityQueue) Enqueue(
 
 	dpq.ensureWindowIsUpdated()
 
-	// Requests are processed in current window, if quota allows for it
+	// Requests are processed in current window, if quota allows for it,
+	// which is the fast path taking none of the queue machinery
 	if dpq.currentWindowCounter < dpq.strategy.WindowQuota {
 		dpq.currentWindowCounter++
 		dpq.mutex.Unlock()
+		req.decide(OutcomeProcessedInWindow, dpq.clock)
 		close(req.doneCh)
 		dpq.cl.Logger.Trace().
 			Str("requestId", req.ID).
@@ -65,8 +79,10 @@ func (dpq *DelayedPriorityQueue) Enqueue(
 		return true, nil
 	}
 
-	if dpq.totalQueueCount() >= maxQueueSize {
+	if dpq.totalQueueCount() >= maxQueueSize &&
+		(overflowPolicy != OverflowRejectOldest || !dpq.evictOldest()) {
 		dpq.mutex.Unlock()
+		req.decide(OutcomeQueueFull, dpq.clock)
 		dpq.cl.Logger.Trace().Str("requestID", req.ID).
 			Msgf("Request dropped due to queue size limit")
 		return false, nil
@@ -75,37 +91,179 @@ func (dpq *DelayedPriorityQueue) Enqueue(
 
 	dpq.cl.Logger.Trace().Str("requestID", req.ID).
 		Msgf("Sending request to be processed in queue")
+	ttl += BoundTTLGrace(ttl, ttlGrace)
+	req.expiresAt = dpq.clock.Now().Add(ttl)
 	heap.Push(&dpq.queue, req)
 	dpq.requestCounts[req.priority]++
+	dpq.waitingRequests[req] = struct{}{}
 
 	dpq.mutex.Unlock()
 
 	// Wait until request is processed or TTL expires
-	select {
-	case <-req.doneCh:
-		dpq.cl.Logger.Trace().
-			Str("requestID", req.ID).
-			Msgf("Request processing completed")
-		dpq.mutex.Lock()
-		defer dpq.mutex.Unlock()
-		dpq.requestCounts[req.priority]--
-		return true, nil
-	case <-dpq.clock.After(ttl):
-		dpq.cl.Logger.Trace().Str("requestID", req.ID).
-			Msgf("Request TTLed (now: %+v, ttl: %+v)", dpq.clock.Now(), ttl)
-		dpq.mutex.Lock()
-		defer dpq.mutex.Unlock()
-		dpq.requestCounts[req.priority]--
-		return false, nil
+	ttlPassed := dpq.clock.After(ttl)
+	for {
+		select {
+		case <-req.doneCh:
+			dpq.cl.Logger.Trace().
+				Str("requestID", req.ID).
+				Msgf("Request processing completed")
+			req.decide(OutcomeProcessedFromQueue, dpq.clock)
+			dpq.mutex.Lock()
+			defer dpq.mutex.Unlock()
+			dpq.stopWaiting(req)
+			return true, nil
+		case <-req.evictedCh:
+			dpq.cl.Logger.Trace().Str("requestID", req.ID).
+				Msgf("Request evicted to admit a newer request")
+			req.decide(OutcomeEvicted, dpq.clock)
+			return false, nil
+		case <-ttlPassed:
+			if extension, extended := dpq.extendTTL(req); extended {
+				ttlPassed = dpq.clock.After(extension)
+				continue
+			}
+			dpq.cl.Logger.Trace().Str("requestID", req.ID).
+				Msgf("Request TTLed (now: %+v, ttl: %+v)", dpq.clock.Now(), ttl)
+			req.decide(OutcomeTTLExpired, dpq.clock)
+			dpq.mutex.Lock()
+			defer dpq.mutex.Unlock()
+			dpq.stopWaiting(req)
+			return false, nil
+		}
 	}
 }
 
+// extendTTL extends the TTL of a request which may have it extended once,
+// if capacity frees within the extension and the requests ahead of it do not
+// take all of it, so it would be admitted as the window ends.
+// A TTL passing right as the window ends is not extended, as the request
+// already expired as the next window starts.
+func (dpq *DelayedPriorityQueue) extendTTL(req *Request) (time.Duration, bool) {
+	dpq.mutex.Lock()
+	defer dpq.mutex.Unlock()
+	if req.ttlExtension <= 0 || req.ttlExtended {
+		return 0, false
+	}
+	if _, waiting := dpq.waitingRequests[req]; !waiting {
+		return 0, false
+	}
+	now := dpq.clock.Now()
+	if !now.Before(dpq.currentWindowEndTime) {
+		return 0, false
+	}
+	// the request must not have expired yet as the window ends
+	if !dpq.currentWindowEndTime.Before(now.Add(req.ttlExtension)) {
+		return 0, false
+	}
+	nextWindowQuota := dpq.strategy.WindowQuota
+	if dpq.pendingWindowQuota != nil {
+		nextWindowQuota = *dpq.pendingWindowQuota
+	}
+	ahead := int64(0)
+	for waiting := range dpq.waitingRequests {
+		if waiting != req && (PriorityQueue{waiting, req}).Less(0, 1) {
+			ahead++
+		}
+	}
+	if ahead >= nextWindowQuota {
+		return 0, false
+	}
+	req.ttlExtended = true
+	req.expiresAt = now.Add(req.ttlExtension)
+	dpq.cl.Logger.Trace().Str("requestID", req.ID).
+		Msgf("Extending TTL by %v, as the request is about to be admitted",
+			req.ttlExtension)
+	return req.ttlExtension, true
+}
+
 func (dpq *DelayedPriorityQueue) Counts() map[float64]int64 {
 	dpq.mutex.RLock()
 	defer dpq.mutex.RUnlock()
 	return deepCopyMap(dpq.requestCounts)
 }
 
+// Snapshot reads the requests waiting in the queue without modifying it.
+// Expired requests may still be in the priority queue until it is processed,
+// so the oldest request is looked up among the requests still waiting.
+func (dpq *DelayedPriorityQueue) Snapshot() Snapshot {
+	dpq.mutex.RLock()
+	defer dpq.mutex.RUnlock()
+	snapshot := Snapshot{Counts: deepCopyMap(dpq.requestCounts)} //nolint:exhaustruct
+	for req := range dpq.waitingRequests {
+		if snapshot.OldestEnqueuedAt.IsZero() ||
+			req.timestamp.Before(snapshot.OldestEnqueuedAt) {
+			snapshot.OldestEnqueuedAt = req.timestamp
+		}
+	}
+	return snapshot
+}
+
+// Pending lists the requests waiting in the queue, in the order they would
+// be processed in, without modifying the queue
+func (dpq *DelayedPriorityQueue) Pending() []PendingRequest {
+	dpq.mutex.RLock()
+	waiting := make(PriorityQueue, 0, len(dpq.waitingRequests))
+	for req := range dpq.waitingRequests {
+		waiting = append(waiting, req)
+	}
+	dpq.mutex.RUnlock()
+	sort.Slice(waiting, waiting.Less)
+
+	pending := make([]PendingRequest, 0, len(waiting))
+	for _, req := range waiting {
+		pending = append(pending, PendingRequest{
+			ID:         req.ID,
+			Priority:   req.priority,
+			EnqueuedAt: req.timestamp,
+			ExpiresAt:  req.expiresAt,
+		})
+	}
+	return pending
+}
+
+func (dpq *DelayedPriorityQueue) WindowUsage() WindowUsage {
+	dpq.mutex.Lock()
+	defer dpq.mutex.Unlock()
+	dpq.ensureWindowIsUpdated()
+	return WindowUsage{
+		Quota:   dpq.strategy.WindowQuota,
+		Used:    dpq.currentWindowCounter,
+		EndTime: dpq.currentWindowEndTime,
+	}
+}
+
+// WarmStart carries the budget remaining in the given window of a prior queue
+// over to the current window, if the prior window did not end yet,
+// so recreating a queue does not allow a burst of requests
+func (dpq *DelayedPriorityQueue) WarmStart(prior WindowUsage) {
+	dpq.mutex.Lock()
+	defer dpq.mutex.Unlock()
+	dpq.ensureWindowIsUpdated()
+	if !dpq.clock.Now().Before(prior.EndTime) {
+		return
+	}
+	remaining := prior.Quota - prior.Used
+	if remaining < 0 {
+		remaining = 0
+	}
+	if used := dpq.strategy.WindowQuota - remaining; used > dpq.currentWindowCounter {
+		dpq.currentWindowCounter = used
+	}
+}
+
+// UpdateQuota changes the window quota of the queue from the next window on,
+// so the requests already admitted in the current window stay accounted for,
+// and waiting requests keep draining by their TTL as usual
+func (dpq *DelayedPriorityQueue) UpdateQuota(quota int64) {
+	dpq.mutex.Lock()
+	defer dpq.mutex.Unlock()
+	dpq.ensureWindowIsUpdated()
+	dpq.cl.Logger.Info().
+		Msgf("Window quota changes from %d to %d, applying it from %v",
+			dpq.strategy.WindowQuota, quota, dpq.currentWindowEndTime)
+	dpq.pendingWindowQuota = &quota
+}
+
 func deepCopyMap(m map[float64]int64) map[float64]int64 {
 	result := map[float64]int64{}
 	for k, v := range m {
@@ -132,6 +290,10 @@ func (dpq *DelayedPriorityQueue) ensureWindowIsUpdated() {
 	if updatedWindowEndTime.After(dpq.currentWindowEndTime) {
 		dpq.currentWindowCounter = 0
 		dpq.currentWindowEndTime = updatedWindowEndTime
+		if dpq.pendingWindowQuota != nil {
+			dpq.strategy.WindowQuota = *dpq.pendingWindowQuota
+			dpq.pendingWindowQuota = nil
+		}
 	}
 }
 
@@ -144,6 +306,46 @@ func (dpq *DelayedPriorityQueue) totalQueueCount() int64 {
 	return totalCount
 }
 
+// stopWaiting removes a request from the waiting requests, unless it was
+// already removed by being evicted while its TTL expired.
+// Must be called while holding the mutex.
+func (dpq *DelayedPriorityQueue) stopWaiting(req *Request) {
+	if _, waiting := dpq.waitingRequests[req]; !waiting {
+		return
+	}
+	dpq.requestCounts[req.priority]--
+	delete(dpq.waitingRequests, req)
+}
+
+// evictOldest removes the oldest waiting request from the queue, so a newer
+// request can be enqueued in its place, and returns whether there was one.
+// Expired requests which are still in the priority queue are skipped.
+// Must be called while holding the mutex.
+func (dpq *DelayedPriorityQueue) evictOldest() bool {
+	oldestIndex := -1
+	for index, req := range dpq.queue {
+		if _, waiting := dpq.waitingRequests[req]; !waiting {
+			continue
+		}
+		if oldestIndex == -1 ||
+			req.timestamp.Before(dpq.queue[oldestIndex].timestamp) {
+			oldestIndex = index
+		}
+	}
+	if oldestIndex == -1 {
+		return false
+	}
+	req, valid := heap.Remove(&dpq.queue, oldestIndex).(*Request)
+	if !valid {
+		return false
+	}
+	dpq.stopWaiting(req)
+	close(req.evictedCh)
+	dpq.cl.Logger.Trace().Str("requestID", req.ID).
+		Msgf("Evicting oldest request as queue is full")
+	return true
+}
+
 func (dpq *DelayedPriorityQueue) process() {
 	for {
 		<-dpq.clock.After(dpq.GetTimeTillWindowEnd())
@@ -155,6 +357,7 @@ func (dpq *DelayedPriorityQueue) process() {
 }
 
 func (dpq *DelayedPriorityQueue) processQueueItems() {
+	now := dpq.clock.Now()
 	for dpq.queue.Len() > 0 &&
 		dpq.currentWindowCounter < dpq.strategy.WindowQuota {
 		req, valid := heap.Pop(&dpq.queue).(*Request)
@@ -164,6 +367,15 @@ func (dpq *DelayedPriorityQueue) processQueueItems() {
 					"will not process")
 			continue
 		}
+		// Requests are expired once their TTL passes, even if the TTL is
+		// right at the start of the window, so they take no quota.
+		// They no longer wait, so their TTL is not extended once popped.
+		if !now.Before(req.expiresAt) {
+			dpq.cl.Logger.Trace().Str("requestID", req.ID).
+				Msgf("Skipping queued request as its TTL passed")
+			dpq.stopWaiting(req)
+			continue
+		}
 		dpq.cl.Logger.Trace().
 			Str("requestID", req.ID).
 			Msgf("Attempt to process queued request")
@@ -43,9 +43,48 @@ type Request struct {
 	ID           string
 	priority     float64
 	timestamp    time.Time
+	expiresAt    time.Time
 	doneCh       chan struct{}
+	evictedCh    chan struct{}
 	processMutex sync.Mutex
 	isProcessed  bool
+	outcome      Outcome
+	decidedAt    time.Time
+	// the most the TTL may be extended by once, 0 unless it may
+	ttlExtension time.Duration
+	ttlExtended  bool
+}
+
+// Outcome describes how enqueueing a request was decided
+type Outcome int
+
+const (
+	OutcomeUndecided Outcome = iota
+	OutcomeProcessedInWindow
+	OutcomeProcessedFromQueue
+	OutcomeQueueFull
+	OutcomeTTLExpired
+	OutcomeEvicted
+)
+
+func (outcome Outcome) String() string {
+	var res string
+	switch outcome {
+	case OutcomeProcessedInWindow:
+		res = "processed_in_window"
+	case OutcomeProcessedFromQueue:
+		res = "processed_from_queue"
+	case OutcomeQueueFull:
+		res = "queue_full"
+	case OutcomeTTLExpired:
+		res = "ttl_expired"
+	case OutcomeEvicted:
+		res = "evicted"
+	case OutcomeUndecided:
+		res = "undecided"
+	}
+
+	return res
 }
 
 func NewRequest(id string, priority float64, clock clock.Clock) *Request {
@@ -54,7 +93,44 @@ func NewRequest(id string, priority float64, clock clock.Clock) *Request {
 		priority:     priority,
 		timestamp:    clock.Now(),
 		doneCh:       make(chan struct{}),
+		evictedCh:    make(chan struct{}),
 		processMutex: sync.Mutex{},
 		isProcessed:  false,
 	}
 }
+
+// EnqueuedAt returns the time the request was created to be enqueued at
+func (req *Request) EnqueuedAt() time.Time {
+	return req.timestamp
+}
+
+// AllowTTLExtension has the TTL of the request be extended once, by up to
+// the given extension, if it is about to be admitted as its TTL passes
+func (req *Request) AllowTTLExtension(maxExtension time.Duration) {
+	req.ttlExtension = maxExtension
+}
+
+// TTLExtended returns whether the TTL of the request was extended,
+// it should only be read once Enqueue returned
+func (req *Request) TTLExtended() bool {
+	return req.ttlExtended
+}
+
+// Outcome returns how enqueueing the request was decided,
+// it should only be read once Enqueue returned
+func (req *Request) Outcome() Outcome {
+	return req.outcome
+}
+
+// WaitTime returns how long the request waited until its outcome was decided
+func (req *Request) WaitTime() time.Duration {
+	if req.outcome == OutcomeUndecided {
+		return 0
+	}
+	return req.decidedAt.Sub(req.timestamp)
+}
+
+func (req *Request) decide(outcome Outcome, clock clock.Clock) {
+	req.outcome = outcome
+	req.decidedAt = clock.Now()
+}
@@ -192,7 +192,7 @@ func (th *queueTestHarness) enqueue(req *queue.Request) chan struct{} {
 		<-startCh // Wait for a signal to start
 		startTime := th.Clock.Now()
 		log.Debug().Msgf("Request %s goes to Enqueue", req.ID)
-		result, err := th.DPQ.Enqueue(req, th.TTL, th.QueueSize)
+		result, err := th.DPQ.Enqueue(req, th.TTL, 0, th.QueueSize, queue.OverflowRejectNew)
 		if err != nil {
 			log.Debug().Msgf("Error while processing request %s, runtime: %v, err: %s",
 				req.ID,
@@ -0,0 +1,91 @@
+package tenant
+
+import (
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	AttributeName = "tenant"
+	// DefaultTenant labels requests which carry no tenant header
+	DefaultTenant = "default"
+	// OverflowTenant labels all tenants first seen once the
+	// cardinality limit was reached
+	OverflowTenant = "other"
+	// SharedTenant labels state which is shared by several tenants
+	// (e.g. a quota group which is not split by tenant)
+	SharedTenant          = "shared"
+	DefaultMaxCardinality = 100
+)
+
+// Resolver resolves the tenant of requests out of a configurable header,
+// so metrics can be broken down per tenant. The number of distinct tenants
+// it resolves to is bounded, in order to keep the metrics cardinality in check.
+type Resolver struct {
+	headerName     string
+	maxCardinality int
+	mutex          sync.RWMutex
+	knownTenants   map[string]struct{}
+}
+
+func NewResolver(headerName string, maxCardinality int) *Resolver {
+	if maxCardinality <= 0 {
+		maxCardinality = DefaultMaxCardinality
+	}
+	return &Resolver{
+		headerName:     headerName,
+		maxCardinality: maxCardinality,
+		mutex:          sync.RWMutex{},
+		knownTenants:   map[string]struct{}{},
+	}
+}
+
+// Resolve returns the tenant of a request with the given headers.
+// A nil Resolver resolves all requests to the default tenant.
+func (resolver *Resolver) Resolve(headers map[string]string) string {
+	if resolver == nil || resolver.headerName == "" {
+		return DefaultTenant
+	}
+	tenantID := strings.TrimSpace(resolver.headerValue(headers))
+	if tenantID == "" {
+		return DefaultTenant
+	}
+
+	resolver.mutex.RLock()
+	_, known := resolver.knownTenants[tenantID]
+	resolver.mutex.RUnlock()
+	if known {
+		return tenantID
+	}
+
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+	if _, known := resolver.knownTenants[tenantID]; known {
+		return tenantID
+	}
+	if len(resolver.knownTenants) >= resolver.maxCardinality {
+		return OverflowTenant
+	}
+	resolver.knownTenants[tenantID] = struct{}{}
+	return tenantID
+}
+
+// Attribute returns the tenant metric attribute of a request
+// with the given headers
+func (resolver *Resolver) Attribute(headers map[string]string) attribute.KeyValue {
+	return attribute.String(AttributeName, resolver.Resolve(headers))
+}
+
+func (resolver *Resolver) headerValue(headers map[string]string) string {
+	if value, found := headers[resolver.headerName]; found {
+		return value
+	}
+	for name, value := range headers {
+		if strings.EqualFold(name, resolver.headerName) {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package tenant_test
+
+import (
+	"lunar/engine/utils/tenant"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const tenantHeader = "X-Tenant-ID"
+
+func TestResolveReturnsTenantFromHeader(t *testing.T) {
+	t.Parallel()
+	resolver := tenant.NewResolver(tenantHeader, 10)
+
+	assert.Equal(t, "acme", resolver.Resolve(map[string]string{tenantHeader: "acme"}))
+	assert.Equal(t, "globex",
+		resolver.Resolve(map[string]string{"x-tenant-id": " globex "}))
+}
+
+func TestResolveReturnsDefaultTenantWithoutHeader(t *testing.T) {
+	t.Parallel()
+	resolver := tenant.NewResolver(tenantHeader, 10)
+
+	assert.Equal(t, tenant.DefaultTenant, resolver.Resolve(map[string]string{}))
+	assert.Equal(t, tenant.DefaultTenant,
+		resolver.Resolve(map[string]string{tenantHeader: ""}))
+}
+
+func TestResolveReturnsDefaultTenantIfNotConfigured(t *testing.T) {
+	t.Parallel()
+	var nilResolver *tenant.Resolver
+	headers := map[string]string{tenantHeader: "acme"}
+
+	assert.Equal(t, tenant.DefaultTenant, tenant.NewResolver("", 10).Resolve(headers))
+	assert.Equal(t, tenant.DefaultTenant, nilResolver.Resolve(headers))
+}
+
+func TestResolveIsBoundedByMaxCardinality(t *testing.T) {
+	t.Parallel()
+	resolver := tenant.NewResolver(tenantHeader, 2)
+
+	assert.Equal(t, "acme", resolver.Resolve(map[string]string{tenantHeader: "acme"}))
+	assert.Equal(t, "globex", resolver.Resolve(map[string]string{tenantHeader: "globex"}))
+	assert.Equal(t, tenant.OverflowTenant,
+		resolver.Resolve(map[string]string{tenantHeader: "initech"}))
+	// tenants seen before the limit was reached keep their label
+	assert.Equal(t, "acme", resolver.Resolve(map[string]string{tenantHeader: "acme"}))
+}
+
+func TestAttributeCarriesResolvedTenant(t *testing.T) {
+	t.Parallel()
+	resolver := tenant.NewResolver(tenantHeader, 10)
+
+	assert.Equal(t,
+		attribute.String(tenant.AttributeName, "acme"),
+		resolver.Attribute(map[string]string{tenantHeader: "acme"}))
+}
@@ -0,0 +1,83 @@
+package upstreamhost
+
+import (
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	AttributeName = "host"
+	// OverflowHost labels all hosts first seen once the
+	// cardinality limit was reached
+	OverflowHost          = "other"
+	DefaultMaxCardinality = 100
+)
+
+// defaultPorts are stripped off hosts, as the host is the same without them
+var defaultPorts = []string{":80", ":443"}
+
+// Labels labels metrics by the upstream host they are of, so they can be
+// broken down per upstream. The number of distinct hosts labeled is bounded,
+// in order to keep the metrics cardinality in check.
+// A nil Labels labels nothing, as the label is toggled off.
+type Labels struct {
+	maxCardinality int
+	mutex          sync.RWMutex
+	knownHosts     map[string]struct{}
+}
+
+func NewLabels(maxCardinality int) *Labels {
+	if maxCardinality <= 0 {
+		maxCardinality = DefaultMaxCardinality
+	}
+	return &Labels{
+		maxCardinality: maxCardinality,
+		mutex:          sync.RWMutex{},
+		knownHosts:     map[string]struct{}{},
+	}
+}
+
+// Normalize returns the host of the URL (with no scheme, as the engine
+// keeps URLs), lowercased and without its port if it is a default one
+func Normalize(url string) string {
+	host, _, _ := strings.Cut(url, "/")
+	host = strings.ToLower(host)
+	for _, port := range defaultPorts {
+		if trimmed, found := strings.CutSuffix(host, port); found {
+			return trimmed
+		}
+	}
+	return host
+}
+
+// Attributes returns the host metric attribute of the URL,
+// none if the label is toggled off
+func (labels *Labels) Attributes(url string) []attribute.KeyValue {
+	if labels == nil {
+		return nil
+	}
+	return []attribute.KeyValue{attribute.String(AttributeName, labels.label(url))}
+}
+
+func (labels *Labels) label(url string) string {
+	host := Normalize(url)
+	labels.mutex.RLock()
+	_, known := labels.knownHosts[host]
+	labels.mutex.RUnlock()
+	if known {
+		return host
+	}
+
+	labels.mutex.Lock()
+	defer labels.mutex.Unlock()
+	if _, known := labels.knownHosts[host]; known {
+		return host
+	}
+	if len(labels.knownHosts) >= labels.maxCardinality {
+		return OverflowHost
+	}
+	labels.knownHosts[host] = struct{}{}
+	return host
+}
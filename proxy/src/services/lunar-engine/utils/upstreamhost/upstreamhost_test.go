@@ -0,0 +1,35 @@
+package upstreamhost_test
+
+import (
+	"lunar/engine/utils/upstreamhost"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestNormalizeLowercasesAndStripsDefaultPorts(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "api.com", upstreamhost.Normalize("API.com/users/{id}"))
+	assert.Equal(t, "api.com", upstreamhost.Normalize("api.com:443/users"))
+	assert.Equal(t, "api.com", upstreamhost.Normalize("api.com:80"))
+	assert.Equal(t, "api.com:8080", upstreamhost.Normalize("api.com:8080/users"))
+}
+
+func TestAttributesAreBoundedByTheMaxCardinality(t *testing.T) {
+	t.Parallel()
+	labels := upstreamhost.NewLabels(1)
+
+	assert.Equal(t, []attribute.KeyValue{attribute.String("host", "api.com")},
+		labels.Attributes("api.com/users"))
+	assert.Equal(t, []attribute.KeyValue{attribute.String("host", upstreamhost.OverflowHost)},
+		labels.Attributes("other.com/users"))
+	assert.Equal(t, []attribute.KeyValue{attribute.String("host", "api.com")},
+		labels.Attributes("API.com:443/orders"))
+}
+
+func TestNilLabelsAddNoAttributes(t *testing.T) {
+	t.Parallel()
+	var labels *upstreamhost.Labels
+	assert.Empty(t, labels.Attributes("api.com/users"))
+}
@@ -0,0 +1,321 @@
+package writers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	deadLetterQueueSize         = 1024
+	deadLetterFilePermissions   = 0o600
+	deadLetterRecordsMetricName = "lunar_exporters.dead_letter.records"
+)
+
+// Results of dead lettering a record, reported on the records metric
+const (
+	deadLetterWritten = "written"
+	deadLetterDropped = "dropped"
+	deadLetterFailed  = "failed"
+)
+
+// DeadLetterer is implemented by writers which dead letter
+// the records they could not deliver
+type DeadLetterer interface {
+	DeadLetter(source string, record []byte)
+}
+
+// DeadLetter dead letters a record which could not be delivered through the
+// writer, if the writer dead letters records, otherwise the record is lost
+func DeadLetter(writer Writer, source string, record []byte) {
+	if deadLetterer, ok := writer.(DeadLetterer); ok {
+		deadLetterer.DeadLetter(source, record)
+		return
+	}
+	log.Warn().Msgf("Failed to deliver record to %s, it is dropped", source)
+}
+
+// DeadLetterSinkConfig configures the file records which could not be
+// delivered are appended to. It is rotated once it reaches MaxFileSize bytes,
+// keeping up to MaxFiles rotated files (named by the path suffixed with
+// their index, the most recent being 1).
+type DeadLetterSinkConfig struct {
+	Path        string
+	MaxFileSize int64
+	MaxFiles    int
+}
+
+// DeadLetterSink appends records which could not be delivered to a file,
+// one per line, prefixed by the time they were dead lettered and their source,
+// so they can be reprocessed later.
+// Records are written in the background, so dead lettering never blocks
+// the caller, and records are dropped if the sink is backed up.
+type DeadLetterSink struct {
+	config     DeadLetterSinkConfig
+	queue      chan deadLetterRecord
+	done       chan struct{}
+	closeMutex sync.RWMutex
+	closed     bool
+	file       *os.File
+	size       int64
+	// The meter is only set up once the sink is first used,
+	// as the sink is set up along with the writers, before metrics are
+	meter              func() metric.Meter
+	recordsCounterOnce sync.Once
+	recordsCounter     metric.Int64Counter
+}
+
+type deadLetterRecord struct {
+	source  string
+	content []byte
+	// set on requests to reopen the file rather than records,
+	// which are answered once the file was reopened
+	reopened chan error
+}
+
+func NewDeadLetterSink(
+	config DeadLetterSinkConfig,
+	meter func() metric.Meter,
+) (*DeadLetterSink, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("dead letter file path is not set")
+	}
+	if config.MaxFileSize <= 0 || config.MaxFiles < 0 {
+		return nil, fmt.Errorf("dead letter file sizes must be positive")
+	}
+	sink := &DeadLetterSink{
+		config:         config,
+		queue:          make(chan deadLetterRecord, deadLetterQueueSize),
+		done:           make(chan struct{}),
+		closeMutex:     sync.RWMutex{},
+		closed:         false,
+		file:           nil,
+		size:           0,
+		meter:          meter,
+		recordsCounter: nil,
+	}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	go sink.run()
+	return sink, nil
+}
+
+func (sink *DeadLetterSink) DeadLetter(source string, record []byte) {
+	// The record is copied, as it is written after the caller returns
+	content := append([]byte(nil), record...)
+	sink.closeMutex.RLock()
+	defer sink.closeMutex.RUnlock()
+	if sink.closed {
+		log.Error().Msgf("Dead letter sink is closed, dropping record of %s", source)
+		sink.count(source, deadLetterDropped)
+		return
+	}
+	select {
+	case sink.queue <- deadLetterRecord{source: source, content: content, reopened: nil}:
+	default:
+		log.Error().Msgf("Dead letter sink is backed up, dropping record of %s",
+			source)
+		sink.count(source, deadLetterDropped)
+	}
+}
+
+// Reopen closes the file and opens it again once the records dead lettered
+// so far were written, so a file moved by an external rotation is written
+// no more. Records dead lettered meanwhile are written to the new file.
+func (sink *DeadLetterSink) Reopen() error {
+	reopened := make(chan error, 1)
+	sink.closeMutex.RLock()
+	if sink.closed {
+		sink.closeMutex.RUnlock()
+		return nil
+	}
+	sink.queue <- deadLetterRecord{source: "", content: nil, reopened: reopened}
+	sink.closeMutex.RUnlock()
+	return <-reopened
+}
+
+// Close writes the queued records and closes the file,
+// records dead lettered afterwards are dropped
+func (sink *DeadLetterSink) Close() error {
+	sink.closeMutex.Lock()
+	if !sink.closed {
+		sink.closed = true
+		close(sink.queue)
+	}
+	sink.closeMutex.Unlock()
+	<-sink.done
+	if sink.file == nil {
+		return nil
+	}
+	err := sink.file.Close()
+	sink.file = nil
+	return err
+}
+
+func (sink *DeadLetterSink) run() {
+	defer close(sink.done)
+	for record := range sink.queue {
+		if record.reopened != nil {
+			record.reopened <- sink.reopen()
+			continue
+		}
+		if err := sink.write(record); err != nil {
+			log.Error().Err(err).Msgf("Failed to dead letter record of %s",
+				record.source)
+			sink.count(record.source, deadLetterFailed)
+			continue
+		}
+		log.Debug().Msgf("Dead lettered record of %s", record.source)
+		sink.count(record.source, deadLetterWritten)
+	}
+}
+
+func (sink *DeadLetterSink) write(record deadLetterRecord) error {
+	line := make([]byte, 0, len(record.content)+len(record.source)+64)
+	line = append(line, time.Now().Format(time.RFC3339Nano)...)
+	line = append(line, ' ')
+	line = append(line, record.source...)
+	line = append(line, ' ')
+	line = append(line, record.content...)
+	line = append(line, '\n')
+
+	lineSize := int64(len(line))
+	if lineSize > sink.config.MaxFileSize {
+		return fmt.Errorf("record of %d bytes exceeds the max file size", lineSize)
+	}
+	// A file which failed to open after rotating is opened again
+	if sink.file == nil {
+		if err := sink.open(); err != nil {
+			return err
+		}
+	}
+	if sink.size+lineSize > sink.config.MaxFileSize {
+		if err := sink.rotate(); err != nil {
+			return err
+		}
+	}
+	written, err := sink.file.Write(line)
+	sink.size += int64(written)
+	return err
+}
+
+func (sink *DeadLetterSink) open() error {
+	file, err := os.OpenFile(sink.config.Path,
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY, deadLetterFilePermissions)
+	if err != nil {
+		return fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to open dead letter file: %w", err),
+			file.Close())
+	}
+	sink.file = file
+	sink.size = info.Size()
+	return nil
+}
+
+func (sink *DeadLetterSink) reopen() error {
+	if sink.file != nil {
+		if err := sink.file.Close(); err != nil {
+			log.Warn().Err(err).Msg("Failed to close dead letter file")
+		}
+		sink.file = nil
+	}
+	if err := sink.open(); err != nil {
+		return err
+	}
+	log.Info().Msgf("Dead letter file %s was reopened", sink.config.Path)
+	return nil
+}
+
+// rotate shifts the rotated files, dropping the oldest beyond MaxFiles,
+// and starts a new file
+func (sink *DeadLetterSink) rotate() error {
+	if err := sink.file.Close(); err != nil {
+		log.Warn().Err(err).Msg("Failed to close dead letter file")
+	}
+	sink.file = nil
+	path := sink.config.Path
+	if sink.config.MaxFiles == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate dead letter file: %w", err)
+		}
+	} else {
+		for index := sink.config.MaxFiles - 1; index >= 1; index-- {
+			err := os.Rename(rotatedPath(path, index), rotatedPath(path, index+1))
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to rotate dead letter file: %w", err)
+			}
+		}
+		if err := os.Rename(path, rotatedPath(path, 1)); err != nil {
+			return fmt.Errorf("failed to rotate dead letter file: %w", err)
+		}
+	}
+	log.Warn().Msgf("Dead letter file %s was rotated", path)
+	return sink.open()
+}
+
+func rotatedPath(path string, index int) string {
+	return path + "." + strconv.Itoa(index)
+}
+
+func (sink *DeadLetterSink) count(source string, result string) {
+	sink.recordsCounterOnce.Do(func() {
+		recordsCounter, err := sink.meter().Int64Counter(
+			deadLetterRecordsMetricName,
+			metric.WithDescription("Records which could not be delivered, "+
+				"by whether they were written to the dead letter file"),
+			metric.WithUnit("{record}"),
+		)
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to create dead letter metric")
+		}
+		sink.recordsCounter = recordsCounter
+	})
+	if sink.recordsCounter == nil {
+		return
+	}
+	sink.recordsCounter.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("source", source),
+		attribute.String("result", result),
+	))
+}
+
+// deadLetteringWriter dead letters the records which could not be delivered
+// through the writer it wraps to its sink
+type deadLetteringWriter struct {
+	Writer
+	sink *DeadLetterSink
+}
+
+// WithDeadLetterSink wraps the writer, so records which could not be
+// delivered through it are dead lettered to the given sink
+func WithDeadLetterSink(writer Writer, sink *DeadLetterSink) Writer {
+	return &deadLetteringWriter{Writer: writer, sink: sink}
+}
+
+func (writer *deadLetteringWriter) WriteRouted(route Route, b []byte) (int, error) {
+	return WriteRouted(writer.Writer, route, b)
+}
+
+func (writer *deadLetteringWriter) Flush(ctx context.Context) error {
+	return Flush(ctx, writer.Writer)
+}
+
+func (writer *deadLetteringWriter) Reopen() error {
+	return errors.Join(Reopen(writer.Writer), writer.sink.Reopen())
+}
+
+func (writer *deadLetteringWriter) DeadLetter(source string, record []byte) {
+	writer.sink.DeadLetter(source, record)
+}
@@ -0,0 +1,177 @@
+package writers_test
+
+import (
+	"context"
+	"errors"
+	"lunar/engine/utils/writers"
+	"lunar/toolkit-core/clock"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/metric"
+	sdkMetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+var errUndeliverable = errors.New("undeliverable")
+
+func newTestDeadLetterSink(
+	t *testing.T,
+	maxFileSize int64,
+	maxFiles int,
+) (*writers.DeadLetterSink, string, *sdkMetric.ManualReader) {
+	path := filepath.Join(t.TempDir(), "dead-letter.log")
+	reader := sdkMetric.NewManualReader()
+	meter := sdkMetric.NewMeterProvider(sdkMetric.WithReader(reader)).Meter("test")
+	sink, err := writers.NewDeadLetterSink(writers.DeadLetterSinkConfig{
+		Path:        path,
+		MaxFileSize: maxFileSize,
+		MaxFiles:    maxFiles,
+	}, func() metric.Meter { return meter })
+	require.NoError(t, err)
+	return sink, path, reader
+}
+
+func readLines(t *testing.T, path string) []string {
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+func collectDeadLetterRecords(
+	t *testing.T,
+	reader *sdkMetric.ManualReader,
+) map[string]int64 {
+	var resourceMetrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &resourceMetrics))
+
+	perResult := map[string]int64{}
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, recordedMetric := range scopeMetrics.Metrics {
+			if recordedMetric.Name != "lunar_exporters.dead_letter.records" {
+				continue
+			}
+			sum, _ := recordedMetric.Data.(metricdata.Sum[int64])
+			for _, dataPoint := range sum.DataPoints {
+				result, _ := dataPoint.Attributes.Value("result")
+				perResult[result.AsString()] += dataPoint.Value
+			}
+		}
+	}
+	return perResult
+}
+
+// failingWriter fails all writes
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errUndeliverable }
+
+func (failingWriter) Close() error { return nil }
+
+func TestDeadLetterSinkAppendsRecordsWithTheirSource(t *testing.T) {
+	t.Parallel()
+	sink, path, reader := newTestDeadLetterSink(t, 1024, 1)
+
+	sink.DeadLetter("s3", []byte(`{"id": 1}`))
+	sink.DeadLetter("syslog:audit", []byte(`{"id": 2}`))
+	require.NoError(t, sink.Close())
+
+	lines := readLines(t, path)
+	require.Len(t, lines, 2)
+	require.True(t, strings.HasSuffix(lines[0], ` s3 {"id": 1}`), lines[0])
+	require.True(t, strings.HasSuffix(lines[1], ` syslog:audit {"id": 2}`), lines[1])
+	require.Equal(t, map[string]int64{"written": 2}, collectDeadLetterRecords(t, reader))
+}
+
+func TestDeadLetterSinkRotatesAndBoundsFiles(t *testing.T) {
+	t.Parallel()
+	record := strings.Repeat("x", 40)
+	// Each line holds a timestamp, so a file fits a single record
+	sink, path, reader := newTestDeadLetterSink(t, 100, 2)
+
+	for i := 0; i < 4; i++ {
+		sink.DeadLetter("file", []byte(record))
+	}
+	sink.DeadLetter("file", []byte(strings.Repeat("x", 200)))
+	require.NoError(t, sink.Close())
+
+	for _, rotated := range []string{path, path + ".1", path + ".2"} {
+		require.Len(t, readLines(t, rotated), 1, rotated)
+	}
+	_, err := os.Stat(path + ".3")
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, map[string]int64{"written": 4, "failed": 1},
+		collectDeadLetterRecords(t, reader))
+}
+
+func TestWithDeadLetterSinkDeadLettersThroughWrappedWriter(t *testing.T) {
+	t.Parallel()
+	sink, path, _ := newTestDeadLetterSink(t, 1024, 1)
+	writer := writers.WithDeadLetterSink(failingWriter{}, sink)
+
+	_, err := writer.Write([]byte("record"))
+	require.ErrorIs(t, err, errUndeliverable)
+	writers.DeadLetter(writer, "file", []byte("record"))
+	require.NoError(t, sink.Close())
+
+	require.Len(t, readLines(t, path), 1)
+}
+
+func TestRoutingWriterDeadLettersRecordsOfFailingTargets(t *testing.T) {
+	t.Parallel()
+	unavailable := newSyslogListener(t)
+	unavailableAddress := unavailable.address()
+	require.NoError(t, unavailable.listener.Close())
+	sink, path, _ := newTestDeadLetterSink(t, 1024, 1)
+	writer, err := writers.NewRoutingWriter(clock.NewMockClock(), []writers.TargetConfig{
+		{Name: "unavailable", Address: unavailableAddress},
+	}, sink)
+	require.NoError(t, err)
+
+	_, err = writer.Write([]byte("record"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		content, err := os.ReadFile(path)
+		return err == nil && strings.Contains(string(content), " syslog:unavailable record")
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestDeadLetterSinkWritesToTheNewFileOnceReopenedAfterARotation(t *testing.T) {
+	t.Parallel()
+	sink, path, reader := newTestDeadLetterSink(t, 1<<20, 1)
+	writer := writers.WithDeadLetterSink(failingWriter{}, sink)
+
+	for i := 0; i < 100; i++ {
+		writers.DeadLetter(writer, "file", []byte("before"))
+	}
+	// An external rotation moves the file away, as logrotate does
+	rotated := path + ".rotated"
+	require.NoError(t, os.Rename(path, rotated))
+	writers.DeadLetter(writer, "file", []byte("before"))
+	require.NoError(t, writers.FlushAndReopen(context.Background(), writer))
+	writers.DeadLetter(writer, "file", []byte("after"))
+	require.NoError(t, sink.Close())
+
+	// Records dead lettered before the reopen completed to the moved file
+	before := readLines(t, rotated)
+	require.Len(t, before, 101)
+	for _, line := range before {
+		require.True(t, strings.HasSuffix(line, " file before"), line)
+	}
+	after := readLines(t, path)
+	require.Len(t, after, 1)
+	require.True(t, strings.HasSuffix(after[0], " file after"), after[0])
+	require.Equal(t, map[string]int64{"written": 102}, collectDeadLetterRecords(t, reader))
+}
+
+func TestDeadLetterSinkReopenedOnceClosedIsANoOp(t *testing.T) {
+	t.Parallel()
+	sink, _, _ := newTestDeadLetterSink(t, 1024, 1)
+	require.NoError(t, sink.Close())
+	require.NoError(t, sink.Reopen())
+}
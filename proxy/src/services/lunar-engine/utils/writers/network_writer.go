@@ -9,6 +9,8 @@ import (
 type NetworkWriter struct {
 	network string
 	address string
+	// prefixTimestamp prefixes each message with the time it is written at
+	prefixTimestamp bool
 
 	mutex      sync.RWMutex
 	connection serverConnection
@@ -27,7 +29,10 @@ func (writer *NetworkWriter) connect() (err error) {
 		return fmt.Errorf("Failed to setup %s connection to %s:%s, error: %s",
 			writer.network, writer.network, writer.address, err)
 	}
-	writer.connection = &netConn{connection: connection}
+	writer.connection = &netConn{
+		connection:      connection,
+		prefixTimestamp: writer.prefixTimestamp,
+	}
 
 	return err
 }
@@ -0,0 +1,41 @@
+package writers
+
+import (
+	"context"
+	"errors"
+)
+
+// Flusher is implemented by writers which write records in the background,
+// flushing waits for the records written so far to be delivered
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// Reopener is implemented by writers which write to files, reopening them
+// so files rotated externally (e.g. by logrotate) are not written once moved
+type Reopener interface {
+	Reopen() error
+}
+
+// Flush waits for the records written so far to be delivered, if the writer
+// writes them in the background, or until the context is done
+func Flush(ctx context.Context, writer Writer) error {
+	if flusher, ok := writer.(Flusher); ok {
+		return flusher.Flush(ctx)
+	}
+	return nil
+}
+
+// Reopen reopens the files the writer writes to, if any
+func Reopen(writer Writer) error {
+	if reopener, ok := writer.(Reopener); ok {
+		return reopener.Reopen()
+	}
+	return nil
+}
+
+// FlushAndReopen flushes the writer and then reopens its files,
+// so the records written before are delivered to the files as they were
+func FlushAndReopen(ctx context.Context, writer Writer) error {
+	return errors.Join(Flush(ctx, writer), Reopen(writer))
+}
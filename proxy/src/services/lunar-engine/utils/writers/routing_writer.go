@@ -0,0 +1,365 @@
+package writers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lunar/toolkit-core/clock"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Severity is the syslog severity of a written record,
+// lower values are more severe
+type Severity int
+
+const (
+	SeverityError   Severity = 3
+	SeverityWarning Severity = 4
+	SeverityInfo    Severity = 6
+)
+
+// DataType is the kind of data a written record holds
+type DataType string
+
+const (
+	DataTypeTransactions           DataType = "transactions"
+	DataTypeRejectedRequests       DataType = "rejected_requests"
+	DataTypeRejectedRequestSamples DataType = "rejected_request_samples"
+	DataTypeUsageSnapshots         DataType = "usage_snapshots"
+	DataTypeQueueSnapshots         DataType = "queue_snapshots"
+)
+
+type Format string
+
+const (
+	// FormatLunar is the format the bundled Fluent Bit parses:
+	// a timestamp followed by the record
+	FormatLunar Format = "lunar"
+	// FormatRFC5424 is a syslog message carrying the facility and severity
+	FormatRFC5424 Format = "rfc5424"
+)
+
+const (
+	defaultTargetNetwork  = "tcp"
+	defaultTargetFacility = "user"
+	targetQueueSize       = 1024
+	targetWriteAttempts   = 3
+	rfc5424AppName        = "lunar-engine"
+	rfc5424NilValue       = "-"
+	syslogSeverityCount   = 8
+)
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var severities = map[string]Severity{
+	"error":   SeverityError,
+	"warning": SeverityWarning,
+	"info":    SeverityInfo,
+}
+
+// Route describes a written record, so it can be routed to the right targets
+type Route struct {
+	DataType DataType
+	Severity Severity
+}
+
+// RoutedWriter is a Writer which routes the records written to it
+type RoutedWriter interface {
+	Writer
+	WriteRouted(route Route, b []byte) (int, error)
+}
+
+// WriteRouted writes the record with its route if the writer routes records,
+// otherwise the record is written as is
+func WriteRouted(writer Writer, route Route, b []byte) (int, error) {
+	if routedWriter, ok := writer.(RoutedWriter); ok {
+		return routedWriter.WriteRouted(route, b)
+	}
+	return writer.Write(b)
+}
+
+// TargetConfig configures a syslog target, and the records routed to it.
+// A target without data types receives records of all data types, and a target
+// without a minimum severity receives records of all severities.
+type TargetConfig struct {
+	Name        string     `json:"name"`
+	Network     string     `json:"network"`
+	Address     string     `json:"address"`
+	Facility    string     `json:"facility"`
+	Format      Format     `json:"format"`
+	DataTypes   []DataType `json:"data_types"`
+	MinSeverity string     `json:"min_severity"`
+}
+
+// ParseTargetConfigs parses a JSON list of syslog targets
+func ParseTargetConfigs(raw string) ([]TargetConfig, error) {
+	var configs []TargetConfig
+	if err := json.Unmarshal([]byte(raw), &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse syslog targets: %w", err)
+	}
+	return configs, nil
+}
+
+// RoutingWriter writes each record to the targets its route matches.
+// Each target is written to in the background through its own queue,
+// so a failing or slow target does not block the others.
+// Records a target could not be written are dead lettered, if a sink is set.
+type RoutingWriter struct {
+	targets        []*syslogTarget
+	deadLetterSink *DeadLetterSink
+}
+
+type syslogTarget struct {
+	name        string
+	writer      Writer
+	format      Format
+	facility    int
+	hostname    string
+	clock       clock.Clock
+	dataTypes   map[DataType]struct{}
+	minSeverity Severity
+	queue       chan targetRecord
+}
+
+type targetRecord struct {
+	route   Route
+	content []byte
+	// set on flushes rather than records,
+	// closed once the records queued before were written
+	flushed chan struct{}
+}
+
+func NewRoutingWriter(
+	clock clock.Clock,
+	configs []TargetConfig,
+	deadLetterSink *DeadLetterSink,
+) (*RoutingWriter, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no syslog targets are configured")
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = rfc5424NilValue
+	}
+	names := map[string]struct{}{}
+	routingWriter := &RoutingWriter{deadLetterSink: deadLetterSink} //nolint:exhaustruct
+	for _, config := range configs {
+		if _, found := names[config.Name]; found {
+			return nil, fmt.Errorf("syslog target %s is defined more than once",
+				config.Name)
+		}
+		names[config.Name] = struct{}{}
+		target, err := newSyslogTarget(config, hostname, clock)
+		if err != nil {
+			return nil, err
+		}
+		routingWriter.targets = append(routingWriter.targets, target)
+	}
+	for _, target := range routingWriter.targets {
+		go target.run(routingWriter)
+	}
+	return routingWriter, nil
+}
+
+func newSyslogTarget(
+	config TargetConfig,
+	hostname string,
+	clock clock.Clock,
+) (*syslogTarget, error) {
+	if config.Name == "" {
+		return nil, fmt.Errorf("syslog target name is not set")
+	}
+	if config.Address == "" {
+		return nil, fmt.Errorf("address of syslog target %s is not set", config.Name)
+	}
+	network := config.Network
+	if network == "" {
+		network = defaultTargetNetwork
+	}
+	format := config.Format
+	if format == "" {
+		format = FormatLunar
+	}
+	if format != FormatLunar && format != FormatRFC5424 {
+		return nil, fmt.Errorf("format %s of syslog target %s is not supported",
+			format, config.Name)
+	}
+	facilityName := config.Facility
+	if facilityName == "" {
+		facilityName = defaultTargetFacility
+	}
+	facility, found := facilities[facilityName]
+	if !found {
+		return nil, fmt.Errorf("facility %s of syslog target %s is not supported",
+			facilityName, config.Name)
+	}
+	minSeverity := Severity(syslogSeverityCount - 1)
+	if config.MinSeverity != "" {
+		if minSeverity, found = severities[config.MinSeverity]; !found {
+			return nil, fmt.Errorf("severity %s of syslog target %s is not supported",
+				config.MinSeverity, config.Name)
+		}
+	}
+	dataTypes := map[DataType]struct{}{}
+	for _, dataType := range config.DataTypes {
+		dataTypes[dataType] = struct{}{}
+	}
+
+	return &syslogTarget{
+		name: config.Name,
+		// The connection is set up on the first write, and again after failures,
+		// so an unavailable target does not disable exporting to it
+		writer: &NetworkWriter{ //nolint:exhaustruct
+			network:         network,
+			address:         config.Address,
+			prefixTimestamp: format == FormatLunar,
+		},
+		format:      format,
+		facility:    facility,
+		hostname:    hostname,
+		clock:       clock,
+		dataTypes:   dataTypes,
+		minSeverity: minSeverity,
+		queue:       make(chan targetRecord, targetQueueSize),
+	}, nil
+}
+
+// Write routes the record as an informational record without a data type
+func (writer *RoutingWriter) Write(b []byte) (int, error) {
+	return writer.WriteRouted(Route{Severity: SeverityInfo}, b)
+}
+
+func (writer *RoutingWriter) WriteRouted(route Route, b []byte) (int, error) {
+	// The record is copied, as it is written after the caller returns
+	content := append([]byte(nil), b...)
+	routed := false
+	for _, target := range writer.targets {
+		if !target.matches(route) {
+			continue
+		}
+		routed = true
+		select {
+		case target.queue <- targetRecord{route: route, content: content, flushed: nil}:
+		default:
+			log.Warn().Msgf("Syslog target %s is backed up, dropping record",
+				target.name)
+			writer.DeadLetter(target.source(), content)
+		}
+	}
+	if !routed {
+		log.Trace().Msgf("No syslog target matches %+v, dropping record", route)
+	}
+	return len(b), nil
+}
+
+// Close closes the connections to the targets, they reconnect on the next write
+func (writer *RoutingWriter) Close() error {
+	var errs []error
+	for _, target := range writer.targets {
+		if err := target.writer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close syslog target %s: %w",
+				target.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Flush waits for the records queued to the targets so far to be written,
+// or dead lettered if they could not be, or until the context is done
+func (writer *RoutingWriter) Flush(ctx context.Context) error {
+	flushes := make([]chan struct{}, 0, len(writer.targets))
+	for _, target := range writer.targets {
+		flushed := make(chan struct{})
+		select {
+		case target.queue <- targetRecord{route: Route{}, content: nil, flushed: flushed}:
+			flushes = append(flushes, flushed)
+		case <-ctx.Done():
+			return fmt.Errorf("failed to flush syslog target %s: %w",
+				target.name, ctx.Err())
+		}
+	}
+	for index, flushed := range flushes {
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+			return fmt.Errorf("failed to flush syslog target %s: %w",
+				writer.targets[index].name, ctx.Err())
+		}
+	}
+	return nil
+}
+
+// Reopen reopens the dead letter file, if set
+func (writer *RoutingWriter) Reopen() error {
+	if writer.deadLetterSink == nil {
+		return nil
+	}
+	return writer.deadLetterSink.Reopen()
+}
+
+func (target *syslogTarget) matches(route Route) bool {
+	if route.Severity > target.minSeverity {
+		return false
+	}
+	if len(target.dataTypes) == 0 {
+		return true
+	}
+	_, found := target.dataTypes[route.DataType]
+	return found
+}
+
+// DeadLetter dead letters the record to the sink, if set
+func (writer *RoutingWriter) DeadLetter(source string, record []byte) {
+	if writer.deadLetterSink == nil {
+		log.Warn().Msgf("Failed to deliver record to %s, it is dropped", source)
+		return
+	}
+	writer.deadLetterSink.DeadLetter(source, record)
+}
+
+func (target *syslogTarget) source() string {
+	return "syslog:" + target.name
+}
+
+func (target *syslogTarget) run(writer *RoutingWriter) {
+	for record := range target.queue {
+		if record.flushed != nil {
+			close(record.flushed)
+			continue
+		}
+		message := target.formatRecord(record)
+		var err error
+		for attempt := 0; attempt < targetWriteAttempts; attempt++ {
+			if _, err = target.writer.Write(message); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Debug().Err(err).Msgf("Failed to write to syslog target %s",
+				target.name)
+			writer.DeadLetter(target.source(), record.content)
+		}
+	}
+}
+
+func (target *syslogTarget) formatRecord(record targetRecord) []byte {
+	if target.format == FormatLunar {
+		return record.content
+	}
+	priority := target.facility*syslogSeverityCount + int(record.route.Severity)
+	header := "<" + strconv.Itoa(priority) + ">1 " +
+		target.clock.Now().Format(time.RFC3339Nano) + " " +
+		target.hostname + " " + rfc5424AppName + " " +
+		rfc5424NilValue + " " + rfc5424NilValue + " " + rfc5424NilValue + " "
+	return append([]byte(header), record.content...)
+}
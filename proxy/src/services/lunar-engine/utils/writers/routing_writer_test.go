@@ -0,0 +1,211 @@
+package writers_test
+
+import (
+	"bufio"
+	"context"
+	"lunar/engine/utils/writers"
+	"lunar/toolkit-core/clock"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// syslogListener collects the lines written to it over TCP
+type syslogListener struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	lines    []string
+}
+
+func newSyslogListener(t *testing.T) *syslogListener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	syslogListener := &syslogListener{listener: listener}
+	go syslogListener.accept()
+	return syslogListener
+}
+
+func (listener *syslogListener) accept() {
+	for {
+		connection, err := listener.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			scanner := bufio.NewScanner(connection)
+			for scanner.Scan() {
+				listener.mutex.Lock()
+				listener.lines = append(listener.lines, scanner.Text())
+				listener.mutex.Unlock()
+			}
+		}()
+	}
+}
+
+func (listener *syslogListener) address() string {
+	return listener.listener.Addr().String()
+}
+
+func (listener *syslogListener) received() []string {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+	return append([]string(nil), listener.lines...)
+}
+
+func requireReceived(t *testing.T, listener *syslogListener, count int) []string {
+	require.Eventually(t, func() bool {
+		return len(listener.received()) >= count
+	}, time.Second, 10*time.Millisecond)
+	return listener.received()
+}
+
+func transactionRoute(severity writers.Severity) writers.Route {
+	return writers.Route{DataType: writers.DataTypeTransactions, Severity: severity}
+}
+
+func TestRoutingWriterRoutesByDataTypeAndSeverity(t *testing.T) {
+	t.Parallel()
+	transactions := newSyslogListener(t)
+	errors := newSyslogListener(t)
+	writer, err := writers.NewRoutingWriter(clock.NewMockClock(), []writers.TargetConfig{
+		{
+			Name:      "transactions",
+			Address:   transactions.address(),
+			DataTypes: []writers.DataType{writers.DataTypeTransactions},
+		},
+		{Name: "errors", Address: errors.address(), MinSeverity: "error"},
+	}, nil)
+	require.NoError(t, err)
+
+	for _, record := range []struct {
+		route   writers.Route
+		content string
+	}{
+		{transactionRoute(writers.SeverityInfo), "ok"},
+		{transactionRoute(writers.SeverityError), "failed"},
+		{writers.Route{
+			DataType: writers.DataTypeRejectedRequests,
+			Severity: writers.SeverityWarning,
+		}, "rejected"},
+	} {
+		_, err := writer.WriteRouted(record.route, []byte(record.content))
+		require.NoError(t, err)
+	}
+
+	transactionLines := requireReceived(t, transactions, 2)
+	require.Len(t, transactionLines, 2)
+	require.True(t, strings.HasSuffix(transactionLines[0], " ok"))
+	require.True(t, strings.HasSuffix(transactionLines[1], " failed"))
+	errorLines := requireReceived(t, errors, 1)
+	require.Len(t, errorLines, 1)
+	require.True(t, strings.HasSuffix(errorLines[0], " failed"))
+}
+
+func TestRoutingWriterFormatsRFC5424WithFacilityAndSeverity(t *testing.T) {
+	t.Parallel()
+	listener := newSyslogListener(t)
+	clock := clock.NewMockClock()
+	writer, err := writers.NewRoutingWriter(clock, []writers.TargetConfig{{
+		Name:     "audit",
+		Address:  listener.address(),
+		Facility: "local0",
+		Format:   writers.FormatRFC5424,
+	}}, nil)
+	require.NoError(t, err)
+
+	_, err = writer.WriteRouted(writers.Route{
+		DataType: writers.DataTypeRejectedRequests,
+		Severity: writers.SeverityWarning,
+	}, []byte("file rejected"))
+	require.NoError(t, err)
+
+	line := requireReceived(t, listener, 1)[0]
+	// local0 (16) * 8 + warning (4)
+	require.True(t, strings.HasPrefix(line, "<132>1 "+
+		clock.Now().Format(time.RFC3339Nano)+" "), line)
+	require.True(t, strings.HasSuffix(line, " lunar-engine - - - file rejected"), line)
+}
+
+func TestRoutingWriterFailingTargetDoesNotBlockOthers(t *testing.T) {
+	t.Parallel()
+	// The address is released, so writes to it fail
+	unavailable := newSyslogListener(t)
+	unavailableAddress := unavailable.address()
+	require.NoError(t, unavailable.listener.Close())
+	available := newSyslogListener(t)
+	writer, err := writers.NewRoutingWriter(clock.NewMockClock(), []writers.TargetConfig{
+		{Name: "unavailable", Address: unavailableAddress},
+		{Name: "available", Address: available.address()},
+	}, nil)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err := writer.Write([]byte("record"))
+		require.NoError(t, err)
+	}
+
+	require.Len(t, requireReceived(t, available, 3), 3)
+}
+
+func TestRoutingWriterRejectsInvalidTargets(t *testing.T) {
+	t.Parallel()
+	for name, configs := range map[string][]writers.TargetConfig{
+		"no targets":       {},
+		"missing address":  {{Name: "a"}},
+		"duplicate name":   {{Name: "a", Address: "x:1"}, {Name: "a", Address: "y:1"}},
+		"unknown facility": {{Name: "a", Address: "x:1", Facility: "local9"}},
+		"unknown format":   {{Name: "a", Address: "x:1", Format: "xml"}},
+		"unknown severity": {{Name: "a", Address: "x:1", MinSeverity: "fatal"}},
+		"missing the name": {{Address: "x:1"}},
+	} {
+		_, err := writers.NewRoutingWriter(clock.NewMockClock(), configs, nil)
+		require.Error(t, err, name)
+	}
+}
+
+func TestParseTargetConfigs(t *testing.T) {
+	t.Parallel()
+	configs, err := writers.ParseTargetConfigs(`[{
+		"name": "errors",
+		"address": "logs.internal:514",
+		"facility": "local3",
+		"format": "rfc5424",
+		"data_types": ["transactions"],
+		"min_severity": "error"
+	}]`)
+
+	require.NoError(t, err)
+	require.Equal(t, []writers.TargetConfig{{
+		Name:        "errors",
+		Address:     "logs.internal:514",
+		Facility:    "local3",
+		Format:      writers.FormatRFC5424,
+		DataTypes:   []writers.DataType{writers.DataTypeTransactions},
+		MinSeverity: "error",
+	}}, configs)
+}
+
+func TestRoutingWriterFlushWaitsForTheQueuedRecords(t *testing.T) {
+	t.Parallel()
+	listener := newSyslogListener(t)
+	writer, err := writers.NewRoutingWriter(clock.NewMockClock(), []writers.TargetConfig{
+		{Name: "audit", Address: listener.address()},
+	}, nil)
+	require.NoError(t, err)
+
+	for i := 0; i < 50; i++ {
+		_, err = writer.Write([]byte("record"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writers.FlushAndReopen(context.Background(), writer))
+	requireReceived(t, listener, 50)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, writer.Flush(cancelled), context.Canceled)
+}
@@ -1,6 +1,7 @@
 package writers
 
 import (
+	"io"
 	"lunar/toolkit-core/client"
 	"lunar/toolkit-core/clock"
 	"net"
@@ -14,13 +15,27 @@ type Writer interface {
 	Close() error
 }
 
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// NopCloser writes to the given writer, leaving closing it to its owner
+func NopCloser(writer io.Writer) Writer {
+	return nopCloser{Writer: writer}
+}
+
 type serverConnection interface {
 	writeBytes(message []byte) error
 	close() error
 }
 
 type netConn struct {
-	connection net.Conn
+	connection      net.Conn
+	prefixTimestamp bool
 }
 
 // Dial function is not thread safe.
@@ -30,8 +45,9 @@ func Dial(
 	clock clock.Clock,
 ) Writer {
 	writer := &NetworkWriter{ //nolint:exhaustruct
-		network: network,
-		address: address,
+		network:         network,
+		address:         address,
+		prefixTimestamp: true,
 	}
 
 	retryConfig := client.RetryConfig{
@@ -77,7 +93,9 @@ func addTimestampPrefix(message []byte) []byte {
 }
 
 func (networkConnection *netConn) writeBytes(message []byte) error {
-	message = addTimestampPrefix(message)
+	if networkConnection.prefixTimestamp {
+		message = addTimestampPrefix(message)
+	}
 	message = ensureEndsWithNewline(message)
 	_, err := networkConnection.connection.Write(message)
 	return err